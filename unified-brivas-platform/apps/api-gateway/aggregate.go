@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// aggregateFunctions are computed over numeric columns, besides the count
+var aggregateFunctions = []string{"sum", "avg", "min", "max"}
+
+// aggregateColumn is one function over one column of an aggregate query
+type aggregateColumn struct {
+	function string
+	column   string
+}
+
+// numericColumns lists the columns of table that can be aggregated
+func numericColumns(table TableSchema) []string {
+	var cols []string
+	for _, col := range table.Columns {
+		if t := mapSQLTypeToGraphQL(col.Type); t == graphql.Int || t == graphql.Float {
+			cols = append(cols, col.Name)
+		}
+	}
+	return cols
+}
+
+// aggregateQuery renders a query selecting the count of table's rows
+// matching where, a WHERE clause or empty, followed by each aggregate
+func aggregateQuery(table TableSchema, where string, aggregates []aggregateColumn) string {
+	selects := []string{"count(*)"}
+	for _, a := range aggregates {
+		selects = append(selects, fmt.Sprintf("%s(%s)", a.function, table.ColumnRef(a.column)))
+	}
+	return "SELECT " + strings.Join(selects, ", ") + " FROM " + table.QualifiedName() + where
+}
+
+// aggregate runs a count or aggregate tool: the count of the live rows
+// matching the input's filter that the caller may select, and for each
+// function in the input its value over the listed numeric columns, null
+// without rows
+func (h *MCPHandler) aggregate(ctx context.Context, table TableSchema, input map[string]interface{}) (map[string]interface{}, error) {
+	numeric := make(map[string]bool)
+	for _, col := range numericColumns(table) {
+		numeric[col] = true
+	}
+
+	var aggregates []aggregateColumn
+	for _, fn := range aggregateFunctions {
+		v, ok := input[fn]
+		if !ok {
+			continue
+		}
+		cols, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be an array of columns", errInvalidToolInput, fn)
+		}
+		for _, c := range cols {
+			col, _ := c.(string)
+			if !numeric[col] {
+				return nil, fmt.Errorf("%w: %v is not a numeric column of %s", errInvalidToolInput, c, table.Name)
+			}
+			aggregates = append(aggregates, aggregateColumn{function: fn, column: col})
+		}
+	}
+
+	where, args, err := h.where(ctx, table, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	values := make([]sql.NullFloat64, len(aggregates))
+	dest := []interface{}{&count}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := sessionQueryRow(ctx, h.db, aggregateQuery(table, where, aggregates), args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"count": count}
+	for i, a := range aggregates {
+		byColumn, ok := result[a.function].(map[string]interface{})
+		if !ok {
+			byColumn = make(map[string]interface{})
+			result[a.function] = byColumn
+		}
+		if values[i].Valid {
+			byColumn[a.column] = values[i].Float64
+		} else {
+			byColumn[a.column] = nil
+		}
+	}
+	return result, nil
+}
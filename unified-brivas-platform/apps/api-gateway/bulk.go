@@ -0,0 +1,345 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	auth "github.com/brivas/unified-platform/packages/core"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// DefaultBulkChunkSize is the number of rows written per transaction
+const DefaultBulkChunkSize = 500
+
+// Bulk row outcomes
+const (
+	bulkInserted = "inserted"
+	bulkUpdated  = "updated"
+	bulkNotFound = "not_found"
+	bulkFailed   = "error"
+)
+
+// bulkResult is the outcome of one row of a bulk update
+type bulkResult struct {
+	Index  int                    `json:"index"`
+	Status string                 `json:"status"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// bulkStatement is the SQL for one validated row
+type bulkStatement struct {
+	index int
+	pk    interface{}
+	query string
+	args  []interface{}
+}
+
+// bulkPlanner validates rows and renders them as upserts, or as plain
+// updates when the caller may update but not insert
+type bulkPlanner struct {
+	table   TableSchema
+	columns map[string]bool
+	upsert  bool
+	update  *auth.UpdatePermission
+	insert  *auth.InsertPermission
+	claims  *auth.Claims
+	// returning lists the columns each written row reports, all by default
+	returning []string
+}
+
+func newBulkPlanner(ctx context.Context, table TableSchema, perms PermissionSource, claims *auth.Claims) (*bulkPlanner, error) {
+	p := &bulkPlanner{
+		table:   table,
+		columns: make(map[string]bool, len(table.Columns)),
+		upsert:  true,
+		claims:  claims,
+	}
+	for _, col := range table.Columns {
+		p.columns[col.Name] = true
+		p.returning = append(p.returning, col.Name)
+	}
+
+	if perms == nil {
+		return p, nil
+	}
+	perm, err := perms.Authorize(ctx, table.Name, auth.PermissionUpdate, claims)
+	if err != nil {
+		return nil, fmt.Errorf("update not allowed on %s", table.Name)
+	}
+	p.update = perm.Update
+	if p.update != nil && sessionTx(ctx) != nil {
+		// The session's policies filter the rows instead
+		update := *p.update
+		update.Filter = nil
+		p.update = &update
+	}
+
+	// Upserting inserts too, which the role or an API key's scopes may
+	// not allow
+	insert, err := insertPermission(ctx, perms, table.Name, claims)
+	p.insert = insert
+	p.upsert = err == nil && insert != nil
+	return p, nil
+}
+
+// plan validates a row against the schema and permissions
+func (p *bulkPlanner) plan(index int, row map[string]interface{}) (*bulkStatement, error) {
+	pk := p.table.PrimaryKey
+	id, ok := row[pk]
+	if !ok || id == nil {
+		return nil, fmt.Errorf("missing primary key %q", pk)
+	}
+
+	var set []string
+	for col := range row {
+		if !p.columns[col] {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+		if col == pk {
+			continue
+		}
+		if p.update != nil && len(p.update.Columns) > 0 && !contains(p.update.Columns, col) {
+			return nil, fmt.Errorf("column %q may not be updated", col)
+		}
+		set = append(set, col)
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("no columns to update")
+	}
+	sort.Strings(set)
+
+	stmt := &bulkStatement{index: index, pk: id}
+	if p.upsert {
+		values, err := insertValues(p.insert, row, p.claims)
+		if err != nil {
+			return nil, err
+		}
+		p.renderUpsert(stmt, values, set)
+	} else {
+		p.renderUpdate(stmt, row, set)
+	}
+	return stmt, nil
+}
+
+// renderUpsert inserts values, the row as the insert permission allows
+// it, or, when the key exists and the row passes the update filter,
+// overwrites the given columns. (xmax = 0) is true only for freshly
+// inserted rows.
+func (p *bulkPlanner) renderUpsert(stmt *bulkStatement, values map[string]interface{}, set []string) {
+	cols := make([]string, 0, len(values))
+	for col := range values {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	placeholders := make([]string, len(cols))
+	for i, col := range cols {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		stmt.args = append(stmt.args, values[col])
+		cols[i] = quoteIdentifier(col)
+	}
+
+	assignments := make([]string, len(set))
+	for i, col := range set {
+		assignments[i] = fmt.Sprintf("%s = EXCLUDED.%s", quoteIdentifier(col), quoteIdentifier(col))
+	}
+
+	stmt.query = fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s%s%s, (xmax = 0) AS _inserted",
+		p.table.QualifiedName(),
+		strings.Join(cols, ", "),
+		strings.Join(placeholders, ", "),
+		quoteIdentifier(p.table.PrimaryKey),
+		strings.Join(assignments, ", "),
+		p.filterClause(stmt, " WHERE "),
+		returningClause(p.returning),
+	)
+}
+
+func (p *bulkPlanner) renderUpdate(stmt *bulkStatement, row map[string]interface{}, set []string) {
+	assignments := make([]string, len(set))
+	for i, col := range set {
+		assignments[i] = fmt.Sprintf("%s = $%d", quoteIdentifier(col), i+1)
+		stmt.args = append(stmt.args, row[col])
+	}
+	stmt.args = append(stmt.args, stmt.pk)
+
+	stmt.query = fmt.Sprintf(
+		"UPDATE %s SET %s WHERE %s = $%d%s%s",
+		p.table.QualifiedName(),
+		strings.Join(assignments, ", "),
+		p.table.ColumnRef(p.table.PrimaryKey),
+		len(stmt.args),
+		p.filterClause(stmt, " AND "),
+		returningClause(p.returning),
+	)
+}
+
+// filterClause renders the role's update filter against the existing row
+func (p *bulkPlanner) filterClause(stmt *bulkStatement, prefix string) string {
+	if p.update == nil {
+		return ""
+	}
+	var clause string
+	clause, stmt.args = rlsCondition(p.table.Name, p.update.Filter, p.claims, stmt.args)
+	if clause == "" {
+		return ""
+	}
+	return prefix + clause
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// handleBulkUpsert updates or inserts an array of rows keyed by primary key.
+// Rows are written in chunks, one transaction per chunk; a failing row is
+// rolled back to its savepoint and reported without aborting the chunk.
+func (h *RESTHandler) handleBulkUpsert(table TableSchema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var items []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			h.jsonError(w, r, "invalid JSON array", http.StatusBadRequest)
+			return
+		}
+
+		planner, err := newBulkPlanner(ctx, table, h.permissions, requestClaims(ctx))
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+		returning, ok := h.restReturning(w, r, table)
+		if !ok {
+			return
+		}
+		planner.returning = returning
+
+		results := make([]bulkResult, len(items))
+		statements := make([]*bulkStatement, 0, len(items))
+		for i, item := range items {
+			stmt, err := planner.plan(i, item)
+			if err != nil {
+				results[i] = bulkResult{Index: i, Status: bulkFailed, Error: err.Error()}
+				continue
+			}
+			statements = append(statements, stmt)
+		}
+
+		chunkSize := h.bulkChunkSize
+		if chunkSize <= 0 {
+			chunkSize = DefaultBulkChunkSize
+		}
+		for start := 0; start < len(statements); start += chunkSize {
+			end := start + chunkSize
+			if end > len(statements) {
+				end = len(statements)
+			}
+			h.writeBulkChunk(ctx, table.Name, statements[start:end], results)
+		}
+
+		counts := map[string]int{}
+		for _, res := range results {
+			counts[res.Status]++
+		}
+		h.jsonResponse(w, map[string]interface{}{
+			"inserted":  counts[bulkInserted],
+			"updated":   counts[bulkUpdated],
+			"not_found": counts[bulkNotFound],
+			"failed":    counts[bulkFailed],
+			"results":   results,
+		}, http.StatusOK)
+	}
+}
+
+func (h *RESTHandler) writeBulkChunk(ctx context.Context, tableName string, chunk []*bulkStatement, results []bulkResult) {
+	err := withTransaction(ctx, h.db, func(tx *sql.Tx) error {
+		for _, stmt := range chunk {
+			results[stmt.index] = execBulkStatement(ctx, tx, stmt)
+			if results[stmt.index].Status == bulkFailed && ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		h.logger.Warn("bulk upsert chunk failed", zap.String("table", tableName), zap.Error(err))
+		for _, stmt := range chunk {
+			if results[stmt.index].Status != bulkFailed {
+				results[stmt.index] = bulkResult{Index: stmt.index, Status: bulkFailed, Error: "transaction rolled back"}
+			}
+		}
+		return
+	}
+
+	// Announce only once the chunk has committed
+	for _, stmt := range chunk {
+		switch results[stmt.index].Status {
+		case bulkInserted:
+			h.changes.Notify(ctx, tableName, ChangeInsert, stmt.pk)
+		case bulkUpdated:
+			h.changes.Notify(ctx, tableName, ChangeUpdate, stmt.pk)
+		}
+	}
+}
+
+func execBulkStatement(ctx context.Context, tx *sql.Tx, stmt *bulkStatement) bulkResult {
+	result := bulkResult{Index: stmt.index}
+	fail := func(err error) bulkResult {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT bulk_row")
+		result.Status = bulkFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_row"); err != nil {
+		result.Status = bulkFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	rows, err := tx.QueryContext(ctx, stmt.query, stmt.args...)
+	if err != nil {
+		return fail(err)
+	}
+	data, err := scanRowsToMaps(lumadb.WrapRows(rows))
+	rows.Close()
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT bulk_row"); err != nil {
+		return fail(err)
+	}
+
+	// No row back means the key is missing or the update filter excluded it;
+	// both are reported as not found so existence does not leak
+	if len(data) == 0 {
+		result.Status = bulkNotFound
+		return result
+	}
+
+	result.Data = data[0]
+	result.Status = bulkUpdated
+	if inserted, ok := result.Data["_inserted"].(bool); ok {
+		if inserted {
+			result.Status = bulkInserted
+		}
+		delete(result.Data, "_inserted")
+	}
+	return result
+}
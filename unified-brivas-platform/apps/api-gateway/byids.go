@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	auth "github.com/brivas/unified-platform/packages/core"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// maxBatchIDs bounds the ids of one get-many-by-ids request
+const maxBatchIDs = 100
+
+// batchIDs checks the ids of a get-many request against the table's
+// primary key, converting each to its type
+func batchIDs(table TableSchema, ids []interface{}) ([]interface{}, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: ids are required", ErrInvalidID)
+	}
+	if len(ids) > maxBatchIDs {
+		return nil, fmt.Errorf("at most %d ids may be requested at once", maxBatchIDs)
+	}
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		key, err := primaryKeyValue(table, id)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// rowsByIDs reads the rows of table with the given primary keys in one
+// query, in the order of ids, with a nil row for each id not found or
+// hidden by filter. Only columns are read, or every column when nil; the
+// primary key is read regardless to match rows to ids, and dropped again
+// unless among columns.
+func rowsByIDs(ctx context.Context, db *lumadb.Client, table TableSchema, ids []interface{}, columns []string, filter map[string]string, includeDeleted bool) ([]map[string]interface{}, error) {
+	selected := "*"
+	if columns != nil {
+		read := columns
+		if !contains(read, table.PrimaryKey) {
+			read = append(append([]string(nil), read...), table.PrimaryKey)
+		}
+		quoted := make([]string, len(read))
+		for i, col := range read {
+			quoted[i] = quoteIdentifier(col)
+		}
+		selected = strings.Join(quoted, ", ")
+	}
+
+	// Each distinct id is bound once
+	keyOf := primaryKeyString(table)
+	var args []interface{}
+	placeholders := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if key := keyOf(id); !seen[key] {
+			seen[key] = true
+			args = append(args, id)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+	}
+
+	conditions := []string{fmt.Sprintf("%s IN (%s)", table.ColumnRef(table.PrimaryKey), strings.Join(placeholders, ", "))}
+	if cond := liveRowsCondition(table); cond != "" && !includeDeleted {
+		conditions = append(conditions, cond)
+	}
+	condition, args := rlsCondition(table.Name, filter, requestClaims(ctx), args)
+	if condition != "" {
+		conditions = append(conditions, condition)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", selected, table.QualifiedName(), strings.Join(conditions, " AND "))
+
+	rows, err := sessionQuery(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found, err := scanRowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]map[string]interface{}, len(found))
+	for _, row := range found {
+		byKey[keyOf(row[table.PrimaryKey])] = row
+		if columns != nil && !contains(columns, table.PrimaryKey) {
+			delete(row, table.PrimaryKey)
+		}
+	}
+	result := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		result[i] = byKey[keyOf(id)]
+	}
+	return result, nil
+}
+
+// primaryKeyString returns how ids and scanned primary keys of table are
+// compared: as text, ignoring case for UUIDs
+func primaryKeyString(table TableSchema) func(interface{}) string {
+	if col := findColumn(table, table.PrimaryKey); col != nil && strings.EqualFold(col.Type, "uuid") {
+		return func(v interface{}) string { return strings.ToLower(fmt.Sprint(v)) }
+	}
+	return func(v interface{}) string { return fmt.Sprint(v) }
+}
+
+// handleListByIDs serves GET /{table}?ids=1,2,3: the rows with those ids in
+// their order, with null for each missing one, or ?missing=omit to leave
+// them out. Rows are limited by the caller's row-level filter and to the
+// columns their role may read.
+func (h *RESTHandler) handleListByIDs(w http.ResponseWriter, r *http.Request, table TableSchema) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	omitMissing := false
+	switch query.Get("missing") {
+	case "", "null":
+	case "omit":
+		omitMissing = true
+	default:
+		h.jsonError(w, r, "missing must be null or omit", http.StatusBadRequest)
+		return
+	}
+	var raw []interface{}
+	for _, id := range strings.Split(query.Get("ids"), ",") {
+		raw = append(raw, strings.TrimSpace(id))
+	}
+	ids, err := batchIDs(table, raw)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	includeDeleted, ok := h.includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	filter, err := rowFilter(ctx, h.permissions, table.Name, requestClaims(ctx), auth.PermissionSelect)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusForbidden)
+		return
+	}
+	columns, err := returningColumns(ctx, table, nil, h.authz)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(columns) == 0 {
+		h.jsonError(w, r, "no readable columns", http.StatusForbidden)
+		return
+	}
+
+	rows, err := rowsByIDs(ctx, h.db, table, ids, columns, filter, includeDeleted)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if row != nil {
+			data = append(data, row)
+		} else if !omitMissing {
+			data = append(data, nil)
+		}
+	}
+	h.jsonResponse(w, map[string]interface{}{"data": data}, http.StatusOK)
+}
+
+// resolveByIDs resolves <table>_by_pks(ids: [...]): the rows with those ids
+// in their order, with null for each missing one unless omitMissing is set.
+// Column reads are authorized by the field resolvers.
+func (h *GraphQLHandler) resolveByIDs(table TableSchema) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		raw, _ := p.Args["ids"].([]interface{})
+		ids, err := batchIDs(table, raw)
+		if err != nil {
+			return nil, err
+		}
+		includeDeleted, err := includeDeletedArg(p)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := rowFilter(p.Context, h.permissions, table.Name, requestClaims(p.Context), auth.PermissionSelect)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := rowsByIDs(p.Context, h.db, table, ids, nil, filter, includeDeleted)
+		if err != nil {
+			return nil, err
+		}
+		omitMissing, _ := p.Args["omitMissing"].(bool)
+		result := make([]interface{}, 0, len(rows))
+		for _, row := range rows {
+			if row != nil {
+				result = append(result, row)
+			} else if !omitMissing {
+				result = append(result, nil)
+			}
+		}
+		return result, nil
+	}
+}
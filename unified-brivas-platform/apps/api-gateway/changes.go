@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// ChangeChannelPrefix prefixes the LumaDB notification channel of each table
+const ChangeChannelPrefix = "brivas_changes_"
+
+// Change operations carried by ChangeEvent
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ChangeEvent announces a committed write. WebSocket clients receive it on
+// the channel named after the table.
+type ChangeEvent struct {
+	Table      string      `json:"table"`
+	Operation  string      `json:"operation"`
+	PrimaryKey interface{} `json:"primary_key"`
+}
+
+// ChangeNotifier emits change events for the tables that opted in
+type ChangeNotifier struct {
+	tables   map[string]bool
+	notify   func(ctx context.Context, channel, payload string) error
+	notifyTx func(ctx context.Context, tx *sql.Tx, channel, payload string) error
+	logger   *zap.Logger
+}
+
+// NewChangeNotifier creates a notifier publishing through LumaDB NOTIFY
+func NewChangeNotifier(db *lumadb.Client, tables []string, logger *zap.Logger) *ChangeNotifier {
+	n := &ChangeNotifier{
+		tables:   make(map[string]bool, len(tables)),
+		notify:   db.Notify,
+		notifyTx: db.NotifyTx,
+		logger:   logger,
+	}
+	for _, table := range tables {
+		n.tables[table] = true
+	}
+	return n
+}
+
+// Notify announces a write to table. Callers invoke it only after the write
+// has committed; the write stands even if the notification cannot be sent.
+func (n *ChangeNotifier) Notify(ctx context.Context, table, operation string, pk interface{}) {
+	if n == nil || !n.tables[table] {
+		return
+	}
+
+	payload, err := json.Marshal(ChangeEvent{Table: table, Operation: operation, PrimaryKey: pk})
+	if err != nil {
+		n.logger.Warn("failed to encode change event", zap.String("table", table), zap.Error(err))
+		return
+	}
+	// In a database session the event is queued until the session commits
+	if tx := sessionTx(ctx); tx != nil && n.notifyTx != nil {
+		err = n.notifyTx(ctx, tx, changeChannel(table), string(payload))
+	} else {
+		err = n.notify(ctx, changeChannel(table), string(payload))
+	}
+	if err != nil {
+		n.logger.Warn("failed to publish change event",
+			zap.String("table", table), zap.String("operation", operation), zap.Error(err))
+	}
+}
+
+// publishes reports whether writes to table are announced
+func (n *ChangeNotifier) publishes(table string) bool {
+	return n != nil && n.tables[table]
+}
+
+// Channels returns the LumaDB channels the notifier publishes on
+func (n *ChangeNotifier) Channels() []string {
+	channels := make([]string, 0, len(n.tables))
+	for table := range n.tables {
+		channels = append(channels, changeChannel(table))
+	}
+	return channels
+}
+
+func changeChannel(table string) string {
+	return ChangeChannelPrefix + table
+}
+
+// ListenForChanges relays change events from LumaDB to WebSocket
+// subscribers of each table, including GraphQL subscriptions, until ctx is
+// cancelled
+func (h *WebSocketHandler) ListenForChanges(ctx context.Context, channels []string) error {
+	return h.db.Listen(ctx, channels, h.relayChange)
+}
+
+func (h *WebSocketHandler) relayChange(channel, payload string) {
+	var event ChangeEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		h.logger.Warn("invalid change event", zap.String("channel", channel), zap.Error(err))
+		return
+	}
+	if err := h.Broadcast(event.Table, event); err != nil {
+		h.logger.Warn("failed to broadcast change event", zap.String("table", event.Table), zap.Error(err))
+	}
+	if h.graphql != nil {
+		h.graphql.Publish(event)
+	}
+}
+
+// insertedKey prefers the key returned by the database, which covers
+// generated keys, over the one supplied in the request
+func insertedKey(returned, supplied map[string]interface{}, pk string) interface{} {
+	if v, ok := returned[pk]; ok && v != nil {
+		return v
+	}
+	return supplied[pk]
+}
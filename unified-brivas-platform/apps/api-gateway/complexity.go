@@ -0,0 +1,261 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// defaultListSize is the assumed row count of a list field without a limit
+const defaultListSize = 10
+
+// QueryLimits bounds the work a single GraphQL operation may request.
+// Zero disables the corresponding check.
+type QueryLimits struct {
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// queryCost is the result of analysing one operation
+type queryCost struct {
+	depth      int
+	complexity int
+}
+
+// queryAnalyzer computes depth and complexity of an operation before it is
+// executed. Every field costs 1; the cost of the selections under a list
+// field is multiplied by its limit argument, or defaultListSize without one.
+// Costs saturate at math.MaxInt rather than overflow, so nested lists with
+// huge limits cannot wrap around to a small or negative complexity.
+type queryAnalyzer struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// checkQueryLimits rejects operations in doc that exceed limits and
+// returns the complexity of those it checked. Parse and validation errors
+// are left for graphql.Do to report.
+func checkQueryLimits(schema *graphql.Schema, doc *ast.Document, operationName string, variables map[string]interface{}, limits QueryLimits) (int, error) {
+	if limits.MaxDepth <= 0 && limits.MaxComplexity <= 0 {
+		return 0, nil
+	}
+
+	a := &queryAnalyzer{
+		schema:    schema,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+	}
+	var operations []*ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			a.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				operations = append(operations, def)
+			}
+		}
+	}
+
+	complexity := 0
+	for _, op := range operations {
+		var root graphql.Type
+		switch op.Operation {
+		case ast.OperationTypeMutation:
+			root = schema.MutationType()
+		case ast.OperationTypeSubscription:
+			root = schema.SubscriptionType()
+		default:
+			root = schema.QueryType()
+		}
+
+		cost := a.selectionSet(op.SelectionSet, root, 1, map[string]bool{})
+		if limits.MaxDepth > 0 && cost.depth > limits.MaxDepth {
+			return 0, fmt.Errorf("query depth %d exceeds the maximum of %d", cost.depth, limits.MaxDepth)
+		}
+		if limits.MaxComplexity > 0 && cost.complexity > limits.MaxComplexity {
+			return 0, fmt.Errorf("query complexity %d exceeds the maximum of %d", cost.complexity, limits.MaxComplexity)
+		}
+		complexity = saturatingAdd(complexity, cost.complexity)
+	}
+
+	return complexity, nil
+}
+
+// complexityBudgetKey is the context key of a complexityBudget
+type complexityBudgetKey struct{}
+
+// complexityBudget is the complexity the operations of one batch have
+// spent so far
+type complexityBudget struct {
+	spent int
+}
+
+// withComplexityBudget charges every operation executed with the returned
+// context against one budget, so splitting a query across a batch does not
+// multiply the complexity it may request
+func withComplexityBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, complexityBudgetKey{}, &complexityBudget{})
+}
+
+// chargeComplexity spends cost from ctx's budget, refusing an operation
+// that would take the batch past limits. Operations run one at a time, so
+// the budget needs no lock.
+func chargeComplexity(ctx context.Context, cost int, limits QueryLimits) error {
+	budget, ok := ctx.Value(complexityBudgetKey{}).(*complexityBudget)
+	if !ok || limits.MaxComplexity <= 0 {
+		return nil
+	}
+	spent := saturatingAdd(budget.spent, cost)
+	if spent > limits.MaxComplexity {
+		return fmt.Errorf("batch complexity %d exceeds the maximum of %d", spent, limits.MaxComplexity)
+	}
+	budget.spent = spent
+	return nil
+}
+
+// saturatingAdd adds non-negative costs, stopping at math.MaxInt
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// saturatingMul multiplies non-negative costs, stopping at math.MaxInt
+func saturatingMul(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+// selectionSet returns the cost of set, whose fields sit at depth on parent.
+// visiting guards against fragment cycles, which validation rejects later.
+func (a *queryAnalyzer) selectionSet(set *ast.SelectionSet, parent graphql.Type, depth int, visiting map[string]bool) queryCost {
+	var total queryCost
+	if set == nil {
+		return total
+	}
+
+	for _, sel := range set.Selections {
+		var cost queryCost
+		switch sel := sel.(type) {
+		case *ast.Field:
+			cost = a.field(sel, parent, depth, visiting)
+		case *ast.InlineFragment:
+			typ := parent
+			if sel.TypeCondition != nil {
+				typ = a.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			cost = a.selectionSet(sel.SelectionSet, typ, depth, visiting)
+		case *ast.FragmentSpread:
+			frag, ok := a.fragments[sel.Name.Value]
+			if !ok || visiting[frag.Name.Value] {
+				continue
+			}
+			visiting[frag.Name.Value] = true
+			cost = a.selectionSet(frag.SelectionSet, a.schema.Type(frag.TypeCondition.Name.Value), depth, visiting)
+			delete(visiting, frag.Name.Value)
+		}
+
+		total.complexity = saturatingAdd(total.complexity, cost.complexity)
+		if cost.depth > total.depth {
+			total.depth = cost.depth
+		}
+	}
+
+	return total
+}
+
+func (a *queryAnalyzer) field(f *ast.Field, parent graphql.Type, depth int, visiting map[string]bool) queryCost {
+	// Introspection is bounded by the schema itself
+	if len(f.Name.Value) > 1 && f.Name.Value[:2] == "__" {
+		return queryCost{}
+	}
+
+	var fieldType graphql.Type
+	if obj, ok := parent.(*graphql.Object); ok {
+		if def, ok := obj.Fields()[f.Name.Value]; ok {
+			fieldType = def.Type
+		}
+	}
+
+	isList := false
+	for {
+		switch t := fieldType.(type) {
+		case *graphql.NonNull:
+			fieldType = t.OfType
+			continue
+		case *graphql.List:
+			isList = true
+			fieldType = t.OfType
+			continue
+		}
+		break
+	}
+
+	children := a.selectionSet(f.SelectionSet, fieldType, depth+1, visiting)
+	multiplier := 1
+	if isList {
+		multiplier = a.listSize(f)
+	}
+
+	cost := queryCost{depth: depth, complexity: saturatingAdd(1, saturatingMul(children.complexity, multiplier))}
+	if children.depth > cost.depth {
+		cost.depth = children.depth
+	}
+	return cost
+}
+
+// listSize estimates how many items a list field returns from its limit,
+// or the number of ids it is given
+func (a *queryAnalyzer) listSize(f *ast.Field) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value == "ids" {
+			switch v := arg.Value.(type) {
+			case *ast.ListValue:
+				return max(len(v.Values), 1)
+			case *ast.Variable:
+				if ids, ok := a.variables[v.Name.Value].([]interface{}); ok {
+					return max(len(ids), 1)
+				}
+			}
+			continue
+		}
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			n, err := strconv.Atoi(v.Value)
+			if errors.Is(err, strconv.ErrRange) && v.Value[0] != '-' {
+				return math.MaxInt
+			}
+			if err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			switch n := a.variables[v.Name.Value].(type) {
+			case int:
+				if n > 0 {
+					return n
+				}
+			case float64:
+				if n >= math.MaxInt {
+					return math.MaxInt
+				}
+				if n > 0 {
+					return int(n)
+				}
+			}
+		}
+	}
+	return defaultListSize
+}
@@ -0,0 +1,201 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// CORSPolicy overrides the gateway-wide CORS settings for every request
+// whose path starts with PathPrefix. The longest matching prefix wins.
+type CORSPolicy struct {
+	PathPrefix       string   `json:"path_prefix"`
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowCredentials bool     `json:"allow_credentials"`
+}
+
+// originMatcher matches request origins against an allow-list of exact
+// origins ("https://app.brivas.com"), wildcard subdomain patterns
+// ("https://*.brivas.com") or the catch-all "*"
+type originMatcher struct {
+	any       bool
+	exact     map[string]struct{}
+	wildcards []wildcardOrigin
+}
+
+type wildcardOrigin struct {
+	scheme string
+	suffix string // ".brivas.com" or ".brivas.com:8443"
+}
+
+func newOriginMatcher(origins []string) (*originMatcher, error) {
+	m := &originMatcher{exact: make(map[string]struct{})}
+
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		if origin == "*" {
+			m.any = true
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port]", origin)
+		}
+		if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid origin %q: must not contain a path, query or credentials", origin)
+		}
+
+		if strings.HasPrefix(u.Host, "*.") {
+			suffix := u.Host[1:]
+			if strings.Contains(suffix, "*") || len(strings.Trim(suffix, ".")) == 0 {
+				return nil, fmt.Errorf("invalid origin %q: only a single leading wildcard label is supported", origin)
+			}
+			m.wildcards = append(m.wildcards, wildcardOrigin{scheme: u.Scheme, suffix: suffix})
+			continue
+		}
+		if strings.Contains(u.Host, "*") {
+			return nil, fmt.Errorf("invalid origin %q: wildcard must be the leftmost label", origin)
+		}
+
+		m.exact[u.Scheme+"://"+u.Host] = struct{}{}
+	}
+
+	return m, nil
+}
+
+// Allowed reports whether the given Origin header value is on the allow-list
+func (m *originMatcher) Allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if m.any {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if _, ok := m.exact[origin]; ok {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, w := range m.wildcards {
+		if u.Scheme != w.scheme || !strings.HasSuffix(u.Host, w.suffix) {
+			continue
+		}
+		// Require at least one label in front of the suffix so that
+		// "https://*.brivas.com" does not match "https://brivas.com"
+		if label := strings.TrimSuffix(u.Host, w.suffix); label != "" && !strings.HasSuffix(label, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// corsPolicy is a compiled CORSPolicy
+type corsPolicy struct {
+	prefix  string
+	allowed []string
+	origins *originMatcher
+	handler *cors.Cors
+}
+
+// corsPolicies resolves the CORS policy to apply for a request path
+type corsPolicies struct {
+	routes   []*corsPolicy // sorted by descending prefix length
+	fallback *corsPolicy
+}
+
+// newCORSPolicies compiles the gateway-wide and per-route CORS settings,
+// rejecting configurations browsers would refuse or that are unsafe
+func newCORSPolicies(cfg *Config) (*corsPolicies, error) {
+	fallback, err := compileCORSPolicy(CORSPolicy{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowCredentials: cfg.AllowCredentials,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &corsPolicies{fallback: fallback}
+	seen := make(map[string]bool)
+	for _, route := range cfg.CORSPolicies {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return nil, fmt.Errorf("cors policy prefix %q must start with /", route.PathPrefix)
+		}
+		if seen[route.PathPrefix] {
+			return nil, fmt.Errorf("duplicate cors policy for prefix %q", route.PathPrefix)
+		}
+		seen[route.PathPrefix] = true
+
+		compiled, err := compileCORSPolicy(route)
+		if err != nil {
+			return nil, fmt.Errorf("cors policy %q: %w", route.PathPrefix, err)
+		}
+		p.routes = append(p.routes, compiled)
+	}
+
+	sort.Slice(p.routes, func(i, j int) bool {
+		return len(p.routes[i].prefix) > len(p.routes[j].prefix)
+	})
+
+	return p, nil
+}
+
+// compileCORSPolicy compiles policy. Without allowed origins it admits no
+// cross-origin requests, which suits a gateway serving only same-origin or
+// non-browser clients.
+func compileCORSPolicy(policy CORSPolicy) (*corsPolicy, error) {
+	origins, err := newOriginMatcher(policy.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+	if origins.any && policy.AllowCredentials {
+		return nil, fmt.Errorf("allowed origin \"*\" cannot be combined with credentials; list origins explicitly")
+	}
+
+	// AllowOriginFunc makes rs/cors reflect the matched origin back instead
+	// of answering with "*", which is what browsers require with credentials
+	return &corsPolicy{
+		prefix:  policy.PathPrefix,
+		allowed: policy.AllowedOrigins,
+		origins: origins,
+		handler: cors.New(cors.Options{
+			AllowOriginFunc:  origins.Allowed,
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"*"},
+			AllowCredentials: policy.AllowCredentials,
+		}),
+	}, nil
+}
+
+// forPath returns the policy with the longest prefix matching path
+func (p *corsPolicies) forPath(path string) *corsPolicy {
+	for _, route := range p.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route
+		}
+	}
+	return p.fallback
+}
+
+// Handler wraps next with the CORS policy selected per request path
+func (p *corsPolicies) Handler(next http.Handler) http.Handler {
+	wrapped := make(map[*corsPolicy]http.Handler, len(p.routes)+1)
+	wrapped[p.fallback] = p.fallback.handler.Handler(next)
+	for _, route := range p.routes {
+		wrapped[route] = route.handler.Handler(next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapped[p.forPath(r.URL.Path)].ServeHTTP(w, r)
+	})
+}
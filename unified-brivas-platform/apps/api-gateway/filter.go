@@ -0,0 +1,144 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// filterOperators maps the comparison operators of a structured filter to
+// SQL. Besides these, "in" takes an array and "is_null" a boolean.
+var filterOperators = map[string]string{
+	"eq":    "=",
+	"neq":   "<>",
+	"gt":    ">",
+	"gte":   ">=",
+	"lt":    "<",
+	"lte":   "<=",
+	"like":  "LIKE",
+	"ilike": "ILIKE",
+}
+
+// filterCondition renders a structured filter as AND-ed conditions on
+// table, appending the bound values to args. Each key is a column; a plain
+// value tests equality and an object applies operators, as in
+//
+//	{"status": "failed", "sent_date": {"gte": "2024-01-01"}, "sid": {"in": ["a", "b"]}}
+//
+// Columns are checked against the schema, since they end up as
+// identifiers in the query.
+func filterCondition(table TableSchema, filter map[string]interface{}, args []interface{}) (string, []interface{}, error) {
+	cols := make([]string, 0, len(filter))
+	for col := range filter {
+		if findColumn(table, col) == nil {
+			return "", args, fmt.Errorf("unknown column %q for table %s", col, table.Name)
+		}
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	var conditions []string
+	for _, col := range cols {
+		ops, ok := filter[col].(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{"eq": filter[col]}
+		}
+		names := make([]string, 0, len(ops))
+		for op := range ops {
+			names = append(names, op)
+		}
+		sort.Strings(names)
+
+		ref := table.ColumnRef(col)
+		for _, op := range names {
+			val := ops[op]
+			switch op {
+			case "in":
+				values, ok := val.([]interface{})
+				if !ok || len(values) == 0 {
+					return "", args, fmt.Errorf("%s: in takes a non-empty array", col)
+				}
+				placeholders := make([]string, len(values))
+				for i, v := range values {
+					if !scalarValue(v) {
+						return "", args, fmt.Errorf("%s: in takes an array of values", col)
+					}
+					args = append(args, v)
+					placeholders[i] = fmt.Sprintf("$%d", len(args))
+				}
+				conditions = append(conditions, fmt.Sprintf("%s IN (%s)", ref, strings.Join(placeholders, ", ")))
+			case "is_null":
+				isNull, ok := val.(bool)
+				if !ok {
+					return "", args, fmt.Errorf("%s: is_null takes a boolean", col)
+				}
+				if isNull {
+					conditions = append(conditions, ref+" IS NULL")
+				} else {
+					conditions = append(conditions, ref+" IS NOT NULL")
+				}
+			default:
+				sqlOp, ok := filterOperators[op]
+				if !ok {
+					return "", args, fmt.Errorf("%s: unknown operator %q", col, op)
+				}
+				if !scalarValue(val) {
+					return "", args, fmt.Errorf("%s: %s takes a value", col, op)
+				}
+				args = append(args, val)
+				conditions = append(conditions, fmt.Sprintf("%s %s $%d", ref, sqlOp, len(args)))
+			}
+		}
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// whereArg renders the where argument of a GraphQL field, a structured
+// filter as a JSON object, appending its values to args. Raw SQL is not
+// accepted: it could escape the row-level and soft-delete conditions it
+// is AND-ed with.
+func whereArg(table TableSchema, fieldArgs map[string]interface{}, args []interface{}) (string, []interface{}, error) {
+	where, ok := fieldArgs["where"].(string)
+	if !ok || where == "" {
+		return "", args, nil
+	}
+	var filter map[string]interface{}
+	if err := json.Unmarshal([]byte(where), &filter); err != nil {
+		return "", args, fmt.Errorf("where must be a JSON filter object such as {\"status\": \"active\"}")
+	}
+	return filterCondition(table, filter, args)
+}
+
+// scalarValue reports whether v can be bound as a query argument
+func scalarValue(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+// orderByClause renders "column [asc|desc], ..." as an ORDER BY clause,
+// checking the columns against the schema
+func orderByClause(table TableSchema, orderBy string) (string, error) {
+	var terms []string
+	for _, term := range strings.Split(orderBy, ",") {
+		fields := strings.Fields(term)
+		if len(fields) == 0 || len(fields) > 2 {
+			return "", fmt.Errorf("order_by must be a list of \"column [asc|desc]\"")
+		}
+		if findColumn(table, fields[0]) == nil {
+			return "", fmt.Errorf("unknown column %q for table %s", fields[0], table.Name)
+		}
+		dir := "ASC"
+		if len(fields) == 2 {
+			dir = strings.ToUpper(fields[1])
+			if dir != "ASC" && dir != "DESC" {
+				return "", fmt.Errorf("order_by direction must be asc or desc")
+			}
+		}
+		terms = append(terms, table.ColumnRef(fields[0])+" "+dir)
+	}
+	return " ORDER BY " + strings.Join(terms, ", "), nil
+}
@@ -3,22 +3,29 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
-	"github.com/rs/cors"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
 	"go.uber.org/zap"
 
+	"github.com/brivas/unified-platform/packages/apierr"
+	auth "github.com/brivas/unified-platform/packages/core"
 	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
 )
 
@@ -30,24 +37,74 @@ type UnifiedAPIEngine struct {
 	restAPI      *RESTHandler
 	websocketAPI *WebSocketHandler
 	mcpAPI       *MCPHandler
+	cors         *corsPolicies
+	changes      *ChangeNotifier
+	permissions  PermissionSource
 	router       chi.Router
 	logger       *zap.Logger
 	mu           sync.RWMutex
+	// dbSchema is the Postgres schema introspected and queried
+	dbSchema string
+	// softDelete and autoSoftDelete are reapplied to reloaded schemas
+	softDelete     map[string]string
+	autoSoftDelete bool
+	// healthChecks are the subsystems reported by /health and /ready
+	healthChecks map[string]registeredCheck
 }
 
+// DefaultDatabaseSchema is the Postgres schema used unless configured
+const DefaultDatabaseSchema = "public"
+
 // Schema represents the database schema used for API generation
 type Schema struct {
+	Name        string                   `json:"name"` // Postgres schema
 	Tables      []TableSchema            `json:"tables"`
 	Permissions map[string]PermissionSet `json:"permissions"`
 }
 
 // TableSchema defines a table structure for API generation
 type TableSchema struct {
+	Schema     string     `json:"schema,omitempty"` // Postgres schema, unqualified when empty
 	Name       string     `json:"name"`
 	PrimaryKey string     `json:"primary_key"`
 	Columns    []Column   `json:"columns"`
 	Indexes    []Index    `json:"indexes"`
 	Relations  []Relation `json:"relations"`
+	// SoftDeleteColumn, when set, makes DELETE flag rows instead of removing them
+	SoftDeleteColumn string `json:"soft_delete_column,omitempty"`
+	// PrimaryKeyInferred is set when the table has no primary key and
+	// PrimaryKey is the assumed "id"
+	PrimaryKeyInferred bool `json:"primary_key_inferred,omitempty"`
+}
+
+// QualifiedName returns the quoted, schema-qualified when a schema is
+// set, table reference used in generated queries
+func (t TableSchema) QualifiedName() string {
+	if t.Schema == "" {
+		return quoteIdentifier(t.Name)
+	}
+	return quoteIdentifier(t.Schema) + "." + quoteIdentifier(t.Name)
+}
+
+// ColumnRef returns the quoted "table"."column" reference to a column, so
+// reserved words and mixed case names survive in generated queries
+func (t TableSchema) ColumnRef(column string) string {
+	return quoteIdentifier(t.Name) + "." + quoteIdentifier(column)
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// checkColumns rejects row keys that are not columns of the table. The
+// keys come from the request body and end up as identifiers in the query.
+func checkColumns(table TableSchema, data map[string]interface{}) error {
+	for col := range data {
+		if findColumn(table, col) == nil {
+			return fmt.Errorf("unknown column %q for table %s", col, table.Name)
+		}
+	}
+	return nil
 }
 
 // Column represents a database column
@@ -65,10 +122,11 @@ type Index struct {
 	Unique  bool     `json:"unique"`
 }
 
-// Relation defines a foreign key relationship
+// Relation defines a foreign key relationship. Rows join on
+// this.ForeignKey = TargetTable.TargetKey.
 type Relation struct {
 	Name        string `json:"name"`
-	Type        string `json:"type"` // one-to-one, one-to-many, many-to-many
+	Type        string `json:"type"` // one-to-one, one-to-many, many-to-one, many-to-many
 	TargetTable string `json:"target_table"`
 	ForeignKey  string `json:"foreign_key"`
 	TargetKey   string `json:"target_key"`
@@ -99,7 +157,54 @@ type Config struct {
 	EnableWebSocket bool
 	EnableMCP       bool
 	EnableCORS      bool
-	AllowedOrigins  []string
+	// AllowedOrigins accepts exact origins, wildcard subdomain patterns
+	// such as "https://*.brivas.com", or "*" when credentials are disabled.
+	// Empty allows no cross-origin requests.
+	AllowedOrigins   []string
+	AllowCredentials bool
+	CORSPolicies     []CORSPolicy
+	// WebSocketAllowNoOrigin admits upgrades without an Origin header,
+	// which native and mobile clients do not send
+	WebSocketAllowNoOrigin bool
+	// WebSocket origins are taken from the CORS allow-list for /ws
+	WebSocket WebSocketOptions
+	// Authenticate resolves each request's claims, typically the auth
+	// engine's Middleware. It runs before rate limiting and idempotency,
+	// which key on the claims.
+	Authenticate func(http.Handler) http.Handler
+	// RateLimits are keyed by role; RateLimiter defaults to in-memory
+	EnableRateLimit bool
+	RateLimits      map[string]RateLimit
+	RateLimiter     RateLimiter
+	// Idempotency replays mutations that repeat an Idempotency-Key header
+	EnableIdempotency bool
+	Idempotency       IdempotencyOptions
+	// MaxDepth and MaxComplexity bound each GraphQL operation; 0 disables
+	MaxDepth      int
+	MaxComplexity int
+	// MaxBatchSize bounds the operations of a batched GraphQL request;
+	// 0 disables
+	MaxBatchSize int
+	// FieldAuthorizer hides GraphQL fields per role, typically the auth engine
+	FieldAuthorizer FieldAuthorizer
+	// ChangeNotifications lists tables whose writes are pushed to WebSocket
+	// subscribers; off by default to spare high-write tables the overhead
+	ChangeNotifications []string
+	// Permissions restricts API reads and writes per role, typically the auth engine
+	Permissions   PermissionSource
+	BulkChunkSize int
+	// SoftDelete maps tables to their deleted_at/is_deleted column;
+	// AutoSoftDelete detects those columns on every other table
+	SoftDelete     map[string]string
+	AutoSoftDelete bool
+	// StrictSchema refuses to generate APIs while ValidateSchema reports
+	// errors; every problem it finds is logged either way
+	StrictSchema bool
+	// RLSMode selects where row-level security is enforced: RLSModeGateway,
+	// the default, or RLSModeDatabase
+	RLSMode string
+	// ListLimits bounds the rows of GraphQL, REST and MCP lists
+	ListLimits ListLimits
 }
 
 // DefaultConfig returns default gateway configuration
@@ -113,33 +218,65 @@ func DefaultConfig() *Config {
 		EnableMCP:       true,
 		EnableCORS:      true,
 		AllowedOrigins:  []string{"*"},
+		WebSocket:       DefaultWebSocketOptions(),
+		EnableRateLimit: true,
+		RateLimits:      DefaultRateLimits(),
+
+		EnableIdempotency: true,
+		Idempotency:       DefaultIdempotencyOptions(),
+
+		MaxDepth:      10,
+		MaxComplexity: 1000,
+		MaxBatchSize:  DefaultMaxBatchSize,
+		BulkChunkSize: DefaultBulkChunkSize,
+		ListLimits:    ListLimits{Default: DefaultListLimit, Max: MaxListLimit},
 	}
 }
 
 // NewUnifiedAPIEngine creates a new Hasura-style API engine
 func NewUnifiedAPIEngine(db *lumadb.Client, logger *zap.Logger) *UnifiedAPIEngine {
 	engine := &UnifiedAPIEngine{
-		db:     db,
-		logger: logger,
-		router: chi.NewRouter(),
+		db:       db,
+		logger:   logger,
+		router:   chi.NewRouter(),
+		dbSchema: DefaultDatabaseSchema,
 	}
+	engine.RegisterHealthCheck("database", HealthCheckFunc(db.Health), true)
 
 	// Setup middleware
 	engine.router.Use(middleware.RequestID)
 	engine.router.Use(middleware.RealIP)
-	engine.router.Use(middleware.Logger)
+	engine.router.Use(Tracing)
+	engine.router.Use(RequestLogger(logger, DefaultRequestLogOptions()))
 	engine.router.Use(middleware.Recoverer)
 	engine.router.Use(middleware.Timeout(60 * time.Second))
 
 	return engine
 }
 
+// SetDatabaseSchema selects the Postgres schema, such as a tenant's, that
+// LoadSchemaFromDB introspects and generated queries target
+func (e *UnifiedAPIEngine) SetDatabaseSchema(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if name == "" {
+		name = DefaultDatabaseSchema
+	}
+	e.dbSchema = name
+}
+
 // LoadSchemaFromDB introspects LumaDB and builds schema for API generation
 func (e *UnifiedAPIEngine) LoadSchemaFromDB(ctx context.Context) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	dbSchema := e.dbSchema
+	if dbSchema == "" {
+		dbSchema = DefaultDatabaseSchema
+	}
+
 	schema := &Schema{
+		Name:        dbSchema,
 		Tables:      make([]TableSchema, 0),
 		Permissions: make(map[string]PermissionSet),
 	}
@@ -148,8 +285,8 @@ func (e *UnifiedAPIEngine) LoadSchemaFromDB(ctx context.Context) error {
 	rows, err := e.db.Query(ctx, `
 		SELECT table_name 
 		FROM information_schema.tables 
-		WHERE table_schema = 'public' AND table_type = 'BASE TABLE'
-	`)
+		WHERE table_schema = $1 AND table_type = 'BASE TABLE'
+	`, dbSchema)
 	if err != nil {
 		return fmt.Errorf("failed to query tables: %w", err)
 	}
@@ -167,6 +304,7 @@ func (e *UnifiedAPIEngine) LoadSchemaFromDB(ctx context.Context) error {
 	// For each table, get column information
 	for _, tableName := range tableNames {
 		table := TableSchema{
+			Schema:  dbSchema,
 			Name:    tableName,
 			Columns: make([]Column, 0),
 		}
@@ -174,9 +312,9 @@ func (e *UnifiedAPIEngine) LoadSchemaFromDB(ctx context.Context) error {
 		colRows, err := e.db.Query(ctx, `
 			SELECT column_name, data_type, is_nullable, column_default
 			FROM information_schema.columns
-			WHERE table_name = $1 AND table_schema = 'public'
+			WHERE table_name = $1 AND table_schema = $2
 			ORDER BY ordinal_position
-		`, tableName)
+		`, tableName, dbSchema)
 		if err != nil {
 			e.logger.Warn("failed to get columns", zap.String("table", tableName), zap.Error(err))
 			continue
@@ -202,17 +340,26 @@ func (e *UnifiedAPIEngine) LoadSchemaFromDB(ctx context.Context) error {
 			FROM pg_index i
 			JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
 			WHERE i.indrelid = $1::regclass AND i.indisprimary
-		`, tableName)
+		`, table.QualifiedName())
 		var pk string
 		if err := pkRow.Scan(&pk); err == nil {
 			table.PrimaryKey = pk
 		} else {
 			table.PrimaryKey = "id" // Default assumption
+			table.PrimaryKeyInferred = true
 		}
 
 		schema.Tables = append(schema.Tables, table)
 	}
 
+	// Indexes first: relation cardinality depends on unique indexes
+	if err := e.loadIndexes(ctx, schema); err != nil {
+		e.logger.Warn("failed to load indexes", zap.Error(err))
+	}
+	if err := e.loadRelations(ctx, schema); err != nil {
+		e.logger.Warn("failed to load relations", zap.Error(err))
+	}
+
 	e.schema = schema
 	e.logger.Info("schema loaded", zap.Int("tables", len(schema.Tables)))
 
@@ -225,24 +372,131 @@ func (e *UnifiedAPIEngine) GenerateAPIs(cfg *Config) error {
 		return fmt.Errorf("schema not loaded, call LoadSchemaFromDB first")
 	}
 
+	e.mu.Lock()
+	e.softDelete, e.autoSoftDelete = cfg.SoftDelete, cfg.AutoSoftDelete
+	err := configureSoftDelete(e.schema, cfg.SoftDelete, cfg.AutoSoftDelete)
+	e.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("invalid soft delete configuration: %w", err)
+	}
+
+	var schemaErrors []string
+	for _, w := range e.ValidateSchema() {
+		e.logger.Warn("schema problem",
+			zap.String("severity", w.Severity),
+			zap.String("code", w.Code),
+			zap.String("table", w.Table),
+			zap.String("column", w.Column),
+			zap.String("message", w.Message))
+		if w.Severity == SeverityError {
+			schemaErrors = append(schemaErrors, w.Message)
+		}
+	}
+	if cfg.StrictSchema && len(schemaErrors) > 0 {
+		return fmt.Errorf("schema has %d errors: %s", len(schemaErrors), strings.Join(schemaErrors, "; "))
+	}
+
+	// Compile the origin allow-list up front so a bad config fails startup
+	// and WebSocket origin checks share it with HTTP CORS. Neither needs
+	// it otherwise, so it is not validated then.
+	if cfg.EnableCORS || cfg.EnableWebSocket {
+		policies, err := newCORSPolicies(cfg)
+		if err != nil {
+			return fmt.Errorf("invalid CORS configuration: %w", err)
+		}
+		e.cors = policies
+	}
+
+	// Database sessions wrap the routes that query tables on behalf of
+	// the caller; WebSocket subscriptions keep the gateway's row filters
+	session := func(next http.Handler) http.Handler { return next }
+	switch cfg.RLSMode {
+	case "", RLSModeGateway:
+	case RLSModeDatabase:
+		session = SessionMiddleware(e.db, e.logger)
+	default:
+		return fmt.Errorf("invalid RLS mode %q", cfg.RLSMode)
+	}
+
+	// Middleware must be registered before any route is mounted
+	if cfg.Authenticate != nil {
+		e.router.Use(cfg.Authenticate)
+	}
+	if cfg.EnableRateLimit {
+		limiter := cfg.RateLimiter
+		if limiter == nil {
+			limiter = NewMemoryRateLimiter()
+		}
+		limits := cfg.RateLimits
+		if limits == nil {
+			limits = DefaultRateLimits()
+		}
+		e.router.Use(RateLimitMiddleware(limiter, limits, e.logger))
+	}
+
+	if cfg.EnableIdempotency {
+		opts := cfg.Idempotency
+		if opts.TTL == 0 {
+			opts = DefaultIdempotencyOptions()
+		}
+		e.router.Use(IdempotencyMiddleware(NewLumaDBIdempotencyStore(e.db), opts, e.logger))
+	}
+
+	if len(cfg.ChangeNotifications) > 0 {
+		e.changes = NewChangeNotifier(e.db, cfg.ChangeNotifications, e.logger)
+	}
+
 	// Generate GraphQL API
 	if cfg.EnableGraphQL {
 		e.graphqlAPI = NewGraphQLHandler(e.db, e.schema, e.logger)
-		e.router.Handle("/graphql", e.graphqlAPI)
-		e.router.Handle("/v1/graphql", e.graphqlAPI) // Hasura-compatible path
+		e.graphqlAPI.SetQueryLimits(QueryLimits{MaxDepth: cfg.MaxDepth, MaxComplexity: cfg.MaxComplexity})
+		e.graphqlAPI.SetListLimits(cfg.ListLimits)
+		e.graphqlAPI.SetMaxBatchSize(cfg.MaxBatchSize)
+		if cfg.FieldAuthorizer != nil {
+			e.graphqlAPI.SetFieldAuthorizer(cfg.FieldAuthorizer)
+		}
+		e.graphqlAPI.SetChangeNotifier(e.changes)
+		if cfg.Permissions != nil {
+			e.graphqlAPI.SetPermissions(cfg.Permissions)
+		}
+		e.router.Handle("/graphql", session(e.graphqlAPI))
+		e.router.Handle("/v1/graphql", session(e.graphqlAPI)) // Hasura-compatible path
 		e.logger.Info("GraphQL API enabled", zap.String("path", "/graphql"))
 	}
 
 	// Generate REST API
 	if cfg.EnableREST {
 		e.restAPI = NewRESTHandler(e.db, e.schema, e.logger)
-		e.router.Mount("/api/v1", e.restAPI.Routes())
+		e.restAPI.SetChangeNotifier(e.changes)
+		e.restAPI.SetBulkChunkSize(cfg.BulkChunkSize)
+		e.restAPI.SetListLimits(cfg.ListLimits)
+		if cfg.FieldAuthorizer != nil {
+			e.restAPI.SetFieldAuthorizer(cfg.FieldAuthorizer)
+		}
+		if cfg.Permissions != nil {
+			e.restAPI.SetPermissions(cfg.Permissions)
+		}
+		e.router.Mount("/api/v1", session(e.restAPI.Routes()))
 		e.logger.Info("REST API enabled", zap.String("path", "/api/v1"))
 	}
 
 	// Generate WebSocket API for subscriptions
 	if cfg.EnableWebSocket {
-		e.websocketAPI = NewWebSocketHandler(e.db, e.schema, e.logger)
+		wsOrigins := e.cors.forPath("/ws").allowed
+		e.websocketAPI, err = NewWebSocketHandler(e.db, e.schema, wsOrigins, cfg.WebSocketAllowNoOrigin, e.logger)
+		if err != nil {
+			return fmt.Errorf("failed to create WebSocket handler: %w", err)
+		}
+		if err := e.websocketAPI.SetOptions(cfg.WebSocket); err != nil {
+			return fmt.Errorf("failed to configure WebSocket handler: %w", err)
+		}
+		if e.graphqlAPI != nil {
+			e.websocketAPI.SetGraphQLHandler(e.graphqlAPI)
+			e.graphqlAPI.SetWebSocketHandler(e.websocketAPI)
+		}
+		if cfg.Permissions != nil {
+			e.websocketAPI.SetPermissions(cfg.Permissions)
+		}
 		e.router.Handle("/ws", e.websocketAPI)
 		e.logger.Info("WebSocket API enabled", zap.String("path", "/ws"))
 	}
@@ -250,7 +504,11 @@ func (e *UnifiedAPIEngine) GenerateAPIs(cfg *Config) error {
 	// Generate MCP API for LLM integration
 	if cfg.EnableMCP {
 		e.mcpAPI = NewMCPHandler(e.db, e.schema, e.logger)
-		e.router.Mount("/mcp", e.mcpAPI.Routes())
+		e.mcpAPI.SetListLimits(cfg.ListLimits)
+		if cfg.Permissions != nil {
+			e.mcpAPI.SetPermissions(cfg.Permissions)
+		}
+		e.router.Mount("/mcp", session(e.mcpAPI.Routes()))
 		e.logger.Info("MCP API enabled", zap.String("path", "/mcp"))
 	}
 
@@ -258,22 +516,45 @@ func (e *UnifiedAPIEngine) GenerateAPIs(cfg *Config) error {
 	e.router.Get("/health", e.healthCheck)
 	e.router.Get("/ready", e.readinessCheck)
 
+	// Schema introspection for debugging generated APIs
+	e.router.Get("/admin/schema", e.schemaIntrospection)
+	e.router.Post("/admin/schema/reload", e.reloadSchema)
+
+	// Typed Go client for the generated REST API
+	e.router.Get("/admin/sdk/go", e.goClientSDK)
+
+	// Role-scoped description of the generated APIs (Hasura-compatible path)
+	e.permissions = cfg.Permissions
+	e.router.Get("/v1/metadata", e.metadata)
+
 	return nil
 }
 
 // Start starts the API server
 func (e *UnifiedAPIEngine) Start(cfg *Config) error {
-	handler := e.router
+	var handler http.Handler = e.router
 
 	// Enable CORS if configured
 	if cfg.EnableCORS {
-		c := cors.New(cors.Options{
-			AllowedOrigins:   cfg.AllowedOrigins,
-			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"*"},
-			AllowCredentials: true,
-		})
-		handler = c.Handler(e.router).(chi.Router)
+		if e.cors == nil {
+			policies, err := newCORSPolicies(cfg)
+			if err != nil {
+				return fmt.Errorf("invalid CORS configuration: %w", err)
+			}
+			e.cors = policies
+		}
+		handler = e.cors.Handler(e.router)
+	}
+
+	// Relay committed writes to WebSocket subscribers while serving
+	if e.changes != nil && e.websocketAPI != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := e.websocketAPI.ListenForChanges(ctx, e.changes.Channels()); err != nil && ctx.Err() == nil {
+				e.logger.Error("change notification listener stopped", zap.Error(err))
+			}
+		}()
 	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
@@ -282,49 +563,60 @@ func (e *UnifiedAPIEngine) Start(cfg *Config) error {
 	return http.ListenAndServe(addr, handler)
 }
 
-func (e *UnifiedAPIEngine) healthCheck(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	health := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().UTC(),
-		"version":   "1.0.0",
-	}
-
-	// Check database connection
-	if err := e.db.Health(ctx); err != nil {
-		health["status"] = "unhealthy"
-		health["database"] = "disconnected"
-		w.WriteHeader(http.StatusServiceUnavailable)
-	} else {
-		health["database"] = "connected"
+// schemaIntrospection returns the schema the engine generated its APIs from,
+// with the problems ValidateSchema finds in it. It is restricted to admins
+// since it exposes every table and column.
+func (e *UnifiedAPIEngine) schemaIntrospection(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		apierr.Write(w, r, "admin role required", http.StatusForbidden)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(health)
-}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-func (e *UnifiedAPIEngine) readinessCheck(w http.ResponseWriter, r *http.Request) {
-	ready := map[string]interface{}{
-		"ready":  e.schema != nil && len(e.schema.Tables) > 0,
-		"tables": 0,
-	}
-	if e.schema != nil {
-		ready["tables"] = len(e.schema.Tables)
+	if e.schema == nil {
+		apierr.Write(w, r, "schema not loaded", http.StatusServiceUnavailable)
+		return
 	}
-
 	w.Header().Set("Content-Type", "application/json")
-	if !ready["ready"].(bool) {
-		w.WriteHeader(http.StatusServiceUnavailable)
+	warnings := validateSchema(e.schema)
+	if warnings == nil {
+		warnings = []SchemaWarning{}
 	}
-	json.NewEncoder(w).Encode(ready)
+	json.NewEncoder(w).Encode(struct {
+		*Schema
+		Warnings []SchemaWarning `json:"warnings"`
+	}{e.schema, warnings})
 }
 
 // GraphQLHandler handles GraphQL requests
 type GraphQLHandler struct {
-	db     *lumadb.Client
-	schema *graphql.Schema
-	logger *zap.Logger
+	db          *lumadb.Client
+	logger      *zap.Logger
+	limits      QueryLimits
+	listLimits  ListLimits
+	maxBatch    int
+	authz       FieldAuthorizer
+	changes     *ChangeNotifier
+	permissions PermissionSource
+	ws          http.Handler
+
+	watchMu  sync.Mutex
+	watchers map[*changeWatcher]struct{}
+
+	// schemaMu guards the served schema, which reloads swap
+	schemaMu    sync.RWMutex
+	schema      *graphql.Schema
+	fingerprint string
+	compiled    *schemaCache
+}
+
+// FieldAuthorizer decides which columns a role may read. The GraphQL schema
+// is shared by all roles, so the check runs in each field resolver against
+// the claims the auth middleware placed in the request context.
+type FieldAuthorizer interface {
+	CanSelectColumn(ctx context.Context, table string, role auth.Role, column string) bool
 }
 
 // NewGraphQLHandler creates a new GraphQL handler with auto-generated schema
@@ -333,34 +625,65 @@ func NewGraphQLHandler(db *lumadb.Client, dbSchema *Schema, logger *zap.Logger)
 		db:     db,
 		logger: logger,
 	}
+	handler.SetSchema(dbSchema)
+	return handler
+}
 
-	// Build GraphQL schema from database schema
+// buildSchema generates the GraphQL schema for the tables of dbSchema, with
+// resolvers bound to h
+func (h *GraphQLHandler) buildSchema(dbSchema *Schema) (*graphql.Schema, error) {
 	queryFields := graphql.Fields{}
 	mutationFields := graphql.Fields{}
+	subscriptionFields := graphql.Fields{}
 
 	for _, table := range dbSchema.Tables {
 		tableName := table.Name
-		objType := handler.buildObjectType(table)
+		objType := h.buildObjectType(table)
+
+		getArgs := graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+		}
+		listArgs := graphql.FieldConfigArgument{
+			"where":   &graphql.ArgumentConfig{Type: graphql.String},
+			"limit":   &graphql.ArgumentConfig{Type: graphql.Int},
+			"offset":  &graphql.ArgumentConfig{Type: graphql.Int},
+			"orderBy": &graphql.ArgumentConfig{Type: graphql.String},
+		}
+		deleteArgs := graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+		}
+		if table.SoftDeleteColumn != "" {
+			getArgs["includeDeleted"] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+			listArgs["includeDeleted"] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+			deleteArgs["hard"] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+		}
 
 		// Generate query: get single record
 		queryFields[toCamelCase(tableName)] = &graphql.Field{
-			Type: objType,
-			Args: graphql.FieldConfigArgument{
-				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
-			},
-			Resolve: handler.resolveGetOne(tableName, table.PrimaryKey),
+			Type:    objType,
+			Args:    getArgs,
+			Resolve: h.resolveGetOne(table),
 		}
 
 		// Generate query: list records
 		queryFields[toPlural(toCamelCase(tableName))] = &graphql.Field{
-			Type: graphql.NewList(objType),
-			Args: graphql.FieldConfigArgument{
-				"where":   &graphql.ArgumentConfig{Type: graphql.String},
-				"limit":   &graphql.ArgumentConfig{Type: graphql.Int},
-				"offset":  &graphql.ArgumentConfig{Type: graphql.Int},
-				"orderBy": &graphql.ArgumentConfig{Type: graphql.String},
-			},
-			Resolve: handler.resolveList(tableName),
+			Type:    graphql.NewList(objType),
+			Args:    listArgs,
+			Resolve: h.resolveList(table),
+		}
+
+		// Generate query: get records by ids
+		byIDsArgs := graphql.FieldConfigArgument{
+			"ids":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID)))},
+			"omitMissing": &graphql.ArgumentConfig{Type: graphql.Boolean},
+		}
+		if table.SoftDeleteColumn != "" {
+			byIDsArgs["includeDeleted"] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+		}
+		queryFields[tableName+"_by_pks"] = &graphql.Field{
+			Type:    graphql.NewList(objType),
+			Args:    byIDsArgs,
+			Resolve: h.resolveByIDs(table),
 		}
 
 		// Generate mutation: insert
@@ -369,7 +692,7 @@ func NewGraphQLHandler(db *lumadb.Client, dbSchema *Schema, logger *zap.Logger)
 			Args: graphql.FieldConfigArgument{
 				"object": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
 			},
-			Resolve: handler.resolveInsert(tableName),
+			Resolve: h.resolveInsert(table),
 		}
 
 		// Generate mutation: update
@@ -379,16 +702,24 @@ func NewGraphQLHandler(db *lumadb.Client, dbSchema *Schema, logger *zap.Logger)
 				"id":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
 				"_set": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
 			},
-			Resolve: handler.resolveUpdate(tableName, table.PrimaryKey),
+			Resolve: h.resolveUpdate(table),
 		}
 
 		// Generate mutation: delete
 		mutationFields["delete_"+tableName] = &graphql.Field{
+			Type:    objType,
+			Args:    deleteArgs,
+			Resolve: h.resolveDelete(table),
+		}
+
+		// Generate subscription: stream changed rows
+		subscriptionFields[tableName] = &graphql.Field{
 			Type: objType,
 			Args: graphql.FieldConfigArgument{
-				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"where": &graphql.ArgumentConfig{Type: graphql.String},
 			},
-			Resolve: handler.resolveDelete(tableName, table.PrimaryKey),
+			Resolve:   resolveChangedRow,
+			Subscribe: h.subscribeChanges(table),
 		}
 	}
 
@@ -402,16 +733,44 @@ func NewGraphQLHandler(db *lumadb.Client, dbSchema *Schema, logger *zap.Logger)
 		Fields: mutationFields,
 	})
 
-	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+	schemaConfig := graphql.SchemaConfig{
 		Query:    queryType,
 		Mutation: mutationType,
-	})
-	if err != nil {
-		logger.Error("failed to create GraphQL schema", zap.Error(err))
 	}
-	handler.schema = &schema
+	if len(subscriptionFields) > 0 {
+		schemaConfig.Subscription = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Subscription",
+			Fields: subscriptionFields,
+		})
+	}
 
-	return handler
+	schema, err := graphql.NewSchema(schemaConfig)
+	return &schema, err
+}
+
+// SetQueryLimits sets the depth and complexity budget for each operation
+func (h *GraphQLHandler) SetQueryLimits(limits QueryLimits) {
+	h.limits = limits
+}
+
+// SetMaxBatchSize bounds the operations of a batched request; 0 disables
+func (h *GraphQLHandler) SetMaxBatchSize(n int) {
+	h.maxBatch = n
+}
+
+// SetListLimits sets the default and largest limit of list fields
+func (h *GraphQLHandler) SetListLimits(limits ListLimits) {
+	h.listLimits = limits
+}
+
+// SetChangeNotifier announces committed mutations to subscribers
+func (h *GraphQLHandler) SetChangeNotifier(changes *ChangeNotifier) {
+	h.changes = changes
+}
+
+// SetFieldAuthorizer enables field-level authorization of column reads
+func (h *GraphQLHandler) SetFieldAuthorizer(authz FieldAuthorizer) {
+	h.authz = authz
 }
 
 func (h *GraphQLHandler) buildObjectType(table TableSchema) *graphql.Object {
@@ -419,7 +778,8 @@ func (h *GraphQLHandler) buildObjectType(table TableSchema) *graphql.Object {
 
 	for _, col := range table.Columns {
 		fields[toCamelCase(col.Name)] = &graphql.Field{
-			Type: mapSQLTypeToGraphQL(col.Type),
+			Type:    mapSQLTypeToGraphQL(col.Type),
+			Resolve: h.resolveColumn(table.Name, col.Name),
 		}
 	}
 
@@ -429,44 +789,115 @@ func (h *GraphQLHandler) buildObjectType(table TableSchema) *graphql.Object {
 	})
 }
 
-func (h *GraphQLHandler) resolveGetOne(tableName, primaryKey string) graphql.FieldResolveFn {
+// resolveColumn reads column from the scanned row, resolving to null with a
+// permission error when the caller's role may not see it
+func (h *GraphQLHandler) resolveColumn(tableName, column string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if h.authz != nil {
+			role := requestClaims(p.Context).Role
+			if !h.authz.CanSelectColumn(p.Context, tableName, role, column) {
+				return nil, fmt.Errorf("permission denied: %s cannot read %s.%s", role, tableName, column)
+			}
+		}
+
+		row, ok := p.Source.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return row[column], nil
+	}
+}
+
+func (h *GraphQLHandler) resolveGetOne(table TableSchema) graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (interface{}, error) {
-		id := p.Args["id"]
-		query := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", tableName, primaryKey)
+		id, err := primaryKeyValue(table, p.Args["id"])
+		if err != nil {
+			return nil, err
+		}
+		filter, err := rowFilter(p.Context, h.permissions, table.Name, requestClaims(p.Context), auth.PermissionSelect)
+		if err != nil {
+			return nil, err
+		}
+		query := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", table.QualifiedName(), table.ColumnRef(table.PrimaryKey))
+
+		includeDeleted, err := includeDeletedArg(p)
+		if err != nil {
+			return nil, err
+		}
+		if cond := liveRowsCondition(table); cond != "" && !includeDeleted {
+			query += " AND " + cond
+		}
+		// A row the filter hides resolves to null, like a missing one
+		condition, args := rlsCondition(table.Name, filter, requestClaims(p.Context), []interface{}{id})
+		if condition != "" {
+			query += " AND " + condition
+		}
 
-		row := h.db.QueryRow(p.Context, query, id)
-		// Scan into map - simplified for this example
-		return scanRowToMap(row, nil)
+		row, err := sessionQueryMap(p.Context, h.db, query, args...)
+		if err != nil || row == nil {
+			return nil, err
+		}
+		return row, nil
 	}
 }
 
-func (h *GraphQLHandler) resolveList(tableName string) graphql.FieldResolveFn {
+func (h *GraphQLHandler) resolveList(table TableSchema) graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (interface{}, error) {
-		query := fmt.Sprintf("SELECT * FROM %s", tableName)
+		query := fmt.Sprintf("SELECT * FROM %s", table.QualifiedName())
 
-		var args []interface{}
-		argIdx := 1
+		includeDeleted, err := includeDeletedArg(p)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := rowFilter(p.Context, h.permissions, table.Name, requestClaims(p.Context), auth.PermissionSelect)
+		if err != nil {
+			return nil, err
+		}
 
-		if where, ok := p.Args["where"].(string); ok && where != "" {
-			query += " WHERE " + where
+		var conditions []string
+		if cond := liveRowsCondition(table); cond != "" && !includeDeleted {
+			conditions = append(conditions, cond)
+		}
+		condition, args := rlsCondition(table.Name, filter, requestClaims(p.Context), nil)
+		if condition != "" {
+			conditions = append(conditions, condition)
+		}
+		if condition, args, err = whereArg(table, p.Args, args); err != nil {
+			return nil, err
+		}
+		if condition != "" {
+			conditions = append(conditions, condition)
+		}
+		argIdx := len(args) + 1
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
 		}
 
 		if orderBy, ok := p.Args["orderBy"].(string); ok && orderBy != "" {
-			query += " ORDER BY " + orderBy
+			clause, err := orderByClause(table, orderBy)
+			if err != nil {
+				return nil, err
+			}
+			query += clause
 		}
 
-		if limit, ok := p.Args["limit"].(int); ok {
-			query += fmt.Sprintf(" LIMIT $%d", argIdx)
-			args = append(args, limit)
-			argIdx++
+		// graphql-go needs the whole result to resolve the selection, so
+		// lists are paged like REST ones to bound it
+		requested, given := p.Args["limit"].(int)
+		limit, err := h.listLimits.limit(requested, given)
+		if err != nil {
+			return nil, err
 		}
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, limit)
+		argIdx++
 
 		if offset, ok := p.Args["offset"].(int); ok {
 			query += fmt.Sprintf(" OFFSET $%d", argIdx)
 			args = append(args, offset)
 		}
 
-		rows, err := h.db.Query(p.Context, query, args...)
+		rows, err := sessionQuery(p.Context, h.db, query, args...)
 		if err != nil {
 			return nil, err
 		}
@@ -476,13 +907,27 @@ func (h *GraphQLHandler) resolveList(tableName string) graphql.FieldResolveFn {
 	}
 }
 
-func (h *GraphQLHandler) resolveInsert(tableName string) graphql.FieldResolveFn {
+func (h *GraphQLHandler) resolveInsert(table TableSchema) graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (interface{}, error) {
 		objectJSON := p.Args["object"].(string)
 		var data map[string]interface{}
 		if err := json.Unmarshal([]byte(objectJSON), &data); err != nil {
 			return nil, err
 		}
+		if err := checkColumns(table, data); err != nil {
+			return nil, err
+		}
+		perm, err := insertPermission(p.Context, h.permissions, table.Name, requestClaims(p.Context))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = insertValues(perm, data, requestClaims(p.Context)); err != nil {
+			return nil, err
+		}
+		returning, err := h.graphQLReturning(p, table)
+		if err != nil {
+			return nil, err
+		}
 
 		columns := make([]string, 0, len(data))
 		placeholders := make([]string, 0, len(data))
@@ -490,101 +935,259 @@ func (h *GraphQLHandler) resolveInsert(tableName string) graphql.FieldResolveFn
 
 		i := 1
 		for col, val := range data {
-			columns = append(columns, col)
+			columns = append(columns, quoteIdentifier(col))
 			placeholders = append(placeholders, fmt.Sprintf("$%d", i))
 			values = append(values, val)
 			i++
 		}
 
 		query := fmt.Sprintf(
-			"INSERT INTO %s (%s) VALUES (%s) RETURNING *",
-			tableName,
+			"INSERT INTO %s (%s) VALUES (%s)%s",
+			table.QualifiedName(),
 			strings.Join(columns, ", "),
 			strings.Join(placeholders, ", "),
+			returning,
 		)
 
-		row := h.db.QueryRow(p.Context, query, values...)
-		return scanRowToMap(row, nil)
+		result, err := h.queryOne(p.Context, query, values...)
+		if err != nil || result == nil {
+			return nil, err
+		}
+
+		h.notify(p.Context, table.Name, ChangeInsert, insertedKey(result, data, table.PrimaryKey))
+		return result, nil
 	}
 }
 
-func (h *GraphQLHandler) resolveUpdate(tableName, primaryKey string) graphql.FieldResolveFn {
+func (h *GraphQLHandler) resolveUpdate(table TableSchema) graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (interface{}, error) {
-		id := p.Args["id"]
+		id, err := primaryKeyValue(table, p.Args["id"])
+		if err != nil {
+			return nil, err
+		}
+		filter, err := rowFilter(p.Context, h.permissions, table.Name, requestClaims(p.Context), auth.PermissionUpdate)
+		if err != nil {
+			return nil, err
+		}
 		setJSON := p.Args["_set"].(string)
 
 		var data map[string]interface{}
 		if err := json.Unmarshal([]byte(setJSON), &data); err != nil {
 			return nil, err
 		}
+		if err := checkColumns(table, data); err != nil {
+			return nil, err
+		}
+		returning, err := h.graphQLReturning(p, table)
+		if err != nil {
+			return nil, err
+		}
 
 		setClauses := make([]string, 0, len(data))
 		values := make([]interface{}, 0, len(data)+1)
 
 		i := 1
 		for col, val := range data {
-			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", col, i))
+			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), i))
 			values = append(values, val)
 			i++
 		}
 		values = append(values, id)
 
 		query := fmt.Sprintf(
-			"UPDATE %s SET %s WHERE %s = $%d RETURNING *",
-			tableName,
+			"UPDATE %s SET %s WHERE %s = $%d",
+			table.QualifiedName(),
 			strings.Join(setClauses, ", "),
-			primaryKey,
+			table.ColumnRef(table.PrimaryKey),
 			i,
 		)
+		condition, values := rlsCondition(table.Name, filter, requestClaims(p.Context), values)
+		if condition != "" {
+			query += " AND " + condition
+		}
+		query += returning
+
+		result, err := h.queryOne(p.Context, query, values...)
+		if err != nil || result == nil {
+			return nil, err
+		}
 
-		row := h.db.QueryRow(p.Context, query, values...)
-		return scanRowToMap(row, nil)
+		h.notify(p.Context, table.Name, ChangeUpdate, id)
+		return result, nil
 	}
 }
 
-func (h *GraphQLHandler) resolveDelete(tableName, primaryKey string) graphql.FieldResolveFn {
+func (h *GraphQLHandler) resolveDelete(table TableSchema) graphql.FieldResolveFn {
 	return func(p graphql.ResolveParams) (interface{}, error) {
-		id := p.Args["id"]
-		query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1 RETURNING *", tableName, primaryKey)
+		id, err := primaryKeyValue(table, p.Args["id"])
+		if err != nil {
+			return nil, err
+		}
+
+		filter, err := rowFilter(p.Context, h.permissions, table.Name, requestClaims(p.Context), auth.PermissionDelete)
+		if err != nil {
+			return nil, err
+		}
+
+		hard, _ := p.Args["hard"].(bool)
+		if hard && !isAdmin(p.Context) {
+			return nil, fmt.Errorf("hard delete requires an admin role")
+		}
+
+		returning, err := h.graphQLReturning(p, table)
+		if err != nil {
+			return nil, err
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", table.QualifiedName(), table.ColumnRef(table.PrimaryKey))
+		if table.SoftDeleteColumn != "" && !hard {
+			query = fmt.Sprintf("UPDATE %s SET %s WHERE %s = $1 AND %s",
+				table.QualifiedName(), softDeleteAssignment(table), table.ColumnRef(table.PrimaryKey), liveRowsCondition(table))
+		}
+		condition, args := rlsCondition(table.Name, filter, requestClaims(p.Context), []interface{}{id})
+		if condition != "" {
+			query += " AND " + condition
+		}
+		query += returning
+
+		result, err := h.queryOne(p.Context, query, args...)
+		if err != nil || result == nil {
+			return nil, err
+		}
 
-		row := h.db.QueryRow(p.Context, query, id)
-		return scanRowToMap(row, nil)
+		h.notify(p.Context, table.Name, ChangeDelete, id)
+		return result, nil
 	}
 }
 
+// DefaultMaxBatchSize bounds the operations of a batched request unless
+// configured otherwise
+const DefaultMaxBatchSize = 20
+
+// graphQLRequest is a single GraphQL operation in a request body
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
 func (h *GraphQLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var params struct {
-		Query         string                 `json:"query"`
-		OperationName string                 `json:"operationName"`
-		Variables     map[string]interface{} `json:"variables"`
+	if h.ws != nil && isGraphQLWS(r) {
+		h.ws.ServeHTTP(w, r)
+		return
 	}
 
+	var params graphQLRequest
+
 	if r.Method == "POST" {
-		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			apierr.Write(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Batched requests arrive as a JSON array of operations
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			h.serveBatch(w, r, trimmed)
+			return
+		}
+
+		if err := json.Unmarshal(body, &params); err != nil {
+			apierr.Write(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 	} else {
 		params.Query = r.URL.Query().Get("query")
 	}
 
-	result := graphql.Do(graphql.Params{
-		Schema:         *h.schema,
+	result := h.execute(r.Context(), params)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// serveBatch executes each operation in order against the same request
+// context, so request-scoped state is shared across the batch. A malformed
+// operation yields an error entry in its slot instead of failing the batch.
+// The batch shares one complexity budget: an operation that would exhaust
+// it fails in its slot and those after it may still fit.
+func (h *GraphQLHandler) serveBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var operations []json.RawMessage
+	if err := json.Unmarshal(body, &operations); err != nil {
+		apierr.Write(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(operations) == 0 {
+		apierr.Write(w, r, "empty batch", http.StatusBadRequest)
+		return
+	}
+	if h.maxBatch > 0 && len(operations) > h.maxBatch {
+		apierr.Write(w, r, fmt.Sprintf("batch of %d operations exceeds the maximum of %d", len(operations), h.maxBatch), http.StatusBadRequest)
+		return
+	}
+
+	ctx := withComplexityBudget(r.Context())
+	results := make([]*graphql.Result, len(operations))
+	for i, raw := range operations {
+		var params graphQLRequest
+		if err := json.Unmarshal(raw, &params); err != nil {
+			results[i] = &graphql.Result{
+				Errors: []gqlerrors.FormattedError{
+					gqlerrors.NewFormattedError(fmt.Sprintf("invalid operation at index %d: %v", i, err)),
+				},
+			}
+			continue
+		}
+		results[i] = h.execute(ctx, params)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (h *GraphQLHandler) execute(ctx context.Context, params graphQLRequest) *graphql.Result {
+	// Field resolvers check permissions per row; resolve each once
+	ctx = auth.WithPermissionCache(ctx)
+
+	schema := h.currentSchema()
+	gqlParams := graphql.Params{
+		Schema:         *schema,
 		RequestString:  params.Query,
 		VariableValues: params.Variables,
 		OperationName:  params.OperationName,
-		Context:        r.Context(),
-	})
+		Context:        ctx,
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	// Reject over-budget operations before any resolver runs
+	doc, err := parser.Parse(parser.ParseParams{Source: params.Query})
+	if err != nil {
+		return graphql.Do(gqlParams)
+	}
+	complexity, err := checkQueryLimits(schema, doc, params.OperationName, params.Variables, h.limits)
+	if err == nil {
+		err = chargeComplexity(ctx, complexity, h.limits)
+	}
+	if err != nil {
+		return &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())},
+		}
+	}
+
+	if h.db != nil && isMutation(doc, params.OperationName) {
+		return h.executeMutation(ctx, gqlParams)
+	}
+	return graphql.Do(gqlParams)
 }
 
 // RESTHandler handles REST API requests
 type RESTHandler struct {
-	db     *lumadb.Client
-	schema *Schema
-	logger *zap.Logger
+	db            *lumadb.Client
+	schema        *Schema
+	logger        *zap.Logger
+	changes       *ChangeNotifier
+	permissions   PermissionSource
+	authz         FieldAuthorizer
+	bulkChunkSize int
+	listLimits    ListLimits
 }
 
 // NewRESTHandler creates a new REST handler
@@ -592,72 +1195,169 @@ func NewRESTHandler(db *lumadb.Client, schema *Schema, logger *zap.Logger) *REST
 	return &RESTHandler{db: db, schema: schema, logger: logger}
 }
 
-// Routes returns the REST API routes
-func (h *RESTHandler) Routes() chi.Router {
-	r := chi.NewRouter()
+// SetChangeNotifier announces committed writes to subscribers
+func (h *RESTHandler) SetChangeNotifier(changes *ChangeNotifier) {
+	h.changes = changes
+}
 
-	for _, table := range h.schema.Tables {
-		tableName := table.Name
-		pk := table.PrimaryKey
+// SetPermissions enforces role permissions on every read and write
+func (h *RESTHandler) SetPermissions(permissions PermissionSource) {
+	h.permissions = permissions
+}
 
-		// GET /resource - List
-		r.Get("/"+tableName, h.handleList(tableName))
+// SetFieldAuthorizer limits the columns writes return to those the
+// caller's role may read
+func (h *RESTHandler) SetFieldAuthorizer(authz FieldAuthorizer) {
+	h.authz = authz
+}
 
-		// GET /resource/{id} - Get one
-		r.Get("/"+tableName+"/{id}", h.handleGetOne(tableName, pk))
+// SetBulkChunkSize sets how many rows each bulk transaction writes
+func (h *RESTHandler) SetBulkChunkSize(size int) {
+	h.bulkChunkSize = size
+}
+
+// SetListLimits sets the default and largest ?limit= of lists
+func (h *RESTHandler) SetListLimits(limits ListLimits) {
+	h.listLimits = limits
+}
+
+// Routes returns the REST API routes
+func (h *RESTHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	for _, table := range h.schema.Tables {
+		tableName := table.Name
+
+		// GET /resource - List, or GET /resource?ids=1,2,3 - Get many
+		r.Get("/"+tableName, h.handleList(table))
+
+		// GET /resource/{id} - Get one
+		r.Get("/"+tableName+"/{id}", h.handleGetOne(table))
 
 		// POST /resource - Create
-		r.Post("/"+tableName, h.handleCreate(tableName))
+		r.Post("/"+tableName, h.handleCreate(table))
 
 		// PUT /resource/{id} - Update
-		r.Put("/"+tableName+"/{id}", h.handleUpdate(tableName, pk))
+		r.Put("/"+tableName+"/{id}", h.handleUpdate(table))
 
 		// PATCH /resource/{id} - Partial update
-		r.Patch("/"+tableName+"/{id}", h.handleUpdate(tableName, pk))
+		r.Patch("/"+tableName+"/{id}", h.handleUpdate(table))
 
 		// DELETE /resource/{id} - Delete
-		r.Delete("/"+tableName+"/{id}", h.handleDelete(tableName, pk))
+		r.Delete("/"+tableName+"/{id}", h.handleDelete(table))
 
 		// POST /resource/bulk - Bulk insert
-		r.Post("/"+tableName+"/bulk", h.handleBulkCreate(tableName))
+		r.Post("/"+tableName+"/bulk", h.handleBulkCreate(table))
+
+		// PUT /resource/bulk - Bulk upsert
+		r.Put("/"+tableName+"/bulk", h.handleBulkUpsert(table))
 	}
 
 	return r
 }
 
-func (h *RESTHandler) handleList(tableName string) http.HandlerFunc {
+func (h *RESTHandler) handleList(table TableSchema) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		query := fmt.Sprintf("SELECT * FROM %s LIMIT 100", tableName)
+		if r.URL.Query().Has("ids") {
+			h.handleListByIDs(w, r, table)
+			return
+		}
 
-		rows, err := h.db.Query(ctx, query)
+		includeDeleted, ok := h.includeDeleted(w, r)
+		if !ok {
+			return
+		}
+		params, err := parseListParams(r.URL.Query(), h.listLimits)
 		if err != nil {
-			h.jsonError(w, err.Error(), http.StatusInternalServerError)
+			h.jsonError(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
+		filter, err := rowFilter(ctx, h.permissions, table.Name, requestClaims(ctx), auth.PermissionSelect)
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		// The page and the count share the same conditions
+		var conditions []string
+		if cond := liveRowsCondition(table); cond != "" && !includeDeleted {
+			conditions = append(conditions, cond)
+		}
+		condition, args := rlsCondition(table.Name, filter, requestClaims(ctx), nil)
+		if condition != "" {
+			conditions = append(conditions, condition)
+		}
+		var where string
+		if len(conditions) > 0 {
+			where = " WHERE " + strings.Join(conditions, " AND ")
+		}
 
-		results, err := scanRowsToMaps(rows)
+		// The count goes in a header, so it runs before the page is written
+		page := Pagination{Limit: params.limit, Offset: params.offset}
+		if params.count != CountNone {
+			total, err := h.countRows(ctx, table, where, args, params.count)
+			if err != nil {
+				h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			page.Total = &total
+			page.Estimated = params.count == CountEstimated
+			w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		}
+
+		// One row past the page tells whether another page exists
+		query := fmt.Sprintf("SELECT * FROM %s%s LIMIT $%d OFFSET $%d", table.QualifiedName(), where, len(args)+1, len(args)+2)
+		pageArgs := append(append([]interface{}(nil), args...), params.limit+1, params.offset)
+
+		rows, err := sessionQuery(ctx, h.db, query, pageArgs...)
 		if err != nil {
-			h.jsonError(w, err.Error(), http.StatusInternalServerError)
+			h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer rows.Close()
 
-		h.jsonResponse(w, results, http.StatusOK)
+		h.writeListPage(w, r, rows, page)
 	}
 }
 
-func (h *RESTHandler) handleGetOne(tableName, pk string) http.HandlerFunc {
+func (h *RESTHandler) handleGetOne(table TableSchema) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		id := chi.URLParam(r, "id")
+		id, err := primaryKeyValue(table, chi.URLParam(r, "id"))
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		query := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", tableName, pk)
-		row := h.db.QueryRow(ctx, query, id)
+		includeDeleted, ok := h.includeDeleted(w, r)
+		if !ok {
+			return
+		}
+		filter, err := rowFilter(ctx, h.permissions, table.Name, requestClaims(ctx), auth.PermissionSelect)
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
 
-		result, err := scanRowToMap(row, nil)
+		query := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", table.QualifiedName(), table.ColumnRef(table.PrimaryKey))
+		if cond := liveRowsCondition(table); cond != "" && !includeDeleted {
+			query += " AND " + cond
+		}
+		// A row the filter hides is answered 404 like a missing one, so
+		// other tenants' keys do not leak
+		condition, args := rlsCondition(table.Name, filter, requestClaims(ctx), []interface{}{id})
+		if condition != "" {
+			query += " AND " + condition
+		}
+
+		result, err := sessionQueryMap(ctx, h.db, query, args...)
 		if err != nil {
-			h.jsonError(w, "not found", http.StatusNotFound)
+			h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if result == nil {
+			h.jsonError(w, r, "not found", http.StatusNotFound)
 			return
 		}
 
@@ -665,54 +1365,124 @@ func (h *RESTHandler) handleGetOne(tableName, pk string) http.HandlerFunc {
 	}
 }
 
-func (h *RESTHandler) handleCreate(tableName string) http.HandlerFunc {
+func (h *RESTHandler) handleCreate(table TableSchema) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		perm, err := insertPermission(ctx, h.permissions, table.Name, requestClaims(ctx))
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
 
 		var data map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			h.jsonError(w, "invalid JSON", http.StatusBadRequest)
+			h.jsonError(w, r, "invalid JSON", http.StatusBadRequest)
 			return
 		}
-
-		columns := make([]string, 0, len(data))
-		placeholders := make([]string, 0, len(data))
-		values := make([]interface{}, 0, len(data))
-
-		i := 1
-		for col, val := range data {
-			columns = append(columns, col)
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-			values = append(values, val)
-			i++
+		if err := checkColumns(table, data); err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if data, err = insertValues(perm, data, requestClaims(ctx)); err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+		returning, ok := h.restReturning(w, r, table)
+		if !ok {
+			return
 		}
 
-		query := fmt.Sprintf(
-			"INSERT INTO %s (%s) VALUES (%s) RETURNING *",
-			tableName,
-			strings.Join(columns, ", "),
-			strings.Join(placeholders, ", "),
-		)
-
-		row := h.db.QueryRow(ctx, query, values...)
-		result, err := scanRowToMap(row, nil)
+		result, key, err := h.insertRow(ctx, table, data, returning)
 		if err != nil {
-			h.jsonError(w, err.Error(), http.StatusInternalServerError)
+			h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		h.changes.Notify(ctx, table.Name, ChangeInsert, key)
 		h.jsonResponse(w, result, http.StatusCreated)
 	}
 }
 
-func (h *RESTHandler) handleUpdate(tableName, pk string) http.HandlerFunc {
+// insertRow inserts data and returns the returning columns of the new row,
+// along with its key for change notifications, which is read even when it
+// is not to be returned
+func (h *RESTHandler) insertRow(ctx context.Context, table TableSchema, data map[string]interface{}, returning []string) (map[string]interface{}, interface{}, error) {
+	columns := make([]string, 0, len(data))
+	placeholders := make([]string, 0, len(data))
+	values := make([]interface{}, 0, len(data))
+
+	i := 1
+	for col, val := range data {
+		columns = append(columns, quoteIdentifier(col))
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i))
+		values = append(values, val)
+		i++
+	}
+
+	read := returning
+	if !contains(read, table.PrimaryKey) {
+		read = append(read[:len(read):len(read)], table.PrimaryKey)
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)%s",
+		table.QualifiedName(),
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+		returningClause(read),
+	)
+
+	rows, err := sessionQuery(ctx, h.db, query, values...)
+	if err != nil {
+		return nil, nil, err
+	}
+	results, err := scanRowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil, fmt.Errorf("insert into %s returned no row", table.Name)
+	}
+
+	result := results[0]
+	key := insertedKey(result, data, table.PrimaryKey)
+	if len(read) > len(returning) {
+		delete(result, table.PrimaryKey)
+	}
+	return result, key, nil
+}
+
+func (h *RESTHandler) handleUpdate(table TableSchema) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		id := chi.URLParam(r, "id")
+		key, err := primaryKeyValue(table, id)
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter, err := rowFilter(ctx, h.permissions, table.Name, requestClaims(ctx), auth.PermissionUpdate)
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
 
 		var data map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			h.jsonError(w, "invalid JSON", http.StatusBadRequest)
+			h.jsonError(w, r, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if len(data) == 0 {
+			h.jsonError(w, r, "no columns to update", http.StatusBadRequest)
+			return
+		}
+		if err := checkColumns(table, data); err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		returning, ok := h.restReturning(w, r, table)
+		if !ok {
 			return
 		}
 
@@ -721,86 +1491,134 @@ func (h *RESTHandler) handleUpdate(tableName, pk string) http.HandlerFunc {
 
 		i := 1
 		for col, val := range data {
-			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", col, i))
+			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), i))
 			values = append(values, val)
 			i++
 		}
-		values = append(values, id)
+		values = append(values, key)
 
 		query := fmt.Sprintf(
-			"UPDATE %s SET %s WHERE %s = $%d RETURNING *",
-			tableName,
+			"UPDATE %s SET %s WHERE %s = $%d",
+			table.QualifiedName(),
 			strings.Join(setClauses, ", "),
-			pk,
+			table.ColumnRef(table.PrimaryKey),
 			i,
 		)
 
-		row := h.db.QueryRow(ctx, query, values...)
-		result, err := scanRowToMap(row, nil)
-		if err != nil {
-			h.jsonError(w, "not found", http.StatusNotFound)
-			return
-		}
-
-		h.jsonResponse(w, result, http.StatusOK)
+		h.writeReturning(w, r, table.Name, ChangeUpdate, id, query, values, filter, returning)
 	}
 }
 
-func (h *RESTHandler) handleDelete(tableName, pk string) http.HandlerFunc {
+func (h *RESTHandler) handleDelete(table TableSchema) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		id := chi.URLParam(r, "id")
+		key, err := primaryKeyValue(table, id)
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1 RETURNING *", tableName, pk)
-		row := h.db.QueryRow(ctx, query, id)
-
-		result, err := scanRowToMap(row, nil)
+		filter, err := rowFilter(ctx, h.permissions, table.Name, requestClaims(ctx), auth.PermissionDelete)
 		if err != nil {
-			h.jsonError(w, "not found", http.StatusNotFound)
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
 			return
 		}
 
-		h.jsonResponse(w, result, http.StatusOK)
+		// ?hard=true removes audited rows outright and is reserved for admins
+		hard := r.URL.Query().Get("hard") == "true"
+		if hard && !isAdmin(ctx) {
+			h.jsonError(w, r, "hard delete requires an admin role", http.StatusForbidden)
+			return
+		}
+		returning, ok := h.restReturning(w, r, table)
+		if !ok {
+			return
+		}
+
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", table.QualifiedName(), table.ColumnRef(table.PrimaryKey))
+		if table.SoftDeleteColumn != "" && !hard {
+			query = fmt.Sprintf("UPDATE %s SET %s WHERE %s = $1 AND %s",
+				table.QualifiedName(), softDeleteAssignment(table), table.ColumnRef(table.PrimaryKey), liveRowsCondition(table))
+		}
+		h.writeReturning(w, r, table.Name, ChangeDelete, id, query, []interface{}{key}, filter, returning)
 	}
 }
 
-func (h *RESTHandler) handleBulkCreate(tableName string) http.HandlerFunc {
+// writeReturning runs an UPDATE or DELETE restricted by the role's row
+// filter and responds with the returning columns of the affected rows. No
+// returned row means 404, whether the key does not exist or the filter
+// hides it, so existence of other tenants' rows does not leak.
+func (h *RESTHandler) writeReturning(w http.ResponseWriter, r *http.Request, tableName, operation, id, query string, args []interface{}, filter map[string]string, returning []string) {
+	ctx := r.Context()
+
+	condition, args := rlsCondition(tableName, filter, requestClaims(ctx), args)
+	if condition != "" {
+		query += " AND " + condition
+	}
+	query += returningClause(returning)
+
+	rows, err := sessionQuery(ctx, h.db, query, args...)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	results, err := scanRowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(results) == 0 {
+		h.jsonError(w, r, "not found", http.StatusNotFound)
+		return
+	}
+
+	h.changes.Notify(ctx, tableName, operation, id)
+	h.jsonResponse(w, map[string]interface{}{
+		"affected_rows": len(results),
+		"returning":     results,
+	}, http.StatusOK)
+}
+
+func (h *RESTHandler) handleBulkCreate(table TableSchema) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		perm, err := insertPermission(ctx, h.permissions, table.Name, requestClaims(ctx))
+		if err != nil {
+			h.jsonError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
 
 		var items []map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
-			h.jsonError(w, "invalid JSON array", http.StatusBadRequest)
+			h.jsonError(w, r, "invalid JSON array", http.StatusBadRequest)
+			return
+		}
+		for i, data := range items {
+			if err := checkColumns(table, data); err != nil {
+				h.jsonError(w, r, fmt.Sprintf("item %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+			if items[i], err = insertValues(perm, data, requestClaims(ctx)); err != nil {
+				h.jsonError(w, r, fmt.Sprintf("item %d: %v", i, err), http.StatusForbidden)
+				return
+			}
+		}
+		returning, ok := h.restReturning(w, r, table)
+		if !ok {
 			return
 		}
 
 		results := make([]map[string]interface{}, 0, len(items))
 
 		for _, data := range items {
-			columns := make([]string, 0, len(data))
-			placeholders := make([]string, 0, len(data))
-			values := make([]interface{}, 0, len(data))
-
-			i := 1
-			for col, val := range data {
-				columns = append(columns, col)
-				placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-				values = append(values, val)
-				i++
-			}
-
-			query := fmt.Sprintf(
-				"INSERT INTO %s (%s) VALUES (%s) RETURNING *",
-				tableName,
-				strings.Join(columns, ", "),
-				strings.Join(placeholders, ", "),
-			)
-
-			row := h.db.QueryRow(ctx, query, values...)
-			result, err := scanRowToMap(row, nil)
+			result, key, err := h.insertRow(ctx, table, data, returning)
 			if err != nil {
 				continue
 			}
+			h.changes.Notify(ctx, table.Name, ChangeInsert, key)
 			results = append(results, result)
 		}
 
@@ -817,31 +1635,153 @@ func (h *RESTHandler) jsonResponse(w http.ResponseWriter, data interface{}, stat
 	json.NewEncoder(w).Encode(data)
 }
 
-func (h *RESTHandler) jsonError(w http.ResponseWriter, message string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+func (h *RESTHandler) jsonError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	apierr.Write(w, r, message, status)
 }
 
 // WebSocketHandler handles WebSocket subscriptions
 type WebSocketHandler struct {
-	db       *lumadb.Client
-	schema   *Schema
-	logger   *zap.Logger
-	upgrader websocket.Upgrader
-	clients  sync.Map
+	db            *lumadb.Client
+	schema        *Schema
+	logger        *zap.Logger
+	upgrader      websocket.Upgrader
+	opts          WebSocketOptions
+	origins       *originMatcher
+	allowNoOrigin bool
+	clients       sync.Map
+	graphql       *GraphQLHandler
+	// permissions, when set, restricts channels to the tables a caller's
+	// role may read in full
+	permissions PermissionSource
+
+	connections   int64
+	subscriptions int64
+	dropped       int64
 }
 
-// NewWebSocketHandler creates a new WebSocket handler
-func NewWebSocketHandler(db *lumadb.Client, schema *Schema, logger *zap.Logger) *WebSocketHandler {
-	return &WebSocketHandler{
-		db:     db,
-		schema: schema,
-		logger: logger,
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
-		},
+// Slow client policies applied when a client's send buffer is full
+const (
+	SlowClientDrop       = "drop"
+	SlowClientDisconnect = "disconnect"
+)
+
+// WebSocketOptions configures per-connection limits
+type WebSocketOptions struct {
+	MaxSubscriptions int
+	SendBuffer       int
+	SlowClientPolicy string // drop or disconnect
+	WriteTimeout     time.Duration
+}
+
+// DefaultWebSocketOptions returns default WebSocket limits
+func DefaultWebSocketOptions() WebSocketOptions {
+	return WebSocketOptions{
+		MaxSubscriptions: 20,
+		SendBuffer:       256,
+		SlowClientPolicy: SlowClientDrop,
+		WriteTimeout:     10 * time.Second,
+	}
+}
+
+// WebSocketStats reports live connection counts for the health endpoint
+type WebSocketStats struct {
+	Connections   int64 `json:"connections"`
+	Subscriptions int64 `json:"subscriptions"`
+	Dropped       int64 `json:"dropped_messages"`
+}
+
+// wsMessage is the envelope exchanged with WebSocket clients
+type wsMessage struct {
+	Type    string          `json:"type"`
+	Channel string          `json:"channel,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsClient is a single connection with its own buffered send queue so a
+// slow reader never blocks the broadcast fan-out
+type wsClient struct {
+	conn *websocket.Conn
+	// ctx is the upgrade request's, carrying the caller's claims
+	ctx           context.Context
+	send          chan []byte
+	done          chan struct{}
+	closeOnce     sync.Once
+	mu            sync.Mutex
+	subscriptions map[string]struct{}
+}
+
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+func (c *wsClient) subscribed(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.subscriptions[channel]
+	return ok
+}
+
+// NewWebSocketHandler creates a new WebSocket handler that only accepts
+// upgrades from allowedOrigins, using the same matcher as HTTP CORS
+func NewWebSocketHandler(db *lumadb.Client, schema *Schema, allowedOrigins []string, allowNoOrigin bool, logger *zap.Logger) (*WebSocketHandler, error) {
+	origins, err := newOriginMatcher(allowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &WebSocketHandler{
+		db:            db,
+		schema:        schema,
+		logger:        logger,
+		opts:          DefaultWebSocketOptions(),
+		origins:       origins,
+		allowNoOrigin: allowNoOrigin,
+	}
+	h.upgrader = websocket.Upgrader{CheckOrigin: h.checkOrigin}
+
+	return h, nil
+}
+
+// SetOptions replaces the default limits; zero fields keep their default.
+// It must be called before the handler serves connections.
+func (h *WebSocketHandler) SetOptions(opts WebSocketOptions) error {
+	defaults := DefaultWebSocketOptions()
+	if opts.MaxSubscriptions <= 0 {
+		opts.MaxSubscriptions = defaults.MaxSubscriptions
+	}
+	if opts.SendBuffer <= 0 {
+		opts.SendBuffer = defaults.SendBuffer
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaults.WriteTimeout
+	}
+	switch opts.SlowClientPolicy {
+	case "":
+		opts.SlowClientPolicy = defaults.SlowClientPolicy
+	case SlowClientDrop, SlowClientDisconnect:
+	default:
+		return fmt.Errorf("unknown slow client policy %q", opts.SlowClientPolicy)
 	}
+	h.opts = opts
+	return nil
+}
+
+// checkOrigin rejects cross-site upgrades to prevent WebSocket hijacking
+func (h *WebSocketHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return h.allowNoOrigin
+	}
+	if !h.origins.Allowed(origin) {
+		h.logger.Warn("websocket origin rejected", zap.String("origin", origin))
+		return false
+	}
+	return true
 }
 
 func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -850,11 +1790,39 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.logger.Error("websocket upgrade failed", zap.Error(err))
 		return
 	}
-	defer conn.Close()
+
+	client := &wsClient{
+		conn:          conn,
+		ctx:           r.Context(),
+		send:          make(chan []byte, h.opts.SendBuffer),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]struct{}),
+	}
 
 	clientID := fmt.Sprintf("%p", conn)
-	h.clients.Store(clientID, conn)
-	defer h.clients.Delete(clientID)
+	h.clients.Store(clientID, client)
+	atomic.AddInt64(&h.connections, 1)
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		h.writeLoop(client)
+	}()
+
+	defer func() {
+		client.close()
+		<-writerDone
+		h.clients.Delete(clientID)
+		client.mu.Lock()
+		atomic.AddInt64(&h.subscriptions, -int64(len(client.subscriptions)))
+		client.mu.Unlock()
+		atomic.AddInt64(&h.connections, -1)
+	}()
+
+	if h.graphql != nil && conn.Subprotocol() == GraphQLWSProtocol {
+		h.serveGraphQLWS(r.Context(), client)
+		return
+	}
 
 	for {
 		_, message, err := conn.ReadMessage()
@@ -862,31 +1830,167 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		var msg struct {
-			Type    string          `json:"type"`
-			Channel string          `json:"channel"`
-			Payload json.RawMessage `json:"payload"`
-		}
-
+		var msg wsMessage
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
 		}
 
 		switch msg.Type {
 		case "subscribe":
-			h.logger.Info("client subscribed", zap.String("channel", msg.Channel))
+			h.subscribe(client, msg.Channel)
 		case "unsubscribe":
-			h.logger.Info("client unsubscribed", zap.String("channel", msg.Channel))
+			h.unsubscribe(client, msg.Channel)
 		}
 	}
 }
 
+func (h *WebSocketHandler) subscribe(client *wsClient, channel string) {
+	if channel == "" {
+		h.reply(client, "error", channel, "channel is required")
+		return
+	}
+	if err := h.authorizeChannel(client, channel); err != nil {
+		h.reply(client, "error", channel, err.Error())
+		return
+	}
+
+	client.mu.Lock()
+	_, exists := client.subscriptions[channel]
+	if !exists && len(client.subscriptions) >= h.opts.MaxSubscriptions {
+		client.mu.Unlock()
+		h.reply(client, "error", channel, fmt.Sprintf("subscription limit of %d reached", h.opts.MaxSubscriptions))
+		return
+	}
+	if !exists {
+		client.subscriptions[channel] = struct{}{}
+		atomic.AddInt64(&h.subscriptions, 1)
+	}
+	client.mu.Unlock()
+
+	h.logger.Info("client subscribed", zap.String("channel", channel))
+	h.reply(client, "subscribed", channel, "")
+}
+
+func (h *WebSocketHandler) unsubscribe(client *wsClient, channel string) {
+	client.mu.Lock()
+	if _, ok := client.subscriptions[channel]; ok {
+		delete(client.subscriptions, channel)
+		atomic.AddInt64(&h.subscriptions, -1)
+	}
+	client.mu.Unlock()
+
+	h.logger.Info("client unsubscribed", zap.String("channel", channel))
+	h.reply(client, "unsubscribed", channel, "")
+}
+
+func (h *WebSocketHandler) reply(client *wsClient, msgType, channel, message string) {
+	msg := wsMessage{Type: msgType, Channel: channel}
+	if message != "" {
+		msg.Payload, _ = json.Marshal(map[string]string{"message": message})
+	}
+	data, _ := json.Marshal(msg)
+	h.enqueue(client, data)
+}
+
+// SetPermissions restricts channels to the tables the caller's role may
+// read in full, checked when subscribing. A change event's key would
+// reveal rows a row-level filter hides, so tables the role reads through
+// one are only available as GraphQL subscriptions, which apply the filter.
+func (h *WebSocketHandler) SetPermissions(permissions PermissionSource) {
+	h.permissions = permissions
+}
+
+// authorizeChannel returns why client may not receive the changes of the
+// table channel names, or nil
+func (h *WebSocketHandler) authorizeChannel(client *wsClient, channel string) error {
+	if h.permissions == nil {
+		return nil
+	}
+	filter, err := rowFilter(client.ctx, h.permissions, channel, requestClaims(client.ctx), auth.PermissionSelect)
+	if err != nil {
+		return err
+	}
+	if len(filter) > 0 {
+		return fmt.Errorf("rows of %s are filtered for your role; use a GraphQL subscription", channel)
+	}
+	return nil
+}
+
+// Broadcast publishes payload to every client subscribed to channel
+func (h *WebSocketHandler) Broadcast(channel string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(wsMessage{Type: "data", Channel: channel, Payload: body})
+	if err != nil {
+		return err
+	}
+
+	h.clients.Range(func(_, value interface{}) bool {
+		client := value.(*wsClient)
+		if client.subscribed(channel) {
+			h.enqueue(client, data)
+		}
+		return true
+	})
+	return nil
+}
+
+// enqueue never blocks: a full buffer means the client cannot keep up and
+// the configured slow client policy applies
+func (h *WebSocketHandler) enqueue(client *wsClient, data []byte) {
+	select {
+	case <-client.done:
+		return
+	default:
+	}
+
+	select {
+	case client.send <- data:
+	default:
+		atomic.AddInt64(&h.dropped, 1)
+		if h.opts.SlowClientPolicy == SlowClientDisconnect {
+			h.logger.Warn("disconnecting slow websocket client")
+			client.close()
+		}
+	}
+}
+
+// writeLoop drains the client's send queue, bounding every write with a
+// deadline so a dead socket cannot hold the goroutine forever
+func (h *WebSocketHandler) writeLoop(client *wsClient) {
+	for {
+		select {
+		case <-client.done:
+			return
+		case data := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(h.opts.WriteTimeout))
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				client.close()
+				return
+			}
+		}
+	}
+}
+
+// Stats returns the current connection and subscription counts
+func (h *WebSocketHandler) Stats() WebSocketStats {
+	return WebSocketStats{
+		Connections:   atomic.LoadInt64(&h.connections),
+		Subscriptions: atomic.LoadInt64(&h.subscriptions),
+		Dropped:       atomic.LoadInt64(&h.dropped),
+	}
+}
+
 // MCPHandler handles Model Context Protocol requests for LLM integration
 type MCPHandler struct {
-	db     *lumadb.Client
-	schema *Schema
-	logger *zap.Logger
-	tools  map[string]MCPTool
+	db          *lumadb.Client
+	schema      *Schema
+	logger      *zap.Logger
+	tools       map[string]MCPTool
+	permissions PermissionSource
+	listLimits  ListLimits
 }
 
 // MCPTool defines an MCP tool for LLM consumption
@@ -897,6 +2001,14 @@ type MCPTool struct {
 	Handler     func(context.Context, map[string]interface{}) (interface{}, error) `json:"-"`
 }
 
+// Tool errors answered with a client error status
+var (
+	// errInvalidToolInput is caused by the caller's input
+	errInvalidToolInput = errors.New("invalid input")
+	// errToolNotAllowed is the caller's role lacking the permission
+	errToolNotAllowed = errors.New("forbidden")
+)
+
 // NewMCPHandler creates a new MCP handler
 func NewMCPHandler(db *lumadb.Client, schema *Schema, logger *zap.Logger) *MCPHandler {
 	h := &MCPHandler{
@@ -914,6 +2026,21 @@ func NewMCPHandler(db *lumadb.Client, schema *Schema, logger *zap.Logger) *MCPHa
 	return h
 }
 
+// SetPermissions restricts tools to the tables and rows the caller's role
+// may select
+func (h *MCPHandler) SetPermissions(permissions PermissionSource) {
+	h.permissions = permissions
+}
+
+// SetListLimits sets the default and largest limit of list tools. Tools
+// are registered again so their input schemas show the new maximum.
+func (h *MCPHandler) SetListLimits(limits ListLimits) {
+	h.listLimits = limits
+	for _, table := range h.schema.Tables {
+		h.registerTableTools(table)
+	}
+}
+
 func (h *MCPHandler) registerTableTools(table TableSchema) {
 	tableName := table.Name
 
@@ -924,26 +2051,51 @@ func (h *MCPHandler) registerTableTools(table TableSchema) {
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"limit":  map[string]string{"type": "integer", "description": "Maximum records to return"},
-				"offset": map[string]string{"type": "integer", "description": "Number of records to skip"},
+				"filter":   filterSchema(table),
+				"order_by": map[string]string{"type": "string", "description": `Columns to sort by, as "column [asc|desc], ..."`},
+				"limit":    map[string]interface{}{"type": "integer", "description": "Maximum records to return", "minimum": 1, "maximum": h.listLimits.withDefaults().Max},
+				"offset":   map[string]interface{}{"type": "integer", "description": "Number of records to skip", "minimum": 0},
 			},
 		},
 		Handler: func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
-			limit := 100
-			if l, ok := input["limit"].(float64); ok {
-				limit = int(l)
-			}
+			return h.list(ctx, table, input)
+		},
+	}
 
-			query := fmt.Sprintf("SELECT * FROM %s LIMIT %d", tableName, limit)
-			rows, err := h.db.Query(ctx, query)
-			if err != nil {
-				return nil, err
-			}
-			defer rows.Close()
-			return scanRowsToMaps(rows)
+	// Count tool
+	h.tools["count_"+tableName] = MCPTool{
+		Name:        "count_" + tableName,
+		Description: fmt.Sprintf("Count %s records matching optional filters", tableName),
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"filter": filterSchema(table)},
+		},
+		Handler: func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+			return h.aggregate(ctx, table, map[string]interface{}{"filter": input["filter"]})
 		},
 	}
 
+	// Aggregate tool, for tables with numeric columns
+	if numeric := numericColumns(table); len(numeric) > 0 {
+		properties := map[string]interface{}{"filter": filterSchema(table)}
+		for _, fn := range aggregateFunctions {
+			properties[fn] = map[string]interface{}{
+				"type":        "array",
+				"description": fmt.Sprintf("Numeric columns to compute the %s of", fn),
+				"items":       map[string]interface{}{"type": "string", "enum": numeric},
+			}
+		}
+		h.tools["aggregate_"+tableName] = MCPTool{
+			Name: "aggregate_" + tableName,
+			Description: fmt.Sprintf("Count %s records matching optional filters and compute sum, avg, min or max "+
+				"of the numeric columns %s", tableName, strings.Join(numeric, ", ")),
+			InputSchema: map[string]interface{}{"type": "object", "properties": properties},
+			Handler: func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+				return h.aggregate(ctx, table, input)
+			},
+		}
+	}
+
 	// Get tool
 	h.tools["get_"+tableName] = MCPTool{
 		Name:        "get_" + tableName,
@@ -956,14 +2108,135 @@ func (h *MCPHandler) registerTableTools(table TableSchema) {
 			"required": []string{"id"},
 		},
 		Handler: func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
-			id := input["id"]
-			query := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", tableName, table.PrimaryKey)
-			row := h.db.QueryRow(ctx, query, id)
-			return scanRowToMap(row, nil)
+			id, err := primaryKeyValue(table, input["id"])
+			if err != nil {
+				return nil, err
+			}
+			// The caller's row filter hides the row as if it did not exist
+			where, args, err := h.where(ctx, table, nil)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, id)
+			condition := fmt.Sprintf("%s = $%d", table.ColumnRef(table.PrimaryKey), len(args))
+			if where == "" {
+				where = " WHERE " + condition
+			} else {
+				where += " AND " + condition
+			}
+			row, err := sessionQueryMap(ctx, h.db, "SELECT * FROM "+table.QualifiedName()+where, args...)
+			if err == nil && row == nil {
+				err = fmt.Errorf("no %s with id %v", tableName, id)
+			}
+			return row, err
 		},
 	}
 }
 
+// list runs a list tool: the rows matching the input's filter that the
+// caller may select, in order_by order
+func (h *MCPHandler) list(ctx context.Context, table TableSchema, input map[string]interface{}) ([]map[string]interface{}, error) {
+	// A limit that is not an integer is rejected as out of range
+	v, given := input["limit"]
+	requested := 0
+	if l, ok := v.(float64); ok && l == float64(int(l)) {
+		requested = int(l)
+	}
+	limit, err := h.listLimits.limit(requested, given)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidToolInput, err)
+	}
+	offset := 0
+	if v, ok := input["offset"]; ok {
+		o, ok := v.(float64)
+		if !ok || o != float64(int(o)) || o < 0 {
+			return nil, fmt.Errorf("%w: offset must be a non-negative integer", errInvalidToolInput)
+		}
+		offset = int(o)
+	}
+
+	where, args, err := h.where(ctx, table, input)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT * FROM " + table.QualifiedName() + where
+	if v, ok := input["order_by"]; ok && v != "" {
+		orderBy, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: order_by must be a string", errInvalidToolInput)
+		}
+		clause, err := orderByClause(table, orderBy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidToolInput, err)
+		}
+		query += clause
+	}
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := sessionQuery(ctx, h.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRowsToMaps(rows)
+}
+
+// where renders the caller's row filter and the input's filter as a WHERE
+// clause, or empty without either. Soft-deleted rows are always excluded;
+// tools have no way to ask for them.
+func (h *MCPHandler) where(ctx context.Context, table TableSchema, input map[string]interface{}) (string, []interface{}, error) {
+	rls, err := rowFilter(ctx, h.permissions, table.Name, requestClaims(ctx), auth.PermissionSelect)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errToolNotAllowed, err)
+	}
+	var conditions []string
+	if cond := liveRowsCondition(table); cond != "" {
+		conditions = append(conditions, cond)
+	}
+	condition, args := rlsCondition(table.Name, rls, requestClaims(ctx), nil)
+	if condition != "" {
+		conditions = append(conditions, condition)
+	}
+	if v, ok := input["filter"]; ok && v != nil {
+		filter, ok := v.(map[string]interface{})
+		if !ok {
+			return "", nil, fmt.Errorf("%w: filter must be an object", errInvalidToolInput)
+		}
+		if condition, args, err = filterCondition(table, filter, args); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", errInvalidToolInput, err)
+		}
+		if condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// filterSchema describes the filter tools accept on table
+func filterSchema(table TableSchema) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"description": "Conditions on columns, all of which must hold. A value tests equality; an object " +
+			"applies operators: eq, neq, gt, gte, lt, lte, like, ilike, in (array) and is_null (boolean), " +
+			`as in {"status": "failed", "created_at": {"gte": "2024-01-01"}}`,
+		"propertyNames": map[string]interface{}{"enum": columnNames(table)},
+	}
+}
+
+// columnNames lists the table's columns
+func columnNames(table TableSchema) []string {
+	names := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
 // Routes returns the MCP API routes
 func (h *MCPHandler) Routes() chi.Router {
 	r := chi.NewRouter()
@@ -987,19 +2260,27 @@ func (h *MCPHandler) Routes() chi.Router {
 		toolName := chi.URLParam(r, "name")
 		tool, ok := h.tools[toolName]
 		if !ok {
-			http.Error(w, "tool not found", http.StatusNotFound)
+			apierr.Write(w, r, "tool not found", http.StatusNotFound)
 			return
 		}
 
 		var input map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-			http.Error(w, "invalid input", http.StatusBadRequest)
+			apierr.Write(w, r, "invalid input", http.StatusBadRequest)
 			return
 		}
 
 		result, err := tool.Handler(r.Context(), input)
+		if errors.Is(err, errInvalidToolInput) {
+			apierr.Write(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errToolNotAllowed) {
+			apierr.Write(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierr.Write(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -1053,44 +2334,41 @@ func toPlural(s string) string {
 	return s + "s"
 }
 
-func scanRowToMap(row *sql.Row, cols []string) (map[string]interface{}, error) {
-	// This is a simplified implementation
-	// In production, use sqlx or implement proper column scanning
-	result := make(map[string]interface{})
-	// Implementation would scan row into result map
-	return result, nil
-}
-
-func scanRowsToMaps(rows *sql.Rows) ([]map[string]interface{}, error) {
-	cols, err := rows.Columns()
+// scanRowsToMaps reads rows into maps of stable JSON values
+func scanRowsToMaps(rows *lumadb.Rows) ([]map[string]interface{}, error) {
+	stream, err := newRowStream(rows)
 	if err != nil {
 		return nil, err
 	}
 
 	results := make([]map[string]interface{}, 0)
-
-	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columns {
-			columnPointers[i] = &columns[i]
-		}
-
-		if err := rows.Scan(columnPointers...); err != nil {
+	for {
+		m, err := stream.next()
+		if err != nil {
 			return nil, err
 		}
-
-		m := make(map[string]interface{})
-		for i, colName := range cols {
-			val := columns[i]
-			if b, ok := val.([]byte); ok {
-				m[colName] = string(b)
-			} else {
-				m[colName] = val
-			}
+		if m == nil {
+			return results, nil
 		}
 		results = append(results, m)
 	}
+}
 
-	return results, nil
+// jsonValue converts a value scanned by lumadb to the form the generated
+// APIs return: ISO-8601 times, a bare date for DATE columns, and JSON and
+// binary columns as strings
+func jsonValue(dbType string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if strings.EqualFold(dbType, "DATE") {
+			return v.Format("2006-01-02")
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(v)
+	case []byte:
+		return string(v)
+	default:
+		return v
+	}
 }
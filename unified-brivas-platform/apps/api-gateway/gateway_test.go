@@ -2,10 +2,36 @@
 package gateway
 
 import (
+	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	auth "github.com/brivas/unified-platform/packages/core"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+	"github.com/brivas/unified-platform/packages/lumadb-client/lumadbtest"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -34,6 +60,558 @@ func TestHealthCheck(t *testing.T) {
 	}
 }
 
+func TestLoadSchemaDetectsRelations(t *testing.T) {
+	text := func(columns ...string) *lumadbtest.Rows {
+		types := make([]string, len(columns))
+		for i := range types {
+			types[i] = "TEXT"
+		}
+		return &lumadbtest.Rows{Columns: columns, Types: types}
+	}
+
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.Contains(query, "information_schema.tables"):
+			rows := text("table_name")
+			rows.Data = [][]driver.Value{{"accounts"}, {"campaigns"}, {"profiles"}}
+			return rows, nil
+		case strings.Contains(query, "information_schema.columns"):
+			rows := text("column_name", "data_type", "is_nullable", "column_default")
+			rows.Data = [][]driver.Value{{"id", "uuid", "NO", nil}}
+			if args[0].Value != "accounts" {
+				rows.Data = append(rows.Data, []driver.Value{"account_id", "uuid", "NO", nil})
+			}
+			return rows, nil
+		case strings.Contains(query, "pg_indexes"):
+			rows := text("tablename", "indexname", "indexdef")
+			rows.Data = [][]driver.Value{
+				{"accounts", "accounts_pkey", "CREATE UNIQUE INDEX accounts_pkey ON public.accounts USING btree (id)"},
+				{"campaigns", "campaigns_account_id_idx", "CREATE INDEX campaigns_account_id_idx ON public.campaigns USING btree (account_id, created_at DESC)"},
+				{"profiles", "profiles_account_id_key", "CREATE UNIQUE INDEX profiles_account_id_key ON public.profiles USING btree (account_id)"},
+			}
+			return rows, nil
+		case strings.Contains(query, "FOREIGN KEY"):
+			rows := text("constraint_name", "table_name", "column_name", "target_table", "target_column")
+			rows.Data = [][]driver.Value{
+				{"campaigns_account_id_fkey", "campaigns", "account_id", "accounts", "id"},
+				{"profiles_account_id_fkey", "profiles", "account_id", "accounts", "id"},
+			}
+			return rows, nil
+		case strings.Contains(query, "indisprimary"):
+			rows := text("attname")
+			rows.Data = [][]driver.Value{{"id"}}
+			return rows, nil
+		}
+		return &lumadbtest.Rows{}, nil
+	})
+
+	engine := NewUnifiedAPIEngine(db, zap.NewNop())
+	if err := engine.LoadSchemaFromDB(context.Background()); err != nil {
+		t.Fatalf("LoadSchemaFromDB failed: %v", err)
+	}
+
+	tables := map[string]TableSchema{}
+	for _, table := range engine.schema.Tables {
+		tables[table.Name] = table
+	}
+
+	campaignIdx := tables["campaigns"].Indexes
+	if len(campaignIdx) != 1 || campaignIdx[0].Unique || len(campaignIdx[0].Columns) != 2 || campaignIdx[0].Columns[1] != "created_at" {
+		t.Errorf("Unexpected campaigns indexes: %+v", campaignIdx)
+	}
+
+	want := map[string][]Relation{
+		"accounts": {
+			{Name: "campaigns", Type: "one-to-many", TargetTable: "campaigns", ForeignKey: "id", TargetKey: "account_id"},
+			{Name: "profiles", Type: "one-to-one", TargetTable: "profiles", ForeignKey: "id", TargetKey: "account_id"},
+		},
+		"campaigns": {
+			{Name: "account", Type: "many-to-one", TargetTable: "accounts", ForeignKey: "account_id", TargetKey: "id"},
+		},
+		"profiles": {
+			{Name: "account", Type: "one-to-one", TargetTable: "accounts", ForeignKey: "account_id", TargetKey: "id"},
+		},
+	}
+	for name, relations := range want {
+		got := tables[name].Relations
+		if len(got) != len(relations) {
+			t.Errorf("%s: expected %d relations, got %+v", name, len(relations), got)
+			continue
+		}
+		for i := range relations {
+			if got[i] != relations[i] {
+				t.Errorf("%s: expected relation %+v, got %+v", name, relations[i], got[i])
+			}
+		}
+	}
+}
+
+func TestDatabaseSchemaQualifiesQueries(t *testing.T) {
+	var introspectArgs []interface{}
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.Contains(query, "information_schema.tables"):
+			introspectArgs = append(introspectArgs, args[0].Value)
+			return &lumadbtest.Rows{Columns: []string{"table_name"}, Types: []string{"TEXT"}, Data: [][]driver.Value{{"accounts"}}}, nil
+		case strings.Contains(query, "information_schema.columns"):
+			introspectArgs = append(introspectArgs, args[1].Value)
+			return &lumadbtest.Rows{
+				Columns: []string{"column_name", "data_type", "is_nullable", "column_default"},
+				Types:   []string{"TEXT", "TEXT", "TEXT", "TEXT"},
+				Data:    [][]driver.Value{{"id", "uuid", "NO", nil}},
+			}, nil
+		case strings.Contains(query, "indisprimary"):
+			introspectArgs = append(introspectArgs, args[0].Value)
+			return &lumadbtest.Rows{Columns: []string{"attname"}, Types: []string{"TEXT"}, Data: [][]driver.Value{{"id"}}}, nil
+		}
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"TEXT"}, Data: [][]driver.Value{{"acc_1"}}}, nil
+	})
+
+	engine := NewUnifiedAPIEngine(db, zap.NewNop())
+	engine.SetDatabaseSchema("tenant_x")
+	if err := engine.LoadSchemaFromDB(context.Background()); err != nil {
+		t.Fatalf("LoadSchemaFromDB failed: %v", err)
+	}
+
+	want := []interface{}{"tenant_x", "tenant_x", `"tenant_x"."accounts"`}
+	if len(introspectArgs) != len(want) {
+		t.Fatalf("Expected introspection args %v, got %v", want, introspectArgs)
+	}
+	for i := range want {
+		if introspectArgs[i] != want[i] {
+			t.Errorf("Expected introspection args %v, got %v", want, introspectArgs)
+		}
+	}
+	if table := engine.schema.Tables[0]; table.Schema != "tenant_x" || table.QualifiedName() != `"tenant_x"."accounts"` {
+		t.Errorf("Unexpected table schema: %+v", table)
+	}
+
+	router := NewRESTHandler(db, engine.schema, zap.NewNop()).Routes()
+	for _, path := range []string{"/accounts", "/accounts/acc_1"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		last := fake.LastQuery()
+		if !strings.Contains(last, `FROM "tenant_x"."accounts"`) {
+			t.Errorf("GET %s: expected a schema-qualified query, got %q", path, last)
+		}
+	}
+}
+
+func TestReservedWordIdentifiers(t *testing.T) {
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		return &lumadbtest.Rows{Columns: []string{"id", "user"}, Types: []string{"TEXT", "TEXT"}, Data: [][]driver.Value{{"ord_1", "acc_1"}}}, nil
+	})
+	order := TableSchema{Name: "order", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "text"}, {Name: "user", Type: "text"}}}
+	schema := &Schema{Tables: []TableSchema{order}}
+	lastQuery := func() string { return fake.LastQuery() }
+
+	router := NewRESTHandler(db, schema, zap.NewNop()).Routes()
+	send := func(method, path, body string) int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr.Code
+	}
+
+	if code := send("GET", "/order/ord_1", ""); code != http.StatusOK {
+		t.Fatalf("Expected 200 for a get, got %d", code)
+	}
+	if want := `SELECT * FROM "order" WHERE "order"."id" = $1`; lastQuery() != want {
+		t.Errorf("Unexpected REST get query:\n got %s\nwant %s", lastQuery(), want)
+	}
+	if code := send("POST", "/order", `{"user": "acc_1"}`); code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a create, got %d", code)
+	}
+	if want := `INSERT INTO "order" ("user") VALUES ($1) RETURNING "id", "user"`; lastQuery() != want {
+		t.Errorf("Unexpected REST insert query:\n got %s\nwant %s", lastQuery(), want)
+	}
+	if code := send("PATCH", "/order/ord_1", `{"user": "acc_2"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 for an update, got %d", code)
+	}
+	if want := `UPDATE "order" SET "user" = $1 WHERE "order"."id" = $2 RETURNING "id", "user"`; lastQuery() != want {
+		t.Errorf("Unexpected REST update query:\n got %s\nwant %s", lastQuery(), want)
+	}
+
+	queries := len(fake.Queries())
+	for _, body := range []string{`{"user; DROP TABLE accounts": "x"}`, `{"nope": 1}`} {
+		if code := send("POST", "/order", body); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for unknown column in %s, got %d", body, code)
+		}
+	}
+	if len(fake.Queries()) != queries {
+		t.Errorf("Expected no query for unknown columns, got %v", fake.Queries()[queries:])
+	}
+
+	gql := NewGraphQLHandler(db, schema, zap.NewNop())
+	params := graphql.ResolveParams{Context: context.Background(), Args: map[string]interface{}{"id": "ord_1", "_set": `{"user": "acc_3"}`}}
+	if _, err := gql.resolveUpdate(order)(params); err != nil {
+		t.Fatalf("GraphQL update failed: %v", err)
+	}
+	if want := `UPDATE "order" SET "user" = $1 WHERE "order"."id" = $2 RETURNING "id"`; lastQuery() != want {
+		t.Errorf("Unexpected GraphQL update query:\n got %s\nwant %s", lastQuery(), want)
+	}
+	params.Args = map[string]interface{}{"object": `{"select": 1}`}
+	if _, err := gql.resolveInsert(order)(params); err == nil || !strings.Contains(err.Error(), "unknown column") {
+		t.Errorf("Expected GraphQL insert to reject an unknown column, got %v", err)
+	}
+
+	mcp := NewMCPHandler(db, schema, zap.NewNop())
+	if _, err := mcp.tools["get_order"].Handler(context.Background(), map[string]interface{}{"id": "ord_1"}); err != nil {
+		t.Fatalf("MCP get failed: %v", err)
+	}
+	if want := `SELECT * FROM "order" WHERE "order"."id" = $1`; lastQuery() != want {
+		t.Errorf("Unexpected MCP get query:\n got %s\nwant %s", lastQuery(), want)
+	}
+}
+
+// hiddenColumns is a FieldAuthorizer hiding the listed columns from users
+type hiddenColumns []string
+
+func (h hiddenColumns) CanSelectColumn(ctx context.Context, table string, role auth.Role, column string) bool {
+	return role != auth.RoleUser || !contains(h, column)
+}
+
+func TestMutationReturning(t *testing.T) {
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		// Return the columns listed after RETURNING, as the database would
+		i := strings.LastIndex(query, " RETURNING ")
+		rows := &lumadbtest.Rows{}
+		var row []driver.Value
+		for _, col := range strings.Split(query[i+len(" RETURNING "):], ", ") {
+			rows.Columns = append(rows.Columns, strings.Trim(col, `"`))
+			rows.Types = append(rows.Types, "VARCHAR")
+			row = append(row, "v_"+strings.Trim(col, `"`))
+		}
+		rows.Data = [][]driver.Value{row}
+		return rows, nil
+	})
+	accounts := TableSchema{Name: "accounts", PrimaryKey: "id", Columns: []Column{
+		{Name: "id", Type: "varchar"}, {Name: "email", Type: "varchar"}, {Name: "password_hash", Type: "varchar"},
+	}}
+	schema := &Schema{Tables: []TableSchema{accounts}}
+	lastQuery := func() string { return fake.LastQuery() }
+	user := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+
+	rest := NewRESTHandler(db, schema, zap.NewNop())
+	rest.SetFieldAuthorizer(hiddenColumns{"password_hash"})
+	router := rest.Routes()
+	send := func(method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "claims", user))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var result map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr.Code, result
+	}
+
+	// The default projection leaves out what the role cannot read
+	code, row := send("POST", "/accounts", `{"email": "a@b.c", "password_hash": "x"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if !strings.HasSuffix(lastQuery(), `RETURNING "id", "email"`) || row["password_hash"] != nil || row["email"] != "v_email" {
+		t.Errorf("Expected the hidden column not returned, got %s and %v", lastQuery(), row)
+	}
+
+	// The key is read for change notifications but not returned unless asked
+	code, row = send("POST", "/accounts?returning=email", `{"email": "a@b.c"}`)
+	if code != http.StatusCreated || !strings.HasSuffix(lastQuery(), `RETURNING "email", "id"`) {
+		t.Errorf("Unexpected insert: %d, %s", code, lastQuery())
+	}
+	if _, ok := row["id"]; ok || row["email"] != "v_email" {
+		t.Errorf("Expected only email returned, got %v", row)
+	}
+
+	send("PATCH", "/accounts/acc_1?returning=id,password_hash", `{"email": "b@b.c"}`)
+	if !strings.HasSuffix(lastQuery(), `RETURNING "id"`) {
+		t.Errorf("Expected the hidden column intersected away, got %s", lastQuery())
+	}
+	if code, _ := send("DELETE", "/accounts/acc_1?returning=password_hash", ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with no readable column to return, got %d", code)
+	}
+	if code, _ := send("DELETE", "/accounts/acc_1?returning=nope", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown column, got %d", code)
+	}
+
+	// GraphQL returns what the selection set asks for
+	gql := NewGraphQLHandler(db, schema, zap.NewNop())
+	gql.SetFieldAuthorizer(hiddenColumns{"password_hash"})
+	ctx := context.WithValue(context.Background(), "claims", user)
+	result := gql.execute(ctx, graphQLRequest{Query: `mutation { update_accounts(id: "acc_1", _set: "{\"email\": \"c@b.c\"}") { email } }`})
+	if result.HasErrors() {
+		t.Fatalf("Mutation failed: %v", result.Errors)
+	}
+	if !strings.HasSuffix(lastQuery(), `RETURNING "email", "id"`) {
+		t.Errorf("Expected the selection set returned, got %s", lastQuery())
+	}
+}
+
+func TestGraphQLSchemaReload(t *testing.T) {
+	original := wideSchema(3)
+	handler := NewGraphQLHandler(nil, original, zap.NewNop())
+	served := handler.currentSchema()
+
+	if handler.SetSchema(wideSchema(3)) || handler.currentSchema() != served {
+		t.Error("Expected an identical schema not to be rebuilt")
+	}
+
+	changed := wideSchema(3)
+	changed.Tables[1].Columns = append(changed.Tables[1].Columns, Column{Name: "status", Type: "text"})
+	if changed.Fingerprint() == original.Fingerprint() {
+		t.Fatal("Expected a new column to change the fingerprint")
+	}
+	if !handler.SetSchema(changed) {
+		t.Fatal("Expected a changed schema to be rebuilt")
+	}
+	if typ, ok := handler.currentSchema().Type("Table001").(*graphql.Object); !ok || typ.Fields()["status"] == nil {
+		t.Error("Expected the new column in the served schema")
+	}
+
+	// Going back reuses the schema compiled for the original fingerprint
+	if !handler.SetSchema(original) || handler.currentSchema() != served {
+		t.Error("Expected the cached schema for the original fingerprint")
+	}
+
+	// Soft delete configuration is part of what generates the API
+	soft := wideSchema(3)
+	soft.Tables[0].SoftDeleteColumn = "column_1"
+	if soft.Fingerprint() == original.Fingerprint() {
+		t.Error("Expected soft delete configuration to change the fingerprint")
+	}
+
+	engine := NewUnifiedAPIEngine(nil, zap.NewNop())
+	req := httptest.NewRequest("POST", "/admin/schema/reload", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "claims", &auth.Claims{Role: auth.RoleUser}))
+	rr := httptest.NewRecorder()
+	engine.reloadSchema(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a user, got %d", rr.Code)
+	}
+}
+
+func TestGenerateGoClient(t *testing.T) {
+	schema := &Schema{Tables: []TableSchema{
+		{Name: "accounts", PrimaryKey: "id", Columns: []Column{
+			{Name: "id", Type: "bigint"},
+			{Name: "email", Type: "varchar"},
+			{Name: "balance", Type: "numeric", Nullable: true},
+			{Name: "settings", Type: "jsonb", Nullable: true},
+		}},
+		{Name: "sender_ids", PrimaryKey: "sender_id", Columns: []Column{{Name: "sender_id", Type: "varchar"}, {Name: "approved", Type: "boolean"}}},
+		{Name: "sms_history", Columns: []Column{{Name: "message", Type: "text"}}},
+	}}
+	handler := NewRESTHandler(nil, schema, zap.NewNop())
+
+	src, err := handler.GenerateGoClient("brivas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"type Account struct",
+		"Balance  *float64         `json:\"balance,omitempty\"`",
+		"func (c *Client) ListAccounts(ctx context.Context, opts *ListOptions) (*AccountsList, error)",
+		"func (c *Client) GetAccount(ctx context.Context, id int64) (*Account, error)",
+		"func (c *Client) UpdateSenderID(ctx context.Context, id string, in *SenderID) (*SenderID, error)",
+		"func (c *Client) ListSMSHistory(",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("Generated client lacks %q", want)
+		}
+	}
+	if strings.Contains(string(src), "GetSMSHistory") {
+		t.Error("Expected no Get for a table without a primary key")
+	}
+
+	// The client type checks
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "client.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("brivas", fset, []*ast.File{file}, nil); err != nil {
+		t.Errorf("Generated client does not compile: %v\n%s", err, src)
+	}
+
+	if _, err := handler.GenerateGoClient("func"); err == nil {
+		t.Error("Expected a keyword package name to be rejected")
+	}
+	handler.schema = &Schema{Tables: []TableSchema{{Name: "account"}, {Name: "accounts"}}}
+	if _, err := handler.GenerateGoClient("brivas"); err == nil {
+		t.Error("Expected colliding type names to be rejected")
+	}
+}
+
+func TestSchemaIntrospection(t *testing.T) {
+	engine := &UnifiedAPIEngine{
+		schema: &Schema{
+			Tables: []TableSchema{
+				{
+					Name:       "accounts",
+					PrimaryKey: "id",
+					Columns:    []Column{{Name: "id", Type: "uuid"}, {Name: "email", Type: "varchar", Nullable: true}},
+					Indexes:    []Index{{Name: "accounts_email_key", Columns: []string{"email"}, Unique: true}},
+				},
+			},
+		},
+	}
+
+	request := func(role auth.Role) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin/schema", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "claims", &auth.Claims{Role: role}))
+		rr := httptest.NewRecorder()
+		engine.schemaIntrospection(rr, req)
+		return rr
+	}
+
+	if rr := request(auth.RoleUser); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a user, got %d", rr.Code)
+	}
+
+	rr := request(auth.RoleAdmin)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for an admin, got %d", rr.Code)
+	}
+	var schema struct {
+		Schema
+		Warnings []SchemaWarning `json:"warnings"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("Invalid schema JSON: %v", err)
+	}
+	if len(schema.Tables) != 1 || !schema.Tables[0].Columns[1].Nullable || !schema.Tables[0].Indexes[0].Unique {
+		t.Errorf("Unexpected schema: %s", rr.Body.String())
+	}
+	if schema.Warnings == nil || len(schema.Warnings) != 0 {
+		t.Errorf("Expected an empty warnings list, got %s", rr.Body.String())
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	engine := NewUnifiedAPIEngine(nil, zap.NewNop())
+	engine.schema = &Schema{
+		Tables: []TableSchema{
+			{
+				Name:       "campaign",
+				PrimaryKey: "id",
+				Columns: []Column{
+					{Name: "id", Type: "uuid"},
+					{Name: "order", Type: "integer"},
+					{Name: "tags", Type: "ARRAY"},
+					{Name: "sent_count", Type: "bigint"},
+					{Name: "account_id", Type: "uuid"},
+					{Name: "accountId", Type: "uuid"},
+				},
+			},
+			{Name: "campaigns", PrimaryKey: "id", PrimaryKeyInferred: true, Columns: []Column{{Name: "id", Type: "text"}}},
+			{Name: "events", PrimaryKey: "id", PrimaryKeyInferred: true, Columns: []Column{{Name: "payload", Type: "jsonb"}}},
+			{Name: "query", PrimaryKey: "key", Columns: []Column{{Name: "2fa", Type: "text"}}},
+		},
+	}
+
+	type finding struct{ severity, code, table, column string }
+	got := map[finding]bool{}
+	for _, w := range engine.ValidateSchema() {
+		got[finding{w.Severity, w.Code, w.Table, w.Column}] = true
+	}
+	want := []finding{
+		{SeverityWarning, WarningUnmappedType, "campaign", "tags"},
+		{SeverityWarning, WarningUnmappedType, "campaign", "sent_count"},
+		{SeverityError, WarningNameCollision, "campaign", "accountId"},
+		{SeverityWarning, WarningInferredPrimaryKey, "campaigns", "id"},
+		{SeverityError, WarningNameCollision, "campaigns", ""},
+		{SeverityError, WarningMissingPrimaryKey, "events", ""},
+		{SeverityError, WarningInvalidName, "query", "2fa"},
+		{SeverityError, WarningMissingPrimaryKey, "query", "key"},
+	}
+	for _, f := range want {
+		if !got[f] {
+			t.Errorf("Missing finding %+v", f)
+		}
+		delete(got, f)
+	}
+	// Table "query" is also reported for clashing with the Query root type
+	delete(got, finding{SeverityError, WarningNameCollision, "query", ""})
+	for f := range got {
+		t.Errorf("Unexpected finding %+v", f)
+	}
+
+	if err := engine.GenerateAPIs(&Config{StrictSchema: true}); err == nil || !strings.Contains(err.Error(), "schema has") {
+		t.Errorf("Expected strict generation to refuse the schema, got %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	engine := &UnifiedAPIEngine{
+		schema: &Schema{
+			Name: "public",
+			Tables: []TableSchema{
+				{
+					Name:       "accounts",
+					PrimaryKey: "id",
+					Columns:    []Column{{Name: "id", Type: "varchar"}, {Name: "email", Type: "varchar"}, {Name: "password", Type: "varchar"}},
+				},
+				{
+					Name:       "sms_history",
+					PrimaryKey: "id",
+					Columns:    []Column{{Name: "id", Type: "bigint"}, {Name: "account_id", Type: "varchar"}},
+					Relations:  []Relation{{Name: "account", Type: "many-to-one", TargetTable: "accounts", ForeignKey: "account_id", TargetKey: "id"}},
+				},
+				{Name: "api_keys", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "uuid"}}},
+			},
+		},
+		graphqlAPI:  &GraphQLHandler{},
+		restAPI:     &RESTHandler{},
+		permissions: auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()),
+	}
+
+	request := func(claims *auth.Claims) Metadata {
+		req := httptest.NewRequest("GET", "/v1/metadata", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		rr := httptest.NewRecorder()
+		engine.metadata(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+		var md Metadata
+		if err := json.Unmarshal(rr.Body.Bytes(), &md); err != nil {
+			t.Fatalf("Invalid metadata JSON: %v", err)
+		}
+		return md
+	}
+
+	md := request(&auth.Claims{AccountID: "acc_1", Role: auth.RoleUser})
+	if md.Role != auth.RoleUser || len(md.Tables) != 2 {
+		t.Fatalf("Expected accounts and sms_history for a user, got %+v", md)
+	}
+	accounts, history := md.Tables[0], md.Tables[1]
+	if len(accounts.Columns) != 2 || accounts.Columns[1].Name != "email" {
+		t.Errorf("Expected only the readable accounts columns, got %+v", accounts.Columns)
+	}
+	if _, ok := accounts.Permissions["delete"]; ok || accounts.Permissions["update"].Filter["id"] != "X-Account-ID" {
+		t.Errorf("Unexpected accounts permissions: %+v", accounts.Permissions)
+	}
+	if fmt.Sprint(accounts.GraphQL.Mutations) != "[update_accounts]" {
+		t.Errorf("Expected only the update mutation, got %v", accounts.GraphQL.Mutations)
+	}
+	if fmt.Sprint(history.GraphQL.Queries) != "[smsHistory smsHistories]" || len(history.REST) != 4 {
+		t.Errorf("Unexpected sms_history operations: %+v %+v", history.GraphQL, history.REST)
+	}
+	if len(history.Relationships) != 1 || history.Relationships[0].TargetTable != "accounts" {
+		t.Errorf("Expected the account relationship, got %+v", history.Relationships)
+	}
+
+	// API key scopes narrow the role's operations further
+	md = request(&auth.Claims{AccountID: "acc_1", Role: auth.RoleUser, Permissions: []string{"sms_history:select"}})
+	if len(md.Tables) != 1 || len(md.Tables[0].Permissions) != 1 || md.Tables[0].Relationships != nil {
+		t.Errorf("Expected read-only sms_history without relationships, got %+v", md.Tables)
+	}
+
+	if md := request(&auth.Claims{Role: auth.RoleAnonymous}); len(md.Tables) != 0 {
+		t.Errorf("Expected no tables for an anonymous caller, got %+v", md.Tables)
+	}
+}
+
 func TestReadinessCheck(t *testing.T) {
 	// Test with no schema
 	engine := &UnifiedAPIEngine{}
@@ -62,6 +640,55 @@ func TestReadinessCheck(t *testing.T) {
 	}
 }
 
+func TestHealthComponents(t *testing.T) {
+	engine := &UnifiedAPIEngine{schema: &Schema{Tables: []TableSchema{{Name: "accounts", PrimaryKey: "id"}}}}
+	var workersErr, llmErr error
+	probed := 0
+	engine.RegisterHealthCheck("sms_workers", HealthCheckFunc(func(ctx context.Context) error { return workersErr }), true)
+	engine.RegisterHealthCheck("llm", HealthCheckFunc(func(ctx context.Context) error {
+		probed++
+		return llmErr
+	}), false)
+
+	get := func(handler http.HandlerFunc, target string) (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", target, nil))
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response
+	}
+
+	// Liveness runs no checks
+	if code, resp := get(engine.healthCheck, "/health"); code != http.StatusOK || resp["components"] != nil || probed != 0 {
+		t.Errorf("Expected a cheap liveness response, got %d %v after %d probes", code, resp, probed)
+	}
+
+	llmErr = errors.New("no provider reachable")
+	code, resp := get(engine.healthCheck, "/health?detail=true")
+	llm, _ := resp["components"].(map[string]interface{})["llm"].(map[string]interface{})
+	if code != http.StatusOK || resp["status"] != "degraded" || llm["status"] != ComponentDown || llm["error"] != "no provider reachable" {
+		t.Errorf("Expected degraded with the LLM down, got %d %v", code, resp)
+	}
+
+	// Readiness only runs critical checks, which are all up
+	probed = 0
+	if code, resp := get(engine.readinessCheck, "/ready"); code != http.StatusOK || probed != 0 {
+		t.Errorf("Expected ready, got %d %v after %d probes", code, resp, probed)
+	}
+
+	workersErr = errors.New("dlr_flusher last ran 5m0s ago")
+	if code, resp := get(engine.healthCheck, "/health?detail=true"); code != http.StatusServiceUnavailable || resp["status"] != "unhealthy" {
+		t.Errorf("Expected unhealthy with a critical subsystem down, got %d %v", code, resp)
+	}
+	code, resp = get(engine.readinessCheck, "/ready")
+	if down, _ := resp["down"].([]interface{}); code != http.StatusServiceUnavailable || len(down) != 1 || down[0] != "sms_workers" {
+		t.Errorf("Expected not ready with sms_workers down, got %d %v", code, resp)
+	}
+	if code, _ := get(engine.healthCheck, "/health"); code != http.StatusOK {
+		t.Errorf("Expected liveness to pass regardless, got %d", code)
+	}
+}
+
 func TestToCamelCase(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -138,79 +765,2401 @@ func TestMapSQLTypeToGraphQL(t *testing.T) {
 	}
 }
 
-func TestRESTHandlerListValidation(t *testing.T) {
-	handler := &RESTHandler{
-		schema: &Schema{
-			Tables: []TableSchema{
-				{Name: "accounts", PrimaryKey: "id"},
-			},
-		},
-	}
-
-	req := httptest.NewRequest("GET", "/accounts", nil)
-	rr := httptest.NewRecorder()
+func TestColumnScanner(t *testing.T) {
+	ts := time.Date(2024, 3, 15, 10, 30, 0, 0, time.FixedZone("WAT", 3600))
 
-	// This would normally require a DB connection
-	// Testing handler setup logic
-	routes := handler.Routes()
-	if routes == nil {
-		t.Error("Routes should not be nil")
+	tests := []struct {
+		dbType   string
+		src      interface{}
+		expected interface{}
+	}{
+		{"INT4", int64(42), int64(42)},
+		{"INT8", nil, nil},
+		{"FLOAT8", float64(2.5), float64(2.5)},
+		{"NUMERIC", []byte("1234.50"), float64(1234.5)},
+		{"NUMERIC", nil, nil},
+		{"BOOL", true, true},
+		{"BOOL", nil, nil},
+		{"TIMESTAMP", ts, "2024-03-15T09:30:00Z"},
+		{"TIMESTAMPTZ", nil, nil},
+		{"DATE", ts, "2024-03-15"},
+		{"DATE", nil, nil},
+		{"TIME", []byte("10:30:00"), "10:30:00"},
+		{"VARCHAR", []byte("BRIVAS"), "BRIVAS"},
+		{"TEXT", "hello", "hello"},
+		{"JSONB", []byte(`{"a":1}`), `{"a":1}`},
+		{"VARCHAR", nil, nil},
 	}
-}
 
-func TestGraphQLQueryParsing(t *testing.T) {
-	// Test GraphQL query parsing
-	query := `{
-		accounts(limit: 10) {
-			id
-			email
+	for _, tc := range tests {
+		dest, value := lumadb.ColumnScanner(tc.dbType)
+		if err := dest.Scan(tc.src); err != nil {
+			t.Errorf("%s: Scan(%v) failed: %v", tc.dbType, tc.src, err)
+			continue
+		}
+		if result := jsonValue(tc.dbType, value()); result != tc.expected {
+			t.Errorf("%s: converted %v to %#v, expected %#v", tc.dbType, tc.src, result, tc.expected)
 		}
-	}`
-
-	if len(query) == 0 {
-		t.Error("Query should not be empty")
 	}
 }
 
-func TestMCPToolRegistration(t *testing.T) {
-	handler := &MCPHandler{
-		tools: make(map[string]MCPTool),
-		schema: &Schema{
-			Tables: []TableSchema{
-				{Name: "accounts", PrimaryKey: "id"},
-				{Name: "campaigns", PrimaryKey: "id"},
-			},
+func TestBulkUpsertPlanning(t *testing.T) {
+	accounts := TableSchema{
+		Name:       "accounts",
+		PrimaryKey: "id",
+		Columns: []Column{
+			{Name: "id", Type: "varchar"},
+			{Name: "first_name", Type: "varchar"},
+			{Name: "balance", Type: "numeric"},
 		},
 	}
+	authz := auth.NewAuthorizationEngine(nil, "secret", zap.NewNop())
 
-	// Register tools for tables
-	for _, table := range handler.schema.Tables {
-		handler.registerTableTools(table)
+	// Without a permission source every row is upserted
+	planner, err := newBulkPlanner(context.Background(), accounts, nil, &auth.Claims{Role: auth.RoleAnonymous})
+	if err != nil {
+		t.Fatalf("newBulkPlanner failed: %v", err)
+	}
+	stmt, err := planner.plan(0, map[string]interface{}{"id": "acc_1", "first_name": "Ada", "balance": 10})
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	wantUpsert := `INSERT INTO "accounts" ("balance", "first_name", "id") VALUES ($1, $2, $3) ON CONFLICT ("id") DO UPDATE SET "balance" = EXCLUDED."balance", "first_name" = EXCLUDED."first_name" RETURNING "id", "first_name", "balance", (xmax = 0) AS _inserted`
+	if stmt.query != wantUpsert {
+		t.Errorf("Unexpected upsert SQL:\n got %s\nwant %s", stmt.query, wantUpsert)
 	}
 
-	// Verify tools were registered
-	if len(handler.tools) < 4 { // At least 2 tools per table (list, get)
-		t.Errorf("Expected at least 4 tools, got %d", len(handler.tools))
+	for name, row := range map[string]map[string]interface{}{
+		"missing primary key": {"first_name": "Ada"},
+		"unknown column":      {"id": "acc_1", "password": "x"},
+		"nothing to set":      {"id": "acc_1"},
+	} {
+		if _, err := planner.plan(0, row); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
 	}
 
-	// Check specific tool exists
-	if _, ok := handler.tools["list_accounts"]; !ok {
-		t.Error("list_accounts tool should exist")
+	// Users may update but not insert accounts, only their own and only some columns
+	planner, err = newBulkPlanner(context.Background(), accounts, authz, &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser})
+	if err != nil {
+		t.Fatalf("newBulkPlanner failed: %v", err)
 	}
-	if _, ok := handler.tools["get_campaigns"]; !ok {
-		t.Error("get_campaigns tool should exist")
+	stmt, err = planner.plan(0, map[string]interface{}{"id": "acc_2", "first_name": "Ada"})
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	wantUpdate := `UPDATE "accounts" SET "first_name" = $1 WHERE "accounts"."id" = $2 AND "accounts"."id" = $3 RETURNING "id", "first_name", "balance"`
+	if stmt.query != wantUpdate {
+		t.Errorf("Unexpected update SQL:\n got %s\nwant %s", stmt.query, wantUpdate)
+	}
+	if len(stmt.args) != 3 || stmt.args[1] != "acc_2" || stmt.args[2] != "acc_1" {
+		t.Errorf("Expected filter bound to the caller's account, got %v", stmt.args)
+	}
+	if _, err := planner.plan(0, map[string]interface{}{"id": "acc_1", "balance": 1000}); err == nil {
+		t.Error("Expected balance to be rejected for users")
 	}
-}
 
-// Benchmark tests
-func BenchmarkToCamelCase(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		toCamelCase("account_id_test_value")
+	if _, err := newBulkPlanner(context.Background(), accounts, authz, &auth.Claims{Role: auth.RoleAnonymous}); err == nil {
+		t.Error("Expected anonymous bulk updates to be forbidden")
+	}
+
+	// Upserts insert as the insert permission allows: its presets apply,
+	// and a key scoped to updates only gets plain updates
+	campaigns := TableSchema{
+		Name:       "campaigns",
+		PrimaryKey: "id",
+		Columns:    []Column{{Name: "id", Type: "varchar"}, {Name: "account_id", Type: "varchar"}, {Name: "name", Type: "varchar"}},
+	}
+	user := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+	planner, _ = newBulkPlanner(context.Background(), campaigns, authz, user)
+	stmt, err = planner.plan(0, map[string]interface{}{"id": "cmp_1", "name": "launch", "account_id": "acc_2"})
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if !strings.HasPrefix(stmt.query, "INSERT") || stmt.args[0] != "acc_1" {
+		t.Errorf("Expected an upsert preset to the caller's account, got %s %v", stmt.query, stmt.args)
+	}
+	scoped := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser, Permissions: []string{"campaigns:update"}}
+	planner, _ = newBulkPlanner(context.Background(), campaigns, authz, scoped)
+	if stmt, err = planner.plan(0, map[string]interface{}{"id": "cmp_1", "name": "launch"}); err != nil || !strings.HasPrefix(stmt.query, "UPDATE") {
+		t.Errorf("Expected a plain update for a key without insert scope, got %v %v", stmt, err)
+	}
+
+	restricted := auth.NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	restricted.ReloadPermissions([]*auth.TablePermission{{
+		Role:   auth.RoleUser,
+		Table:  "campaigns",
+		Insert: &auth.InsertPermission{Allowed: true, Columns: []string{"id", "name", "account_id"}, Check: map[string]string{"account_id": "X-Account-ID"}},
+		Update: &auth.UpdatePermission{Allowed: true},
+	}})
+	planner, _ = newBulkPlanner(context.Background(), campaigns, restricted, user)
+	if _, err := planner.plan(0, map[string]interface{}{"id": "cmp_1", "name": "launch", "account_id": "acc_2"}); err == nil {
+		t.Error("Expected a row failing the insert check to be rejected")
+	}
+	if _, err := planner.plan(0, map[string]interface{}{"id": "cmp_1", "name": "launch", "account_id": "acc_1"}); err != nil {
+		t.Errorf("Expected a row passing the insert check to be planned: %v", err)
+	}
+	restricted.ReloadPermissions([]*auth.TablePermission{{
+		Role:   auth.RoleUser,
+		Table:  "campaigns",
+		Insert: &auth.InsertPermission{Allowed: true, Columns: []string{"name"}},
+		Update: &auth.UpdatePermission{Allowed: true},
+	}})
+	planner, _ = newBulkPlanner(context.Background(), campaigns, restricted, user)
+	if _, err := planner.plan(0, map[string]interface{}{"id": "cmp_1", "name": "launch"}); err == nil {
+		t.Error("Expected a column outside the insert permission's to be rejected")
 	}
 }
 
-func BenchmarkToPascalCase(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		toPascalCase("account_id_test_value")
+func TestRESTHandlerBulkUpsertValidation(t *testing.T) {
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "email", Type: "varchar"}}},
+		},
+	}
+	handler := NewRESTHandler(nil, schema, zap.NewNop())
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	router := handler.Routes()
+
+	req := httptest.NewRequest("PUT", "/accounts/bulk", strings.NewReader(`[{"id": "acc_1", "email": "a@b.c"}]`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for anonymous caller, got %d", rr.Code)
 	}
+
+	// Invalid rows are reported individually without touching the database
+	handler.SetPermissions(nil)
+	req = httptest.NewRequest("PUT", "/accounts/bulk", strings.NewReader(`[{"email": "a@b.c"}, {"id": "acc_1", "nope": 1}]`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	var body struct {
+		Failed  int          `json:"failed"`
+		Results []bulkResult `json:"results"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if body.Failed != 2 || len(body.Results) != 2 || body.Results[1].Index != 1 {
+		t.Errorf("Expected two per-row errors, got %s", rr.Body.String())
+	}
+}
+
+func TestRESTHandlerUpdateDeleteAffectedRows(t *testing.T) {
+	// Only acc_1 exists and it belongs to account acc_1
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		rows := &lumadbtest.Rows{Columns: []string{"id", "email"}, Types: []string{"VARCHAR", "VARCHAR"}}
+		for _, arg := range args {
+			if arg.Value == "acc_missing" || arg.Value == "acc_2" {
+				return rows, nil
+			}
+		}
+		rows.Data = [][]driver.Value{{"acc_1", "a@b.c"}}
+		return rows, nil
+	})
+
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "email", Type: "varchar"}}},
+		},
+	}
+	handler := NewRESTHandler(db, schema, zap.NewNop())
+	router := handler.Routes()
+
+	send := func(method, path, body string, claims *auth.Claims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("PATCH", "/accounts/acc_1", `{"email": "a@b.c"}`, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a matching update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		AffectedRows int                      `json:"affected_rows"`
+		Returning    []map[string]interface{} `json:"returning"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if body.AffectedRows != 1 || len(body.Returning) != 1 || body.Returning[0]["email"] != "a@b.c" {
+		t.Errorf("Expected the affected row in the body, got %s", rr.Body.String())
+	}
+
+	if rr := send("DELETE", "/accounts/acc_1", "", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a matching delete, got %d", rr.Code)
+	}
+	if rr := send("PATCH", "/accounts/acc_missing", `{"email": "a@b.c"}`, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing row, got %d", rr.Code)
+	}
+	if rr := send("DELETE", "/accounts/acc_missing", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing row, got %d", rr.Code)
+	}
+
+	// Another tenant's row exists but the user's RLS filter hides it
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	other := &auth.Claims{AccountID: "acc_2", Role: auth.RoleUser}
+	if rr := send("PATCH", "/accounts/acc_1", `{"email": "eve@b.c"}`, other); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a row hidden by RLS, got %d", rr.Code)
+	}
+	if last := fake.LastQuery(); !strings.Contains(last, `AND "accounts"."id" = $3`) {
+		t.Errorf("Expected the update filter in the query, got %s", last)
+	}
+	if rr := send("DELETE", "/accounts/acc_1", "", other); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a role without delete permission, got %d", rr.Code)
+	}
+
+	// Reads by id are filtered the same way
+	if rr := send("GET", "/accounts/acc_1", "", other); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a read hidden by RLS, got %d", rr.Code)
+	}
+	if last := fake.LastQuery(); !strings.Contains(last, `AND "accounts"."id" = $2`) {
+		t.Errorf("Expected the select filter in the query, got %s", last)
+	}
+	if rr := send("GET", "/accounts/acc_1", "", &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the caller's own row, got %d", rr.Code)
+	}
+
+	gql := NewGraphQLHandler(db, schema, zap.NewNop())
+	gql.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	params := graphql.ResolveParams{Context: context.WithValue(context.Background(), "claims", other), Args: map[string]interface{}{"id": "acc_1"}}
+	if row, err := gql.resolveGetOne(schema.Tables[0])(params); err != nil || row != nil {
+		t.Errorf("Expected GraphQL get of a row hidden by RLS to resolve to null, got %v, %v", row, err)
+	}
+}
+
+func TestRESTHandlerAuthorizesReadsAndCreates(t *testing.T) {
+	var inserted []driver.NamedValue
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		if strings.HasPrefix(query, "INSERT") {
+			inserted = args
+		}
+		return &lumadbtest.Rows{
+			Columns: []string{"id", "account_id", "name"},
+			Types:   []string{"VARCHAR", "VARCHAR", "VARCHAR"},
+			Data:    [][]driver.Value{{"cmp_1", "acc_1", "launch"}},
+		}, nil
+	})
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "campaigns", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "account_id", Type: "varchar"}, {Name: "name", Type: "varchar"}}},
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "email", Type: "varchar"}}},
+		},
+	}
+	handler := NewRESTHandler(db, schema, zap.NewNop())
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	router := handler.Routes()
+
+	send := func(method, path, body string, claims *auth.Claims) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// An API key scoped to updates may neither read nor create campaigns
+	scoped := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser, Permissions: []string{"campaigns:update"}}
+	queries := len(fake.Queries())
+	if code := send("GET", "/campaigns/cmp_1", "", scoped); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for get-by-id outside the key's scopes, got %d", code)
+	}
+	if code := send("POST", "/campaigns", `{"name": "launch"}`, scoped); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for create outside the key's scopes, got %d", code)
+	}
+	if code := send("POST", "/campaigns/bulk", `[{"name": "launch"}]`, scoped); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for bulk create outside the key's scopes, got %d", code)
+	}
+	if n := len(fake.Queries()); n != queries {
+		t.Errorf("Expected denied requests not to reach the database, ran %d queries", n-queries)
+	}
+
+	// Users may not create accounts at all
+	user := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+	if code := send("POST", "/accounts", `{"email": "eve@b.c"}`, user); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a role without insert permission, got %d", code)
+	}
+
+	// The insert preset overrides the account the caller names
+	if code := send("POST", "/campaigns", `{"name": "launch", "account_id": "acc_2"}`, user); code != http.StatusCreated {
+		t.Fatalf("Expected 201 for an allowed create, got %d", code)
+	}
+	for _, arg := range inserted {
+		if arg.Value == "acc_2" {
+			t.Errorf("Expected account_id to be preset to the caller's, got args %v", inserted)
+		}
+	}
+
+	// Columns and checks of an insert permission
+	perm := &auth.InsertPermission{Allowed: true, Columns: []string{"name", "account_id"}, Check: map[string]string{"account_id": "X-Account-ID"}}
+	if _, err := insertValues(perm, map[string]interface{}{"id": "cmp_2"}, user); err == nil {
+		t.Error("Expected a column outside the permission's to be rejected")
+	}
+	if _, err := insertValues(perm, map[string]interface{}{"name": "launch", "account_id": "acc_2"}, user); err == nil {
+		t.Error("Expected a row failing the check to be rejected")
+	}
+	if _, err := insertValues(perm, map[string]interface{}{"name": "launch", "account_id": "acc_1"}, user); err != nil {
+		t.Errorf("Expected a row passing the check to be accepted: %v", err)
+	}
+}
+
+type recordingAuditSink struct {
+	events []auth.AuditEvent
+	ctxs   []context.Context
+	err    error
+}
+
+func (s *recordingAuditSink) Record(ctx context.Context, event auth.AuditEvent) error {
+	s.events = append(s.events, event)
+	s.ctxs = append(s.ctxs, ctx)
+	return s.err
+}
+
+func TestAuthorizationAudit(t *testing.T) {
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{"acc_1"}}}, nil
+	})
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "first_name", Type: "varchar"}}},
+		},
+	}
+
+	sink := &recordingAuditSink{}
+	authz := auth.NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	authz.SetAuditSink(sink)
+	handler := NewRESTHandler(db, schema, zap.NewNop())
+	handler.SetPermissions(authz)
+	router := handler.Routes()
+
+	send := func(method, body string) int {
+		req := httptest.NewRequest(method, "/accounts/acc_1", strings.NewReader(body))
+		claims := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+		req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := send("PATCH", `{"first_name": "Ada"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 for an allowed update, got %d", code)
+	}
+	if code := send("DELETE", ""); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a denied delete, got %d", code)
+	}
+
+	if len(sink.events) != 2 {
+		t.Fatalf("Expected 2 audit events, got %+v", sink.events)
+	}
+	granted, denied := sink.events[0], sink.events[1]
+	if !granted.Allowed || granted.Operation != auth.PermissionUpdate || granted.AccountID != "acc_1" || granted.Table != "accounts" {
+		t.Errorf("Unexpected grant event: %+v", granted)
+	}
+	if denied.Allowed || denied.Operation != auth.PermissionDelete || denied.Role != auth.RoleUser || denied.Reason == "" || denied.Timestamp.IsZero() {
+		t.Errorf("Unexpected denial event: %+v", denied)
+	}
+
+	// A failing sink must not change the outcome of the request
+	sink.err = auth.ErrAuditQueueFull
+	if code := send("DELETE", ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with a failing sink, got %d", code)
+	}
+	claims := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+	ctx := context.WithValue(context.Background(), "claims", claims)
+	if _, _, err := authz.ApplyRLS(ctx, "SELECT * FROM accounts", "accounts", auth.PermissionSelect, claims); err != nil {
+		t.Errorf("Expected ApplyRLS to succeed with a failing sink: %v", err)
+	}
+	if len(sink.events) != 4 || !sink.events[3].Allowed || sink.events[3].Operation != auth.PermissionSelect {
+		t.Fatalf("Expected ApplyRLS to be audited, got %+v", sink.events)
+	}
+	if sink.ctxs[3].Value("claims") != claims {
+		t.Error("Expected ApplyRLS to audit with the caller's context")
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "campaigns", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "uuid"}, {Name: "deleted_at", Type: "timestamp with time zone"}}},
+			{Name: "sender_ids", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "uuid"}, {Name: "is_deleted", Type: "boolean"}}},
+			{Name: "sms_history", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "uuid"}, {Name: "archived_at", Type: "timestamp"}}},
+		},
+	}
+
+	if err := configureSoftDelete(schema, map[string]string{"sms_history": "missing"}, false); err == nil {
+		t.Error("Expected an error for a missing soft delete column")
+	}
+	if err := configureSoftDelete(schema, map[string]string{"nope": "deleted_at"}, false); err == nil {
+		t.Error("Expected an error for an unknown table")
+	}
+	if err := configureSoftDelete(schema, map[string]string{"sms_history": "archived_at"}, true); err != nil {
+		t.Fatalf("configureSoftDelete failed: %v", err)
+	}
+	for i, want := range []string{"deleted_at", "is_deleted", "archived_at"} {
+		if got := schema.Tables[i].SoftDeleteColumn; got != want {
+			t.Errorf("%s: expected soft delete column %q, got %q", schema.Tables[i].Name, want, got)
+		}
+	}
+	if got := liveRowsCondition(schema.Tables[1]); got != `"sender_ids"."is_deleted" IS NOT TRUE` {
+		t.Errorf("Unexpected flag condition %q", got)
+	}
+
+	const campaignID = "6f1c2a9e-3b7d-4e8a-9c5f-0d2e4b6a8c1f"
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		return &lumadbtest.Rows{
+			Columns: []string{"id"},
+			Types:   []string{"UUID"},
+			Data:    [][]driver.Value{{campaignID}},
+		}, nil
+	})
+	router := NewRESTHandler(db, schema, zap.NewNop()).Routes()
+	send := func(method, path string, role auth.Role) int {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), "claims", &auth.Claims{Role: role}))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	lastQuery := func() string { return fake.LastQuery() }
+
+	if code := send("DELETE", "/campaigns/"+campaignID, auth.RoleUser); code != http.StatusOK {
+		t.Fatalf("Expected 200 for soft delete, got %d", code)
+	}
+	if want := `UPDATE "campaigns" SET "deleted_at" = NOW() WHERE "campaigns"."id" = $1 AND "campaigns"."deleted_at" IS NULL RETURNING "id", "deleted_at"`; lastQuery() != want {
+		t.Errorf("Unexpected soft delete query:\n got %s\nwant %s", lastQuery(), want)
+	}
+
+	if code := send("DELETE", "/campaigns/"+campaignID+"?hard=true", auth.RoleUser); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for hard delete by a user, got %d", code)
+	}
+	if code := send("DELETE", "/campaigns/"+campaignID+"?hard=true", auth.RoleAdmin); code != http.StatusOK {
+		t.Errorf("Expected 200 for hard delete by an admin, got %d", code)
+	}
+	if !strings.HasPrefix(lastQuery(), `DELETE FROM "campaigns"`) {
+		t.Errorf("Expected a hard delete, got %s", lastQuery())
+	}
+
+	send("GET", "/campaigns", auth.RoleUser)
+	if !strings.Contains(lastQuery(), `WHERE "campaigns"."deleted_at" IS NULL`) {
+		t.Errorf("Expected list to exclude soft-deleted rows, got %s", lastQuery())
+	}
+	if code := send("GET", "/campaigns?include_deleted=true", auth.RoleUser); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for include_deleted by a user, got %d", code)
+	}
+	send("GET", "/campaigns?include_deleted=true", auth.RoleAdmin)
+	if strings.Contains(lastQuery(), "deleted_at") {
+		t.Errorf("Expected admin listing to include soft-deleted rows, got %s", lastQuery())
+	}
+
+	// MCP tools never see soft-deleted rows
+	mcp := NewMCPHandler(db, schema, zap.NewNop())
+	if _, err := mcp.tools["list_campaigns"].Handler(context.Background(), map[string]interface{}{}); err != nil {
+		t.Fatalf("MCP list failed: %v", err)
+	}
+	if want := `SELECT * FROM "campaigns" WHERE "campaigns"."deleted_at" IS NULL LIMIT $1 OFFSET $2`; lastQuery() != want {
+		t.Errorf("Unexpected MCP list query:\n got %s\nwant %s", lastQuery(), want)
+	}
+	if _, err := mcp.tools["get_campaigns"].Handler(context.Background(), map[string]interface{}{"id": campaignID}); err != nil {
+		t.Fatalf("MCP get failed: %v", err)
+	}
+	if want := `SELECT * FROM "campaigns" WHERE "campaigns"."deleted_at" IS NULL AND "campaigns"."id" = $1`; lastQuery() != want {
+		t.Errorf("Unexpected MCP get query:\n got %s\nwant %s", lastQuery(), want)
+	}
+
+	// A GraphQL filter cannot escape the guard to list soft-deleted rows
+	graphqlAPI := NewGraphQLHandler(db, schema, zap.NewNop())
+	ctx := context.WithValue(context.Background(), "claims", &auth.Claims{Role: auth.RoleAdmin})
+	list := func(where string) *graphql.Result {
+		return graphqlAPI.execute(ctx, graphQLRequest{
+			Query:     `query($where: String) { campaignses(where: $where) { id } }`,
+			Variables: map[string]interface{}{"where": where},
+		})
+	}
+	queries := len(fake.Queries())
+	if result := list("true) OR (true"); !result.HasErrors() || len(fake.Queries()) != queries {
+		t.Errorf("Expected a raw SQL filter to be rejected, got %v", result.Errors)
+	}
+	if result := list(`{"id": "` + campaignID + `"}`); result.HasErrors() {
+		t.Fatalf("GraphQL list failed: %v", result.Errors)
+	}
+	if want := `SELECT * FROM "campaigns" WHERE "campaigns"."deleted_at" IS NULL AND "campaigns"."id" = $1 LIMIT $2`; lastQuery() != want {
+		t.Errorf("Unexpected GraphQL list query:\n got %s\nwant %s", lastQuery(), want)
+	}
+}
+
+func TestPrimaryKeyIDs(t *testing.T) {
+	accounts := TableSchema{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "bigint"}, {Name: "email", Type: "varchar"}}}
+	campaigns := TableSchema{Name: "campaigns", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "uuid"}}}
+
+	tests := []struct {
+		table TableSchema
+		id    interface{}
+		want  interface{}
+	}{
+		{accounts, "42", int64(42)},
+		{accounts, float64(42), int64(42)},
+		{accounts, "abc", nil},
+		{accounts, "4.2", nil},
+		{accounts, "99999999999999999999", nil},
+		{accounts, "", nil},
+		{accounts, "  ", nil},
+		{accounts, nil, nil},
+		{campaigns, "6f1c2a9e-3b7d-4e8a-9c5f-0d2e4b6a8c1f", "6f1c2a9e-3b7d-4e8a-9c5f-0d2e4b6a8c1f"},
+		{campaigns, "cmp_1", nil},
+		{campaigns, "", nil},
+	}
+	for _, tt := range tests {
+		got, err := primaryKeyValue(tt.table, tt.id)
+		if tt.want == nil {
+			if !errors.Is(err, ErrInvalidID) {
+				t.Errorf("%s id %#v: expected ErrInvalidID, got %v, %v", tt.table.Name, tt.id, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s id %#v: expected %#v, got %#v, %v", tt.table.Name, tt.id, tt.want, got, err)
+		}
+	}
+
+	// Only account 42 exists
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		rows := &lumadbtest.Rows{Columns: []string{"id", "email"}, Types: []string{"INT8", "VARCHAR"}}
+		if len(args) > 0 && args[len(args)-1].Value == int64(42) {
+			rows.Data = [][]driver.Value{{int64(42), "a@b.c"}}
+		}
+		return rows, nil
+	})
+	schema := &Schema{Tables: []TableSchema{accounts}}
+	router := NewRESTHandler(db, schema, zap.NewNop()).Routes()
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, path := range []string{"/accounts/abc", "/accounts/%20"} {
+		if code := send("GET", path, ""); code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %d", path, code)
+		}
+		if code := send("PATCH", path, `{"email": "a@b.c"}`); code != http.StatusBadRequest {
+			t.Errorf("PATCH %s: expected 400, got %d", path, code)
+		}
+		if code := send("DELETE", path, ""); code != http.StatusBadRequest {
+			t.Errorf("DELETE %s: expected 400, got %d", path, code)
+		}
+	}
+	if n := len(fake.Queries()); n != 0 {
+		t.Errorf("Expected malformed ids not to be queried, got %d queries", n)
+	}
+	if code := send("GET", "/accounts/42", ""); code != http.StatusOK {
+		t.Errorf("Expected 200 for an existing account, got %d", code)
+	}
+	if code := send("GET", "/accounts/7", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing account, got %d", code)
+	}
+
+	handler := NewGraphQLHandler(db, schema, zap.NewNop())
+	query := func(q string) map[string]interface{} {
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(fmt.Sprintf(`{"query": %q}`, q)))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var result map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return result
+	}
+	for _, id := range []string{"abc", ""} {
+		result := query(fmt.Sprintf(`{ accounts(id: %q) { id } }`, id))
+		errs, _ := result["errors"].([]interface{})
+		if len(errs) == 0 || !strings.Contains(fmt.Sprint(errs[0]), "invalid id") {
+			t.Errorf("id %q: expected an invalid id error, got %v", id, result)
+		}
+	}
+	result := query(`{ accounts(id: "7") { id } }`)
+	if data, _ := result["data"].(map[string]interface{}); data == nil || data["accounts"] != nil || result["errors"] != nil {
+		t.Errorf("Expected null without errors for a missing account, got %v", result)
+	}
+}
+
+func TestGetByIDs(t *testing.T) {
+	// Accounts 1 and 3 exist, and come back in the database's order
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		rows := &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"INT8"}}
+		withEmail := strings.HasPrefix(query, "SELECT *") || strings.Contains(query, `"email"`)
+		if withEmail {
+			rows.Columns, rows.Types = []string{"id", "email"}, []string{"INT8", "VARCHAR"}
+		}
+		for _, id := range []int64{3, 1} {
+			for _, arg := range args {
+				if arg.Value != id {
+					continue
+				}
+				row := []driver.Value{id}
+				if withEmail {
+					row = append(row, fmt.Sprintf("%d@b.c", id))
+				}
+				rows.Data = append(rows.Data, row)
+				break
+			}
+		}
+		return rows, nil
+	})
+	schema := &Schema{Tables: []TableSchema{
+		{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "bigint"}, {Name: "email", Type: "varchar"}}},
+	}}
+	handler := NewRESTHandler(db, schema, zap.NewNop())
+	router := handler.Routes()
+	get := func(path string, claims *auth.Claims) (int, []map[string]interface{}) {
+		req := httptest.NewRequest("GET", path, nil)
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var body struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr.Code, body.Data
+	}
+
+	code, rows := get("/accounts?ids=3,2,1,3", nil)
+	if code != http.StatusOK || len(rows) != 4 {
+		t.Fatalf("Expected 4 rows, got %d: %v", code, rows)
+	}
+	if rows[0]["id"] != float64(3) || rows[1] != nil || rows[2]["id"] != float64(1) || rows[3]["email"] != "3@b.c" {
+		t.Errorf("Expected rows in the order asked for with null for 2, got %v", rows)
+	}
+	if n := len(fake.Queries()); n != 1 {
+		t.Errorf("Expected one query, got %d", n)
+	}
+	if want := `SELECT "id", "email" FROM "accounts" WHERE "accounts"."id" IN ($1, $2, $3)`; fake.LastQuery() != want {
+		t.Errorf("Unexpected query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+	if _, rows := get("/accounts?ids=3,2,1&missing=omit", nil); len(rows) != 2 || rows[1]["id"] != float64(1) {
+		t.Errorf("Expected the missing id left out, got %v", rows)
+	}
+
+	tooMany := strings.TrimSuffix(strings.Repeat("1,", maxBatchIDs+1), ",")
+	for _, path := range []string{"/accounts?ids=1,abc", "/accounts?ids=", "/accounts?ids=1,,2", "/accounts?ids=" + tooMany, "/accounts?ids=1&missing=skip"} {
+		if code, _ := get(path, nil); code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %d", path, code)
+		}
+	}
+
+	// Rows are limited to the caller's tenant and readable columns
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	handler.SetFieldAuthorizer(hiddenColumns{"email"})
+	user := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+	if code, rows := get("/accounts?ids=1", user); code != http.StatusOK || len(rows) != 1 || rows[0] == nil || rows[0]["email"] != nil {
+		t.Errorf("Expected the row without email, got %d: %v", code, rows)
+	}
+	if last := fake.LastQuery(); !strings.HasPrefix(last, `SELECT "id" FROM`) || !strings.Contains(last, `AND "accounts"."id" = $2`) {
+		t.Errorf("Expected the RLS filter and readable columns in the query, got %s", last)
+	}
+
+	gql := NewGraphQLHandler(db, schema, zap.NewNop())
+	query := func(q string) map[string]interface{} {
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(fmt.Sprintf(`{"query": %q}`, q)))
+		rr := httptest.NewRecorder()
+		gql.ServeHTTP(rr, req)
+		var result map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return result
+	}
+	result := query(`{ accounts_by_pks(ids: ["3", "2", "1"]) { id email } }`)
+	data, _ := result["data"].(map[string]interface{})
+	list, _ := data["accounts_by_pks"].([]interface{})
+	if len(list) != 3 || list[1] != nil || list[0].(map[string]interface{})["email"] != "3@b.c" {
+		t.Errorf("Expected rows in order with null for 2, got %v", result)
+	}
+	result = query(`{ accounts_by_pks(ids: ["3", "2", "1"], omitMissing: true) { id } }`)
+	data, _ = result["data"].(map[string]interface{})
+	if list, _ := data["accounts_by_pks"].([]interface{}); len(list) != 2 {
+		t.Errorf("Expected the missing id left out, got %v", result)
+	}
+	if result := query(`{ accounts_by_pks(ids: ["1", "abc"]) { id } }`); result["errors"] == nil {
+		t.Errorf("Expected an invalid id error, got %v", result)
+	}
+}
+
+func TestRESTHandlerListValidation(t *testing.T) {
+	handler := &RESTHandler{
+		schema: &Schema{
+			Tables: []TableSchema{
+				{Name: "accounts", PrimaryKey: "id"},
+			},
+		},
+	}
+
+	// This would normally require a DB connection
+	// Testing handler setup logic
+	routes := handler.Routes()
+	if routes == nil {
+		t.Error("Routes should not be nil")
+	}
+}
+
+func TestRESTHandlerListPagination(t *testing.T) {
+	// Five rows match, served by LIMIT/OFFSET from the last two arguments
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			return &lumadbtest.Rows{Columns: []string{"count"}, Types: []string{"INT8"}, Data: [][]driver.Value{{int64(5)}}}, nil
+		case strings.HasPrefix(query, "EXPLAIN"):
+			return &lumadbtest.Rows{Columns: []string{"QUERY PLAN"}, Types: []string{"JSON"}, Data: [][]driver.Value{{`[{"Plan": {"Plan Rows": 4}}]`}}}, nil
+		}
+		limit := int(args[len(args)-2].Value.(int64))
+		offset := int(args[len(args)-1].Value.(int64))
+		rows := &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}}
+		for i := offset; i < 5 && i < offset+limit; i++ {
+			rows.Data = append(rows.Data, []driver.Value{fmt.Sprintf("acc_%d", i)})
+		}
+		return rows, nil
+	})
+	schema := &Schema{Tables: []TableSchema{{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}}}}
+	handler := NewRESTHandler(db, schema, zap.NewNop())
+	router := handler.Routes()
+
+	list := func(query string, claims *auth.Claims) (*httptest.ResponseRecorder, ListResponse) {
+		req := httptest.NewRequest("GET", "/accounts"+query, nil)
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var body ListResponse
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr, body
+	}
+
+	rr, body := list("?limit=2", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(body.Data) != 2 || !body.Pagination.HasMore || body.Pagination.Limit != 2 || body.Pagination.Total != nil {
+		t.Errorf("Unexpected first page without count: %s", rr.Body.String())
+	}
+	if rr.Header().Get("X-Total-Count") != "" {
+		t.Error("Expected no X-Total-Count without a count")
+	}
+
+	rr, body = list("?limit=2&offset=4&count=exact", nil)
+	if len(body.Data) != 1 || body.Pagination.HasMore || body.Pagination.Offset != 4 ||
+		body.Pagination.Total == nil || *body.Pagination.Total != 5 || body.Pagination.Estimated {
+		t.Errorf("Unexpected last page with exact count: %s", rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Total-Count"); got != "5" {
+		t.Errorf("Expected X-Total-Count 5, got %q", got)
+	}
+
+	rr, body = list("?count=estimated", nil)
+	if body.Pagination.Total == nil || *body.Pagination.Total != 4 || !body.Pagination.Estimated || body.Pagination.Limit != DefaultListLimit {
+		t.Errorf("Unexpected estimated count: %s", rr.Body.String())
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=5000", "?offset=-1", "?count=maybe"} {
+		if rr, _ := list(query, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+
+	// The count is restricted by the caller's row filter like the page
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	list("?count=exact", &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser})
+	// The count comes first, for its header to precede the streamed page
+	count, page := fake.Queries()[len(fake.Queries())-2], fake.LastQuery()
+	if want := `SELECT * FROM "accounts" WHERE "accounts"."id" = $1 LIMIT $2 OFFSET $3`; page != want {
+		t.Errorf("Unexpected page query:\n got %s\nwant %s", page, want)
+	}
+	if want := `SELECT count(*) FROM "accounts" WHERE "accounts"."id" = $1`; count != want {
+		t.Errorf("Unexpected count query:\n got %s\nwant %s", count, want)
+	}
+}
+
+func TestListLimits(t *testing.T) {
+	tests := []struct {
+		limits    ListLimits
+		requested int
+		given     bool
+		want      int // 0 when rejected
+	}{
+		{ListLimits{}, 0, false, DefaultListLimit},
+		{ListLimits{}, MaxListLimit, true, MaxListLimit},
+		{ListLimits{}, MaxListLimit + 1, true, 0},
+		{ListLimits{Default: 20, Max: 50}, 0, false, 20},
+		{ListLimits{Default: 20, Max: 50}, 51, true, 0},
+		{ListLimits{Default: 20, Max: 50, Clamp: true}, 51, true, 50},
+		{ListLimits{Default: 20, Max: 50, Clamp: true}, 0, true, 0},
+		{ListLimits{Default: 200, Max: 50}, 0, false, 50},
+	}
+	for _, tc := range tests {
+		got, err := tc.limits.limit(tc.requested, tc.given)
+		if tc.want == 0 && err == nil || tc.want != 0 && (err != nil || got != tc.want) {
+			t.Errorf("%+v limit(%d, %v): got %d, %v; want %d", tc.limits, tc.requested, tc.given, got, err, tc.want)
+		}
+	}
+
+	// Every list path serves the configured limits
+	var limit interface{}
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		for i, a := range args {
+			if strings.Contains(query, fmt.Sprintf("LIMIT $%d", i+1)) {
+				limit = a.Value
+			}
+		}
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}}, nil
+	})
+	schema := &Schema{Tables: []TableSchema{{Name: "account", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}}}}
+	rest := NewRESTHandler(db, schema, zap.NewNop())
+	graphQL := NewGraphQLHandler(db, schema, zap.NewNop())
+	mcp := NewMCPHandler(db, schema, zap.NewNop())
+
+	restList := func(query string) int {
+		rr := httptest.NewRecorder()
+		rest.Routes().ServeHTTP(rr, httptest.NewRequest("GET", "/account"+query, nil))
+		return rr.Code
+	}
+	graphQLList := func(args string) bool {
+		return !graphQL.execute(context.Background(), graphQLRequest{Query: "{ accounts" + args + " { id } }"}).HasErrors()
+	}
+	mcpList := func(input string) int {
+		rr := httptest.NewRecorder()
+		mcp.Routes().ServeHTTP(rr, httptest.NewRequest("POST", "/tools/list_account/execute", strings.NewReader(input)))
+		return rr.Code
+	}
+
+	for _, clamp := range []bool{false, true} {
+		limits := ListLimits{Default: 20, Max: 50, Clamp: clamp}
+		rest.SetListLimits(limits)
+		graphQL.SetListLimits(limits)
+		mcp.SetListLimits(limits)
+
+		// REST reads one row more to tell whether there is a next page
+		limit = nil
+		if code := restList(""); code != http.StatusOK || limit != int64(21) {
+			t.Errorf("clamp %v: REST default got %d, limit %v", clamp, code, limit)
+		}
+		if !graphQLList("") || limit != int64(20) {
+			t.Errorf("clamp %v: GraphQL default got limit %v", clamp, limit)
+		}
+		if code := mcpList(`{}`); code != http.StatusOK || limit != int64(20) {
+			t.Errorf("clamp %v: MCP default got %d, limit %v", clamp, code, limit)
+		}
+
+		limit = nil
+		if code := restList("?limit=500"); clamp && (code != http.StatusOK || limit != int64(51)) || !clamp && (code != http.StatusBadRequest || limit != nil) {
+			t.Errorf("clamp %v: REST limit above the maximum got %d, limit %v", clamp, code, limit)
+		}
+		if ok := graphQLList("(limit: 500)"); clamp && (!ok || limit != int64(50)) || !clamp && (ok || limit != nil) {
+			t.Errorf("clamp %v: GraphQL limit above the maximum got ok %v, limit %v", clamp, ok, limit)
+		}
+		if code := mcpList(`{"limit": 500}`); clamp && (code != http.StatusOK || limit != int64(50)) || !clamp && (code != http.StatusBadRequest || limit != nil) {
+			t.Errorf("clamp %v: MCP limit above the maximum got %d, limit %v", clamp, code, limit)
+		}
+	}
+
+	schemaMax := mcp.tools["list_account"].InputSchema["properties"].(map[string]interface{})["limit"].(map[string]interface{})["maximum"]
+	if schemaMax != 50 {
+		t.Errorf("Expected the list tool's maximum limit to be 50, got %v", schemaMax)
+	}
+}
+
+func TestDatabaseSessionRLS(t *testing.T) {
+	var session []string
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		if strings.HasPrefix(query, "SELECT set_config") {
+			session = append(session, fmt.Sprintf("%v=%v", args[0].Value, args[1].Value))
+		}
+		if len(args) > 0 && args[0].Value == "acc_broken" {
+			return nil, errors.New("relation is corrupt")
+		}
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{"acc_1"}}}, nil
+	})
+	schema := &Schema{Tables: []TableSchema{{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}}}}
+	handler := NewRESTHandler(db, schema, zap.NewNop())
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	router := SessionMiddleware(db, zap.NewNop())(handler.Routes())
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "claims", &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Claims become session variables and the row filter is left to the
+	// database's policies
+	rr := serve("GET", "/accounts", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := strings.Join(session, " "); got != "app.account_id=acc_1 app.is_live=false app.role=user app.user_id=" {
+		t.Errorf("Unexpected session variables: %s", got)
+	}
+	if page := fake.LastQuery(); page != `SELECT * FROM "accounts" LIMIT $1 OFFSET $2` {
+		t.Errorf("Expected no gateway row filter, got %s", page)
+	}
+	if fake.Commits() != 1 || fake.Rollbacks() != 0 {
+		t.Errorf("Expected the session to commit, got %d commits, %d rollbacks", fake.Commits(), fake.Rollbacks())
+	}
+
+	// A refusal is served as is; the session it never used commits
+	rr = serve("POST", "/accounts", `{"first_name": "x"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if fake.Commits() != 2 || fake.Rollbacks() != 0 {
+		t.Errorf("Expected the session to commit, got %d commits, %d rollbacks", fake.Commits(), fake.Rollbacks())
+	}
+
+	// A failed statement rolls the session back and the handler's error
+	// is still served
+	rr = serve("GET", "/accounts/acc_broken", "")
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "relation is corrupt") {
+		t.Fatalf("Expected the handler's 500, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if fake.Commits() != 2 || fake.Rollbacks() != 1 {
+		t.Errorf("Expected the session to roll back, got %d commits, %d rollbacks", fake.Commits(), fake.Rollbacks())
+	}
+
+	// A panic rolls the session back before it propagates
+	panicking := SessionMiddleware(db, zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("Expected the panic to propagate, got %v", p)
+			}
+		}()
+		panicking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if fake.Commits() != 2 || fake.Rollbacks() != 2 {
+		t.Errorf("Expected the session to roll back, got %d commits, %d rollbacks", fake.Commits(), fake.Rollbacks())
+	}
+}
+
+func TestGraphQLBatchRequest(t *testing.T) {
+	handler := NewGraphQLHandler(nil, &Schema{
+		Tables: []TableSchema{
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}},
+		},
+	}, zap.NewNop())
+
+	body := `[
+		{"query": "{ __typename }"},
+		"not an operation",
+		{"query": "query Named { __schema { queryType { name } } }", "operationName": "Named"}
+	]`
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var results []map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Expected a JSON array response: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if data, _ := results[0]["data"].(map[string]interface{}); data["__typename"] != "Query" {
+		t.Errorf("Expected first result to resolve __typename, got %v", results[0])
+	}
+	if _, ok := results[1]["errors"]; !ok {
+		t.Errorf("Expected malformed operation to produce an error entry, got %v", results[1])
+	}
+	if _, ok := results[2]["data"]; !ok {
+		t.Errorf("Expected third operation to succeed, got %v", results[2])
+	}
+
+	// The batch shares one complexity budget: each list below costs 11
+	budgeted := NewGraphQLHandler(nil, &Schema{
+		Tables: []TableSchema{
+			{Name: "account", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}},
+		},
+	}, zap.NewNop())
+	budgeted.SetQueryLimits(QueryLimits{MaxComplexity: 25})
+	list := `{"query": "{ accounts { id } }"}`
+	rr = httptest.NewRecorder()
+	budgeted.ServeHTTP(rr, httptest.NewRequest("POST", "/graphql", strings.NewReader("["+list+","+list+","+list+`, {"query": "{ __typename }"}]`)))
+	var batch []graphql.Result
+	if err := json.Unmarshal(rr.Body.Bytes(), &batch); err != nil || len(batch) != 4 {
+		t.Fatalf("Expected 4 results, got %s", rr.Body.String())
+	}
+	for i, want := range []string{"", "", "batch complexity 33 exceeds the maximum of 25", ""} {
+		var got string
+		for _, e := range batch[i].Errors {
+			if strings.Contains(e.Message, "complexity") {
+				got = e.Message
+			}
+		}
+		if got != want {
+			t.Errorf("Operation %d: expected complexity error %q, got %q", i, want, got)
+		}
+	}
+
+	// Batches beyond the maximum are refused before any operation runs
+	handler.SetMaxBatchSize(2)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/graphql", strings.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an oversized batch, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGraphQLMutationTransaction(t *testing.T) {
+	// Inserting acc_2 violates a constraint
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		for _, arg := range args {
+			if arg.Value == "acc_2" {
+				return nil, fmt.Errorf("duplicate key value violates unique constraint")
+			}
+		}
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{args[0].Value}}}, nil
+	})
+	var notified []string
+	handler := NewGraphQLHandler(db, &Schema{
+		Tables: []TableSchema{
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}},
+		},
+	}, zap.NewNop())
+	handler.SetChangeNotifier(&ChangeNotifier{
+		tables: map[string]bool{"accounts": true},
+		notify: func(ctx context.Context, channel, payload string) error {
+			notified = append(notified, payload)
+			return nil
+		},
+		logger: zap.NewNop(),
+	})
+
+	insert := func(ids ...string) *graphql.Result {
+		var fields []string
+		for i, id := range ids {
+			fields = append(fields, fmt.Sprintf(`m%d: insert_accounts(object: "{\"id\": \"%s\"}") { id }`, i, id))
+		}
+		return handler.execute(context.Background(), graphQLRequest{Query: "mutation { " + strings.Join(fields, " ") + " }"})
+	}
+
+	result := insert("acc_1", "acc_2")
+	if !result.HasErrors() || result.Data != nil {
+		t.Errorf("Expected only errors from a failed mutation, got %+v", result)
+	}
+	if fake.Commits() != 0 || fake.Rollbacks() != 1 {
+		t.Errorf("Expected the first insert rolled back, got %d commits and %d rollbacks", fake.Commits(), fake.Rollbacks())
+	}
+	if len(fake.Queries()) != 2 {
+		t.Errorf("Expected both inserts in the transaction, got %v", fake.Queries())
+	}
+	if len(notified) != 0 {
+		t.Errorf("Expected no change events for a rolled back mutation, got %v", notified)
+	}
+
+	result = insert("acc_1", "acc_3")
+	if result.HasErrors() {
+		t.Fatalf("Mutation failed: %v", result.Errors)
+	}
+	if fake.Commits() != 1 || fake.Rollbacks() != 1 {
+		t.Errorf("Expected the mutation committed once, got %d commits and %d rollbacks", fake.Commits(), fake.Rollbacks())
+	}
+	if len(notified) != 2 {
+		t.Errorf("Expected a change event per insert after commit, got %v", notified)
+	}
+}
+
+func TestGraphQLQueryLimits(t *testing.T) {
+	handler := NewGraphQLHandler(nil, &Schema{
+		Tables: []TableSchema{
+			{Name: "account", PrimaryKey: "id", Columns: []Column{
+				{Name: "id", Type: "varchar"},
+				{Name: "name", Type: "varchar"},
+			}},
+		},
+	}, zap.NewNop())
+
+	tests := []struct {
+		name    string
+		limits  QueryLimits
+		query   string
+		wantErr string
+	}{
+		{"depth exceeded", QueryLimits{MaxDepth: 1}, "{ accounts { id } }", "query depth 2 exceeds the maximum of 1"},
+		{"depth via fragment", QueryLimits{MaxDepth: 1}, "{ ...F } fragment F on Query { account(id: 1) { id } }", "query depth 2 exceeds the maximum of 1"},
+		{"complexity exceeded", QueryLimits{MaxComplexity: 1000}, "{ accounts(limit: 500) { id name } }", "query complexity 1001 exceeds the maximum of 1000"},
+		{"default list size", QueryLimits{MaxComplexity: 20}, "{ accounts { id name } }", "query complexity 21 exceeds the maximum of 20"},
+		{"within budget", QueryLimits{MaxDepth: 2, MaxComplexity: 100}, "{ __typename }", ""},
+		{"saturated limit", QueryLimits{MaxComplexity: 1000}, "{ accounts(limit: 99999999999999999999) { id name } }", fmt.Sprintf("query complexity %d exceeds the maximum of 1000", math.MaxInt)},
+		{"saturated product", QueryLimits{MaxComplexity: 1000}, "{ accounts(limit: 9223372036854775807) { id name } }", fmt.Sprintf("query complexity %d exceeds the maximum of 1000", math.MaxInt)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler.SetQueryLimits(tt.limits)
+			result := handler.execute(context.Background(), graphQLRequest{Query: tt.query})
+
+			if tt.wantErr == "" {
+				if result.HasErrors() {
+					t.Fatalf("Expected no errors, got %v", result.Errors)
+				}
+				return
+			}
+			if len(result.Errors) != 1 || result.Errors[0].Message != tt.wantErr {
+				t.Fatalf("Expected error %q, got %v", tt.wantErr, result.Errors)
+			}
+		})
+	}
+}
+
+func TestGraphQLFieldAuthorization(t *testing.T) {
+	handler := NewGraphQLHandler(nil, &Schema{}, zap.NewNop())
+	handler.SetFieldAuthorizer(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+
+	accountType := handler.buildObjectType(TableSchema{
+		Name:       "accounts",
+		PrimaryKey: "id",
+		Columns: []Column{
+			{Name: "id", Type: "varchar"},
+			{Name: "balance", Type: "numeric"},
+			{Name: "live_secret_key", Type: "varchar"},
+		},
+	})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"account": &graphql.Field{
+					Type: accountType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return map[string]interface{}{"id": "acc_1", "balance": 12.5, "live_secret_key": "lk_123"}, nil
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatalf("Failed to build schema: %v", err)
+	}
+
+	query := "{ account { id balance liveSecretKey } }"
+	run := func(role auth.Role) *graphql.Result {
+		ctx := context.WithValue(context.Background(), "claims", &auth.Claims{AccountID: "acc_1", Role: role})
+		return graphql.Do(graphql.Params{Schema: schema, RequestString: query, Context: ctx})
+	}
+
+	admin := run(auth.RoleAdmin)
+	if admin.HasErrors() {
+		t.Fatalf("Expected admin to read every field, got %v", admin.Errors)
+	}
+	account := admin.Data.(map[string]interface{})["account"].(map[string]interface{})
+	if account["liveSecretKey"] != "lk_123" {
+		t.Errorf("Expected admin to see liveSecretKey, got %v", account)
+	}
+
+	user := run(auth.RoleUser)
+	if len(user.Errors) != 1 || !strings.Contains(user.Errors[0].Message, "accounts.live_secret_key") {
+		t.Fatalf("Expected a permission error for live_secret_key, got %v", user.Errors)
+	}
+	account = user.Data.(map[string]interface{})["account"].(map[string]interface{})
+	if account["liveSecretKey"] != nil {
+		t.Errorf("Expected liveSecretKey to resolve to null for user, got %v", account["liveSecretKey"])
+	}
+	if account["id"] != "acc_1" || account["balance"] != 12.5 {
+		t.Errorf("Expected user to see id and balance, got %v", account)
+	}
+}
+
+func TestGraphQLListWhere(t *testing.T) {
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{"cmp_1"}}}, nil
+	})
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "campaigns", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "account_id", Type: "varchar"}, {Name: "status", Type: "varchar"}}},
+		},
+	}
+	handler := NewGraphQLHandler(db, schema, zap.NewNop())
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+
+	// Users only see their own account's campaigns
+	ctx := context.WithValue(context.Background(), "claims", &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser})
+	list := func(where string) *graphql.Result {
+		return handler.execute(ctx, graphQLRequest{
+			Query:     `query($where: String) { campaignses(where: $where) { id } }`,
+			Variables: map[string]interface{}{"where": where},
+		})
+	}
+
+	// SQL breaking out of its parentheses never reaches the database
+	queries := len(fake.Queries())
+	for _, where := range []string{"true) OR (true", `{"status": "active") OR (true"}`, `{"account_id) OR (true": "x"}`} {
+		if result := list(where); !result.HasErrors() {
+			t.Errorf("Expected where %q to be rejected", where)
+		}
+	}
+	if n := len(fake.Queries()); n != queries {
+		t.Errorf("Expected rejected filters not to reach the database, ran %d queries", n-queries)
+	}
+
+	if result := list(`{"status": {"in": ["active", "paused"]}}`); result.HasErrors() {
+		t.Fatalf("Expected a structured filter to be accepted, got %v", result.Errors)
+	}
+	want := `SELECT * FROM "campaigns" WHERE "campaigns"."account_id" = $1 AND "campaigns"."status" IN ($2, $3) LIMIT $4`
+	if fake.LastQuery() != want {
+		t.Errorf("Unexpected list query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+}
+
+func TestGraphQLListOrderBy(t *testing.T) {
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{"cmp_1"}}}, nil
+	})
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "campaigns", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "createdAt", Type: "timestamp"}, {Name: "order", Type: "integer"}}},
+		},
+	}
+	handler := NewGraphQLHandler(db, schema, zap.NewNop())
+	ctx := context.WithValue(context.Background(), "claims", &auth.Claims{Role: auth.RoleAdmin})
+	list := func(orderBy string) *graphql.Result {
+		return handler.execute(ctx, graphQLRequest{
+			Query:     `query($orderBy: String) { campaignses(orderBy: $orderBy) { id } }`,
+			Variables: map[string]interface{}{"orderBy": orderBy},
+		})
+	}
+
+	// Mixed-case and reserved-word columns are quoted
+	if result := list("createdAt desc, order"); result.HasErrors() {
+		t.Fatalf("Expected the order to be accepted, got %v", result.Errors)
+	}
+	if want := `SELECT * FROM "campaigns" ORDER BY "campaigns"."createdAt" DESC, "campaigns"."order" ASC LIMIT $1`; fake.LastQuery() != want {
+		t.Errorf("Unexpected list query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+
+	queries := len(fake.Queries())
+	for _, orderBy := range []string{"missing", "id sideways", "id; DROP TABLE campaigns", "(SELECT 1)"} {
+		if result := list(orderBy); !result.HasErrors() {
+			t.Errorf("Expected orderBy %q to be rejected", orderBy)
+		}
+	}
+	if n := len(fake.Queries()); n != queries {
+		t.Errorf("Expected rejected orders not to reach the database, ran %d queries", n-queries)
+	}
+}
+
+func TestGraphQLQueryParsing(t *testing.T) {
+	// Test GraphQL query parsing
+	query := `{
+		accounts(limit: 10) {
+			id
+			email
+		}
+	}`
+
+	if len(query) == 0 {
+		t.Error("Query should not be empty")
+	}
+}
+
+func TestMCPToolRegistration(t *testing.T) {
+	handler := &MCPHandler{
+		tools: make(map[string]MCPTool),
+		schema: &Schema{
+			Tables: []TableSchema{
+				{Name: "accounts", PrimaryKey: "id"},
+				{Name: "campaigns", PrimaryKey: "id"},
+			},
+		},
+	}
+
+	// Register tools for tables
+	for _, table := range handler.schema.Tables {
+		handler.registerTableTools(table)
+	}
+
+	// Verify tools were registered
+	if len(handler.tools) < 4 { // At least 2 tools per table (list, get)
+		t.Errorf("Expected at least 4 tools, got %d", len(handler.tools))
+	}
+
+	// Check specific tool exists
+	if _, ok := handler.tools["list_accounts"]; !ok {
+		t.Error("list_accounts tool should exist")
+	}
+	if _, ok := handler.tools["get_campaigns"]; !ok {
+		t.Error("get_campaigns tool should exist")
+	}
+}
+
+func TestMCPListFilter(t *testing.T) {
+	var lastArgs []interface{}
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		lastArgs = nil
+		for _, a := range args {
+			lastArgs = append(lastArgs, a.Value)
+		}
+		return &lumadbtest.Rows{Columns: []string{"id"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{"msg_1"}}}, nil
+	})
+	schema := &Schema{Tables: []TableSchema{{Name: "sms_history", PrimaryKey: "id", Columns: []Column{
+		{Name: "id", Type: "varchar"}, {Name: "account_id", Type: "varchar"},
+		{Name: "status", Type: "varchar"}, {Name: "sent_date", Type: "timestamp"},
+	}}}}
+	handler := NewMCPHandler(db, schema, zap.NewNop())
+	router := handler.Routes()
+
+	execute := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/tools/list_sms_history/execute", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "claims", &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := execute(`{"filter": {"status": "failed", "sent_date": {"gte": "2024-01-01", "lt": "2024-02-01"}, "id": {"in": ["a", "b"]}},
+		"order_by": "sent_date desc, id", "limit": 20}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	want := `SELECT * FROM "sms_history" WHERE "sms_history"."id" IN ($1, $2) AND "sms_history"."sent_date" >= $3 AND ` +
+		`"sms_history"."sent_date" < $4 AND "sms_history"."status" = $5 ORDER BY "sms_history"."sent_date" DESC, "sms_history"."id" ASC LIMIT $6 OFFSET $7`
+	if got := fake.LastQuery(); got != want {
+		t.Errorf("Unexpected list query:\n got %s\nwant %s", got, want)
+	}
+	if got := fmt.Sprint(lastArgs); got != "[a b 2024-01-01 2024-02-01 failed 20 0]" {
+		t.Errorf("Unexpected list args %s", got)
+	}
+
+	// The caller's row filter comes first and still applies
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	if rr := execute(`{"filter": {"status": "failed"}}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	want = `SELECT * FROM "sms_history" WHERE "sms_history"."account_id" = $1 AND "sms_history"."status" = $2 LIMIT $3 OFFSET $4`
+	if got := fake.LastQuery(); got != want {
+		t.Errorf("Unexpected filtered list query:\n got %s\nwant %s", got, want)
+	}
+
+	queries := len(fake.Queries())
+	for _, body := range []string{
+		`{"filter": {"status; DROP TABLE accounts": "x"}}`,
+		`{"filter": {"status": {"regex": ".*"}}}`,
+		`{"filter": {"status": {"in": []}}}`,
+		`{"filter": {"status": {"eq": {"nested": 1}}}}`,
+		`{"filter": "status = 'failed'"}`,
+		`{"order_by": "sent_date; DROP TABLE accounts"}`,
+		`{"order_by": "nope"}`,
+		`{"limit": 0}`,
+		`{"offset": -1}`,
+	} {
+		if rr := execute(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+	if len(fake.Queries()) != queries {
+		t.Errorf("Expected no query for invalid input, got %v", fake.Queries()[queries:])
+	}
+}
+
+func TestMCPAggregate(t *testing.T) {
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		if strings.Contains(query, "sum(") {
+			return &lumadbtest.Rows{Columns: []string{"count", "sum", "avg"}, Types: []string{"INT8", "NUMERIC", "NUMERIC"},
+				Data: [][]driver.Value{{int64(3), float64(12.5), nil}}}, nil
+		}
+		return &lumadbtest.Rows{Columns: []string{"count"}, Types: []string{"INT8"}, Data: [][]driver.Value{{int64(7)}}}, nil
+	})
+	schema := &Schema{Tables: []TableSchema{
+		{Name: "sms_history", PrimaryKey: "id", Columns: []Column{
+			{Name: "id", Type: "varchar"}, {Name: "status", Type: "varchar"},
+			{Name: "cost", Type: "numeric"}, {Name: "segments", Type: "integer"},
+		}},
+		{Name: "sender_ids", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}},
+	}}
+	handler := NewMCPHandler(db, schema, zap.NewNop())
+	if _, ok := handler.tools["aggregate_sender_ids"]; ok {
+		t.Error("Expected no aggregate tool for a table without numeric columns")
+	}
+	if _, ok := handler.tools["count_sender_ids"]; !ok {
+		t.Error("Expected a count tool for every table")
+	}
+	router := handler.Routes()
+
+	execute := func(tool, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/tools/"+tool+"/execute", strings.NewReader(body)))
+		var resp struct {
+			Result map[string]interface{} `json:"result"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp.Result
+	}
+
+	rr, result := execute("count_sms_history", `{"filter": {"status": "failed"}}`)
+	if rr.Code != http.StatusOK || result["count"] != float64(7) {
+		t.Fatalf("Unexpected count: %d %s", rr.Code, rr.Body.String())
+	}
+	if want := `SELECT count(*) FROM "sms_history" WHERE "sms_history"."status" = $1`; fake.LastQuery() != want {
+		t.Errorf("Unexpected count query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+
+	rr, result = execute("aggregate_sms_history", `{"sum": ["cost"], "avg": ["segments"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if want := `SELECT count(*), sum("sms_history"."cost"), avg("sms_history"."segments") FROM "sms_history"`; fake.LastQuery() != want {
+		t.Errorf("Unexpected aggregate query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+	sum, _ := result["sum"].(map[string]interface{})
+	avg, _ := result["avg"].(map[string]interface{})
+	if result["count"] != float64(3) || sum["cost"] != 12.5 || avg == nil || avg["segments"] != nil {
+		t.Errorf("Unexpected aggregate result: %s", rr.Body.String())
+	}
+
+	for _, body := range []string{`{"sum": ["status"]}`, `{"sum": "cost"}`, `{"max": ["cost); DROP TABLE x; --"]}`} {
+		if rr, _ := execute("aggregate_sms_history", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+
+	// Soft-deleted rows are neither counted nor aggregated
+	schema.Tables[0].Columns = append(schema.Tables[0].Columns, Column{Name: "deleted_at", Type: "timestamp"})
+	schema.Tables[0].SoftDeleteColumn = "deleted_at"
+	router = NewMCPHandler(db, schema, zap.NewNop()).Routes()
+	execute("count_sms_history", `{"filter": {"status": "failed"}}`)
+	if want := `SELECT count(*) FROM "sms_history" WHERE "sms_history"."deleted_at" IS NULL AND "sms_history"."status" = $1`; fake.LastQuery() != want {
+		t.Errorf("Unexpected count query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+	execute("aggregate_sms_history", `{"sum": ["cost"]}`)
+	if want := `SELECT count(*), sum("sms_history"."cost") FROM "sms_history" WHERE "sms_history"."deleted_at" IS NULL`; fake.LastQuery() != want {
+		t.Errorf("Unexpected aggregate query:\n got %s\nwant %s", fake.LastQuery(), want)
+	}
+}
+
+func TestOriginMatcher(t *testing.T) {
+	matcher, err := newOriginMatcher([]string{
+		"https://app.brivas.com",
+		"https://*.brivas.io",
+		"http://localhost:3000",
+	})
+	if err != nil {
+		t.Fatalf("newOriginMatcher failed: %v", err)
+	}
+
+	tests := []struct {
+		origin   string
+		expected bool
+	}{
+		{"https://app.brivas.com", true},
+		{"https://APP.brivas.com", true},
+		{"http://app.brivas.com", false},
+		{"https://evil.com", false},
+		{"https://console.brivas.io", true},
+		{"https://a.b.brivas.io", true},
+		{"https://brivas.io", false},
+		{"https://evilbrivas.io", false},
+		{"http://console.brivas.io", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:4000", false},
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		if result := matcher.Allowed(tc.origin); result != tc.expected {
+			t.Errorf("Allowed(%q) = %v, expected %v", tc.origin, result, tc.expected)
+		}
+	}
+}
+
+func TestCORSConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *Config
+		wantErr bool
+	}{
+		{"wildcard without credentials", &Config{AllowedOrigins: []string{"*"}}, false},
+		{"wildcard with credentials", &Config{AllowedOrigins: []string{"*"}, AllowCredentials: true}, true},
+		{"explicit with credentials", &Config{AllowedOrigins: []string{"https://*.brivas.com"}, AllowCredentials: true}, false},
+		{"no origins", &Config{}, false},
+		{"origin with path", &Config{AllowedOrigins: []string{"https://brivas.com/app"}}, true},
+		{"inner wildcard", &Config{AllowedOrigins: []string{"https://app.*.com"}}, true},
+		{"route policy with credentials and wildcard", &Config{
+			AllowedOrigins: []string{"https://brivas.com"},
+			CORSPolicies: []CORSPolicy{
+				{PathPrefix: "/mcp", AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			},
+		}, true},
+	}
+
+	for _, tc := range tests {
+		_, err := newCORSPolicies(tc.cfg)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: newCORSPolicies error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	// Without CORS or WebSockets the allow-list is never used
+	engine := NewUnifiedAPIEngine(nil, zap.NewNop())
+	engine.schema = &Schema{}
+	if err := engine.GenerateAPIs(&Config{AllowedOrigins: []string{"https://app.*.com"}}); err != nil {
+		t.Errorf("Expected the allow-list to be ignored, got %v", err)
+	}
+	if err := engine.GenerateAPIs(&Config{EnableWebSocket: true, AllowedOrigins: []string{"https://app.*.com"}}); err == nil {
+		t.Error("Expected an invalid allow-list to fail generation when WebSockets use it")
+	}
+
+	// An empty allow-list admits no cross-origin requests
+	policies, _ := newCORSPolicies(&Config{})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/graphql", nil)
+	req.Header.Set("Origin", "https://app.brivas.com")
+	policies.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no Access-Control-Allow-Origin, got %q", got)
+	}
+}
+
+func TestCORSReflectsOriginWithCredentials(t *testing.T) {
+	policies, err := newCORSPolicies(&Config{
+		AllowedOrigins:   []string{"https://*.brivas.com"},
+		AllowCredentials: true,
+		CORSPolicies: []CORSPolicy{
+			{PathPrefix: "/mcp", AllowedOrigins: []string{"https://agents.example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newCORSPolicies failed: %v", err)
+	}
+
+	handler := policies.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		path        string
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{"/graphql", "https://app.brivas.com", "https://app.brivas.com", "true"},
+		{"/graphql", "https://evil.com", "", ""},
+		{"/mcp/tools", "https://agents.example.com", "https://agents.example.com", ""},
+		{"/mcp/tools", "https://app.brivas.com", "", ""},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Origin", tc.origin)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+			t.Errorf("%s from %s: Access-Control-Allow-Origin = %q, expected %q", tc.path, tc.origin, got, tc.allowOrigin)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != tc.credentials {
+			t.Errorf("%s from %s: Access-Control-Allow-Credentials = %q, expected %q", tc.path, tc.origin, got, tc.credentials)
+		}
+	}
+}
+
+func TestWebSocketCheckOrigin(t *testing.T) {
+	handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"https://*.brivas.com"}, false, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		name   string
+		origin string
+		allow  bool
+	}{
+		{"allowed origin", "https://app.brivas.com", true},
+		{"disallowed origin", "https://evil.com", false},
+		{"missing origin", "", false},
+	}
+
+	for _, tc := range tests {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if tc.allow {
+			if err != nil {
+				t.Errorf("%s: expected upgrade to succeed, got %v", tc.name, err)
+				continue
+			}
+			conn.Close()
+			continue
+		}
+
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: expected upgrade to be rejected", tc.name)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %v", tc.name, resp)
+		}
+	}
+}
+
+func TestWebSocketAllowNoOrigin(t *testing.T) {
+	handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"https://app.brivas.com"}, true, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	if !handler.checkOrigin(req) {
+		t.Error("Expected request without Origin to be allowed when allowNoOrigin is set")
+	}
+
+	req.Header.Set("Origin", "https://evil.com")
+	if handler.checkOrigin(req) {
+		t.Error("Expected disallowed origin to be rejected even when allowNoOrigin is set")
+	}
+}
+
+func TestWebSocketSubscriptionLimit(t *testing.T) {
+	handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"*"}, true, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+	if err := handler.SetOptions(WebSocketOptions{MaxSubscriptions: 2}); err != nil {
+		t.Fatalf("SetOptions failed: %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	var reply wsMessage
+	for _, channel := range []string{"accounts", "campaigns", "sms_history"} {
+		conn.WriteJSON(wsMessage{Type: "subscribe", Channel: channel})
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+	}
+	if reply.Type != "error" || reply.Channel != "sms_history" {
+		t.Errorf("Expected third subscription to be rejected, got %+v", reply)
+	}
+	if stats := handler.Stats(); stats.Connections != 1 || stats.Subscriptions != 2 {
+		t.Errorf("Expected 1 connection and 2 subscriptions, got %+v", stats)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.Stats().Connections != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := handler.Stats(); stats.Connections != 0 || stats.Subscriptions != 0 {
+		t.Errorf("Expected counters to reset on disconnect, got %+v", stats)
+	}
+}
+
+func TestWebSocketChannelPermissions(t *testing.T) {
+	handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"*"}, true, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+	handler.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+
+	// The role comes from the query string in place of the auth middleware
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if role := r.URL.Query().Get("role"); role != "" {
+			ctx = context.WithValue(ctx, "claims", &auth.Claims{AccountID: "acc_1", Role: auth.Role(role)})
+		}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	dial := func(role string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?role="+role, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		return conn
+	}
+	subscribe := func(conn *websocket.Conn, channel string) wsMessage {
+		var reply wsMessage
+		conn.WriteJSON(wsMessage{Type: "subscribe", Channel: channel})
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		return reply
+	}
+
+	admin, user, anonymous := dial(string(auth.RoleAdmin)), dial(string(auth.RoleUser)), dial("")
+	defer admin.Close()
+	defer user.Close()
+	defer anonymous.Close()
+
+	if reply := subscribe(admin, "accounts"); reply.Type != "subscribed" {
+		t.Errorf("Expected an admin to subscribe to accounts, got %+v", reply)
+	}
+	// Users read campaigns through a row filter the events would bypass
+	if reply := subscribe(user, "campaigns"); reply.Type != "error" || !strings.Contains(string(reply.Payload), "GraphQL subscription") {
+		t.Errorf("Expected a filtered table to be refused, got %+v", reply)
+	}
+	if reply := subscribe(user, "accounts"); reply.Type != "error" {
+		t.Errorf("Expected a user to be refused accounts, got %+v", reply)
+	}
+	if reply := subscribe(anonymous, "accounts"); reply.Type != "error" {
+		t.Errorf("Expected an anonymous client to be refused, got %+v", reply)
+	}
+	if stats := handler.Stats(); stats.Subscriptions != 1 {
+		t.Errorf("Expected only the admin's subscription, got %+v", stats)
+	}
+
+	handler.relayChange(changeChannel("accounts"), `{"table":"accounts","operation":"update","primary_key":"acc_2"}`)
+	var msg wsMessage
+	admin.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := admin.ReadJSON(&msg); err != nil || msg.Type != "data" || msg.Channel != "accounts" {
+		t.Errorf("Expected the admin to receive the change, got %+v (%v)", msg, err)
+	}
+	user.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if err := user.ReadJSON(&msg); err == nil {
+		t.Errorf("Expected the user to receive nothing, got %+v", msg)
+	}
+}
+
+func TestWebSocketSlowClientPolicy(t *testing.T) {
+	handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"*"}, false, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+	if err := handler.SetOptions(WebSocketOptions{SlowClientPolicy: "block"}); err == nil {
+		t.Error("Expected an unknown slow client policy to be rejected")
+	}
+
+	for _, policy := range []string{SlowClientDrop, SlowClientDisconnect} {
+		handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"*"}, false, zap.NewNop())
+		if err != nil {
+			t.Fatalf("NewWebSocketHandler failed: %v", err)
+		}
+		if err := handler.SetOptions(WebSocketOptions{SendBuffer: 1, SlowClientPolicy: policy}); err != nil {
+			t.Fatalf("SetOptions failed: %v", err)
+		}
+
+		// A client whose writer is not running never drains its queue
+		client := &wsClient{
+			send:          make(chan []byte, 1),
+			done:          make(chan struct{}),
+			subscriptions: map[string]struct{}{"accounts": {}},
+		}
+		handler.clients.Store("slow", client)
+
+		handler.Broadcast("accounts", map[string]string{"id": "1"})
+		handler.Broadcast("accounts", map[string]string{"id": "2"})
+
+		if stats := handler.Stats(); stats.Dropped != 1 {
+			t.Errorf("%s: expected 1 dropped message, got %d", policy, stats.Dropped)
+		}
+
+		select {
+		case <-client.done:
+			if policy == SlowClientDrop {
+				t.Errorf("%s: client should stay connected", policy)
+			}
+		default:
+			if policy == SlowClientDisconnect {
+				t.Errorf("%s: client should have been disconnected", policy)
+			}
+		}
+	}
+}
+
+func TestChangeNotifications(t *testing.T) {
+	type published struct{ channel, payload string }
+	var sent []published
+
+	notifier := &ChangeNotifier{
+		tables: map[string]bool{"campaigns": true},
+		notify: func(ctx context.Context, channel, payload string) error {
+			sent = append(sent, published{channel, payload})
+			return nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	notifier.Notify(context.Background(), "campaigns", ChangeUpdate, "cmp_1")
+	notifier.Notify(context.Background(), "sms_history", ChangeInsert, "msg_1")
+
+	if len(sent) != 1 {
+		t.Fatalf("Expected only the opted-in table to notify, got %d events", len(sent))
+	}
+	if sent[0].channel != ChangeChannelPrefix+"campaigns" {
+		t.Errorf("Expected channel %q, got %q", ChangeChannelPrefix+"campaigns", sent[0].channel)
+	}
+
+	// A nil notifier, used when no table opted in, is a no-op
+	var disabled *ChangeNotifier
+	disabled.Notify(context.Background(), "campaigns", ChangeDelete, "cmp_1")
+
+	handler, err := NewWebSocketHandler(nil, &Schema{}, []string{"*"}, false, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+	subscriber := &wsClient{
+		send:          make(chan []byte, 1),
+		done:          make(chan struct{}),
+		subscriptions: map[string]struct{}{"campaigns": {}},
+	}
+	bystander := &wsClient{
+		send:          make(chan []byte, 1),
+		done:          make(chan struct{}),
+		subscriptions: map[string]struct{}{"accounts": {}},
+	}
+	handler.clients.Store("subscriber", subscriber)
+	handler.clients.Store("bystander", bystander)
+
+	handler.relayChange(sent[0].channel, sent[0].payload)
+
+	select {
+	case data := <-subscriber.send:
+		var msg wsMessage
+		json.Unmarshal(data, &msg)
+		var event ChangeEvent
+		json.Unmarshal(msg.Payload, &event)
+		if msg.Channel != "campaigns" || event.Operation != ChangeUpdate || event.PrimaryKey != "cmp_1" {
+			t.Errorf("Unexpected change message: %s", data)
+		}
+	default:
+		t.Fatal("Expected subscriber to receive the change event")
+	}
+	if len(bystander.send) != 0 {
+		t.Error("Expected client subscribed to another table to receive nothing")
+	}
+}
+
+func TestGraphQLSubscriptions(t *testing.T) {
+	var mu sync.Mutex
+	var rowQueries []string
+	var rowArgs [][]driver.NamedValue
+	// Rows are returned as the database would: only when their account
+	// is the one the row filter binds last
+	accounts := map[driver.Value]string{"cmp_1": "acc_1", "cmp_2": "acc_2"}
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		mu.Lock()
+		rowQueries = append(rowQueries, query)
+		rowArgs = append(rowArgs, args)
+		mu.Unlock()
+		rows := &lumadbtest.Rows{Columns: []string{"id", "status"}, Types: []string{"VARCHAR", "VARCHAR"}}
+		if len(args) > 1 && accounts[args[0].Value] == args[len(args)-1].Value {
+			rows.Data = [][]driver.Value{{args[0].Value, "active"}}
+		}
+		return rows, nil
+	})
+	schema := &Schema{
+		Tables: []TableSchema{
+			{Name: "campaigns", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}, {Name: "status", Type: "varchar"}, {Name: "account_id", Type: "varchar"}}},
+			{Name: "accounts", PrimaryKey: "id", Columns: []Column{{Name: "id", Type: "varchar"}}},
+		},
+	}
+
+	graphqlAPI := NewGraphQLHandler(db, schema, zap.NewNop())
+	graphqlAPI.SetChangeNotifier(&ChangeNotifier{tables: map[string]bool{"campaigns": true}, logger: zap.NewNop()})
+	graphqlAPI.SetPermissions(auth.NewAuthorizationEngine(nil, "secret", zap.NewNop()))
+	wsAPI, err := NewWebSocketHandler(db, schema, []string{"*"}, true, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+	wsAPI.SetGraphQLHandler(graphqlAPI)
+	graphqlAPI.SetWebSocketHandler(wsAPI)
+
+	// Upgrade on the GraphQL endpoint as an authenticated user
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := &auth.Claims{AccountID: "acc_1", Role: auth.RoleUser}
+		graphqlAPI.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "claims", claims)))
+	}))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{GraphQLWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != GraphQLWSProtocol {
+		t.Fatalf("Expected subprotocol %q, got %q", GraphQLWSProtocol, conn.Subprotocol())
+	}
+
+	var msg graphQLWSMessage
+	conn.WriteJSON(graphQLWSMessage{Type: gqlConnectionInit})
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != gqlConnectionAck {
+		t.Fatalf("Expected connection_ack, got %+v (%v)", msg, err)
+	}
+
+	// Tables without published changes cannot be subscribed to
+	conn.WriteJSON(graphQLWSMessage{ID: "1", Type: gqlSubscribe, Payload: json.RawMessage(`{"query":"subscription { accounts { id } }"}`)})
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != gqlError || msg.ID != "1" {
+		t.Fatalf("Expected an error for an unpublished table, got %+v (%v)", msg, err)
+	}
+
+	// Raw SQL could escape the row filter
+	conn.WriteJSON(graphQLWSMessage{ID: "1", Type: gqlSubscribe, Payload: json.RawMessage(`{"query":"subscription { campaigns(where: \"true) OR true OR (true\") { id status } }"}`)})
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != gqlError || msg.ID != "1" {
+		t.Fatalf("Expected an error for a raw SQL filter, got %+v (%v)", msg, err)
+	}
+
+	conn.WriteJSON(graphQLWSMessage{ID: "2", Type: gqlSubscribe, Payload: json.RawMessage(`{"query":"subscription { campaigns(where: \"{\\\"status\\\": \\\"active\\\"}\") { id status } }"}`)})
+	watching := func() int {
+		graphqlAPI.watchMu.Lock()
+		defer graphqlAPI.watchMu.Unlock()
+		return len(graphqlAPI.watchers)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for watching() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if watching() != 1 {
+		t.Fatal("Expected the subscription to watch campaigns")
+	}
+
+	// Deletes, other tables and other accounts' rows are not streamed
+	wsAPI.relayChange(changeChannel("campaigns"), `{"table":"campaigns","operation":"delete","primary_key":"cmp_9"}`)
+	wsAPI.relayChange(changeChannel("campaigns"), `{"table":"campaigns","operation":"update","primary_key":"cmp_2"}`)
+	wsAPI.relayChange(changeChannel("accounts"), `{"table":"accounts","operation":"update","primary_key":"acc_1"}`)
+	wsAPI.relayChange(changeChannel("campaigns"), `{"table":"campaigns","operation":"update","primary_key":"cmp_1"}`)
+
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != gqlNext || msg.ID != "2" {
+		t.Fatalf("Expected next, got %+v (%v)", msg, err)
+	}
+	var result struct {
+		Data map[string]map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(msg.Payload, &result)
+	if row := result.Data["campaigns"]; row["id"] != "cmp_1" || row["status"] != "active" {
+		t.Errorf("Unexpected streamed row: %s", msg.Payload)
+	}
+
+	mu.Lock()
+	if len(rowQueries) != 2 {
+		t.Fatalf("Expected two row reads, got %v", rowQueries)
+	}
+	wantQuery := `SELECT * FROM "campaigns" WHERE "campaigns"."id" = $1 AND "campaigns"."status" = $2 AND "campaigns"."account_id" = $3`
+	if rowQueries[1] != wantQuery {
+		t.Errorf("Unexpected row query:\n got %s\nwant %s", rowQueries[1], wantQuery)
+	}
+	if args := rowArgs[1]; len(args) != 3 || args[0].Value != "cmp_1" || args[1].Value != "active" || args[2].Value != "acc_1" {
+		t.Errorf("Expected the key, the filter and the caller's account bound, got %+v", args)
+	}
+	mu.Unlock()
+
+	// Completing the subscription stops it without a server complete
+	conn.WriteJSON(graphQLWSMessage{ID: "2", Type: gqlComplete})
+	deadline = time.Now().Add(2 * time.Second)
+	for (watching() != 0 || wsAPI.Stats().Subscriptions != 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if watching() != 0 || wsAPI.Stats().Subscriptions != 0 {
+		t.Errorf("Expected the subscription to end, %d watchers and %+v", watching(), wsAPI.Stats())
+	}
+
+	// Queries run once and complete
+	conn.WriteJSON(graphQLWSMessage{ID: "3", Type: gqlSubscribe, Payload: json.RawMessage(`{"query":"{ campaigns(id: \"cmp_1\") { id } }"}`)})
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != gqlNext || msg.ID != "3" {
+		t.Fatalf("Expected next for a query, got %+v (%v)", msg, err)
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != gqlComplete || msg.ID != "3" {
+		t.Fatalf("Expected complete for a query, got %+v (%v)", msg, err)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	opts := DefaultRequestLogOptions()
+	opts.SampleEvery = 10
+
+	var downstreamTraceID string
+	handler := RequestLogger(zap.New(core), opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate the auth middleware resolving claims into headers
+		r.Header.Set("X-Account-ID", "BV123456789")
+		r.Header.Set("X-Role", "user")
+		downstreamTraceID = TraceIDFromContext(r.Context())
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest("GET", "/api/v1/accounts", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-API-Key", "lk_secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if logs.Len() != 1 {
+		t.Fatalf("Expected 1 log entry, got %d", logs.Len())
+	}
+	fields := logs.All()[0].ContextMap()
+	if fields["account_id"] != "BV123456789" || fields["role"] != "user" {
+		t.Errorf("Expected account and role in log, got %v / %v", fields["account_id"], fields["role"])
+	}
+	if fields["bytes"] != int64(2) {
+		t.Errorf("Expected 2 bytes logged, got %v", fields["bytes"])
+	}
+	headers := fields["headers"].(map[string]string)
+	if headers["Authorization"] != "[REDACTED]" || headers["X-Api-Key"] != "[REDACTED]" {
+		t.Errorf("Expected secrets to be redacted, got %v", headers)
+	}
+	if downstreamTraceID == "" || rr.Header().Get(TraceIDHeader) != downstreamTraceID {
+		t.Errorf("Expected trace id to be propagated, got %q and %q", downstreamTraceID, rr.Header().Get(TraceIDHeader))
+	}
+
+	// Health checks are sampled
+	for i := 0; i < 20; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	}
+	if logs.Len() != 3 {
+		t.Errorf("Expected 2 sampled health check entries, got %d", logs.Len()-1)
+	}
+}
+
+func TestTracingPropagatesTraceID(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceID, traceparent string
+	handler := Tracing(RequestLogger(zap.NewNop(), DefaultRequestLogOptions())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceID = TraceIDFromContext(r.Context())
+			traceparent = r.Header.Get("traceparent")
+		}),
+	))
+
+	req := httptest.NewRequest("GET", "/graphql", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected incoming trace id to be reused, got %q", traceID)
+	}
+	if !strings.Contains(traceparent, traceID) {
+		t.Errorf("Expected traceparent to be propagated downstream, got %q", traceparent)
+	}
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	limit := RateLimit{RequestsPerSecond: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if result, _ := limiter.Allow(context.Background(), "user:1", limit); !result.Allowed {
+			t.Fatalf("Request %d should be allowed within burst", i+1)
+		}
+	}
+
+	result, _ := limiter.Allow(context.Background(), "user:1", limit)
+	if result.Allowed {
+		t.Fatal("Request beyond burst should be denied")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("Expected 1s retry-after, got %v", result.RetryAfter)
+	}
+
+	// Other accounts have their own bucket
+	if result, _ := limiter.Allow(context.Background(), "user:2", limit); !result.Allowed {
+		t.Error("Separate account should not share a bucket")
+	}
+
+	now = now.Add(time.Second)
+	if result, _ := limiter.Allow(context.Background(), "user:1", limit); !result.Allowed {
+		t.Error("Request should be allowed after refill")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limits := map[string]RateLimit{
+		"anonymous": {RequestsPerSecond: 1, Burst: 1},
+		"user":      {RequestsPerSecond: 1, Burst: 3},
+		"admin":     {},
+	}
+	handler := RateLimitMiddleware(NewMemoryRateLimiter(), limits, zap.NewNop())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+
+	send := func(accountID, role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/accounts", nil)
+		if accountID != "" {
+			claims := &auth.Claims{AccountID: accountID, Role: auth.Role(role)}
+			req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("", ""); rr.Code != http.StatusOK {
+		t.Errorf("First anonymous request should pass, got %d", rr.Code)
+	}
+	rr := send("", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Second anonymous request should be limited, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "1" || rr.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("Expected Retry-After and X-RateLimit headers, got %v", rr.Header())
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := send("BV1", "user"); rr.Code != http.StatusOK {
+			t.Errorf("User request %d should pass, got %d", i+1, rr.Code)
+		}
+	}
+	if rr := send("BV1", "user"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("User should be limited after burst, got %d", rr.Code)
+	}
+
+	for i := 0; i < 10; i++ {
+		if rr := send("BV2", "admin"); rr.Code != http.StatusOK {
+			t.Fatalf("Unlimited role should never be limited, got %d", rr.Code)
+		}
+	}
+
+	// Identity headers are the client's own and never trusted
+	req := httptest.NewRequest("GET", "/api/v1/accounts", nil)
+	req.Header.Set("X-Account-ID", "BV3")
+	req.Header.Set("X-Role", "admin")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected headers claiming an unlimited role to be limited as anonymous, got %d", rr.Code)
+	}
+}
+
+// memoryIdempotencyStore is an in-process IdempotencyStore for tests
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*IdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, key, requestHash string, lockTTL time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.records[key]; ok {
+		copied := *rec
+		return &copied, false, nil
+	}
+	s.records[key] = &IdempotencyRecord{RequestHash: requestHash}
+	return nil, true, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = rec
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	var calls int32
+
+	handler := IdempotencyMiddleware(store, DefaultIdempotencyOptions(), zap.NewNop())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&calls, 1)
+			time.Sleep(20 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":%d}`, n)
+		}),
+	)
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/campaigns", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "claims", &auth.Claims{AccountID: "BV123456789", Role: auth.RoleUser}))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Concurrent duplicates run the handler once and share its response
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = send("key-1", `{"name":"launch"}`)
+		}(i)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expected handler to run once, ran %d times", calls)
+	}
+	for _, rr := range responses {
+		if rr.Code != http.StatusCreated || rr.Body.String() != `{"id":1}` {
+			t.Errorf("Expected replayed 201 {\"id\":1}, got %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := send("key-1", `{"name":"other"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for reused key with different body, got %d", rr.Code)
+	}
+
+	if rr := send("key-2", `{"name":"launch"}`); rr.Body.String() != `{"id":2}` {
+		t.Errorf("Expected a new key to run the handler, got %s", rr.Body.String())
+	}
+
+	// Requests without a key are not deduplicated
+	req := httptest.NewRequest("POST", "/api/v1/campaigns", strings.NewReader(`{}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Expected request without key to reach handler, calls = %d", calls)
+	}
+
+	// Anonymous callers are never replayed, whatever account they claim
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/campaigns", strings.NewReader(`{"name":"launch"}`))
+		req.Header.Set("X-Account-ID", "BV123456789")
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if atomic.LoadInt32(&calls) != 5 {
+		t.Errorf("Expected anonymous requests to reach handler, calls = %d", calls)
+	}
+}
+
+// Benchmark tests
+func BenchmarkToCamelCase(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		toCamelCase("account_id_test_value")
+	}
+}
+
+func BenchmarkToPascalCase(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		toPascalCase("account_id_test_value")
+	}
+}
+
+// wideSchema generates a schema of n tables of ten columns each
+func wideSchema(n int) *Schema {
+	schema := &Schema{Name: "public"}
+	for i := 0; i < n; i++ {
+		table := TableSchema{Name: fmt.Sprintf("table_%03d", i), PrimaryKey: "id"}
+		table.Columns = append(table.Columns, Column{Name: "id", Type: "uuid"})
+		for j := 1; j < 10; j++ {
+			table.Columns = append(table.Columns, Column{Name: fmt.Sprintf("column_%d", j), Type: "text", Nullable: true})
+		}
+		schema.Tables = append(schema.Tables, table)
+	}
+	return schema
+}
+
+func BenchmarkGraphQLSchemaBuild(b *testing.B) {
+	schema := wideSchema(200)
+
+	b.Run("rebuild", func(b *testing.B) {
+		handler := &GraphQLHandler{logger: zap.NewNop()}
+		for i := 0; i < b.N; i++ {
+			if _, err := handler.buildSchema(schema); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unchanged", func(b *testing.B) {
+		handler := NewGraphQLHandler(nil, schema, zap.NewNop())
+		for i := 0; i < b.N; i++ {
+			if handler.SetSchema(schema) {
+				b.Fatal("rebuilt an unchanged schema")
+			}
+		}
+	})
+}
+
+// heapWriter is a response writer discarding the body and recording the
+// most heap in use at any write, which is when a response peaks
+type heapWriter struct {
+	header http.Header
+	peak   uint64
+}
+
+func (w *heapWriter) Header() http.Header { return w.header }
+func (w *heapWriter) WriteHeader(int)     {}
+
+func (w *heapWriter) Write(p []byte) (int, error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	w.peak = max(w.peak, m.HeapAlloc)
+	return len(p), nil
+}
+
+func BenchmarkListResponse(b *testing.B) {
+	const n = 50000
+	data := make([][]driver.Value, n)
+	for i := range data {
+		data[i] = []driver.Value{fmt.Sprintf("msg_%06d", i), "+2348012345678", "delivered", int64(i), time.Unix(int64(i), 0)}
+	}
+	db, _ := lumadbtest.New(func(string, []driver.NamedValue) (*lumadbtest.Rows, error) {
+		return &lumadbtest.Rows{
+			Columns: []string{"id", "recipient", "status", "segments", "sent_at"},
+			Types:   []string{"VARCHAR", "VARCHAR", "VARCHAR", "INT8", "TIMESTAMPTZ"},
+			Data:    data,
+		}, nil
+	})
+	handler := NewRESTHandler(db, &Schema{}, zap.NewNop())
+	page := Pagination{Limit: n}
+
+	run := func(b *testing.B, write func(w http.ResponseWriter, rows *lumadb.Rows)) {
+		b.ReportAllocs()
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+			var before runtime.MemStats
+			runtime.ReadMemStats(&before)
+			rows, err := db.Query(context.Background(), "SELECT * FROM sms_history")
+			if err != nil {
+				b.Fatal(err)
+			}
+			w := &heapWriter{header: make(http.Header)}
+			write(w, rows)
+			rows.Close()
+			if w.peak > before.HeapAlloc {
+				peak = max(peak, w.peak-before.HeapAlloc)
+			}
+		}
+		b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+	}
+
+	b.Run("buffered", func(b *testing.B) {
+		run(b, func(w http.ResponseWriter, rows *lumadb.Rows) {
+			results, err := scanRowsToMaps(rows)
+			if err != nil {
+				b.Fatal(err)
+			}
+			handler.jsonResponse(w, ListResponse{Data: results, Pagination: page}, http.StatusOK)
+		})
+	})
+	b.Run("streamed", func(b *testing.B) {
+		run(b, func(w http.ResponseWriter, rows *lumadb.Rows) {
+			handler.writeListPage(w, nil, rows, page)
+		})
+	})
 }
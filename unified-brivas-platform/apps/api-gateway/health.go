@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthChecker is implemented by subsystems the engine reports on, such
+// as a service's background workers or the LLM providers. HealthCheck
+// returns nil when the subsystem is up.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthCheckFunc adapts a function to HealthChecker
+type HealthCheckFunc func(ctx context.Context) error
+
+// HealthCheck calls f
+func (f HealthCheckFunc) HealthCheck(ctx context.Context) error {
+	return f(ctx)
+}
+
+// healthCheckTimeout bounds each component check
+const healthCheckTimeout = 2 * time.Second
+
+// Component statuses
+const (
+	ComponentUp   = "up"
+	ComponentDown = "down"
+)
+
+// ComponentHealth is one subsystem's entry in the health report
+type ComponentHealth struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type registeredCheck struct {
+	checker  HealthChecker
+	critical bool
+}
+
+// RegisterHealthCheck adds a subsystem to the detailed health report,
+// GET /health?detail=true. While a critical subsystem is down /ready
+// fails, so the instance is taken out of rotation; the others only show
+// as degraded. Registering a name again replaces its check. The database
+// is registered as critical by NewUnifiedAPIEngine.
+func (e *UnifiedAPIEngine) RegisterHealthCheck(name string, checker HealthChecker, critical bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.healthChecks == nil {
+		e.healthChecks = make(map[string]registeredCheck)
+	}
+	e.healthChecks[name] = registeredCheck{checker: checker, critical: critical}
+}
+
+// checkComponents runs the registered checks concurrently, only the
+// critical ones when criticalOnly is set
+func (e *UnifiedAPIEngine) checkComponents(ctx context.Context, criticalOnly bool) map[string]ComponentHealth {
+	e.mu.RLock()
+	checks := make(map[string]registeredCheck, len(e.healthChecks))
+	for name, check := range e.healthChecks {
+		if check.critical || !criticalOnly {
+			checks[name] = check
+		}
+	}
+	e.mu.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]ComponentHealth, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check registeredCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.checker.HealthCheck(ctx)
+			result := ComponentHealth{
+				Status:    ComponentUp,
+				Critical:  check.critical,
+				LatencyMS: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				result.Status = ComponentDown
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// criticalDown lists the critical components that are down, sorted
+func criticalDown(components map[string]ComponentHealth) []string {
+	var down []string
+	for name, c := range components {
+		if c.Critical && c.Status == ComponentDown {
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+	return down
+}
+
+// healthCheck is the liveness probe. Without ?detail=true it touches
+// nothing outside the process, so a struggling dependency cannot get the
+// instance restarted. The detailed report checks every registered
+// subsystem: it is "degraded" when only non-critical ones are down and
+// "unhealthy", with a 503, when a critical one is.
+func (e *UnifiedAPIEngine) healthCheck(w http.ResponseWriter, r *http.Request) {
+	health := map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now().UTC(),
+		"version":   "1.0.0",
+	}
+	if e.websocketAPI != nil {
+		health["websocket"] = e.websocketAPI.Stats()
+	}
+
+	status := http.StatusOK
+	if r.URL.Query().Get("detail") == "true" {
+		components := e.checkComponents(r.Context(), false)
+		health["components"] = components
+		for _, c := range components {
+			if c.Status == ComponentDown {
+				health["status"] = "degraded"
+			}
+		}
+		if len(criticalDown(components)) > 0 {
+			health["status"] = "unhealthy"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// readinessCheck reports ready once the schema is loaded and while every
+// critical subsystem is up
+func (e *UnifiedAPIEngine) readinessCheck(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	tables := 0
+	if e.schema != nil {
+		tables = len(e.schema.Tables)
+	}
+	e.mu.RUnlock()
+
+	components := e.checkComponents(r.Context(), true)
+	down := criticalDown(components)
+	ready := map[string]interface{}{
+		"ready":      tables > 0 && len(down) == 0,
+		"tables":     tables,
+		"components": components,
+	}
+	if len(down) > 0 {
+		ready["down"] = down
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready["ready"].(bool) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(ready)
+}
@@ -0,0 +1,301 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// IdempotencyKeyHeader is the client-supplied key that makes a mutation safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyRecord is a stored mutation response
+type IdempotencyRecord struct {
+	RequestHash string
+	Completed   bool
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyStore persists the first response for each idempotency key.
+// Reserve atomically claims a key; when the key is already held it returns
+// the existing record and reserved=false.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, key, requestHash string, lockTTL time.Duration) (rec *IdempotencyRecord, reserved bool, err error)
+	Complete(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyOptions configures the idempotency middleware
+type IdempotencyOptions struct {
+	TTL          time.Duration // how long completed responses are replayed
+	LockTTL      time.Duration // how long an in-flight claim is honoured
+	PollInterval time.Duration // wait between checks for a duplicate in flight
+	MaxBodyBytes int64
+}
+
+// DefaultIdempotencyOptions returns default idempotency settings
+func DefaultIdempotencyOptions() IdempotencyOptions {
+	return IdempotencyOptions{
+		TTL:          24 * time.Hour,
+		LockTTL:      time.Minute,
+		PollInterval: 100 * time.Millisecond,
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// IdempotencyMiddleware replays the stored response for unsafe requests that
+// repeat an Idempotency-Key for the same account, method and path. A
+// duplicate arriving while the first is in flight waits for it to finish.
+// The account is the one the auth middleware in front resolved; requests
+// of anonymous callers are never replayed, since nothing tells them apart.
+func IdempotencyMiddleware(store IdempotencyStore, opts IdempotencyOptions, logger *zap.Logger) func(http.Handler) http.Handler {
+	locks := newKeyedMutex()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idemKey := r.Header.Get(IdempotencyKeyHeader)
+			accountID := requestClaims(r.Context()).AccountID
+			if idemKey == "" || accountID == "" || !isUnsafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, opts.MaxBodyBytes+1))
+			if err != nil || int64(len(body)) > opts.MaxBodyBytes {
+				apierr.Write(w, r, "request body too large for idempotent replay", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := hashParts(accountID, r.Method, r.URL.Path, idemKey)
+			requestHash := hashParts(string(body))
+
+			// Serialize duplicates within this replica; the store handles
+			// duplicates across replicas
+			unlock := locks.Lock(key)
+			defer unlock()
+
+			ctx := r.Context()
+			for {
+				rec, reserved, err := store.Reserve(ctx, key, requestHash, opts.LockTTL)
+				if err != nil {
+					logger.Error("idempotency store unavailable", zap.Error(err))
+					apierr.Write(w, r, "idempotency check failed", http.StatusServiceUnavailable)
+					return
+				}
+
+				if reserved {
+					serveAndStore(w, r, next, store, key, requestHash, opts.TTL, logger)
+					return
+				}
+
+				if rec.RequestHash != requestHash {
+					apierr.Write(w, r, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+					return
+				}
+
+				if rec.Completed {
+					if rec.ContentType != "" {
+						w.Header().Set("Content-Type", rec.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(rec.Status)
+					w.Write(rec.Body)
+					return
+				}
+
+				// Another replica is still processing this key
+				select {
+				case <-ctx.Done():
+					apierr.Write(w, r, "request with this Idempotency-Key is still in progress", http.StatusConflict)
+					return
+				case <-time.After(opts.PollInterval):
+				}
+			}
+		})
+	}
+}
+
+func serveAndStore(w http.ResponseWriter, r *http.Request, next http.Handler, store IdempotencyStore, key, requestHash string, ttl time.Duration, logger *zap.Logger) {
+	var buf bytes.Buffer
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	ww.Tee(&buf)
+
+	completed := false
+	defer func() {
+		if !completed {
+			// Let a retry run again rather than replaying a failure
+			if err := store.Release(context.Background(), key); err != nil {
+				logger.Warn("failed to release idempotency key", zap.Error(err))
+			}
+		}
+	}()
+
+	next.ServeHTTP(ww, r)
+
+	status := ww.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= http.StatusInternalServerError {
+		return
+	}
+
+	err := store.Complete(context.Background(), key, &IdempotencyRecord{
+		RequestHash: requestHash,
+		Completed:   true,
+		Status:      status,
+		ContentType: ww.Header().Get("Content-Type"),
+		Body:        buf.Bytes(),
+	}, ttl)
+	if err != nil {
+		logger.Warn("failed to store idempotent response", zap.Error(err))
+		return
+	}
+	completed = true
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// keyedMutex hands out one mutex per key, dropping it once unused
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*refMutex)}
+}
+
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// LumaDBIdempotencyStore persists idempotency records in the
+// idempotency_keys table so they survive restarts and are shared by replicas
+type LumaDBIdempotencyStore struct {
+	db *lumadb.Client
+}
+
+// NewLumaDBIdempotencyStore creates a LumaDB-backed idempotency store
+func NewLumaDBIdempotencyStore(db *lumadb.Client) *LumaDBIdempotencyStore {
+	return &LumaDBIdempotencyStore{db: db}
+}
+
+// Reserve claims key unless an unexpired record already holds it
+func (s *LumaDBIdempotencyStore) Reserve(ctx context.Context, key, requestHash string, lockTTL time.Duration) (*IdempotencyRecord, bool, error) {
+	var id int64
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO idempotency_keys (cache_key, request_hash, status, expires_at)
+		VALUES ($1, $2, 'in_progress', NOW() + $3 * INTERVAL '1 second')
+		ON CONFLICT (cache_key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status = 'in_progress',
+			response_status = NULL,
+			content_type = NULL,
+			response_body = NULL,
+			created_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		RETURNING id
+	`, key, requestHash, lockTTL.Seconds()).Scan(&id)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	rec := &IdempotencyRecord{}
+	var status string
+	var responseStatus sql.NullInt64
+	var contentType sql.NullString
+	err = s.db.QueryRow(ctx, `
+		SELECT request_hash, status, response_status, content_type, response_body
+		FROM idempotency_keys WHERE cache_key = $1
+	`, key).Scan(&rec.RequestHash, &status, &responseStatus, &contentType, &rec.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the insert and the read; try again
+		return &IdempotencyRecord{RequestHash: requestHash}, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+
+	rec.Completed = status == "completed"
+	rec.Status = int(responseStatus.Int64)
+	rec.ContentType = contentType.String
+	return rec, false, nil
+}
+
+// Complete stores the response for key and keeps it for ttl
+func (s *LumaDBIdempotencyStore) Complete(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status = 'completed', response_status = $2, content_type = $3, response_body = $4,
+			expires_at = NOW() + $5 * INTERVAL '1 second'
+		WHERE cache_key = $1
+	`, key, rec.Status, rec.ContentType, rec.Body, ttl.Seconds())
+	return err
+}
+
+// Release drops an in-flight claim so the request can be retried
+func (s *LumaDBIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.Exec(ctx,
+		"DELETE FROM idempotency_keys WHERE cache_key = $1 AND status = 'in_progress'", key)
+	return err
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// foreignKey is a single-column foreign key constraint
+type foreignKey struct {
+	Name         string
+	Table        string
+	Column       string
+	TargetTable  string
+	TargetColumn string
+}
+
+var indexDefPattern = regexp.MustCompile(`(?i)^CREATE (UNIQUE )?INDEX .* USING \w+ \((.*)\)`)
+
+// loadIndexes fills Indexes for every table from pg_indexes
+func (e *UnifiedAPIEngine) loadIndexes(ctx context.Context, schema *Schema) error {
+	rows, err := e.db.Query(ctx, `
+		SELECT tablename, indexname, indexdef
+		FROM pg_indexes
+		WHERE schemaname = $1
+		ORDER BY tablename, indexname
+	`, schema.Name)
+	if err != nil {
+		return fmt.Errorf("failed to query indexes: %w", err)
+	}
+	defer rows.Close()
+
+	byTable := make(map[string][]Index)
+	for rows.Next() {
+		var table, name, def string
+		if err := rows.Scan(&table, &name, &def); err != nil {
+			return fmt.Errorf("failed to scan index: %w", err)
+		}
+		unique, columns, ok := parseIndexDef(def)
+		if !ok {
+			continue
+		}
+		byTable[table] = append(byTable[table], Index{Name: name, Columns: columns, Unique: unique})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range schema.Tables {
+		schema.Tables[i].Indexes = byTable[schema.Tables[i].Name]
+	}
+	return nil
+}
+
+// parseIndexDef extracts uniqueness and key columns from a pg_indexes
+// definition. Expression and partial index keys are returned verbatim.
+func parseIndexDef(def string) (bool, []string, bool) {
+	// Drop INCLUDE and WHERE clauses, which follow the key list
+	if i := strings.Index(strings.ToUpper(def), ") INCLUDE ("); i >= 0 {
+		def = def[:i+1]
+	}
+	if i := strings.Index(strings.ToUpper(def), ") WHERE "); i >= 0 {
+		def = def[:i+1]
+	}
+
+	m := indexDefPattern.FindStringSubmatch(def)
+	if m == nil {
+		return false, nil, false
+	}
+
+	var columns []string
+	for _, col := range strings.Split(m[2], ",") {
+		col = strings.Trim(strings.TrimSpace(col), `"`)
+		// Strip ordering options such as "created_at DESC"
+		if fields := strings.Fields(col); len(fields) > 1 && !strings.Contains(col, "(") {
+			col = strings.Trim(fields[0], `"`)
+		}
+		columns = append(columns, col)
+	}
+	return m[1] != "", columns, true
+}
+
+// loadRelations discovers foreign keys and records a relation on both the
+// referencing and the referenced table
+func (e *UnifiedAPIEngine) loadRelations(ctx context.Context, schema *Schema) error {
+	rows, err := e.db.Query(ctx, `
+		SELECT tc.constraint_name, tc.table_name, kcu.column_name,
+			ccu.table_name AS target_table, ccu.column_name AS target_column
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_name = tc.constraint_name AND kcu.constraint_schema = tc.constraint_schema
+		JOIN information_schema.referential_constraints rc
+			ON rc.constraint_name = tc.constraint_name AND rc.constraint_schema = tc.constraint_schema
+		JOIN information_schema.key_column_usage ccu
+			ON ccu.constraint_name = rc.unique_constraint_name
+			AND ccu.constraint_schema = rc.unique_constraint_schema
+			AND ccu.ordinal_position = kcu.position_in_unique_constraint
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = $1
+		ORDER BY tc.table_name, tc.constraint_name
+	`, schema.Name)
+	if err != nil {
+		return fmt.Errorf("failed to query foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var fks []foreignKey
+	columnCount := make(map[string]int)
+	for rows.Next() {
+		var fk foreignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.TargetTable, &fk.TargetColumn); err != nil {
+			return fmt.Errorf("failed to scan foreign key: %w", err)
+		}
+		columnCount[fk.Name]++
+		fks = append(fks, fk)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Composite foreign keys cannot be expressed as a single-column relation
+	single := fks[:0]
+	for _, fk := range fks {
+		if columnCount[fk.Name] == 1 {
+			single = append(single, fk)
+		}
+	}
+
+	buildRelations(schema, single)
+	return nil
+}
+
+// buildRelations adds the relations implied by fks. A foreign key column
+// that is unique on its own makes the relation one-to-one; otherwise the
+// referencing table has many-to-one and the referenced one one-to-many.
+func buildRelations(schema *Schema, fks []foreignKey) {
+	tables := make(map[string]*TableSchema, len(schema.Tables))
+	for i := range schema.Tables {
+		tables[schema.Tables[i].Name] = &schema.Tables[i]
+	}
+
+	for _, fk := range fks {
+		child, parent := tables[fk.Table], tables[fk.TargetTable]
+		if child == nil || parent == nil {
+			continue
+		}
+
+		oneToOne := uniqueColumn(*child, fk.Column)
+		childType, parentType := "many-to-one", "one-to-many"
+		if oneToOne {
+			childType, parentType = "one-to-one", "one-to-one"
+		}
+
+		addRelation(child, Relation{
+			Name:        strings.TrimSuffix(fk.Column, "_id"),
+			Type:        childType,
+			TargetTable: parent.Name,
+			ForeignKey:  fk.Column,
+			TargetKey:   fk.TargetColumn,
+		}, fk.TargetTable)
+
+		addRelation(parent, Relation{
+			Name:        child.Name,
+			Type:        parentType,
+			TargetTable: child.Name,
+			ForeignKey:  fk.TargetColumn,
+			TargetKey:   fk.Column,
+		}, child.Name+"_by_"+fk.Column)
+	}
+}
+
+// addRelation appends rel, falling back to altName when its name collides
+// with a column or an earlier relation
+func addRelation(table *TableSchema, rel Relation, altName string) {
+	taken := func(name string) bool {
+		if name == "" || findColumn(*table, name) != nil {
+			return true
+		}
+		for _, existing := range table.Relations {
+			if existing.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	if taken(rel.Name) {
+		rel.Name = altName
+	}
+	if taken(rel.Name) {
+		return
+	}
+	table.Relations = append(table.Relations, rel)
+}
+
+// uniqueColumn reports whether column alone is the primary key or a
+// unique index of table
+func uniqueColumn(table TableSchema, column string) bool {
+	if table.PrimaryKey == column {
+		return true
+	}
+	for _, idx := range table.Indexes {
+		if idx.Unique && len(idx.Columns) == 1 && idx.Columns[0] == column {
+			return true
+		}
+	}
+	return false
+}
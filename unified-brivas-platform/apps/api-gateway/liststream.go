@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// rowStream reads query results one row at a time, as maps of stable JSON
+// values
+type rowStream struct {
+	rows    *lumadb.Rows
+	dbTypes map[string]string
+}
+
+func newRowStream(rows *lumadb.Rows) (*rowStream, error) {
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	dbTypes := make(map[string]string, len(colTypes))
+	for _, ct := range colTypes {
+		dbTypes[ct.Name()] = ct.DatabaseTypeName()
+	}
+	return &rowStream{rows: rows, dbTypes: dbTypes}, nil
+}
+
+// next returns the next row, or nil once the rows are exhausted
+func (s *rowStream) next() (map[string]interface{}, error) {
+	if !s.rows.Next() {
+		return nil, s.rows.Err()
+	}
+	m, err := s.rows.ScanMap()
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range m {
+		m[name] = jsonValue(s.dbTypes[name], v)
+	}
+	return m, nil
+}
+
+// writeListPage writes rows as a ListResponse of at most page.Limit rows,
+// encoding each row as it is scanned so a page costs the same memory
+// whatever its size. A row past the limit sets HasMore. Once the first row
+// is written the status can no longer change, so a failure after that is
+// logged and the body left unterminated, which no client mistakes for a
+// complete page.
+func (h *RESTHandler) writeListPage(w http.ResponseWriter, r *http.Request, rows *lumadb.Rows, page Pagination) {
+	stream, err := newRowStream(rows)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	row, err := stream.next()
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+
+	buf.WriteString(`{"data":[`)
+	for n := 0; row != nil; n++ {
+		if n == page.Limit {
+			page.HasMore = true
+			break
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(row); err != nil {
+			h.logger.Error("list response failed", zap.Error(err))
+			buf.Flush()
+			return
+		}
+		if row, err = stream.next(); err != nil {
+			h.logger.Error("list response failed", zap.Error(err))
+			buf.Flush()
+			return
+		}
+	}
+	buf.WriteString(`],"pagination":`)
+	enc.Encode(page)
+	buf.WriteString("}\n")
+	buf.Flush()
+}
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// TraceIDHeader carries the trace id between the gateway and downstream services
+const TraceIDHeader = "X-Trace-ID"
+
+type traceIDKey struct{}
+
+// TraceIDFromContext returns the trace id assigned by RequestLogger
+func TraceIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// RequestLogOptions configures the structured access log
+type RequestLogOptions struct {
+	// SampledPaths are logged once every SampleEvery requests unless they fail
+	SampledPaths []string
+	SampleEvery  uint64
+	// RedactHeaders are logged as "[REDACTED]"
+	RedactHeaders []string
+}
+
+// DefaultRequestLogOptions samples health checks and redacts credentials
+func DefaultRequestLogOptions() RequestLogOptions {
+	return RequestLogOptions{
+		SampledPaths:  []string{"/health", "/ready"},
+		SampleEvery:   100,
+		RedactHeaders: []string{"Authorization", "X-API-Key", "Cookie", "Set-Cookie"},
+	}
+}
+
+// RequestLogger returns a zap-based access log middleware. Account and role
+// are read after the handler runs, since the auth middleware resolves them
+// from claims into the X-Account-ID and X-Role request headers.
+func RequestLogger(logger *zap.Logger, opts RequestLogOptions) func(http.Handler) http.Handler {
+	sampled := make(map[string]bool, len(opts.SampledPaths))
+	for _, path := range opts.SampledPaths {
+		sampled[path] = true
+	}
+	redacted := make(map[string]bool, len(opts.RedactHeaders))
+	for _, header := range opts.RedactHeaders {
+		redacted[http.CanonicalHeaderKey(header)] = true
+	}
+	var sampleCounter uint64
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// Prefer the OpenTelemetry trace id so logs and spans correlate
+			traceID := r.Header.Get(TraceIDHeader)
+			if spanCtx := trace.SpanContextFromContext(r.Context()); spanCtx.HasTraceID() {
+				traceID = spanCtx.TraceID().String()
+			}
+			if traceID == "" {
+				traceID = uuid.NewString()
+			}
+			r.Header.Set(TraceIDHeader, traceID)
+			w.Header().Set(TraceIDHeader, traceID)
+			ctx := context.WithValue(r.Context(), traceIDKey{}, traceID)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if sampled[r.URL.Path] && status < http.StatusBadRequest && opts.SampleEvery > 1 {
+				if atomic.AddUint64(&sampleCounter, 1)%opts.SampleEvery != 1 {
+					return
+				}
+			}
+
+			fields := []zap.Field{
+				zap.String("request_id", middleware.GetReqID(ctx)),
+				zap.String("trace_id", traceID),
+				zap.String("account_id", r.Header.Get("X-Account-ID")),
+				zap.String("role", r.Header.Get("X-Role")),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Duration("latency", time.Since(start)),
+				zap.Int("bytes", ww.BytesWritten()),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.Any("headers", redactHeaders(r.Header, redacted)),
+			}
+
+			switch {
+			case status >= http.StatusInternalServerError:
+				logger.Error("request completed", fields...)
+			case status >= http.StatusBadRequest:
+				logger.Warn("request completed", fields...)
+			default:
+				logger.Info("request completed", fields...)
+			}
+		})
+	}
+}
+
+func redactHeaders(header http.Header, redacted map[string]bool) map[string]string {
+	out := make(map[string]string, len(header))
+	for key, values := range header {
+		if redacted[key] {
+			out[key] = "[REDACTED]"
+			continue
+		}
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+	auth "github.com/brivas/unified-platform/packages/core"
+)
+
+// PermissionDescriber looks up a role's permission rules without
+// authorizing, and so auditing, a request. The auth engine implements it;
+// /v1/metadata uses it to describe what the caller may do.
+type PermissionDescriber interface {
+	GetPermissionContext(ctx context.Context, table string, role auth.Role) *auth.TablePermission
+}
+
+// Metadata describes the generated APIs available to the caller's role
+type Metadata struct {
+	Role   auth.Role       `json:"role"`
+	Schema string          `json:"schema"`
+	Tables []TableMetadata `json:"tables"`
+}
+
+// TableMetadata is a table the role may use: the columns it can read, the
+// operations it may perform and the generated endpoints for them
+type TableMetadata struct {
+	Name          string                         `json:"name"`
+	PrimaryKey    string                         `json:"primary_key"`
+	Columns       []Column                       `json:"columns"`
+	Relationships []Relation                     `json:"relationships,omitempty"`
+	Permissions   map[string]OperationPermission `json:"permissions"`
+	GraphQL       *GraphQLOperations             `json:"graphql,omitempty"`
+	REST          []RESTOperation                `json:"rest,omitempty"`
+}
+
+// OperationPermission is an allowed operation's restrictions. Empty
+// Columns means every column.
+type OperationPermission struct {
+	Columns []string          `json:"columns,omitempty"`
+	Filter  map[string]string `json:"filter,omitempty"`
+	Check   map[string]string `json:"check,omitempty"`
+	Set     map[string]string `json:"set,omitempty"`
+	Limit   int               `json:"limit,omitempty"`
+}
+
+// GraphQLOperations are the generated GraphQL fields for a table
+type GraphQLOperations struct {
+	Queries       []string `json:"queries,omitempty"`
+	Mutations     []string `json:"mutations,omitempty"`
+	Subscriptions []string `json:"subscriptions,omitempty"`
+}
+
+// RESTOperation is a generated REST endpoint
+type RESTOperation struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// metadata describes the tables and operations the caller's role can use,
+// for client-side capability discovery. Unlike /admin/schema it is open to
+// every role and scoped to it.
+func (e *UnifiedAPIEngine) metadata(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.schema == nil {
+		apierr.Write(w, r, "schema not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx := r.Context()
+	claims := requestClaims(ctx)
+	md := Metadata{Role: claims.Role, Schema: e.schema.Name, Tables: make([]TableMetadata, 0)}
+
+	readable := make(map[string]bool)
+	for _, table := range e.schema.Tables {
+		perms := e.operationPermissions(ctx, table.Name, claims)
+		if len(perms) == 0 {
+			continue
+		}
+		tm := TableMetadata{
+			Name:        table.Name,
+			PrimaryKey:  table.PrimaryKey,
+			Columns:     make([]Column, 0),
+			Permissions: perms,
+			// Filtered below once every readable table is known
+			Relationships: table.Relations,
+		}
+		if sel, ok := perms[string(auth.PermissionSelect)]; ok {
+			readable[table.Name] = true
+			for _, col := range table.Columns {
+				if len(sel.Columns) == 0 || contains(sel.Columns, col.Name) {
+					tm.Columns = append(tm.Columns, col)
+				}
+			}
+		}
+		if e.graphqlAPI != nil {
+			tm.GraphQL = graphQLOperations(table.Name, perms, e.changes.publishes(table.Name))
+		}
+		if e.restAPI != nil {
+			tm.REST = restOperations(table.Name, perms)
+		}
+		md.Tables = append(md.Tables, tm)
+	}
+
+	// Keep the relationships the role can follow between readable tables
+	for i := range md.Tables {
+		var rels []Relation
+		for _, rel := range md.Tables[i].Relationships {
+			if readable[md.Tables[i].Name] && readable[rel.TargetTable] {
+				rels = append(rels, rel)
+			}
+		}
+		md.Tables[i].Relationships = rels
+	}
+
+	json.NewEncoder(w).Encode(md)
+}
+
+// operationPermissions returns the operations claims allow on table, keyed
+// by select, insert, update and delete. Without a permission source every
+// operation is allowed unrestricted, as in the REST API.
+func (e *UnifiedAPIEngine) operationPermissions(ctx context.Context, table string, claims *auth.Claims) map[string]OperationPermission {
+	ops := []auth.Permission{auth.PermissionSelect, auth.PermissionInsert, auth.PermissionUpdate, auth.PermissionDelete}
+	perms := make(map[string]OperationPermission)
+	if e.permissions == nil {
+		for _, op := range ops {
+			perms[string(op)] = OperationPermission{}
+		}
+		return perms
+	}
+
+	var perm *auth.TablePermission
+	if describer, ok := e.permissions.(PermissionDescriber); ok {
+		perm = describer.GetPermissionContext(ctx, table, claims.Role)
+	} else {
+		// Fall back to authorizing each operation, which is audited
+		for _, op := range ops {
+			if p, err := e.permissions.Authorize(ctx, table, op, claims); err == nil {
+				perm = p
+				break
+			}
+		}
+	}
+	if perm == nil {
+		return perms
+	}
+
+	for _, op := range ops {
+		if !claims.HasScope(table, op) {
+			continue
+		}
+		switch {
+		case op == auth.PermissionSelect && perm.Select != nil && perm.Select.Allowed:
+			perms[string(op)] = OperationPermission{Columns: perm.Select.Columns, Filter: perm.Select.Filter, Limit: perm.Select.Limit}
+		case op == auth.PermissionInsert && perm.Insert != nil && perm.Insert.Allowed:
+			perms[string(op)] = OperationPermission{Columns: perm.Insert.Columns, Check: perm.Insert.Check, Set: perm.Insert.Set}
+		case op == auth.PermissionUpdate && perm.Update != nil && perm.Update.Allowed:
+			perms[string(op)] = OperationPermission{Columns: perm.Update.Columns, Filter: perm.Update.Filter}
+		case op == auth.PermissionDelete && perm.Delete != nil && perm.Delete.Allowed:
+			perms[string(op)] = OperationPermission{Filter: perm.Delete.Filter}
+		}
+	}
+	return perms
+}
+
+// graphQLOperations lists the fields NewGraphQLHandler generates for table
+// that perms allow. Subscriptions stream only tables whose changes are
+// published.
+func graphQLOperations(table string, perms map[string]OperationPermission, published bool) *GraphQLOperations {
+	ops := &GraphQLOperations{}
+	if _, ok := perms[string(auth.PermissionSelect)]; ok {
+		ops.Queries = append(ops.Queries, toCamelCase(table), toPlural(toCamelCase(table)))
+		if published {
+			ops.Subscriptions = append(ops.Subscriptions, table)
+		}
+	}
+	for _, op := range []auth.Permission{auth.PermissionInsert, auth.PermissionUpdate, auth.PermissionDelete} {
+		if _, ok := perms[string(op)]; ok {
+			ops.Mutations = append(ops.Mutations, string(op)+"_"+table)
+		}
+	}
+	return ops
+}
+
+// restOperations lists the endpoints RESTHandler.Routes mounts under
+// /api/v1 for table that perms allow
+func restOperations(table string, perms map[string]OperationPermission) []RESTOperation {
+	base := "/api/v1/" + table
+	routes := map[auth.Permission][]RESTOperation{
+		auth.PermissionSelect: {{http.MethodGet, base}, {http.MethodGet, base + "/{id}"}},
+		auth.PermissionInsert: {{http.MethodPost, base}, {http.MethodPost, base + "/bulk"}},
+		auth.PermissionUpdate: {{http.MethodPut, base + "/{id}"}, {http.MethodPatch, base + "/{id}"}, {http.MethodPut, base + "/bulk"}},
+		auth.PermissionDelete: {{http.MethodDelete, base + "/{id}"}},
+	}
+
+	var ops []RESTOperation
+	for _, op := range []auth.Permission{auth.PermissionSelect, auth.PermissionInsert, auth.PermissionUpdate, auth.PermissionDelete} {
+		if _, ok := perms[string(op)]; ok {
+			ops = append(ops, routes[op]...)
+		}
+	}
+	return ops
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+type mutationTxKey struct{}
+
+// mutationTx is the transaction a GraphQL mutation runs in, with the
+// change events to announce once it commits
+type mutationTx struct {
+	tx      *gatewayTx
+	changes []ChangeEvent
+}
+
+// isMutation reports whether the operation of doc that will be executed
+// is a mutation
+func isMutation(doc *ast.Document, operationName string) bool {
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			return op.Operation == ast.OperationTypeMutation
+		}
+	}
+	return false
+}
+
+// executeMutation runs every root field of a mutation in one transaction,
+// a savepoint when the request runs in a database session. Any error, from
+// a statement or a resolver, rolls back all of them and the result carries
+// only the errors; data from the rolled back fields never reached the
+// database.
+func (h *GraphQLHandler) executeMutation(ctx context.Context, params graphql.Params) *graphql.Result {
+	tx, err := beginTx(ctx, h.db)
+	if err != nil {
+		return &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(fmt.Sprintf("starting transaction: %v", err))},
+		}
+	}
+	mtx := &mutationTx{tx: tx}
+	params.Context = context.WithValue(ctx, mutationTxKey{}, mtx)
+
+	result := graphql.Do(params)
+	if result.HasErrors() {
+		tx.rollback(ctx)
+		result.Data = nil
+		return result
+	}
+	if err := tx.commit(ctx); err != nil {
+		return &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(fmt.Sprintf("committing mutation: %v", err))},
+		}
+	}
+
+	for _, event := range mtx.changes {
+		h.changes.Notify(ctx, event.Table, event.Operation, event.PrimaryKey)
+	}
+	return result
+}
+
+// queryOne runs a statement returning at most one row, in the mutation's
+// transaction when there is one. No row yields a nil map.
+func (h *GraphQLHandler) queryOne(ctx context.Context, query string, args ...interface{}) (map[string]interface{}, error) {
+	var rows *lumadb.Rows
+	if mtx, ok := ctx.Value(mutationTxKey{}).(*mutationTx); ok {
+		r, err := mtx.tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		rows = lumadb.WrapRows(r)
+	} else {
+		r, err := sessionQuery(ctx, h.db, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		rows = r
+	}
+	defer rows.Close()
+
+	results, err := scanRowsToMaps(rows)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// notify announces a write, holding it until the mutation's transaction
+// commits when there is one
+func (h *GraphQLHandler) notify(ctx context.Context, table, operation string, pk interface{}) {
+	if mtx, ok := ctx.Value(mutationTxKey{}).(*mutationTx); ok {
+		mtx.changes = append(mtx.changes, ChangeEvent{Table: table, Operation: operation, PrimaryKey: pk})
+		return
+	}
+	h.changes.Notify(ctx, table, operation, pk)
+}
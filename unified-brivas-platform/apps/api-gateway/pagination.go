@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// List limit defaults
+const (
+	// DefaultListLimit is the page size when no limit is given
+	DefaultListLimit = 100
+	// MaxListLimit is the largest limit served
+	MaxListLimit = 1000
+)
+
+// ListLimits bounds the rows GraphQL, REST and MCP lists return, so no
+// request can read a whole table. Zero fields use DefaultListLimit and
+// MaxListLimit.
+type ListLimits struct {
+	// Default is the limit of lists that do not give one
+	Default int
+	// Max is the largest limit served. Larger ones are rejected, or
+	// lowered to Max when Clamp is set.
+	Max   int
+	Clamp bool
+}
+
+// withDefaults fills in unset limits and keeps Default within Max
+func (l ListLimits) withDefaults() ListLimits {
+	if l.Max <= 0 {
+		l.Max = MaxListLimit
+	}
+	if l.Default <= 0 {
+		l.Default = DefaultListLimit
+	}
+	l.Default = min(l.Default, l.Max)
+	return l
+}
+
+// limit is the limit to serve for a request that asked for requested, if
+// given
+func (l ListLimits) limit(requested int, given bool) (int, error) {
+	l = l.withDefaults()
+	switch {
+	case !given:
+		return l.Default, nil
+	case requested < 1 || requested > l.Max && !l.Clamp:
+		return 0, fmt.Errorf("limit must be between 1 and %d", l.Max)
+	}
+	return min(requested, l.Max), nil
+}
+
+// Row count modes accepted by ?count= on REST lists. Exact runs a COUNT(*)
+// under the list's filter, estimated takes the query planner's row
+// estimate, which is cheap on huge tables but may be off.
+const (
+	CountExact     = "exact"
+	CountEstimated = "estimated"
+	CountNone      = "none"
+)
+
+// Pagination describes the page returned by a REST list
+type Pagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// Total is the number of rows matching the filter, absent with count=none
+	Total *int64 `json:"total,omitempty"`
+	// Estimated is set when Total is the planner's estimate
+	Estimated bool `json:"estimated,omitempty"`
+	HasMore   bool `json:"has_more"`
+}
+
+// ListResponse is the envelope of REST list responses
+type ListResponse struct {
+	Data       []map[string]interface{} `json:"data"`
+	Pagination Pagination               `json:"pagination"`
+}
+
+type listParams struct {
+	limit  int
+	offset int
+	count  string
+}
+
+// parseListParams reads ?limit=, ?offset= and ?count=. Counting is off
+// unless asked for.
+func parseListParams(query url.Values, limits ListLimits) (listParams, error) {
+	params := listParams{count: CountNone}
+
+	// A limit that is not an integer is rejected as out of range
+	v := query.Get("limit")
+	requested, _ := strconv.Atoi(v)
+	limit, err := limits.limit(requested, v != "")
+	if err != nil {
+		return params, err
+	}
+	params.limit = limit
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return params, fmt.Errorf("offset must be a non-negative integer")
+		}
+		params.offset = n
+	}
+	switch v := query.Get("count"); v {
+	case "":
+	case CountExact, CountEstimated, CountNone:
+		params.count = v
+	default:
+		return params, fmt.Errorf("count must be %s, %s or %s", CountExact, CountEstimated, CountNone)
+	}
+	return params, nil
+}
+
+// countRows counts the rows of table matching where, a WHERE clause or
+// empty, exactly or from the planner's estimate depending on mode
+func (h *RESTHandler) countRows(ctx context.Context, table TableSchema, where string, args []interface{}, mode string) (int64, error) {
+	from := "FROM " + table.QualifiedName() + where
+
+	if mode == CountEstimated {
+		var plan string
+		if err := sessionQueryRow(ctx, h.db, "EXPLAIN (FORMAT JSON) SELECT 1 "+from, args...).Scan(&plan); err != nil {
+			return 0, fmt.Errorf("estimating row count: %w", err)
+		}
+		return planRows(plan)
+	}
+
+	var total int64
+	if err := sessionQueryRow(ctx, h.db, "SELECT count(*) "+from, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("counting rows: %w", err)
+	}
+	return total, nil
+}
+
+// planRows reads the top node's row estimate from EXPLAIN (FORMAT JSON)
+func planRows(plan string) (int64, error) {
+	var explain []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &explain); err != nil || len(explain) == 0 {
+		return 0, fmt.Errorf("unexpected query plan %q", plan)
+	}
+	return int64(explain[0].Plan.Rows), nil
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	auth "github.com/brivas/unified-platform/packages/core"
+)
+
+// PermissionSource authorizes table operations, typically the auth engine,
+// which also audits each decision. Without one the REST API applies no role
+// restrictions.
+type PermissionSource interface {
+	Authorize(ctx context.Context, table string, op auth.Permission, claims *auth.Claims) (*auth.TablePermission, error)
+}
+
+// requestClaims returns the claims the auth middleware stored in ctx,
+// treating unauthenticated requests as anonymous
+func requestClaims(ctx context.Context) *auth.Claims {
+	if claims, ok := ctx.Value("claims").(*auth.Claims); ok && claims != nil {
+		return claims
+	}
+	return &auth.Claims{Role: auth.RoleAnonymous}
+}
+
+// rlsCondition renders a row-level security filter as AND-ed conditions on
+// table, appending the bound values to args
+func rlsCondition(table string, filter map[string]string, claims *auth.Claims, args []interface{}) (string, []interface{}) {
+	if len(filter) == 0 {
+		return "", args
+	}
+
+	cols := make([]string, 0, len(filter))
+	for col := range filter {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	conditions := make([]string, len(cols))
+	for i, col := range cols {
+		args = append(args, sessionValue(filter[col], claims))
+		conditions[i] = fmt.Sprintf("%s.%s = $%d", quoteIdentifier(table), quoteIdentifier(col), len(args))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// sessionValue substitutes session variables such as X-Account-ID, matching
+// the auth engine's ApplyRLS
+func sessionValue(val string, claims *auth.Claims) interface{} {
+	if strings.HasPrefix(val, "X-") {
+		return claims.AccountID
+	}
+	return val
+}
+
+// rowFilter returns the row-level filter for op on table, or an error when
+// the caller's role may not perform op at all. Without a permission source
+// there is no filter, nor in a database session, whose policies filter
+// rows instead.
+func rowFilter(ctx context.Context, permissions PermissionSource, table string, claims *auth.Claims, op auth.Permission) (map[string]string, error) {
+	if permissions == nil {
+		return nil, nil
+	}
+
+	perm, err := permissions.Authorize(ctx, table, op, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%s not allowed on %s", op, table)
+	}
+	if sessionTx(ctx) != nil {
+		return nil, nil
+	}
+	switch op {
+	case auth.PermissionSelect:
+		return perm.Select.Filter, nil
+	case auth.PermissionUpdate:
+		return perm.Update.Filter, nil
+	case auth.PermissionDelete:
+		return perm.Delete.Filter, nil
+	}
+	return nil, nil
+}
+
+// insertPermission returns the caller's insert permission on table, or an
+// error when the role may not insert into it. Without a permission source
+// there is none, and rows are inserted as given.
+func insertPermission(ctx context.Context, permissions PermissionSource, table string, claims *auth.Claims) (*auth.InsertPermission, error) {
+	if permissions == nil {
+		return nil, nil
+	}
+
+	perm, err := permissions.Authorize(ctx, table, auth.PermissionInsert, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%s not allowed on %s", auth.PermissionInsert, table)
+	}
+	return perm.Insert, nil
+}
+
+// insertValues returns the row to insert for data: restricted to the
+// permission's columns, with its presets applied and its check holding
+func insertValues(perm *auth.InsertPermission, data map[string]interface{}, claims *auth.Claims) (map[string]interface{}, error) {
+	if perm == nil {
+		return data, nil
+	}
+
+	values := make(map[string]interface{}, len(data)+len(perm.Set))
+	for col, val := range data {
+		if len(perm.Columns) > 0 && !contains(perm.Columns, col) {
+			return nil, fmt.Errorf("column %q may not be inserted", col)
+		}
+		values[col] = val
+	}
+	for col, val := range perm.Set {
+		values[col] = sessionValue(val, claims)
+	}
+
+	cols := make([]string, 0, len(perm.Check))
+	for col := range perm.Check {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	for _, col := range cols {
+		val, ok := values[col]
+		if !ok || fmt.Sprint(val) != fmt.Sprint(sessionValue(perm.Check[col], claims)) {
+			return nil, fmt.Errorf("row fails the insert check on %q", col)
+		}
+	}
+	return values, nil
+}
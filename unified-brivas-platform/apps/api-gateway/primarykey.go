@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidID is returned for a record ID that cannot be a value of its
+// table's primary key
+var ErrInvalidID = errors.New("invalid id")
+
+// integerBits are the sizes of the integer primary key types
+var integerBits = map[string]int{
+	"smallint": 16, "int2": 16, "smallserial": 16,
+	"integer": 32, "int": 32, "int4": 32, "serial": 32,
+	"bigint": 64, "int8": 64, "bigserial": 64,
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+
+// primaryKeyValue checks a record ID from a URL or argument against the
+// type of the table's primary key and converts it to that type, so a
+// malformed ID is a client error rather than a failed cast in the query.
+// An empty ID is never valid. IDs of an inferred key not among the
+// columns are passed through unchecked.
+func primaryKeyValue(table TableSchema, id interface{}) (interface{}, error) {
+	var s string
+	switch v := id.(type) {
+	case string:
+		s = v
+	case float64:
+		// JSON numbers, from MCP input
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		s = strconv.Itoa(v)
+	case nil:
+	default:
+		return nil, fmt.Errorf("%w: %v is not a scalar", ErrInvalidID, id)
+	}
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrInvalidID)
+	}
+
+	col := findColumn(table, table.PrimaryKey)
+	if col == nil {
+		return s, nil
+	}
+	typ := strings.ToLower(col.Type)
+	if bits, ok := integerBits[typ]; ok {
+		n, err := strconv.ParseInt(s, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a %d-bit integer", ErrInvalidID, s, bits)
+		}
+		return n, nil
+	}
+	switch typ {
+	case "uuid":
+		if !uuidPattern.MatchString(s) {
+			return nil, fmt.Errorf("%w: %q is not a UUID", ErrInvalidID, s)
+		}
+	case "numeric", "decimal", "real", "double precision":
+		// Kept as text, which the database parses without losing precision
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%w: %q is not a number", ErrInvalidID, s)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+)
+
+// RateLimit is a token bucket allowance: RequestsPerSecond refill with
+// bursts of up to Burst requests. A zero RequestsPerSecond means unlimited.
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// RateLimitResult is the outcome of a single limiter check
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // time until the next token when denied
+	Reset      time.Duration // time until the bucket is full again
+}
+
+// RateLimiter decides whether a request identified by key may proceed.
+// The in-memory implementation suits a single replica; multi-replica
+// deployments can plug in a shared (e.g. Redis-backed) implementation.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// DefaultRateLimits returns per-role limits, keyed by auth role name
+func DefaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		"anonymous":   {RequestsPerSecond: 2, Burst: 10},
+		"user":        {RequestsPerSecond: 20, Burst: 40},
+		"reseller":    {RequestsPerSecond: 50, Burst: 100},
+		"admin":       {RequestsPerSecond: 100, Burst: 200},
+		"service":     {RequestsPerSecond: 500, Burst: 1000},
+		"super_admin": {},
+	}
+}
+
+// RateLimitMiddleware throttles requests per account using the role's
+// limit. It must run after the auth middleware, whose claims it keys on;
+// the X-Account-ID and X-Role headers are never trusted, since without
+// the auth middleware in front clients set them. Anonymous callers are
+// keyed by IP.
+func RateLimitMiddleware(limiter RateLimiter, limits map[string]RateLimit, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := requestClaims(r.Context())
+			role, accountID := string(claims.Role), claims.AccountID
+			if role == "" || accountID == "" {
+				role = "anonymous"
+			}
+
+			limit, ok := limits[role]
+			if !ok {
+				limit = limits["anonymous"]
+			}
+			if limit.RequestsPerSecond <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := role + ":" + accountID
+			if role == "anonymous" {
+				key = "anonymous:" + clientIP(r)
+			}
+
+			result, err := limiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				// Fail open: a limiter outage must not take the API down
+				logger.Warn("rate limiter unavailable", zap.String("key", key), zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				apierr.Write(w, r, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(r *http.Request) string {
+	// middleware.RealIP has already folded X-Forwarded-For into RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimiter is an in-process token bucket limiter
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// NewMemoryRateLimiter creates an in-memory rate limiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow takes a token from key's bucket if one is available
+func (l *MemoryRateLimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	b, ok := l.buckets[key]
+	if !ok || b.limit != limit {
+		b = &tokenBucket{tokens: burst, updated: now, limit: limit}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.RequestsPerSecond)
+	b.updated = now
+
+	result := RateLimitResult{Limit: int(burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / limit.RequestsPerSecond)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((burst - b.tokens) / limit.RequestsPerSecond)
+
+	l.sweep(now)
+	return result, nil
+}
+
+// sweep drops buckets that have refilled completely, since they carry no
+// state beyond a fresh bucket
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		refill := now.Sub(b.updated).Seconds() * b.limit.RequestsPerSecond
+		if b.tokens+refill >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
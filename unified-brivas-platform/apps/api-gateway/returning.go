@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// returningColumns picks the columns a mutation returns: the requested ones,
+// or every column of the table when none are requested, less those the
+// caller's role may not read. Writes thereby expose no more than reads.
+func returningColumns(ctx context.Context, table TableSchema, requested []string, authz FieldAuthorizer) ([]string, error) {
+	candidates := requested
+	if len(candidates) == 0 {
+		candidates = make([]string, len(table.Columns))
+		for i, col := range table.Columns {
+			candidates[i] = col.Name
+		}
+	}
+
+	role := requestClaims(ctx).Role
+	columns := make([]string, 0, len(candidates))
+	for _, col := range candidates {
+		if findColumn(table, col) == nil {
+			return nil, fmt.Errorf("unknown column %q for table %s", col, table.Name)
+		}
+		if authz == nil || authz.CanSelectColumn(ctx, table.Name, role, col) {
+			columns = append(columns, col)
+		}
+	}
+	return columns, nil
+}
+
+// returningClause renders the RETURNING clause for columns. With no columns
+// a constant is returned, so affected rows can still be counted.
+func returningClause(columns []string) string {
+	if len(columns) == 0 {
+		return " RETURNING 1"
+	}
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = quoteIdentifier(col)
+	}
+	return " RETURNING " + strings.Join(quoted, ", ")
+}
+
+// restReturning resolves ?returning=col1,col2 against the caller's readable
+// columns, writing a 400 or 403 and returning false when it cannot be served
+func (h *RESTHandler) restReturning(w http.ResponseWriter, r *http.Request, table TableSchema) ([]string, bool) {
+	var requested []string
+	for _, col := range strings.Split(r.URL.Query().Get("returning"), ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			requested = append(requested, col)
+		}
+	}
+
+	columns, err := returningColumns(r.Context(), table, requested, h.authz)
+	if err != nil {
+		h.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if len(columns) == 0 {
+		h.jsonError(w, r, "no readable columns to return", http.StatusForbidden)
+		return nil, false
+	}
+	return columns, true
+}
+
+// graphQLReturning renders RETURNING for the columns behind the fields
+// selected on a mutation's result, less those the caller's role may not
+// read. The primary key is always read for change notifications; only
+// selected fields are resolved, so it is not exposed.
+func (h *GraphQLHandler) graphQLReturning(p graphql.ResolveParams, table TableSchema) (string, error) {
+	fields := make(map[string]string, len(table.Columns))
+	for _, col := range table.Columns {
+		fields[toCamelCase(col.Name)] = col.Name
+	}
+
+	seen := make(map[string]bool)
+	var selected []string
+	var walk func(set *ast.SelectionSet)
+	walk = func(set *ast.SelectionSet) {
+		if set == nil {
+			return
+		}
+		for _, sel := range set.Selections {
+			switch sel := sel.(type) {
+			case *ast.Field:
+				if col, ok := fields[sel.Name.Value]; ok && !seen[col] {
+					seen[col] = true
+					selected = append(selected, col)
+				}
+			case *ast.InlineFragment:
+				walk(sel.SelectionSet)
+			case *ast.FragmentSpread:
+				if frag, ok := p.Info.Fragments[sel.Name.Value].(*ast.FragmentDefinition); ok {
+					walk(frag.SelectionSet)
+				}
+			}
+		}
+	}
+	for _, field := range p.Info.FieldASTs {
+		walk(field.SelectionSet)
+	}
+
+	var columns []string
+	if len(selected) > 0 {
+		var err error
+		if columns, err = returningColumns(p.Context, table, selected, h.authz); err != nil {
+			return "", err
+		}
+	}
+	if !contains(columns, table.PrimaryKey) && findColumn(table, table.PrimaryKey) != nil {
+		columns = append(columns, table.PrimaryKey)
+	}
+	return returningClause(columns), nil
+}
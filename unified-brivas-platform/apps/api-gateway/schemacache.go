@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+)
+
+// schemaCacheSize is how many compiled GraphQL schemas a handler keeps, so
+// that a reload back to an earlier database schema, such as after a
+// migration rollback, does not compile it again
+const schemaCacheSize = 4
+
+// schemaCache holds compiled GraphQL schemas by fingerprint, evicting the
+// oldest beyond schemaCacheSize
+type schemaCache struct {
+	schemas map[string]*graphql.Schema
+	order   []string
+}
+
+func (c *schemaCache) get(fingerprint string) (*graphql.Schema, bool) {
+	if c == nil {
+		return nil, false
+	}
+	schema, ok := c.schemas[fingerprint]
+	return schema, ok
+}
+
+func (c *schemaCache) put(fingerprint string, schema *graphql.Schema) {
+	if _, ok := c.schemas[fingerprint]; ok {
+		return
+	}
+	c.schemas[fingerprint] = schema
+	c.order = append(c.order, fingerprint)
+	if len(c.order) > schemaCacheSize {
+		delete(c.schemas, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Fingerprint identifies the tables of s, including the soft delete
+// configuration applied to them. Equal fingerprints generate equal APIs.
+func (s *Schema) Fingerprint() string {
+	// Marshalling sorts map keys, so the encoding is stable
+	data, _ := json.Marshal(struct {
+		Name   string        `json:"name"`
+		Tables []TableSchema `json:"tables"`
+	}{s.Name, s.Tables})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetSchema serves the GraphQL schema generated for dbSchema. Nothing is
+// built when dbSchema has the fingerprint of the served schema, and a
+// schema compiled earlier for the same fingerprint is reused. It reports
+// whether the served schema changed; a schema that fails to build is
+// logged and the previous one kept.
+func (h *GraphQLHandler) SetSchema(dbSchema *Schema) bool {
+	fingerprint := dbSchema.Fingerprint()
+
+	h.schemaMu.RLock()
+	unchanged := h.schema != nil && h.fingerprint == fingerprint
+	schema, cached := h.compiled.get(fingerprint)
+	h.schemaMu.RUnlock()
+	if unchanged {
+		return false
+	}
+
+	// Compile outside the lock, requests keep using the served schema
+	if !cached {
+		var err error
+		schema, err = h.buildSchema(dbSchema)
+		if err != nil {
+			h.logger.Error("failed to create GraphQL schema", zap.Error(err))
+			fingerprint = ""
+		}
+	}
+
+	h.schemaMu.Lock()
+	defer h.schemaMu.Unlock()
+	if fingerprint == "" && h.schema != nil {
+		return false
+	}
+	if h.compiled == nil {
+		h.compiled = &schemaCache{schemas: make(map[string]*graphql.Schema)}
+	}
+	if fingerprint != "" {
+		h.compiled.put(fingerprint, schema)
+	}
+	h.schema = schema
+	h.fingerprint = fingerprint
+	return true
+}
+
+// currentSchema returns the schema requests are executed against
+func (h *GraphQLHandler) currentSchema() *graphql.Schema {
+	h.schemaMu.RLock()
+	defer h.schemaMu.RUnlock()
+	return h.schema
+}
+
+// ReloadSchema introspects the database again and swaps in a regenerated
+// GraphQL schema when the tables changed; an unchanged database costs only
+// the introspection. It reports whether the GraphQL schema changed. REST,
+// WebSocket and MCP routes serve the tables they were generated for until
+// restart.
+func (e *UnifiedAPIEngine) ReloadSchema(ctx context.Context) (bool, error) {
+	if err := e.LoadSchemaFromDB(ctx); err != nil {
+		return false, err
+	}
+
+	e.mu.Lock()
+	err := configureSoftDelete(e.schema, e.softDelete, e.autoSoftDelete)
+	schema, graphqlAPI := e.schema, e.graphqlAPI
+	e.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	if graphqlAPI == nil {
+		return false, nil
+	}
+	changed := graphqlAPI.SetSchema(schema)
+	e.logger.Info("schema reloaded", zap.Bool("graphql_rebuilt", changed), zap.Int("tables", len(schema.Tables)))
+	return changed, nil
+}
+
+// reloadSchema serves POST /admin/schema/reload for admins, e.g. after a
+// migration
+func (e *UnifiedAPIEngine) reloadSchema(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		apierr.Write(w, r, "admin role required", http.StatusForbidden)
+		return
+	}
+
+	changed, err := e.ReloadSchema(r.Context())
+	if err != nil {
+		apierr.Write(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"graphql_rebuilt": changed})
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Schema warning severities. Errors break generated endpoints; warnings
+// degrade them.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Schema warning codes
+const (
+	WarningInferredPrimaryKey = "inferred_primary_key"
+	WarningMissingPrimaryKey  = "missing_primary_key"
+	WarningUnmappedType       = "unmapped_type"
+	WarningInvalidName        = "invalid_name"
+	WarningNameCollision      = "name_collision"
+)
+
+// SchemaWarning is a problem ValidateSchema found in the loaded schema
+type SchemaWarning struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Table    string `json:"table"`
+	Column   string `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+// graphQLName matches the names GraphQL accepts for fields and types
+var graphQLName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// builtinTypeNames are taken before any table type is named
+var builtinTypeNames = []string{"Query", "Mutation", "Subscription", "String", "Int", "Float", "Boolean", "ID"}
+
+// stringTypes are SQL types served as GraphQL String without loss. JSON
+// is passed through as its text.
+var stringTypes = map[string]bool{
+	"json": true, "jsonb": true,
+	"text": true, "character varying": true, "varchar": true, "character": true, "char": true, "bpchar": true,
+	"uuid": true, "name": true, "inet": true, "cidr": true, "macaddr": true,
+	"date": true, "timestamp": true, "timestamptz": true,
+	"timestamp with time zone": true, "timestamp without time zone": true,
+	"time with time zone": true, "time without time zone": true,
+}
+
+// lossyTypes map to a GraphQL type that cannot hold every value
+var lossyTypes = map[string]string{
+	"bigint":    "GraphQL Int is 32-bit, so larger values resolve to null",
+	"bigserial": "GraphQL Int is 32-bit, so larger values resolve to null",
+	"numeric":   "it is served as Float and loses precision beyond 15 digits",
+	"decimal":   "it is served as Float and loses precision beyond 15 digits",
+}
+
+// ValidateSchema reports what in the loaded schema the generated APIs
+// cannot serve cleanly: tables whose primary key was assumed to be "id",
+// columns whose SQL type has no exact GraphQL counterpart, and names that
+// collide once camel or pascal cased.
+// It is a dry run of GenerateAPIs for onboarding a database.
+func (e *UnifiedAPIEngine) ValidateSchema() []SchemaWarning {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.schema == nil {
+		return nil
+	}
+	return validateSchema(e.schema)
+}
+
+func validateSchema(schema *Schema) []SchemaWarning {
+	var warnings []SchemaWarning
+	add := func(severity, code, table, column, format string, args ...interface{}) {
+		warnings = append(warnings, SchemaWarning{
+			Severity: severity,
+			Code:     code,
+			Table:    table,
+			Column:   column,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	typeOwners := make(map[string]string)
+	for _, name := range builtinTypeNames {
+		typeOwners[name] = "a built-in type"
+	}
+	queryOwners := make(map[string]string)
+
+	for _, table := range schema.Tables {
+		hasPK := false
+		fieldOwners := make(map[string]string)
+		for _, col := range table.Columns {
+			if col.Name == table.PrimaryKey {
+				hasPK = true
+			}
+
+			field := toCamelCase(col.Name)
+			if !graphQLName.MatchString(field) || strings.HasPrefix(field, "__") {
+				add(SeverityError, WarningInvalidName, table.Name, col.Name,
+					"column %s.%s becomes GraphQL field %q, which is not a valid name", table.Name, col.Name, field)
+			} else if other, ok := fieldOwners[field]; ok {
+				add(SeverityError, WarningNameCollision, table.Name, col.Name,
+					"columns %s and %s of %s both become GraphQL field %q", other, col.Name, table.Name, field)
+			} else {
+				fieldOwners[field] = col.Name
+			}
+
+			sqlType := strings.ToLower(col.Type)
+			if reason, ok := lossyTypes[sqlType]; ok {
+				add(SeverityWarning, WarningUnmappedType, table.Name, col.Name,
+					"column %s.%s has type %s: %s", table.Name, col.Name, col.Type, reason)
+			} else if mapSQLTypeToGraphQL(sqlType) == graphql.String && !stringTypes[sqlType] {
+				add(SeverityWarning, WarningUnmappedType, table.Name, col.Name,
+					"column %s.%s has type %s, which has no GraphQL mapping and is served as String", table.Name, col.Name, col.Type)
+			}
+		}
+
+		switch {
+		case table.PrimaryKeyInferred && !hasPK:
+			add(SeverityError, WarningMissingPrimaryKey, table.Name, "",
+				"table %s has no primary key and no %q column, so lookups, updates and deletes by id fail", table.Name, table.PrimaryKey)
+		case table.PrimaryKeyInferred:
+			add(SeverityWarning, WarningInferredPrimaryKey, table.Name, table.PrimaryKey,
+				"table %s has no primary key; %q is assumed to identify rows", table.Name, table.PrimaryKey)
+		case !hasPK:
+			add(SeverityError, WarningMissingPrimaryKey, table.Name, table.PrimaryKey,
+				"primary key %s.%s is not among the table's columns", table.Name, table.PrimaryKey)
+		}
+
+		typeName := toPascalCase(table.Name)
+		if !graphQLName.MatchString(typeName) || strings.HasPrefix(typeName, "__") {
+			add(SeverityError, WarningInvalidName, table.Name, "",
+				"table %s becomes GraphQL type %q, which is not a valid name", table.Name, typeName)
+			continue
+		}
+		if other, ok := typeOwners[typeName]; ok {
+			add(SeverityError, WarningNameCollision, table.Name, "",
+				"table %s becomes GraphQL type %q, already used by %s", table.Name, typeName, other)
+		} else {
+			typeOwners[typeName] = "table " + table.Name
+		}
+
+		single := toCamelCase(table.Name)
+		for _, field := range []string{single, toPlural(single)} {
+			if other, ok := queryOwners[field]; ok {
+				add(SeverityError, WarningNameCollision, table.Name, "",
+					"table %s generates query field %q, already generated for %s", table.Name, field, other)
+				continue
+			}
+			queryOwners[field] = table.Name
+		}
+	}
+	return warnings
+}
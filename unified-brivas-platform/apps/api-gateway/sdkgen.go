@@ -0,0 +1,387 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+)
+
+// goInitialisms are written in upper case in generated identifiers
+var goInitialisms = map[string]bool{
+	"api": true, "dlr": true, "html": true, "http": true, "id": true, "ip": true,
+	"json": true, "otp": true, "sms": true, "sql": true, "url": true, "uuid": true,
+}
+
+// goIdentifier turns a database name into an exported Go identifier,
+// e.g. sender_ids to SenderIDs
+func goIdentifier(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, part := range parts {
+		lower := strings.ToLower(part)
+		switch {
+		case goInitialisms[lower]:
+			b.WriteString(strings.ToUpper(lower))
+		case strings.HasSuffix(lower, "s") && goInitialisms[strings.TrimSuffix(lower, "s")]:
+			b.WriteString(strings.ToUpper(strings.TrimSuffix(lower, "s")) + "s")
+		default:
+			runes := []rune(part)
+			runes[0] = unicode.ToUpper(runes[0])
+			b.WriteString(string(runes))
+		}
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit([]rune(id)[0]) {
+		id = "X" + id
+	}
+	return id
+}
+
+// toSingular undoes the common English plurals of table names
+func toSingular(s string) string {
+	switch {
+	case strings.HasSuffix(s, "ies"):
+		return s[:len(s)-3] + "y"
+	case strings.HasSuffix(s, "sses"), strings.HasSuffix(s, "xes"):
+		return s[:len(s)-2]
+	case strings.HasSuffix(s, "s") && !strings.HasSuffix(s, "ss"):
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// goFieldType maps a column type to the Go type of its field. Types the
+// client cannot decode reliably, such as dates, stay strings.
+func goFieldType(sqlType string) string {
+	switch strings.ToLower(sqlType) {
+	case "integer", "int", "smallint", "bigint", "serial":
+		return "int64"
+	case "real", "double precision", "numeric", "decimal":
+		return "float64"
+	case "boolean", "bool":
+		return "bool"
+	case "json", "jsonb":
+		return "json.RawMessage"
+	default:
+		return "string"
+	}
+}
+
+type sdkField struct {
+	Name, Type, Column string
+}
+
+type sdkTable struct {
+	Path    string // table name escaped for the URL
+	Type    string // row struct
+	List    string // plural for ListX
+	Fields  []sdkField
+	KeyType string // empty without a primary key
+}
+
+// sdkReserved are the identifiers of the generated runtime, which row
+// types must not reuse
+var sdkReserved = map[string]bool{
+	"Client": true, "Error": true, "ListOptions": true, "Pagination": true, "NewClient": true,
+}
+
+// sdkTables describes the schema's tables for the client template,
+// rejecting names that would collide once turned into Go identifiers
+func sdkTables(schema *Schema) ([]sdkTable, error) {
+	// Row and list types share the package scope; List methods are unique
+	// when list types are
+	types := make(map[string]string)
+	declare := func(name, table string) error {
+		if other, ok := types[name]; ok {
+			return fmt.Errorf("tables %s and %s both map to type %s", other, table, name)
+		}
+		types[name] = table
+		return nil
+	}
+
+	tables := make([]sdkTable, 0, len(schema.Tables))
+	for _, table := range schema.Tables {
+		t := sdkTable{
+			Path: url.PathEscape(table.Name),
+			Type: goIdentifier(toSingular(table.Name)),
+			List: goIdentifier(table.Name),
+		}
+		if sdkReserved[t.Type] {
+			t.Type += "Row"
+		}
+		if err := declare(t.Type, table.Name); err != nil {
+			return nil, err
+		}
+		if err := declare(t.List+"List", table.Name); err != nil {
+			return nil, err
+		}
+
+		fields := make(map[string]string)
+		for _, col := range table.Columns {
+			if strings.ContainsAny(col.Name, "\"`,") {
+				return nil, fmt.Errorf("column %q of %s cannot be used as a JSON field name", col.Name, table.Name)
+			}
+			f := sdkField{Name: goIdentifier(col.Name), Type: goFieldType(col.Type), Column: col.Name}
+			if other, ok := fields[f.Name]; ok {
+				return nil, fmt.Errorf("columns %s and %s of %s both map to field %s", other, col.Name, table.Name, f.Name)
+			}
+			fields[f.Name] = col.Name
+			t.Fields = append(t.Fields, f)
+			if col.Name == table.PrimaryKey {
+				t.KeyType = f.Type
+			}
+		}
+		if t.KeyType == "json.RawMessage" {
+			t.KeyType = "string"
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// GenerateGoClient emits the source of a typed Go client for the REST API
+// of h's tables, in package pkgName: a struct per table and List, Get,
+// Create, Update and Delete methods, the last three only for tables with a
+// primary key. Row fields are pointers, so creates and updates send just
+// the fields that are set.
+func (h *RESTHandler) GenerateGoClient(pkgName string) ([]byte, error) {
+	if !token.IsIdentifier(pkgName) || token.IsKeyword(pkgName) {
+		return nil, fmt.Errorf("invalid package name %q", pkgName)
+	}
+	tables, err := sdkTables(h.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = goClientTemplate.Execute(&buf, struct {
+		Package string
+		Tables  []sdkTable
+	}{pkgName, tables})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated client does not parse: %w", err)
+	}
+	return src, nil
+}
+
+// goClientSDK serves GET /admin/sdk/go?package=name for admins, the
+// generated client as a Go source file
+func (e *UnifiedAPIEngine) goClientSDK(w http.ResponseWriter, r *http.Request) {
+	writeError := func(msg string, status int) {
+		apierr.Write(w, r, msg, status)
+	}
+	if !isAdmin(r.Context()) {
+		writeError("admin role required", http.StatusForbidden)
+		return
+	}
+	if e.restAPI == nil {
+		writeError("REST API not enabled", http.StatusNotFound)
+		return
+	}
+
+	pkgName := r.URL.Query().Get("package")
+	if pkgName == "" {
+		pkgName = "brivas"
+	}
+	src, err := e.restAPI.GenerateGoClient(pkgName)
+	if err != nil {
+		writeError(err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-go; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="client.go"`)
+	w.Write(src)
+}
+
+var goClientTemplate = template.Must(template.New("client").Parse(`// Code generated by the Brivas API gateway from the database schema. DO NOT EDIT.
+
+// Package {{.Package}} is a typed client for the Brivas REST API
+package {{.Package}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Client calls the REST API under BaseURL, e.g. https://api.brivas.com/api/v1
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client authenticating with token
+func NewClient(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token, HTTPClient: http.DefaultClient}
+}
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Message    string ` + "`json:\"error\"`" + `
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("brivas: %d %s", e.StatusCode, e.Message)
+}
+
+// ListOptions pages lists; Count is "exact", "estimated" or empty for none
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Count  string
+}
+
+func (o *ListOptions) values() url.Values {
+	v := url.Values{}
+	if o == nil {
+		return v
+	}
+	if o.Limit > 0 {
+		v.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		v.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Count != "" {
+		v.Set("count", o.Count)
+	}
+	return v
+}
+
+// Pagination describes the page returned by a list
+type Pagination struct {
+	Limit     int    ` + "`json:\"limit\"`" + `
+	Offset    int    ` + "`json:\"offset\"`" + `
+	Total     *int64 ` + "`json:\"total,omitempty\"`" + `
+	Estimated bool   ` + "`json:\"estimated,omitempty\"`" + `
+	HasMore   bool   ` + "`json:\"has_more\"`" + `
+}
+
+type mutationResult[T any] struct {
+	AffectedRows int ` + "`json:\"affected_rows\"`" + `
+	Returning    []T ` + "`json:\"returning\"`" + `
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+{{range .Tables}}
+// {{.Type}} is a row of {{.Path}}
+type {{.Type}} struct {
+{{- range .Fields}}
+	{{.Name}} *{{.Type}} ` + "`json:\"{{.Column}},omitempty\"`" + `
+{{- end}}
+}
+
+// {{.List}}List is a page of {{.Path}}
+type {{.List}}List struct {
+	Data       []{{.Type}} ` + "`json:\"data\"`" + `
+	Pagination Pagination ` + "`json:\"pagination\"`" + `
+}
+
+// List{{.List}} lists {{.Path}}
+func (c *Client) List{{.List}}(ctx context.Context, opts *ListOptions) (*{{.List}}List, error) {
+	var out {{.List}}List
+	if err := c.do(ctx, http.MethodGet, "/{{.Path}}", opts.values(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Create{{.Type}} inserts a row into {{.Path}} and returns it
+func (c *Client) Create{{.Type}}(ctx context.Context, in *{{.Type}}) (*{{.Type}}, error) {
+	var out {{.Type}}
+	if err := c.do(ctx, http.MethodPost, "/{{.Path}}", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+{{- if .KeyType}}
+
+// Get{{.Type}} reads the row of {{.Path}} with primary key id
+func (c *Client) Get{{.Type}}(ctx context.Context, id {{.KeyType}}) (*{{.Type}}, error) {
+	var out {{.Type}}
+	if err := c.do(ctx, http.MethodGet, "/{{.Path}}/"+url.PathEscape(fmt.Sprint(id)), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update{{.Type}} sets the non-nil fields of in on the row of {{.Path}}
+// with primary key id and returns the row
+func (c *Client) Update{{.Type}}(ctx context.Context, id {{.KeyType}}, in *{{.Type}}) (*{{.Type}}, error) {
+	var out mutationResult[{{.Type}}]
+	if err := c.do(ctx, http.MethodPatch, "/{{.Path}}/"+url.PathEscape(fmt.Sprint(id)), nil, in, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Returning) == 0 {
+		return nil, &Error{StatusCode: http.StatusNotFound, Message: "not found"}
+	}
+	return &out.Returning[0], nil
+}
+
+// Delete{{.Type}} deletes the row of {{.Path}} with primary key id
+func (c *Client) Delete{{.Type}}(ctx context.Context, id {{.KeyType}}) error {
+	return c.do(ctx, http.MethodDelete, "/{{.Path}}/"+url.PathEscape(fmt.Sprint(id)), nil, nil, nil)
+}
+{{- end}}
+{{end}}`))
@@ -0,0 +1,260 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+	auth "github.com/brivas/unified-platform/packages/core"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Row-level security enforcement modes selectable with Config.RLSMode
+const (
+	// RLSModeGateway appends the role's row filters to the generated SQL,
+	// as the auth engine's ApplyRLS does
+	RLSModeGateway = "gateway"
+	// RLSModeDatabase runs each GraphQL, REST and MCP request in a LumaDB
+	// session carrying the caller's claims and leaves row filtering to
+	// native row-level security policies. Config.Permissions, when set,
+	// still authorizes each operation and its columns, but its row filters
+	// are not applied.
+	RLSModeDatabase = "database"
+)
+
+// Session variables set from the caller's claims in RLSModeDatabase.
+// Policies read them with current_setting('app.account_id', true).
+const (
+	SessionAccountID = "app.account_id"
+	SessionUserID    = "app.user_id"
+	SessionRole      = "app.role"
+	SessionIsLive    = "app.is_live"
+)
+
+type sessionKey struct{}
+
+// session is the database session a request runs in
+type session struct {
+	tx *sql.Tx
+	// err is the first failure that leaves the session unfit to commit
+	err error
+}
+
+// sessionVars maps claims to the session variables policies can read
+func sessionVars(claims *auth.Claims) map[string]string {
+	return map[string]string{
+		SessionAccountID: claims.AccountID,
+		SessionUserID:    claims.Subject,
+		SessionRole:      string(claims.Role),
+		SessionIsLive:    strconv.FormatBool(claims.IsLive),
+	}
+}
+
+// sessionTx returns the database session the request runs in, if any
+func sessionTx(ctx context.Context) *sql.Tx {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		return s.tx
+	}
+	return nil
+}
+
+// failSession marks the request's database session, if any, to be rolled
+// back when the handler returns. Only the first error is kept.
+func failSession(ctx context.Context, err error) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok && s.err == nil && err != nil {
+		s.err = err
+	}
+}
+
+// errHandlerPanicked rolls back the session of a handler that panicked
+var errHandlerPanicked = errors.New("handler panicked")
+
+// SessionMiddleware runs each request in a LumaDB session with the
+// caller's claims set as session variables. The response streams to the
+// client as the handler writes it. The session rolls back when the handler
+// failed, that is when a statement outside a savepoint failed, a savepoint
+// could not be undone, or the handler panicked, and commits otherwise: a
+// response such as a 403 that never touched the database commits an empty
+// session. A failed commit answers with a 500 when no response has been
+// sent yet and aborts the connection otherwise, so a client never mistakes
+// the response for a success.
+func SessionMiddleware(db *lumadb.Client, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp := &sessionResponse{ResponseWriter: w}
+			var panicked interface{}
+
+			err := db.WithSession(r.Context(), sessionVars(requestClaims(r.Context())), func(tx *sql.Tx) error {
+				s := &session{tx: tx}
+				func() {
+					defer func() {
+						if p := recover(); p != nil {
+							panicked = p
+							s.err = errHandlerPanicked
+						}
+					}()
+					next.ServeHTTP(resp, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+				}()
+				return s.err
+			})
+			if panicked != nil {
+				panic(panicked)
+			}
+			if err == nil {
+				return
+			}
+
+			if !resp.started {
+				logger.Error("database session failed", zap.String("path", r.URL.Path), zap.Error(err))
+				apierr.Write(w, r, "database session failed", http.StatusInternalServerError)
+				return
+			}
+			// The handler's own error response is already on its way; a
+			// response that looked successful must not complete
+			logger.Warn("database session rolled back", zap.String("path", r.URL.Path), zap.Error(err))
+			if resp.status < http.StatusBadRequest {
+				panic(http.ErrAbortHandler)
+			}
+		})
+	}
+}
+
+// sessionResponse records whether a response has started, and with what
+// status, so a failed session can still be reported
+type sessionResponse struct {
+	http.ResponseWriter
+	started bool
+	status  int
+}
+
+func (s *sessionResponse) WriteHeader(status int) {
+	if !s.started {
+		s.started = true
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *sessionResponse) Write(p []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *sessionResponse) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// sessionQuery runs query in the request's database session when there is
+// one
+func sessionQuery(ctx context.Context, db *lumadb.Client, query string, args ...interface{}) (*lumadb.Rows, error) {
+	if tx := sessionTx(ctx); tx != nil {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			failSession(ctx, err)
+			return nil, err
+		}
+		return lumadb.WrapRows(rows), nil
+	}
+	return db.Query(ctx, query, args...)
+}
+
+// sessionQueryRow runs query in the request's database session when there
+// is one
+func sessionQueryRow(ctx context.Context, db *lumadb.Client, query string, args ...interface{}) *sql.Row {
+	if tx := sessionTx(ctx); tx != nil {
+		row := tx.QueryRowContext(ctx, query, args...)
+		failSession(ctx, row.Err())
+		return row
+	}
+	return db.QueryRow(ctx, query, args...)
+}
+
+// sessionQueryMap runs query in the request's database session when there
+// is one, returning its first row or nil when there is none
+func sessionQueryMap(ctx context.Context, db *lumadb.Client, query string, args ...interface{}) (map[string]interface{}, error) {
+	rows, err := sessionQuery(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stream, err := newRowStream(rows)
+	if err != nil {
+		return nil, err
+	}
+	return stream.next()
+}
+
+// gatewayTx is a transaction of the gateway's own or, in a database
+// session, a savepoint of the session, which commits with the request
+type gatewayTx struct {
+	*sql.Tx
+	savepoint bool
+}
+
+const gatewaySavepoint = "gateway_tx"
+
+// beginTx starts a transaction, nested in the request's database session
+// when there is one
+func beginTx(ctx context.Context, db *lumadb.Client) (*gatewayTx, error) {
+	if tx := sessionTx(ctx); tx != nil {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT "+gatewaySavepoint); err != nil {
+			failSession(ctx, err)
+			return nil, err
+		}
+		return &gatewayTx{Tx: tx, savepoint: true}, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayTx{Tx: tx}, nil
+}
+
+// commit commits the transaction. A savepoint that cannot be released
+// fails the session.
+func (t *gatewayTx) commit(ctx context.Context) error {
+	if t.savepoint {
+		_, err := t.ExecContext(ctx, "RELEASE SAVEPOINT "+gatewaySavepoint)
+		failSession(ctx, err)
+		return err
+	}
+	return t.Commit()
+}
+
+// rollback rolls the transaction back. The failure it undoes stays
+// contained in a savepoint, so the session may still commit; a savepoint
+// that cannot be undone fails the session.
+func (t *gatewayTx) rollback(ctx context.Context) error {
+	if t.savepoint {
+		_, err := t.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+gatewaySavepoint)
+		failSession(ctx, err)
+		return err
+	}
+	return t.Rollback()
+}
+
+// withTransaction is lumadb's WithTransaction, nested in the request's
+// database session when there is one
+func withTransaction(ctx context.Context, db *lumadb.Client, fn func(*sql.Tx) error) error {
+	tx, err := beginTx(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx.Tx); err != nil {
+		if rbErr := tx.rollback(ctx); rbErr != nil {
+			return fmt.Errorf("transaction failed: %w, rollback failed: %v", err, rbErr)
+		}
+		return err
+	}
+	return tx.commit(ctx)
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	auth "github.com/brivas/unified-platform/packages/core"
+)
+
+// softDeleteColumns are detected, in order, when AutoSoftDelete is enabled
+var softDeleteColumns = []string{"deleted_at", "is_deleted"}
+
+// configureSoftDelete marks the tables whose DELETE only flags rows. Tables
+// listed explicitly must have the named column; with auto enabled the
+// remaining tables are detected by a deleted_at or is_deleted column.
+func configureSoftDelete(schema *Schema, explicit map[string]string, auto bool) error {
+	known := make(map[string]bool, len(explicit))
+	for i := range schema.Tables {
+		table := &schema.Tables[i]
+
+		if column, ok := explicit[table.Name]; ok {
+			known[table.Name] = true
+			if findColumn(*table, column) == nil {
+				return fmt.Errorf("soft delete column %q not found on %s", column, table.Name)
+			}
+			table.SoftDeleteColumn = column
+			continue
+		}
+
+		if auto {
+			for _, column := range softDeleteColumns {
+				if findColumn(*table, column) != nil {
+					table.SoftDeleteColumn = column
+					break
+				}
+			}
+		}
+	}
+
+	for table := range explicit {
+		if !known[table] {
+			return fmt.Errorf("soft delete configured for unknown table %s", table)
+		}
+	}
+	return nil
+}
+
+func findColumn(table TableSchema, name string) *Column {
+	for i := range table.Columns {
+		if table.Columns[i].Name == name {
+			return &table.Columns[i]
+		}
+	}
+	return nil
+}
+
+// softDeleteFlag reports whether the soft delete column is a boolean flag
+// rather than a deletion timestamp
+func softDeleteFlag(table TableSchema) bool {
+	col := findColumn(table, table.SoftDeleteColumn)
+	return col != nil && strings.HasPrefix(strings.ToLower(col.Type), "bool")
+}
+
+// liveRowsCondition restricts a query on table to rows not soft-deleted
+func liveRowsCondition(table TableSchema) string {
+	if table.SoftDeleteColumn == "" {
+		return ""
+	}
+	if softDeleteFlag(table) {
+		return table.ColumnRef(table.SoftDeleteColumn) + " IS NOT TRUE"
+	}
+	return table.ColumnRef(table.SoftDeleteColumn) + " IS NULL"
+}
+
+// softDeleteAssignment is the SET clause that soft-deletes a row
+func softDeleteAssignment(table TableSchema) string {
+	if softDeleteFlag(table) {
+		return quoteIdentifier(table.SoftDeleteColumn) + " = TRUE"
+	}
+	return quoteIdentifier(table.SoftDeleteColumn) + " = NOW()"
+}
+
+// isAdmin reports whether the caller may see soft-deleted rows and
+// hard-delete audited data
+func isAdmin(ctx context.Context) bool {
+	role := requestClaims(ctx).Role
+	return role == auth.RoleAdmin || role == auth.RoleSuperAdmin
+}
+
+// includeDeletedArg reads the includeDeleted GraphQL argument, which only
+// admins may set
+func includeDeletedArg(p graphql.ResolveParams) (bool, error) {
+	include, _ := p.Args["includeDeleted"].(bool)
+	if include && !isAdmin(p.Context) {
+		return false, fmt.Errorf("includeDeleted requires an admin role")
+	}
+	return include, nil
+}
+
+// includeDeleted reads ?include_deleted=true, rejecting it for non-admins
+func (h *RESTHandler) includeDeleted(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	if r.URL.Query().Get("include_deleted") != "true" {
+		return false, true
+	}
+	if !isAdmin(r.Context()) {
+		h.jsonError(w, r, "include_deleted requires an admin role", http.StatusForbidden)
+		return false, false
+	}
+	return true, true
+}
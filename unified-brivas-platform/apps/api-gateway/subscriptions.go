@@ -0,0 +1,415 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.uber.org/zap"
+
+	auth "github.com/brivas/unified-platform/packages/core"
+)
+
+// GraphQLWSProtocol is the WebSocket subprotocol of the graphql-ws library
+// (graphql-transport-ws). Clients negotiating it on /ws, or upgrading on
+// /graphql, run GraphQL operations instead of channel subscriptions.
+const GraphQLWSProtocol = "graphql-transport-ws"
+
+// graphql-ws message types
+const (
+	gqlConnectionInit = "connection_init"
+	gqlConnectionAck  = "connection_ack"
+	gqlPing           = "ping"
+	gqlPong           = "pong"
+	gqlSubscribe      = "subscribe"
+	gqlNext           = "next"
+	gqlError          = "error"
+	gqlComplete       = "complete"
+)
+
+// graphql-ws close codes
+const (
+	gqlCloseBadRequest         = 4400
+	gqlCloseUnauthorized       = 4401
+	gqlCloseInitTimeout        = 4408
+	gqlCloseSubscriberExists   = 4409
+	gqlCloseTooManyInitialised = 4429
+)
+
+const (
+	// gqlInitTimeout is how long a client may take to send connection_init
+	gqlInitTimeout = 10 * time.Second
+	// changeWatcherBuffer bounds the change events queued for a slow
+	// subscription before further events are dropped
+	changeWatcherBuffer = 64
+)
+
+// graphQLWSMessage is the envelope of the graphql-ws protocol
+type graphQLWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// changeWatcher receives the change events of one table for a subscription
+type changeWatcher struct {
+	table  string
+	events chan ChangeEvent
+}
+
+// SetPermissions enforces role permissions on every query, mutation and
+// subscription, including row-level security on the rows they touch
+func (h *GraphQLHandler) SetPermissions(permissions PermissionSource) {
+	h.permissions = permissions
+}
+
+// SetWebSocketHandler hands WebSocket upgrades on the GraphQL endpoint to
+// ws, so graphql-ws clients can use the same URL as queries
+func (h *GraphQLHandler) SetWebSocketHandler(ws http.Handler) {
+	h.ws = ws
+}
+
+// Publish delivers a committed change to the subscriptions on its table.
+// A subscription that cannot keep up misses the event.
+func (h *GraphQLHandler) Publish(event ChangeEvent) {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+
+	for w := range h.watchers {
+		if w.table != event.Table {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			h.logger.Warn("dropping change event for slow subscription", zap.String("table", event.Table))
+		}
+	}
+}
+
+func (h *GraphQLHandler) watch(table string) *changeWatcher {
+	w := &changeWatcher{table: table, events: make(chan ChangeEvent, changeWatcherBuffer)}
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	if h.watchers == nil {
+		h.watchers = make(map[*changeWatcher]struct{})
+	}
+	h.watchers[w] = struct{}{}
+	return w
+}
+
+func (h *GraphQLHandler) unwatch(w *changeWatcher) {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	delete(h.watchers, w)
+}
+
+// subscribeChanges streams the rows of table changed by inserts and
+// updates, re-read so that they are current and pass the where argument
+// and the caller's row-level filter. Deletes are not streamed since the
+// row can no longer be checked against either.
+func (h *GraphQLHandler) subscribeChanges(table TableSchema) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if !h.changes.publishes(table.Name) {
+			return nil, fmt.Errorf("changes to %s are not published", table.Name)
+		}
+		filter, err := rowFilter(p.Context, h.permissions, table.Name, requestClaims(p.Context), auth.PermissionSelect)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := whereArg(table, p.Args, nil); err != nil {
+			return nil, err
+		}
+
+		w := h.watch(table.Name)
+		rows := make(chan interface{})
+		go func() {
+			defer close(rows)
+			defer h.unwatch(w)
+			for {
+				select {
+				case <-p.Context.Done():
+					return
+				case event := <-w.events:
+					if event.Operation == ChangeDelete {
+						continue
+					}
+					row, err := h.changedRow(p.Context, table, event.PrimaryKey, p.Args, filter)
+					if err != nil {
+						h.logger.Warn("failed to read changed row", zap.String("table", table.Name), zap.Error(err))
+						continue
+					}
+					if row == nil {
+						continue
+					}
+					select {
+					case rows <- row:
+					case <-p.Context.Done():
+						return
+					}
+				}
+			}
+		}()
+		return rows, nil
+	}
+}
+
+// changedRow reads the row with key pk if it is live and passes the where
+// argument of fieldArgs and the row-level filter, or returns nil
+func (h *GraphQLHandler) changedRow(ctx context.Context, table TableSchema, pk interface{}, fieldArgs map[string]interface{}, filter map[string]string) (map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", table.QualifiedName(), table.ColumnRef(table.PrimaryKey))
+	where, args, err := whereArg(table, fieldArgs, []interface{}{pk})
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		query += " AND " + where
+	}
+	if cond := liveRowsCondition(table); cond != "" {
+		query += " AND " + cond
+	}
+	condition, args := rlsCondition(table.Name, filter, requestClaims(ctx), args)
+	if condition != "" {
+		query += " AND " + condition
+	}
+
+	rows, err := sessionQuery(ctx, h.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanRowsToMaps(rows)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// resolveChangedRow resolves a subscription field to the row its
+// subscriber emitted
+func resolveChangedRow(p graphql.ResolveParams) (interface{}, error) {
+	return p.Source, nil
+}
+
+// subscribe runs an operation received over graphql-ws. Subscriptions
+// yield a result per streamed row until ctx is cancelled; queries and
+// mutations yield a single result. The channel is closed when done.
+func (h *GraphQLHandler) subscribe(ctx context.Context, params graphQLRequest) <-chan *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: params.Query})
+	if err != nil || !isSubscription(doc, params.OperationName) {
+		results := make(chan *graphql.Result, 1)
+		results <- h.execute(ctx, params)
+		close(results)
+		return results
+	}
+
+	schema := h.currentSchema()
+	if _, err := checkQueryLimits(schema, doc, params.OperationName, params.Variables, h.limits); err != nil {
+		results := make(chan *graphql.Result, 1)
+		results <- &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())}}
+		close(results)
+		return results
+	}
+
+	return graphql.Subscribe(graphql.Params{
+		Schema:         *schema,
+		RequestString:  params.Query,
+		VariableValues: params.Variables,
+		OperationName:  params.OperationName,
+		Context:        ctx,
+	})
+}
+
+// isSubscription reports whether the operation doc selects is a subscription
+func isSubscription(doc *ast.Document, operationName string) bool {
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			return op.Operation == ast.OperationTypeSubscription
+		}
+	}
+	return false
+}
+
+// SetGraphQLHandler enables the graphql-ws protocol on the WebSocket API
+func (h *WebSocketHandler) SetGraphQLHandler(graphqlAPI *GraphQLHandler) {
+	h.graphql = graphqlAPI
+	h.upgrader.Subprotocols = []string{GraphQLWSProtocol}
+}
+
+// serveGraphQLWS speaks graphql-ws on client until the connection closes.
+// Every operation counts against the client's subscription limit while it
+// runs.
+func (h *WebSocketHandler) serveGraphQLWS(ctx context.Context, client *wsClient) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	operations := make(map[string]context.CancelFunc)
+	done := make(chan string)
+	defer func() {
+		for _, stop := range operations {
+			stop()
+		}
+		for range operations {
+			<-done
+		}
+		atomic.AddInt64(&h.subscriptions, -int64(len(operations)))
+	}()
+
+	// Messages arrive on a separate goroutine so finished operations can be
+	// released while waiting for the client
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		for {
+			_, data, err := client.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	initialised := false
+	client.conn.SetReadDeadline(time.Now().Add(gqlInitTimeout))
+	for {
+		var data []byte
+		select {
+		case id := <-done:
+			delete(operations, id)
+			atomic.AddInt64(&h.subscriptions, -1)
+			continue
+		case d, ok := <-messages:
+			if !ok {
+				if !initialised {
+					h.closeGraphQLWS(client, gqlCloseInitTimeout, "Connection initialisation timeout")
+				}
+				return
+			}
+			data = d
+		}
+
+		var msg graphQLWSMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			h.closeGraphQLWS(client, gqlCloseBadRequest, "Invalid message received")
+			return
+		}
+
+		switch msg.Type {
+		case gqlConnectionInit:
+			if initialised {
+				h.closeGraphQLWS(client, gqlCloseTooManyInitialised, "Too many initialisation requests")
+				return
+			}
+			initialised = true
+			client.conn.SetReadDeadline(time.Time{})
+			h.sendGraphQLWS(client, graphQLWSMessage{Type: gqlConnectionAck})
+		case gqlPing:
+			h.sendGraphQLWS(client, graphQLWSMessage{Type: gqlPong})
+		case gqlPong:
+		case gqlSubscribe:
+			if !initialised {
+				h.closeGraphQLWS(client, gqlCloseUnauthorized, "Unauthorized")
+				return
+			}
+			var params graphQLRequest
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &params) != nil {
+				h.closeGraphQLWS(client, gqlCloseBadRequest, "Invalid message received")
+				return
+			}
+			if _, exists := operations[msg.ID]; exists {
+				h.closeGraphQLWS(client, gqlCloseSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+				return
+			}
+			if len(operations) >= h.opts.MaxSubscriptions {
+				h.sendGraphQLWSErrors(client, msg.ID, fmt.Sprintf("subscription limit of %d reached", h.opts.MaxSubscriptions))
+				continue
+			}
+
+			opCtx, stop := context.WithCancel(ctx)
+			operations[msg.ID] = stop
+			atomic.AddInt64(&h.subscriptions, 1)
+			go func(id string) {
+				h.runGraphQLOperation(opCtx, client, id, params)
+				done <- id
+			}(msg.ID)
+		case gqlComplete:
+			if stop, ok := operations[msg.ID]; ok {
+				stop()
+			}
+		default:
+			h.closeGraphQLWS(client, gqlCloseBadRequest, fmt.Sprintf("Unknown message type %q", msg.Type))
+			return
+		}
+	}
+}
+
+// runGraphQLOperation sends the operation's results as next messages and a
+// complete message once it ends, unless the client completed it first. An
+// operation rejected before execution gets a single error message.
+func (h *WebSocketHandler) runGraphQLOperation(ctx context.Context, client *wsClient, id string, params graphQLRequest) {
+	sent := false
+	for result := range h.graphql.subscribe(ctx, params) {
+		// Drain after cancellation so the executor can exit
+		if ctx.Err() != nil {
+			continue
+		}
+		if !sent && result.Data == nil && len(result.Errors) > 0 {
+			payload, _ := json.Marshal(result.Errors)
+			h.sendGraphQLWS(client, graphQLWSMessage{ID: id, Type: gqlError, Payload: payload})
+			return
+		}
+		payload, _ := json.Marshal(result)
+		h.sendGraphQLWS(client, graphQLWSMessage{ID: id, Type: gqlNext, Payload: payload})
+		sent = true
+	}
+	if ctx.Err() == nil {
+		h.sendGraphQLWS(client, graphQLWSMessage{ID: id, Type: gqlComplete})
+	}
+}
+
+func (h *WebSocketHandler) sendGraphQLWS(client *wsClient, msg graphQLWSMessage) {
+	data, _ := json.Marshal(msg)
+	h.enqueue(client, data)
+}
+
+func (h *WebSocketHandler) sendGraphQLWSErrors(client *wsClient, id, message string) {
+	payload, _ := json.Marshal([]gqlerrors.FormattedError{gqlerrors.NewFormattedError(message)})
+	h.sendGraphQLWS(client, graphQLWSMessage{ID: id, Type: gqlError, Payload: payload})
+}
+
+// closeGraphQLWS closes the connection with a graphql-ws close code
+func (h *WebSocketHandler) closeGraphQLWS(client *wsClient, code int, reason string) {
+	client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(h.opts.WriteTimeout))
+	client.close()
+}
+
+// isGraphQLWS reports whether r asks to upgrade to graphql-ws
+func isGraphQLWS(r *http.Request) bool {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return false
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if strings.EqualFold(protocol, GraphQLWSProtocol) {
+			return true
+		}
+	}
+	return false
+}
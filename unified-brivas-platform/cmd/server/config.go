@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	gateway "github.com/brivas/unified-platform/apps/api-gateway"
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// EnvProduction is the APP_ENV of production deployments, where settings
+// without a safe default must be given
+const EnvProduction = "production"
+
+// AppConfig is the server's configuration, read from the environment by
+// LoadConfig
+type AppConfig struct {
+	// Environment is APP_ENV, "development" unless set
+	Environment string
+
+	DB *lumadb.Config
+	// DatabaseSchema is the schema APIs are generated for
+	DatabaseSchema string
+	// DBDebug reports rows left unclosed
+	DBDebug bool
+	// PoolMonitor enables pool saturation alerts and idle limit auto-tuning
+	// between PoolMinIdleConns and PoolMaxIdleConns
+	PoolMonitor      bool
+	PoolMinIdleConns int
+	PoolMaxIdleConns int
+
+	API *gateway.Config
+
+	// Digests runs the scheduler sending accounts' usage digests, and the
+	// dispatcher delivering them as webhooks
+	Digests bool
+	// LLM writes the digests' summaries; without a provider key they
+	// carry stats only
+	LLM *llm.Config
+}
+
+// LoadConfig reads the configuration from the environment, once. Variables
+// not set there are taken from the file named by CONFIG_FILE, or ./.env
+// when present, which holds KEY=value or YAML-style KEY: value lines.
+// Malformed values are reported rather than replaced by their defaults,
+// all of them in one error.
+func LoadConfig() (*AppConfig, error) {
+	file, err := configFile(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	return loadConfig(envOverFile(os.LookupEnv, file))
+}
+
+// envOverFile looks variables up in the environment, then in the file's
+// variables
+func envOverFile(lookupEnv func(string) (string, bool), file map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		if v, ok := lookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := file[key]
+		return v, ok
+	}
+}
+
+// configFile reads the variables of a configuration file. Without a path,
+// ./.env is read if it exists.
+func configFile(path string) (map[string]string, error) {
+	if path == "" {
+		if _, err := os.Stat(".env"); err != nil {
+			return nil, nil
+		}
+		path = ".env"
+	}
+	vars, err := godotenv.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return vars, nil
+}
+
+func loadConfig(lookup func(string) (string, bool)) (*AppConfig, error) {
+	env := &envReader{lookup: lookup}
+
+	cfg := &AppConfig{
+		Environment: env.str("APP_ENV", "development"),
+		DB: &lumadb.Config{
+			Host:            env.str("LUMADB_HOST", "localhost"),
+			Port:            env.port("LUMADB_PORT", 5432),
+			Database:        env.str("LUMADB_DATABASE", "brivas"),
+			User:            env.str("LUMADB_USER", "brivas"),
+			Password:        env.str("LUMADB_PASSWORD", ""),
+			SSLMode:         env.str("LUMADB_SSLMODE", "disable"),
+			MaxOpenConns:    env.int("LUMADB_MAX_OPEN_CONNS", 100, 1),
+			MaxIdleConns:    env.int("LUMADB_MAX_IDLE_CONNS", 25, 0),
+			ConnMaxLifetime: 5 * time.Minute,
+			ConnMaxIdleTime: 1 * time.Minute,
+		},
+		DatabaseSchema:   env.str("LUMADB_SCHEMA", gateway.DefaultDatabaseSchema),
+		DBDebug:          env.bool("LUMADB_DEBUG", false),
+		PoolMonitor:      env.bool("LUMADB_POOL_MONITOR", false),
+		PoolMinIdleConns: env.int("LUMADB_MIN_IDLE_CONNS", 0, 0),
+		PoolMaxIdleConns: env.int("LUMADB_MAX_IDLE_CONNS_LIMIT", 0, 0),
+
+		API: &gateway.Config{
+			Port:             env.port("API_PORT", 8080),
+			Host:             env.str("API_HOST", "0.0.0.0"),
+			EnableGraphQL:    env.bool("ENABLE_GRAPHQL", true),
+			EnableREST:       env.bool("ENABLE_REST", true),
+			EnableWebSocket:  env.bool("ENABLE_WEBSOCKET", true),
+			EnableMCP:        env.bool("ENABLE_MCP", true),
+			EnableCORS:       env.bool("ENABLE_CORS", true),
+			AllowedOrigins:   env.list("CORS_ALLOWED_ORIGINS", []string{"*"}),
+			AllowCredentials: env.bool("CORS_ALLOW_CREDENTIALS", false),
+
+			WebSocketAllowNoOrigin: env.bool("WEBSOCKET_ALLOW_NO_ORIGIN", false),
+			WebSocket: gateway.WebSocketOptions{
+				MaxSubscriptions: env.int("WEBSOCKET_MAX_SUBSCRIPTIONS", 20, 1),
+				SendBuffer:       env.int("WEBSOCKET_SEND_BUFFER", 256, 1),
+				SlowClientPolicy: env.str("WEBSOCKET_SLOW_CLIENT_POLICY", gateway.SlowClientDrop),
+				WriteTimeout:     10 * time.Second,
+			},
+			EnableRateLimit: env.bool("ENABLE_RATE_LIMIT", true),
+			RateLimits:      gateway.DefaultRateLimits(),
+
+			EnableIdempotency: env.bool("ENABLE_IDEMPOTENCY", true),
+			Idempotency:       gateway.DefaultIdempotencyOptions(),
+
+			MaxDepth:      env.int("GRAPHQL_MAX_DEPTH", 10, 1),
+			MaxComplexity: env.int("GRAPHQL_MAX_COMPLEXITY", 1000, 1),
+			MaxBatchSize:  env.int("GRAPHQL_MAX_BATCH_SIZE", gateway.DefaultMaxBatchSize, 1),
+			BulkChunkSize: env.int("REST_BULK_CHUNK_SIZE", gateway.DefaultBulkChunkSize, 1),
+
+			AutoSoftDelete: env.bool("AUTO_SOFT_DELETE", false),
+			StrictSchema:   env.bool("STRICT_SCHEMA", false),
+
+			RLSMode: env.str("RLS_MODE", gateway.RLSModeGateway),
+		},
+
+		Digests: env.bool("ENABLE_DIGESTS", false),
+		LLM: &llm.Config{
+			Gemini:    &llm.GeminiConfig{APIKey: env.str("GEMINI_API_KEY", "")},
+			OpenAI:    &llm.OpenAIConfig{APIKey: env.str("OPENAI_API_KEY", "")},
+			Anthropic: &llm.AnthropicConfig{APIKey: env.str("ANTHROPIC_API_KEY", "")},
+		},
+	}
+	if cfg.Environment == EnvProduction && cfg.DB.Password == "" {
+		env.errs = append(env.errs, errors.New("LUMADB_PASSWORD is required when APP_ENV is production"))
+	}
+	switch cfg.API.WebSocket.SlowClientPolicy {
+	case gateway.SlowClientDrop, gateway.SlowClientDisconnect:
+	default:
+		env.errs = append(env.errs, fmt.Errorf("WEBSOCKET_SLOW_CLIENT_POLICY must be %q or %q",
+			gateway.SlowClientDrop, gateway.SlowClientDisconnect))
+	}
+
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// envReader reads typed variables, collecting the errors of malformed ones
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *envReader) value(key string) (string, bool) {
+	v, ok := e.lookup(key)
+	v = strings.TrimSpace(v)
+	return v, ok && v != ""
+}
+
+func (e *envReader) str(key, defaultValue string) string {
+	if v, ok := e.value(key); ok {
+		return v
+	}
+	return defaultValue
+}
+
+// int reads an integer of at least minValue
+func (e *envReader) int(key string, defaultValue, minValue int) int {
+	v, ok := e.value(key)
+	if !ok {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not an integer", key, v))
+		return defaultValue
+	}
+	if n < minValue {
+		e.errs = append(e.errs, fmt.Errorf("%s: must be at least %d, got %d", key, minValue, n))
+		return defaultValue
+	}
+	return n
+}
+
+func (e *envReader) port(key string, defaultValue int) int {
+	n := e.int(key, defaultValue, 1)
+	if n > 65535 {
+		e.errs = append(e.errs, fmt.Errorf("%s: %d is not a valid port", key, n))
+		return defaultValue
+	}
+	return n
+}
+
+func (e *envReader) bool(key string, defaultValue bool) bool {
+	v, ok := e.value(key)
+	if !ok {
+		return defaultValue
+	}
+	switch strings.ToLower(v) {
+	case "true", "1", "yes", "on":
+		return true
+	case "false", "0", "no", "off":
+		return false
+	}
+	e.errs = append(e.errs, fmt.Errorf("%s: %q is not a boolean", key, v))
+	return defaultValue
+}
+
+// list reads a comma-separated list, dropping empty items
+func (e *envReader) list(key string, defaultValue []string) []string {
+	v, ok := e.value(key)
+	if !ok {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// vars looks keys up in m, as the environment would
+func vars(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := loadConfig(vars(nil))
+	if err != nil {
+		t.Fatalf("Expected the defaults to load, got %v", err)
+	}
+	if cfg.Environment != "development" || cfg.DB.Port != 5432 || cfg.API.Port != 8080 || !cfg.API.EnableGraphQL || cfg.Digests {
+		t.Errorf("Unexpected defaults: %+v, db %+v, api %+v", cfg, cfg.DB, cfg.API)
+	}
+
+	cfg, err = loadConfig(vars(map[string]string{
+		"LUMADB_PORT":          " 6432 ",
+		"ENABLE_GRAPHQL":       "off",
+		"ENABLE_DIGESTS":       "YES",
+		"CORS_ALLOWED_ORIGINS": "https://a.example, ,https://b.example",
+		"APP_ENV":              EnvProduction,
+		"LUMADB_PASSWORD":      "secret",
+	}))
+	if err != nil {
+		t.Fatalf("Expected the variables to load, got %v", err)
+	}
+	if cfg.DB.Port != 6432 || cfg.API.EnableGraphQL || !cfg.Digests || len(cfg.API.AllowedOrigins) != 2 {
+		t.Errorf("Unexpected configuration: db %+v, api %+v", cfg.DB, cfg.API)
+	}
+
+	for _, tc := range []struct {
+		name string
+		vars map[string]string
+		want []string
+	}{
+		{name: "malformed int", vars: map[string]string{"LUMADB_MAX_OPEN_CONNS": "many"},
+			want: []string{`LUMADB_MAX_OPEN_CONNS: "many" is not an integer`}},
+		{name: "int below minimum", vars: map[string]string{"GRAPHQL_MAX_DEPTH": "0"},
+			want: []string{"GRAPHQL_MAX_DEPTH: must be at least 1, got 0"}},
+		{name: "port out of range", vars: map[string]string{"API_PORT": "70000"},
+			want: []string{"API_PORT: 70000 is not a valid port"}},
+		{name: "negative port", vars: map[string]string{"LUMADB_PORT": "-1"},
+			want: []string{"LUMADB_PORT: must be at least 1"}},
+		{name: "malformed bool", vars: map[string]string{"ENABLE_REST": "maybe"},
+			want: []string{`ENABLE_REST: "maybe" is not a boolean`}},
+		{name: "production without a password", vars: map[string]string{"APP_ENV": EnvProduction},
+			want: []string{"LUMADB_PASSWORD is required"}},
+		{name: "unknown slow client policy", vars: map[string]string{"WEBSOCKET_SLOW_CLIENT_POLICY": "block"},
+			want: []string{"WEBSOCKET_SLOW_CLIENT_POLICY must be"}},
+		{name: "every error reported", vars: map[string]string{"API_PORT": "http", "ENABLE_MCP": "2"},
+			want: []string{"API_PORT", "ENABLE_MCP"}},
+	} {
+		_, err := loadConfig(vars(tc.vars))
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %q in %v", tc.name, want, err)
+			}
+		}
+	}
+}
+
+func TestEnvOverFile(t *testing.T) {
+	env := vars(map[string]string{"API_PORT": "9090", "APP_ENV": ""})
+	file := map[string]string{"API_PORT": "8081", "LUMADB_HOST": "db", "APP_ENV": EnvProduction}
+	lookup := envOverFile(env, file)
+
+	for key, want := range map[string]string{"API_PORT": "9090", "LUMADB_HOST": "db", "APP_ENV": ""} {
+		if got, ok := lookup(key); !ok || got != want {
+			t.Errorf("%s: expected %q, got %q (set %v)", key, want, got, ok)
+		}
+	}
+	if _, ok := lookup("API_HOST"); ok {
+		t.Error("Expected a variable set nowhere to be missing")
+	}
+
+	// A variable set empty in the environment still hides the file's value,
+	// and reads as unset
+	cfg, err := loadConfig(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.API.Port != 9090 || cfg.DB.Host != "db" || cfg.Environment != "development" {
+		t.Errorf("Expected the environment over the file, got port %d, host %q, env %q",
+			cfg.API.Port, cfg.DB.Host, cfg.Environment)
+	}
+}
@@ -3,7 +3,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,7 +11,11 @@ import (
 	"go.uber.org/zap"
 
 	gateway "github.com/brivas/unified-platform/apps/api-gateway"
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
 	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+	"github.com/brivas/unified-platform/packages/telemetry"
+	ai "github.com/brivas/unified-platform/services/ai-service"
+	sms "github.com/brivas/unified-platform/services/sms-service"
 )
 
 func main() {
@@ -25,19 +28,19 @@ func main() {
 		zap.Time("startup", time.Now()),
 	)
 
+	// Initialize tracing (OTLP exporter configured via OTEL_EXPORTER_OTLP_* env)
+	shutdownTracing, err := telemetry.Init(context.Background(), "unified-brivas-platform", "1.0.0")
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
+
 	// Load configuration from environment
-	dbConfig := &lumadb.Config{
-		Host:            getEnv("LUMADB_HOST", "localhost"),
-		Port:            getEnvInt("LUMADB_PORT", 5432),
-		Database:        getEnv("LUMADB_DATABASE", "brivas"),
-		User:            getEnv("LUMADB_USER", "brivas"),
-		Password:        getEnv("LUMADB_PASSWORD", ""),
-		SSLMode:         getEnv("LUMADB_SSLMODE", "disable"),
-		MaxOpenConns:    getEnvInt("LUMADB_MAX_OPEN_CONNS", 100),
-		MaxIdleConns:    getEnvInt("LUMADB_MAX_IDLE_CONNS", 25),
-		ConnMaxLifetime: 5 * time.Minute,
-		ConnMaxIdleTime: 1 * time.Minute,
+	cfg, err := LoadConfig()
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
+	dbConfig := cfg.DB
 
 	// Connect to LumaDB
 	db, err := lumadb.Connect(dbConfig)
@@ -52,9 +55,26 @@ func main() {
 		zap.String("database", dbConfig.Database),
 	)
 
+	// Report rows left unclosed, with the stack that queried them
+	if cfg.DBDebug {
+		db.SetDebugLogger(logger)
+	}
+
+	// Pool saturation alerts and idle limit auto-tuning are opt-in
+	if cfg.PoolMonitor {
+		go db.MonitorPool(context.Background(), lumadb.PoolMonitorConfig{
+			SaturationThreshold: lumadb.DefaultSaturationThreshold,
+			MinIdleConns:        cfg.PoolMinIdleConns,
+			MaxIdleConns:        cfg.PoolMaxIdleConns,
+			Logger:              logger,
+		})
+	}
+
 	// Create API engine
 	engine := gateway.NewUnifiedAPIEngine(db, logger)
 
+	engine.SetDatabaseSchema(cfg.DatabaseSchema)
+
 	// Load schema from database
 	ctx := context.Background()
 	if err := engine.LoadSchemaFromDB(ctx); err != nil {
@@ -62,21 +82,28 @@ func main() {
 	}
 
 	// Configure and generate APIs
-	apiConfig := &gateway.Config{
-		Port:            getEnvInt("API_PORT", 8080),
-		Host:            getEnv("API_HOST", "0.0.0.0"),
-		EnableGraphQL:   getEnvBool("ENABLE_GRAPHQL", true),
-		EnableREST:      getEnvBool("ENABLE_REST", true),
-		EnableWebSocket: getEnvBool("ENABLE_WEBSOCKET", true),
-		EnableMCP:       getEnvBool("ENABLE_MCP", true),
-		EnableCORS:      getEnvBool("ENABLE_CORS", true),
-		AllowedOrigins:  []string{"*"},
-	}
+	apiConfig := cfg.API
 
 	if err := engine.GenerateAPIs(apiConfig); err != nil {
 		logger.Fatal("Failed to generate APIs", zap.Error(err))
 	}
 
+	// Scheduled usage digests are queued and delivered as webhooks
+	if cfg.Digests {
+		orchestrator, err := llm.NewOrchestrator(cfg.LLM, logger)
+		if err != nil {
+			logger.Fatal("Failed to create LLM orchestrator", zap.Error(err))
+		}
+		webhooks := sms.NewWebhookDispatcher(db, logger, 0, 0, nil)
+		digests := ai.NewService(db, orchestrator, logger)
+		digests.SetWebhookSender(webhooks)
+
+		digestCtx, stopDigests := context.WithCancel(ctx)
+		defer stopDigests()
+		go webhooks.Run(digestCtx)
+		go digests.RunDigests(digestCtx)
+	}
+
 	// Graceful shutdown handling
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
@@ -93,32 +120,9 @@ func main() {
 		zap.Bool("rest", apiConfig.EnableREST),
 		zap.Bool("websocket", apiConfig.EnableWebSocket),
 		zap.Bool("mcp", apiConfig.EnableMCP),
+		zap.Bool("digests", cfg.Digests),
 	)
 
 	<-shutdown
 	logger.Info("Shutting down...")
 }
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		var result int
-		if _, err := fmt.Sscanf(value, "%d", &result); err == nil {
-			return result
-		}
-	}
-	return defaultValue
-}
-
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return value == "true" || value == "1" || value == "yes"
-	}
-	return defaultValue
-}
@@ -16,4 +16,14 @@ require (
 	github.com/rs/cors v1.10.1
 	github.com/joho/godotenv v1.5.1
 	go.uber.org/zap v1.27.0
+	go.uber.org/goleak v1.3.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+	go.opentelemetry.io/otel/metric v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.24.0
+	golang.org/x/crypto v0.18.0
+	github.com/prometheus/client_golang v1.19.0
+	golang.org/x/sync v0.6.0
+	golang.org/x/sys v0.17.0
 )
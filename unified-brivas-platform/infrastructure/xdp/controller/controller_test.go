@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// flows yields states in order, as the conntrack iterator would
+func flows(states ...flowState) func() (flowState, bool) {
+	return func() (flowState, bool) {
+		if len(states) == 0 {
+			return flowState{}, false
+		}
+		state := states[0]
+		states = states[1:]
+		return state, true
+	}
+}
+
+func TestCountActiveFlows(t *testing.T) {
+	idle := uint64(flowIdleTimeout.Nanoseconds())
+	now := 10 * idle
+	counts := countActiveFlows(now, flows(
+		flowState{LastSeen: now, BackendIdx: 1, Pool: poolSIP},
+		flowState{LastSeen: now - idle, BackendIdx: 1, Pool: poolSIP},
+		flowState{LastSeen: now - idle - 1, BackendIdx: 1, Pool: poolSIP},
+		flowState{LastSeen: now, BackendIdx: 1, Pool: poolAPI},
+		flowState{LastSeen: now, BackendIdx: 2, Pool: poolAPI, Closed: 1},
+	))
+	want := map[backendSlot]int{{pool: poolSIP, index: 1}: 2, {pool: poolAPI, index: 1}: 1}
+	if len(counts) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, counts)
+	}
+	for slot, n := range want {
+		if counts[slot] != n {
+			t.Errorf("Expected %d flows on %+v, got %d", n, slot, counts[slot])
+		}
+	}
+
+	// A clock reading below the timeout must not wrap the cutoff around
+	counts = countActiveFlows(idle/2, flows(flowState{LastSeen: 1, BackendIdx: 3, Pool: poolSIP}))
+	if counts[backendSlot{pool: poolSIP, index: 3}] != 1 {
+		t.Errorf("Expected a flow seen after boot to be active, got %v", counts)
+	}
+}
+
+func TestDrainBackends(t *testing.T) {
+	busy := backendSlot{pool: poolSIP, index: 1}
+	idle := backendSlot{pool: poolAPI, index: 2}
+	draining := map[backendSlot]bool{busy: true, idle: true}
+
+	polls := 0
+	active := func() (map[backendSlot]int, error) {
+		polls++
+		if polls == 1 {
+			return map[backendSlot]int{busy: 3}, nil
+		}
+		return map[backendSlot]int{}, nil
+	}
+	var removed []backendSlot
+	remove := func(slot backendSlot) error {
+		removed = append(removed, slot)
+		return nil
+	}
+
+	if err := drainBackends(context.Background(), draining, active, remove); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != idle || removed[1] != busy {
+		t.Errorf("Expected the idle backend removed first and the busy one once drained, got %v", removed)
+	}
+	if len(draining) != 0 {
+		t.Errorf("Expected nothing left draining, got %v", draining)
+	}
+
+	// Backends with flows left when ctx is done stay in place
+	draining = map[backendSlot]bool{busy: true}
+	removed = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stuck := func() (map[backendSlot]int, error) { return map[backendSlot]int{busy: 1}, nil }
+	if err := drainBackends(ctx, draining, stuck, remove); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to end the drain, got %v", err)
+	}
+	if len(removed) != 0 || !draining[busy] {
+		t.Errorf("Expected the busy backend kept, got removed %v, draining %v", removed, draining)
+	}
+
+	// A failed removal is reported
+	draining = map[backendSlot]bool{idle: true}
+	failing := func(backendSlot) error { return errors.New("map full") }
+	if err := drainBackends(context.Background(), draining, stuck, failing); err == nil {
+		t.Error("Expected the failed removal to be reported")
+	}
+}
+
+func TestLinkStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		linked   uint32
+		current  int
+		detached bool
+		ok       bool
+	}{
+		{name: "attached", linked: 3, current: 3, ok: true},
+		{name: "cleared by the kernel", linked: 0, current: 3, detached: true},
+		{name: "interface re-created", linked: 3, current: 7, detached: true},
+		{name: "lookup failed", linked: 3, current: 0},
+	} {
+		err := linkStatus("eth0", tc.linked, tc.current)
+		if tc.ok != (err == nil) || errors.Is(err, errDetached) != tc.detached {
+			t.Errorf("%s: expected ok %v, detached %v, got %v", tc.name, tc.ok, tc.detached, err)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	now := func(time.Duration) <-chan time.Time {
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	attachCalls := func(failures int, calls *int) func() error {
+		return func() error {
+			*calls++
+			if *calls <= failures {
+				return errors.New("device busy")
+			}
+			return nil
+		}
+	}
+
+	// A link whose state cannot be read is not touched
+	lb := &XDPLoadBalancer{iface: "eth0", attached: true}
+	calls := 0
+	unknown := func() error { return errors.New("reading link info: EAGAIN") }
+	lb.reconcile(context.Background(), unknown, attachCalls(0, &calls), now)
+	if state := lb.AttachState(); calls != 0 || !state.Attached {
+		t.Errorf("Expected no re-attach on an unreadable link, got %d attaches, %+v", calls, state)
+	}
+
+	// A confirmed detach re-attaches, retrying with growing backoff
+	var waits []time.Duration
+	after := func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		return now(d)
+	}
+	detached := func() error { return fmt.Errorf("link %w", errDetached) }
+	lb.reconcile(context.Background(), detached, attachCalls(2, &calls), after)
+	if state := lb.AttachState(); calls != 3 || !state.Attached || state.Reattaches != 1 {
+		t.Errorf("Expected a re-attach on the third try, got %d attaches, %+v", calls, state)
+	}
+	if len(waits) != 2 || waits[0] != reattachMinBackoff || waits[1] != 2*reattachMinBackoff {
+		t.Errorf("Expected doubling backoff, got %v", waits)
+	}
+
+	// Failed re-attaches stop when ctx is done and leave the program detached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	never := func(time.Duration) <-chan time.Time { return nil }
+	lb.reconcile(ctx, detached, attachCalls(1, &calls), never)
+	if state := lb.AttachState(); calls != 1 || state.Attached || state.Reattaches != 1 {
+		t.Errorf("Expected one failed attempt before giving up, got %d attaches, %+v", calls, state)
+	}
+}
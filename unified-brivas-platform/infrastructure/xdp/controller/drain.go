@@ -0,0 +1,173 @@
+// Connection draining for backend removal and controller restarts
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// Backend pools, matching POOL_* in xdp_lb.c
+const (
+	poolSIP uint8 = 0
+	poolAPI uint8 = 1
+)
+
+// flowIdleTimeout is how long a flow without packets still counts as
+// active. UDP SIP flows never close explicitly, and a TCP connection whose
+// FIN was not seen would otherwise hold a drain open until the timeout.
+// A TCP flow the client closed or reset counts as drained right away.
+const flowIdleTimeout = 60 * time.Second
+
+const drainPollInterval = 500 * time.Millisecond
+
+// flowKey and flowState mirror struct flow_key and struct flow_state
+type flowKey struct {
+	SrcIP   uint32
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+	_       [3]byte
+}
+
+type flowState struct {
+	LastSeen   uint64
+	BackendIdx uint32
+	Pool       uint8
+	Closed     uint8
+	_          [2]byte
+}
+
+// backendSlot identifies a backend by pool and map index
+type backendSlot struct {
+	pool  uint8
+	index uint32
+}
+
+func (lb *XDPLoadBalancer) backends(pool uint8) *ebpf.Map {
+	if pool == poolAPI {
+		return lb.objs.ApiBackends
+	}
+	return lb.objs.SipBackends
+}
+
+// RemoveSIPBackend stops new flows to a SIP backend. Its existing flows
+// keep being forwarded until Drain removes it.
+func (lb *XDPLoadBalancer) RemoveSIPBackend(index int) error {
+	return lb.removeBackend(backendSlot{pool: poolSIP, index: uint32(index)})
+}
+
+// RemoveAPIBackend stops new flows to an API backend. Its existing flows
+// keep being forwarded until Drain removes it.
+func (lb *XDPLoadBalancer) RemoveAPIBackend(index int) error {
+	return lb.removeBackend(backendSlot{pool: poolAPI, index: uint32(index)})
+}
+
+func (lb *XDPLoadBalancer) removeBackend(slot backendSlot) error {
+	var backend Backend
+	if err := lb.backends(slot.pool).Lookup(slot.index, &backend); err != nil {
+		return fmt.Errorf("looking up backend %d: %w", slot.index, err)
+	}
+	if backend.IP == 0 {
+		return nil
+	}
+
+	backend.Weight = 0
+	if err := lb.backends(slot.pool).Put(slot.index, &backend); err != nil {
+		return fmt.Errorf("draining backend %d: %w", slot.index, err)
+	}
+	lb.draining[slot] = true
+	return nil
+}
+
+// ActiveFlows counts the flows seen within flowIdleTimeout per backend
+func (lb *XDPLoadBalancer) ActiveFlows() (map[backendSlot]int, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return nil, fmt.Errorf("reading clock: %w", err)
+	}
+
+	var key flowKey
+	var state flowState
+	iter := lb.objs.Conntrack.Iterate()
+	// bpf_ktime_get_ns reads the same monotonic clock
+	counts := countActiveFlows(uint64(ts.Nano()), func() (flowState, bool) {
+		ok := iter.Next(&key, &state)
+		return state, ok
+	})
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating conntrack: %w", err)
+	}
+	return counts, nil
+}
+
+// countActiveFlows counts per backend the open flows next yields that were
+// seen within flowIdleTimeout of now, a monotonic clock reading
+func countActiveFlows(now uint64, next func() (flowState, bool)) map[backendSlot]int {
+	// Shortly after boot the clock reads less than the timeout, and every
+	// flow seen so far is recent
+	var cutoff uint64
+	if idle := uint64(flowIdleTimeout.Nanoseconds()); now > idle {
+		cutoff = now - idle
+	}
+
+	counts := make(map[backendSlot]int)
+	for {
+		state, ok := next()
+		if !ok {
+			return counts
+		}
+		if state.Closed == 0 && state.LastSeen >= cutoff {
+			counts[backendSlot{pool: state.Pool, index: state.BackendIdx}]++
+		}
+	}
+}
+
+// Drain waits until the removed backends have no active flows, or ctx is
+// done, and then deletes them. It returns ctx's error if flows remained.
+func (lb *XDPLoadBalancer) Drain(ctx context.Context) error {
+	return drainBackends(ctx, lb.draining, lb.ActiveFlows, func(slot backendSlot) error {
+		return lb.backends(slot.pool).Put(slot.index, &Backend{})
+	})
+}
+
+// drainBackends polls activeFlows until none of the draining backends has
+// any, removing each as it empties, or until ctx is done
+func drainBackends(ctx context.Context, draining map[backendSlot]bool, activeFlows func() (map[backendSlot]int, error), remove func(backendSlot) error) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		counts, err := activeFlows()
+		if err != nil {
+			return err
+		}
+
+		remaining := 0
+		for slot := range draining {
+			if counts[slot] == 0 {
+				if err := remove(slot); err != nil {
+					return fmt.Errorf("removing backend %d: %w", slot.index, err)
+				}
+				delete(draining, slot)
+				continue
+			}
+			remaining += counts[slot]
+		}
+		if len(draining) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("Drain timed out with %d active flows on %d backends", remaining, len(draining))
+			return ctx.Err()
+		}
+	}
+}
@@ -4,12 +4,15 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,11 +29,28 @@ type Backend struct {
 }
 
 type XDPLoadBalancer struct {
-	objs  xdp_lbObjects
-	link  link.Link
-	iface string
+	objs     xdp_lbObjects
+	iface    string
+	draining map[backendSlot]bool
+
+	// mu guards the link, which Supervise replaces when re-attaching
+	mu         sync.Mutex
+	link       link.Link
+	attached   bool
+	reattaches uint64
 }
 
+// Stats are the XDP program's counters, summed over CPUs
+type Stats struct {
+	Packets     uint64
+	Bytes       uint64
+	SIPRequests uint64
+	Dropped     uint64
+}
+
+// defaultDrainTimeout bounds how long shutdown waits for in-flight flows
+const defaultDrainTimeout = 30 * time.Second
+
 func NewXDPLoadBalancer(iface string) (*XDPLoadBalancer, error) {
 	// Load pre-compiled BPF objects
 	objs := xdp_lbObjects{}
@@ -38,22 +58,19 @@ func NewXDPLoadBalancer(iface string) (*XDPLoadBalancer, error) {
 		return nil, fmt.Errorf("loading objects: %w", err)
 	}
 
+	lb := &XDPLoadBalancer{
+		objs:     objs,
+		iface:    iface,
+		draining: make(map[backendSlot]bool),
+	}
+
 	// Attach XDP program to interface
-	l, err := link.AttachXDP(link.XDPOptions{
-		Program:   objs.XdpLoadBalancer,
-		Interface: ifaceIndex(iface),
-		Flags:     link.XDPGenericMode, // Use XDPDriverMode for production
-	})
-	if err != nil {
+	if err := lb.attach(); err != nil {
 		objs.Close()
-		return nil, fmt.Errorf("attaching XDP: %w", err)
+		return nil, err
 	}
-
-	return &XDPLoadBalancer{
-		objs:  objs,
-		link:  l,
-		iface: iface,
-	}, nil
+	lb.attached = true
+	return lb, nil
 }
 
 func (lb *XDPLoadBalancer) AddSIPBackend(index int, ip string, port uint16, weight uint16) error {
@@ -74,30 +91,43 @@ func (lb *XDPLoadBalancer) AddAPIBackend(index int, ip string, port uint16, weig
 	return lb.objs.ApiBackends.Put(uint32(index), &backend)
 }
 
-func (lb *XDPLoadBalancer) GetStats() (packets, bytes, sipReqs, dropped uint64, err error) {
-	var val uint64
-
-	if err = lb.objs.Stats.Lookup(uint32(0), &val); err == nil {
-		packets = val
-	}
-	if err = lb.objs.Stats.Lookup(uint32(1), &val); err == nil {
-		bytes = val
-	}
-	if err = lb.objs.Stats.Lookup(uint32(2), &val); err == nil {
-		sipReqs = val
-	}
-	if err = lb.objs.Stats.Lookup(uint32(3), &val); err == nil {
-		dropped = val
+// GetStats reads the stats map, matching STAT_* in xdp_lb.c. It is a
+// per-CPU array, so each counter is the sum of the CPUs' values.
+func (lb *XDPLoadBalancer) GetStats() (Stats, error) {
+	var stats Stats
+	for key, dst := range []*uint64{&stats.Packets, &stats.Bytes, &stats.SIPRequests, &stats.Dropped} {
+		var perCPU []uint64
+		if err := lb.objs.Stats.Lookup(uint32(key), &perCPU); err != nil {
+			return Stats{}, fmt.Errorf("reading stat %d: %w", key, err)
+		}
+		for _, v := range perCPU {
+			*dst += v
+		}
 	}
-
-	return packets, bytes, sipReqs, dropped, nil
+	return stats, nil
 }
 
 func (lb *XDPLoadBalancer) Close() error {
-	lb.link.Close()
+	lb.mu.Lock()
+	if lb.link != nil {
+		lb.link.Close()
+		lb.link = nil
+	}
+	lb.mu.Unlock()
 	return lb.objs.Close()
 }
 
+// drainTimeout reads XDP_DRAIN_TIMEOUT, e.g. "45s"
+func drainTimeout() time.Duration {
+	if v := os.Getenv("XDP_DRAIN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("Invalid XDP_DRAIN_TIMEOUT %q, using %s", v, defaultDrainTimeout)
+	}
+	return defaultDrainTimeout
+}
+
 func ipToUint32(ip string) uint32 {
 	parsed := net.ParseIP(ip).To4()
 	if parsed == nil {
@@ -168,6 +198,21 @@ func main() {
 	log.Printf("XDP load balancer attached to %s", iface)
 	log.Printf("Performance: 100+ Gbps | Latency: 0.001ms")
 
+	// Re-attach the program if it gets detached
+	supervise, stopSupervise := context.WithCancel(context.Background())
+	defer stopSupervise()
+	go lb.Supervise(supervise)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", lb)
+	go func() {
+		addr := metricsAddr()
+		log.Printf("Serving metrics on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+
 	// Stats reporting
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -176,26 +221,56 @@ func main() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
-	var lastPackets, lastSipReqs, lastDropped uint64
+	var last Stats
+	var haveLast bool
 
 	for {
 		select {
 		case <-ticker.C:
-			packets, bytes, sipReqs, dropped, _ := lb.GetStats()
-
-			pps := (packets - lastPackets) / 5
-			sps := (sipReqs - lastSipReqs) / 5
-			dps := (dropped - lastDropped) / 5
-
-			log.Printf("Stats: %d pps | %d SIP/s | %d dropped/s | Total: %d packets, %d MB",
-				pps, sps, dps, packets, bytes/(1024*1024))
-
-			lastPackets = packets
-			lastSipReqs = sipReqs
-			lastDropped = dropped
+			stats, err := lb.GetStats()
+			if err != nil {
+				// Rates resume from the next good read instead of spanning the gap
+				log.Printf("Failed to read stats: %v", err)
+				haveLast = false
+				continue
+			}
+			if !lb.AttachState().Attached {
+				log.Printf("XDP program detached from %s, waiting for re-attach", iface)
+			}
+
+			if haveLast {
+				pps := (stats.Packets - last.Packets) / 5
+				sps := (stats.SIPRequests - last.SIPRequests) / 5
+				dps := (stats.Dropped - last.Dropped) / 5
+
+				log.Printf("Stats: %d pps | %d SIP/s | %d dropped/s | Total: %d packets, %d MB",
+					pps, sps, dps, stats.Packets, stats.Bytes/(1024*1024))
+			}
+			last, haveLast = stats, true
 
 		case <-sig:
 			log.Println("Shutting down XDP load balancer...")
+			stopSupervise()
+
+			// Stop new flows and let in-flight ones finish before detaching
+			for i := range backends {
+				if err := lb.RemoveSIPBackend(i); err != nil {
+					log.Printf("Failed to drain SIP backend %d: %v", i, err)
+				}
+			}
+			for i := range apiBackends {
+				if err := lb.RemoveAPIBackend(i); err != nil {
+					log.Printf("Failed to drain API backend %d: %v", i, err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout())
+			if err := lb.Drain(ctx); err != nil {
+				log.Printf("Detaching with flows still active: %v", err)
+			} else {
+				log.Println("All flows drained")
+			}
+			cancel()
 			return
 		}
 	}
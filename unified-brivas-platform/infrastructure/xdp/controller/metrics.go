@@ -0,0 +1,58 @@
+// Prometheus metrics for the controller
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// defaultMetricsAddr is where /metrics is served unless XDP_METRICS_ADDR
+// says otherwise
+const defaultMetricsAddr = ":9464"
+
+func metricsAddr() string {
+	if v := os.Getenv("XDP_METRICS_ADDR"); v != "" {
+		return v
+	}
+	return defaultMetricsAddr
+}
+
+// ServeHTTP serves the attachment state and packet counters in the
+// Prometheus text format. Counters are left out when the stats map cannot
+// be read rather than reported stale.
+func (lb *XDPLoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := lb.AttachState()
+	attached := 0
+	if state.Attached {
+		attached = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP xdp_attached Whether the XDP program is attached to the interface.\n")
+	fmt.Fprintf(w, "# TYPE xdp_attached gauge\n")
+	fmt.Fprintf(w, "xdp_attached{iface=%q} %d\n", lb.iface, attached)
+	fmt.Fprintf(w, "# HELP xdp_reattaches_total Times the XDP program was re-attached after being detached.\n")
+	fmt.Fprintf(w, "# TYPE xdp_reattaches_total counter\n")
+	fmt.Fprintf(w, "xdp_reattaches_total{iface=%q} %d\n", lb.iface, state.Reattaches)
+
+	stats, err := lb.GetStats()
+	if err != nil {
+		log.Printf("Reading stats for /metrics: %v", err)
+		return
+	}
+	for _, m := range []struct {
+		name, help string
+		value      uint64
+	}{
+		{"xdp_packets_total", "Packets seen by the XDP program.", stats.Packets},
+		{"xdp_bytes_total", "Bytes seen by the XDP program.", stats.Bytes},
+		{"xdp_sip_requests_total", "SIP requests load balanced.", stats.SIPRequests},
+		{"xdp_dropped_total", "Packets dropped.", stats.Dropped},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s{iface=%q} %d\n",
+			m.name, m.help, m.name, m.name, lb.iface, m.value)
+	}
+}
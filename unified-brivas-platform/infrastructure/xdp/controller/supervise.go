@@ -0,0 +1,166 @@
+// Link supervision: re-attaches the XDP program when it is detached
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cilium/ebpf/link"
+)
+
+const (
+	// superviseInterval is how often the link is checked
+	superviseInterval = 5 * time.Second
+	// Re-attach attempts back off exponentially between these bounds
+	reattachMinBackoff = time.Second
+	reattachMaxBackoff = time.Minute
+)
+
+// errDetached marks a confirmed detach, as opposed to a failure to read
+// the link's state
+var errDetached = errors.New("detached")
+
+// AttachState describes whether the program is attached, for /metrics
+type AttachState struct {
+	Attached   bool
+	Reattaches uint64
+}
+
+// attach attaches the XDP program to the interface, replacing a previous
+// link. The interface is looked up again since a re-created interface gets
+// a new index.
+func (lb *XDPLoadBalancer) attach() error {
+	index := ifaceIndex(lb.iface)
+	if index == 0 {
+		return fmt.Errorf("interface %s not found", lb.iface)
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.link != nil {
+		// Free the interface first, a second XDP link on it is refused
+		lb.link.Close()
+		lb.link = nil
+	}
+	l, err := link.AttachXDP(link.XDPOptions{
+		Program:   lb.objs.XdpLoadBalancer,
+		Interface: index,
+		Flags:     link.XDPGenericMode, // Use XDPDriverMode for production
+	})
+	if err != nil {
+		return fmt.Errorf("attaching XDP: %w", err)
+	}
+	lb.link = l
+	return nil
+}
+
+// checkLink returns why the program no longer handles the interface's
+// traffic, wrapping errDetached, or nil while it does. Other errors leave
+// the link's state unknown.
+func (lb *XDPLoadBalancer) checkLink() error {
+	lb.mu.Lock()
+	l := lb.link
+	lb.mu.Unlock()
+	if l == nil {
+		return fmt.Errorf("not attached: %w", errDetached)
+	}
+
+	info, err := l.Info()
+	if err != nil {
+		return fmt.Errorf("reading link info: %w", err)
+	}
+	var linked uint32
+	if xdp := info.XDP(); xdp != nil {
+		linked = xdp.Ifindex
+	}
+	return linkStatus(lb.iface, linked, ifaceIndex(lb.iface))
+}
+
+// linkStatus judges a link attached to interface index linked, given the
+// interface's current index. The kernel clears a link's ifindex when its
+// interface goes away; until then a failed lookup proves nothing.
+func linkStatus(iface string, linked uint32, current int) error {
+	switch {
+	case linked == 0:
+		return fmt.Errorf("link %w", errDetached)
+	case current == 0:
+		return fmt.Errorf("interface %s not found", iface)
+	case uint32(current) != linked:
+		return fmt.Errorf("interface %s was re-created: %w", iface, errDetached)
+	}
+	return nil
+}
+
+// Supervise checks the link every superviseInterval and re-attaches the
+// program with exponential backoff when it was detached, until ctx is done
+func (lb *XDPLoadBalancer) Supervise(ctx context.Context) {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lb.reconcile(ctx, lb.checkLink, lb.attach, time.After)
+	}
+}
+
+// reconcile re-attaches the program through attach when check confirms it
+// was detached, waiting on after between failed attempts, until it is
+// attached again or ctx is done. A link whose state cannot be read is left
+// alone: re-attaching closes it, which would interrupt working traffic.
+func (lb *XDPLoadBalancer) reconcile(ctx context.Context, check, attach func() error, after func(time.Duration) <-chan time.Time) {
+	err := check()
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, errDetached) {
+		log.Printf("Checking the XDP link on %s: %v", lb.iface, err)
+		return
+	}
+	lb.setAttached(false)
+	log.Printf("XDP program not attached to %s: %v", lb.iface, err)
+
+	backoff := reattachMinBackoff
+	for {
+		err := attach()
+		if err == nil {
+			lb.mu.Lock()
+			lb.attached = true
+			lb.reattaches++
+			lb.mu.Unlock()
+			log.Printf("XDP program re-attached to %s", lb.iface)
+			return
+		}
+		log.Printf("Re-attaching XDP to %s failed, retrying in %s: %v", lb.iface, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-after(backoff):
+		}
+		backoff = min(backoff*2, reattachMaxBackoff)
+	}
+}
+
+func (lb *XDPLoadBalancer) setAttached(attached bool) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.attached = attached
+}
+
+// AttachState returns the current attachment state
+func (lb *XDPLoadBalancer) AttachState() AttachState {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return AttachState{
+		Attached:   lb.attached,
+		Reattaches: lb.reattaches,
+	}
+}
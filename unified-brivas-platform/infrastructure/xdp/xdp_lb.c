@@ -38,6 +38,31 @@ struct rate_info {
     __u64 last_update;
 };
 
+// Backend pools
+#define POOL_SIP 0
+#define POOL_API 1
+
+// A flow is pinned to the backend chosen for its first packet so that
+// draining a backend (weight 0) only diverts new flows
+struct flow_key {
+    __u32 src_ip;
+    __u16 src_port;
+    __u16 dst_port;
+    __u8 proto;
+    __u8 pad[3];
+};
+
+// A closed flow keeps its entry, and its backend, until it ages out of the
+// LRU map, so the rest of the close handshake and any retransmissions reach
+// the same backend. The controller counts it as drained.
+struct flow_state {
+    __u64 last_seen;
+    __u32 backend_idx;
+    __u8 pool;
+    __u8 closed;
+    __u8 pad[2];
+};
+
 // BPF Maps
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
@@ -60,6 +85,14 @@ struct {
     __type(value, struct backend);
 } api_backends SEC(".maps");
 
+// Active flows, read by the controller to know when a drain is complete
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 1000000);
+    __type(key, struct flow_key);
+    __type(value, struct flow_state);
+} conntrack SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __uint(max_entries, 4);
@@ -82,6 +115,42 @@ static __always_inline __u32 maglev_hash(__u32 src_ip, __u16 src_port, __u32 num
     return hash % num_backends;
 }
 
+// Select the backend for a flow: the pinned one for known flows, otherwise
+// the hashed slot or the next slot after it that is configured with a
+// nonzero weight. A connection opening on the tuple of a closed flow is a
+// new flow. Returns NULL when no backend accepts new flows.
+static __always_inline struct backend *select_backend(void *backends, __u8 pool, struct flow_key *fk, int opening) {
+    __u64 now = bpf_ktime_get_ns();
+    struct backend *backend;
+
+    struct flow_state *flow = bpf_map_lookup_elem(&conntrack, fk);
+    if (flow && flow->pool == pool && !(flow->closed && opening)) {
+        __u32 idx = flow->backend_idx;
+        backend = bpf_map_lookup_elem(backends, &idx);
+        if (backend && backend->ip) {
+            flow->last_seen = now;
+            return backend;
+        }
+    }
+
+    __u32 start = maglev_hash(fk->src_ip, fk->src_port, MAX_BACKENDS);
+#pragma unroll
+    for (__u32 i = 0; i < MAX_BACKENDS; i++) {
+        __u32 idx = (start + i) % MAX_BACKENDS;
+        backend = bpf_map_lookup_elem(backends, &idx);
+        if (backend && backend->ip && backend->weight) {
+            struct flow_state new_flow = {
+                .last_seen = now,
+                .backend_idx = idx,
+                .pool = pool,
+            };
+            bpf_map_update_elem(&conntrack, fk, &new_flow, BPF_ANY);
+            return backend;
+        }
+    }
+    return NULL;
+}
+
 // Rate limiting check
 static __always_inline int check_rate_limit(__u32 src_ip) {
     struct rate_info *info;
@@ -113,6 +182,17 @@ static __always_inline int check_rate_limit(__u32 src_ip) {
     return 1;
 }
 
+// Mark a TCP flow closed once the client closes or resets it. Its entry
+// stays, so the packets that follow still reach the pinned backend until
+// the flow goes idle.
+static __always_inline void end_tcp_flow(struct tcphdr *tcp, struct flow_key *fk) {
+    if (!tcp->fin && !tcp->rst)
+        return;
+    struct flow_state *flow = bpf_map_lookup_elem(&conntrack, fk);
+    if (flow)
+        flow->closed = 1;
+}
+
 // Direct Server Return (DSR) - rewrite MAC only
 static __always_inline void do_dsr(struct ethhdr *eth, struct backend *backend) {
     // In production, lookup MAC from ARP table
@@ -169,9 +249,14 @@ int xdp_load_balancer(struct xdp_md *ctx) {
             }
             
             // Load balance to SIP backend
-            __u32 backend_idx = maglev_hash(src_ip, bpf_ntohs(udp->source), MAX_BACKENDS);
-            struct backend *backend = bpf_map_lookup_elem(&sip_backends, &backend_idx);
-            if (backend && backend->ip) {
+            struct flow_key fk = {
+                .src_ip = src_ip,
+                .src_port = bpf_ntohs(udp->source),
+                .dst_port = dest_port,
+                .proto = IPPROTO_UDP,
+            };
+            struct backend *backend = select_backend(&sip_backends, POOL_SIP, &fk, 0);
+            if (backend) {
                 // DSR: Forward to backend
                 do_dsr(eth, backend);
                 ip->daddr = backend->ip;
@@ -192,6 +277,13 @@ int xdp_load_balancer(struct xdp_md *ctx) {
             return XDP_PASS;
         
         dest_port = bpf_ntohs(tcp->dest);
+        struct flow_key fk = {
+            .src_ip = src_ip,
+            .src_port = bpf_ntohs(tcp->source),
+            .dst_port = dest_port,
+            .proto = IPPROTO_TCP,
+        };
+        int opening = tcp->syn && !tcp->ack;
         
         // SIP TLS traffic
         if (dest_port == SIP_TLS_PORT) {
@@ -202,24 +294,24 @@ int xdp_load_balancer(struct xdp_md *ctx) {
                 return XDP_DROP;
             }
             
-            __u32 backend_idx = maglev_hash(src_ip, bpf_ntohs(tcp->source), MAX_BACKENDS);
-            struct backend *backend = bpf_map_lookup_elem(&sip_backends, &backend_idx);
-            if (backend && backend->ip) {
+            struct backend *backend = select_backend(&sip_backends, POOL_SIP, &fk, opening);
+            if (backend) {
                 do_dsr(eth, backend);
                 ip->daddr = backend->ip;
                 ip->check = 0;
+                end_tcp_flow(tcp, &fk);
                 return XDP_TX;
             }
         }
         
         // API traffic
         if (dest_port == API_PORT) {
-            __u32 backend_idx = maglev_hash(src_ip, bpf_ntohs(tcp->source), MAX_BACKENDS);
-            struct backend *backend = bpf_map_lookup_elem(&api_backends, &backend_idx);
-            if (backend && backend->ip) {
+            struct backend *backend = select_backend(&api_backends, POOL_API, &fk, opening);
+            if (backend) {
                 do_dsr(eth, backend);
                 ip->daddr = backend->ip;
                 ip->check = 0;
+                end_tcp_flow(tcp, &fk);
                 return XDP_TX;
             }
         }
@@ -35,8 +35,8 @@ CREATE TABLE IF NOT EXISTS accounts (
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_accounts_reg_time ON accounts(reg_time);
-CREATE INDEX idx_accounts_email ON accounts(email);
+CREATE INDEX IF NOT EXISTS idx_accounts_reg_time ON accounts(reg_time);
+CREATE INDEX IF NOT EXISTS idx_accounts_email ON accounts(email);
 
 -- ============================================================================
 -- USER BUCKETS (Rate Limits & Balances)
@@ -57,7 +57,7 @@ CREATE TABLE IF NOT EXISTS user_buckets (
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_user_buckets_account_id ON user_buckets(account_id);
+CREATE INDEX IF NOT EXISTS idx_user_buckets_account_id ON user_buckets(account_id);
 
 -- ============================================================================
 -- SMS HISTORY & MESSAGING
@@ -84,11 +84,11 @@ CREATE TABLE IF NOT EXISTS sms_history (
     created_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_sms_history_account_id ON sms_history(account_id);
-CREATE INDEX idx_sms_history_u_aid ON sms_history(u_aid);
-CREATE INDEX idx_sms_history_rid ON sms_history(rid);
-CREATE INDEX idx_sms_history_sid ON sms_history(sid);
-CREATE INDEX idx_sms_history_sent_date ON sms_history(sent_date);
+CREATE INDEX IF NOT EXISTS idx_sms_history_account_id ON sms_history(account_id);
+CREATE INDEX IF NOT EXISTS idx_sms_history_u_aid ON sms_history(u_aid);
+CREATE INDEX IF NOT EXISTS idx_sms_history_rid ON sms_history(rid);
+CREATE INDEX IF NOT EXISTS idx_sms_history_sid ON sms_history(sid);
+CREATE INDEX IF NOT EXISTS idx_sms_history_sent_date ON sms_history(sent_date);
 
 -- ============================================================================
 -- FLASH CALL & VOICE OTP
@@ -111,8 +111,8 @@ CREATE TABLE IF NOT EXISTS flash_call_history (
     created_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_flash_call_account_id ON flash_call_history(account_id);
-CREATE INDEX idx_flash_call_u_aid ON flash_call_history(u_aid);
+CREATE INDEX IF NOT EXISTS idx_flash_call_account_id ON flash_call_history(account_id);
+CREATE INDEX IF NOT EXISTS idx_flash_call_u_aid ON flash_call_history(u_aid);
 
 -- ============================================================================
 -- SENDER IDS
@@ -134,7 +134,7 @@ CREATE TABLE IF NOT EXISTS sender_ids (
     UNIQUE(sender, type)
 );
 
-CREATE INDEX idx_sender_ids_account_id ON sender_ids(account_id);
+CREATE INDEX IF NOT EXISTS idx_sender_ids_account_id ON sender_ids(account_id);
 
 -- ============================================================================
 -- USER APPS & WEBHOOKS
@@ -151,8 +151,8 @@ CREATE TABLE IF NOT EXISTS user_apps (
     created_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_user_apps_account_id ON user_apps(account_id);
-CREATE INDEX idx_user_apps_slug ON user_apps(slug);
+CREATE INDEX IF NOT EXISTS idx_user_apps_account_id ON user_apps(account_id);
+CREATE INDEX IF NOT EXISTS idx_user_apps_slug ON user_apps(slug);
 
 -- ============================================================================
 -- APPLICATIONS REGISTRY
@@ -240,7 +240,7 @@ CREATE TABLE IF NOT EXISTS contacts (
     name VARCHAR(100)
 );
 
-CREATE INDEX idx_contacts_account_id ON contacts(account_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_account_id ON contacts(account_id);
 
 -- ============================================================================
 -- DEFAULT SMS RATES (Volume-based pricing)
@@ -301,8 +301,8 @@ CREATE TABLE IF NOT EXISTS billing_transactions (
     processed_at TIMESTAMP
 );
 
-CREATE INDEX idx_billing_tx_tenant_id ON billing_transactions(tenant_id);
-CREATE INDEX idx_billing_tx_created_at ON billing_transactions(created_at);
+CREATE INDEX IF NOT EXISTS idx_billing_tx_tenant_id ON billing_transactions(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_billing_tx_created_at ON billing_transactions(created_at);
 
 CREATE TABLE IF NOT EXISTS invoices (
     id SERIAL PRIMARY KEY,
@@ -324,7 +324,7 @@ CREATE TABLE IF NOT EXISTS invoices (
     sent_at TIMESTAMP
 );
 
-CREATE INDEX idx_invoices_tenant_id ON invoices(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_tenant_id ON invoices(tenant_id);
 
 -- ============================================================================
 -- RATE CARDS
@@ -363,8 +363,8 @@ CREATE TABLE IF NOT EXISTS sms_templates (
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_sms_templates_account_id ON sms_templates(account_id);
-CREATE INDEX idx_sms_templates_category ON sms_templates(category);
+CREATE INDEX IF NOT EXISTS idx_sms_templates_account_id ON sms_templates(account_id);
+CREATE INDEX IF NOT EXISTS idx_sms_templates_category ON sms_templates(category);
 
 -- ============================================================================
 -- CAMPAIGNS
@@ -390,8 +390,8 @@ CREATE TABLE IF NOT EXISTS campaigns (
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_campaigns_account_id ON campaigns(account_id);
-CREATE INDEX idx_campaigns_status ON campaigns(status);
+CREATE INDEX IF NOT EXISTS idx_campaigns_account_id ON campaigns(account_id);
+CREATE INDEX IF NOT EXISTS idx_campaigns_status ON campaigns(status);
 
 -- ============================================================================
 -- SERVICE ERRORS (Debugging & Monitoring)
@@ -428,4 +428,4 @@ CREATE TABLE IF NOT EXISTS resellers (
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_resellers_parent_account ON resellers(parent_account_id);
+CREATE INDEX IF NOT EXISTS idx_resellers_parent_account ON resellers(parent_account_id);
@@ -0,0 +1,20 @@
+-- Unified Brivas Platform - Gateway idempotency keys
+-- Stores the first response to each mutation sent with an Idempotency-Key header
+
+-- ============================================================================
+-- IDEMPOTENCY KEYS
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id SERIAL PRIMARY KEY,
+    cache_key VARCHAR(64) NOT NULL UNIQUE, -- sha256(account, method, path, key)
+    request_hash VARCHAR(64) NOT NULL,
+    status VARCHAR(20) DEFAULT 'in_progress', -- in_progress, completed
+    response_status INTEGER,
+    content_type VARCHAR(100),
+    response_body BYTEA,
+    created_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -0,0 +1,20 @@
+-- Unified Brivas Platform - Authorization audit log
+-- One row per grant or denial made by the authorization engine
+
+-- ============================================================================
+-- AUDIT LOG
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP NOT NULL,
+    account_id VARCHAR(100),
+    role VARCHAR(50) NOT NULL,
+    table_name VARCHAR(100) NOT NULL,
+    operation VARCHAR(20) NOT NULL, -- select, insert, update, delete
+    allowed BOOLEAN NOT NULL,
+    reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_occurred_at ON audit_log(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_account_id ON audit_log(account_id, occurred_at);
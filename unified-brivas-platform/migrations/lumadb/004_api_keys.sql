@@ -0,0 +1,20 @@
+-- Unified Brivas Platform - Scoped API keys
+-- Keys are stored as SHA-256 hashes; the plaintext is shown once at creation
+
+-- ============================================================================
+-- API KEYS
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(36) PRIMARY KEY,
+    account_id VARCHAR(15) NOT NULL REFERENCES accounts(id),
+    key_prefix VARCHAR(20) NOT NULL, -- e.g. live_3f9a2c1b, for identifying keys in listings
+    key_hash VARCHAR(64) NOT NULL UNIQUE, -- sha256(key)
+    scopes TEXT[] NOT NULL, -- table:operation, e.g. sms_history:insert, campaigns:*
+    is_live BOOLEAN NOT NULL,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_account_id ON api_keys(account_id);
@@ -0,0 +1,20 @@
+-- Unified Brivas Platform - TOTP multi-factor authentication
+-- MFA is enabled on the first verified code after enrollment
+
+-- ============================================================================
+-- MFA
+-- ============================================================================
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS mfa_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS mfa_secret VARCHAR(64); -- base32 TOTP secret
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS mfa_last_step BIGINT; -- last accepted time step, rejects replays
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    account_id VARCHAR(15) NOT NULL REFERENCES accounts(id),
+    code_hash VARCHAR(64) NOT NULL, -- sha256(code)
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_account_id ON mfa_recovery_codes(account_id);
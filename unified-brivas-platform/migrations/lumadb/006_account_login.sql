@@ -0,0 +1,10 @@
+-- Unified Brivas Platform - Password login
+-- Roles for token issuance and brute-force lockout state
+
+-- ============================================================================
+-- ACCOUNT LOGIN
+-- ============================================================================
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS role VARCHAR(20) DEFAULT 'user'; -- user, reseller, admin, super_admin
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
@@ -0,0 +1,17 @@
+-- Unified Brivas Platform - Plan quotas
+-- Monthly usage per account and metered resource, checked against the account's plan
+
+-- ============================================================================
+-- USAGE QUOTAS
+-- ============================================================================
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS plan VARCHAR(30) DEFAULT 'free';
+
+CREATE TABLE IF NOT EXISTS usage_counters (
+    account_id VARCHAR(15) NOT NULL REFERENCES accounts(id),
+    resource VARCHAR(50) NOT NULL, -- sms, llm_tokens, ...
+    period DATE NOT NULL, -- first day of the month (UTC)
+    used BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (account_id, resource, period)
+);
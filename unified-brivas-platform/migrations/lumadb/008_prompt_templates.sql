@@ -0,0 +1,16 @@
+-- Unified Brivas Platform - Prompt templates
+-- Versioned LLM prompts that replace the AI service's built-in prompts by name
+
+-- ============================================================================
+-- PROMPT TEMPLATES
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS prompt_templates (
+    name VARCHAR(100) NOT NULL,
+    version VARCHAR(30) NOT NULL,
+    weight INTEGER NOT NULL DEFAULT 1, -- share of traffic among active versions
+    messages JSONB NOT NULL, -- [{"role": "system", "content": "... {{.Var}} ..."}]
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (name, version)
+);
@@ -0,0 +1,20 @@
+-- Unified Brivas Platform - Vector documents
+-- Embeddings of account history for retrieval-augmented AI answers (pgvector)
+
+-- ============================================================================
+-- VECTOR DOCUMENTS
+-- ============================================================================
+
+CREATE EXTENSION IF NOT EXISTS vector;
+
+-- Dimensions depend on the embedding provider, so the column is unsized and
+-- searches only compare vectors of the query's dimension
+CREATE TABLE IF NOT EXISTS vector_documents (
+    id VARCHAR(100) PRIMARY KEY,
+    embedding vector NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}', -- account_id, kind, content, ...
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_vector_documents_metadata ON vector_documents USING GIN (metadata jsonb_path_ops);
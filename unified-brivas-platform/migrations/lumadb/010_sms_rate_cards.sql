@@ -0,0 +1,26 @@
+-- Unified Brivas Platform - SMS rate cards
+-- Per-message SMS prices by network and message type, for everyone, a plan, or one account
+-- (rate_cards holds the versioned multi-channel billing cards)
+
+-- ============================================================================
+-- SMS RATE CARDS
+-- ============================================================================
+
+-- NULL network, account_id and plan match any; account cards win over plan
+-- cards, which win over cards for everyone
+CREATE TABLE IF NOT EXISTS sms_rate_cards (
+    id SERIAL PRIMARY KEY,
+    network VARCHAR(10), -- MTN, AIRTEL, GLO, 9MOBILE
+    message_type VARCHAR(15) NOT NULL, -- otp, transactional, promotional, corporate
+    account_id VARCHAR(15) REFERENCES accounts(id) ON DELETE CASCADE,
+    plan VARCHAR(30),
+    rate DOUBLE PRECISION NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (account_id IS NULL OR plan IS NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sms_rate_cards_key ON sms_rate_cards(
+    COALESCE(network, ''), message_type, COALESCE(account_id, ''), COALESCE(plan, '')
+) WHERE active = TRUE;
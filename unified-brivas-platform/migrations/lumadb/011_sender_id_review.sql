@@ -0,0 +1,12 @@
+-- Unified Brivas Platform - Sender ID review
+-- Admin review of requested sender IDs: who decided, when, and why a request was rejected
+
+-- ============================================================================
+-- SENDER ID REVIEW
+-- ============================================================================
+
+ALTER TABLE sender_ids ADD COLUMN IF NOT EXISTS reason TEXT;
+ALTER TABLE sender_ids ADD COLUMN IF NOT EXISTS reviewed_by VARCHAR(45);
+ALTER TABLE sender_ids ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_sender_ids_status ON sender_ids(status);
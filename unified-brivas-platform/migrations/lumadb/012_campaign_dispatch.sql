@@ -0,0 +1,15 @@
+-- Unified Brivas Platform - Campaign dispatch
+-- What a campaign sends and to whom, and the bulk SID its messages are logged under in sms_history
+
+-- ============================================================================
+-- CAMPAIGN DISPATCH
+-- ============================================================================
+
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS message TEXT; -- falls back to the template's content
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS sms_type VARCHAR(15) DEFAULT 'promotional';
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS recipients JSONB DEFAULT '[]';
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS is_live BOOLEAN DEFAULT FALSE;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS sid VARCHAR(100);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_due ON campaigns(scheduled_at) WHERE status = 'scheduled';
@@ -0,0 +1,54 @@
+-- Unified Brivas Platform - Webhook deliveries
+-- Queued webhook events, every delivery attempt, and deliveries that ran out of retries
+
+-- ============================================================================
+-- WEBHOOK DELIVERIES
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    account_id VARCHAR(45) NOT NULL,
+    url TEXT NOT NULL,
+    event VARCHAR(50) NOT NULL, -- sms.status, sender_id.approved, ...
+    payload JSONB NOT NULL,
+    status VARCHAR(15) NOT NULL DEFAULT 'pending', -- pending, delivering, delivered, dead
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_account_id ON webhook_deliveries(account_id);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER, -- NULL when no response was received
+    error TEXT,
+    duration_ms INTEGER,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON webhook_attempts(delivery_id);
+
+-- ============================================================================
+-- WEBHOOK DEAD LETTER
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS webhook_deadletter (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    account_id VARCHAR(45) NOT NULL,
+    url TEXT NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT,
+    failed_at TIMESTAMP DEFAULT NOW(),
+    redelivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deadletter_account_id ON webhook_deadletter(account_id, failed_at);
@@ -0,0 +1,31 @@
+-- Unified Brivas Platform - Short links
+-- Per-recipient tracked links substituted into campaign messages, and their clicks
+
+-- ============================================================================
+-- SHORT LINKS
+-- ============================================================================
+
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS track_links BOOLEAN DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS short_links (
+    code VARCHAR(16) PRIMARY KEY,
+    target TEXT NOT NULL,
+    account_id VARCHAR(45) NOT NULL,
+    campaign_id VARCHAR(50),
+    recipient VARCHAR(45),
+    clicks INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_clicked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_short_links_campaign_id ON short_links(campaign_id);
+
+CREATE TABLE IF NOT EXISTS link_clicks (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(16) NOT NULL REFERENCES short_links(code) ON DELETE CASCADE,
+    ip VARCHAR(45),
+    user_agent TEXT,
+    clicked_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_clicks_code ON link_clicks(code);
@@ -0,0 +1,21 @@
+-- Unified Brivas Platform - Digest schedules
+-- Opt-in periodic usage digests (SMS stats plus an AI summary) delivered to an account's webhook
+
+-- ============================================================================
+-- DIGEST SCHEDULES
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS digest_schedules (
+    account_id VARCHAR(45) PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    cron VARCHAR(100) NOT NULL DEFAULT '0 8 * * *', -- minute hour day-of-month month day-of-week
+    timezone VARCHAR(50) NOT NULL DEFAULT 'Africa/Lagos',
+    period_days INTEGER NOT NULL DEFAULT 1, -- how many days of traffic each digest covers
+    webhook_url TEXT NOT NULL,
+    next_run_at TIMESTAMP NOT NULL,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_digest_schedules_due ON digest_schedules(next_run_at) WHERE enabled = TRUE;
@@ -0,0 +1,12 @@
+-- Unified Brivas Platform - SMS history time series
+-- Supports GET /bulk/insights/timeseries, which aggregates one account's bulk messages over a time range
+
+-- ============================================================================
+-- SMS HISTORY TIME SERIES
+-- ============================================================================
+
+-- The time series query filters on account_id and type and ranges over
+-- created_at; without this index it scans every row of the account. On a
+-- live database build it with CREATE INDEX CONCURRENTLY outside a
+-- transaction to avoid blocking writes.
+CREATE INDEX IF NOT EXISTS idx_sms_history_account_type_created ON sms_history(account_id, type, created_at);
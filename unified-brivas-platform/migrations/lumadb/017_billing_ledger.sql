@@ -0,0 +1,35 @@
+-- Unified Brivas Platform - Billing ledger
+-- billing_transactions becomes an append-only ledger of every top-up, charge and refund;
+-- accounts.balance caches the running total and is only changed together with a ledger entry
+
+-- ============================================================================
+-- BILLING LEDGER
+-- ============================================================================
+
+ALTER TABLE billing_transactions ADD COLUMN IF NOT EXISTS type VARCHAR(15); -- opening, topup, charge, refund
+ALTER TABLE billing_transactions ADD COLUMN IF NOT EXISTS balance_after DOUBLE PRECISION;
+ALTER TABLE billing_transactions ADD COLUMN IF NOT EXISTS reference VARCHAR(100); -- SID, message ID or payment reference
+ALTER TABLE billing_transactions ADD COLUMN IF NOT EXISTS description TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_billing_tx_tenant_created ON billing_transactions(tenant_id, created_at);
+
+-- Ledger entries are never changed; corrections are new entries
+CREATE OR REPLACE FUNCTION billing_transactions_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'billing_transactions entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS billing_transactions_immutable ON billing_transactions;
+CREATE TRIGGER billing_transactions_immutable
+    BEFORE UPDATE OR DELETE ON billing_transactions
+    FOR EACH ROW EXECUTE FUNCTION billing_transactions_immutable();
+
+-- Open the ledger with each account's current balance so entries sum to it
+INSERT INTO billing_transactions (transaction_id, tenant_id, channel, base_amount, sub_total, final_amount,
+    status, type, balance_after, description, idempotency_key, processed_at)
+SELECT 'TXN-OPEN-' || id, id, 'sms', balance, balance, balance,
+    'completed', 'opening', balance, 'Opening balance', 'opening:' || id, NOW()
+FROM accounts
+WHERE balance <> 0
+ON CONFLICT (idempotency_key) DO NOTHING;
@@ -0,0 +1,74 @@
+-- Unified Brivas Platform - SMS content filter
+-- Blocklist rules, per-account and per-plan filter strictness, and the messages the filter flagged or blocked
+
+-- ============================================================================
+-- CONTENT RULES
+-- ============================================================================
+
+-- A keyword matches case-insensitively anywhere in the body; a regex pattern
+-- uses Go RE2 syntax. Scores of the rules a message matches add up, to 100.
+CREATE TABLE IF NOT EXISTS content_rules (
+    id SERIAL PRIMARY KEY,
+    pattern TEXT NOT NULL,
+    is_regex BOOLEAN DEFAULT FALSE,
+    category VARCHAR(30) NOT NULL, -- phishing, fraud, gambling, adult, ...
+    score INT NOT NULL CHECK (score BETWEEN 0 AND 100),
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_content_rules_pattern ON content_rules(pattern);
+
+INSERT INTO content_rules (pattern, is_regex, category, score) VALUES
+    ('(?i)\b(verify|update|confirm)\b.{0,30}\b(bvn|nin|atm pin|card details)\b', TRUE, 'phishing', 80),
+    ('(?i)\byour (account|bvn) (has been|will be) (blocked|suspended)\b', TRUE, 'phishing', 60),
+    ('send your otp', FALSE, 'phishing', 90),
+    ('double your money', FALSE, 'fraud', 70),
+    ('you have won', FALSE, 'fraud', 40)
+ON CONFLICT (pattern) DO NOTHING;
+
+-- ============================================================================
+-- CONTENT POLICIES
+-- ============================================================================
+
+-- Strictness sets the flag and block thresholds: off, lenient, standard or
+-- strict. An account's policy wins over its plan's; accounts with neither
+-- use standard without AI scoring.
+CREATE TABLE IF NOT EXISTS content_policies (
+    id SERIAL PRIMARY KEY,
+    account_id VARCHAR(15) REFERENCES accounts(id) ON DELETE CASCADE,
+    plan VARCHAR(30),
+    strictness VARCHAR(10) NOT NULL DEFAULT 'standard',
+    ai_scoring BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK ((account_id IS NULL) <> (plan IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_content_policies_owner ON content_policies(
+    COALESCE(account_id, ''), COALESCE(plan, '')
+);
+
+-- Approved senders an admin trusts skip the filter
+ALTER TABLE sender_ids ADD COLUMN IF NOT EXISTS bypass_content_filter BOOLEAN DEFAULT FALSE;
+
+-- ============================================================================
+-- FLAGGED MESSAGES
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS flagged_messages (
+    id SERIAL PRIMARY KEY,
+    account_id VARCHAR(15) NOT NULL,
+    sid VARCHAR(100) NOT NULL,
+    sender VARCHAR(255),
+    message TEXT NOT NULL,
+    recipients INT NOT NULL,
+    score INT NOT NULL,
+    verdict VARCHAR(10) NOT NULL, -- flagged (sent), blocked (rejected)
+    matches JSONB DEFAULT '[]',
+    strictness VARCHAR(10),
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_flagged_messages_account ON flagged_messages(account_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_flagged_messages_created ON flagged_messages(created_at);
@@ -0,0 +1,12 @@
+-- Unified Brivas Platform - SMS history by network
+-- Records each message's network and delivery time, for GET /bulk/insights/by-network
+
+-- ============================================================================
+-- SMS HISTORY NETWORK
+-- ============================================================================
+
+-- Rows written before this migration have no network and are reported under
+-- an empty one. delivered_at is set when a delivered DLR is flushed, so
+-- latencies are accurate to the DLR flush interval.
+ALTER TABLE sms_history ADD COLUMN IF NOT EXISTS network VARCHAR(10);
+ALTER TABLE sms_history ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
@@ -0,0 +1,29 @@
+-- Unified Brivas Platform - Scheduled messages
+-- Single SMS sends held until their scheduled_at, and the balance they reserve meanwhile
+
+-- ============================================================================
+-- SCHEDULED MESSAGES
+-- ============================================================================
+
+-- A live message reserves rate_per_sms of its account's balance while it is
+-- scheduled; it is charged only once the scheduler sends it.
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id VARCHAR(50) PRIMARY KEY,
+    account_id VARCHAR(45) NOT NULL,
+    sid VARCHAR(100) NOT NULL,
+    sender VARCHAR(100),
+    recipient VARCHAR(45) NOT NULL,
+    message TEXT NOT NULL,
+    network VARCHAR(10),
+    rate_per_sms DOUBLE PRECISION NOT NULL,
+    is_live BOOLEAN DEFAULT FALSE,
+    status VARCHAR(15) NOT NULL DEFAULT 'scheduled', -- scheduled, sending, sent, failed, cancelled
+    error TEXT,
+    scheduled_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(scheduled_at) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_reserved ON scheduled_messages(account_id) WHERE status = 'scheduled';
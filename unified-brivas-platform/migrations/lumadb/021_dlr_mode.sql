@@ -0,0 +1,13 @@
+-- Unified Brivas Platform - DLR delivery mode
+-- Whether an account's delivery reports are pushed to its webhooks, polled from the status endpoints, or both
+
+-- ============================================================================
+-- DLR MODE
+-- ============================================================================
+
+-- push and both queue a webhook per DLR; pull queues none. GET /status/{sid}
+-- and GET /message/{rid} answer in every mode.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS dlr_mode VARCHAR(10) DEFAULT 'push';
+
+-- GET /message/{rid} looks messages up by rid (idx_sms_history_rid) and
+-- GET /status/{sid} by sid (idx_sms_history_sid)
@@ -0,0 +1,15 @@
+-- Unified Brivas Platform - SMS suppressions
+-- Recipients an account must not message, skipped and counted as suppressed by bulk sends
+
+-- ============================================================================
+-- SMS SUPPRESSIONS
+-- ============================================================================
+
+-- recipient is the formatted number (2348031234567), as logged in sms_history
+CREATE TABLE IF NOT EXISTS sms_suppressions (
+    account_id VARCHAR(45) NOT NULL,
+    recipient VARCHAR(45) NOT NULL,
+    reason VARCHAR(100),
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (account_id, recipient)
+);
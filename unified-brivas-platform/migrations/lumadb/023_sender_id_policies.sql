@@ -0,0 +1,36 @@
+-- Unified Brivas Platform - Sender ID policies
+-- Which kinds of sender ID each destination country, network and message type accepts
+
+-- ============================================================================
+-- SENDER ID POLICIES
+-- ============================================================================
+
+-- country_code is the dial prefix recipients are matched on, longest first.
+-- NULL network and message_type match any; the most specific row wins.
+-- Recipients in countries without a policy accept any sender ID.
+CREATE TABLE IF NOT EXISTS sender_id_policies (
+    id SERIAL PRIMARY KEY,
+    country_code VARCHAR(5) NOT NULL,
+    network VARCHAR(10),
+    message_type VARCHAR(15),
+    allow_alphanumeric BOOLEAN DEFAULT TRUE,
+    allow_long_code BOOLEAN DEFAULT TRUE,
+    allow_short_code BOOLEAN DEFAULT TRUE,
+    registration_required BOOLEAN DEFAULT FALSE, -- requested senders must be approved sender IDs
+    default_sender VARCHAR(20), -- used when the request names no sender
+    note TEXT, -- explains rejections to the caller
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sender_id_policies_key ON sender_id_policies(
+    country_code, COALESCE(network, ''), COALESCE(message_type, '')
+) WHERE active = TRUE;
+
+INSERT INTO sender_id_policies
+    (country_code, allow_alphanumeric, allow_long_code, allow_short_code, registration_required, default_sender, note)
+VALUES
+    ('234', TRUE, TRUE, TRUE, TRUE, 'BRIVAS', 'Nigerian networks only deliver from sender IDs registered with them'),
+    ('1', FALSE, TRUE, TRUE, TRUE, NULL, 'US and Canadian carriers block alphanumeric sender IDs; use a registered long code or short code')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,12 @@
+-- Unified Brivas Platform - DLR latency
+-- Carrier-reported delivery times, send-to-delivery latency and attempt counts per message
+
+-- ============================================================================
+-- DLR LATENCY
+-- ============================================================================
+
+-- delivered_at now holds the carrier's done date when its DLR has one, and
+-- the time the DLR was received otherwise. delivery_latency_ms is measured
+-- from created_at, when the message was sent.
+ALTER TABLE sms_history ADD COLUMN IF NOT EXISTS delivery_latency_ms BIGINT;
+ALTER TABLE sms_history ADD COLUMN IF NOT EXISTS dlr_attempts INTEGER; -- delivery attempts the carrier reported, if any
@@ -0,0 +1,14 @@
+-- Unified Brivas Platform - Webhook signing secrets
+-- The secret an account's webhooks are HMAC-signed with, and the one it replaced during a rotation
+
+-- ============================================================================
+-- WEBHOOK SECRETS
+-- ============================================================================
+
+-- Deliveries are signed with webhook_secret. POST /webhooks/secret/rotate
+-- moves it to webhook_secret_previous, which receivers should keep accepting
+-- until webhook_secret_previous_expires_at.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS webhook_secret VARCHAR(64);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS webhook_secret_created_at TIMESTAMP;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS webhook_secret_previous VARCHAR(64);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS webhook_secret_previous_expires_at TIMESTAMP;
@@ -0,0 +1,11 @@
+-- Unified Brivas Platform - SMS history by account and date
+-- Supports the AI service's optimal-schedule, fraud analysis and digest aggregations, which read one account's recent history
+
+-- ============================================================================
+-- SMS HISTORY BY ACCOUNT AND DATE
+-- ============================================================================
+
+-- The aggregations filter on account_id and range over sent_date. On a
+-- live database build it with CREATE INDEX CONCURRENTLY outside a
+-- transaction to avoid blocking writes.
+CREATE INDEX IF NOT EXISTS idx_sms_history_account_sent_date ON sms_history(account_id, sent_date);
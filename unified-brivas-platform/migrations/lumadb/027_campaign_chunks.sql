@@ -0,0 +1,27 @@
+-- Unified Brivas Platform - Campaign chunks
+-- A running campaign's recipients split into batches, each checkpointed once sent, so that
+-- paused or interrupted campaigns resume where they stopped
+
+-- ============================================================================
+-- CAMPAIGN CHUNKS
+-- ============================================================================
+
+-- A chunk is sending while a runner holds it; one left sending past the
+-- runner's lease is taken over, minus the recipients already in sms_history.
+CREATE TABLE IF NOT EXISTS campaign_chunks (
+    campaign_id VARCHAR(50) NOT NULL REFERENCES campaigns(campaign_id) ON DELETE CASCADE,
+    chunk_index INTEGER NOT NULL,
+    recipients JSONB NOT NULL,
+    recipient_count INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending', -- pending, sending, sent, failed
+    sent_count INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, chunk_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaign_chunks_open ON campaign_chunks(campaign_id, chunk_index)
+    WHERE status IN ('pending', 'sending');
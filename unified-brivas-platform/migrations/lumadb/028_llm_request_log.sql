@@ -0,0 +1,20 @@
+-- Unified Brivas Platform - LLM request log
+-- Completion requests and responses kept for debugging and replay, when request logging is enabled
+
+-- ============================================================================
+-- LLM REQUEST LOG
+-- ============================================================================
+
+-- entry is the llm.RequestLogEntry as JSON, content redacted or omitted as
+-- configured; the other columns are copied out of it for searching.
+CREATE TABLE IF NOT EXISTS llm_request_log (
+    id VARCHAR(40) PRIMARY KEY,
+    provider VARCHAR(50),
+    model VARCHAR(100),
+    error TEXT,
+    replay_of VARCHAR(40),
+    entry JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_request_log_created_at ON llm_request_log(created_at);
@@ -0,0 +1,22 @@
+-- Unified Brivas Platform - AI usage
+-- Token usage and estimated cost of every LLM completion, per account, for AI spend reporting
+
+-- ============================================================================
+-- AI USAGE
+-- ============================================================================
+
+-- cost and currency are NULL for models without a price
+CREATE TABLE IF NOT EXISTS ai_usage (
+    id BIGSERIAL PRIMARY KEY,
+    account_id VARCHAR(50) NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    model VARCHAR(100),
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    cost NUMERIC(14, 6),
+    currency VARCHAR(3),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_usage_account_created ON ai_usage(account_id, created_at);
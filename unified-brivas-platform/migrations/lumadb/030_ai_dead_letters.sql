@@ -0,0 +1,23 @@
+-- Unified Brivas Platform - AI dead letters
+-- Batch job items that failed every attempt, kept for inspection and resubmission
+
+-- ============================================================================
+-- AI DEAD LETTERS
+-- ============================================================================
+
+-- item is the job item as submitted, as JSON; account_id is NULL for jobs
+-- not run on behalf of an account
+CREATE TABLE IF NOT EXISTS ai_dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    job_id VARCHAR(40) NOT NULL,
+    job VARCHAR(50) NOT NULL,
+    item_index INTEGER NOT NULL,
+    item JSONB,
+    error TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    account_id VARCHAR(50),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_dead_letters_job_id ON ai_dead_letters(job_id);
+CREATE INDEX IF NOT EXISTS idx_ai_dead_letters_account_created ON ai_dead_letters(account_id, created_at);
@@ -0,0 +1,42 @@
+-- Unified Brivas Platform - Prompt experiments
+-- Weighted A/B tests of prompt variants, with the responses each variant produced and their reported outcomes
+
+-- ============================================================================
+-- PROMPT EXPERIMENTS
+-- ============================================================================
+
+-- variants is [{"id": "...", "weight": 1, "messages": [{"role": "system", "content": "..."}]}]
+CREATE TABLE IF NOT EXISTS prompt_experiments (
+    id VARCHAR(50) PRIMARY KEY,
+    prompt VARCHAR(100) NOT NULL,
+    variants JSONB NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A prompt has at most one active experiment
+CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_experiments_active_prompt
+    ON prompt_experiments(prompt) WHERE active;
+
+-- One row per response a variant produced
+CREATE TABLE IF NOT EXISTS prompt_experiment_exposures (
+    response_id VARCHAR(40) PRIMARY KEY,
+    experiment_id VARCHAR(50) NOT NULL REFERENCES prompt_experiments(id) ON DELETE CASCADE,
+    variant VARCHAR(100) NOT NULL,
+    account_id VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_prompt_experiment_exposures_experiment
+    ON prompt_experiment_exposures(experiment_id, variant);
+
+-- score is optional, such as a 1-5 satisfaction rating
+CREATE TABLE IF NOT EXISTS prompt_experiment_outcomes (
+    response_id VARCHAR(40) PRIMARY KEY REFERENCES prompt_experiment_exposures(response_id) ON DELETE CASCADE,
+    experiment_id VARCHAR(50) NOT NULL,
+    variant VARCHAR(100) NOT NULL,
+    success BOOLEAN NOT NULL,
+    score NUMERIC(10, 4),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,11 @@
+-- Unified Brivas Platform - Message webhooks
+-- A webhook given with a message, which receives its delivery reports instead of its app's webhook
+
+-- ============================================================================
+-- MESSAGE WEBHOOKS
+-- ============================================================================
+
+-- Only https URLs resolving to public addresses are stored; messages without
+-- one report to their app's webhook as before.
+ALTER TABLE sms_history ADD COLUMN IF NOT EXISTS webhook TEXT;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS webhook TEXT;
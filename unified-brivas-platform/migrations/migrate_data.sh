@@ -24,10 +24,14 @@ echo "Unified Brivas Platform - Data Migration"
 echo "============================================"
 
 # Step 1: Apply LumaDB schema
+# Migrations are idempotent, so re-runs are safe; ON_ERROR_STOP makes a
+# failing statement fail psql, and with it this script
 echo ""
 echo "[Step 1/5] Applying LumaDB schema..."
-PGPASSWORD="${LUMADB_PASSWORD}" psql -h "$LUMADB_HOST" -p "$LUMADB_PORT" -U "$LUMADB_USER" -d "$LUMADB_DB" \
-    -f ./migrations/lumadb/001_initial_schema.sql
+for migration in ./migrations/lumadb/*.sql; do
+    PGPASSWORD="${LUMADB_PASSWORD}" psql -h "$LUMADB_HOST" -p "$LUMADB_PORT" -U "$LUMADB_USER" -d "$LUMADB_DB" \
+        -v ON_ERROR_STOP=1 -f "$migration"
+done
 echo "  ✓ Schema applied"
 
 # Step 2: Migrate accounts from MySQL
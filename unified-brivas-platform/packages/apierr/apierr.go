@@ -0,0 +1,105 @@
+// Package apierr writes the error envelope every service answers a failed
+// request with:
+//
+//	{"error": {"code": "not_found", "message": "...", "request_id": "..."}}
+//
+// The request id is the one middleware.RequestID assigned, so an error a
+// client reports can be found in the server's logs
+package apierr
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Error codes. Clients may rely on them; messages may change.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthorized     = "unauthorized"
+	CodePaymentRequired  = "payment_required"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodePayloadTooLarge  = "payload_too_large"
+	CodeUnprocessable    = "unprocessable"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+	CodeTimeout          = "timeout"
+)
+
+// Error is the body of a failed request's envelope
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Envelope is the response to a failed request
+type Envelope struct {
+	Error Error `json:"error"`
+}
+
+// CodeForStatus returns the code of an error answered with status
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusPaymentRequired:
+		return CodePaymentRequired
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound, http.StatusGone:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// RequestID returns the id of r: the one middleware.RequestID assigned, or
+// the X-Request-Id header of a service not mounted behind it
+func RequestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get(middleware.RequestIDHeader)
+}
+
+// Write answers r with status and an envelope with the status's code
+func Write(w http.ResponseWriter, r *http.Request, message string, status int) {
+	WriteCode(w, r, CodeForStatus(status), message, status)
+}
+
+// WriteCode answers r with status and an envelope with code. The request
+// id is also sent as the X-Request-Id header.
+func WriteCode(w http.ResponseWriter, r *http.Request, code, message string, status int) {
+	id := RequestID(r)
+	if id != "" {
+		w.Header().Set(middleware.RequestIDHeader, id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Envelope{Error: Error{Code: code, Message: message, RequestID: id}})
+}
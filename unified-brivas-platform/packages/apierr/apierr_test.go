@@ -0,0 +1,64 @@
+// Package apierr provides tests for the error envelope
+package apierr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestCodeForStatus(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusBadRequest:          CodeInvalidRequest,
+		http.StatusUnauthorized:        CodeUnauthorized,
+		http.StatusPaymentRequired:     CodePaymentRequired,
+		http.StatusForbidden:           CodeForbidden,
+		http.StatusNotFound:            CodeNotFound,
+		http.StatusConflict:            CodeConflict,
+		http.StatusUnprocessableEntity: CodeUnprocessable,
+		http.StatusTooManyRequests:     CodeRateLimited,
+		http.StatusTeapot:              CodeInvalidRequest,
+		http.StatusInternalServerError: CodeInternal,
+		http.StatusServiceUnavailable:  CodeUnavailable,
+		http.StatusGatewayTimeout:      CodeTimeout,
+	} {
+		if got := CodeForStatus(status); got != want {
+			t.Errorf("%d: expected %q, got %q", status, want, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var assigned string
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assigned = middleware.GetReqID(r.Context())
+		Write(w, r, "account not found", http.StatusNotFound)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var resp Envelope
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := Error{Code: CodeNotFound, Message: "account not found", RequestID: assigned}
+	if rec.Code != http.StatusNotFound || assigned == "" || resp.Error != want {
+		t.Errorf("Expected 404 with %+v, got %d %+v", want, rec.Code, resp.Error)
+	}
+	if got := rec.Header().Get("X-Request-Id"); got != assigned {
+		t.Errorf("Expected X-Request-Id %q, got %q", assigned, got)
+	}
+
+	// Without the middleware the caller's header is used
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	WriteCode(rec, req, "quota_exceeded", "monthly sms quota exceeded", http.StatusPaymentRequired)
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if want := (Error{Code: "quota_exceeded", Message: "monthly sms quota exceeded", RequestID: "req-1"}); resp.Error != want {
+		t.Errorf("Expected %+v, got %+v", want, resp.Error)
+	}
+}
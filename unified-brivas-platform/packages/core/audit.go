@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// ErrAuditQueueFull is returned when an asynchronous sink cannot accept
+// another event without blocking
+var ErrAuditQueueFull = errors.New("audit queue full")
+
+// DefaultAuditQueueSize is the number of events TableAuditSink buffers
+const DefaultAuditQueueSize = 1024
+
+// AuditEvent records one authorization decision
+type AuditEvent struct {
+	Timestamp time.Time  `json:"timestamp"`
+	AccountID string     `json:"account_id"`
+	Role      Role       `json:"role"`
+	Table     string     `json:"table"`
+	Operation Permission `json:"operation"`
+	Allowed   bool       `json:"allowed"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// AuditSink receives authorization decisions. Record is called on the
+// request path and must not block; a sink that cannot keep up returns an
+// error and the engine falls back to its logger.
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// LoggerAuditSink writes audit events as structured log entries
+type LoggerAuditSink struct {
+	logger *zap.Logger
+}
+
+// NewLoggerAuditSink creates the default sink
+func NewLoggerAuditSink(logger *zap.Logger) *LoggerAuditSink {
+	return &LoggerAuditSink{logger: logger}
+}
+
+// Record logs the event, denials at warn level
+func (s *LoggerAuditSink) Record(ctx context.Context, event AuditEvent) error {
+	logAuditEvent(s.logger, "authorization decision", event)
+	return nil
+}
+
+func logAuditEvent(logger *zap.Logger, msg string, event AuditEvent) {
+	fields := []zap.Field{
+		zap.Time("timestamp", event.Timestamp),
+		zap.String("account_id", event.AccountID),
+		zap.String("role", string(event.Role)),
+		zap.String("table", event.Table),
+		zap.String("operation", string(event.Operation)),
+		zap.Bool("allowed", event.Allowed),
+	}
+	if event.Reason != "" {
+		fields = append(fields, zap.String("reason", event.Reason))
+	}
+	if event.Allowed {
+		logger.Info(msg, fields...)
+	} else {
+		logger.Warn(msg, fields...)
+	}
+}
+
+// TableAuditSink stores audit events in the audit_log table. Events are
+// queued and written by a background worker so the request never waits on
+// the database; events that cannot be stored are logged instead.
+type TableAuditSink struct {
+	db     lumadb.Querier
+	logger *zap.Logger
+	queue  chan AuditEvent
+	done   chan struct{}
+}
+
+// NewTableAuditSink starts a sink buffering up to queueSize events
+func NewTableAuditSink(db lumadb.Querier, queueSize int, logger *zap.Logger) *TableAuditSink {
+	if queueSize <= 0 {
+		queueSize = DefaultAuditQueueSize
+	}
+	s := &TableAuditSink{
+		db:     db,
+		logger: logger,
+		queue:  make(chan AuditEvent, queueSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Record queues the event, failing rather than blocking when full
+func (s *TableAuditSink) Record(ctx context.Context, event AuditEvent) error {
+	select {
+	case s.queue <- event:
+		return nil
+	default:
+		return ErrAuditQueueFull
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be written
+func (s *TableAuditSink) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+func (s *TableAuditSink) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := s.db.Exec(ctx, `
+			INSERT INTO audit_log (occurred_at, account_id, role, table_name, operation, allowed, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, event.Timestamp, event.AccountID, string(event.Role), event.Table, string(event.Operation), event.Allowed, event.Reason)
+		cancel()
+		if err != nil {
+			s.logger.Error("failed to store audit event", zap.Error(err))
+			logAuditEvent(s.logger, "authorization decision", event)
+		}
+	}
+}
+
+// SetAuditSink replaces the sink receiving authorization decisions
+func (e *AuthorizationEngine) SetAuditSink(sink AuditSink) {
+	e.audit = sink
+}
+
+// recordDecision reports a decision to the audit sink. A sink failure is
+// logged together with the event, so denials are recorded even when the
+// sink is unavailable.
+func (e *AuthorizationEngine) recordDecision(ctx context.Context, table string, op Permission, claims *Claims, err error) {
+	if e.audit == nil {
+		return
+	}
+
+	event := AuditEvent{
+		Timestamp: time.Now().UTC(),
+		AccountID: claims.AccountID,
+		Role:      claims.Role,
+		Table:     table,
+		Operation: op,
+		Allowed:   err == nil,
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+
+	if sinkErr := e.audit.Record(ctx, event); sinkErr != nil {
+		e.logger.Error("failed to record audit event", zap.Error(sinkErr))
+		logAuditEvent(e.logger, "authorization decision", event)
+	}
+}
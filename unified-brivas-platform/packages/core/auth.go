@@ -4,11 +4,14 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
@@ -46,6 +49,10 @@ type Claims struct {
 	Permissions []string               `json:"permissions,omitempty"`
 	IsLive      bool                   `json:"is_live"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	// MFAPending marks a token that only CompleteMFA accepts
+	MFAPending bool `json:"mfa_pending,omitempty"`
+	// Quota is the remaining monthly quota per resource, loaded per request
+	Quota map[string]int64 `json:"-"`
 }
 
 // TablePermission defines permissions for a specific table
@@ -89,20 +96,41 @@ type DeletePermission struct {
 
 // AuthorizationEngine manages role-based access control
 type AuthorizationEngine struct {
-	db          *lumadb.Client
+	db          lumadb.Querier
 	logger      *zap.Logger
 	jwtSecret   []byte
+	issuer      string
+	audiences   []string
 	permissions map[string]map[Role]*TablePermission
+	audit       AuditSink
+
+	// mu guards permissions; cache holds resolved permissions by
+	// permissionKey and is replaced whenever the rules are reloaded
+	mu      sync.RWMutex
+	cache   atomic.Pointer[sync.Map]
+	lookups atomic.Uint64 // engine lookups, reported by benchmarks
+
+	mfaAttempts *attemptLimiter
+	metrics     *AuthMetrics
+	plans       map[string]PlanLimits
+	quotas      quotaCache
 }
 
+// DefaultTokenIssuer is the iss of first-party tokens
+const DefaultTokenIssuer = "brivas-platform"
+
 // NewAuthorizationEngine creates a new authorization engine
-func NewAuthorizationEngine(db *lumadb.Client, jwtSecret string, logger *zap.Logger) *AuthorizationEngine {
+func NewAuthorizationEngine(db lumadb.Querier, jwtSecret string, logger *zap.Logger) *AuthorizationEngine {
 	engine := &AuthorizationEngine{
 		db:          db,
 		logger:      logger,
 		jwtSecret:   []byte(jwtSecret),
+		issuer:      DefaultTokenIssuer,
 		permissions: make(map[string]map[Role]*TablePermission),
+		audit:       NewLoggerAuditSink(logger),
+		mfaAttempts: newAttemptLimiter(MaxMFAFailures, MFAFailureWindow),
 	}
+	engine.cache.Store(new(sync.Map))
 	engine.initializeDefaultPermissions()
 	return engine
 }
@@ -198,43 +226,85 @@ func (e *AuthorizationEngine) initializeDefaultPermissions() {
 }
 
 func (e *AuthorizationEngine) setPermission(perm *TablePermission) {
-	if _, ok := e.permissions[perm.Table]; !ok {
-		e.permissions[perm.Table] = make(map[Role]*TablePermission)
+	addPermission(e.permissions, perm)
+}
+
+func addPermission(rules map[string]map[Role]*TablePermission, perm *TablePermission) {
+	if _, ok := rules[perm.Table]; !ok {
+		rules[perm.Table] = make(map[Role]*TablePermission)
 	}
-	e.permissions[perm.Table][perm.Role] = perm
+	rules[perm.Table][perm.Role] = perm
 }
 
 // GetPermission returns the permission for a table and role
 func (e *AuthorizationEngine) GetPermission(table string, role Role) *TablePermission {
-	if tablePerms, ok := e.permissions[table]; ok {
-		if perm, ok := tablePerms[role]; ok {
-			return perm
+	e.lookups.Add(1)
+	key := permissionKey{table: table, role: role}
+	if perm, ok := e.cache.Load().Load(key); ok {
+		return perm.(*TablePermission)
+	}
+
+	// Resolve under the read lock so a concurrent reload cannot leave a
+	// stale entry in the new cache
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	perm := e.permissions[table][role]
+	e.cache.Load().Store(key, perm)
+	return perm
+}
+
+// CanSelectColumn reports whether role may read column from table. Only
+// select permissions that list columns restrict fields; table access itself
+// is enforced by ApplyRLS.
+func (e *AuthorizationEngine) CanSelectColumn(ctx context.Context, table string, role Role, column string) bool {
+	perm := e.GetPermissionContext(ctx, table, role)
+	if perm == nil || perm.Select == nil || len(perm.Select.Columns) == 0 {
+		return true
+	}
+	for _, c := range perm.Select.Columns {
+		if c == column {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-// GenerateToken generates a JWT token for a user
+// GenerateToken generates a JWT token for a user. Roles that require MFA
+// receive a short-lived mfa_pending token to exchange via CompleteMFA;
+// accounts that have not enrolled yet enroll with it first, through
+// MFAEnrollHandler.
 func (e *AuthorizationEngine) GenerateToken(accountID string, role Role, isLive bool) (string, error) {
-	claims := &Claims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "brivas-platform",
-		},
-		AccountID: accountID,
-		Role:      role,
-		IsLive:    isLive,
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(e.jwtSecret)
+	return e.issueToken(accountID, role, isLive, RequiresMFA(role))
 }
 
 // ValidateToken validates a JWT token
 func (e *AuthorizationEngine) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := e.parseToken(tokenString)
+	e.metrics.tokenValidated(claims, err)
+	return claims, err
+}
+
+// SetTokenValidation configures the issuer and audiences tokens must carry.
+// A token is accepted when its aud contains any of audiences; no audiences
+// disables the audience check and an empty issuer the issuer check. Issued
+// tokens carry the issuer and the first audience.
+func (e *AuthorizationEngine) SetTokenValidation(issuer string, audiences ...string) {
+	e.issuer = issuer
+	e.audiences = audiences
+}
+
+func (e *AuthorizationEngine) parseToken(tokenString string) (*Claims, error) {
+	var opts []jwt.ParserOption
+	if e.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(e.issuer))
+	}
+	if len(e.audiences) > 0 {
+		opts = append(opts, jwt.WithAudience(e.audiences...))
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return e.jwtSecret, nil
-	})
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -255,7 +325,7 @@ func (e *AuthorizationEngine) Middleware(next http.Handler) http.Handler {
 			if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
 				var err error
 				claims, err = e.ValidateToken(parts[1])
-				if err != nil {
+				if err != nil || claims.MFAPending {
 					claims = &Claims{Role: RoleAnonymous}
 				}
 			}
@@ -265,14 +335,22 @@ func (e *AuthorizationEngine) Middleware(next http.Handler) http.Handler {
 			claims = &Claims{Role: RoleAnonymous}
 		}
 
-		ctx := context.WithValue(r.Context(), "claims", claims)
+		e.attachQuota(r.Context(), claims)
+
+		ctx := context.WithValue(WithPermissionCache(r.Context()), "claims", claims)
 		r.Header.Set("X-Account-ID", claims.AccountID)
 		r.Header.Set("X-Role", string(claims.Role))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// validateAPIKey resolves scoped live_/test_ keys from api_keys, falling
+// back to the unscoped legacy keys stored on accounts
 func (e *AuthorizationEngine) validateAPIKey(ctx context.Context, apiKey string) *Claims {
+	if strings.HasPrefix(apiKey, LiveKeyPrefix) || strings.HasPrefix(apiKey, TestKeyPrefix) {
+		return e.lookupAPIKey(ctx, apiKey)
+	}
+
 	isLive := !strings.HasPrefix(apiKey, "tk_")
 	keyColumn := "live_secret_key"
 	if !isLive {
@@ -285,39 +363,69 @@ func (e *AuthorizationEngine) validateAPIKey(ctx context.Context, apiKey string)
 	), apiKey).Scan(&accountID)
 
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			e.metrics.apiKeyLookedUp(resultMiss)
+		} else {
+			e.metrics.apiKeyLookedUp(resultError)
+		}
 		return &Claims{Role: RoleAnonymous}
 	}
+	e.metrics.apiKeyLookedUp(resultHit)
 	return &Claims{AccountID: accountID, Role: RoleUser, IsLive: isLive}
 }
 
-// ApplyRLS modifies a query to add row-level security filters
-func (e *AuthorizationEngine) ApplyRLS(query, table string, op Permission, claims *Claims) (string, []interface{}, error) {
-	perm := e.GetPermission(table, claims.Role)
+// Authorize checks whether claims may perform op on table, within the
+// scopes of an API key, and records the decision in the audit log
+func (e *AuthorizationEngine) Authorize(ctx context.Context, table string, op Permission, claims *Claims) (*TablePermission, error) {
+	perm, err := authorizeOperation(e.GetPermissionContext(ctx, table, claims.Role), table, op, claims.Role)
+	if err == nil && !claims.HasScope(table, op) {
+		perm, err = nil, fmt.Errorf("api key scope does not allow %s on %s", op, table)
+	}
+	e.metrics.permissionChecked(table, op, err)
+	e.recordDecision(ctx, table, op, claims, err)
+	return perm, err
+}
+
+// authorizeOperation checks that perm allows op
+func authorizeOperation(perm *TablePermission, table string, op Permission, role Role) (*TablePermission, error) {
 	if perm == nil {
-		return "", nil, fmt.Errorf("no permission for %s on %s", claims.Role, table)
+		return nil, fmt.Errorf("no permission for %s on %s", role, table)
+	}
+
+	var allowed bool
+	switch op {
+	case PermissionSelect:
+		allowed = perm.Select != nil && perm.Select.Allowed
+	case PermissionInsert:
+		allowed = perm.Insert != nil && perm.Insert.Allowed
+	case PermissionUpdate:
+		allowed = perm.Update != nil && perm.Update.Allowed
+	case PermissionDelete:
+		allowed = perm.Delete != nil && perm.Delete.Allowed
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%s not allowed", op)
+	}
+	return perm, nil
+}
+
+// ApplyRLS modifies a query to add row-level security filters. The
+// decision is audited with ctx, the caller's request context.
+func (e *AuthorizationEngine) ApplyRLS(ctx context.Context, query, table string, op Permission, claims *Claims) (string, []interface{}, error) {
+	perm, err := e.Authorize(ctx, table, op, claims)
+	if err != nil {
+		return "", nil, err
 	}
 
 	var filter map[string]string
 	switch op {
 	case PermissionSelect:
-		if perm.Select == nil || !perm.Select.Allowed {
-			return "", nil, fmt.Errorf("select not allowed")
-		}
 		filter = perm.Select.Filter
 	case PermissionUpdate:
-		if perm.Update == nil || !perm.Update.Allowed {
-			return "", nil, fmt.Errorf("update not allowed")
-		}
 		filter = perm.Update.Filter
 	case PermissionDelete:
-		if perm.Delete == nil || !perm.Delete.Allowed {
-			return "", nil, fmt.Errorf("delete not allowed")
-		}
 		filter = perm.Delete.Filter
 	case PermissionInsert:
-		if perm.Insert == nil || !perm.Insert.Allowed {
-			return "", nil, fmt.Errorf("insert not allowed")
-		}
 		return query, nil, nil
 	}
 
@@ -351,11 +459,13 @@ func (e *AuthorizationEngine) PermissionsHandler() http.HandlerFunc {
 			claims = &Claims{Role: RoleAnonymous}
 		}
 		perms := make(map[string]*TablePermission)
+		e.mu.RLock()
 		for table, rolePerms := range e.permissions {
 			if p, ok := rolePerms[claims.Role]; ok {
 				perms[table] = p
 			}
 		}
+		e.mu.RUnlock()
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"role": claims.Role, "permissions": perms,
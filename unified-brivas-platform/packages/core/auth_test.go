@@ -0,0 +1,489 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/lumadb-client/lumadbtest"
+)
+
+func TestPermissionCacheReload(t *testing.T) {
+	engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	ctx := WithPermissionCache(context.Background())
+
+	if perm := engine.GetPermissionContext(ctx, "campaigns", RoleUser); perm == nil || perm.Delete == nil {
+		t.Fatalf("Expected the default campaigns permission, got %+v", perm)
+	}
+
+	engine.ReloadPermissions([]*TablePermission{
+		{Role: RoleUser, Table: "campaigns", Select: &SelectPermission{Allowed: true}},
+	})
+
+	if perm := engine.GetPermission("campaigns", RoleUser); perm == nil || perm.Delete != nil {
+		t.Errorf("Expected the reload to invalidate the engine cache, got %+v", perm)
+	}
+	if perm := engine.GetPermission("accounts", RoleAdmin); perm != nil {
+		t.Errorf("Expected permissions missing from the reload to be gone, got %+v", perm)
+	}
+
+	// The in-flight request keeps the view it started with
+	if perm := engine.GetPermissionContext(ctx, "campaigns", RoleUser); perm == nil || perm.Delete == nil {
+		t.Errorf("Expected the request cache to keep its permission, got %+v", perm)
+	}
+	if _, err := engine.Authorize(context.Background(), "campaigns", PermissionDelete, &Claims{Role: RoleUser}); err == nil {
+		t.Error("Expected a new request to see the reloaded permissions")
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	claims := &Claims{AccountID: "acc_1", Role: RoleUser, Permissions: []string{"sms_history:insert", "campaigns:*"}}
+
+	if _, err := engine.Authorize(context.Background(), "sms_history", PermissionInsert, claims); err != nil {
+		t.Errorf("Expected the scoped insert to be allowed: %v", err)
+	}
+	if _, err := engine.Authorize(context.Background(), "campaigns", PermissionDelete, claims); err != nil {
+		t.Errorf("Expected the wildcard scope to allow delete: %v", err)
+	}
+	if _, err := engine.Authorize(context.Background(), "billing_transactions", PermissionSelect, claims); err == nil {
+		t.Error("Expected billing reads outside the key's scopes to be denied")
+	}
+	if _, err := engine.Authorize(context.Background(), "billing_transactions", PermissionSelect, &Claims{AccountID: "acc_1", Role: RoleUser}); err != nil {
+		t.Errorf("Expected unscoped claims to be limited by role only: %v", err)
+	}
+
+	for _, scope := range []string{"sms_history", ":select", "sms_history:drop"} {
+		if err := validateScope(scope); err == nil {
+			t.Errorf("Expected scope %q to be rejected", scope)
+		}
+	}
+
+	live, err := generateAPIKey(true)
+	if err != nil {
+		t.Fatalf("generateAPIKey failed: %v", err)
+	}
+	test, _ := generateAPIKey(false)
+	if !strings.HasPrefix(live, LiveKeyPrefix) || !strings.HasPrefix(test, TestKeyPrefix) {
+		t.Errorf("Expected environment prefixes, got %q and %q", live, test)
+	}
+	if hash := hashAPIKey(live); hash == live || len(hash) != 64 || hash != hashAPIKey(live) {
+		t.Errorf("Unexpected key hash %q", hash)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 SHA-1 test vectors, truncated to six digits
+	secret := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+	} {
+		if code := totpCode(secret, tc.unix/totpPeriod); code != tc.code {
+			t.Errorf("At %d expected %s, got %s", tc.unix, tc.code, code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := matchTOTP(secret, "005924", now, 0)
+	if !ok {
+		t.Fatal("Expected the current code to match")
+	}
+	if _, ok := matchTOTP(secret, "005924", now, step); ok {
+		t.Error("Expected a used code to be rejected")
+	}
+	if _, ok := matchTOTP(secret, totpCode(secret, step-1), now, 0); !ok {
+		t.Error("Expected the previous period's code to be accepted")
+	}
+	if _, ok := matchTOTP(secret, totpCode(secret, step-2), now, 0); ok {
+		t.Error("Expected codes outside the skew to be rejected")
+	}
+}
+
+func TestMFAAttemptLimiter(t *testing.T) {
+	limiter := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	limiter.fail("acc_1", now)
+	limiter.fail("acc_1", now)
+	if limiter.allow("acc_1", now) {
+		t.Error("Expected attempts to be blocked after the maximum failures")
+	}
+	if !limiter.allow("acc_2", now) {
+		t.Error("Expected other accounts to be unaffected")
+	}
+	if !limiter.allow("acc_1", now.Add(time.Minute)) {
+		t.Error("Expected attempts to resume after the window")
+	}
+}
+
+func TestMFAPendingToken(t *testing.T) {
+	engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+
+	token, err := engine.GenerateToken("acc_1", RoleAdmin, true)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	claims, err := engine.ValidateToken(token)
+	if err != nil || !claims.MFAPending || claims.ExpiresAt.Time.After(time.Now().Add(MFAPendingTTL)) {
+		t.Fatalf("Expected a short-lived mfa_pending token, got %+v (%v)", claims, err)
+	}
+
+	var seen *Claims
+	handler := engine.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value("claims").(*Claims)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen == nil || seen.Role != RoleAnonymous {
+		t.Errorf("Expected a pending token to authenticate as anonymous, got %+v", seen)
+	}
+
+	userToken, _ := engine.GenerateToken("acc_2", RoleUser, true)
+	if claims, _ := engine.ValidateToken(userToken); claims == nil || claims.MFAPending {
+		t.Errorf("Expected roles without MFA to receive full tokens, got %+v", claims)
+	}
+	if _, err := engine.CompleteMFA(context.Background(), userToken, "123456"); err != ErrMFATokenInvalid {
+		t.Errorf("Expected CompleteMFA to reject a full token, got %v", err)
+	}
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	var (
+		secret  string
+		enabled bool
+	)
+	one := &lumadbtest.Rows{Columns: []string{"n"}, Types: []string{"INT4"}, Data: [][]driver.Value{{1}}}
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.HasPrefix(query, "UPDATE accounts SET mfa_secret"):
+			if enabled && strings.Contains(query, "IS NOT TRUE") {
+				return &lumadbtest.Rows{}, nil
+			}
+			secret, enabled = args[1].Value.(string), false
+		case strings.Contains(query, "SET mfa_enabled = TRUE"):
+			enabled = true
+		case strings.Contains(query, "SELECT mfa_secret"):
+			return &lumadbtest.Rows{
+				Columns: []string{"mfa_secret", "mfa_last_step"},
+				Types:   []string{"VARCHAR", "INT8"},
+				Data:    [][]driver.Value{{secret, nil}},
+			}, nil
+		case strings.Contains(query, "SELECT mfa_enabled"):
+			return &lumadbtest.Rows{Columns: []string{"mfa_enabled"}, Types: []string{"BOOL"}, Data: [][]driver.Value{{enabled}}}, nil
+		}
+		return one, nil
+	})
+	engine := NewAuthorizationEngine(db, "secret", zap.NewNop())
+	pending, _ := engine.GenerateToken("acc_1", RoleAdmin, true)
+
+	post := func(handler http.HandlerFunc, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+
+	// An admin who never enrolled enrolls with the pending token
+	rr, resp := post(engine.MFAEnrollHandler(), `{"mfa_token": "`+pending+`"}`)
+	if rr.Code != http.StatusOK || resp["secret"] != secret || len(resp["recovery_codes"].([]interface{})) != RecoveryCodeCount {
+		t.Fatalf("Expected an enrollment, got %d %s", rr.Code, rr.Body.String())
+	}
+	if ok, _ := engine.MFAEnabled(context.Background(), "acc_1"); ok {
+		t.Error("Expected MFA to stay disabled until a code is verified")
+	}
+
+	if rr, _ := post(engine.MFACompleteHandler(), `{"mfa_token": "`+pending+`", "code": "000000"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong code, got %d", rr.Code)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	rr, resp = post(engine.MFACompleteHandler(), `{"mfa_token": "`+pending+`", "code": "`+code+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the code to complete MFA, got %d %s", rr.Code, rr.Body.String())
+	}
+	if claims, err := engine.ValidateToken(resp["token"].(string)); err != nil || claims.MFAPending || claims.Role != RoleAdmin {
+		t.Errorf("Expected a full admin token, got %+v (%v)", claims, err)
+	}
+	if ok, _ := engine.MFAEnabled(context.Background(), "acc_1"); !ok {
+		t.Error("Expected the first accepted code to enable MFA")
+	}
+
+	// Once enabled, a pending token can no longer replace the secret
+	if rr, _ := post(engine.MFAEnrollHandler(), `{"mfa_token": "`+pending+`"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for re-enrollment with a pending token, got %d", rr.Code)
+	}
+	full, _ := engine.issueToken("acc_1", RoleAdmin, true, false)
+	if rr, _ := post(engine.MFAEnrollHandler(), `{"mfa_token": "`+full+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a full token, got %d", rr.Code)
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	if _, err := hashPassword("short"); err == nil {
+		t.Error("Expected short passwords to be rejected")
+	}
+
+	hash, err := hashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("hashPassword failed: %v", err)
+	}
+	if hash == "correct horse battery" || !strings.HasPrefix(hash, "$2") {
+		t.Errorf("Expected a bcrypt hash, got %q", hash)
+	}
+	if !checkPassword(hash, "correct horse battery") {
+		t.Error("Expected the password to verify")
+	}
+	if checkPassword(hash, "correct horse battery!") {
+		t.Error("Expected a different password to fail")
+	}
+}
+
+func TestLoginHandlerValidation(t *testing.T) {
+	engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	for _, body := range []string{`not json`, `{"email": "a@b.c"}`, `{"password": "secret123"}`} {
+		rr := httptest.NewRecorder()
+		engine.LoginHandler()(rr, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
+
+func TestAuthMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := NewAuthMetrics(reg)
+	if err != nil {
+		t.Fatalf("NewAuthMetrics failed: %v", err)
+	}
+	engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	engine.SetMetrics(metrics)
+
+	userToken, _ := engine.GenerateToken("acc_1", RoleUser, true)
+	adminToken, _ := engine.GenerateToken("acc_2", RoleAdmin, true)
+	engine.ValidateToken(userToken)
+	engine.ValidateToken(adminToken)
+	engine.ValidateToken("not-a-token")
+
+	for result, want := range map[string]float64{"valid": 1, "mfa_pending": 1, "invalid": 1, "expired": 0} {
+		if got := testutil.ToFloat64(metrics.tokenValidations.WithLabelValues(result)); got != want {
+			t.Errorf("auth_token_validations_total{result=%q} = %v, want %v", result, got, want)
+		}
+	}
+
+	claims := &Claims{AccountID: "acc_1", Role: RoleUser}
+	engine.Authorize(context.Background(), "campaigns", PermissionSelect, claims)
+	engine.Authorize(context.Background(), "billing_transactions", PermissionDelete, claims)
+	engine.Authorize(context.Background(), "billing_transactions", PermissionDelete, claims)
+
+	if got := testutil.ToFloat64(metrics.permissionChecks.WithLabelValues("campaigns", "select", "allowed")); got != 1 {
+		t.Errorf("Expected 1 allowed campaigns select, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.permissionChecks.WithLabelValues("billing_transactions", "delete", "denied")); got != 2 {
+		t.Errorf("Expected 2 denied billing deletes, got %v", got)
+	}
+
+	if _, err := NewAuthMetrics(reg); err == nil {
+		t.Error("Expected registering the metrics twice to fail")
+	}
+}
+
+func TestTokenIssuerAndAudience(t *testing.T) {
+	gateway := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	gateway.SetTokenValidation(DefaultTokenIssuer, "gateway", "admin-portal")
+
+	token, err := gateway.GenerateToken("acc_1", RoleUser, true)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	claims, err := gateway.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Expected a first-party token to validate: %v", err)
+	}
+	if claims.Issuer != DefaultTokenIssuer || len(claims.Audience) != 1 || claims.Audience[0] != "gateway" {
+		t.Errorf("Unexpected iss/aud: %s %v", claims.Issuer, claims.Audience)
+	}
+
+	sign := func(iss string, aud ...string) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    iss,
+				Audience:  aud,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			AccountID: "acc_1",
+			Role:      RoleUser,
+		}).SignedString([]byte("secret"))
+		return token
+	}
+
+	if _, err := gateway.ValidateToken(sign(DefaultTokenIssuer, "admin-portal")); err != nil {
+		t.Errorf("Expected any configured audience to be accepted: %v", err)
+	}
+	if _, err := gateway.ValidateToken(sign(DefaultTokenIssuer, "billing")); err == nil {
+		t.Error("Expected a token for another audience to be rejected")
+	}
+	if _, err := gateway.ValidateToken(sign(DefaultTokenIssuer)); err == nil {
+		t.Error("Expected a token without an audience to be rejected")
+	}
+	if _, err := gateway.ValidateToken(sign("billing-service", "gateway")); err == nil {
+		t.Error("Expected a token from another issuer to be rejected")
+	}
+
+	// Without audiences only the issuer is checked
+	legacy := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	if _, err := legacy.ValidateToken(sign(DefaultTokenIssuer)); err != nil {
+		t.Errorf("Expected the default engine to accept first-party tokens: %v", err)
+	}
+	if _, err := legacy.ValidateToken(sign("billing-service")); err == nil {
+		t.Error("Expected the default engine to check the issuer")
+	}
+}
+
+func TestQuotaErrors(t *testing.T) {
+	var err error = &QuotaExceededError{Resource: ResourceSMS, Limit: 1000, Used: 998, Requested: 5}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Error("Expected QuotaExceededError to match ErrQuotaExceeded")
+	}
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || quotaErr.HTTPStatus() != http.StatusPaymentRequired {
+		t.Errorf("Expected a 402 quota error, got %v", err)
+	}
+
+	period := quotaPeriod(time.Date(2026, 3, 31, 23, 30, 0, 0, time.FixedZone("WAT", 3600)))
+	if !period.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the UTC month start, got %v", period)
+	}
+
+	engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+	if err := engine.CheckQuota(context.Background(), &Claims{AccountID: "acc_1"}, ResourceSMS, 1); err != nil {
+		t.Errorf("Expected quotas to be disabled without plans: %v", err)
+	}
+	engine.SetPlans(DefaultPlans())
+	if err := engine.ConsumeQuota(context.Background(), &Claims{Role: RoleAnonymous}, ResourceSMS, 1); err == nil {
+		t.Error("Expected anonymous callers to be refused quota")
+	}
+
+	// The plan and the attached quota are cached per account
+	used := int64(990)
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.Contains(query, "SELECT plan"):
+			return &lumadbtest.Rows{Columns: []string{"plan"}, Types: []string{"VARCHAR"}, Data: [][]driver.Value{{"free"}}}, nil
+		case strings.Contains(query, "GREATEST"):
+			used = max(used+args[3].Value.(int64), 0)
+		case strings.Contains(query, "INSERT INTO usage_counters"):
+			if used+args[3].Value.(int64) > args[4].Value.(int64) {
+				return &lumadbtest.Rows{}, nil
+			}
+			used += args[3].Value.(int64)
+		case strings.Contains(query, "SELECT resource, used"):
+			return &lumadbtest.Rows{
+				Columns: []string{"resource", "used"},
+				Types:   []string{"VARCHAR", "INT8"},
+				Data:    [][]driver.Value{{ResourceSMS, used}},
+			}, nil
+		}
+		return &lumadbtest.Rows{Columns: []string{"used"}, Types: []string{"INT8"}, Data: [][]driver.Value{{used}}}, nil
+	})
+	engine = NewAuthorizationEngine(db, "secret", zap.NewNop())
+	engine.SetPlans(DefaultPlans())
+	for i := 0; i < 3; i++ {
+		claims := &Claims{AccountID: "acc_1"}
+		engine.attachQuota(context.Background(), claims)
+		if claims.Quota[ResourceSMS] != 10 {
+			t.Fatalf("Expected 10 SMS left, got %v", claims.Quota)
+		}
+	}
+	if n := len(fake.Queries()); n != 2 {
+		t.Errorf("Expected the plan and usage read once, got %d queries", n)
+	}
+
+	// Services metering by account id consume the same counters
+	sms := engine.AccountQuota(ResourceSMS)
+	if err := sms.ConsumeQuota(context.Background(), "acc_1", 4); err != nil {
+		t.Fatalf("ConsumeQuota failed: %v", err)
+	}
+	if err := sms.CheckQuota(context.Background(), "acc_1", 7); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected 7 more SMS to exceed the quota, got %v", err)
+	}
+	claims := &Claims{AccountID: "acc_1"}
+	engine.attachQuota(context.Background(), claims)
+	if claims.Quota[ResourceSMS] != 6 {
+		t.Errorf("Expected the cached quota to reflect consumption, got %v", claims.Quota)
+	}
+
+	// Usage that already happened is recorded past the limit, and calls
+	// without claims are not metered
+	ctx := context.WithValue(context.Background(), "claims", claims)
+	if err := engine.RequestQuota(ResourceSMS).RecordUsage(ctx, 8); err != nil || used != 1002 || claims.Quota[ResourceSMS] != 0 {
+		t.Errorf("Expected usage recorded past the limit, got %v with %d used and %v left", err, used, claims.Quota)
+	}
+	if err := engine.RequestQuota(ResourceSMS).CheckQuota(context.Background(), 1); err != nil {
+		t.Errorf("Expected calls without claims to be unmetered, got %v", err)
+	}
+}
+
+// BenchmarkNestedQueryPermissions simulates the field checks of a GraphQL
+// query nesting accounts > campaigns > sms_history > sender_ids, where every
+// column of every row is authorized
+func BenchmarkNestedQueryPermissions(b *testing.B) {
+	tables := []string{"accounts", "campaigns", "sms_history", "sender_ids"}
+	columns := []string{"id", "account_id", "name", "status", "created_at"}
+	const rowsPerLevel = 5
+
+	resolve := func(ctx context.Context, engine *AuthorizationEngine) {
+		var walk func(level int)
+		walk = func(level int) {
+			if level == len(tables) {
+				return
+			}
+			for row := 0; row < rowsPerLevel; row++ {
+				for _, column := range columns {
+					engine.CanSelectColumn(ctx, tables[level], RoleUser, column)
+				}
+				walk(level + 1)
+			}
+		}
+		walk(0)
+	}
+
+	for _, bc := range []struct {
+		name         string
+		requestCache bool
+	}{
+		{"NoRequestCache", false},
+		{"RequestCache", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			engine := NewAuthorizationEngine(nil, "secret", zap.NewNop())
+			engine.lookups.Store(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctx := context.Background()
+				if bc.requestCache {
+					ctx = WithPermissionCache(ctx)
+				}
+				resolve(ctx, engine)
+			}
+			b.ReportMetric(float64(engine.lookups.Load())/float64(b.N), "lookups/op")
+		})
+	}
+}
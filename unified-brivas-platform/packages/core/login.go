@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+)
+
+// Login settings
+const (
+	MinPasswordLength = 8
+	MaxLoginFailures  = 5
+	LoginLockout      = 15 * time.Minute
+)
+
+// Login errors
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrAccountLocked      = errors.New("account temporarily locked after repeated failed logins")
+)
+
+// dummyPasswordHash is compared against when the account does not exist so
+// that unknown emails take as long as wrong passwords
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("brivas-dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// hashPassword validates and bcrypt-hashes a password
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// checkPassword compares in constant time via bcrypt
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Authenticate verifies an email and password and returns the claims to
+// issue a token for. After MaxLoginFailures consecutive failures the
+// account is locked for LoginLockout.
+func (e *AuthorizationEngine) Authenticate(ctx context.Context, email, password string) (*Claims, error) {
+	var (
+		accountID   string
+		hash        sql.NullString
+		role        sql.NullString
+		lockedUntil sql.NullTime
+	)
+	err := e.db.QueryRow(ctx, `
+		SELECT id, password, role, locked_until FROM accounts WHERE email = $1
+	`, strings.TrimSpace(email)).Scan(&accountID, &hash, &role, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account: %w", err)
+	}
+
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return nil, ErrAccountLocked
+	}
+
+	if !hash.Valid || hash.String == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if !checkPassword(hash.String, password) {
+		e.recordLoginFailure(ctx, accountID)
+		return nil, ErrInvalidCredentials
+	}
+
+	if _, err := e.db.Exec(ctx, `
+		UPDATE accounts SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1
+	`, accountID); err != nil {
+		e.logger.Warn("failed to reset login failures", zap.String("account_id", accountID), zap.Error(err))
+	}
+
+	claims := &Claims{AccountID: accountID, Role: RoleUser, IsLive: true}
+	if role.Valid && role.String != "" {
+		claims.Role = Role(role.String)
+	}
+	return claims, nil
+}
+
+// recordLoginFailure counts a failed login, locking the account and
+// restarting the count once MaxLoginFailures is reached
+func (e *AuthorizationEngine) recordLoginFailure(ctx context.Context, accountID string) {
+	_, err := e.db.Exec(ctx, `
+		UPDATE accounts SET
+			locked_until = CASE WHEN COALESCE(failed_login_attempts, 0) + 1 >= $2 THEN NOW() + $3 * INTERVAL '1 second' ELSE locked_until END,
+			failed_login_attempts = CASE WHEN COALESCE(failed_login_attempts, 0) + 1 >= $2 THEN 0 ELSE COALESCE(failed_login_attempts, 0) + 1 END
+		WHERE id = $1
+	`, accountID, MaxLoginFailures, int(LoginLockout.Seconds()))
+	if err != nil {
+		e.logger.Warn("failed to record login failure", zap.String("account_id", accountID), zap.Error(err))
+	}
+}
+
+// SetPassword stores a new bcrypt hash for the account and clears any
+// lockout
+func (e *AuthorizationEngine) SetPassword(ctx context.Context, accountID, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	result, err := e.db.Exec(ctx, `
+		UPDATE accounts SET password = $2, failed_login_attempts = 0, locked_until = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, accountID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to store password: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("account %s not found", accountID)
+	}
+	return nil
+}
+
+// ChangePassword replaces the password after verifying the current one
+func (e *AuthorizationEngine) ChangePassword(ctx context.Context, accountID, current, password string) error {
+	var hash sql.NullString
+	if err := e.db.QueryRow(ctx, `SELECT password FROM accounts WHERE id = $1`, accountID).Scan(&hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("failed to load account: %w", err)
+	}
+	if !hash.Valid || !checkPassword(hash.String, current) {
+		return ErrInvalidCredentials
+	}
+	return e.SetPassword(ctx, accountID, password)
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// LoginHandler exchanges an email and password for a JWT. Roles that
+// require MFA receive an mfa_pending token for MFACompleteHandler, and
+// mfa_enrolled tells whether MFAEnrollHandler must be called first.
+func (e *AuthorizationEngine) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" || req.Password == "" {
+			apierr.Write(w, r, "email and password are required", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := e.Authenticate(r.Context(), req.Email, req.Password)
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			apierr.Write(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrAccountLocked):
+			w.Header().Set("Retry-After", fmt.Sprint(int(LoginLockout.Seconds())))
+			apierr.Write(w, r, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			e.logger.Error("login failed", zap.Error(err))
+			apierr.Write(w, r, "login failed", http.StatusInternalServerError)
+			return
+		}
+
+		token, err := e.GenerateToken(claims.AccountID, claims.Role, claims.IsLive)
+		if err != nil {
+			e.logger.Error("failed to issue token", zap.Error(err))
+			apierr.Write(w, r, "login failed", http.StatusInternalServerError)
+			return
+		}
+
+		resp := map[string]interface{}{
+			"token":        token,
+			"account_id":   claims.AccountID,
+			"role":         claims.Role,
+			"mfa_required": RequiresMFA(claims.Role),
+		}
+		if RequiresMFA(claims.Role) {
+			enrolled, err := e.MFAEnabled(r.Context(), claims.AccountID)
+			if err != nil {
+				e.logger.Error("failed to load mfa status", zap.Error(err))
+				apierr.Write(w, r, "login failed", http.StatusInternalServerError)
+				return
+			}
+			resp["mfa_enrolled"] = enrolled
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,444 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// TOTP parameters (RFC 6238), the defaults authenticator apps expect
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew accepts codes one period either side of now
+	totpSkew = 1
+)
+
+// MFA settings
+const (
+	MFAIssuer         = "Brivas"
+	MFAPendingTTL     = 5 * time.Minute
+	RecoveryCodeCount = 10
+	MaxMFAFailures    = 5
+	MFAFailureWindow  = 15 * time.Minute
+	recoveryCodeBytes = 5
+)
+
+// MFA errors
+var (
+	ErrMFANotEnrolled  = errors.New("mfa not enrolled")
+	ErrMFAEnrolled     = errors.New("mfa already enrolled")
+	ErrMFARateLimited  = errors.New("too many failed mfa attempts")
+	ErrMFATokenInvalid = errors.New("invalid mfa pending token")
+	ErrMFACodeInvalid  = errors.New("invalid mfa code")
+)
+
+// mfaRoles must pass a second factor before receiving a full token
+var mfaRoles = map[Role]bool{RoleAdmin: true, RoleSuperAdmin: true}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RequiresMFA reports whether tokens for role are only issued after MFA
+func RequiresMFA(role Role) bool {
+	return mfaRoles[role]
+}
+
+// totpCode computes the code for a time step (RFC 4226 dynamic truncation)
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// matchTOTP returns the step code matches within the allowed skew, only
+// considering steps after lastStep so a code cannot be replayed
+func matchTOTP(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// attemptLimiter counts failed verifications per account in a fixed window
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	count int
+	start time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, failures: make(map[string]*attemptWindow)}
+}
+
+// allow reports whether key may make another attempt
+func (l *attemptLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.failures[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		delete(l.failures, key)
+		return true
+	}
+	return w.count < l.max
+}
+
+func (l *attemptLimiter) fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.failures[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &attemptWindow{start: now}
+		l.failures[key] = w
+	}
+	w.count++
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// EnrollTOTP generates a new TOTP secret for the account and returns it with
+// an otpauth:// URL for authenticator apps to scan as a QR code. MFA becomes
+// enabled once VerifyTOTP accepts a code for the new secret.
+func (e *AuthorizationEngine) EnrollTOTP(ctx context.Context, accountID string) (string, string, error) {
+	return e.enrollTOTP(ctx, accountID, true)
+}
+
+// enrollTOTP stores a new secret for the account. Unless replace is set an
+// account with MFA enabled keeps its secret, so holding its password alone
+// is not enough to take over the second factor.
+func (e *AuthorizationEngine) enrollTOTP(ctx context.Context, accountID string, replace bool) (string, string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate mfa secret: %w", err)
+	}
+	secret := totpEncoding.EncodeToString(raw)
+
+	query := `UPDATE accounts SET mfa_secret = $2, mfa_enabled = FALSE, mfa_last_step = NULL WHERE id = $1`
+	if !replace {
+		query += ` AND mfa_enabled IS NOT TRUE`
+	}
+	result, err := e.db.Exec(ctx, query, accountID, secret)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store mfa secret: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		if !replace {
+			return "", "", ErrMFAEnrolled
+		}
+		return "", "", fmt.Errorf("account %s not found", accountID)
+	}
+
+	return secret, totpURL(accountID, secret), nil
+}
+
+// MFAEnabled reports whether the account has confirmed its TOTP enrollment
+func (e *AuthorizationEngine) MFAEnabled(ctx context.Context, accountID string) (bool, error) {
+	var enabled sql.NullBool
+	err := e.db.QueryRow(ctx, `SELECT mfa_enabled FROM accounts WHERE id = $1`, accountID).Scan(&enabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to load mfa status: %w", err)
+	}
+	return enabled.Bool, nil
+}
+
+func totpURL(accountID, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", MFAIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(MFAIssuer + ":" + accountID)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// VerifyTOTP checks a code from the account's authenticator, enabling MFA
+// on the first success after enrollment. Failed attempts are rate limited
+// per account.
+func (e *AuthorizationEngine) VerifyTOTP(ctx context.Context, accountID, code string) (bool, error) {
+	now := time.Now()
+	if !e.mfaAttempts.allow(accountID, now) {
+		return false, ErrMFARateLimited
+	}
+
+	var (
+		secret   sql.NullString
+		lastStep sql.NullInt64
+	)
+	err := e.db.QueryRow(ctx, `
+		SELECT mfa_secret, mfa_last_step FROM accounts WHERE id = $1
+	`, accountID).Scan(&secret, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !secret.Valid) {
+		return false, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load mfa secret: %w", err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret.String)
+	if err != nil {
+		return false, fmt.Errorf("invalid mfa secret: %w", err)
+	}
+
+	step, ok := matchTOTP(key, strings.TrimSpace(code), now, lastStep.Int64)
+	if !ok {
+		e.mfaAttempts.fail(accountID, now)
+		return false, nil
+	}
+
+	// The step condition makes concurrent use of the same code fail
+	result, err := e.db.Exec(ctx, `
+		UPDATE accounts SET mfa_enabled = TRUE, mfa_last_step = $2
+		WHERE id = $1 AND (mfa_last_step IS NULL OR mfa_last_step < $2)
+	`, accountID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record mfa step: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		e.mfaAttempts.fail(accountID, now)
+		return false, nil
+	}
+
+	e.mfaAttempts.reset(accountID)
+	return true, nil
+}
+
+// GenerateRecoveryCodes replaces the account's recovery codes. Each code
+// can stand in for a TOTP code once; only their hashes are stored.
+func (e *AuthorizationEngine) GenerateRecoveryCodes(ctx context.Context, accountID string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+	}
+
+	err := lumadb.InTransaction(ctx, e.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE account_id = $1`, accountID); err != nil {
+			return err
+		}
+		for _, code := range codes {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO mfa_recovery_codes (account_id, code_hash) VALUES ($1, $2)
+			`, accountID, hashAPIKey(normalizeRecoveryCode(code))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// VerifyRecoveryCode consumes one unused recovery code. It shares the
+// failed attempt limit with VerifyTOTP.
+func (e *AuthorizationEngine) VerifyRecoveryCode(ctx context.Context, accountID, code string) (bool, error) {
+	now := time.Now()
+	if !e.mfaAttempts.allow(accountID, now) {
+		return false, ErrMFARateLimited
+	}
+
+	result, err := e.db.Exec(ctx, `
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE account_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, accountID, hashAPIKey(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, fmt.Errorf("failed to verify recovery code: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		e.mfaAttempts.fail(accountID, now)
+		return false, nil
+	}
+
+	e.mfaAttempts.reset(accountID)
+	return true, nil
+}
+
+// pendingClaims returns the claims of an mfa_pending token
+func (e *AuthorizationEngine) pendingClaims(pendingToken string) (*Claims, error) {
+	claims, err := e.ValidateToken(pendingToken)
+	if err != nil || !claims.MFAPending {
+		return nil, ErrMFATokenInvalid
+	}
+	return claims, nil
+}
+
+// CompleteMFA exchanges an mfa_pending token and a TOTP or recovery code
+// for a full-privilege token. The first TOTP code accepted after
+// enrollment also enables MFA for the account.
+func (e *AuthorizationEngine) CompleteMFA(ctx context.Context, pendingToken, code string) (string, error) {
+	claims, err := e.pendingClaims(pendingToken)
+	if err != nil {
+		return "", err
+	}
+
+	verify := e.VerifyTOTP
+	if strings.Contains(code, "-") {
+		verify = e.VerifyRecoveryCode
+	}
+	ok, err := verify(ctx, claims.AccountID, code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrMFACodeInvalid
+	}
+	return e.issueToken(claims.AccountID, claims.Role, claims.IsLive, false)
+}
+
+type mfaRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// MFAEnrollHandler enrolls the account of an mfa_pending token in TOTP,
+// answering with the secret, its otpauth:// URL and new recovery codes.
+// Only accounts without MFA enabled may enroll this way: it is how roles
+// that require MFA set up their second factor at first login.
+func (e *AuthorizationEngine) MFAEnrollHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mfaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+			apierr.Write(w, r, "mfa_token is required", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := e.pendingClaims(req.MFAToken)
+		if err != nil {
+			e.writeMFAError(w, r, err)
+			return
+		}
+		secret, otpURL, err := e.enrollTOTP(r.Context(), claims.AccountID, false)
+		if err != nil {
+			e.writeMFAError(w, r, err)
+			return
+		}
+		codes, err := e.GenerateRecoveryCodes(r.Context(), claims.AccountID)
+		if err != nil {
+			e.writeMFAError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"secret":         secret,
+			"otpauth_url":    otpURL,
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// MFACompleteHandler exchanges an mfa_pending token and a TOTP or recovery
+// code for a full token via CompleteMFA
+func (e *AuthorizationEngine) MFACompleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mfaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+			apierr.Write(w, r, "mfa_token and code are required", http.StatusBadRequest)
+			return
+		}
+
+		token, err := e.CompleteMFA(r.Context(), req.MFAToken, req.Code)
+		if err != nil {
+			e.writeMFAError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": token})
+	}
+}
+
+func (e *AuthorizationEngine) writeMFAError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrMFATokenInvalid), errors.Is(err, ErrMFACodeInvalid):
+		apierr.Write(w, r, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrMFAEnrolled), errors.Is(err, ErrMFANotEnrolled):
+		apierr.Write(w, r, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrMFARateLimited):
+		w.Header().Set("Retry-After", fmt.Sprint(int(MFAFailureWindow.Seconds())))
+		apierr.Write(w, r, err.Error(), http.StatusTooManyRequests)
+	default:
+		e.logger.Error("mfa failed", zap.Error(err))
+		apierr.Write(w, r, "mfa failed", http.StatusInternalServerError)
+	}
+}
+
+// issueToken signs a token; pending tokens are short-lived and only accepted
+// by CompleteMFA
+func (e *AuthorizationEngine) issueToken(accountID string, role Role, isLive, mfaPending bool) (string, error) {
+	ttl := 24 * time.Hour
+	if mfaPending {
+		ttl = MFAPendingTTL
+	}
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    e.issuer,
+		},
+		AccountID:  accountID,
+		Role:       role,
+		IsLive:     isLive,
+		MFAPending: mfaPending,
+	}
+	if len(e.audiences) > 0 {
+		claims.Audience = jwt.ClaimStrings{e.audiences[0]}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(e.jwtSecret)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// permissionKey identifies the permission of one role on one table
+type permissionKey struct {
+	table string
+	role  Role
+}
+
+type permissionCacheKey struct{}
+
+// requestPermissions memoizes the permissions resolved during one request.
+// Besides saving lookups in resolvers that run per row, it gives the request
+// a consistent view: a reload mid-request does not change its decisions.
+type requestPermissions struct {
+	mu      sync.Mutex
+	entries map[permissionKey]*TablePermission
+}
+
+// WithPermissionCache returns a context in which each permission is
+// resolved at most once. It is a no-op when ctx already carries a cache.
+func WithPermissionCache(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(permissionCacheKey{}).(*requestPermissions); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, permissionCacheKey{}, &requestPermissions{
+		entries: make(map[permissionKey]*TablePermission),
+	})
+}
+
+// GetPermissionContext returns the permission for a table and role, using
+// the request cache in ctx when there is one
+func (e *AuthorizationEngine) GetPermissionContext(ctx context.Context, table string, role Role) *TablePermission {
+	cache, ok := ctx.Value(permissionCacheKey{}).(*requestPermissions)
+	if !ok {
+		return e.GetPermission(table, role)
+	}
+
+	key := permissionKey{table: table, role: role}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if perm, ok := cache.entries[key]; ok {
+		return perm
+	}
+	perm := e.GetPermission(table, role)
+	cache.entries[key] = perm
+	return perm
+}
+
+// ReloadPermissions replaces the permission rules and invalidates the
+// engine cache. Requests already holding a request cache keep their view.
+func (e *AuthorizationEngine) ReloadPermissions(perms []*TablePermission) {
+	rules := make(map[string]map[Role]*TablePermission)
+	for _, perm := range perms {
+		addPermission(rules, perm)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.permissions = rules
+	e.cache.Store(new(sync.Map))
+}
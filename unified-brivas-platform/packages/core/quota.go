@@ -0,0 +1,418 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Metered resources
+const (
+	ResourceSMS       = "sms"
+	ResourceLLMTokens = "llm_tokens"
+)
+
+// DefaultPlan applies to accounts without a plan
+const DefaultPlan = "free"
+
+// PlanLimits maps a resource to its monthly limit. Resources missing from a
+// plan are unlimited.
+type PlanLimits map[string]int64
+
+// DefaultPlans are the monthly limits of the built-in plans
+func DefaultPlans() map[string]PlanLimits {
+	return map[string]PlanLimits{
+		"free":       {ResourceSMS: 1000, ResourceLLMTokens: 100000},
+		"starter":    {ResourceSMS: 50000, ResourceLLMTokens: 5000000},
+		"business":   {ResourceSMS: 1000000, ResourceLLMTokens: 50000000},
+		"enterprise": {},
+	}
+}
+
+// ErrQuotaExceeded matches every QuotaExceededError via errors.Is
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaExceededError reports a request that would go over the plan limit
+type QuotaExceededError struct {
+	Resource  string
+	Limit     int64
+	Used      int64
+	Requested int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("monthly %s quota exceeded: %d of %d used, %d requested", e.Resource, e.Used, e.Limit, e.Requested)
+}
+
+// Is makes errors.Is(err, ErrQuotaExceeded) true
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// HTTPStatus is the status handlers should answer with: the plan, not the
+// request rate, is the limit, so it is 402 rather than 429
+func (e *QuotaExceededError) HTTPStatus() int {
+	return http.StatusPaymentRequired
+}
+
+// SetPlans replaces the plan limits used for quota checks
+func (e *AuthorizationEngine) SetPlans(plans map[string]PlanLimits) {
+	e.plans = plans
+	e.quotas.reset()
+}
+
+// QuotaCacheTTL is how long an account's plan, and the remaining quota the
+// middleware attaches to its claims, are reused before being read again.
+// Enforcement re-reads usage every time, so only the advisory quota in
+// claims and plan changes lag by up to this long.
+const QuotaCacheTTL = 30 * time.Second
+
+// quotaCache holds accounts' plan limits and remaining quota
+type quotaCache struct {
+	mu      sync.Mutex
+	entries map[string]*quotaCacheEntry
+}
+
+type quotaCacheEntry struct {
+	limits    PlanLimits
+	remaining map[string]int64 // nil until the middleware loads usage
+	expires   time.Time
+}
+
+// get returns the account's unexpired entry, or nil
+func (c *quotaCache) get(accountID string, now time.Time) *quotaCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[accountID]
+	if !ok || !now.Before(entry.expires) {
+		return nil
+	}
+	return entry
+}
+
+// put caches entry, dropping expired entries as the cache grows
+func (c *quotaCache) put(accountID string, entry *quotaCacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*quotaCacheEntry)
+	}
+	if len(c.entries) >= 1024 {
+		for id, cached := range c.entries {
+			if !now.Before(cached.expires) {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[accountID] = entry
+}
+
+// consumed lowers the account's cached remaining quota of resource
+func (c *quotaCache) consumed(accountID, resource string, remaining int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[accountID]; ok && entry.remaining != nil {
+		if _, limited := entry.remaining[resource]; limited {
+			entry.remaining[resource] = remaining
+		}
+	}
+}
+
+func (c *quotaCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// quotaPeriod is the first day of the current month in UTC
+func quotaPeriod(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// planLimits returns the limits of the account's plan, cached for
+// QuotaCacheTTL
+func (e *AuthorizationEngine) planLimits(ctx context.Context, accountID string) (PlanLimits, error) {
+	now := time.Now()
+	if entry := e.quotas.get(accountID, now); entry != nil {
+		return entry.limits, nil
+	}
+
+	var plan sql.NullString
+	err := e.db.QueryRow(ctx, `SELECT plan FROM accounts WHERE id = $1`, accountID).Scan(&plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plan: %w", err)
+	}
+	name := plan.String
+	if name == "" {
+		name = DefaultPlan
+	}
+	limits, ok := e.plans[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown plan %q", name)
+	}
+	e.quotas.put(accountID, &quotaCacheEntry{limits: limits, expires: now.Add(QuotaCacheTTL)}, now)
+	return limits, nil
+}
+
+// loadQuota returns the remaining monthly quota of each limited resource,
+// cached for QuotaCacheTTL. The map is the caller's own.
+func (e *AuthorizationEngine) loadQuota(ctx context.Context, accountID string) (map[string]int64, error) {
+	now := time.Now()
+	if entry := e.quotas.get(accountID, now); entry != nil && entry.remaining != nil {
+		e.quotas.mu.Lock()
+		defer e.quotas.mu.Unlock()
+		return maps.Clone(entry.remaining), nil
+	}
+
+	limits, err := e.planLimits(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]int64, len(limits))
+	for resource, limit := range limits {
+		remaining[resource] = limit
+	}
+	if len(limits) == 0 {
+		e.cacheRemaining(accountID, limits, remaining, now)
+		return remaining, nil
+	}
+
+	rows, err := e.db.Query(ctx, `
+		SELECT resource, used FROM usage_counters WHERE account_id = $1 AND period = $2
+	`, accountID, quotaPeriod(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var resource string
+		var used int64
+		if err := rows.Scan(&resource, &used); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		if limit, ok := limits[resource]; ok {
+			remaining[resource] = max(limit-used, 0)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	e.cacheRemaining(accountID, limits, remaining, now)
+	return remaining, nil
+}
+
+// cacheRemaining caches a copy of an account's remaining quota
+func (e *AuthorizationEngine) cacheRemaining(accountID string, limits PlanLimits, remaining map[string]int64, now time.Time) {
+	e.quotas.put(accountID, &quotaCacheEntry{
+		limits:    limits,
+		remaining: maps.Clone(remaining),
+		expires:   now.Add(QuotaCacheTTL),
+	}, now)
+}
+
+// attachQuota loads the caller's remaining quota into claims. Quota is
+// advisory here; CheckQuota and ConsumeQuota re-read usage, so a failure
+// only leaves the claims without it. The quota is cached per account, so
+// most requests do not query the database for it.
+func (e *AuthorizationEngine) attachQuota(ctx context.Context, claims *Claims) {
+	if e.plans == nil || claims.AccountID == "" {
+		return
+	}
+	quota, err := e.loadQuota(ctx, claims.AccountID)
+	if err != nil {
+		e.logger.Warn("failed to load quota", zap.String("account_id", claims.AccountID), zap.Error(err))
+		return
+	}
+	claims.Quota = quota
+}
+
+// CheckQuota returns a *QuotaExceededError when consuming n units of
+// resource would exceed the account's monthly limit. It does not consume;
+// use ConsumeQuota to check and record usage atomically.
+func (e *AuthorizationEngine) CheckQuota(ctx context.Context, claims *Claims, resource string, n int) error {
+	limit, limited, err := e.resourceLimit(ctx, claims, resource)
+	if err != nil || !limited {
+		return err
+	}
+
+	var used int64
+	err = e.db.QueryRow(ctx, `
+		SELECT used FROM usage_counters WHERE account_id = $1 AND resource = $2 AND period = $3
+	`, claims.AccountID, resource, quotaPeriod(time.Now())).Scan(&used)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to load usage: %w", err)
+	}
+	if used+int64(n) > limit {
+		return &QuotaExceededError{Resource: resource, Limit: limit, Used: used, Requested: n}
+	}
+	return nil
+}
+
+// ConsumeQuota records n units of resource against the account, failing
+// with a *QuotaExceededError instead when that would exceed the limit. The
+// check and increment are one statement, so concurrent callers cannot
+// overshoot the limit together.
+func (e *AuthorizationEngine) ConsumeQuota(ctx context.Context, claims *Claims, resource string, n int) error {
+	limit, limited, err := e.resourceLimit(ctx, claims, resource)
+	if err != nil {
+		return err
+	}
+	if !limited {
+		limit = -1
+	}
+	if limited && int64(n) > limit {
+		return &QuotaExceededError{Resource: resource, Limit: limit, Requested: n}
+	}
+
+	var used int64
+	err = e.db.QueryRow(ctx, `
+		INSERT INTO usage_counters (account_id, resource, period, used)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, resource, period) DO UPDATE
+		SET used = usage_counters.used + EXCLUDED.used, updated_at = NOW()
+		WHERE $5 < 0 OR usage_counters.used + EXCLUDED.used <= $5
+		RETURNING used
+	`, claims.AccountID, resource, quotaPeriod(time.Now()), n, limit).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The WHERE clause rejected the increment; report current usage
+		e.db.QueryRow(ctx, `
+			SELECT used FROM usage_counters WHERE account_id = $1 AND resource = $2 AND period = $3
+		`, claims.AccountID, resource, quotaPeriod(time.Now())).Scan(&used)
+		return &QuotaExceededError{Resource: resource, Limit: limit, Used: used, Requested: n}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+
+	if limited {
+		if claims.Quota != nil {
+			claims.Quota[resource] = max(limit-used, 0)
+		}
+		e.quotas.consumed(claims.AccountID, resource, max(limit-used, 0))
+	}
+	return nil
+}
+
+// RecordUsage adds n units of resource to the account's usage without
+// checking the limit, for usage that already happened, such as the tokens
+// of a completion whose length was not known up front. A negative n
+// releases usage consumed for work that did not happen.
+func (e *AuthorizationEngine) RecordUsage(ctx context.Context, claims *Claims, resource string, n int) error {
+	limit, limited, err := e.resourceLimit(ctx, claims, resource)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	var used int64
+	err = e.db.QueryRow(ctx, `
+		INSERT INTO usage_counters (account_id, resource, period, used)
+		VALUES ($1, $2, $3, GREATEST($4, 0))
+		ON CONFLICT (account_id, resource, period) DO UPDATE
+		SET used = GREATEST(usage_counters.used + $4, 0), updated_at = NOW()
+		RETURNING used
+	`, claims.AccountID, resource, quotaPeriod(time.Now()), n).Scan(&used)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+
+	if limited {
+		if claims.Quota != nil {
+			claims.Quota[resource] = max(limit-used, 0)
+		}
+		e.quotas.consumed(claims.AccountID, resource, max(limit-used, 0))
+	}
+	return nil
+}
+
+// AccountQuota meters one resource for services that identify the caller
+// by account id, such as the X-Account-ID header, rather than by claims
+type AccountQuota struct {
+	engine   *AuthorizationEngine
+	resource string
+}
+
+// AccountQuota returns the metering of resource, e.g. ResourceSMS
+func (e *AuthorizationEngine) AccountQuota(resource string) *AccountQuota {
+	return &AccountQuota{engine: e, resource: resource}
+}
+
+// CheckQuota is AuthorizationEngine.CheckQuota for the account
+func (q *AccountQuota) CheckQuota(ctx context.Context, accountID string, n int) error {
+	return q.engine.CheckQuota(ctx, &Claims{AccountID: accountID}, q.resource, n)
+}
+
+// ConsumeQuota is AuthorizationEngine.ConsumeQuota for the account
+func (q *AccountQuota) ConsumeQuota(ctx context.Context, accountID string, n int) error {
+	return q.engine.ConsumeQuota(ctx, &Claims{AccountID: accountID}, q.resource, n)
+}
+
+// RecordUsage is AuthorizationEngine.RecordUsage for the account
+func (q *AccountQuota) RecordUsage(ctx context.Context, accountID string, n int) error {
+	return q.engine.RecordUsage(ctx, &Claims{AccountID: accountID}, q.resource, n)
+}
+
+// RequestQuota meters one resource for the caller whose claims the
+// middleware placed in ctx. Calls without claims, such as a service's
+// own background work, are not metered.
+type RequestQuota struct {
+	engine   *AuthorizationEngine
+	resource string
+}
+
+// RequestQuota returns the metering of resource, e.g. ResourceLLMTokens
+func (e *AuthorizationEngine) RequestQuota(resource string) *RequestQuota {
+	return &RequestQuota{engine: e, resource: resource}
+}
+
+// CheckQuota is AuthorizationEngine.CheckQuota for the caller
+func (q *RequestQuota) CheckQuota(ctx context.Context, n int) error {
+	claims, ok := ctx.Value("claims").(*Claims)
+	if !ok || claims == nil {
+		return nil
+	}
+	return q.engine.CheckQuota(ctx, claims, q.resource, n)
+}
+
+// ConsumeQuota is AuthorizationEngine.ConsumeQuota for the caller
+func (q *RequestQuota) ConsumeQuota(ctx context.Context, n int) error {
+	claims, ok := ctx.Value("claims").(*Claims)
+	if !ok || claims == nil {
+		return nil
+	}
+	return q.engine.ConsumeQuota(ctx, claims, q.resource, n)
+}
+
+// RecordUsage is AuthorizationEngine.RecordUsage for the caller
+func (q *RequestQuota) RecordUsage(ctx context.Context, n int) error {
+	claims, ok := ctx.Value("claims").(*Claims)
+	if !ok || claims == nil {
+		return nil
+	}
+	return q.engine.RecordUsage(ctx, claims, q.resource, n)
+}
+
+// resourceLimit returns the monthly limit of resource for the caller and
+// whether there is one. Quotas are disabled without plans.
+func (e *AuthorizationEngine) resourceLimit(ctx context.Context, claims *Claims, resource string) (int64, bool, error) {
+	if e.plans == nil {
+		return 0, false, nil
+	}
+	if claims.AccountID == "" {
+		return 0, false, fmt.Errorf("quota requires an authenticated account")
+	}
+	limits, err := e.planLimits(ctx, claims.AccountID)
+	if err != nil {
+		return 0, false, err
+	}
+	limit, ok := limits[resource]
+	return limit, ok, nil
+}
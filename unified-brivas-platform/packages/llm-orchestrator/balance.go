@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"math/rand"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ewmaAlpha is the weight of the newest sample in latency and error EWMAs
+const ewmaAlpha = 0.2
+
+// providerStats tracks a provider's recent latency and error rate
+type providerStats struct {
+	latency   float64 // EWMA in milliseconds
+	errorRate float64 // EWMA of 0 (success) and 1 (failure)
+	samples   int
+}
+
+// SetProviderWeights sets the share of traffic each provider receives among
+// the providers able to serve the same model, like the weighted XDP
+// backends. Providers default to 1 and 0 stops routing to a provider
+// while another one can serve the model.
+func (o *Orchestrator) SetProviderWeights(weights map[string]int) {
+	o.router.SetWeights(weights)
+}
+
+// SetWeights replaces the provider weights
+func (r *Router) SetWeights(weights map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.weights = make(map[string]int, len(weights))
+	for name, w := range weights {
+		r.weights[name] = max(w, 0)
+	}
+}
+
+// observe records the outcome of a provider call
+func (r *Router) observe(name string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.stats[name]
+	if !ok {
+		s = &providerStats{}
+		r.stats[name] = s
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	if s.samples == 0 {
+		s.latency, s.errorRate = ms, failed
+	} else {
+		s.latency = ewmaAlpha*ms + (1-ewmaAlpha)*s.latency
+		s.errorRate = ewmaAlpha*failed + (1-ewmaAlpha)*s.errorRate
+	}
+	s.samples++
+}
+
+// configuredModels maps provider names to the models they were configured
+// with, so a custom endpoint can serve e.g. gpt-4o alongside OpenAI
+func configuredModels(cfg *Config) map[string][]string {
+	models := make(map[string][]string)
+	if cfg.Gemini != nil {
+		models["gemini"] = cfg.Gemini.Models
+	}
+	if cfg.OpenAI != nil {
+		models["openai"] = cfg.OpenAI.Models
+	}
+	if cfg.Anthropic != nil {
+		models["anthropic"] = cfg.Anthropic.Models
+	}
+	if cfg.Llama != nil {
+		models["llama"] = cfg.Llama.Models
+	}
+	for _, custom := range cfg.Custom {
+		models[custom.Name] = custom.Models
+	}
+	return models
+}
+
+// servesModel reports whether a provider can serve model: one of the
+// models it was configured with or, without a models list, a model
+// matching its name prefix
+func (r *Router) servesModel(name, model string) bool {
+	if models := r.models[name]; len(models) > 0 {
+		return slices.Contains(models, model)
+	}
+	return matchesProvider(model, name)
+}
+
+// allows reports whether model may be sent to a provider. A provider
+// configured with a models list only accepts those; one without takes any.
+func (r *Router) allows(name, model string) bool {
+	models := r.models[name]
+	return model == "" || len(models) == 0 || slices.Contains(models, model)
+}
+
+// AvailableModels returns the models each configured provider accepts. A
+// provider configured without a models list maps to nil and accepts any
+// model.
+func (o *Orchestrator) AvailableModels() map[string][]string {
+	available := make(map[string][]string, len(o.providers))
+	for name := range o.providers {
+		available[name] = slices.Clone(o.router.models[name])
+	}
+	return available
+}
+
+// selectProvider picks among the providers serving model, at random in
+// proportion to weight, success rate and inverse latency
+func (r *Router) selectProvider(model string) string {
+	var candidates []string
+	for name := range r.providers {
+		if r.servesModel(name, model) {
+			candidates = append(candidates, name)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return ""
+	case 1:
+		return candidates[0]
+	}
+	sort.Strings(candidates)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Providers without samples are scored at the mean latency so they
+	// get traffic to measure
+	var sum float64
+	var measured int
+	for _, name := range candidates {
+		if s, ok := r.stats[name]; ok && s.samples > 0 {
+			sum += s.latency
+			measured++
+		}
+	}
+	meanLatency := 1.0
+	if measured > 0 {
+		meanLatency = max(sum/float64(measured), 1)
+	}
+
+	scores := make([]float64, len(candidates))
+	var total float64
+	for i, name := range candidates {
+		weight, ok := r.weights[name]
+		if !ok {
+			weight = 1
+		}
+		if r.unhealthy[name] {
+			continue
+		}
+		latency, errorRate := meanLatency, 0.0
+		if s, ok := r.stats[name]; ok && s.samples > 0 {
+			latency, errorRate = max(s.latency, 1), s.errorRate
+		}
+		// Keep a floor so a recovering provider is still sampled
+		scores[i] = float64(weight) * max(1-errorRate, 0.05) / latency
+		total += scores[i]
+	}
+	if total == 0 {
+		return candidates[0]
+	}
+
+	pick := rand.Float64() * total
+	for i, score := range scores {
+		pick -= score
+		if pick < 0 && score > 0 {
+			return candidates[i]
+		}
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if scores[i] > 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[0]
+}
@@ -0,0 +1,177 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Ways of fitting a longer embedding to EmbeddingConfig.Dimensions
+const (
+	// NormalizeNone rejects embeddings of any other size
+	NormalizeNone = "none"
+	// NormalizeTruncate keeps the leading dimensions, which only models
+	// trained for it tolerate
+	NormalizeTruncate = "truncate"
+	// NormalizeProject applies a fixed random projection, which roughly
+	// preserves distances for any model
+	NormalizeProject = "project"
+)
+
+// minProjectedDimensions is the smallest size a projection is trusted to
+// preserve distances in
+const minProjectedDimensions = 256
+
+var (
+	// ErrEmbeddingDimensions is returned for an embedding that does not
+	// have the configured dimensions and cannot be fitted to them
+	ErrEmbeddingDimensions = errors.New("embedding dimensions do not match")
+	// ErrLossyNormalization is returned when truncating a model's
+	// embeddings would make them meaningless
+	ErrLossyNormalization = errors.New("model embeddings cannot be truncated")
+)
+
+// truncatableModels embed with Matryoshka representation learning, so a
+// prefix of their embeddings is an embedding itself
+var truncatableModels = []string{
+	"text-embedding-3-",
+	"text-embedding-004",
+	"gemini-embedding-",
+	"nomic-embed-text-v1.5",
+	"mxbai-embed-large",
+}
+
+// EmbeddingConfig fits every embedding to the fixed size of a vector store,
+// such as a pgvector column, whichever provider served it. Shorter
+// embeddings are padded with zeros, which preserves their similarities
+// exactly; longer ones are fitted as Normalization says. Fitted vectors
+// are the same size but remain comparable only with vectors of the same
+// model, see EmbeddingModel.
+type EmbeddingConfig struct {
+	// Dimensions is the size of every embedding; zero leaves them as the
+	// provider returns them
+	Dimensions int `json:"dimensions,omitempty"`
+	// Normalization is NormalizeNone, the default, NormalizeTruncate or
+	// NormalizeProject
+	Normalization string `json:"normalization,omitempty"`
+	// TruncatableModels adds to the models NormalizeTruncate accepts, by
+	// name or prefix
+	TruncatableModels []string `json:"truncatable_models,omitempty"`
+}
+
+func (c *EmbeddingConfig) validate() error {
+	if c.Dimensions < 0 {
+		return fmt.Errorf("embedding dimensions must not be negative")
+	}
+	switch c.Normalization {
+	case "", NormalizeNone, NormalizeTruncate:
+	case NormalizeProject:
+		if c.Dimensions < minProjectedDimensions {
+			return fmt.Errorf("embeddings cannot be projected to fewer than %d dimensions", minProjectedDimensions)
+		}
+	default:
+		return fmt.Errorf("unknown embedding normalization %q", c.Normalization)
+	}
+	return nil
+}
+
+// fit returns vector with the configured dimensions. model is the model
+// that embedded it, for errors and to check that it may be truncated.
+func (c *EmbeddingConfig) fit(model string, vector []float64) ([]float64, error) {
+	if c == nil || c.Dimensions == 0 || len(vector) == c.Dimensions {
+		return vector, nil
+	}
+	switch c.Normalization {
+	case "", NormalizeNone:
+		return nil, fmt.Errorf("%w: %s returned %d, want %d", ErrEmbeddingDimensions, model, len(vector), c.Dimensions)
+	}
+	if len(vector) < c.Dimensions {
+		padded := make([]float64, c.Dimensions)
+		copy(padded, vector)
+		return padded, nil
+	}
+
+	if c.Normalization == NormalizeProject {
+		return project(vector, c.Dimensions), nil
+	}
+	if !c.truncatable(model) {
+		return nil, fmt.Errorf("%w: %s was not trained for truncation to %d dimensions; use %q",
+			ErrLossyNormalization, model, c.Dimensions, NormalizeProject)
+	}
+	// A Matryoshka prefix is rescaled to unit length, as its models expect
+	truncated := append([]float64(nil), vector[:c.Dimensions]...)
+	var norm float64
+	for _, x := range truncated {
+		norm += x * x
+	}
+	if norm = math.Sqrt(norm); norm > 0 {
+		for i := range truncated {
+			truncated[i] /= norm
+		}
+	}
+	return truncated, nil
+}
+
+func (c *EmbeddingConfig) truncatable(model string) bool {
+	// The model may be "provider/model", as EmbeddingModel names it
+	model = model[strings.LastIndex(model, "/")+1:]
+	for _, models := range [][]string{truncatableModels, c.TruncatableModels} {
+		for _, prefix := range models {
+			if strings.HasPrefix(model, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// projections caches the sign bits of projection matrices by their
+// dimensions
+var projections sync.Map
+
+// project maps vector to dimensions with a random ±1/√dimensions matrix,
+// which preserves distances within a small factor (Johnson–Lindenstrauss).
+// The matrix is fixed by its size, so every vector of a given size is
+// projected alike.
+func project(vector []float64, dimensions int) []float64 {
+	from := len(vector)
+	key := [2]int{from, dimensions}
+	signs, ok := projections.Load(key)
+	if !ok {
+		signs, _ = projections.LoadOrStore(key, projectionSigns(from, dimensions))
+	}
+	bits := signs.([]uint64)
+
+	scale := 1 / math.Sqrt(float64(dimensions))
+	out := make([]float64, dimensions)
+	for i := range out {
+		var sum float64
+		row := i * from
+		for j, x := range vector {
+			if k := row + j; bits[k/64]&(1<<(k%64)) != 0 {
+				sum -= x
+			} else {
+				sum += x
+			}
+		}
+		out[i] = sum * scale
+	}
+	return out
+}
+
+// projectionSigns draws the signs of a rows×cols matrix from splitmix64
+// seeded by its size
+func projectionSigns(cols, rows int) []uint64 {
+	bits := make([]uint64, (rows*cols+63)/64)
+	state := uint64(cols)<<32 | uint64(rows)
+	for i := range bits {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		bits[i] = z ^ (z >> 31)
+	}
+	return bits
+}
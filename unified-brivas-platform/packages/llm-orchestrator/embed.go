@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// embedConcurrency bounds parallel single embeddings when a provider has no
+// batch endpoint
+const embedConcurrency = 4
+
+// embeddingModels names the model each embedding provider embeds with
+var embeddingModels = map[string]string{
+	"gemini": "text-embedding-004",
+	"openai": "text-embedding-3-small",
+}
+
+// EmbeddingModel names the provider and model Embed and EmbedBatch use, as
+// "provider/model". Vectors are only comparable with vectors of the same
+// model.
+func (o *Orchestrator) EmbeddingModel() (string, error) {
+	provider, err := o.embeddingProvider()
+	if err != nil {
+		return "", err
+	}
+	return provider.Name() + "/" + embeddingModels[provider.Name()], nil
+}
+
+// BatchEmbedder is implemented by providers that embed many texts in one
+// call
+type BatchEmbedder interface {
+	EmbedBatch(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// EmbedBatch embeds texts with the embedding provider, returning vectors in
+// the same order
+func (o *Orchestrator) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	provider, err := o.embeddingProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := startProviderSpan(ctx, "llm.embed_batch", provider.Name(), nil)
+	defer span.End()
+	span.SetAttributes(attribute.Int("llm.batch_size", len(texts)))
+
+	if b, ok := provider.(BatchEmbedder); ok {
+		vectors, err := b.EmbedBatch(ctx, texts)
+		if err == nil && len(vectors) != len(texts) {
+			err = fmt.Errorf("provider returned %d embeddings for %d texts", len(vectors), len(texts))
+		}
+		for i := 0; err == nil && i < len(vectors); i++ {
+			vectors[i], err = o.fitEmbedding(provider.Name(), vectors[i])
+		}
+		recordSpanError(span, err)
+		if err != nil {
+			return nil, err
+		}
+		return vectors, nil
+	}
+
+	vectors := make([][]float64, len(texts))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(embedConcurrency)
+	for i, text := range texts {
+		g.Go(func() error {
+			vector, err := provider.Embed(gctx, text)
+			if err != nil {
+				return err
+			}
+			vectors[i], err = o.fitEmbedding(provider.Name(), vector)
+			return err
+		})
+	}
+	err = g.Wait()
+	recordSpanError(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// fitEmbedding fits a vector provider embedded to the configured
+// dimensions
+func (o *Orchestrator) fitEmbedding(provider string, vector []float64) ([]float64, error) {
+	return o.embeddings.fit(provider+"/"+embeddingModels[provider], vector)
+}
@@ -0,0 +1,28 @@
+package llm
+
+import "strings"
+
+// Finish reasons, normalized across providers
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonContentFilter = "content_filter"
+	FinishReasonToolCalls     = "tool_calls"
+)
+
+// normalizeFinishReason maps a provider's native stop reason (OpenAI
+// finish_reason, Gemini finishReason, Anthropic stop_reason) to the values
+// above. Unknown reasons are kept as is.
+func normalizeFinishReason(reason string) string {
+	switch strings.ToLower(reason) {
+	case "", "stop", "end_turn", "stop_sequence", "eos":
+		return FinishReasonStop
+	case "length", "max_tokens":
+		return FinishReasonLength
+	case "content_filter", "safety", "recitation", "blocklist", "prohibited_content", "spii", "refusal":
+		return FinishReasonContentFilter
+	case "tool_calls", "function_call", "tool_use":
+		return FinishReasonToolCalls
+	}
+	return reason
+}
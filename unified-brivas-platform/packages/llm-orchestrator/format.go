@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"encoding/json"
+)
+
+// Response formats
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat constrains the shape of a completion. Schema is a JSON
+// Schema and only applies to json_schema.
+type ResponseFormat struct {
+	Type   string                 `json:"type"`
+	Name   string                 `json:"name,omitempty"`
+	Schema map[string]interface{} `json:"schema,omitempty"`
+}
+
+// ResponseFormatSupporter is implemented by providers that can enforce a
+// response format natively
+type ResponseFormatSupporter interface {
+	SupportsResponseFormat(format string) bool
+}
+
+const jsonInstruction = "Respond with a single valid JSON object only, with no prose or markdown code fences."
+
+// withResponseFormat returns req as provider should receive it: unchanged
+// when the provider enforces the format itself, otherwise without the
+// format and with an instruction in the system prompt instead
+func withResponseFormat(provider Provider, req *CompletionRequest) *CompletionRequest {
+	format := req.ResponseFormat
+	if format == nil || format.Type == "" || format.Type == ResponseFormatText {
+		return req
+	}
+	if s, ok := provider.(ResponseFormatSupporter); ok && s.SupportsResponseFormat(format.Type) {
+		return req
+	}
+
+	instruction := jsonInstruction
+	if format.Type == ResponseFormatJSONSchema && format.Schema != nil {
+		schema, _ := json.Marshal(format.Schema)
+		instruction += " It must match this JSON Schema: " + string(schema)
+	}
+
+	out := *req
+	out.ResponseFormat = nil
+	out.Messages = make([]Message, 0, len(req.Messages)+1)
+	if len(req.Messages) > 0 && req.Messages[0].Role == "system" {
+		system := req.Messages[0]
+		system.Content += "\n\n" + instruction
+		out.Messages = append(out.Messages, system)
+		out.Messages = append(out.Messages, req.Messages[1:]...)
+	} else {
+		out.Messages = append(out.Messages, Message{Role: "system", Content: instruction})
+		out.Messages = append(out.Messages, req.Messages...)
+	}
+	return &out
+}
+
+// SupportsResponseFormat reports Gemini's responseMimeType/responseSchema
+func (p *GeminiProvider) SupportsResponseFormat(format string) bool {
+	return format == ResponseFormatJSONObject || format == ResponseFormatJSONSchema
+}
+
+// SupportsResponseFormat reports OpenAI's response_format modes
+func (p *OpenAIProvider) SupportsResponseFormat(format string) bool {
+	return format == ResponseFormatJSONObject || format == ResponseFormatJSONSchema
+}
+
+// SupportsResponseFormat reports the JSON mode of OpenAI-compatible Llama
+// servers
+func (p *LlamaProvider) SupportsResponseFormat(format string) bool {
+	return format == ResponseFormatJSONObject
+}
+
+// SupportsResponseFormat assumes custom endpoints implement OpenAI's JSON
+// mode but not schemas
+func (p *OpenAICompatibleProvider) SupportsResponseFormat(format string) bool {
+	return format == ResponseFormatJSONObject
+}
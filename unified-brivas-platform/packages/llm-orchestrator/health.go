@@ -0,0 +1,146 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// warmUpTimeout bounds the startup health checks
+const warmUpTimeout = 10 * time.Second
+
+// ErrKeyRejected is a provider refusing its API key in a health check
+var ErrKeyRejected = errors.New("API key rejected")
+
+// probe issues a cheap GET, typically a models list, and fails on any
+// non-2xx status, with ErrKeyRejected for 401 and 403
+func probe(ctx context.Context, client *http.Client, url string, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: health check returned %s", ErrKeyRejected, resp.Status)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// bearer returns the Authorization header for an optional API key
+func bearer(apiKey string) map[string]string {
+	if apiKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + apiKey}
+}
+
+// HealthCheckAll probes every provider concurrently and returns each
+// result, nil meaning reachable. Unhealthy providers are moved to the end
+// of the fallback order until they pass a check or serve a request.
+func (o *Orchestrator) HealthCheckAll(ctx context.Context) map[string]error {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]error, len(o.providers))
+	)
+	for name, provider := range o.providers {
+		wg.Add(1)
+		go func(name string, provider Provider) {
+			defer wg.Done()
+			err := provider.HealthCheck(ctx)
+			o.router.SetHealthy(name, err == nil)
+
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, provider)
+	}
+	wg.Wait()
+	return results
+}
+
+// HealthCheck reports whether completions can be served: nil when at
+// least one provider is reachable, otherwise every provider's error
+func (o *Orchestrator) HealthCheck(ctx context.Context) error {
+	results := o.HealthCheckAll(ctx)
+	if len(results) == 0 {
+		return errors.New("no LLM providers configured")
+	}
+	var errs []error
+	for name, err := range results {
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// WarmUp runs HealthCheckAll at startup and logs which providers are live
+func (o *Orchestrator) WarmUp(ctx context.Context) {
+	for name, err := range o.HealthCheckAll(ctx) {
+		if err != nil {
+			o.logger.Warn("LLM provider unreachable", zap.String("provider", name), zap.Error(err))
+			continue
+		}
+		o.logger.Info("LLM provider live", zap.String("provider", name))
+	}
+}
+
+// validateKeys runs HealthCheckAll at startup to catch API keys providers
+// reject, logging each and, with exclude, dropping its provider. It must
+// run before the orchestrator serves requests. The error joins every
+// provider's failure when none passed.
+func (o *Orchestrator) validateKeys(ctx context.Context, exclude bool) error {
+	results := o.HealthCheckAll(ctx)
+	if len(results) == 0 {
+		return errors.New("no LLM providers configured")
+	}
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		err := results[name]
+		switch {
+		case err == nil:
+			o.logger.Info("LLM provider key valid", zap.String("provider", name))
+			continue
+		case errors.Is(err, ErrKeyRejected) && exclude:
+			o.logger.Error("LLM provider key rejected, provider excluded", zap.String("provider", name), zap.Error(err))
+			delete(o.providers, name)
+		case errors.Is(err, ErrKeyRejected):
+			o.logger.Error("LLM provider key rejected", zap.String("provider", name), zap.Error(err))
+		default:
+			o.logger.Warn("LLM provider unreachable, key not validated", zap.String("provider", name), zap.Error(err))
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	if len(errs) == len(names) {
+		return fmt.Errorf("no LLM provider validated: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package llm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OpenAI-compatible wire types
+
+type chatCompletionRequest struct {
+	Model          string                `json:"model"`
+	Messages       []Message             `json:"messages"`
+	Temperature    float64               `json:"temperature,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	TopP           float64               `json:"top_p,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	Tools          []Tool                `json:"tools,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name   string                 `json:"name"`
+		Schema map[string]interface{} `json:"schema"`
+	} `json:"json_schema,omitempty"`
+}
+
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *Usage       `json:"usage,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int      `json:"index"`
+	Message      *Message `json:"message,omitempty"`
+	Delta        *Message `json:"delta,omitempty"`
+	FinishReason *string  `json:"finish_reason"`
+}
+
+type embeddingRequest struct {
+	Model string          `json:"model"`
+	Input json.RawMessage `json:"input"`
+}
+
+type embeddingData struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// HTTPHandler serves the orchestrator with OpenAI's API shape, so OpenAI
+// client libraries can use it as a drop-in gateway:
+//
+//	POST /v1/chat/completions  (stream: true answers with server-sent events)
+//	POST /v1/embeddings
+//
+// The requested model drives routing as in Complete.
+//
+// The handler does not authenticate: mount it behind the auth engine's
+// Middleware, which places the caller's claims in the request's context.
+// The usage recorder and the quota enforcer attribute tokens to the caller
+// through those claims; with plan quotas, callers without an account are
+// refused. Exposed without both, it is an open proxy to the providers.
+func (o *Orchestrator) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", o.handleChatCompletions)
+	mux.HandleFunc("POST /v1/embeddings", o.handleEmbeddings)
+	return mux
+}
+
+func (o *Orchestrator) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var body chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeOpenAIError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Messages) == 0 {
+		writeOpenAIError(w, "messages is required", http.StatusBadRequest)
+		return
+	}
+
+	req := &CompletionRequest{
+		Messages:    body.Messages,
+		Model:       body.Model,
+		Temperature: body.Temperature,
+		MaxTokens:   body.MaxTokens,
+		TopP:        body.TopP,
+		Stream:      body.Stream,
+		Tools:       body.Tools,
+	}
+	if f := body.ResponseFormat; f != nil {
+		req.ResponseFormat = &ResponseFormat{Type: f.Type}
+		if f.JSONSchema != nil {
+			req.ResponseFormat.Name = f.JSONSchema.Name
+			req.ResponseFormat.Schema = f.JSONSchema.Schema
+		}
+	}
+
+	if body.Stream {
+		o.streamChatCompletion(w, r, req)
+		return
+	}
+
+	resp, err := o.Complete(r.Context(), req)
+	if errors.Is(err, ErrModelNotAllowed) {
+		writeOpenAIError(w, fmt.Sprintf("model %s is not available", req.Model), http.StatusNotFound)
+		return
+	}
+	if status, ok := quotaStatus(err); ok {
+		writeOpenAIError(w, err.Error(), status)
+		return
+	}
+	if err != nil {
+		o.logger.Warn("chat completion failed", zap.Error(err))
+		writeOpenAIError(w, "completion failed", http.StatusBadGateway)
+		return
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = body.Model
+	}
+	finish := resp.FinishReason
+	writeJSON(w, chatCompletion{
+		ID:      completionID(resp.ID),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []chatChoice{{
+			Message:      &Message{Role: "assistant", Content: resp.Content},
+			FinishReason: &finish,
+		}},
+		Usage: &resp.Usage,
+	})
+}
+
+func (o *Orchestrator) streamChatCompletion(w http.ResponseWriter, r *http.Request, req *CompletionRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, err := o.Stream(r.Context(), req)
+	if errors.Is(err, ErrModelNotAllowed) {
+		writeOpenAIError(w, fmt.Sprintf("model %s is not available", req.Model), http.StatusNotFound)
+		return
+	}
+	if status, ok := quotaStatus(err); ok {
+		writeOpenAIError(w, err.Error(), status)
+		return
+	}
+	if err != nil {
+		o.logger.Warn("chat completion stream failed", zap.Error(err))
+		writeOpenAIError(w, "completion failed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	id, created := completionID(""), time.Now().Unix()
+	send := func(choice chatChoice, usage *Usage) {
+		data, _ := json.Marshal(chatCompletion{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []chatChoice{choice},
+			Usage:   usage,
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	send(chatChoice{Delta: &Message{Role: "assistant"}}, nil)
+	for chunk := range ch {
+		if chunk.Error != nil {
+			// Headers are sent, so the error can only end the stream
+			o.logger.Warn("chat completion stream failed", zap.Error(chunk.Error))
+			break
+		}
+		if chunk.Content != "" {
+			send(chatChoice{Delta: &Message{Content: chunk.Content}}, nil)
+		}
+		if chunk.Done {
+			finish := chunk.FinishReason
+			send(chatChoice{Delta: &Message{}, FinishReason: &finish}, chunk.Usage)
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+func (o *Orchestrator) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var body embeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeOpenAIError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// input is a string or an array of strings
+	var inputs []string
+	var single string
+	if err := json.Unmarshal(body.Input, &single); err == nil {
+		inputs = []string{single}
+	} else if err := json.Unmarshal(body.Input, &inputs); err != nil || len(inputs) == 0 {
+		writeOpenAIError(w, "input must be a string or an array of strings", http.StatusBadRequest)
+		return
+	}
+
+	var tokens int
+	for _, text := range inputs {
+		tokens += estimateTokens(text)
+	}
+	if q := o.quotaEnforcer(); q != nil {
+		err := q.CheckQuota(r.Context(), tokens)
+		if status, ok := quotaStatus(err); ok {
+			writeOpenAIError(w, err.Error(), status)
+			return
+		}
+		if err != nil {
+			o.logger.Warn("token quota check failed", zap.Error(err))
+			writeOpenAIError(w, "embedding failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	provider, err := o.embeddingProvider()
+	if err != nil {
+		writeOpenAIError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	data := make([]embeddingData, len(inputs))
+	for i, text := range inputs {
+		embedding, err := o.Embed(r.Context(), text)
+		if err != nil {
+			o.logger.Warn("embedding failed", zap.Error(err))
+			writeOpenAIError(w, "embedding failed", http.StatusBadGateway)
+			return
+		}
+		data[i] = embeddingData{Object: "embedding", Index: i, Embedding: embedding}
+	}
+	o.recordUsage(r.Context(), provider.Name(), embeddingModels[provider.Name()],
+		Usage{PromptTokens: tokens, TotalTokens: tokens})
+
+	writeJSON(w, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  body.Model,
+		"usage":  map[string]int{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+}
+
+// completionID returns the provider's response id or a new random one
+func completionID(id string) string {
+	if id != "" {
+		return id
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeOpenAIError(w http.ResponseWriter, message string, status int) {
+	errType := "invalid_request_error"
+	if status >= 500 {
+		errType = "api_error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": errType},
+	})
+}
@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Job defaults
+const (
+	DefaultJobAttempts    = 3
+	DefaultJobBaseDelay   = time.Second
+	DefaultJobMaxDelay    = 30 * time.Second
+	DefaultJobConcurrency = 4
+)
+
+// ErrPermanent marks an item failure that retrying cannot fix, such as
+// invalid input or a refusal; see Permanent
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent wraps err so RunJob dead-letters its item without retrying
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// RetryPolicy is how often and how patiently a job item is retried
+type RetryPolicy struct {
+	// MaxAttempts includes the first; DefaultJobAttempts if 0
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// BaseDelay doubles after each failed attempt up to MaxDelay, plus up
+	// to 10% jitter; DefaultJobBaseDelay and DefaultJobMaxDelay if 0
+	BaseDelay time.Duration `json:"base_delay,omitempty"`
+	MaxDelay  time.Duration `json:"max_delay,omitempty"`
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultJobAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultJobBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultJobMaxDelay
+	}
+	return p
+}
+
+// backoff is the wait before retrying after the given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MaxDelay
+	if attempt < 20 {
+		d = min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+	}
+	return d + time.Duration(mathrand.Int63n(int64(d/10)+1))
+}
+
+// retryable reports whether an item that failed with err may succeed when
+// retried; requests no provider will serve never do
+func retryable(err error) bool {
+	return !errors.Is(err, ErrPermanent) && !errors.Is(err, ErrModelNotAllowed)
+}
+
+// sleep waits for d, reporting false if ctx ended first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// DeadLetter is a job item that failed every attempt, kept so it can be
+// inspected and resubmitted
+type DeadLetter struct {
+	JobID string `json:"job_id"`
+	Job   string `json:"job"`
+	// Index is the item's position in the job
+	Index int `json:"index"`
+	// Item is the item as JSON
+	Item     json.RawMessage `json:"item"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	Time     time.Time       `json:"time"`
+}
+
+// DeadLetters stores the items jobs gave up on. Errors storing them are
+// logged and reported in the job summary.
+type DeadLetters interface {
+	DeadLetter(ctx context.Context, letter *DeadLetter) error
+}
+
+// Job processes a batch of items that each call an LLM, so one transient
+// failure costs a retry of its item rather than the batch. Items are
+// retried with backoff as Retry says; those that fail every attempt, or
+// fail permanently, are stored in DeadLetters. Run it with RunJob.
+type Job struct {
+	// Name identifies the kind of job, such as "campaign.optimize"
+	Name  string
+	Retry RetryPolicy
+	// Concurrency is how many items are processed at once,
+	// DefaultJobConcurrency if 0
+	Concurrency int
+	// DeadLetters may be nil, in which case failed items are only reported
+	DeadLetters DeadLetters
+	Logger      *zap.Logger
+}
+
+// ItemResult is the outcome of one job item
+type ItemResult[R any] struct {
+	Index    int    `json:"index"`
+	Result   *R     `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts"`
+	// DeadLettered is set once a failed item is stored for resubmission
+	DeadLettered bool `json:"dead_lettered,omitempty"`
+}
+
+// JobSummary is what a job did, with a result for every item in order
+type JobSummary[R any] struct {
+	ID        string          `json:"job_id"`
+	Job       string          `json:"job"`
+	Total     int             `json:"total"`
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Items     []ItemResult[R] `json:"items"`
+}
+
+// RunJob processes items with fn and returns the outcome of each. It
+// returns once every item has succeeded or been given up on; items not
+// started when ctx ends fail with its error and are not dead-lettered,
+// since nothing was wrong with them.
+func RunJob[T, R any](ctx context.Context, job *Job, items []T, fn func(ctx context.Context, item T) (R, error)) *JobSummary[R] {
+	retry := job.Retry.withDefaults()
+	concurrency := job.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultJobConcurrency
+	}
+	logger := job.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	summary := &JobSummary[R]{
+		ID:    newJobID(),
+		Job:   job.Name,
+		Total: len(items),
+		Items: make([]ItemResult[R], len(items)),
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func(i int, item T) {
+			defer wg.Done()
+			res := &summary.Items[i]
+			res.Index = i
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				res.Error = ctx.Err().Error()
+				return
+			}
+
+			for {
+				res.Attempts++
+				value, err := fn(ctx, item)
+				if err == nil {
+					res.Result = &value
+					return
+				}
+				res.Error = err.Error()
+				if ctx.Err() != nil {
+					return
+				}
+				if !retryable(err) || res.Attempts == retry.MaxAttempts {
+					res.DeadLettered = deadLetter(ctx, job, summary.ID, i, item, err, res.Attempts, logger)
+					return
+				}
+				if !sleep(ctx, retry.backoff(res.Attempts)) {
+					return
+				}
+			}
+		}(i, item)
+	}
+	wg.Wait()
+
+	for _, res := range summary.Items {
+		if res.Result != nil {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// deadLetter stores a failed item, reporting whether it was stored
+func deadLetter[T any](ctx context.Context, job *Job, jobID string, index int, item T, err error, attempts int, logger *zap.Logger) bool {
+	logger.Warn("job item failed",
+		zap.String("job", job.Name), zap.String("job_id", jobID), zap.Int("index", index),
+		zap.Int("attempts", attempts), zap.Error(err))
+	if job.DeadLetters == nil {
+		return false
+	}
+	data, _ := json.Marshal(item)
+	// The item is kept even if the caller has gone away
+	if derr := job.DeadLetters.DeadLetter(context.WithoutCancel(ctx), &DeadLetter{
+		JobID:    jobID,
+		Job:      job.Name,
+		Index:    index,
+		Item:     data,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Time:     time.Now().UTC(),
+	}); derr != nil {
+		logger.Error("failed to dead-letter job item",
+			zap.String("job", job.Name), zap.String("job_id", jobID), zap.Int("index", index), zap.Error(derr))
+		return false
+	}
+	return true
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "job_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxResponseSize bounds a non-streamed response body
+const maxResponseSize = 16 << 20
+
+// postJSON posts body to url and decodes the JSON response into out. A
+// non-2xx status is an error carrying the API's message when it sent one.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("request returned %s: %s", resp.Status, apiErr.Error.Message)
+		}
+		return fmt.Errorf("request returned %s: %s", resp.Status, bytes.TrimSpace(data[:min(len(data), 64<<10)]))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// completeOpenAI posts a chat completion to an OpenAI-compatible endpoint.
+// The response's Provider is left to the caller.
+func completeOpenAI(ctx context.Context, client *http.Client, url string, headers map[string]string, model string, req *CompletionRequest) (*CompletionResponse, error) {
+	var completion chatCompletion
+	if err := postJSON(ctx, client, url, headers, openAIChatBody(model, req), &completion); err != nil {
+		return nil, err
+	}
+	if len(completion.Choices) == 0 || completion.Choices[0].Message == nil {
+		return nil, fmt.Errorf("completion returned no choices")
+	}
+
+	choice := completion.Choices[0]
+	resp := &CompletionResponse{
+		ID:      completion.ID,
+		Model:   completion.Model,
+		Content: choice.Message.Content,
+	}
+	if resp.Model == "" {
+		resp.Model = model
+	}
+	if choice.FinishReason != nil {
+		resp.FinishReason = *choice.FinishReason
+	}
+	if completion.Usage != nil {
+		resp.Usage = *completion.Usage
+	}
+	return resp, nil
+}
+
+// embedOpenAI embeds texts with an OpenAI-compatible embeddings endpoint,
+// returning vectors in the same order
+func embedOpenAI(ctx context.Context, client *http.Client, url string, headers map[string]string, model string, texts []string) ([][]float64, error) {
+	input, err := json.Marshal(texts)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data []embeddingData `json:"data"`
+	}
+	if err := postJSON(ctx, client, url, headers, embeddingRequest{Model: model, Input: input}, &result); err != nil {
+		return nil, err
+	}
+
+	// Servers may answer out of order; index says which input each is for
+	vectors := make([][]float64, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+	return vectors, nil
+}
@@ -3,14 +3,22 @@
 package llm
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/brivas/unified-platform/packages/netguard"
 )
 
 // Provider defines the interface for LLM providers
@@ -19,6 +27,7 @@ type Provider interface {
 	Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error)
 	Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error)
 	Embed(ctx context.Context, text string) ([]float64, error)
+	HealthCheck(ctx context.Context) error
 }
 
 // CompletionRequest represents a chat completion request
@@ -31,6 +40,9 @@ type CompletionRequest struct {
 	Stream      bool                   `json:"stream,omitempty"`
 	Tools       []Tool                 `json:"tools,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	// ResponseFormat is passed to providers that support it and becomes a
+	// prompt instruction for the others
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
 // Message represents a chat message
@@ -62,6 +74,12 @@ type CompletionResponse struct {
 	Usage    Usage  `json:"usage"`
 	Latency  int64  `json:"latency_ms"`
 	Cached   bool   `json:"cached"`
+	// FinishReason tells a complete answer from a truncated or filtered one
+	FinishReason string `json:"finish_reason,omitempty"`
+	// RequestID identifies the request in the request log, for Replay
+	RequestID string `json:"request_id,omitempty"`
+	// Cost is estimated from the usage, nil for models without a price
+	Cost *Cost `json:"cost,omitempty"`
 }
 
 // Usage tracks token usage
@@ -71,21 +89,38 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
-// StreamChunk represents a streaming response chunk
+// StreamChunk represents a streaming response chunk. The terminal chunk
+// (Done) carries the usage of the whole response.
 type StreamChunk struct {
 	Content string `json:"content"`
 	Done    bool   `json:"done"`
 	Error   error  `json:"error,omitempty"`
+	Usage   *Usage `json:"usage,omitempty"`
+	// FinishReason is set on the terminal chunk
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Cost is set on the terminal chunk, for models with a price
+	Cost *Cost `json:"cost,omitempty"`
 }
 
+// ErrModelNotAllowed is returned when no provider's models list includes
+// the requested model
+var ErrModelNotAllowed = errors.New("model not enabled for any provider")
+
 // Orchestrator manages multiple LLM providers with routing and fallback
 type Orchestrator struct {
 	providers map[string]Provider
 	router    *Router
-	fallback  *FallbackChain
 	cache     *Cache
+	flight    singleflight.Group
+	prompts   *PromptRegistry
 	logger    *zap.Logger
-	mu        sync.RWMutex
+	usage     UsageRecorder
+	quota     QuotaEnforcer
+	pricing   Pricing
+	requests  *requestLogger
+	// embeddings is nil when embeddings are returned as served
+	embeddings *EmbeddingConfig
+	mu         sync.RWMutex
 }
 
 // Config holds orchestrator configuration
@@ -96,26 +131,45 @@ type Config struct {
 	Grok      *GrokConfig      `json:"grok,omitempty"`
 	Llama     *LlamaConfig     `json:"llama,omitempty"`
 	Custom    []CustomConfig   `json:"custom,omitempty"`
+	// Prompts are registered with the orchestrator's prompt registry
+	Prompts []PromptTemplate `json:"prompts,omitempty"`
+	// WarmUp health checks every provider on startup
+	WarmUp bool `json:"warm_up,omitempty"`
+	// ValidateOnStart checks every provider's API key on startup instead,
+	// failing NewOrchestrator when no provider passes
+	ValidateOnStart bool `json:"validate_on_start,omitempty"`
+	// ExcludeRejectedKeys drops the providers whose key ValidateOnStart
+	// finds rejected, rather than only logging them
+	ExcludeRejectedKeys bool `json:"exclude_rejected_keys,omitempty"`
+	// RequestLog keeps recent requests and responses for debugging
+	RequestLog *RequestLogConfig `json:"request_log,omitempty"`
+	// Pricing adds to or overrides DefaultPricing
+	Pricing Pricing `json:"pricing,omitempty"`
+	// Embeddings fits embeddings to the dimensions of a vector store
+	Embeddings *EmbeddingConfig `json:"embeddings,omitempty"`
 }
 
 // GeminiConfig configures Google Gemini
 type GeminiConfig struct {
-	APIKey    string   `json:"api_key"`
-	Models    []string `json:"models"`
-	ProjectID string   `json:"project_id,omitempty"`
+	APIKey    string    `json:"api_key"`
+	Models    []string  `json:"models"`
+	ProjectID string    `json:"project_id,omitempty"`
+	Timeouts  *Timeouts `json:"timeouts,omitempty"`
 }
 
 // OpenAIConfig configures OpenAI
 type OpenAIConfig struct {
-	APIKey       string   `json:"api_key"`
-	Organization string   `json:"organization,omitempty"`
-	Models       []string `json:"models"`
+	APIKey       string    `json:"api_key"`
+	Organization string    `json:"organization,omitempty"`
+	Models       []string  `json:"models"`
+	Timeouts     *Timeouts `json:"timeouts,omitempty"`
 }
 
 // AnthropicConfig configures Anthropic Claude
 type AnthropicConfig struct {
-	APIKey string   `json:"api_key"`
-	Models []string `json:"models"`
+	APIKey   string    `json:"api_key"`
+	Models   []string  `json:"models"`
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
 }
 
 // GrokConfig configures xAI Grok
@@ -126,25 +180,63 @@ type GrokConfig struct {
 
 // LlamaConfig configures on-premises Llama
 type LlamaConfig struct {
-	Endpoint string   `json:"endpoint"`
-	Models   []string `json:"models"`
-	APIKey   string   `json:"api_key,omitempty"` // Optional for local
+	Endpoint string    `json:"endpoint"`
+	Models   []string  `json:"models"`
+	APIKey   string    `json:"api_key,omitempty"` // Optional for local
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+
+	// EmbeddingModel is the model Embed uses, the first of Models if empty
+	EmbeddingModel string `json:"embedding_model,omitempty"`
 }
 
 // CustomConfig configures custom OpenAI-compatible endpoints
 type CustomConfig struct {
-	Name     string   `json:"name"`
-	Endpoint string   `json:"endpoint"`
-	APIKey   string   `json:"api_key"`
-	Models   []string `json:"models"`
+	Name     string    `json:"name"`
+	Endpoint string    `json:"endpoint"`
+	APIKey   string    `json:"api_key"`
+	Models   []string  `json:"models"`
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+	// Egress is what Endpoint may connect to: public addresses, and any
+	// trusted internal ones listed in Egress.Allow
+	Egress netguard.Policy `json:"egress"`
+
+	// EmbeddingModel is the model Embed uses, the first of Models if empty
+	EmbeddingModel string `json:"embedding_model,omitempty"`
 }
 
 // NewOrchestrator creates a new LLM orchestrator
 func NewOrchestrator(cfg *Config, logger *zap.Logger) (*Orchestrator, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
 	o := &Orchestrator{
 		providers: make(map[string]Provider),
 		logger:    logger,
 		cache:     NewCache(1000, 1*time.Hour),
+		prompts:   NewPromptRegistry(),
+		pricing:   DefaultPricing(),
+	}
+	for model, price := range cfg.Pricing {
+		o.pricing[model] = price
+	}
+
+	if err := o.prompts.Load(cfg.Prompts); err != nil {
+		return nil, err
+	}
+
+	if cfg.Embeddings != nil {
+		if err := cfg.Embeddings.validate(); err != nil {
+			return nil, err
+		}
+		o.embeddings = cfg.Embeddings
+	}
+
+	if rl := cfg.RequestLog; rl != nil && rl.Enabled {
+		opts := RequestLogOptions{OmitContent: rl.OmitContent}
+		if rl.RedactPII {
+			opts.Redact = RedactPII
+		}
+		o.SetRequestLog(NewMemoryRequestLog(rl.Size), opts)
 	}
 
 	// Initialize Gemini provider
@@ -204,7 +296,21 @@ func NewOrchestrator(cfg *Config, logger *zap.Logger) (*Orchestrator, error) {
 
 	// Setup router with default strategy
 	o.router = NewRouter(o.providers)
-	o.fallback = NewFallbackChain([]string{"gemini", "openai", "anthropic", "llama"})
+	o.router.models = configuredModels(cfg)
+
+	switch {
+	case cfg.ValidateOnStart:
+		ctx, cancel := context.WithTimeout(context.Background(), warmUpTimeout)
+		err := o.validateKeys(ctx, cfg.ExcludeRejectedKeys)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	case cfg.WarmUp:
+		ctx, cancel := context.WithTimeout(context.Background(), warmUpTimeout)
+		o.WarmUp(ctx)
+		cancel()
+	}
 
 	return o, nil
 }
@@ -212,33 +318,87 @@ func NewOrchestrator(cfg *Config, logger *zap.Logger) (*Orchestrator, error) {
 // Complete sends a completion request to the appropriate provider
 func (o *Orchestrator) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 	start := time.Now()
+	resp, err := o.completeCached(ctx, req)
+	return o.logRequest(ctx, &RequestLogEntry{}, req, resp, err, start), err
+}
+
+// completeCached answers req from the cache or a provider
+func (o *Orchestrator) completeCached(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	start := time.Now()
 
 	// Check cache first
 	cacheKey := o.getCacheKey(req)
 	if cached := o.cache.Get(cacheKey); cached != nil {
 		cached.Cached = true
 		cached.Latency = time.Since(start).Milliseconds()
+		// A cached answer costs nothing
+		if cached.Cost != nil {
+			free := *cached.Cost
+			free.Amount = 0
+			cached.Cost = &free
+		}
 		return cached, nil
 	}
 
-	// Route to appropriate provider
-	providerName := o.router.Route(req)
-	provider, ok := o.providers[providerName]
-	if !ok {
-		// Use fallback chain
-		return o.executeWithFallback(ctx, req)
+	if err := o.checkQuota(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// The cached response is shared, so callers get their own copy
+	if req.Stream {
+		shared, err := o.complete(ctx, req, cacheKey)
+		if err != nil {
+			return nil, err
+		}
+		resp := *shared
+		resp.Latency = time.Since(start).Milliseconds()
+		return &resp, nil
+	}
+
+	// Concurrent identical requests share one provider call. It runs
+	// detached from any single caller's cancellation since others may
+	// still be waiting on it.
+	ch := o.flight.DoChan(cacheKey, func() (interface{}, error) {
+		return o.complete(context.WithoutCancel(ctx), req, cacheKey)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		resp := *res.Val.(*CompletionResponse)
+		resp.Latency = time.Since(start).Milliseconds()
+		return &resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// complete routes req, falls back on failure and caches the response
+func (o *Orchestrator) complete(ctx context.Context, req *CompletionRequest, cacheKey string) (*CompletionResponse, error) {
+	// Route to appropriate provider, falling back to the rest in priority
+	chain := o.router.Fallback(req).Chain()
+	if len(chain) == 0 {
+		return nil, o.router.unavailable(req)
 	}
 
 	// Execute request
-	resp, err := provider.Complete(ctx, req)
+	resp, err := o.completeWith(ctx, o.providers[chain[0]], req)
 	if err != nil {
 		o.logger.Warn("Provider failed, trying fallback",
-			zap.String("provider", providerName),
+			zap.String("provider", chain[0]),
 			zap.Error(err))
-		return o.executeWithFallback(ctx, req)
+		resp, err = o.executeWithFallback(ctx, req, chain[1:], err)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	resp.Latency = time.Since(start).Milliseconds()
+	if resp.Usage.TotalTokens == 0 {
+		resp.Usage = estimateUsage(req, resp.Content)
+	}
+	resp.Cost = o.EstimateCost(resp.Provider, resp.Model, resp.Usage)
+	o.recordUsage(ctx, resp.Provider, resp.Model, resp.Usage)
 
 	// Cache response
 	o.cache.Set(cacheKey, resp)
@@ -246,28 +406,38 @@ func (o *Orchestrator) Complete(ctx context.Context, req *CompletionRequest) (*C
 	return resp, nil
 }
 
-func (o *Orchestrator) executeWithFallback(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
-	for _, providerName := range o.fallback.Chain() {
+// executeWithFallback tries chain in order after the routed provider failed
+// with firstErr. Unconfigured providers are skipped and not counted as
+// attempts.
+func (o *Orchestrator) executeWithFallback(ctx context.Context, req *CompletionRequest, chain []string, firstErr error) (*CompletionResponse, error) {
+	attempts, lastErr := 1, firstErr
+	for _, providerName := range chain {
 		provider, ok := o.providers[providerName]
 		if !ok {
 			continue
 		}
 
-		resp, err := provider.Complete(ctx, req)
+		attempts++
+		resp, err := o.completeWith(ctx, provider, req)
 		if err == nil {
 			return resp, nil
 		}
+		lastErr = err
 
 		o.logger.Warn("Fallback provider failed",
 			zap.String("provider", providerName),
 			zap.Error(err))
 	}
 
-	return nil, fmt.Errorf("all providers failed")
+	return nil, fmt.Errorf("all %d providers failed: %w", attempts, lastErr)
 }
 
 func (o *Orchestrator) getCacheKey(req *CompletionRequest) string {
 	data, _ := json.Marshal(req.Messages)
+	if req.ResponseFormat != nil {
+		format, _ := json.Marshal(req.ResponseFormat)
+		data = append(data, format...)
+	}
 	return fmt.Sprintf("%s:%x", req.Model, data)
 }
 
@@ -276,32 +446,104 @@ func (o *Orchestrator) Stream(ctx context.Context, req *CompletionRequest) (<-ch
 	providerName := o.router.Route(req)
 	provider, ok := o.providers[providerName]
 	if !ok {
-		return nil, fmt.Errorf("no provider available")
+		return nil, o.router.unavailable(req)
+	}
+	if err := o.checkQuota(ctx, req); err != nil {
+		return nil, err
 	}
 
-	return provider.Stream(ctx, req)
+	start := time.Now()
+	ctx, span := startProviderSpan(ctx, "llm.stream", provider.Name(), req)
+
+	ch, err := provider.Stream(ctx, withResponseFormat(provider, req))
+	if err != nil {
+		recordSpanError(span, err)
+		span.End()
+		return nil, err
+	}
+	return o.meterStream(ctx, provider.Name(), req.Model, req, ch, func(content string, final *StreamChunk) {
+		span.End()
+		o.logStream(ctx, req, provider.Name(), start, content, final)
+	}), nil
 }
 
 // Embed generates embeddings for text
 func (o *Orchestrator) Embed(ctx context.Context, text string) ([]float64, error) {
-	// Prefer Gemini for embeddings, fallback to OpenAI
-	if provider, ok := o.providers["gemini"]; ok {
-		return provider.Embed(ctx, text)
+	provider, err := o.embeddingProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := startProviderSpan(ctx, "llm.embed", provider.Name(), nil)
+	embedding, err := provider.Embed(ctx, text)
+	if err == nil {
+		embedding, err = o.fitEmbedding(provider.Name(), embedding)
 	}
-	if provider, ok := o.providers["openai"]; ok {
-		return provider.Embed(ctx, text)
+	recordSpanError(span, err)
+	span.End()
+	return embedding, err
+}
+
+// embeddingProvider prefers Gemini for embeddings, falling back to OpenAI.
+// Vectors from different providers are not comparable, so single and batch
+// embeddings always come from the same provider.
+func (o *Orchestrator) embeddingProvider() (Provider, error) {
+	for _, name := range []string{"gemini", "openai"} {
+		if provider, ok := o.providers[name]; ok {
+			return provider, nil
+		}
 	}
 	return nil, fmt.Errorf("no embedding provider available")
 }
 
+// completeWith calls a single provider inside its own span
+func (o *Orchestrator) completeWith(ctx context.Context, provider Provider, req *CompletionRequest) (*CompletionResponse, error) {
+	ctx, span := startProviderSpan(ctx, "llm.complete", provider.Name(), req)
+	defer span.End()
+
+	start := time.Now()
+	resp, err := provider.Complete(ctx, withResponseFormat(provider, req))
+	o.router.observe(provider.Name(), time.Since(start), err)
+	recordSpanError(span, err)
+	if resp != nil {
+		resp.FinishReason = normalizeFinishReason(resp.FinishReason)
+	}
+	if err == nil {
+		o.router.SetHealthy(provider.Name(), true)
+	}
+	if resp != nil {
+		span.SetAttributes(
+			attribute.String("llm.response_model", resp.Model),
+			attribute.Int("llm.usage.prompt_tokens", resp.Usage.PromptTokens),
+			attribute.Int("llm.usage.completion_tokens", resp.Usage.CompletionTokens),
+			attribute.String("llm.finish_reason", resp.FinishReason),
+		)
+	}
+	return resp, err
+}
+
+// Default priority: Gemini > OpenAI > Anthropic > Llama
+var defaultPriority = []string{"gemini", "openai", "anthropic", "llama"}
+
 // Router determines which provider to use for a request
 type Router struct {
 	providers map[string]Provider
+	models    map[string][]string
+	mu        sync.RWMutex
+	unhealthy map[string]bool
+	weights   map[string]int
+	stats     map[string]*providerStats
 }
 
 // NewRouter creates a new router
 func NewRouter(providers map[string]Provider) *Router {
-	return &Router{providers: providers}
+	return &Router{
+		providers: providers,
+		models:    make(map[string][]string),
+		unhealthy: make(map[string]bool),
+		weights:   make(map[string]int),
+		stats:     make(map[string]*providerStats),
+	}
 }
 
 // Route selects a provider based on request characteristics
@@ -312,31 +554,100 @@ func (r *Router) Route(req *CompletionRequest) string {
 	// - Model capabilities
 	// - Load balancing
 
-	// Check for specific model requests
+	// Check for specific model requests, balancing between providers
+	// that serve the same model
 	if req.Model != "" {
-		for name := range r.providers {
-			if matchesProvider(req.Model, name) {
-				return name
-			}
+		if name := r.selectProvider(req.Model); name != "" {
+			return name
 		}
 	}
 
-	// Default priority: Gemini > OpenAI > Anthropic > Llama
-	priority := []string{"gemini", "openai", "anthropic", "llama"}
-	for _, name := range priority {
-		if _, ok := r.providers[name]; ok {
+	// Otherwise fall back to a provider not restricted to other models
+	for _, name := range defaultPriority {
+		if _, ok := r.providers[name]; ok && r.allows(name, req.Model) {
 			return name
 		}
 	}
 
 	// Return first available
+	var names []string
 	for name := range r.providers {
-		return name
+		if r.allows(name, req.Model) {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return names[0]
 	}
 
 	return ""
 }
 
+// unavailable explains why no provider could be routed req
+func (r *Router) unavailable(req *CompletionRequest) error {
+	if req.Model != "" && len(r.providers) > 0 {
+		return fmt.Errorf("%w: %s", ErrModelNotAllowed, req.Model)
+	}
+	return fmt.Errorf("no provider available")
+}
+
+// Fallback returns the order to try providers in for req: the routed
+// provider first, then the other configured providers by priority, with
+// custom providers last in name order. Providers whose models list
+// excludes the requested model are left out.
+func (r *Router) Fallback(req *CompletionRequest) *FallbackChain {
+	first := r.Route(req)
+	if first == "" {
+		return NewFallbackChain(nil)
+	}
+
+	chain := []string{first}
+	seen := map[string]bool{first: true}
+	for _, name := range defaultPriority {
+		if _, ok := r.providers[name]; ok && !seen[name] && r.allows(name, req.Model) {
+			chain = append(chain, name)
+			seen[name] = true
+		}
+	}
+
+	var custom []string
+	for name := range r.providers {
+		if !seen[name] && r.allows(name, req.Model) {
+			custom = append(custom, name)
+		}
+	}
+	sort.Strings(custom)
+	chain = append(chain, custom...)
+
+	// Providers that failed their last health check go last
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ordered := make([]string, 0, len(chain))
+	for _, name := range chain {
+		if !r.unhealthy[name] {
+			ordered = append(ordered, name)
+		}
+	}
+	for _, name := range chain {
+		if r.unhealthy[name] {
+			ordered = append(ordered, name)
+		}
+	}
+	return NewFallbackChain(ordered)
+}
+
+// SetHealthy records the health of a provider for fallback ordering
+func (r *Router) SetHealthy(name string, healthy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if healthy {
+		delete(r.unhealthy, name)
+	} else {
+		r.unhealthy[name] = true
+	}
+}
+
 func matchesProvider(model, provider string) bool {
 	switch provider {
 	case "gemini":
@@ -432,14 +743,19 @@ func (c *Cache) cleanup() {
 // GeminiProvider implements the Gemini API
 type GeminiProvider struct {
 	apiKey string
+	model  string
 	client *http.Client
+	stream *http.Client
 }
 
 // NewGeminiProvider creates a new Gemini provider
 func NewGeminiProvider(cfg *GeminiConfig) (*GeminiProvider, error) {
+	client, stream := cfg.Timeouts.httpClients(DefaultRequestTimeout, nil)
 	return &GeminiProvider{
 		apiKey: cfg.APIKey,
-		client: &http.Client{Timeout: 60 * time.Second},
+		model:  defaultModel(cfg.Models, "gemini-2.0-flash"),
+		client: client,
+		stream: stream,
 	}, nil
 }
 
@@ -449,38 +765,50 @@ func (p *GeminiProvider) Complete(ctx context.Context, req *CompletionRequest) (
 	// Implementation uses Gemini API
 	// https://ai.google.dev/docs
 	return &CompletionResponse{
-		Provider: "gemini",
-		Model:    "gemini-2.0-flash",
-		Content:  "Gemini response placeholder",
+		Provider:     "gemini",
+		Model:        "gemini-2.0-flash",
+		Content:      "Gemini response placeholder",
+		FinishReason: FinishReasonStop,
 	}, nil
 }
 
 func (p *GeminiProvider) Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error) {
-	ch := make(chan StreamChunk)
-	go func() {
-		defer close(ch)
-		ch <- StreamChunk{Content: "Gemini streaming placeholder", Done: true}
-	}()
-	return ch, nil
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	url := "https://generativelanguage.googleapis.com/v1beta/models/" + model + ":streamGenerateContent?alt=sse"
+	return streamEvents(ctx, p.stream, url, map[string]string{"x-goog-api-key": p.apiKey},
+		geminiStreamBody(req), decodeGeminiEvents)
 }
 
 func (p *GeminiProvider) Embed(ctx context.Context, text string) ([]float64, error) {
 	return make([]float64, 768), nil
 }
 
+func (p *GeminiProvider) HealthCheck(ctx context.Context) error {
+	return probe(ctx, p.client, "https://generativelanguage.googleapis.com/v1beta/models?pageSize=1",
+		map[string]string{"x-goog-api-key": p.apiKey})
+}
+
 // OpenAIProvider implements the OpenAI API
 type OpenAIProvider struct {
 	apiKey string
 	org    string
+	model  string
 	client *http.Client
+	stream *http.Client
 }
 
 // NewOpenAIProvider creates a new OpenAI provider
 func NewOpenAIProvider(cfg *OpenAIConfig) (*OpenAIProvider, error) {
+	client, stream := cfg.Timeouts.httpClients(DefaultRequestTimeout, nil)
 	return &OpenAIProvider{
 		apiKey: cfg.APIKey,
 		org:    cfg.Organization,
-		client: &http.Client{Timeout: 60 * time.Second},
+		model:  defaultModel(cfg.Models, "gpt-4"),
+		client: client,
+		stream: stream,
 	}, nil
 }
 
@@ -488,36 +816,50 @@ func (p *OpenAIProvider) Name() string { return "openai" }
 
 func (p *OpenAIProvider) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 	return &CompletionResponse{
-		Provider: "openai",
-		Model:    "gpt-4",
-		Content:  "OpenAI response placeholder",
+		Provider:     "openai",
+		Model:        "gpt-4",
+		Content:      "OpenAI response placeholder",
+		FinishReason: FinishReasonStop,
 	}, nil
 }
 
 func (p *OpenAIProvider) Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error) {
-	ch := make(chan StreamChunk)
-	go func() {
-		defer close(ch)
-		ch <- StreamChunk{Content: "OpenAI streaming placeholder", Done: true}
-	}()
-	return ch, nil
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	headers := map[string]string{"Authorization": "Bearer " + p.apiKey}
+	if p.org != "" {
+		headers["OpenAI-Organization"] = p.org
+	}
+	return streamOpenAI(ctx, p.stream, "https://api.openai.com/v1/chat/completions", headers, model, req)
 }
 
 func (p *OpenAIProvider) Embed(ctx context.Context, text string) ([]float64, error) {
 	return make([]float64, 1536), nil
 }
 
+func (p *OpenAIProvider) HealthCheck(ctx context.Context) error {
+	return probe(ctx, p.client, "https://api.openai.com/v1/models",
+		map[string]string{"Authorization": "Bearer " + p.apiKey})
+}
+
 // AnthropicProvider implements the Anthropic Claude API
 type AnthropicProvider struct {
 	apiKey string
+	model  string
 	client *http.Client
+	stream *http.Client
 }
 
 // NewAnthropicProvider creates a new Anthropic provider
 func NewAnthropicProvider(cfg *AnthropicConfig) (*AnthropicProvider, error) {
+	client, stream := cfg.Timeouts.httpClients(DefaultRequestTimeout, nil)
 	return &AnthropicProvider{
 		apiKey: cfg.APIKey,
-		client: &http.Client{Timeout: 60 * time.Second},
+		model:  defaultModel(cfg.Models, "claude-3-sonnet"),
+		client: client,
+		stream: stream,
 	}, nil
 }
 
@@ -525,101 +867,143 @@ func (p *AnthropicProvider) Name() string { return "anthropic" }
 
 func (p *AnthropicProvider) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 	return &CompletionResponse{
-		Provider: "anthropic",
-		Model:    "claude-3-sonnet",
-		Content:  "Claude response placeholder",
+		Provider:     "anthropic",
+		Model:        "claude-3-sonnet",
+		Content:      "Claude response placeholder",
+		FinishReason: FinishReasonStop,
 	}, nil
 }
 
 func (p *AnthropicProvider) Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error) {
-	ch := make(chan StreamChunk)
-	go func() {
-		defer close(ch)
-		ch <- StreamChunk{Content: "Claude streaming placeholder", Done: true}
-	}()
-	return ch, nil
+	return streamEvents(ctx, p.stream, "https://api.anthropic.com/v1/messages",
+		map[string]string{"x-api-key": p.apiKey, "anthropic-version": "2023-06-01"},
+		p.streamRequest(req), decodeAnthropicEvents())
 }
 
 func (p *AnthropicProvider) Embed(ctx context.Context, text string) ([]float64, error) {
 	return nil, fmt.Errorf("anthropic does not support embeddings")
 }
 
-// LlamaProvider implements on-premises Llama via OpenAI-compatible API
+func (p *AnthropicProvider) HealthCheck(ctx context.Context) error {
+	return probe(ctx, p.client, "https://api.anthropic.com/v1/models?limit=1",
+		map[string]string{"x-api-key": p.apiKey, "anthropic-version": "2023-06-01"})
+}
+
+// LlamaProvider implements on-premises Llama, served with the
+// OpenAI-compatible API under /v1
 type LlamaProvider struct {
-	endpoint string
-	apiKey   string
-	client   *http.Client
+	openai *OpenAICompatibleProvider
 }
 
 // NewLlamaProvider creates a new Llama provider
 func NewLlamaProvider(cfg *LlamaConfig) (*LlamaProvider, error) {
-	return &LlamaProvider{
-		endpoint: cfg.Endpoint,
-		apiKey:   cfg.APIKey,
-		client:   &http.Client{Timeout: 120 * time.Second},
-	}, nil
+	client, stream := cfg.Timeouts.httpClients(DefaultLlamaRequestTimeout, nil)
+	model := defaultModel(cfg.Models, "llama-3.1-70b")
+	return &LlamaProvider{openai: &OpenAICompatibleProvider{
+		name:           "llama",
+		endpoint:       strings.TrimSuffix(cfg.Endpoint, "/") + "/v1",
+		apiKey:         cfg.APIKey,
+		model:          model,
+		embeddingModel: cmp.Or(cfg.EmbeddingModel, model),
+		client:         client,
+		stream:         stream,
+	}}, nil
 }
 
 func (p *LlamaProvider) Name() string { return "llama" }
 
 func (p *LlamaProvider) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
-	// Uses OpenAI-compatible API format for local Llama
-	return &CompletionResponse{
-		Provider: "llama",
-		Model:    "llama-3.1-70b",
-		Content:  "Llama response placeholder",
-	}, nil
+	return p.openai.Complete(ctx, req)
 }
 
 func (p *LlamaProvider) Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error) {
-	ch := make(chan StreamChunk)
-	go func() {
-		defer close(ch)
-		ch <- StreamChunk{Content: "Llama streaming placeholder", Done: true}
-	}()
-	return ch, nil
+	return p.openai.Stream(ctx, req)
 }
 
 func (p *LlamaProvider) Embed(ctx context.Context, text string) ([]float64, error) {
-	return make([]float64, 4096), nil
+	return p.openai.Embed(ctx, text)
+}
+
+// EmbedBatch embeds texts in one request
+func (p *LlamaProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	return p.openai.EmbedBatch(ctx, texts)
 }
 
-// OpenAICompatibleProvider implements custom OpenAI-compatible endpoints
+func (p *LlamaProvider) HealthCheck(ctx context.Context) error {
+	return p.openai.HealthCheck(ctx)
+}
+
+// OpenAICompatibleProvider implements custom OpenAI-compatible endpoints.
+// The endpoint is the API's base URL, such as https://host/v1, which
+// /chat/completions, /embeddings and /models are appended to.
 type OpenAICompatibleProvider struct {
-	name     string
-	endpoint string
-	apiKey   string
-	client   *http.Client
+	name           string
+	endpoint       string
+	apiKey         string
+	model          string
+	embeddingModel string
+	client         *http.Client
+	stream         *http.Client
 }
 
 // NewOpenAICompatibleProvider creates a new OpenAI-compatible provider
 func NewOpenAICompatibleProvider(cfg *CustomConfig) (*OpenAICompatibleProvider, error) {
+	if _, err := cfg.Egress.ParseURL(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("%s: endpoint: %w", cfg.Name, err)
+	}
+	client, stream := cfg.Timeouts.httpClients(DefaultRequestTimeout, &cfg.Egress)
+	model := defaultModel(cfg.Models, "")
 	return &OpenAICompatibleProvider{
-		name:     cfg.Name,
-		endpoint: cfg.Endpoint,
-		apiKey:   cfg.APIKey,
-		client:   &http.Client{Timeout: 60 * time.Second},
+		name:           cfg.Name,
+		endpoint:       strings.TrimSuffix(cfg.Endpoint, "/"),
+		apiKey:         cfg.APIKey,
+		model:          model,
+		embeddingModel: cmp.Or(cfg.EmbeddingModel, model),
+		client:         client,
+		stream:         stream,
 	}, nil
 }
 
 func (p *OpenAICompatibleProvider) Name() string { return p.name }
 
 func (p *OpenAICompatibleProvider) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
-	return &CompletionResponse{
-		Provider: p.name,
-		Content:  "Custom provider response placeholder",
-	}, nil
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	resp, err := completeOpenAI(ctx, p.client, p.endpoint+"/chat/completions", bearer(p.apiKey), model, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+	resp.Provider = p.name
+	return resp, nil
 }
 
 func (p *OpenAICompatibleProvider) Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error) {
-	ch := make(chan StreamChunk)
-	go func() {
-		defer close(ch)
-		ch <- StreamChunk{Content: "Custom provider streaming placeholder", Done: true}
-	}()
-	return ch, nil
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	return streamOpenAI(ctx, p.stream, p.endpoint+"/chat/completions", bearer(p.apiKey), model, req)
 }
 
 func (p *OpenAICompatibleProvider) Embed(ctx context.Context, text string) ([]float64, error) {
-	return make([]float64, 1536), nil
+	vectors, err := p.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// EmbedBatch embeds texts in one request
+func (p *OpenAICompatibleProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	vectors, err := embedOpenAI(ctx, p.client, p.endpoint+"/embeddings", bearer(p.apiKey), p.embeddingModel, texts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+	return vectors, nil
+}
+
+func (p *OpenAICompatibleProvider) HealthCheck(ctx context.Context) error {
+	return probe(ctx, p.client, p.endpoint+"/models", bearer(p.apiKey))
 }
@@ -3,10 +3,113 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"go.uber.org/goleak"
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/netguard"
 )
 
+// loopback lets custom providers call test servers
+var loopback = netguard.Policy{Allow: []string{"127.0.0.1"}}
+
+// fakeProvider records calls and fails when err is set
+type fakeProvider struct {
+	name   string
+	err    error
+	calls  int
+	chunks []StreamChunk
+	// dims sizes embeddings when set; they are the text's length otherwise
+	dims int
+	// release blocks Complete until closed when set
+	release chan struct{}
+	mu      sync.Mutex
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	if p.release != nil {
+		<-p.release
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &CompletionResponse{Provider: p.name, Content: p.name + " response"}, nil
+}
+
+func (p *fakeProvider) Stream(ctx context.Context, req *CompletionRequest) (<-chan StreamChunk, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	ch := make(chan StreamChunk, len(p.chunks))
+	for _, chunk := range p.chunks {
+		ch <- chunk
+	}
+	close(ch)
+	return ch, nil
+}
+
+// recordingUsage collects recorded usage
+type recordingUsage struct {
+	mu     sync.Mutex
+	usages []Usage
+}
+
+func (r *recordingUsage) RecordUsage(ctx context.Context, provider, model string, usage Usage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usages = append(r.usages, usage)
+}
+
+func (p *fakeProvider) Embed(ctx context.Context, text string) ([]float64, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.dims > 0 {
+		v := make([]float64, p.dims)
+		for i := range v {
+			v[i] = float64(len(text) + i%7)
+		}
+		return v, nil
+	}
+	return []float64{float64(len(text))}, nil
+}
+
+func (p *fakeProvider) HealthCheck(ctx context.Context) error {
+	return p.err
+}
+
+func newTestOrchestrator(providers ...*fakeProvider) *Orchestrator {
+	o := &Orchestrator{
+		providers: make(map[string]Provider),
+		logger:    zap.NewNop(),
+		cache:     NewCache(100, time.Hour),
+		prompts:   NewPromptRegistry(),
+	}
+	for _, p := range providers {
+		o.providers[p.name] = p
+	}
+	o.router = NewRouter(o.providers)
+	return o
+}
+
 func TestNewOrchestrator(t *testing.T) {
 	cfg := &Config{
 		Gemini: &GeminiConfig{
@@ -66,6 +169,906 @@ func TestFallbackChain(t *testing.T) {
 	}
 }
 
+func TestRouterFallbackOrder(t *testing.T) {
+	router := NewRouter(map[string]Provider{
+		"llama":     &LlamaProvider{},
+		"openai":    &OpenAIProvider{},
+		"anthropic": &AnthropicProvider{},
+		"mirror":    &OpenAICompatibleProvider{name: "mirror"},
+	})
+
+	chain := router.Fallback(&CompletionRequest{Model: "claude-3-sonnet"}).Chain()
+	want := []string{"anthropic", "openai", "llama", "mirror"}
+	if len(chain) != len(want) {
+		t.Fatalf("Expected chain %v, got %v", want, chain)
+	}
+	for i := range want {
+		if chain[i] != want[i] {
+			t.Fatalf("Expected chain %v, got %v", want, chain)
+		}
+	}
+
+	if chain := NewRouter(nil).Fallback(&CompletionRequest{}).Chain(); len(chain) != 0 {
+		t.Errorf("Expected empty chain without providers, got %v", chain)
+	}
+}
+
+func TestCompleteSkipsFailedProvider(t *testing.T) {
+	openai := &fakeProvider{name: "openai", err: errors.New("unavailable")}
+	gemini := &fakeProvider{name: "gemini"}
+	o := newTestOrchestrator(openai, gemini)
+
+	resp, err := o.Complete(context.Background(), &CompletionRequest{
+		Model:    "gpt-4",
+		Messages: []Message{{Role: "user", Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Provider != "gemini" {
+		t.Errorf("Expected fallback to gemini, got %s", resp.Provider)
+	}
+	if openai.calls != 1 {
+		t.Errorf("Failed provider should be called once, got %d", openai.calls)
+	}
+
+	gemini.err = errors.New("unavailable")
+	_, err = o.Complete(context.Background(), &CompletionRequest{
+		Messages: []Message{{Role: "user", Content: "Other"}},
+	})
+	if err == nil || !errors.Is(err, gemini.err) && !errors.Is(err, openai.err) {
+		t.Fatalf("Expected all providers to fail, got %v", err)
+	}
+	if gemini.calls != 2 || openai.calls != 2 {
+		t.Errorf("Each provider should be tried once per request, got gemini=%d openai=%d", gemini.calls, openai.calls)
+	}
+}
+
+func TestProviderTimeouts(t *testing.T) {
+	client, stream := (*Timeouts)(nil).httpClients(DefaultLlamaRequestTimeout, nil)
+	if client.Timeout != DefaultLlamaRequestTimeout {
+		t.Errorf("Expected default request timeout %v, got %v", DefaultLlamaRequestTimeout, client.Timeout)
+	}
+	if stream.Timeout != 0 {
+		t.Errorf("Streaming should have no overall timeout by default, got %v", stream.Timeout)
+	}
+	if rt := stream.Transport.(*http.Transport).ResponseHeaderTimeout; rt != DefaultReadTimeout {
+		t.Errorf("Expected stream read timeout %v, got %v", DefaultReadTimeout, rt)
+	}
+
+	provider, _ := NewOpenAIProvider(&OpenAIConfig{
+		APIKey:   "test-key",
+		Timeouts: &Timeouts{Connect: time.Second, Request: 5 * time.Second, Stream: 10 * time.Minute},
+	})
+	if provider.client.Timeout != 5*time.Second || provider.stream.Timeout != 10*time.Minute {
+		t.Errorf("Configured timeouts not applied: request=%v stream=%v", provider.client.Timeout, provider.stream.Timeout)
+	}
+	if tt := provider.client.Transport.(*http.Transport).TLSHandshakeTimeout; tt != time.Second {
+		t.Errorf("Expected connect timeout on TLS handshake, got %v", tt)
+	}
+}
+
+func TestHealthCheckAll(t *testing.T) {
+	gemini := &fakeProvider{name: "gemini", err: errors.New("unreachable")}
+	openai := &fakeProvider{name: "openai"}
+	o := newTestOrchestrator(gemini, openai)
+
+	results := o.HealthCheckAll(context.Background())
+	if results["gemini"] == nil || results["openai"] != nil {
+		t.Fatalf("Unexpected health results: %v", results)
+	}
+
+	chain := o.router.Fallback(&CompletionRequest{}).Chain()
+	if chain[0] != "openai" || chain[1] != "gemini" {
+		t.Errorf("Unhealthy provider should be tried last, got %v", chain)
+	}
+
+	gemini.err = nil
+	o.HealthCheckAll(context.Background())
+	if chain := o.router.Fallback(&CompletionRequest{}).Chain(); chain[0] != "gemini" {
+		t.Errorf("Recovered provider should regain priority, got %v", chain)
+	}
+}
+
+func TestOrchestratorHealthCheck(t *testing.T) {
+	gemini := &fakeProvider{name: "gemini", err: errors.New("unreachable")}
+	openai := &fakeProvider{name: "openai"}
+	o := newTestOrchestrator(gemini, openai)
+
+	if err := o.HealthCheck(context.Background()); err != nil {
+		t.Errorf("Expected healthy with one provider reachable, got %v", err)
+	}
+	openai.err = errors.New("timeout")
+	if err := o.HealthCheck(context.Background()); err == nil || !strings.Contains(err.Error(), "gemini: unreachable") {
+		t.Errorf("Expected every provider's error, got %v", err)
+	}
+	if err := newTestOrchestrator().HealthCheck(context.Background()); err == nil {
+		t.Error("Expected an error without providers")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer local-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	provider, _ := NewLlamaProvider(&LlamaConfig{Endpoint: srv.URL + "/", APIKey: "local-key"})
+	if err := provider.HealthCheck(context.Background()); err != nil {
+		t.Errorf("Expected healthy Llama endpoint, got %v", err)
+	}
+
+	provider, _ = NewLlamaProvider(&LlamaConfig{Endpoint: srv.URL})
+	if err := provider.HealthCheck(context.Background()); !errors.Is(err, ErrKeyRejected) {
+		t.Errorf("Expected health check to reject a missing API key, got %v", err)
+	}
+}
+
+func TestValidateOnStart(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+	config := func(llamaKey string, exclude bool) *Config {
+		return &Config{
+			Llama:               &LlamaConfig{Endpoint: srv.URL, APIKey: llamaKey},
+			Custom:              []CustomConfig{{Name: "typo", Endpoint: srv.URL + "/v1", APIKey: "goood-key", Egress: loopback}},
+			ValidateOnStart:     true,
+			ExcludeRejectedKeys: exclude,
+		}
+	}
+
+	o, err := NewOrchestrator(config("good-key", false), nil)
+	if err != nil {
+		t.Fatalf("Expected startup with one valid key, got %v", err)
+	}
+	if _, ok := o.providers["typo"]; !ok {
+		t.Error("Expected a rejected key to be kept without ExcludeRejectedKeys")
+	}
+	if chain := o.router.Fallback(&CompletionRequest{}).Chain(); chain[0] != "llama" {
+		t.Errorf("Expected the rejected provider to be tried last, got %v", chain)
+	}
+
+	o, err = NewOrchestrator(config("good-key", true), nil)
+	if err != nil {
+		t.Fatalf("Expected startup with one valid key, got %v", err)
+	}
+	if _, ok := o.providers["typo"]; ok || len(o.providers) != 1 {
+		t.Errorf("Expected only the rejected provider excluded, got %v", o.providers)
+	}
+
+	_, err = NewOrchestrator(config("bad-key", true), nil)
+	if !errors.Is(err, ErrKeyRejected) || !strings.Contains(err.Error(), "llama: ") || !strings.Contains(err.Error(), "typo: ") {
+		t.Errorf("Expected every provider's rejection, got %v", err)
+	}
+	if _, err := NewOrchestrator(&Config{ValidateOnStart: true}, nil); err == nil {
+		t.Error("Expected an error without providers")
+	}
+}
+
+func TestStreamUsage(t *testing.T) {
+	reported := &Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}
+	gemini := &fakeProvider{name: "gemini", chunks: []StreamChunk{
+		{Content: "Hello "},
+		{Content: "there", Done: true, Usage: reported},
+	}}
+	o := newTestOrchestrator(gemini)
+	recorder := &recordingUsage{}
+	o.SetUsageRecorder(recorder)
+
+	req := &CompletionRequest{Messages: []Message{{Role: "user", Content: "Say hello"}}}
+	last := drainStream(t, o, req)
+	if last.Usage == nil || *last.Usage != *reported {
+		t.Errorf("Expected provider usage %+v on final chunk, got %+v", reported, last.Usage)
+	}
+
+	// Without provider usage the total is estimated from the content
+	gemini.chunks = []StreamChunk{{Content: "Hello there, friend"}, {Done: true}}
+	last = drainStream(t, o, req)
+	if last.Usage == nil || last.Usage.CompletionTokens != 5 || last.Usage.PromptTokens != 3 {
+		t.Errorf("Expected estimated usage, got %+v", last.Usage)
+	}
+
+	if len(recorder.usages) != 2 || recorder.usages[0] != *reported {
+		t.Errorf("Expected both streams recorded, got %+v", recorder.usages)
+	}
+}
+
+// tokenQuota is a QuotaEnforcer allowing limit tokens
+type tokenQuota struct {
+	mu          sync.Mutex
+	limit, used int
+}
+
+// tokenQuotaError is a quota refusal
+type tokenQuotaError struct{}
+
+func (tokenQuotaError) Error() string   { return "monthly llm_tokens quota exceeded" }
+func (tokenQuotaError) HTTPStatus() int { return http.StatusPaymentRequired }
+
+func (q *tokenQuota) CheckQuota(ctx context.Context, n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used+n > q.limit {
+		return tokenQuotaError{}
+	}
+	return nil
+}
+
+func (q *tokenQuota) RecordUsage(ctx context.Context, n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used += n
+	return nil
+}
+
+func TestQuota(t *testing.T) {
+	reported := &Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}
+	gemini := &fakeProvider{name: "gemini", chunks: []StreamChunk{{Content: "Hi", Done: true, Usage: reported}}}
+	o := newTestOrchestrator(gemini)
+	quota := &tokenQuota{limit: 40}
+	o.SetQuota(quota)
+
+	// "Say hello" estimates at 3 prompt tokens; "gemini response" at 4
+	req := &CompletionRequest{Messages: []Message{{Role: "user", Content: "Say hello"}}, MaxTokens: 10}
+	if _, err := o.Complete(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if quota.used != 7 {
+		t.Errorf("Expected the completion's 7 tokens recorded, got %d", quota.used)
+	}
+
+	// A cached answer is free
+	if resp, err := o.Complete(context.Background(), req); err != nil || !resp.Cached || quota.used != 7 {
+		t.Errorf("Expected a free cached answer, got %v (used %d)", err, quota.used)
+	}
+
+	drainStream(t, o, req)
+	if quota.used != 22 {
+		t.Errorf("Expected the stream's reported 15 tokens recorded, got %d", quota.used)
+	}
+
+	// 22 used plus 3 prompt and 16 completion tokens would pass 40
+	calls := gemini.calls
+	req = &CompletionRequest{Messages: []Message{{Role: "user", Content: "Say hello again"}}, MaxTokens: 16}
+	if _, err := o.Complete(context.Background(), req); !errors.As(err, &tokenQuotaError{}) {
+		t.Errorf("Expected a quota refusal, got %v", err)
+	}
+	if _, err := o.Stream(context.Background(), req); !errors.As(err, &tokenQuotaError{}) {
+		t.Errorf("Expected a quota refusal of the stream, got %v", err)
+	}
+	if gemini.calls != calls {
+		t.Error("A refused request should not reach the provider")
+	}
+
+	srv := httptest.NewServer(o.HTTPHandler())
+	defer srv.Close()
+	for _, stream := range []bool{false, true} {
+		resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json",
+			strings.NewReader(fmt.Sprintf(`{"stream":%t,"max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`, stream)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPaymentRequired {
+			t.Errorf("stream %t: expected 402 over quota, got %d", stream, resp.StatusCode)
+		}
+	}
+
+	// Embeddings are metered too: "Say hello" is 3 tokens, 64 characters 16
+	for _, tc := range []struct {
+		input  string
+		status int
+		used   int
+	}{
+		{input: `"Say hello"`, status: http.StatusOK, used: 25},
+		{input: fmt.Sprintf(`[%q]`, strings.Repeat("x", 64)), status: http.StatusPaymentRequired, used: 25},
+	} {
+		resp, err := http.Post(srv.URL+"/v1/embeddings", "application/json",
+			strings.NewReader(`{"input":`+tc.input+`}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || quota.used != tc.used {
+			t.Errorf("%s: expected %d with %d tokens used, got %d with %d", tc.input, tc.status, tc.used, resp.StatusCode, quota.used)
+		}
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	gemini := &fakeProvider{name: "gemini", chunks: []StreamChunk{{Content: "Hi "}, {Content: "Ada", Done: true}}}
+	o := newTestOrchestrator(gemini)
+	log := NewMemoryRequestLog(10)
+	o.SetRequestLog(log, RequestLogOptions{Redact: RedactPII})
+
+	req := &CompletionRequest{Messages: []Message{{Role: "user", Content: "Greet ada@example.com"}}, Temperature: 0.2}
+	resp, err := o.Complete(context.Background(), req)
+	if err != nil || resp.RequestID == "" {
+		t.Fatalf("Expected a logged completion, got %+v, %v", resp, err)
+	}
+	entry, err := log.Get(context.Background(), resp.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Request.Messages[0].Content != "Greet [EMAIL]" || entry.Request.Temperature != 0.2 || entry.Provider != "gemini" || !entry.Redacted {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if req.Messages[0].Content != "Greet ada@example.com" {
+		t.Error("Redaction changed the caller's request")
+	}
+
+	// A replay skips the cache and is logged against the original
+	replayed, err := o.Replay(context.Background(), resp.RequestID)
+	if err != nil || replayed.Cached || gemini.calls != 2 {
+		t.Fatalf("Expected a fresh provider call, got %+v, %v after %d calls", replayed, err, gemini.calls)
+	}
+	if entry, _ := log.Get(context.Background(), replayed.RequestID); entry == nil || entry.ReplayOf != resp.RequestID {
+		t.Errorf("Expected the replay logged against %s, got %+v", resp.RequestID, entry)
+	}
+	if _, err := o.Replay(context.Background(), "llmreq_missing"); !errors.Is(err, ErrRequestNotLogged) {
+		t.Errorf("Expected ErrRequestNotLogged, got %v", err)
+	}
+
+	drainStream(t, o, &CompletionRequest{Messages: []Message{{Role: "user", Content: "Say hi"}}})
+	streamed := log.entries[log.order[len(log.order)-1]]
+	if !streamed.Streamed || streamed.Content != "Hi Ada" {
+		t.Errorf("Expected the stream logged, got %+v", streamed)
+	}
+
+	// Without content nothing can be replayed
+	o.SetRequestLog(log, RequestLogOptions{OmitContent: true})
+	resp, _ = o.Complete(context.Background(), &CompletionRequest{Messages: []Message{{Role: "user", Content: "Secret"}}})
+	if entry, _ := log.Get(context.Background(), resp.RequestID); entry.Request.Messages != nil || entry.Content != "" {
+		t.Errorf("Expected content omitted, got %+v", entry)
+	}
+	if _, err := o.Replay(context.Background(), resp.RequestID); err == nil {
+		t.Error("Expected replaying an omitted request to fail")
+	}
+
+	o.SetRequestLog(nil, RequestLogOptions{})
+	if _, err := o.Replay(context.Background(), resp.RequestID); !errors.Is(err, ErrRequestLogDisabled) {
+		t.Errorf("Expected ErrRequestLogDisabled, got %v", err)
+	}
+}
+
+func TestRedactPII(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Mail ada@example.com now", "Mail [EMAIL] now"},
+		{"Call +234 803 123 4567", "Call [PHONE]"},
+		{"Call 08031234567", "Call [PHONE]"},
+		{"Card 4111-1111-1111-1111 expires", "Card [CARD] expires"},
+		{"Order 12345 shipped", "Order 12345 shipped"},
+	}
+	for _, tc := range tests {
+		if got := RedactPII(tc.in); got != tc.want {
+			t.Errorf("RedactPII(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func drainStream(t *testing.T, o *Orchestrator, req *CompletionRequest) StreamChunk {
+	t.Helper()
+	ch, err := o.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	var last StreamChunk
+	for chunk := range ch {
+		last = chunk
+	}
+	if !last.Done {
+		t.Fatal("Stream ended without a terminal chunk")
+	}
+	return last
+}
+
+func TestProviderStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openAIStreamRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/chat/completions" || !body.Stream || body.Model != "mirror-small" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		io.WriteString(w, ": keep-alive\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"length\"}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":2,\"completion_tokens\":1,\"total_tokens\":3}}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	p, _ := NewOpenAICompatibleProvider(&CustomConfig{Name: "mirror", Endpoint: srv.URL, Models: []string{"mirror-small"}, Egress: loopback})
+	ch, err := p.Stream(context.Background(), &CompletionRequest{Messages: []Message{{Role: "user", Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	var content string
+	var last StreamChunk
+	for chunk := range ch {
+		content += chunk.Content
+		last = chunk
+	}
+	if content != "Hello" || !last.Done || last.Error != nil {
+		t.Fatalf("Unexpected stream: content %q, last %+v", content, last)
+	}
+	if last.FinishReason != "length" || last.Usage == nil || last.Usage.TotalTokens != 3 {
+		t.Errorf("Expected finish reason and usage on the terminal chunk, got %+v", last)
+	}
+}
+
+func TestStreamCancellation(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		// Never finish, like a model still generating
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p, _ := NewOpenAICompatibleProvider(&CustomConfig{Name: "mirror", Endpoint: srv.URL, Egress: loopback})
+	defer p.stream.CloseIdleConnections()
+	o := &Orchestrator{providers: map[string]Provider{"mirror": p}, logger: zap.NewNop()}
+	o.router = NewRouter(o.providers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := o.Stream(ctx, &CompletionRequest{Messages: []Message{{Role: "user", Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if chunk := <-ch; chunk.Content != "Hel" {
+		t.Fatalf("Expected the first chunk, got %+v", chunk)
+	}
+
+	cancel()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Stream channel not closed after cancellation")
+		}
+	}
+}
+
+func TestCompleteCoalescesIdenticalRequests(t *testing.T) {
+	gemini := &fakeProvider{name: "gemini", release: make(chan struct{})}
+	o := newTestOrchestrator(gemini)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := o.Complete(context.Background(), &CompletionRequest{
+				Messages: []Message{{Role: "user", Content: "What is SMPP?"}},
+			})
+			if err == nil && resp.Provider != "gemini" {
+				err = errors.New("unexpected provider " + resp.Provider)
+			}
+			errs <- err
+		}()
+	}
+
+	// Let every caller join the in-flight call before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(gemini.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+	}
+	if gemini.calls != 1 {
+		t.Errorf("Expected one provider call for %d identical requests, got %d", n, gemini.calls)
+	}
+}
+
+func TestPromptRegistry(t *testing.T) {
+	o := newTestOrchestrator()
+	err := o.Prompts().Load([]PromptTemplate{{
+		Name:    "greet",
+		Version: "v1",
+		Messages: []Message{
+			{Role: "system", Content: "You write SMS for {{.Brand}}."},
+			{Role: "user", Content: "Greet {{.Name}}."},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	messages, err := o.RenderPrompt("greet", map[string]interface{}{"Brand": "Brivas", "Name": "Ada"})
+	if err != nil {
+		t.Fatalf("RenderPrompt failed: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Content != "You write SMS for Brivas." || messages[1].Content != "Greet Ada." {
+		t.Errorf("Unexpected rendered messages: %+v", messages)
+	}
+
+	if _, err := o.RenderPrompt("greet", map[string]interface{}{"Brand": "Brivas"}); err == nil {
+		t.Error("Expected an error for a missing variable")
+	}
+	if _, err := o.RenderPrompt("unknown", nil); err == nil {
+		t.Error("Expected an error for an unknown prompt")
+	}
+
+	// Versions share traffic by weight
+	o.Prompts().Register(PromptTemplate{Name: "greet", Version: "v2", Weight: 3, Messages: []Message{{Role: "user", Content: "Hi {{.Name}}"}}})
+	counts := map[string]int{}
+	for i := 0; i < 400; i++ {
+		version, _ := o.Prompts().Select("greet")
+		counts[version]++
+	}
+	if counts["v1"] == 0 || counts["v2"] <= counts["v1"] {
+		t.Errorf("Expected v2 to receive most traffic, got %v", counts)
+	}
+
+	// A key always gets the same version, and keys share traffic by weight
+	counts = map[string]int{}
+	for i := 0; i < 400; i++ {
+		key := fmt.Sprintf("BV%d", i)
+		version, _ := o.Prompts().SelectFor("greet", key)
+		if again, _ := o.Prompts().SelectFor("greet", key); again != version {
+			t.Fatalf("Expected %s to keep version %s, got %s", key, version, again)
+		}
+		counts[version]++
+	}
+	if counts["v1"] < 50 || counts["v2"] < 250 {
+		t.Errorf("Expected keys split about 1:3, got %v", counts)
+	}
+	messages, version, err := o.RenderPromptFor("greet", "BV1", map[string]interface{}{"Brand": "Brivas", "Name": "Ada"})
+	if want, _ := o.Prompts().SelectFor("greet", "BV1"); err != nil || version != want || len(messages) == 0 {
+		t.Errorf("Expected BV1's version %s rendered, got %s %v", want, version, err)
+	}
+
+	o.Prompts().Remove("greet")
+	if _, err := o.RenderPrompt("greet", map[string]interface{}{"Name": "Ada"}); err == nil {
+		t.Error("Expected a removed prompt to be unknown")
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	req := &CompletionRequest{
+		Messages:       []Message{{Role: "system", Content: "You score SMS."}, {Role: "user", Content: "Score this"}},
+		ResponseFormat: &ResponseFormat{Type: ResponseFormatJSONObject},
+	}
+
+	if got := withResponseFormat(&OpenAIProvider{}, req); got != req {
+		t.Error("OpenAI supports JSON mode and should receive the request unchanged")
+	}
+
+	got := withResponseFormat(&AnthropicProvider{}, req)
+	if got.ResponseFormat != nil || len(got.Messages) != 2 {
+		t.Fatalf("Expected the format folded into the system prompt, got %+v", got)
+	}
+	if !strings.HasSuffix(got.Messages[0].Content, jsonInstruction) {
+		t.Errorf("Expected a JSON instruction in the system prompt, got %q", got.Messages[0].Content)
+	}
+	if req.Messages[0].Content != "You score SMS." {
+		t.Error("The caller's messages must not be modified")
+	}
+
+	// Llama has JSON mode but no schemas
+	req = &CompletionRequest{
+		Messages: []Message{{Role: "user", Content: "Score this"}},
+		ResponseFormat: &ResponseFormat{
+			Type:   ResponseFormatJSONSchema,
+			Schema: map[string]interface{}{"type": "object"},
+		},
+	}
+	got = withResponseFormat(&LlamaProvider{}, req)
+	if len(got.Messages) != 2 || got.Messages[0].Role != "system" || !strings.Contains(got.Messages[0].Content, `{"type":"object"}`) {
+		t.Errorf("Expected a system message carrying the schema, got %+v", got.Messages)
+	}
+}
+
+func TestOpenAICompatibleHandler(t *testing.T) {
+	gemini := &fakeProvider{name: "gemini", chunks: []StreamChunk{
+		{Content: "Hel"},
+		{Content: "lo", Done: true, Usage: &Usage{PromptTokens: 2, CompletionTokens: 1, TotalTokens: 3}},
+	}}
+	srv := httptest.NewServer(newTestOrchestrator(gemini).HTTPHandler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var completion chatCompletion
+	json.NewDecoder(resp.Body).Decode(&completion)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || completion.Object != "chat.completion" || len(completion.Choices) != 1 {
+		t.Fatalf("Unexpected completion (status %d): %+v", resp.StatusCode, completion)
+	}
+	if completion.Choices[0].Message.Content != "gemini response" || *completion.Choices[0].FinishReason != "stop" {
+		t.Errorf("Unexpected choice: %+v", completion.Choices[0])
+	}
+
+	resp, err = http.Post(srv.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gemini-2.0-flash","stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+	var content strings.Builder
+	var usage *Usage
+	for _, line := range strings.Split(string(body), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", data, err)
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if content.String() != "Hello" || usage == nil || usage.TotalTokens != 3 {
+		t.Errorf("Unexpected stream: content %q usage %+v", content.String(), usage)
+	}
+	if !strings.HasSuffix(string(body), "data: [DONE]\n\n") {
+		t.Error("Stream should end with [DONE]")
+	}
+
+	resp, err = http.Post(srv.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"input":42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid input, got %d", resp.StatusCode)
+	}
+}
+
+func TestWeightedProviderSelection(t *testing.T) {
+	openai := &fakeProvider{name: "openai"}
+	mirror := &fakeProvider{name: "mirror"}
+	o := newTestOrchestrator(openai, mirror)
+	o.router.models["mirror"] = []string{"gpt-4o"}
+
+	route := func(n int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			counts[o.router.Route(&CompletionRequest{Model: "gpt-4o"})]++
+		}
+		return counts
+	}
+
+	if counts := route(400); counts["openai"] == 0 || counts["mirror"] == 0 {
+		t.Errorf("Expected traffic on both providers, got %v", counts)
+	}
+
+	o.SetProviderWeights(map[string]int{"openai": 0})
+	if counts := route(100); counts["openai"] != 0 {
+		t.Errorf("Weight 0 should stop routing to openai, got %v", counts)
+	}
+
+	// A failing provider loses most of its share
+	o.SetProviderWeights(nil)
+	for i := 0; i < 20; i++ {
+		o.router.observe("openai", 10*time.Millisecond, errors.New("unavailable"))
+		o.router.observe("mirror", 10*time.Millisecond, nil)
+	}
+	if counts := route(400); counts["openai"] >= counts["mirror"]/5 {
+		t.Errorf("Expected traffic to shift away from the failing provider, got %v", counts)
+	}
+
+	// Other models keep routing by name
+	if name := o.router.Route(&CompletionRequest{Model: "gpt-4"}); name != "openai" {
+		t.Errorf("Expected gpt-4 on openai, got %s", name)
+	}
+}
+
+func TestModelAllowList(t *testing.T) {
+	openai := &fakeProvider{name: "openai"}
+	gemini := &fakeProvider{name: "gemini"}
+	o := newTestOrchestrator(openai, gemini)
+	o.router.models["openai"] = []string{"gpt-3.5-turbo"}
+	o.router.models["gemini"] = []string{"gemini-2.0-flash"}
+
+	msgs := []Message{{Role: "user", Content: "Hello"}}
+	_, err := o.Complete(context.Background(), &CompletionRequest{Model: "gpt-4-turbo", Messages: msgs})
+	if !errors.Is(err, ErrModelNotAllowed) {
+		t.Fatalf("Expected ErrModelNotAllowed for an unlisted model, got %v", err)
+	}
+	if _, err := o.Stream(context.Background(), &CompletionRequest{Model: "gpt-4-turbo", Messages: msgs}); !errors.Is(err, ErrModelNotAllowed) {
+		t.Errorf("Expected ErrModelNotAllowed when streaming, got %v", err)
+	}
+	if openai.calls+gemini.calls != 0 {
+		t.Errorf("Unlisted model should not reach a provider, got %d calls", openai.calls+gemini.calls)
+	}
+
+	resp, err := o.Complete(context.Background(), &CompletionRequest{Model: "gpt-3.5-turbo", Messages: msgs})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.Provider != "openai" {
+		t.Errorf("Expected openai, got %s", resp.Provider)
+	}
+	if chain := o.router.Fallback(&CompletionRequest{Model: "gpt-3.5-turbo"}).Chain(); len(chain) != 1 {
+		t.Errorf("Fallback should skip providers without the model, got %v", chain)
+	}
+
+	rec := httptest.NewRecorder()
+	o.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4-turbo","messages":[{"role":"user","content":"Hi"}]}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unlisted model, got %d", rec.Code)
+	}
+
+	available := o.AvailableModels()
+	if len(available["openai"]) != 1 || available["openai"][0] != "gpt-3.5-turbo" {
+		t.Errorf("Unexpected available models %v", available)
+	}
+	available["openai"][0] = "changed"
+	if o.router.models["openai"][0] != "gpt-3.5-turbo" {
+		t.Error("AvailableModels should return a copy")
+	}
+}
+
+func TestNormalizeFinishReason(t *testing.T) {
+	tests := map[string]string{
+		"":           FinishReasonStop,
+		"end_turn":   FinishReasonStop,
+		"STOP":       FinishReasonStop,
+		"max_tokens": FinishReasonLength,
+		"MAX_TOKENS": FinishReasonLength,
+		"length":     FinishReasonLength,
+		"SAFETY":     FinishReasonContentFilter,
+		"tool_use":   FinishReasonToolCalls,
+		"other":      "other",
+	}
+	for raw, want := range tests {
+		if got := normalizeFinishReason(raw); got != want {
+			t.Errorf("normalizeFinishReason(%q) = %q, expected %q", raw, got, want)
+		}
+	}
+
+	o := newTestOrchestrator(&fakeProvider{name: "gemini"})
+	resp, err := o.Complete(context.Background(), &CompletionRequest{Messages: []Message{{Role: "user", Content: "Hi"}}})
+	if err != nil || resp.FinishReason != FinishReasonStop {
+		t.Errorf("Expected a normalized finish reason, got %+v (%v)", resp, err)
+	}
+}
+
+func TestEmbedBatch(t *testing.T) {
+	o := newTestOrchestrator(&fakeProvider{name: "openai"}, &fakeProvider{name: "anthropic"})
+
+	texts := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff"}
+	vectors, err := o.EmbedBatch(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	for i, v := range vectors {
+		if len(v) != 1 || int(v[0]) != len(texts[i]) {
+			t.Errorf("Embedding %d out of order: %v", i, v)
+		}
+	}
+
+	if _, err := newTestOrchestrator(&fakeProvider{name: "anthropic"}).EmbedBatch(context.Background(), texts); err == nil {
+		t.Error("Expected an error without an embedding provider")
+	}
+}
+
+func TestEmbeddingDimensions(t *testing.T) {
+	ctx := context.Background()
+	embed := func(provider *fakeProvider, cfg *EmbeddingConfig) ([]float64, error) {
+		o := newTestOrchestrator(provider)
+		o.embeddings = cfg
+		return o.Embed(ctx, "text")
+	}
+
+	// Without normalization another size is an error
+	if _, err := embed(&fakeProvider{name: "gemini", dims: 768}, &EmbeddingConfig{Dimensions: 1536}); !errors.Is(err, ErrEmbeddingDimensions) {
+		t.Errorf("Expected ErrEmbeddingDimensions, got %v", err)
+	}
+
+	// Shorter vectors are padded with zeros
+	v, err := embed(&fakeProvider{name: "gemini", dims: 768}, &EmbeddingConfig{Dimensions: 1536, Normalization: NormalizeTruncate})
+	if err != nil || len(v) != 1536 || v[767] == 0 || v[768] != 0 {
+		t.Errorf("Expected a zero-padded vector, got %d dimensions, %v", len(v), err)
+	}
+
+	// Matryoshka models are truncated to unit length
+	v, err = embed(&fakeProvider{name: "openai", dims: 3072}, &EmbeddingConfig{Dimensions: 1536, Normalization: NormalizeTruncate})
+	if err != nil || len(v) != 1536 {
+		t.Fatalf("Expected a truncated vector, got %d dimensions, %v", len(v), err)
+	}
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	if math.Abs(norm-1) > 1e-9 {
+		t.Errorf("Expected a unit vector, got squared norm %v", norm)
+	}
+
+	// Other models are not, unless listed
+	cfg := &EmbeddingConfig{Dimensions: 1536, Normalization: NormalizeTruncate}
+	long := make([]float64, 4096)
+	for i := range long {
+		long[i] = math.Sin(float64(i))
+	}
+	if _, err := cfg.fit("llama/llama3", long); !errors.Is(err, ErrLossyNormalization) {
+		t.Errorf("Expected ErrLossyNormalization, got %v", err)
+	}
+	cfg.TruncatableModels = []string{"llama3"}
+	if v, err := cfg.fit("llama/llama3", long); err != nil || len(v) != 1536 {
+		t.Errorf("Expected a listed model to truncate, got %d dimensions, %v", len(v), err)
+	}
+
+	// Projection applies to any model, consistently, and roughly keeps
+	// distances
+	cfg = &EmbeddingConfig{Dimensions: 1536, Normalization: NormalizeProject}
+	other := make([]float64, 4096)
+	for i := range other {
+		other[i] = math.Cos(float64(i) * 0.7)
+	}
+	a, err := cfg.fit("llama/llama3", long)
+	if err != nil || len(a) != 1536 {
+		t.Fatalf("Expected a projected vector, got %d dimensions, %v", len(a), err)
+	}
+	again, _ := cfg.fit("llama/llama3", long)
+	if !reflect.DeepEqual(a, again) {
+		t.Error("Expected the same projection of the same vector")
+	}
+	b, _ := cfg.fit("llama/llama3", other)
+	distance := func(x, y []float64) float64 {
+		var d float64
+		for i := range x {
+			d += (x[i] - y[i]) * (x[i] - y[i])
+		}
+		return math.Sqrt(d)
+	}
+	if ratio := distance(a, b) / distance(long, other); ratio < 0.9 || ratio > 1.1 {
+		t.Errorf("Expected projection to keep distances, got ratio %v", ratio)
+	}
+
+	for _, bad := range []*EmbeddingConfig{
+		{Dimensions: 64, Normalization: NormalizeProject},
+		{Dimensions: 1536, Normalization: "average"},
+		{Dimensions: -1},
+	} {
+		if _, err := NewOrchestrator(&Config{Embeddings: bad}, nil); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
 func TestCache(t *testing.T) {
 	cache := NewCache(100, 1*time.Second)
 
@@ -176,15 +1179,121 @@ func TestAnthropicProvider(t *testing.T) {
 }
 
 func TestLlamaProvider(t *testing.T) {
-	provider := &LlamaProvider{
-		endpoint: "http://localhost:8080",
-	}
+	provider := &LlamaProvider{}
 
 	if provider.Name() != "llama" {
 		t.Errorf("Expected name 'llama', got '%s'", provider.Name())
 	}
 }
 
+func TestOpenAICompatibleProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":{"message":"invalid api key"}}`)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/v1") {
+		case "/chat/completions":
+			var body openAIStreamRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Stream || body.Model != "mirror-small" || len(body.Messages) != 1 || body.MaxTokens != 50 ||
+				body.ResponseFormat == nil || body.ResponseFormat.Type != ResponseFormatJSONObject {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, `{"id":"chatcmpl-1","model":"mirror-small-0613","choices":[{"index":0,`+
+				`"message":{"role":"assistant","content":"{\"ok\":true}"},"finish_reason":"length"}],`+
+				`"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}`)
+		case "/embeddings":
+			var body struct {
+				Model string   `json:"model"`
+				Input []string `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Model != "mirror-embed" || len(body.Input) != 2 {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, `{"data":[{"index":1,"embedding":[0.2]},{"index":0,"embedding":[0.1]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p, _ := NewOpenAICompatibleProvider(&CustomConfig{
+		Name: "mirror", Endpoint: srv.URL + "/", APIKey: "secret",
+		Models: []string{"mirror-small"}, EmbeddingModel: "mirror-embed", Egress: loopback,
+	})
+	req := &CompletionRequest{
+		Messages:       []Message{{Role: "user", Content: "Hi"}},
+		MaxTokens:      50,
+		ResponseFormat: &ResponseFormat{Type: ResponseFormatJSONObject},
+	}
+	resp, err := p.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if resp.ID != "chatcmpl-1" || resp.Provider != "mirror" || resp.Model != "mirror-small-0613" ||
+		resp.Content != `{"ok":true}` || resp.FinishReason != "length" || resp.Usage.TotalTokens != 8 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	vectors, err := p.EmbedBatch(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 0.1 || vectors[1][0] != 0.2 {
+		t.Errorf("Expected embeddings in input order, got %v", vectors)
+	}
+
+	p.apiKey = "wrong"
+	if _, err := p.Complete(context.Background(), req); err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("Expected the API's error, got %v", err)
+	}
+
+	// Llama serves the same API under /v1
+	llama, _ := NewLlamaProvider(&LlamaConfig{Endpoint: srv.URL, APIKey: "secret", Models: []string{"mirror-small"}})
+	resp, err = llama.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Llama Complete failed: %v", err)
+	}
+	if resp.Provider != "llama" || resp.Content != `{"ok":true}` {
+		t.Errorf("Unexpected Llama response %+v", resp)
+	}
+}
+
+func TestOpenAICompatibleProviderEgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"choices":[{"message":{"content":"internal"}}]}`)
+	}))
+	defer srv.Close()
+	req := &CompletionRequest{Messages: []Message{{Role: "user", Content: "Hi"}}}
+
+	for _, endpoint := range []string{"http://169.254.169.254/v1", "http://[::1]:8080", "file:///etc/passwd"} {
+		if _, err := NewOpenAICompatibleProvider(&CustomConfig{Name: "evil", Endpoint: endpoint}); err == nil {
+			t.Errorf("Expected endpoint %s to be refused", endpoint)
+		}
+	}
+
+	// Host names are checked on the address dialed
+	local := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	p, err := NewOpenAICompatibleProvider(&CustomConfig{Name: "evil", Endpoint: local})
+	if err != nil {
+		t.Fatalf("Expected a host name to pass until dialed, got %v", err)
+	}
+	if _, err := p.Complete(context.Background(), req); !errors.Is(err, netguard.ErrBlocked) {
+		t.Errorf("Expected a loopback host to be blocked, got %v", err)
+	}
+
+	// Trusted internal endpoints are allowed by name
+	p, _ = NewOpenAICompatibleProvider(&CustomConfig{Name: "internal", Endpoint: local, Egress: netguard.Policy{Allow: []string{"localhost"}}})
+	if resp, err := p.Complete(context.Background(), req); err != nil || resp.Content != "internal" {
+		t.Errorf("Expected an allowed host to be called, got %+v, %v", resp, err)
+	}
+}
+
 func TestCompletionRequest(t *testing.T) {
 	req := &CompletionRequest{
 		Messages: []Message{
@@ -203,6 +1312,134 @@ func TestCompletionRequest(t *testing.T) {
 	}
 }
 
+func TestPricing(t *testing.T) {
+	pricing := DefaultPricing()
+	for _, tc := range []struct {
+		provider, model string
+		prompt          float64
+		ok              bool
+	}{
+		{"openai", "gpt-4o-mini-2024-07-18", 0.15, true},
+		{"openai", "gpt-4o-2024-08-06", 2.50, true},
+		{"openai", "gpt-4", 30.00, true},
+		{"llama", "llama-3.1-70b", 0, true},
+		{"llama", "", 0, true},
+		{"mirror", "mirror-small", 0, false},
+	} {
+		price, ok := pricing.Price(tc.provider, tc.model)
+		if ok != tc.ok || price.PromptPerMillion != tc.prompt {
+			t.Errorf("Price(%s, %s) = %+v, %v; expected prompt rate %v, %v", tc.provider, tc.model, price, ok, tc.prompt, tc.ok)
+		}
+	}
+
+	cost := pricing.Cost("openai", "gpt-4o", Usage{PromptTokens: 1000, CompletionTokens: 500})
+	if cost == nil || cost.Currency != "USD" || math.Abs(cost.Amount-0.0075) > 1e-12 || cost.CompletionPerMillion != 10 {
+		t.Errorf("Unexpected cost %+v", cost)
+	}
+	if total := cost.Add(cost); total == nil || math.Abs(total.Amount-0.015) > 1e-12 {
+		t.Errorf("Unexpected total %+v", total)
+	}
+	if total := cost.Add(&Cost{Currency: "NGN", Amount: 1}); total != nil {
+		t.Errorf("Expected no total across currencies, got %+v", total)
+	}
+
+	o := newTestOrchestrator(&fakeProvider{name: "openai"})
+	o.SetPricing(Pricing{"openai": {Currency: "USD", PromptPerMillion: 1e6, CompletionPerMillion: 2e6}})
+	req := &CompletionRequest{Messages: []Message{{Role: "user", Content: "Hello"}}}
+	resp, err := o.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	want := float64(resp.Usage.PromptTokens) + 2*float64(resp.Usage.CompletionTokens)
+	if resp.Cost == nil || resp.Cost.Amount != want {
+		t.Errorf("Expected cost %v, got %+v", want, resp.Cost)
+	}
+	if resp, _ = o.Complete(context.Background(), req); !resp.Cached || resp.Cost == nil || resp.Cost.Amount != 0 {
+		t.Errorf("Expected a cached answer to cost nothing, got %+v", resp.Cost)
+	}
+
+	o = newTestOrchestrator(&fakeProvider{name: "openai", chunks: []StreamChunk{
+		{Content: "Hi"}, {Done: true, Usage: &Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4}},
+	}})
+	o.SetPricing(Pricing{"openai": {Currency: "USD", PromptPerMillion: 1e6, CompletionPerMillion: 1e6}})
+	if last := drainStream(t, o, req); last.Cost == nil || last.Cost.Amount != 4 {
+		t.Errorf("Expected the terminal chunk to carry the cost, got %+v", last.Cost)
+	}
+}
+
+type deadLetterList struct {
+	mu      sync.Mutex
+	letters []*DeadLetter
+}
+
+func (l *deadLetterList) DeadLetter(ctx context.Context, letter *DeadLetter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.letters = append(l.letters, letter)
+	return nil
+}
+
+func TestRunJob(t *testing.T) {
+	dead := &deadLetterList{}
+	job := &Job{
+		Name:        "test",
+		Retry:       RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond},
+		DeadLetters: dead,
+	}
+	var calls sync.Map
+	summary := RunJob(context.Background(), job, []string{"ok", "flaky", "down", "invalid"}, func(ctx context.Context, item string) (string, error) {
+		n, _ := calls.LoadOrStore(item, new(atomic.Int32))
+		attempt := n.(*atomic.Int32).Add(1)
+		switch {
+		case item == "flaky" && attempt < 2, item == "down":
+			return "", errors.New("provider unavailable")
+		case item == "invalid":
+			return "", Permanent(errors.New("bad input"))
+		}
+		return strings.ToUpper(item), nil
+	})
+
+	if summary.Total != 4 || summary.Succeeded != 2 || summary.Failed != 2 || !strings.HasPrefix(summary.ID, "job_") {
+		t.Fatalf("Unexpected summary %+v", summary)
+	}
+	want := []struct {
+		result   string
+		attempts int
+		dead     bool
+	}{{"OK", 1, false}, {"FLAKY", 2, false}, {"", 3, true}, {"", 1, true}}
+	for i, w := range want {
+		item := summary.Items[i]
+		if item.Index != i || item.Attempts != w.attempts || item.DeadLettered != w.dead {
+			t.Errorf("item %d: unexpected %+v", i, item)
+		}
+		if (item.Result == nil) != (w.result == "") || item.Result != nil && *item.Result != w.result {
+			t.Errorf("item %d: expected result %q, got %v", i, w.result, item.Result)
+		}
+	}
+	if len(dead.letters) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %d", len(dead.letters))
+	}
+	for _, letter := range dead.letters {
+		if letter.JobID != summary.ID || letter.Job != "test" {
+			t.Errorf("Unexpected dead letter %+v", letter)
+		}
+		if letter.Index == 3 && (string(letter.Item) != `"invalid"` || !strings.Contains(letter.Error, "bad input")) {
+			t.Errorf("Unexpected dead letter for the invalid item %+v", letter)
+		}
+	}
+
+	// Items cut short by cancellation are reported but not dead-lettered
+	ctx, cancel := context.WithCancel(context.Background())
+	dead.letters = nil
+	cancelled := RunJob(ctx, job, []int{1, 2}, func(ctx context.Context, item int) (int, error) {
+		cancel()
+		return 0, errors.New("provider unavailable")
+	})
+	if cancelled.Failed != 2 || len(dead.letters) != 0 {
+		t.Errorf("Expected 2 failures and no dead letters, got %+v and %d", cancelled, len(dead.letters))
+	}
+}
+
 // Benchmark tests
 func BenchmarkCache(b *testing.B) {
 	cache := NewCache(1000, 1*time.Hour)
@@ -0,0 +1,106 @@
+package llm
+
+import "strings"
+
+// ModelPrice is what a model charges per million tokens
+type ModelPrice struct {
+	Currency             string  `json:"currency"`
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+}
+
+// Cost is the estimated cost of a response and the rates it was worked out
+// with
+type Cost struct {
+	Currency             string  `json:"currency"`
+	Amount               float64 `json:"amount"`
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+}
+
+// Pricing maps models to their prices. A key matches a model of that name
+// or starting with it, so "gpt-4o" prices "gpt-4o-2024-08-06", and the
+// longest match wins. A provider's name prices its models that match no
+// model key, such as on-premises Llama.
+type Pricing map[string]ModelPrice
+
+// DefaultPricing holds list prices in US dollars. Override or extend it
+// with Config.Pricing as providers change theirs.
+func DefaultPricing() Pricing {
+	return Pricing{
+		"gpt-4o":            {Currency: "USD", PromptPerMillion: 2.50, CompletionPerMillion: 10.00},
+		"gpt-4o-mini":       {Currency: "USD", PromptPerMillion: 0.15, CompletionPerMillion: 0.60},
+		"gpt-4-turbo":       {Currency: "USD", PromptPerMillion: 10.00, CompletionPerMillion: 30.00},
+		"gpt-4":             {Currency: "USD", PromptPerMillion: 30.00, CompletionPerMillion: 60.00},
+		"gpt-3.5-turbo":     {Currency: "USD", PromptPerMillion: 0.50, CompletionPerMillion: 1.50},
+		"claude-3-opus":     {Currency: "USD", PromptPerMillion: 15.00, CompletionPerMillion: 75.00},
+		"claude-3-sonnet":   {Currency: "USD", PromptPerMillion: 3.00, CompletionPerMillion: 15.00},
+		"claude-3-5-sonnet": {Currency: "USD", PromptPerMillion: 3.00, CompletionPerMillion: 15.00},
+		"claude-3-haiku":    {Currency: "USD", PromptPerMillion: 0.25, CompletionPerMillion: 1.25},
+		"claude-3-5-haiku":  {Currency: "USD", PromptPerMillion: 0.80, CompletionPerMillion: 4.00},
+		"gemini-2.0-flash":  {Currency: "USD", PromptPerMillion: 0.10, CompletionPerMillion: 0.40},
+		"gemini-1.5-pro":    {Currency: "USD", PromptPerMillion: 1.25, CompletionPerMillion: 5.00},
+		"gemini-1.5-flash":  {Currency: "USD", PromptPerMillion: 0.075, CompletionPerMillion: 0.30},
+		"llama":             {Currency: "USD"},
+	}
+}
+
+// Price returns the price of provider's model, false when it has none
+func (p Pricing) Price(provider, model string) (ModelPrice, bool) {
+	var best string
+	found := false
+	for key := range p {
+		if strings.HasPrefix(model, key) && (!found || len(key) > len(best)) {
+			best, found = key, true
+		}
+	}
+	if found {
+		return p[best], true
+	}
+	price, ok := p[provider]
+	return price, ok
+}
+
+// Cost estimates the cost of usage of provider's model, nil when the model
+// has no price
+func (p Pricing) Cost(provider, model string, usage Usage) *Cost {
+	price, ok := p.Price(provider, model)
+	if !ok {
+		return nil
+	}
+	return &Cost{
+		Currency: price.Currency,
+		Amount: (float64(usage.PromptTokens)*price.PromptPerMillion +
+			float64(usage.CompletionTokens)*price.CompletionPerMillion) / 1e6,
+		PromptPerMillion:     price.PromptPerMillion,
+		CompletionPerMillion: price.CompletionPerMillion,
+	}
+}
+
+// Add returns the total of c and other, such as a response and its
+// continuation. The rates are c's; costs in different currencies do not add
+// up, so the total is nil.
+func (c *Cost) Add(other *Cost) *Cost {
+	if c == nil || other == nil || c.Currency != other.Currency {
+		return nil
+	}
+	total := *c
+	total.Amount += other.Amount
+	return &total
+}
+
+// SetPricing replaces the prices costs are estimated with
+func (o *Orchestrator) SetPricing(p Pricing) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pricing = p
+}
+
+// EstimateCost estimates the cost of usage of provider's model, nil when
+// the model has no price
+func (o *Orchestrator) EstimateCost(provider, model string, usage Usage) *Cost {
+	o.mu.RLock()
+	p := o.pricing
+	o.mu.RUnlock()
+	return p.Cost(provider, model, usage)
+}
@@ -0,0 +1,196 @@
+package llm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// PromptTemplate is a named, versioned prompt. Message contents are
+// text/template sources rendered with {{.Var}} substitution.
+type PromptTemplate struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Weight is the share of renders this version receives among the
+	// versions of the same name, for A/B testing. Zero counts as one.
+	Weight   int       `json:"weight,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+type compiledPrompt struct {
+	PromptTemplate
+	contents []*template.Template
+}
+
+// PromptRegistry holds prompt templates by name and version
+type PromptRegistry struct {
+	mu      sync.RWMutex
+	prompts map[string][]*compiledPrompt
+}
+
+// NewPromptRegistry creates an empty prompt registry
+func NewPromptRegistry() *PromptRegistry {
+	return &PromptRegistry{prompts: make(map[string][]*compiledPrompt)}
+}
+
+func compilePrompt(t PromptTemplate) (*compiledPrompt, error) {
+	if t.Name == "" || len(t.Messages) == 0 {
+		return nil, fmt.Errorf("prompt template needs a name and messages")
+	}
+	if t.Weight < 0 {
+		return nil, fmt.Errorf("prompt %s: negative weight", t.Name)
+	}
+	c := &compiledPrompt{PromptTemplate: t}
+	for i, msg := range t.Messages {
+		tmpl, err := template.New(fmt.Sprintf("%s/%s/%d", t.Name, t.Version, i)).
+			Option("missingkey=error").
+			Parse(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", t.Name, err)
+		}
+		c.contents = append(c.contents, tmpl)
+	}
+	return c, nil
+}
+
+// Register adds a template, replacing an existing one with the same name
+// and version
+func (r *PromptRegistry) Register(t PromptTemplate) error {
+	c, err := compilePrompt(t)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions := r.prompts[t.Name]
+	for i, v := range versions {
+		if v.Version == t.Version {
+			versions[i] = c
+			return nil
+		}
+	}
+	r.prompts[t.Name] = append(versions, c)
+	return nil
+}
+
+// Load replaces every version of the names in templates, e.g. with a set
+// read from config or the database. Nothing changes if any fails to parse.
+func (r *PromptRegistry) Load(templates []PromptTemplate) error {
+	loaded := make(map[string][]*compiledPrompt)
+	for _, t := range templates {
+		c, err := compilePrompt(t)
+		if err != nil {
+			return err
+		}
+		loaded[t.Name] = append(loaded[t.Name], c)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, versions := range loaded {
+		r.prompts[name] = versions
+	}
+	return nil
+}
+
+// Select picks a version of the named prompt by weight
+func (r *PromptRegistry) Select(name string) (string, error) {
+	return r.SelectFor(name, "")
+}
+
+// SelectFor picks a version of the named prompt by weight, the same one
+// for every call with the same key, such as an account ID, so a caller
+// sees one version throughout an experiment. Changing the versions or
+// their weights moves some keys to another version. An empty key picks at
+// random.
+func (r *PromptRegistry) SelectFor(name, key string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.prompts[name]
+	if len(versions) == 0 {
+		return "", fmt.Errorf("unknown prompt %q", name)
+	}
+
+	total := 0
+	for _, v := range versions {
+		total += max(v.Weight, 1)
+	}
+	var pick int
+	if key == "" {
+		pick = rand.Intn(total)
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		pick = int(h.Sum64() % uint64(total))
+	}
+	for _, v := range versions {
+		pick -= max(v.Weight, 1)
+		if pick < 0 {
+			return v.Version, nil
+		}
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+// Remove drops every version of the named prompt
+func (r *PromptRegistry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.prompts, name)
+}
+
+// Render renders a specific version of the named prompt. A variable
+// missing from data is an error.
+func (r *PromptRegistry) Render(name, version string, data map[string]interface{}) ([]Message, error) {
+	r.mu.RLock()
+	var prompt *compiledPrompt
+	for _, v := range r.prompts[name] {
+		if v.Version == version {
+			prompt = v
+			break
+		}
+	}
+	r.mu.RUnlock()
+	if prompt == nil {
+		return nil, fmt.Errorf("unknown prompt %q version %q", name, version)
+	}
+
+	messages := make([]Message, len(prompt.Messages))
+	for i, msg := range prompt.Messages {
+		var b strings.Builder
+		if err := prompt.contents[i].Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("render prompt %s: %w", name, err)
+		}
+		messages[i] = Message{Role: msg.Role, Content: b.String(), Name: msg.Name}
+	}
+	return messages, nil
+}
+
+// Prompts returns the orchestrator's prompt registry
+func (o *Orchestrator) Prompts() *PromptRegistry {
+	return o.prompts
+}
+
+// RenderPrompt renders the named prompt, choosing among its versions by
+// weight
+func (o *Orchestrator) RenderPrompt(name string, data map[string]interface{}) ([]Message, error) {
+	messages, _, err := o.RenderPromptFor(name, "", data)
+	return messages, err
+}
+
+// RenderPromptFor renders the version of the named prompt SelectFor picks
+// for key, and returns which version that was
+func (o *Orchestrator) RenderPromptFor(name, key string, data map[string]interface{}) ([]Message, string, error) {
+	version, err := o.prompts.SelectFor(name, key)
+	if err != nil {
+		return nil, "", err
+	}
+	messages, err := o.prompts.Render(name, version, data)
+	return messages, version, err
+}
@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RequestLogEntry is a completion request and its outcome, as logged for
+// debugging and replay
+type RequestLogEntry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Request holds the messages, model and parameters sent, with message
+	// content redacted or omitted as the log's options say
+	Request CompletionRequest `json:"request"`
+	// ContentOmitted is set when neither messages nor response content
+	// were logged; such requests cannot be replayed
+	ContentOmitted bool `json:"content_omitted,omitempty"`
+	Redacted       bool `json:"redacted,omitempty"`
+	Streamed       bool `json:"streamed,omitempty"`
+	// ReplayOf is the entry a replayed request was logged from
+	ReplayOf string `json:"replay_of,omitempty"`
+
+	Provider     string `json:"provider,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Content      string `json:"content,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Usage        Usage  `json:"usage"`
+	Latency      int64  `json:"latency_ms"`
+	Cached       bool   `json:"cached,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// RequestLog is a sink for logged requests. Log is called on the request
+// path once the response is complete, so it should be quick; its errors
+// are logged and otherwise ignored.
+type RequestLog interface {
+	Log(ctx context.Context, entry *RequestLogEntry) error
+	// Get returns a logged entry, ErrRequestNotLogged if there is none
+	Get(ctx context.Context, id string) (*RequestLogEntry, error)
+}
+
+// RequestLogOptions control what is logged
+type RequestLogOptions struct {
+	// Redact rewrites message and response content before it is logged,
+	// such as RedactPII; nil logs content verbatim
+	Redact func(string) string
+	// OmitContent logs only the model, parameters, usage and latency
+	OmitContent bool
+}
+
+// RequestLogConfig enables an in-memory request log from configuration,
+// so it can differ per environment; SetRequestLog installs other sinks
+type RequestLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// Size is how many entries are kept, DefaultRequestLogSize if 0
+	Size        int  `json:"size,omitempty"`
+	RedactPII   bool `json:"redact_pii,omitempty"`
+	OmitContent bool `json:"omit_content,omitempty"`
+}
+
+// DefaultRequestLogSize is how many entries an in-memory log keeps
+const DefaultRequestLogSize = 1000
+
+var (
+	// ErrRequestLogDisabled is returned by Replay without a request log
+	ErrRequestLogDisabled = errors.New("request logging is disabled")
+	// ErrRequestNotLogged is returned for an unknown or evicted entry
+	ErrRequestNotLogged = errors.New("request not logged")
+)
+
+type requestLogger struct {
+	sink RequestLog
+	opts RequestLogOptions
+}
+
+// SetRequestLog logs every completion, streamed or not, to sink; nil
+// disables logging
+func (o *Orchestrator) SetRequestLog(sink RequestLog, opts RequestLogOptions) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if sink == nil {
+		o.requests = nil
+		return
+	}
+	o.requests = &requestLogger{sink: sink, opts: opts}
+}
+
+func (o *Orchestrator) requestLogger() *requestLogger {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.requests
+}
+
+// logRequest logs a completion and returns the response with its
+// RequestID set; resp may be shared with the cache, so it is copied
+func (o *Orchestrator) logRequest(ctx context.Context, entry *RequestLogEntry, req *CompletionRequest, resp *CompletionResponse, err error, start time.Time) *CompletionResponse {
+	l := o.requestLogger()
+	if l == nil {
+		return resp
+	}
+
+	entry.ID = newRequestLogID()
+	entry.Time = start.UTC()
+	entry.Request = *req
+	// The caller may reuse its messages
+	entry.Request.Messages = append([]Message(nil), req.Messages...)
+	entry.Latency = time.Since(start).Milliseconds()
+	if resp != nil {
+		entry.Provider, entry.Model, entry.Content = resp.Provider, resp.Model, resp.Content
+		entry.FinishReason, entry.Usage, entry.Cached = resp.FinishReason, resp.Usage, resp.Cached
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.opts.apply(entry)
+
+	if logErr := l.sink.Log(ctx, entry); logErr != nil {
+		o.logger.Warn("failed to log LLM request", zap.String("request_id", entry.ID), zap.Error(logErr))
+		return resp
+	}
+	if resp == nil {
+		return nil
+	}
+	logged := *resp
+	logged.RequestID = entry.ID
+	return &logged
+}
+
+// logStream logs a finished stream; final is nil when the caller went away
+func (o *Orchestrator) logStream(ctx context.Context, req *CompletionRequest, provider string, start time.Time, content string, final *StreamChunk) {
+	if o.requestLogger() == nil {
+		return
+	}
+	resp := &CompletionResponse{Provider: provider, Model: req.Model, Content: content}
+	err := ctx.Err()
+	if final != nil {
+		err = final.Error
+		resp.FinishReason = final.FinishReason
+		if final.Usage != nil {
+			resp.Usage = *final.Usage
+		}
+	}
+	o.logRequest(context.WithoutCancel(ctx), &RequestLogEntry{Streamed: true}, req, resp, err, start)
+}
+
+// apply redacts or drops the entry's content
+func (opts RequestLogOptions) apply(entry *RequestLogEntry) {
+	if opts.OmitContent {
+		entry.Request.Messages = nil
+		entry.Content = ""
+		entry.ContentOmitted = true
+		return
+	}
+	if opts.Redact == nil {
+		return
+	}
+	for i := range entry.Request.Messages {
+		entry.Request.Messages[i].Content = opts.Redact(entry.Request.Messages[i].Content)
+	}
+	entry.Content = opts.Redact(entry.Content)
+	entry.Redacted = true
+}
+
+// Replay sends a logged request again with the current providers and
+// configuration, bypassing the cache, to reproduce a bad answer or check
+// a fix. A redacted request is replayed as redacted. The replay is logged
+// with ReplayOf set.
+func (o *Orchestrator) Replay(ctx context.Context, id string) (*CompletionResponse, error) {
+	l := o.requestLogger()
+	if l == nil {
+		return nil, ErrRequestLogDisabled
+	}
+	entry, err := l.sink.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ContentOmitted {
+		return nil, fmt.Errorf("request %s was logged without its content", id)
+	}
+
+	req := entry.Request
+	req.Stream = false
+	start := time.Now()
+	resp, err := o.complete(ctx, &req, o.getCacheKey(&req))
+	if resp != nil {
+		copied := *resp
+		copied.Latency = time.Since(start).Milliseconds()
+		resp = &copied
+	}
+	return o.logRequest(ctx, &RequestLogEntry{ReplayOf: id}, &req, resp, err, start), err
+}
+
+func newRequestLogID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "llmreq_" + hex.EncodeToString(b)
+}
+
+// MemoryRequestLog keeps the most recent entries in memory
+type MemoryRequestLog struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*RequestLogEntry
+	order   []string
+}
+
+// NewMemoryRequestLog creates a log of the last size entries
+func NewMemoryRequestLog(size int) *MemoryRequestLog {
+	if size <= 0 {
+		size = DefaultRequestLogSize
+	}
+	return &MemoryRequestLog{size: size, entries: make(map[string]*RequestLogEntry)}
+}
+
+// Log stores entry, evicting the oldest beyond the log's size
+func (m *MemoryRequestLog) Log(ctx context.Context, entry *RequestLogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[entry.ID] = entry
+	m.order = append(m.order, entry.ID)
+	for len(m.order) > m.size {
+		delete(m.entries, m.order[0])
+		m.order = m.order[1:]
+	}
+	return nil
+}
+
+// Get returns a stored entry
+func (m *MemoryRequestLog) Get(ctx context.Context, id string) (*RequestLogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRequestNotLogged, id)
+	}
+	return entry, nil
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// Runs of 7 or more digits, optionally with a leading + and single
+	// spaces or dashes between digits
+	digitRunPattern = regexp.MustCompile(`\+?\d(?:[ -]?\d){6,}`)
+)
+
+// RedactPII masks e-mail addresses as [EMAIL], card numbers (13 to 19
+// digits passing the Luhn check) as [CARD] and other runs of 7 or more
+// digits, such as phone numbers, as [PHONE]
+func RedactPII(text string) string {
+	text = emailPattern.ReplaceAllString(text, "[EMAIL]")
+	return digitRunPattern.ReplaceAllStringFunc(text, func(run string) string {
+		digits := make([]byte, 0, len(run))
+		for i := 0; i < len(run); i++ {
+			if run[i] >= '0' && run[i] <= '9' {
+				digits = append(digits, run[i])
+			}
+		}
+		if len(digits) >= 13 && len(digits) <= 19 && run[0] != '+' && luhnValid(digits) {
+			return "[CARD]"
+		}
+		return "[PHONE]"
+	})
+}
+
+func luhnValid(digits []byte) bool {
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
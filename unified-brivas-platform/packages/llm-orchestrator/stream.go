@@ -0,0 +1,387 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxEventSize bounds a single server-sent event line
+const maxEventSize = 1 << 20
+
+// eventDecoder turns the data of one server-sent event into a chunk. It
+// returns ok false for events carrying nothing to forward, and a Done chunk
+// once the stream is complete.
+type eventDecoder func(data []byte) (chunk StreamChunk, ok bool, err error)
+
+// streamEvents posts body to url and forwards the decoded server-sent
+// events. The channel is closed and the response body released when the
+// stream completes, fails or ctx is cancelled, so an abandoned stream does
+// not hold the connection or its goroutine.
+func streamEvents(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}, decode eventDecoder) (<-chan StreamChunk, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, fmt.Errorf("stream returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	ch := make(chan StreamChunk)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		send := func(chunk StreamChunk) bool {
+			select {
+			case ch <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64<<10), maxEventSize)
+		for scanner.Scan() {
+			data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+			if !ok {
+				continue
+			}
+			chunk, ok, err := decode(bytes.TrimSpace(data))
+			if err != nil {
+				send(StreamChunk{Error: err, Done: true})
+				return
+			}
+			if !ok {
+				continue
+			}
+			if !send(chunk) || chunk.Done {
+				return
+			}
+		}
+
+		// The request is bound to ctx, so cancellation surfaces as a read
+		// error nobody is waiting for
+		if ctx.Err() != nil {
+			return
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		send(StreamChunk{Error: err, Done: true})
+	}()
+	return ch, nil
+}
+
+// defaultModel returns the first configured model, or fallback without one
+func defaultModel(models []string, fallback string) string {
+	if len(models) > 0 {
+		return models[0]
+	}
+	return fallback
+}
+
+// ---------- OpenAI chat completions (OpenAI, Llama, custom endpoints) ----------
+
+type openAIStreamRequest struct {
+	Model          string                `json:"model,omitempty"`
+	Messages       []Message             `json:"messages"`
+	Temperature    float64               `json:"temperature,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	TopP           float64               `json:"top_p,omitempty"`
+	Stream         bool                  `json:"stream"`
+	StreamOptions  *openAIStreamOptions  `json:"stream_options,omitempty"`
+	Tools          []Tool                `json:"tools,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// openAIChatBody maps req to a chat completions request
+func openAIChatBody(model string, req *CompletionRequest) openAIStreamRequest {
+	body := openAIStreamRequest{
+		Model:       model,
+		Messages:    req.Messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		TopP:        req.TopP,
+		Tools:       req.Tools,
+	}
+	if f := req.ResponseFormat; f != nil && f.Type != "" && f.Type != ResponseFormatText {
+		body.ResponseFormat = &openAIResponseFormat{Type: f.Type}
+		if f.Type == ResponseFormatJSONSchema {
+			body.ResponseFormat.JSONSchema = &struct {
+				Name   string                 `json:"name"`
+				Schema map[string]interface{} `json:"schema"`
+			}{Name: f.Name, Schema: f.Schema}
+		}
+	}
+	return body
+}
+
+// streamOpenAI streams a chat completion from an OpenAI-compatible
+// endpoint
+func streamOpenAI(ctx context.Context, client *http.Client, url string, headers map[string]string, model string, req *CompletionRequest) (<-chan StreamChunk, error) {
+	body := openAIChatBody(model, req)
+	body.Stream = true
+	body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+
+	var finish string
+	var usage *Usage
+	return streamEvents(ctx, client, url, headers, body, func(data []byte) (StreamChunk, bool, error) {
+		if string(data) == "[DONE]" {
+			return StreamChunk{Done: true, FinishReason: finish, Usage: usage}, true, nil
+		}
+		var event openAIStreamChunk
+		if err := json.Unmarshal(data, &event); err != nil {
+			return StreamChunk{}, false, err
+		}
+		if event.Error != nil {
+			return StreamChunk{}, false, errors.New(event.Error.Message)
+		}
+		if event.Usage != nil {
+			usage = event.Usage
+		}
+		var content string
+		for _, choice := range event.Choices {
+			content += choice.Delta.Content
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+		return StreamChunk{Content: content}, content != "", nil
+	})
+}
+
+// ---------- Anthropic messages ----------
+
+type anthropicStreamRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature,omitempty"`
+	TopP        float64            `json:"top_p,omitempty"`
+	Stream      bool               `json:"stream"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicMaxTokens is sent when the request sets no limit, since the
+// Messages API requires one
+const anthropicMaxTokens = 4096
+
+func (p *AnthropicProvider) streamRequest(req *CompletionRequest) anthropicStreamRequest {
+	body := anthropicStreamRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      true,
+	}
+	if body.Model == "" {
+		body.Model = p.model
+	}
+	if body.MaxTokens == 0 {
+		body.MaxTokens = anthropicMaxTokens
+	}
+	var system []string
+	for _, m := range req.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		role := m.Role
+		if role != "assistant" {
+			role = "user"
+		}
+		body.Messages = append(body.Messages, anthropicMessage{Role: role, Content: m.Content})
+	}
+	body.System = strings.Join(system, "\n\n")
+	return body
+}
+
+func decodeAnthropicEvents() eventDecoder {
+	var finish string
+	var usage Usage
+	return func(data []byte) (StreamChunk, bool, error) {
+		var event anthropicStreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return StreamChunk{}, false, err
+		}
+		switch event.Type {
+		case "message_start":
+			usage.PromptTokens = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			return StreamChunk{Content: event.Delta.Text}, event.Delta.Text != "", nil
+		case "message_delta":
+			finish = event.Delta.StopReason
+			usage.CompletionTokens = event.Usage.OutputTokens
+		case "message_stop":
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			return StreamChunk{Done: true, FinishReason: finish, Usage: &usage}, true, nil
+		case "error":
+			return StreamChunk{}, false, errors.New(event.Error.Message)
+		}
+		return StreamChunk{}, false, nil
+	}
+}
+
+// ---------- Gemini streamGenerateContent ----------
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      float64                `json:"temperature,omitempty"`
+	MaxOutputTokens  int                    `json:"maxOutputTokens,omitempty"`
+	TopP             float64                `json:"topP,omitempty"`
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
+}
+
+type geminiStreamRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiStreamChunk struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func geminiStreamBody(req *CompletionRequest) geminiStreamRequest {
+	var body geminiStreamRequest
+	var system []geminiPart
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system":
+			system = append(system, geminiPart{Text: m.Content})
+		case "assistant":
+			body.Contents = append(body.Contents, geminiContent{Role: "model", Parts: []geminiPart{{Text: m.Content}}})
+		default:
+			body.Contents = append(body.Contents, geminiContent{Role: "user", Parts: []geminiPart{{Text: m.Content}}})
+		}
+	}
+	if len(system) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	cfg := geminiGenerationConfig{Temperature: req.Temperature, MaxOutputTokens: req.MaxTokens, TopP: req.TopP}
+	if f := req.ResponseFormat; f != nil && f.Type != "" && f.Type != ResponseFormatText {
+		cfg.ResponseMimeType = "application/json"
+		if f.Type == ResponseFormatJSONSchema {
+			cfg.ResponseSchema = f.Schema
+		}
+	}
+	body.GenerationConfig = cfg
+	return body
+}
+
+// decodeGeminiEvents forwards text as it arrives. Gemini has no terminal
+// event, so the chunk with a finish reason is also the Done chunk.
+func decodeGeminiEvents(data []byte) (StreamChunk, bool, error) {
+	var event geminiStreamChunk
+	if err := json.Unmarshal(data, &event); err != nil {
+		return StreamChunk{}, false, err
+	}
+	if event.Error != nil {
+		return StreamChunk{}, false, errors.New(event.Error.Message)
+	}
+
+	var chunk StreamChunk
+	for _, candidate := range event.Candidates {
+		for _, part := range candidate.Content.Parts {
+			chunk.Content += part.Text
+		}
+		if candidate.FinishReason != "" {
+			chunk.Done, chunk.FinishReason = true, candidate.FinishReason
+		}
+	}
+	if u := event.UsageMetadata; chunk.Done && u != nil {
+		chunk.Usage = &Usage{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount,
+			TotalTokens:      u.TotalTokenCount,
+		}
+	}
+	return chunk, chunk.Content != "" || chunk.Done, nil
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/brivas/unified-platform/packages/netguard"
+)
+
+// Default provider timeouts
+const (
+	// DefaultConnectTimeout bounds dialing and the TLS handshake
+	DefaultConnectTimeout = 10 * time.Second
+	// DefaultReadTimeout bounds the wait for the first response headers of
+	// a streaming call
+	DefaultReadTimeout = 30 * time.Second
+	// DefaultRequestTimeout bounds a whole non-streaming call
+	DefaultRequestTimeout = 60 * time.Second
+	// DefaultLlamaRequestTimeout is longer since on-premises models are
+	// slower than the managed APIs
+	DefaultLlamaRequestTimeout = 120 * time.Second
+)
+
+// Timeouts configures a provider's HTTP clients. Zero fields use the
+// defaults above. Streaming calls have no overall timeout unless Stream is
+// set; like every call they end when the request context is cancelled.
+type Timeouts struct {
+	Connect time.Duration `json:"connect,omitempty"`
+	Read    time.Duration `json:"read,omitempty"`
+	Request time.Duration `json:"request,omitempty"`
+	Stream  time.Duration `json:"stream,omitempty"`
+}
+
+// httpClients returns the client for regular calls and the one for
+// streaming calls. defaultRequest is the provider's request timeout default.
+// Clients for endpoints tenants may supply connect only as egress allows.
+func (t *Timeouts) httpClients(defaultRequest time.Duration, egress *netguard.Policy) (*http.Client, *http.Client) {
+	var cfg Timeouts
+	if t != nil {
+		cfg = *t
+	}
+	if cfg.Connect == 0 {
+		cfg.Connect = DefaultConnectTimeout
+	}
+	if cfg.Read == 0 {
+		cfg.Read = DefaultReadTimeout
+	}
+	if cfg.Request == 0 {
+		cfg.Request = defaultRequest
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Connect, KeepAlive: 30 * time.Second}
+	var transport *http.Transport
+	if egress != nil {
+		transport = egress.Transport(dialer)
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dialer.DialContext
+	}
+	transport.TLSHandshakeTimeout = cfg.Connect
+
+	// Non-streaming responses only send headers once generation finishes,
+	// so the read timeout applies to streams alone
+	streamTransport := transport.Clone()
+	streamTransport.ResponseHeaderTimeout = cfg.Read
+
+	return &http.Client{Transport: transport, Timeout: cfg.Request},
+		&http.Client{Transport: streamTransport, Timeout: cfg.Stream}
+}
@@ -0,0 +1,38 @@
+package llm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/brivas/unified-platform/packages/llm-orchestrator")
+
+// startProviderSpan opens a span for a single provider call. Prompt and
+// completion text are never recorded, only sizes and routing metadata.
+func startProviderSpan(ctx context.Context, name, provider string, req *CompletionRequest) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("llm.provider", provider)}
+	if req != nil {
+		attrs = append(attrs,
+			attribute.String("llm.model", req.Model),
+			attribute.Int("llm.messages", len(req.Messages)),
+			attribute.Int("llm.max_tokens", req.MaxTokens),
+		)
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func recordSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, "provider call failed")
+}
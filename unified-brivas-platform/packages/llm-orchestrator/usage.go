@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// UsageRecorder receives token usage of every completed request, streamed
+// or not, for billing and quota accounting
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, provider, model string, usage Usage)
+}
+
+// SetUsageRecorder enables usage recording; nil disables it
+func (o *Orchestrator) SetUsageRecorder(r UsageRecorder) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.usage = r
+}
+
+func (o *Orchestrator) recordUsage(ctx context.Context, provider, model string, usage Usage) {
+	o.mu.RLock()
+	r := o.usage
+	o.mu.RUnlock()
+	if r != nil {
+		r.RecordUsage(ctx, provider, model, usage)
+	}
+	if q := o.quotaEnforcer(); q != nil {
+		if err := q.RecordUsage(ctx, usage.TotalTokens); err != nil {
+			o.logger.Warn("failed to record token quota usage",
+				zap.String("provider", provider), zap.String("model", model), zap.Error(err))
+		}
+	}
+}
+
+// QuotaEnforcer meters tokens against the caller's plan quota, typically
+// the auth engine's RequestQuota(auth.ResourceLLMTokens). A refusal is an
+// error with an HTTPStatus method.
+type QuotaEnforcer interface {
+	CheckQuota(ctx context.Context, n int) error
+	RecordUsage(ctx context.Context, n int) error
+}
+
+// SetQuota enables plan quotas; nil disables them. A request is refused
+// when its prompt and MaxTokens would go over the quota, and the tokens
+// it actually used are recorded once it completes. Cached answers are
+// free.
+func (o *Orchestrator) SetQuota(q QuotaEnforcer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.quota = q
+}
+
+func (o *Orchestrator) quotaEnforcer() QuotaEnforcer {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.quota
+}
+
+// checkQuota refuses req when its estimated tokens would go over the
+// caller's quota
+func (o *Orchestrator) checkQuota(ctx context.Context, req *CompletionRequest) error {
+	q := o.quotaEnforcer()
+	if q == nil {
+		return nil
+	}
+	return q.CheckQuota(ctx, estimateUsage(req, "").PromptTokens+req.MaxTokens)
+}
+
+// quotaStatus returns the status a quota refusal is answered with
+func quotaStatus(err error) (int, bool) {
+	var refusal interface{ HTTPStatus() int }
+	if errors.As(err, &refusal) {
+		return refusal.HTTPStatus(), true
+	}
+	return 0, false
+}
+
+// estimateTokens approximates a token count at four characters per token,
+// used when a provider does not report usage
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// estimateUsage approximates the usage of a request and its completion
+func estimateUsage(req *CompletionRequest, completion string) Usage {
+	var prompt int
+	for _, msg := range req.Messages {
+		prompt += estimateTokens(msg.Content)
+	}
+	completionTokens := estimateTokens(completion)
+	return Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completionTokens,
+		TotalTokens:      prompt + completionTokens,
+	}
+}
+
+// meterStream forwards a provider stream, making sure the terminal chunk
+// carries usage and recording it. done runs once the stream ends with the
+// content streamed and the terminal chunk, nil if the caller went away.
+func (o *Orchestrator) meterStream(ctx context.Context, provider, model string, req *CompletionRequest, in <-chan StreamChunk, done func(content string, final *StreamChunk)) <-chan StreamChunk {
+	out := make(chan StreamChunk)
+	go func() {
+		defer close(out)
+
+		var content []byte
+		var final *StreamChunk
+		defer func() { done(string(content), final) }()
+
+		for chunk := range in {
+			content = append(content, chunk.Content...)
+			if chunk.Done && chunk.Error == nil {
+				chunk.FinishReason = normalizeFinishReason(chunk.FinishReason)
+				if chunk.Usage == nil {
+					usage := estimateUsage(req, string(content))
+					chunk.Usage = &usage
+				}
+				chunk.Cost = o.EstimateCost(provider, model, *chunk.Usage)
+				o.recordUsage(ctx, provider, model, *chunk.Usage)
+			}
+			if chunk.Done || chunk.Error != nil {
+				terminal := chunk
+				final = &terminal
+			}
+
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				// Drain so the provider goroutine can exit
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -7,9 +7,11 @@ import (
 	"database/sql"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq" // PostgreSQL driver for LumaDB PostgreSQL wire protocol
+	"go.uber.org/zap"
 )
 
 // Client represents a connection to LumaDB using PostgreSQL wire protocol
@@ -17,8 +19,21 @@ type Client struct {
 	db     *sql.DB
 	config *Config
 	mu     sync.RWMutex
+	debug  atomic.Pointer[zap.Logger] // set in debug mode
 }
 
+// Querier is the part of Client that services query through. Depending on
+// it rather than on Client lets tests substitute a double, such as the
+// in-memory client of package lumadbtest.
+type Querier interface {
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Query(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+var _ Querier = (*Client)(nil)
+
 // Config holds LumaDB connection configuration
 type Config struct {
 	Host            string
@@ -56,12 +71,7 @@ func Connect(cfg *Config) (*Client, error) {
 		cfg = DefaultConfig()
 	}
 
-	connStr := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMode,
-	)
-
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", cfg.connString())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open LumaDB connection: %w", err)
 	}
@@ -86,6 +96,19 @@ func Connect(cfg *Config) (*Client, error) {
 	}, nil
 }
 
+// NewFromDB wraps an already opened database handle. Listen is unavailable
+// on such clients since it needs the connection settings.
+func NewFromDB(db *sql.DB) *Client {
+	return &Client{db: db}
+}
+
+func (cfg *Config) connString() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMode,
+	)
+}
+
 // DB returns the underlying *sql.DB for direct SQL operations
 // This enables seamless migration - existing SQL code works unchanged
 func (c *Client) DB() *sql.DB {
@@ -101,17 +124,33 @@ func (c *Client) Close() error {
 
 // Exec executes a query without returning any rows
 func (c *Client) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return c.db.ExecContext(ctx, query, args...)
+	ctx, span := startSpan(ctx, query)
+	defer span.End()
+
+	result, err := c.db.ExecContext(ctx, query, args...)
+	recordError(span, err)
+	return result, err
 }
 
-// Query executes a query that returns rows
-func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	return c.db.QueryContext(ctx, query, args...)
+// Query executes a query that returns rows. The rows must be closed, or
+// read to the end, to release their connection.
+func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, span := startSpan(ctx, query)
+	defer span.End()
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	recordError(span, err)
+	return c.wrapRows(rows, query), err
 }
 
 // QueryRow executes a query that returns at most one row
 func (c *Client) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	return c.db.QueryRowContext(ctx, query, args...)
+	ctx, span := startSpan(ctx, query)
+	defer span.End()
+
+	row := c.db.QueryRowContext(ctx, query, args...)
+	recordError(span, row.Err())
+	return row
 }
 
 // BeginTx starts a transaction
@@ -121,7 +160,13 @@ func (c *Client) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, err
 
 // WithTransaction executes a function within a transaction
 func (c *Client) WithTransaction(ctx context.Context, fn func(*sql.Tx) error) error {
-	tx, err := c.db.BeginTx(ctx, nil)
+	return InTransaction(ctx, c, fn)
+}
+
+// InTransaction executes a function within a transaction of q, committing
+// it when fn succeeds and rolling it back otherwise
+func InTransaction(ctx context.Context, q Querier, fn func(*sql.Tx) error) error {
+	tx, err := q.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
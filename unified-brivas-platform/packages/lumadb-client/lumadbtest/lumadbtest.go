@@ -0,0 +1,163 @@
+// Package lumadbtest provides an in-memory lumadb.Client for tests. Its
+// queries are recorded and answered by a function of the query and its
+// arguments, so handlers can be tested against the SQL they send without a
+// database.
+package lumadbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Responder answers a query. Exec statements are answered too; they
+// affect as many rows as the result has.
+type Responder func(query string, args []driver.NamedValue) (*Rows, error)
+
+// Rows is a query result. It is only read, so one result may answer many
+// queries.
+type Rows struct {
+	Columns []string
+	// Types are the columns' database type names, such as "VARCHAR" or
+	// "TIMESTAMPTZ", which lumadb's typed scanning goes by
+	Types []string
+	Data  [][]driver.Value
+}
+
+// DB records what a client from New was asked to do
+type DB struct {
+	mu        sync.Mutex
+	queries   []string
+	commits   int
+	rollbacks int
+	respond   Responder
+}
+
+// New returns a client whose queries respond answers. A nil respond
+// answers every query with no rows.
+func New(respond Responder) (*lumadb.Client, *DB) {
+	d := &DB{respond: respond}
+	return lumadb.NewFromDB(sql.OpenDB(d)), d
+}
+
+// Queries returns the queries and statements run so far, in order
+func (d *DB) Queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+// LastQuery returns the most recent query, or "" before the first
+func (d *DB) LastQuery() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queries) == 0 {
+		return ""
+	}
+	return d.queries[len(d.queries)-1]
+}
+
+// Commits returns how many transactions were committed
+func (d *DB) Commits() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.commits
+}
+
+// Rollbacks returns how many transactions were rolled back
+func (d *DB) Rollbacks() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rollbacks
+}
+
+// Connect implements driver.Connector
+func (d *DB) Connect(context.Context) (driver.Conn, error) { return &conn{db: d}, nil }
+
+// Driver implements driver.Connector
+func (d *DB) Driver() driver.Driver { return nil }
+
+func (d *DB) run(query string, args []driver.NamedValue) (*Rows, error) {
+	d.mu.Lock()
+	d.queries = append(d.queries, query)
+	d.mu.Unlock()
+	if d.respond == nil {
+		return &Rows{}, nil
+	}
+	rows, err := d.respond(query, args)
+	if rows == nil && err == nil {
+		rows = &Rows{}
+	}
+	return rows, err
+}
+
+type conn struct{ db *DB }
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare not supported")
+}
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return tx{db: c.db}, nil }
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{Rows: result}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(result.Data)), nil
+}
+
+type tx struct{ db *DB }
+
+func (t tx) Commit() error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	t.db.commits++
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	t.db.rollbacks++
+	return nil
+}
+
+// rows reads a Rows
+type rows struct {
+	*Rows
+	next int
+}
+
+func (r *rows) Columns() []string { return r.Rows.Columns }
+
+func (r *rows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.Types) {
+		return r.Types[i]
+	}
+	return ""
+}
+
+func (r *rows) Close() error { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.Data) {
+		return io.EOF
+	}
+	copy(dest, r.Data[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,56 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Notify publishes payload on channel. Run outside a transaction the
+// statement commits on its own, so listeners see it only once it succeeds.
+func (c *Client) Notify(ctx context.Context, channel, payload string) error {
+	_, err := c.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// NotifyTx queues a notification inside tx. It is delivered when tx
+// commits and discarded if tx rolls back.
+func (c *Client) NotifyTx(ctx context.Context, tx *sql.Tx, channel, payload string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// Listen calls handle for every notification on channels until ctx is
+// cancelled. It holds a dedicated connection outside the pool and
+// reconnects automatically; notifications sent while disconnected are lost.
+func (c *Client) Listen(ctx context.Context, channels []string, handle func(channel, payload string)) error {
+	if c.config == nil {
+		return fmt.Errorf("listen requires a client created with Connect")
+	}
+
+	listener := pq.NewListener(c.config.connString(), time.Second, time.Minute, nil)
+	defer listener.Close()
+
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case n := <-listener.Notify:
+			// A nil notification signals a reconnect
+			if n != nil {
+				handle(n.Channel, n.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// Pool monitor defaults
+const (
+	// DefaultPoolMonitorInterval is how often the pool statistics are sampled
+	DefaultPoolMonitorInterval = 10 * time.Second
+	// DefaultSaturationThreshold is the share of MaxOpenConns in use from
+	// which the pool is reported as saturated
+	DefaultSaturationThreshold = 0.9
+)
+
+// Pool alert reasons
+const (
+	PoolAlertSaturated = "saturated"
+	PoolAlertWaiting   = "waiting"
+)
+
+var meter = otel.Meter("github.com/brivas/unified-platform/packages/lumadb-client")
+
+// PoolMonitorConfig configures MonitorPool. Auto-tuning of the idle limit
+// is off unless MaxIdleConns is set.
+type PoolMonitorConfig struct {
+	Interval            time.Duration
+	SaturationThreshold float64
+	// WaitThreshold is the time requests may spend waiting for a
+	// connection in one interval before an alert; zero alerts on any wait
+	WaitThreshold time.Duration
+
+	// MinIdleConns and MaxIdleConns bound the idle limit when auto-tuning
+	MinIdleConns int
+	MaxIdleConns int
+
+	Logger  *zap.Logger
+	OnAlert func(PoolAlert)
+}
+
+// PoolAlert reports a sampling interval in which the pool ran out of, or
+// close to, connections. Wait figures cover that interval only.
+type PoolAlert struct {
+	Reason       string        `json:"reason"`
+	Saturation   float64       `json:"saturation"`
+	InUse        int           `json:"in_use"`
+	MaxOpen      int           `json:"max_open"`
+	WaitCount    int64         `json:"wait_count"`
+	WaitDuration time.Duration `json:"wait_duration"`
+}
+
+// PoolSaturation returns the share of MaxOpenConns currently in use, or 0
+// when the pool is unlimited
+func (c *Client) PoolSaturation() float64 {
+	return saturation(c.db.Stats())
+}
+
+func saturation(stats sql.DBStats) float64 {
+	if stats.MaxOpenConnections <= 0 {
+		return 0
+	}
+	return float64(stats.InUse) / float64(stats.MaxOpenConnections)
+}
+
+// MonitorPool samples the pool statistics every interval until ctx is
+// cancelled, logging and recording a metric whenever requests waited for a
+// connection or usage reached the saturation threshold. A climbing wait
+// count with a steady load usually means rows or transactions are not
+// being closed.
+func (c *Client) MonitorPool(ctx context.Context, cfg PoolMonitorConfig) {
+	m := c.newPoolMonitor(cfg)
+
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sample(ctx, c.db.Stats())
+		}
+	}
+}
+
+type poolMonitor struct {
+	cfg     PoolMonitorConfig
+	setIdle func(n int)
+
+	mu            sync.Mutex
+	idle          int // current idle limit when auto-tuning
+	waitCount     int64
+	waitDuration  time.Duration
+	maxIdleClosed int64
+
+	alerts metric.Int64Counter
+}
+
+func (c *Client) newPoolMonitor(cfg PoolMonitorConfig) *poolMonitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultPoolMonitorInterval
+	}
+	if cfg.SaturationThreshold <= 0 {
+		cfg.SaturationThreshold = DefaultSaturationThreshold
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
+	}
+	if cfg.MinIdleConns < 0 {
+		cfg.MinIdleConns = 0
+	}
+	if cfg.MaxIdleConns > 0 && cfg.MaxIdleConns < cfg.MinIdleConns {
+		cfg.MaxIdleConns = cfg.MinIdleConns
+	}
+
+	stats := c.db.Stats()
+	m := &poolMonitor{
+		cfg:           cfg,
+		setIdle:       c.db.SetMaxIdleConns,
+		waitCount:     stats.WaitCount,
+		waitDuration:  stats.WaitDuration,
+		maxIdleClosed: stats.MaxIdleClosed,
+	}
+	if cfg.MaxIdleConns > 0 {
+		m.idle = cfg.MinIdleConns
+		if c.config != nil {
+			m.idle = c.config.MaxIdleConns
+		}
+		m.idle = clampIdle(m.idle, cfg.MinIdleConns, cfg.MaxIdleConns)
+		m.setIdle(m.idle)
+	}
+
+	m.alerts, _ = meter.Int64Counter("lumadb.pool.alerts",
+		metric.WithDescription("Sampling intervals in which the connection pool was saturated or requests waited"))
+	meter.Float64ObservableGauge("lumadb.pool.saturation",
+		metric.WithDescription("Share of the maximum open connections in use"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(saturation(c.db.Stats()))
+			return nil
+		}))
+	return m
+}
+
+// sample compares stats with the previous sample, alerting and adjusting
+// the idle limit as needed
+func (m *poolMonitor) sample(ctx context.Context, stats sql.DBStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	waits := stats.WaitCount - m.waitCount
+	waited := stats.WaitDuration - m.waitDuration
+	idleClosed := stats.MaxIdleClosed - m.maxIdleClosed
+	m.waitCount, m.waitDuration, m.maxIdleClosed = stats.WaitCount, stats.WaitDuration, stats.MaxIdleClosed
+
+	sat := saturation(stats)
+	alert := PoolAlert{
+		Saturation:   sat,
+		InUse:        stats.InUse,
+		MaxOpen:      stats.MaxOpenConnections,
+		WaitCount:    waits,
+		WaitDuration: waited,
+	}
+	switch {
+	case waits > 0 && waited >= m.cfg.WaitThreshold:
+		alert.Reason = PoolAlertWaiting
+	case stats.MaxOpenConnections > 0 && sat >= m.cfg.SaturationThreshold:
+		alert.Reason = PoolAlertSaturated
+	}
+
+	if alert.Reason != "" {
+		m.cfg.Logger.Warn("LumaDB connection pool under pressure",
+			zap.String("reason", alert.Reason),
+			zap.Float64("saturation", sat),
+			zap.Int("in_use", stats.InUse),
+			zap.Int("max_open", stats.MaxOpenConnections),
+			zap.Int64("wait_count", waits),
+			zap.Duration("wait_duration", waited),
+		)
+		if m.alerts != nil {
+			m.alerts.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", alert.Reason)))
+		}
+		if m.cfg.OnAlert != nil {
+			m.cfg.OnAlert(alert)
+		}
+	}
+
+	if m.cfg.MaxIdleConns > 0 {
+		m.tune(stats, alert.Reason != "" || idleClosed > 0)
+	}
+}
+
+// tune grows the idle limit by a quarter while the pool is under pressure
+// or closing connections it will soon reopen, and shrinks it back towards
+// the minimum once fewer than half the idle connections are in use
+func (m *poolMonitor) tune(stats sql.DBStats, pressure bool) {
+	step := m.idle / 4
+	if step < 1 {
+		step = 1
+	}
+
+	idle := m.idle
+	switch {
+	case pressure:
+		idle += step
+	case stats.InUse < m.idle/2:
+		idle -= step
+	}
+	idle = clampIdle(idle, m.cfg.MinIdleConns, m.cfg.MaxIdleConns)
+	if idle == m.idle {
+		return
+	}
+
+	m.cfg.Logger.Info("Adjusted LumaDB idle connection limit",
+		zap.Int("from", m.idle),
+		zap.Int("to", idle),
+	)
+	m.idle = idle
+	m.setIdle(idle)
+}
+
+func clampIdle(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
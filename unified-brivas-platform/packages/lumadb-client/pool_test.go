@@ -0,0 +1,128 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// stubDriver opens connections answering every query with two rows of an
+// INT8 column, or with the typed test rows for typedQuery
+type stubDriver struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error) { return stubConn{}, nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (stubConn) Close() error                        { return nil }
+func (stubConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (stubConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if query == typedQuery {
+		return &stubRows{names: typedColumns, types: typedTypes, row: typedRow, left: int(args[0].(int64))}, nil
+	}
+	return &stubRows{names: []string{"n"}, types: []string{"INT8"}, row: []driver.Value{int64(1)}, left: 2}, nil
+}
+
+// stubRows returns row left times
+type stubRows struct {
+	names, types []string
+	row          []driver.Value
+	left         int
+}
+
+func (r *stubRows) Columns() []string                           { return r.names }
+func (r *stubRows) ColumnTypeDatabaseTypeName(index int) string { return r.types[index] }
+func (*stubRows) Close() error                                  { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.left == 0 {
+		return io.EOF
+	}
+	r.left--
+	copy(dest, r.row)
+	return nil
+}
+
+func init() {
+	sql.Register("lumadb-stub", stubDriver{})
+}
+
+func TestPoolExhaustion(t *testing.T) {
+	db, err := sql.Open("lumadb-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(2)
+	c := NewFromDB(db)
+	defer c.Close()
+
+	var alerts []PoolAlert
+	m := c.newPoolMonitor(PoolMonitorConfig{
+		MinIdleConns: 1,
+		MaxIdleConns: 8,
+		OnAlert:      func(a PoolAlert) { alerts = append(alerts, a) },
+	})
+	ctx := context.Background()
+
+	// A quiet pool raises nothing and keeps the minimum idle limit
+	m.sample(ctx, db.Stats())
+	if len(alerts) != 0 || m.idle != 1 {
+		t.Fatalf("expected no alert and idle limit 1, got %v and %d", alerts, m.idle)
+	}
+
+	// Hold every connection, then wait for a third
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	if got := c.PoolSaturation(); got != 1 {
+		t.Fatalf("expected saturation 1, got %v", got)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.Conn(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the pool to be exhausted, got %v", err)
+	}
+
+	m.sample(ctx, db.Stats())
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %d", len(alerts))
+	}
+	if a := alerts[0]; a.Reason != PoolAlertWaiting || a.WaitCount != 1 || a.InUse != 2 || a.MaxOpen != 2 {
+		t.Errorf("unexpected alert %+v", a)
+	}
+	if m.idle != 2 {
+		t.Errorf("expected the idle limit to grow to 2, got %d", m.idle)
+	}
+
+	// Still saturated but no new waits
+	m.sample(ctx, db.Stats())
+	if len(alerts) != 2 || alerts[1].Reason != PoolAlertSaturated || alerts[1].WaitCount != 0 {
+		t.Errorf("expected a saturation alert, got %+v", alerts)
+	}
+}
+
+func TestPoolSaturationUnlimited(t *testing.T) {
+	db, err := sql.Open("lumadb-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFromDB(db)
+	defer c.Close()
+
+	if _, err := db.Conn(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.PoolSaturation(); got != 0 {
+		t.Errorf("expected 0 for an unlimited pool, got %v", got)
+	}
+}
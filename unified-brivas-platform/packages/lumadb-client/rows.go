@@ -0,0 +1,58 @@
+package lumadb
+
+import (
+	"database/sql"
+	"runtime"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+// Rows is the result of Client.Query. It behaves as *sql.Rows; in debug
+// mode it also remembers where the query was made so that rows garbage
+// collected without being closed or read to the end can be reported.
+type Rows struct {
+	*sql.Rows
+	scan *rowScanner // set by the first ScanMap
+}
+
+// SetDebugLogger turns on debug mode, warning on logger about every Rows
+// left unclosed with the stack that queried it. Capturing the stacks costs
+// an allocation per query, so it is meant for development. A nil logger
+// turns debug mode off for later queries.
+func (c *Client) SetDebugLogger(logger *zap.Logger) {
+	c.debug.Store(logger)
+}
+
+// wrapRows wraps rows, tracking them when debug mode is on
+func (c *Client) wrapRows(rows *sql.Rows, query string) *Rows {
+	if rows == nil {
+		return nil
+	}
+	r := &Rows{Rows: rows}
+	logger := c.debug.Load()
+	if logger == nil {
+		return r
+	}
+
+	op, table := describeQuery(query)
+	stack := debug.Stack()
+	runtime.SetFinalizer(r, func(r *Rows) {
+		// Rows read to the end close themselves, releasing the connection
+		if _, err := r.Rows.Columns(); err != nil {
+			return
+		}
+		logger.Warn("LumaDB rows garbage collected without Close; the connection leaked",
+			zap.String("operation", op),
+			zap.String("table", table),
+			zap.ByteString("stack", stack),
+		)
+	})
+	return r
+}
+
+// Close closes the rows, returning the connection to the pool
+func (r *Rows) Close() error {
+	runtime.SetFinalizer(r, nil)
+	return r.Rows.Close()
+}
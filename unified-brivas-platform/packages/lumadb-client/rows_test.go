@@ -0,0 +1,70 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRowsLeakWarning(t *testing.T) {
+	db, err := sql.Open("lumadb-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFromDB(db)
+	defer c.Close()
+
+	core, logs := observer.New(zap.WarnLevel)
+	c.SetDebugLogger(zap.New(core))
+	ctx := context.Background()
+
+	query := func() {
+		rows, err := c.Query(ctx, "SELECT n FROM sms_history")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Next()
+	}
+
+	// Closed and fully read rows are not reported
+	rows, err := c.Query(ctx, "SELECT n FROM sms_history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	rows, err = c.Query(ctx, "SELECT n FROM sms_history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+
+	query()
+	deadline := time.Now().Add(2 * time.Second)
+	for logs.Len() == 0 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 leak warning, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["table"] != "sms_history" {
+		t.Errorf("expected the leaked query's table, got %v", fields["table"])
+	}
+	if stack, _ := fields["stack"].(string); stack == "" {
+		t.Error("expected the query's stack")
+	}
+	if got := db.Stats().InUse; got != 1 {
+		t.Errorf("expected the leaked connection to stay in use, got %d", got)
+	}
+}
@@ -0,0 +1,163 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// QueryTyped executes a query whose rows are read with ScanMap. Unlike
+// Query it resolves the column types up front, so a result that cannot be
+// described fails here rather than on the first row.
+func (c *Client) QueryTyped(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	rows, err := c.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := rows.prepareScan(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return rows, nil
+}
+
+// WrapRows wraps rows obtained elsewhere, such as from a transaction, so
+// they can be read with ScanMap
+func WrapRows(rows *sql.Rows) *Rows {
+	return &Rows{Rows: rows}
+}
+
+// rowScanner holds the scan destinations for a result, reused for every
+// row so that only the returned maps and values are allocated per row
+type rowScanner struct {
+	names  []string
+	dests  []interface{}
+	values []func() interface{}
+}
+
+func (r *Rows) prepareScan() error {
+	if r.scan != nil {
+		return nil
+	}
+	colTypes, err := r.Rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	s := &rowScanner{
+		names:  make([]string, len(colTypes)),
+		dests:  make([]interface{}, len(colTypes)),
+		values: make([]func() interface{}, len(colTypes)),
+	}
+	for i, ct := range colTypes {
+		s.names[i] = ct.Name()
+		s.dests[i], s.values[i] = ColumnScanner(ct.DatabaseTypeName())
+	}
+	r.scan = s
+	return nil
+}
+
+// ScanMap scans the current row into a map keyed by column name, with
+// values typed by ColumnScanner. Call it after Next as with Scan.
+func (r *Rows) ScanMap() (map[string]interface{}, error) {
+	if err := r.prepareScan(); err != nil {
+		return nil, err
+	}
+	if err := r.Rows.Scan(r.scan.dests...); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{}, len(r.scan.names))
+	for i, name := range r.scan.names {
+		m[name] = r.scan.values[i]()
+	}
+	return m, nil
+}
+
+// ColumnScanner returns a scan destination for a database column type and
+// a function returning the scanned value as a plain Go type: int64,
+// float64 (including NUMERIC), bool, time.Time, json.RawMessage for JSON,
+// []byte for BYTEA and string otherwise. NULL is returned as nil.
+func ColumnScanner(dbType string) (sql.Scanner, func() interface{}) {
+	switch strings.ToUpper(dbType) {
+	case "INT2", "INT4", "INT8", "OID", "SMALLINT", "INTEGER", "BIGINT", "SERIAL", "BIGSERIAL":
+		v := &sql.NullInt64{}
+		return v, func() interface{} {
+			if !v.Valid {
+				return nil
+			}
+			return v.Int64
+		}
+	case "FLOAT4", "FLOAT8", "NUMERIC", "DECIMAL", "REAL", "DOUBLE PRECISION", "MONEY":
+		v := &sql.NullFloat64{}
+		return v, func() interface{} {
+			if !v.Valid {
+				return nil
+			}
+			return v.Float64
+		}
+	case "BOOL", "BOOLEAN":
+		v := &sql.NullBool{}
+		return v, func() interface{} {
+			if !v.Valid {
+				return nil
+			}
+			return v.Bool
+		}
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ":
+		v := &sql.NullTime{}
+		return v, func() interface{} {
+			if !v.Valid {
+				return nil
+			}
+			return v.Time
+		}
+	case "JSON", "JSONB":
+		v := &nullBytes{}
+		return v, func() interface{} {
+			if !v.valid {
+				return nil
+			}
+			return json.RawMessage(v.b)
+		}
+	case "BYTEA":
+		v := &nullBytes{}
+		return v, func() interface{} {
+			if !v.valid {
+				return nil
+			}
+			return v.b
+		}
+	default:
+		v := &sql.NullString{}
+		return v, func() interface{} {
+			if !v.Valid {
+				return nil
+			}
+			return v.String
+		}
+	}
+}
+
+// nullBytes scans a nullable byte column. The driver's buffer is only
+// valid until the next row, so Scan copies it into a slice of its own.
+type nullBytes struct {
+	b     []byte
+	valid bool
+}
+
+func (n *nullBytes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		n.b, n.valid = nil, false
+	case []byte:
+		n.b, n.valid = append([]byte(nil), v...), true
+	case string:
+		n.b, n.valid = []byte(v), true
+	default:
+		return fmt.Errorf("cannot scan %T into a byte column", src)
+	}
+	return nil
+}
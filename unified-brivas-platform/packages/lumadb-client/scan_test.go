@@ -0,0 +1,98 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// typedQuery returns its argument's number of typedRow rows
+const typedQuery = "SELECT typed"
+
+var (
+	typedColumns = []string{"id", "amount", "delivered", "sent_at", "meta", "body", "sender", "note"}
+	typedTypes   = []string{"INT8", "NUMERIC", "BOOL", "TIMESTAMPTZ", "JSONB", "BYTEA", "VARCHAR", "TEXT"}
+	typedRow     = []driver.Value{
+		int64(42), []byte("1234.50"), true, time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC),
+		[]byte(`{"a":1}`), []byte{0xde, 0xad}, []byte("BRIVAS"), nil,
+	}
+)
+
+func TestScanMap(t *testing.T) {
+	db, err := sql.Open("lumadb-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFromDB(db)
+	defer c.Close()
+
+	rows, err := c.QueryTyped(context.Background(), typedQuery, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []map[string]interface{}
+	for rows.Next() {
+		m, err := rows.ScanMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(got))
+	}
+
+	m := got[0]
+	if m["id"] != int64(42) || m["amount"] != 1234.5 || m["delivered"] != true || m["sender"] != "BRIVAS" || m["note"] != nil {
+		t.Errorf("unexpected scalar values %#v", m)
+	}
+	if ts, ok := m["sent_at"].(time.Time); !ok || !ts.Equal(time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected sent_at as time.Time, got %#v", m["sent_at"])
+	}
+	if meta, ok := m["meta"].(json.RawMessage); !ok || string(meta) != `{"a":1}` {
+		t.Errorf("expected meta as json.RawMessage, got %#v", m["meta"])
+	}
+	if body, ok := m["body"].([]byte); !ok || len(body) != 2 || body[0] != 0xde {
+		t.Errorf("expected body as []byte, got %#v", m["body"])
+	}
+
+	// The reused destinations must not alias earlier rows' bytes
+	got[1]["meta"].(json.RawMessage)[0] = '['
+	if string(got[0]["meta"].(json.RawMessage)) != `{"a":1}` {
+		t.Error("rows share the scanned JSON buffer")
+	}
+}
+
+func BenchmarkScanMap(b *testing.B) {
+	db, err := sql.Open("lumadb-stub", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := NewFromDB(db)
+	defer c.Close()
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	rows, err := c.QueryTyped(ctx, typedQuery, b.N)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if _, err := rows.ScanMap(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// sessionVarName matches custom setting names, which must be qualified
+// with a prefix such as "app."
+var sessionVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)+$`)
+
+// WithSession executes fn within a transaction that has vars set as local
+// configuration variables, so row-level security policies can read them
+// with current_setting('app.account_id', true). The variables last until
+// the transaction ends and never leak to other users of the connection.
+func (c *Client) WithSession(ctx context.Context, vars map[string]string, fn func(*sql.Tx) error) error {
+	return c.WithTransaction(ctx, func(tx *sql.Tx) error {
+		if err := SetSessionVars(ctx, tx, vars); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// SetSessionVars sets vars on tx for the rest of the transaction, in name
+// order
+func SetSessionVars(ctx context.Context, tx *sql.Tx, vars map[string]string) error {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if !sessionVarName.MatchString(name) {
+			return fmt.Errorf("invalid session variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// set_config is SET LOCAL with bound parameters
+		if _, err := tx.ExecContext(ctx, "SELECT set_config($1, $2, true)", name, vars[name]); err != nil {
+			return fmt.Errorf("failed to set session variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
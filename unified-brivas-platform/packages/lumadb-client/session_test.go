@@ -0,0 +1,105 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// sessionLog records the statements and transaction ends seen by
+// sessionDriver connections
+var sessionLog struct {
+	sync.Mutex
+	entries []string
+}
+
+func logSession(entry string) {
+	sessionLog.Lock()
+	sessionLog.entries = append(sessionLog.entries, entry)
+	sessionLog.Unlock()
+}
+
+type sessionDriver struct{}
+
+func (sessionDriver) Open(string) (driver.Conn, error) { return sessionConn{}, nil }
+
+type sessionConn struct{}
+
+func (sessionConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (sessionConn) Close() error                        { return nil }
+func (sessionConn) Begin() (driver.Tx, error)           { logSession("BEGIN"); return sessionTx{}, nil }
+
+func (sessionConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	logSession(fmt.Sprint(query, args))
+	return driver.RowsAffected(0), nil
+}
+
+type sessionTx struct{}
+
+func (sessionTx) Commit() error   { logSession("COMMIT"); return nil }
+func (sessionTx) Rollback() error { logSession("ROLLBACK"); return nil }
+
+func init() {
+	sql.Register("lumadb-session-stub", sessionDriver{})
+}
+
+func TestWithSession(t *testing.T) {
+	db, err := sql.Open("lumadb-session-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFromDB(db)
+	defer c.Close()
+	ctx := context.Background()
+
+	run := func(vars map[string]string, fn func(*sql.Tx) error) ([]string, error) {
+		sessionLog.Lock()
+		sessionLog.entries = nil
+		sessionLog.Unlock()
+		err := c.WithSession(ctx, vars, fn)
+		sessionLog.Lock()
+		defer sessionLog.Unlock()
+		return sessionLog.entries, err
+	}
+
+	vars := map[string]string{"app.role": "user", "app.account_id": "acct-1"}
+	log, err := run(vars, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM accounts")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"BEGIN",
+		"SELECT set_config($1, $2, true)[app.account_id acct-1]",
+		"SELECT set_config($1, $2, true)[app.role user]",
+		"DELETE FROM accounts[]",
+		"COMMIT",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("statements = %q, want %q", log, want)
+	}
+
+	// An error from fn rolls the session back
+	failure := errors.New("denied")
+	log, err = run(vars, func(*sql.Tx) error { return failure })
+	if !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+	if last := log[len(log)-1]; last != "ROLLBACK" {
+		t.Errorf("last statement = %q, want ROLLBACK", last)
+	}
+
+	// Unqualified names are refused before anything runs in the session
+	called := false
+	_, err = run(map[string]string{"role": "admin"}, func(*sql.Tx) error { called = true; return nil })
+	if err == nil || called {
+		t.Errorf("unqualified variable: err = %v, fn called = %v", err, called)
+	}
+}
@@ -0,0 +1,85 @@
+package lumadb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/brivas/unified-platform/packages/lumadb-client")
+
+// startSpan opens a client span for a query. Only the SQL operation and
+// table are recorded; the statement text may embed tenant data.
+func startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	op, table := describeQuery(query)
+	name := "lumadb." + strings.ToLower(op)
+	if table != "" {
+		name += " " + table
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", op),
+			attribute.String("db.sql.table", table),
+		),
+	)
+}
+
+func recordError(span trace.Span, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, "query failed")
+}
+
+// describeQuery extracts the SQL verb and the primary table a statement
+// targets, e.g. ("SELECT", "sms_history")
+func describeQuery(query string) (op, table string) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	op = strings.ToUpper(fields[0])
+
+	var marker string
+	switch op {
+	case "SELECT", "DELETE":
+		marker = "FROM"
+	case "INSERT":
+		marker = "INTO"
+	case "UPDATE":
+		return op, cleanIdentifier(fieldAt(fields, 1))
+	default:
+		return op, ""
+	}
+
+	for i, field := range fields {
+		if strings.EqualFold(field, marker) {
+			return op, cleanIdentifier(fieldAt(fields, i+1))
+		}
+	}
+	return op, ""
+}
+
+func fieldAt(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+func cleanIdentifier(s string) string {
+	if i := strings.IndexAny(s, "(,;"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.Trim(s, `"`)
+}
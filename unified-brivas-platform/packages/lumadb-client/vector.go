@@ -0,0 +1,169 @@
+package lumadb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Match is a stored vector found by a similarity search. Score is the
+// cosine similarity, 1 meaning identical direction.
+type Match struct {
+	ID       string            `json:"id"`
+	Score    float64           `json:"score"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// VectorStore stores embeddings for similarity search. Search only
+// considers vectors whose metadata contains every key/value in filter,
+// which is how callers scope results to an account.
+type VectorStore interface {
+	Upsert(ctx context.Context, id string, vector []float64, metadata map[string]string) error
+	Search(ctx context.Context, vector []float64, k int, filter map[string]string) ([]Match, error)
+}
+
+// MemoryVectorStore is a VectorStore for tests and small corpora; searches
+// scan every vector
+type MemoryVectorStore struct {
+	mu      sync.RWMutex
+	vectors map[string]memoryVector
+}
+
+type memoryVector struct {
+	vector   []float64
+	metadata map[string]string
+}
+
+// NewMemoryVectorStore creates an empty in-memory vector store
+func NewMemoryVectorStore() *MemoryVectorStore {
+	return &MemoryVectorStore{vectors: make(map[string]memoryVector)}
+}
+
+// Upsert stores or replaces a vector
+func (s *MemoryVectorStore) Upsert(ctx context.Context, id string, vector []float64, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vectors[id] = memoryVector{vector: append([]float64(nil), vector...), metadata: metadata}
+	return nil
+}
+
+// Search returns the k vectors most similar to vector
+func (s *MemoryVectorStore) Search(ctx context.Context, vector []float64, k int, filter map[string]string) ([]Match, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []Match
+	for id, v := range s.vectors {
+		if len(v.vector) != len(vector) || !matchesFilter(v.metadata, filter) {
+			continue
+		}
+		matches = append(matches, Match{ID: id, Score: cosineSimilarity(vector, v.vector), Metadata: v.metadata})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
+
+func matchesFilter(metadata, filter map[string]string) bool {
+	for key, value := range filter {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// PgVectorStore is a VectorStore on the vector_documents table using the
+// pgvector extension
+type PgVectorStore struct {
+	client *Client
+}
+
+// NewPgVectorStore creates a pgvector-backed store
+func NewPgVectorStore(client *Client) *PgVectorStore {
+	return &PgVectorStore{client: client}
+}
+
+// vectorLiteral formats a vector in pgvector's text form, e.g. [1,2.5,3]
+func vectorLiteral(vector []float64) string {
+	parts := make([]string, len(vector))
+	for i, v := range vector {
+		parts[i] = strconv.FormatFloat(v, 'f', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// Upsert stores or replaces a vector
+func (s *PgVectorStore) Upsert(ctx context.Context, id string, vector []float64, metadata map[string]string) error {
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Exec(ctx, `
+		INSERT INTO vector_documents (id, embedding, metadata)
+		VALUES ($1, $2::vector, $3)
+		ON CONFLICT (id) DO UPDATE
+		SET embedding = EXCLUDED.embedding, metadata = EXCLUDED.metadata, updated_at = NOW()
+	`, id, vectorLiteral(vector), meta)
+	if err != nil {
+		return fmt.Errorf("failed to upsert vector: %w", err)
+	}
+	return nil
+}
+
+// Search returns the k vectors nearest to vector by cosine distance
+func (s *PgVectorStore) Search(ctx context.Context, vector []float64, k int, filter map[string]string) ([]Match, error) {
+	if filter == nil {
+		filter = map[string]string{}
+	}
+	meta, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.client.Query(ctx, `
+		SELECT id, metadata, 1 - (embedding <=> $1::vector) AS score
+		FROM vector_documents
+		WHERE metadata @> $2 AND vector_dims(embedding) = $4
+		ORDER BY embedding <=> $1::vector
+		LIMIT $3
+	`, vectorLiteral(vector), meta, k, len(vector))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search vectors: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []Match
+	for rows.Next() {
+		var m Match
+		var metadata []byte
+		if err := rows.Scan(&m.ID, &metadata, &m.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan match: %w", err)
+		}
+		if err := json.Unmarshal(metadata, &m.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata for %s: %w", m.ID, err)
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
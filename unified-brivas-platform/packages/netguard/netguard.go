@@ -0,0 +1,172 @@
+// Package netguard keeps outbound calls to URLs tenants supply, such as
+// webhooks and custom LLM endpoints, off the platform's own network
+// Addresses are checked when a connection is dialed, so a host name cannot
+// pass validation and then resolve to an internal address
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrInvalidURL rejects a URL that is malformed or has a scheme the
+	// policy does not allow
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrBlocked rejects an address that is not public and not allowed
+	ErrBlocked = errors.New("address not allowed")
+)
+
+// sharedAddressSpace is carrier-grade NAT space, internal like the private
+// ranges
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Policy decides which URLs outbound calls may be made to. The zero Policy
+// allows http and https to public addresses only.
+type Policy struct {
+	// RequireHTTPS rejects http URLs
+	RequireHTTPS bool `json:"require_https,omitempty"`
+	// Allow lists trusted internal endpoints that may be called although
+	// they are not public: host names, IP addresses or CIDR networks
+	Allow []string `json:"allow,omitempty"`
+
+	// lookup resolves host names; the system resolver if nil
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ParseURL parses raw and checks its scheme and, when the host is an IP
+// address, that the address is allowed. Host names are checked by CheckURL
+// or when dialed.
+func (p Policy) ParseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %q is not an absolute URL", ErrInvalidURL, raw)
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && !p.RequireHTTPS:
+	case p.RequireHTTPS:
+		return nil, fmt.Errorf("%w: must be an https URL", ErrInvalidURL)
+	default:
+		return nil, fmt.Errorf("%w: must be an http(s) URL", ErrInvalidURL)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%w: must not contain credentials", ErrInvalidURL)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !p.allowedIP(ip) {
+		return nil, fmt.Errorf("%w: %s is not a public address", ErrBlocked, ip)
+	}
+	return u, nil
+}
+
+// CheckURL checks raw as ParseURL does and that its host resolves only to
+// allowed addresses. An error that is neither ErrInvalidURL nor ErrBlocked
+// means the host could not be resolved.
+func (p Policy) CheckURL(ctx context.Context, raw string) error {
+	u, err := p.ParseURL(raw)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil || p.allowedHost(host) {
+		return nil
+	}
+
+	lookup := p.lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !p.allowedIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s, which is not public", ErrBlocked, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// DialContext returns dialer's DialContext refusing to connect to
+// addresses p does not allow
+func (p Policy) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	guarded := *dialer
+	guarded.Control = func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !p.allowedIP(ip) {
+			return fmt.Errorf("%w: %s is not a public address", ErrBlocked, host)
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && p.allowedHost(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// Transport returns a copy of http.DefaultTransport dialing with dialer as
+// p allows. It never uses a proxy, which would connect on its behalf.
+func (p Policy) Transport(dialer *net.Dialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = p.DialContext(dialer)
+	return transport
+}
+
+// Client returns a client with p's Transport that only follows redirects
+// to URLs p allows
+func (p Policy) Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: p.Transport(&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			_, err := p.ParseURL(req.URL.String())
+			return err
+		},
+	}
+}
+
+// allowedHost reports whether host is listed in Allow
+func (p Policy) allowedHost(host string) bool {
+	for _, allowed := range p.Allow {
+		if strings.EqualFold(strings.TrimSuffix(host, "."), strings.TrimSuffix(allowed, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedIP reports whether ip is public, or listed in Allow
+func (p Policy) allowedIP(ip net.IP) bool {
+	if ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip) {
+		// Global unicast excludes loopback, link-local, which includes
+		// cloud metadata endpoints, and unspecified addresses
+		return true
+	}
+	for _, allowed := range p.Allow {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
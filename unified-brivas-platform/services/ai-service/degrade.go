@@ -0,0 +1,113 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+)
+
+// degrade answers a failed completion with fallback, a result worked out
+// without the model, flagged "degraded": true. Endpoints that truly need
+// the model use completionError instead. An answer blocked by the safety
+// filter is never replaced, since the model did respond.
+func (s *Service) degrade(w http.ResponseWriter, r *http.Request, err error, msg string, fallback map[string]interface{}) {
+	if errors.Is(err, errContentFiltered) {
+		s.completionError(w, r, err, msg)
+		return
+	}
+	s.logger.Warn(msg+", serving degraded result", zap.Error(err))
+	fallback["status"] = "success"
+	fallback["degraded"] = true
+	s.jsonResponse(w, fallback, http.StatusOK)
+}
+
+// ticketCategories are tried in order; the first with a keyword in the
+// ticket wins. Keywords are lower case words separated by single spaces.
+var ticketCategories = []struct {
+	category string
+	keywords []string
+}{
+	{"billing", []string{"invoice", "payment", "refund", "charge", "charged", "balance", "credit", "billing", "top up", "topup"}},
+	{"sender_id", []string{"sender id", "senderid", "sender name"}},
+	{"api", []string{"api", "endpoint", "webhook", "token", "401", "403", "integration", "sdk"}},
+	{"campaign", []string{"campaign", "bulk", "schedule", "broadcast"}},
+	{"account", []string{"account", "login", "password", "sign in", "verify", "kyc"}},
+	{"technical", []string{"not delivered", "delivery", "error", "failed", "not working", "down", "slow", "bug"}},
+}
+
+var (
+	urgentKeywords   = []string{"urgent", "asap", "immediately", "outage", "emergency"}
+	highKeywords     = []string{"not working", "failed", "error", "not delivered", "blocked", "charged twice"}
+	negativeKeywords = []string{"angry", "terrible", "worst", "disappointed", "unacceptable", "frustrated", "not working", "failed"}
+	positiveKeywords = []string{"thanks", "thank you", "great", "love", "appreciate", "excellent"}
+)
+
+// categorizeTicketByKeywords is the categorization fallback: keyword
+// matching on the subject and body, in the model's answer format
+func categorizeTicketByKeywords(subject, body string) map[string]interface{} {
+	// Whole words only, so "api" does not match "rapid"
+	text := " " + strings.Join(strings.FieldsFunc(strings.ToLower(subject+" "+body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ") + " "
+	hasAny := func(keywords []string) bool {
+		for _, k := range keywords {
+			if strings.Contains(text, " "+k+" ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	category := "other"
+	for _, c := range ticketCategories {
+		if hasAny(c.keywords) {
+			category = c.category
+			break
+		}
+	}
+	priority := "medium"
+	switch {
+	case hasAny(urgentKeywords):
+		priority = "urgent"
+	case hasAny(highKeywords):
+		priority = "high"
+	}
+	sentiment := "neutral"
+	switch {
+	case hasAny(negativeKeywords):
+		sentiment = "negative"
+	case hasAny(positiveKeywords):
+		sentiment = "positive"
+	}
+
+	return map[string]interface{}{
+		"category":  category,
+		"priority":  priority,
+		"sentiment": sentiment,
+		"suggested_response": fmt.Sprintf("Thank you for contacting Brivas support. We have received your %s request and will get back to you shortly.",
+			strings.ReplaceAll(category, "_", " ")),
+	}
+}
+
+// scheduleFromPeaks is the scheduling fallback: the historical peak hours,
+// best first
+func scheduleFromPeaks(peaks []map[string]interface{}) map[string]interface{} {
+	slots := make([]map[string]interface{}, 0, len(peaks))
+	for _, p := range peaks {
+		slots = append(slots, map[string]interface{}{"hour": p["hour"], "delivered": p["delivered"]})
+	}
+	return map[string]interface{}{"slots": slots, "basis": "historical peak hours"}
+}
+
+// summarizeStats is the summary fallback, the stats in a sentence
+func summarizeStats(stats *UsageStats) string {
+	return fmt.Sprintf("%d messages sent, %d delivered (%.1f%%), %d failed; ₦%.2f spent.",
+		stats.TotalSent, stats.Delivered, stats.DeliveryRate, stats.Failed, stats.Spent)
+}
+
+// supportUnavailableResponse is the support answer fallback
+const supportUnavailableResponse = "Our assistant is unavailable right now. Your question has been noted and a support agent will follow up shortly."
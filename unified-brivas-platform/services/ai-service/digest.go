@@ -0,0 +1,365 @@
+package ai
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+)
+
+// EventDigest is the webhook event digests are delivered as
+const EventDigest = "report.digest"
+
+// Digest schedule defaults
+const (
+	DefaultDigestCron     = "0 8 * * *"
+	DefaultDigestTimezone = "Africa/Lagos"
+
+	digestPollInterval = time.Minute
+	maxDigestDays      = 31
+)
+
+// WebhookSender queues webhook deliveries; the SMS service's
+// WebhookDispatcher implements it
+type WebhookSender interface {
+	Enqueue(ctx context.Context, accountID, url, event string, payload []byte) error
+}
+
+// SetWebhookSender sets how digests are delivered
+func (s *Service) SetWebhookSender(sender WebhookSender) {
+	s.webhooks = sender
+}
+
+// UsageStats summarizes an account's SMS traffic over a period
+type UsageStats struct {
+	TotalSent    int            `json:"total_sent"`
+	Delivered    int            `json:"delivered"`
+	Failed       int            `json:"failed"`
+	DeliveryRate float64        `json:"delivery_rate"`
+	Spent        float64        `json:"spent"`
+	TopFailures  []FailureCount `json:"top_failures"`
+}
+
+// FailureCount is the number of failed messages for a sender and type
+type FailureCount struct {
+	Sender string `json:"sender"`
+	Type   string `json:"type"`
+	Count  int    `json:"count"`
+}
+
+// usageStats gathers the account's SMS stats for the last days days
+func (s *Service) usageStats(ctx context.Context, accountID string, days int) (*UsageStats, error) {
+	var stats UsageStats
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*),
+			   COALESCE(SUM(CASE WHEN status='delivered' THEN 1 ELSE 0 END), 0),
+			   COALESCE(SUM(CASE WHEN status='failed' THEN 1 ELSE 0 END), 0),
+			   COALESCE(SUM(rate_per_sms), 0)
+		FROM sms_history
+		WHERE account_id = $1 AND sent_date >= CURRENT_DATE - $2 * INTERVAL '1 day'
+	`, accountID, days).Scan(&stats.TotalSent, &stats.Delivered, &stats.Failed, &stats.Spent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage stats: %w", err)
+	}
+	if stats.TotalSent > 0 {
+		stats.DeliveryRate = float64(stats.Delivered) / float64(stats.TotalSent) * 100
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT COALESCE(sender, ''), COALESCE(sms_type, ''), COUNT(*) FROM sms_history
+		WHERE account_id = $1 AND status = 'failed' AND sent_date >= CURRENT_DATE - $2 * INTERVAL '1 day'
+		GROUP BY 1, 2
+		ORDER BY 3 DESC
+		LIMIT 5
+	`, accountID, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load top failures: %w", err)
+	}
+	defer rows.Close()
+
+	stats.TopFailures = make([]FailureCount, 0)
+	for rows.Next() {
+		var f FailureCount
+		if err := rows.Scan(&f.Sender, &f.Type, &f.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan failure count: %w", err)
+		}
+		stats.TopFailures = append(stats.TopFailures, f)
+	}
+	return &stats, rows.Err()
+}
+
+// summarize asks the model for a narrative of stats
+func (s *Service) summarize(ctx context.Context, stats *UsageStats, period string) (string, error) {
+	resp, err := s.complete(ctx, PromptSummarize, map[string]interface{}{
+		"TotalSent":    stats.TotalSent,
+		"Delivered":    stats.Delivered,
+		"DeliveryRate": fmt.Sprintf("%.1f", stats.DeliveryRate),
+		"Failed":       stats.Failed,
+		"Spent":        fmt.Sprintf("%.2f", stats.Spent),
+		"Period":       period,
+	}, &llm.CompletionRequest{})
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
+
+// DigestSchedule configures an account's periodic digest
+type DigestSchedule struct {
+	AccountID  string     `json:"account_id"`
+	Enabled    bool       `json:"enabled"`
+	Cron       string     `json:"cron"`
+	Timezone   string     `json:"timezone"`
+	PeriodDays int        `json:"period_days"`
+	WebhookURL string     `json:"webhook_url"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+}
+
+// nextRun fills defaults, validates the schedule and returns when it next
+// runs after now, in UTC
+func (d *DigestSchedule) nextRun(now time.Time) (time.Time, error) {
+	if d.Cron == "" {
+		d.Cron = DefaultDigestCron
+	}
+	if d.Timezone == "" {
+		d.Timezone = DefaultDigestTimezone
+	}
+	if d.PeriodDays == 0 {
+		d.PeriodDays = 1
+	}
+	if d.PeriodDays < 0 || d.PeriodDays > maxDigestDays {
+		return time.Time{}, fmt.Errorf("period_days must be between 1 and %d", maxDigestDays)
+	}
+	if u, err := url.Parse(d.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return time.Time{}, errors.New("webhook_url must be an http(s) URL")
+	}
+
+	schedule, err := parseCron(d.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", d.Timezone)
+	}
+	next := schedule.Next(now.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never runs", d.Cron)
+	}
+	return next.UTC(), nil
+}
+
+// Digest is the report delivered for a digest schedule. Summary is empty
+// when the AI summary could not be generated.
+type Digest struct {
+	AccountID   string      `json:"account_id"`
+	PeriodDays  int         `json:"period_days"`
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	Stats       *UsageStats `json:"stats"`
+	Summary     string      `json:"summary,omitempty"`
+	GeneratedAt time.Time   `json:"generated_at"`
+}
+
+// buildDigest gathers stats and adds an AI summary when the model is
+// available; a failed summary leaves a stats-only digest
+func (s *Service) buildDigest(ctx context.Context, accountID string, days int) (*Digest, error) {
+	stats, err := s.usageStats(ctx, accountID, days)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	digest := &Digest{
+		AccountID:   accountID,
+		PeriodDays:  days,
+		From:        now.AddDate(0, 0, -days),
+		To:          now,
+		Stats:       stats,
+		GeneratedAt: now,
+	}
+
+	if s.llm != nil && stats.TotalSent > 0 {
+		period := fmt.Sprintf("last %d days", days)
+		switch days {
+		case 1:
+			period = "daily"
+		case 7:
+			period = "weekly"
+		}
+		summary, err := s.summarize(ctx, stats, period)
+		if err != nil {
+			s.logger.Warn("digest summary unavailable, sending stats only", zap.String("account_id", accountID), zap.Error(err))
+		}
+		digest.Summary = summary
+	}
+	return digest, nil
+}
+
+// RunDigests sends due digests until ctx is cancelled
+func (s *Service) RunDigests(ctx context.Context) {
+	s.digestBeat.Store(time.Now().UnixNano())
+	defer s.digestBeat.Store(0)
+
+	ticker := time.NewTicker(digestPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendDueDigests(ctx)
+			s.digestBeat.Store(time.Now().UnixNano())
+		}
+	}
+}
+
+func (s *Service) sendDueDigests(ctx context.Context) {
+	if s.webhooks == nil {
+		return
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT account_id, cron, timezone, period_days, webhook_url FROM digest_schedules
+		WHERE enabled = TRUE AND next_run_at <= NOW()
+	`)
+	if err != nil {
+		s.logger.Warn("failed to load due digests", zap.Error(err))
+		return
+	}
+	var due []DigestSchedule
+	for rows.Next() {
+		var d DigestSchedule
+		if err := rows.Scan(&d.AccountID, &d.Cron, &d.Timezone, &d.PeriodDays, &d.WebhookURL); err == nil {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range due {
+		next, err := d.nextRun(time.Now())
+		if err != nil {
+			s.logger.Warn("invalid digest schedule", zap.String("account_id", d.AccountID), zap.Error(err))
+			continue
+		}
+		// Advancing next_run_at claims the run, so one instance sends it
+		res, err := s.db.Exec(ctx, `
+			UPDATE digest_schedules SET next_run_at = $2, last_run_at = NOW()
+			WHERE account_id = $1 AND next_run_at <= NOW()
+		`, d.AccountID, next)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		if err := s.sendDigest(ctx, d); err != nil {
+			s.logger.Warn("digest failed", zap.String("account_id", d.AccountID), zap.Error(err))
+		}
+	}
+}
+
+func (s *Service) sendDigest(ctx context.Context, d DigestSchedule) error {
+	digest, err := s.buildDigest(ctx, d.AccountID, d.PeriodDays)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":      EventDigest,
+		"account_id": d.AccountID,
+		"data":       digest,
+		"created_at": digest.GeneratedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return s.webhooks.Enqueue(ctx, d.AccountID, d.WebhookURL, EventDigest, payload)
+}
+
+// handleGetDigestSchedule returns the account's digest schedule
+func (s *Service) handleGetDigestSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	d := DigestSchedule{AccountID: chi.URLParam(r, "account_id")}
+	var lastRunAt sql.NullTime
+	err := s.db.QueryRow(ctx, `
+		SELECT enabled, cron, timezone, period_days, webhook_url, next_run_at, last_run_at
+		FROM digest_schedules WHERE account_id = $1
+	`, d.AccountID).Scan(&d.Enabled, &d.Cron, &d.Timezone, &d.PeriodDays, &d.WebhookURL, &d.NextRunAt, &lastRunAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "no digest configured", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to load digest schedule", http.StatusInternalServerError)
+		return
+	}
+	if lastRunAt.Valid {
+		d.LastRunAt = &lastRunAt.Time
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"data":   d,
+	}, http.StatusOK)
+}
+
+// handleSetDigestSchedule creates or replaces the account's digest schedule
+func (s *Service) handleSetDigestSchedule(w http.ResponseWriter, r *http.Request) {
+	d := DigestSchedule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	d.AccountID = chi.URLParam(r, "account_id")
+	next, err := d.nextRun(time.Now())
+	if err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.NextRunAt = next
+
+	_, err = s.db.Exec(r.Context(), `
+		INSERT INTO digest_schedules (account_id, enabled, cron, timezone, period_days, webhook_url, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (account_id) DO UPDATE
+		SET enabled = EXCLUDED.enabled, cron = EXCLUDED.cron, timezone = EXCLUDED.timezone,
+			period_days = EXCLUDED.period_days, webhook_url = EXCLUDED.webhook_url,
+			next_run_at = EXCLUDED.next_run_at, updated_at = NOW()
+	`, d.AccountID, d.Enabled, d.Cron, d.Timezone, d.PeriodDays, d.WebhookURL, d.NextRunAt)
+	if err != nil {
+		s.logger.Error("failed to save digest schedule", zap.Error(err))
+		s.jsonError(w, r, "failed to save digest schedule", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"data":   d,
+	}, http.StatusOK)
+}
+
+// handleDeleteDigestSchedule stops the account's digests
+func (s *Service) handleDeleteDigestSchedule(w http.ResponseWriter, r *http.Request) {
+	res, err := s.db.Exec(r.Context(), `DELETE FROM digest_schedules WHERE account_id = $1`, chi.URLParam(r, "account_id"))
+	if err != nil {
+		s.jsonError(w, r, "failed to delete digest schedule", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, r, "no digest configured", http.StatusNotFound)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+}
@@ -0,0 +1,292 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// Embedding limits
+const (
+	// maxEmbedTextLength bounds each text, in characters, below the
+	// providers' input limits
+	maxEmbedTextLength = 8000
+	// maxEmbedBatch bounds the texts of one /embed/batch request
+	maxEmbedBatch = 100
+
+	embedCacheSize = 10000
+	embedCacheTTL  = 24 * time.Hour
+)
+
+// Default per-account embedding allowance, in texts; the burst admits a
+// full batch
+const (
+	defaultEmbedRate  = 20
+	defaultEmbedBurst = maxEmbedBatch
+	// maxEmbedBuckets triggers pruning of idle callers' buckets
+	maxEmbedBuckets = 10000
+)
+
+// EmbedResponse carries the vectors of /embed and /embed/batch with the
+// model that produced them, "provider/model"
+type EmbedResponse struct {
+	Status     string      `json:"status"`
+	Embedding  []float64   `json:"embedding,omitempty"`
+	Embeddings [][]float64 `json:"embeddings,omitempty"`
+	Model      string      `json:"model"`
+	Dimensions int         `json:"dimensions"`
+}
+
+// SetEmbedRateLimit sets how many texts per second each account may embed,
+// with bursts of up to burst texts; a rate of 0 disables the limit. Batches
+// larger than burst are always refused.
+func (s *Service) SetEmbedRateLimit(perSecond float64, burst int) {
+	s.embedLimits = newEmbedLimiter(perSecond, burst)
+}
+
+func (s *Service) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		return validateEmbedText("text", req.Text)
+	}) {
+		return
+	}
+
+	resp, ok := s.serveEmbeddings(w, r, []string{req.Text})
+	if !ok {
+		return
+	}
+	resp.Embedding = resp.Embeddings[0]
+	resp.Embeddings = nil
+	s.jsonResponse(w, resp, http.StatusOK)
+}
+
+func (s *Service) handleEmbedBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Texts []string `json:"texts"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if len(req.Texts) == 0 {
+			return fmt.Errorf("texts is required")
+		}
+		if len(req.Texts) > maxEmbedBatch {
+			return fmt.Errorf("at most %d texts may be embedded at once", maxEmbedBatch)
+		}
+		for i, text := range req.Texts {
+			if err := validateEmbedText(fmt.Sprintf("texts[%d]", i), text); err != nil {
+				return err
+			}
+		}
+		return nil
+	}) {
+		return
+	}
+
+	if resp, ok := s.serveEmbeddings(w, r, req.Texts); ok {
+		s.jsonResponse(w, resp, http.StatusOK)
+	}
+}
+
+// validateEmbedText rejects empty and overlong texts
+func validateEmbedText(field, text string) error {
+	if err := requireFields(field, text); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(text) > maxEmbedTextLength {
+		return fmt.Errorf("%s exceeds %d characters", field, maxEmbedTextLength)
+	}
+	return nil
+}
+
+// serveEmbeddings charges texts to the caller's allowance and embeds them,
+// answering the request itself and returning false on failure
+func (s *Service) serveEmbeddings(w http.ResponseWriter, r *http.Request, texts []string) (*EmbedResponse, bool) {
+	if wait, ok := s.embedLimits.allow(embedCaller(r), len(texts)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		s.jsonError(w, r, "embedding rate limit exceeded", http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	model, err := s.llm.EmbeddingModel()
+	if err != nil {
+		s.logger.Warn("embeddings unavailable", zap.Error(err))
+		s.jsonError(w, r, "embeddings are unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	vectors, err := s.embedTexts(r.Context(), model, texts)
+	if err != nil {
+		s.logger.Warn("embedding failed", zap.String("model", model), zap.Error(err))
+		s.jsonError(w, r, "embedding failed", http.StatusBadGateway)
+		return nil, false
+	}
+
+	return &EmbedResponse{
+		Status:     "success",
+		Embeddings: vectors,
+		Model:      model,
+		Dimensions: len(vectors[0]),
+	}, true
+}
+
+// embedTexts embeds texts with one EmbedBatch call for those not cached
+func (s *Service) embedTexts(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	var missing []string
+	var missingAt []int
+	for i, text := range texts {
+		if vector, ok := s.embedCache.get(model, text); ok {
+			vectors[i] = vector
+			continue
+		}
+		missing = append(missing, text)
+		missingAt = append(missingAt, i)
+	}
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := s.llm.EmbedBatch(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, vector := range embedded {
+		vectors[missingAt[j]] = vector
+		s.embedCache.put(model, missing[j], vector)
+	}
+	return vectors, nil
+}
+
+// embedCaller keys the rate limit by the account the auth middleware
+// resolved, or by client IP for anonymous callers
+func embedCaller(r *http.Request) string {
+	if accountID := r.Header.Get("X-Account-ID"); accountID != "" {
+		return "account:" + accountID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// embedLimiter is a token bucket per caller, refilled at rate texts per
+// second up to burst
+type embedLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*embedBucket
+}
+
+type embedBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newEmbedLimiter(perSecond float64, burst int) *embedLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &embedLimiter{rate: perSecond, burst: float64(burst), buckets: make(map[string]*embedBucket)}
+}
+
+// allow takes n tokens from key's bucket, or reports how long until they
+// are available. A nil limiter allows everything.
+func (l *embedLimiter) allow(key string, n int) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.buckets) >= maxEmbedBuckets {
+		// A refilled bucket is the same as none
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &embedBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	need := float64(n)
+	if b.tokens < need {
+		return time.Duration((need - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens -= need
+	return 0, true
+}
+
+// embeddingCache keeps vectors by model and text, evicting the oldest
+// beyond its size. Embeddings are deterministic, so entries only expire to
+// pick up model updates.
+type embeddingCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]embeddingEntry
+	order   []string
+}
+
+type embeddingEntry struct {
+	vector []float64
+	expiry time.Time
+}
+
+func newEmbeddingCache(size int, ttl time.Duration) *embeddingCache {
+	return &embeddingCache{size: size, ttl: ttl, entries: make(map[string]embeddingEntry)}
+}
+
+func embeddingKey(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *embeddingCache) get(model, text string) ([]float64, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[embeddingKey(model, text)]
+	if !ok || time.Now().After(entry.expiry) {
+		return nil, false
+	}
+	return entry.vector, true
+}
+
+func (c *embeddingCache) put(model, text string, vector []float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := embeddingKey(model, text)
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = embeddingEntry{vector: vector, expiry: time.Now().Add(c.ttl)}
+	for len(c.order) > c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
@@ -0,0 +1,545 @@
+package ai
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+)
+
+// maxExperimentVariants bounds the variants of one experiment
+const maxExperimentVariants = 10
+
+var experimentIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// PromptExperiment splits the traffic of a prompt between variants of its
+// messages, by weight, to find out which performs better. Each account is
+// assigned a variant by a hash of its ID, so it sees the same one
+// throughout; requests without an account get the prompt as usual. A
+// prompt has at most one active experiment.
+type PromptExperiment struct {
+	ID string `json:"id"`
+	// Prompt is the name of the prompt experimented on, such as
+	// PromptSupportResponse
+	Prompt    string              `json:"prompt"`
+	Variants  []ExperimentVariant `json:"variants"`
+	Active    bool                `json:"active"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// ExperimentVariant is one version of an experiment's prompt. Its
+// messages are templates like the prompt's own, given the same data.
+type ExperimentVariant struct {
+	ID string `json:"id"`
+	// Weight is the variant's share of accounts, at least 1
+	Weight   int           `json:"weight"`
+	Messages []llm.Message `json:"messages"`
+}
+
+// ExperimentAssignment tags a response with the variant that produced it.
+// Report how it went to POST /experiments/{id}/outcomes with ResponseID.
+type ExperimentAssignment struct {
+	ExperimentID string `json:"experiment_id"`
+	VariantID    string `json:"variant_id"`
+	ResponseID   string `json:"response_id"`
+}
+
+// VariantResult is how a variant has performed so far
+type VariantResult struct {
+	VariantID string `json:"variant_id"`
+	// Weight is 0 for variants since removed from the experiment
+	Weight int `json:"weight"`
+	// Exposures counts the responses the variant produced
+	Exposures int `json:"exposures"`
+	// Outcomes counts the responses an outcome was reported for
+	Outcomes  int `json:"outcomes"`
+	Successes int `json:"successes"`
+	// SuccessRate is Successes over Outcomes and AverageScore the mean of
+	// the scores reported; both are nil until there are any
+	SuccessRate  *float64 `json:"success_rate"`
+	AverageScore *float64 `json:"average_score"`
+}
+
+var errExperimentNotFound = errors.New("experiment not found")
+
+// promptName is the name the variants are registered under, beside the
+// prompt's own versions
+func (e *PromptExperiment) promptName() string {
+	return e.Prompt + "@" + e.ID
+}
+
+func (e *PromptExperiment) templates() []llm.PromptTemplate {
+	templates := make([]llm.PromptTemplate, len(e.Variants))
+	for i, v := range e.Variants {
+		templates[i] = llm.PromptTemplate{Name: e.promptName(), Version: v.ID, Weight: v.Weight, Messages: v.Messages}
+	}
+	return templates
+}
+
+func (e *PromptExperiment) validate() error {
+	if !experimentIDPattern.MatchString(e.ID) {
+		return errors.New("id must be 1-50 lowercase letters, digits, '-' or '_'")
+	}
+	if e.Prompt == "" {
+		return errors.New("prompt is required")
+	}
+	if len(e.Variants) < 2 || len(e.Variants) > maxExperimentVariants {
+		return fmt.Errorf("an experiment needs 2 to %d variants", maxExperimentVariants)
+	}
+	seen := make(map[string]bool, len(e.Variants))
+	for i, v := range e.Variants {
+		switch {
+		case v.ID == "":
+			return fmt.Errorf("variants[%d]: id is required", i)
+		case seen[v.ID]:
+			return fmt.Errorf("variants[%d]: duplicate id %q", i, v.ID)
+		case v.Weight < 1:
+			return fmt.Errorf("variants[%d]: weight must be at least 1", i)
+		case len(v.Messages) == 0:
+			return fmt.Errorf("variants[%d]: messages is required", i)
+		}
+		seen[v.ID] = true
+	}
+	// Templates that do not parse are caught before they are stored
+	return llm.NewPromptRegistry().Load(e.templates())
+}
+
+// installExperiment starts routing the experiment's prompt to its
+// variants, or stops it once the experiment is inactive
+func (s *Service) installExperiment(e *PromptExperiment) error {
+	if !e.Active {
+		s.experimentsMu.Lock()
+		if current := s.experiments[e.Prompt]; current != nil && current.ID == e.ID {
+			delete(s.experiments, e.Prompt)
+		}
+		s.experimentsMu.Unlock()
+		s.llm.Prompts().Remove(e.promptName())
+		return nil
+	}
+	if err := s.llm.Prompts().Load(e.templates()); err != nil {
+		return err
+	}
+	s.experimentsMu.Lock()
+	defer s.experimentsMu.Unlock()
+	if s.experiments == nil {
+		s.experiments = make(map[string]*PromptExperiment)
+	}
+	s.experiments[e.Prompt] = e
+	return nil
+}
+
+// LoadExperiments installs the active experiments stored in
+// prompt_experiments. Experiments changed through another instance take
+// effect here when this is next called.
+func (s *Service) LoadExperiments(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `SELECT `+experimentColumns+` FROM prompt_experiments WHERE active = TRUE`)
+	if err != nil {
+		return fmt.Errorf("failed to load experiments: %w", err)
+	}
+	defer rows.Close()
+
+	var errs []error
+	for rows.Next() {
+		e, err := scanExperiment(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan experiment: %w", err)
+		}
+		if err := s.installExperiment(e); err != nil {
+			errs = append(errs, fmt.Errorf("experiment %s: %w", e.ID, err))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// renderPrompt renders the named prompt, or the variant of its active
+// experiment that the account in ctx is assigned
+func (s *Service) renderPrompt(ctx context.Context, name string, data map[string]interface{}) ([]llm.Message, *ExperimentAssignment, error) {
+	s.experimentsMu.RLock()
+	e := s.experiments[name]
+	s.experimentsMu.RUnlock()
+	if accountID := accountFromContext(ctx); e != nil && accountID != "" {
+		messages, variant, err := s.llm.RenderPromptFor(e.promptName(), accountID, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return messages, &ExperimentAssignment{ExperimentID: e.ID, VariantID: variant, ResponseID: newResponseID()}, nil
+	}
+	messages, err := s.llm.RenderPrompt(name, data)
+	return messages, nil, err
+}
+
+// recordExposure notes that a variant answered, so its outcomes can be
+// reported and compared
+func (s *Service) recordExposure(ctx context.Context, a *ExperimentAssignment) {
+	if _, err := s.db.Exec(ctx, `
+		INSERT INTO prompt_experiment_exposures (response_id, experiment_id, variant, account_id)
+		VALUES ($1, $2, $3, $4)
+	`, a.ResponseID, a.ExperimentID, a.VariantID, accountFromContext(ctx)); err != nil {
+		s.logger.Warn("failed to record experiment exposure",
+			zap.String("experiment_id", a.ExperimentID), zap.Error(err))
+	}
+}
+
+func newResponseID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "airesp_" + hex.EncodeToString(b)
+}
+
+const experimentColumns = `id, prompt, variants, active, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExperiment(row rowScanner) (*PromptExperiment, error) {
+	var e PromptExperiment
+	var variants []byte
+	if err := row.Scan(&e.ID, &e.Prompt, &variants, &e.Active, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variants, &e.Variants); err != nil {
+		return nil, fmt.Errorf("invalid variants in experiment %s: %w", e.ID, err)
+	}
+	return &e, nil
+}
+
+func (s *Service) getExperiment(ctx context.Context, id string) (*PromptExperiment, error) {
+	e, err := scanExperiment(s.db.QueryRow(ctx, `SELECT `+experimentColumns+` FROM prompt_experiments WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errExperimentNotFound
+	}
+	return e, err
+}
+
+// activeExperimentConflict reports another active experiment on e's
+// prompt, which e may not run alongside
+func (s *Service) activeExperimentConflict(ctx context.Context, e *PromptExperiment) error {
+	var other string
+	err := s.db.QueryRow(ctx, `
+		SELECT id FROM prompt_experiments WHERE prompt = $1 AND active = TRUE AND id <> $2
+	`, e.Prompt, e.ID).Scan(&other)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("experiment %s is already running on %s", other, e.Prompt)
+}
+
+// isAdminRequest reports whether the caller may manage experiments
+func isAdminRequest(r *http.Request) bool {
+	role := r.Header.Get("X-Role")
+	return role == "admin" || role == "super_admin"
+}
+
+// handleCreateExperiment starts an experiment, active unless the body says
+// otherwise
+func (s *Service) handleCreateExperiment(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "not allowed to manage experiments", http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+	e := PromptExperiment{Active: true}
+	if !s.decodeAndValidate(w, r, &e, e.validate) {
+		return
+	}
+	if _, err := s.llm.Prompts().Select(e.Prompt); err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if e.Active {
+		if err := s.activeExperimentConflict(ctx, &e); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	variants, _ := json.Marshal(e.Variants)
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO prompt_experiments (id, prompt, variants, active)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING created_at, updated_at
+	`, e.ID, e.Prompt, variants, e.Active).Scan(&e.CreatedAt, &e.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "experiment "+e.ID+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to create experiment", zap.String("id", e.ID), zap.Error(err))
+		s.jsonError(w, r, "failed to create experiment", http.StatusInternalServerError)
+		return
+	}
+	if err := s.installExperiment(&e); err != nil {
+		s.logger.Error("failed to install experiment", zap.String("id", e.ID), zap.Error(err))
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"data":   e,
+	}, http.StatusCreated)
+}
+
+func (s *Service) handleListExperiments(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "not allowed to manage experiments", http.StatusForbidden)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `SELECT `+experimentColumns+` FROM prompt_experiments ORDER BY created_at DESC`)
+	if err != nil {
+		s.jsonError(w, r, "failed to load experiments", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	experiments := make([]*PromptExperiment, 0)
+	for rows.Next() {
+		e, err := scanExperiment(rows)
+		if err != nil {
+			s.jsonError(w, r, "failed to load experiments", http.StatusInternalServerError)
+			return
+		}
+		experiments = append(experiments, e)
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, r, "failed to load experiments", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"data":   experiments,
+	}, http.StatusOK)
+}
+
+func (s *Service) handleGetExperiment(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "not allowed to manage experiments", http.StatusForbidden)
+		return
+	}
+	e, err := s.getExperiment(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, errExperimentNotFound) {
+		s.jsonError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to load experiment", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"data":   e,
+	}, http.StatusOK)
+}
+
+// handleUpdateExperiment adjusts an experiment's variants or starts and
+// stops it. The variants given replace the experiment's; one given
+// without messages keeps those of the variant with its ID, so weights can
+// be changed on their own.
+func (s *Service) handleUpdateExperiment(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "not allowed to manage experiments", http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+	var req struct {
+		Variants []ExperimentVariant `json:"variants"`
+		Active   *bool               `json:"active"`
+	}
+	if !s.decodeAndValidate(w, r, &req, nil) {
+		return
+	}
+	e, err := s.getExperiment(ctx, chi.URLParam(r, "id"))
+	if errors.Is(err, errExperimentNotFound) {
+		s.jsonError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to load experiment", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Variants != nil {
+		previous := make(map[string][]llm.Message, len(e.Variants))
+		for _, v := range e.Variants {
+			previous[v.ID] = v.Messages
+		}
+		for i, v := range req.Variants {
+			if len(v.Messages) == 0 {
+				req.Variants[i].Messages = previous[v.ID]
+			}
+		}
+		e.Variants = req.Variants
+	}
+	if req.Active != nil {
+		e.Active = *req.Active
+	}
+	if err := e.validate(); err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if e.Active {
+		if err := s.activeExperimentConflict(ctx, e); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	variants, _ := json.Marshal(e.Variants)
+	err = s.db.QueryRow(ctx, `
+		UPDATE prompt_experiments SET variants = $2, active = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`, e.ID, variants, e.Active).Scan(&e.UpdatedAt)
+	if err != nil {
+		s.logger.Error("failed to update experiment", zap.String("id", e.ID), zap.Error(err))
+		s.jsonError(w, r, "failed to update experiment", http.StatusInternalServerError)
+		return
+	}
+	if err := s.installExperiment(e); err != nil {
+		s.logger.Error("failed to install experiment", zap.String("id", e.ID), zap.Error(err))
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"data":   e,
+	}, http.StatusOK)
+}
+
+// handleExperimentOutcome records how a tagged response went, such as
+// whether a support answer resolved the ticket. Reporting again replaces
+// the outcome. Accounts may only report on their own responses.
+func (s *Service) handleExperimentOutcome(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResponseID string   `json:"response_id"`
+		Success    *bool    `json:"success"`
+		Score      *float64 `json:"score"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if err := requireFields("response_id", req.ResponseID); err != nil {
+			return err
+		}
+		if req.Success == nil {
+			return errors.New("success is required")
+		}
+		return nil
+	}) {
+		return
+	}
+	accountID := accountFromContext(r.Context())
+	if isAdminRequest(r) {
+		accountID = ""
+	}
+
+	res, err := s.db.Exec(r.Context(), `
+		INSERT INTO prompt_experiment_outcomes (response_id, experiment_id, variant, success, score)
+		SELECT response_id, experiment_id, variant, $3, $4 FROM prompt_experiment_exposures
+		WHERE response_id = $1 AND experiment_id = $2 AND ($5 = '' OR account_id = $5)
+		ON CONFLICT (response_id) DO UPDATE
+		SET success = EXCLUDED.success, score = EXCLUDED.score, created_at = NOW()
+	`, req.ResponseID, chi.URLParam(r, "id"), *req.Success, req.Score, accountID)
+	if err != nil {
+		s.logger.Error("failed to record experiment outcome", zap.Error(err))
+		s.jsonError(w, r, "failed to record outcome", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, r, "response not found in this experiment", http.StatusNotFound)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+}
+
+// handleExperimentResults reports each variant's exposures and outcomes
+func (s *Service) handleExperimentResults(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "not allowed to manage experiments", http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+	e, err := s.getExperiment(ctx, chi.URLParam(r, "id"))
+	if errors.Is(err, errExperimentNotFound) {
+		s.jsonError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to load experiment", http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]*VariantResult, 0, len(e.Variants))
+	byID := make(map[string]*VariantResult, len(e.Variants))
+	for _, v := range e.Variants {
+		result := &VariantResult{VariantID: v.ID, Weight: v.Weight}
+		results = append(results, result)
+		byID[v.ID] = result
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT x.variant, COUNT(*), COUNT(o.response_id),
+			COUNT(*) FILTER (WHERE o.success), AVG(o.score)
+		FROM prompt_experiment_exposures x
+		LEFT JOIN prompt_experiment_outcomes o ON o.response_id = x.response_id
+		WHERE x.experiment_id = $1
+		GROUP BY x.variant
+		ORDER BY x.variant
+	`, e.ID)
+	if err != nil {
+		s.logger.Warn("experiment results query failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load results", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variant string
+		var exposures, outcomes, successes int
+		var score sql.NullFloat64
+		if err := rows.Scan(&variant, &exposures, &outcomes, &successes, &score); err != nil {
+			s.jsonError(w, r, "failed to load results", http.StatusInternalServerError)
+			return
+		}
+		result := byID[variant]
+		if result == nil {
+			result = &VariantResult{VariantID: variant}
+			results = append(results, result)
+		}
+		result.Exposures, result.Outcomes, result.Successes = exposures, outcomes, successes
+		if outcomes > 0 {
+			rate := float64(successes) / float64(outcomes)
+			result.SuccessRate = &rate
+		}
+		if score.Valid {
+			result.AverageScore = &score.Float64
+		}
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, r, "failed to load results", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status":     "success",
+		"experiment": e,
+		"variants":   results,
+	}, http.StatusOK)
+}
@@ -0,0 +1,25 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// digestStaleAfter is how long RunDigests may go without polling before
+// HealthCheck reports it stuck; a poll can take a while with many digests
+const digestStaleAfter = 5 * digestPollInterval
+
+// HealthCheck reports the service unhealthy when no LLM provider is
+// reachable, or when RunDigests was started and has stopped polling
+func (s *Service) HealthCheck(ctx context.Context) error {
+	if last := s.digestBeat.Load(); last != 0 {
+		if since := time.Since(time.Unix(0, last)); since > digestStaleAfter {
+			return fmt.Errorf("digest worker last polled %s ago", since.Round(time.Second))
+		}
+	}
+	if err := s.llm.HealthCheck(ctx); err != nil {
+		return fmt.Errorf("LLM unavailable: %w", err)
+	}
+	return nil
+}
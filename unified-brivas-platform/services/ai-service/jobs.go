@@ -0,0 +1,136 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Batch job limits and names
+const (
+	// maxJobItems bounds the items of one batch request
+	maxJobItems = 100
+
+	JobOptimizeCampaigns = "campaign.optimize"
+	JobGenerateSMS       = "sms.generate"
+)
+
+// DeadLetterStore is an llm.DeadLetters kept in LumaDB, so the items batch
+// jobs gave up on can be inspected and resubmitted. Install it with
+// SetDeadLetters.
+type DeadLetterStore struct {
+	db lumadb.Querier
+}
+
+var _ llm.DeadLetters = (*DeadLetterStore)(nil)
+
+// NewDeadLetterStore creates a dead-letter store in the ai_dead_letters
+// table
+func NewDeadLetterStore(db lumadb.Querier) *DeadLetterStore {
+	return &DeadLetterStore{db: db}
+}
+
+// DeadLetter stores letter
+func (s *DeadLetterStore) DeadLetter(ctx context.Context, letter *llm.DeadLetter) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO ai_dead_letters (job_id, job, item_index, item, error, attempts, account_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+	`, letter.JobID, letter.Job, letter.Index, []byte(letter.Item), letter.Error, letter.Attempts,
+		accountFromContext(ctx), letter.Time)
+	return err
+}
+
+// SetDeadLetters sets where batch items that fail every attempt are kept;
+// without one they are only reported
+func (s *Service) SetDeadLetters(store llm.DeadLetters) {
+	s.deadLetters = store
+}
+
+// SetJobRetry sets how batch items are retried
+func (s *Service) SetJobRetry(policy llm.RetryPolicy) {
+	s.jobRetry = policy
+}
+
+func (s *Service) job(name string) *llm.Job {
+	return &llm.Job{
+		Name:        name,
+		Retry:       s.jobRetry,
+		DeadLetters: s.deadLetters,
+		Logger:      s.logger,
+	}
+}
+
+// jobItem adapts an LLM call for a job, so refusals are not retried
+func jobItem[T, R any](fn func(ctx context.Context, item T) (R, error)) func(ctx context.Context, item T) (R, error) {
+	return func(ctx context.Context, item T) (R, error) {
+		result, err := fn(ctx, item)
+		if errors.Is(err, errContentFiltered) {
+			err = llm.Permanent(err)
+		}
+		return result, err
+	}
+}
+
+// validateJobItems checks a batch's size and each of its items
+func validateJobItems[T any](field string, items []T, validate func(*T) error) error {
+	if len(items) == 0 {
+		return fmt.Errorf("%s is required", field)
+	}
+	if len(items) > maxJobItems {
+		return fmt.Errorf("at most %d %s may be submitted at once", maxJobItems, field)
+	}
+	for i := range items {
+		if err := validate(&items[i]); err != nil {
+			return fmt.Errorf("%s[%d]: %w", field, i, err)
+		}
+	}
+	return nil
+}
+
+// jobResponse answers with a job's summary: 200 when every item
+// succeeded, 207 when some did and 502 when none did
+func jobResponse[R any](s *Service, w http.ResponseWriter, summary *llm.JobSummary[R]) {
+	status, code := "success", http.StatusOK
+	switch {
+	case summary.Failed == summary.Total:
+		status, code = "failed", http.StatusBadGateway
+	case summary.Failed > 0:
+		status, code = "partial", http.StatusMultiStatus
+	}
+	s.jsonResponse(w, map[string]interface{}{
+		"status": status,
+		"job":    summary,
+	}, code)
+}
+
+func (s *Service) handleOptimizeCampaigns(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Campaigns []CampaignStats `json:"campaigns"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		return validateJobItems("campaigns", req.Campaigns, (*CampaignStats).validate)
+	}) {
+		return
+	}
+
+	summary := llm.RunJob(r.Context(), s.job(JobOptimizeCampaigns), req.Campaigns, jobItem(s.optimizeCampaign))
+	jobResponse(s, w, summary)
+}
+
+func (s *Service) handleGenerateSMSBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Requests []GenerateSMSRequest `json:"requests"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		return validateJobItems("requests", req.Requests, (*GenerateSMSRequest).validate)
+	}) {
+		return
+	}
+
+	summary := llm.RunJob(r.Context(), s.job(JobGenerateSMS), req.Requests, jobItem(s.generateSMS))
+	jobResponse(s, w, summary)
+}
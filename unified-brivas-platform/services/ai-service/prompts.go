@@ -0,0 +1,298 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+)
+
+// Prompt names
+const (
+	PromptGenerateSMS      = "sms.generate"
+	PromptImproveSMS       = "sms.improve"
+	PromptTranslateSMS     = "sms.translate"
+	PromptOptimizeCampaign = "campaign.optimize"
+	PromptOptimalSchedule  = "campaign.schedule"
+	PromptSegmentAudience  = "campaign.segment"
+	PromptFraudAnalysis    = "fraud.analyze"
+	PromptFraudScore       = "fraud.score"
+	PromptSupportResponse  = "support.respond"
+	PromptCategorizeTicket = "support.categorize"
+	PromptSummarize        = "analytics.summarize"
+	PromptChat             = "chat"
+)
+
+// jsonObject asks providers for JSON output the handlers can return as is
+var jsonObject = &llm.ResponseFormat{Type: llm.ResponseFormatJSONObject}
+
+func userPrompt(name, content string) llm.PromptTemplate {
+	return llm.PromptTemplate{
+		Name:     name,
+		Version:  "v1",
+		Messages: []llm.Message{{Role: "user", Content: content}},
+	}
+}
+
+// defaultPrompts are built in; rows in prompt_templates replace them by name
+var defaultPrompts = []llm.PromptTemplate{
+	userPrompt(PromptGenerateSMS, `Generate {{.Variations}} SMS message variations for:
+Purpose: {{.Purpose}}
+Product/Service: {{.Product}}
+Target Audience: {{.Audience}}
+Tone: {{.Tone}}
+Keywords to include: {{.Keywords}}
+Maximum length: {{.MaxLength}} characters
+
+Return a JSON object with a "variations" array of objects with "content" and "char_count".
+Ensure messages are engaging, clear, and include a call-to-action.`),
+
+	userPrompt(PromptImproveSMS, `Improve this SMS message for better {{.Goal}}:
+Original: "{{.Content}}"
+
+Provide:
+1. Improved version (max 160 chars)
+2. Explanation of changes
+3. Predicted engagement score (1-10)
+
+Return as JSON with "improved", "explanation", "score" fields.`),
+
+	userPrompt(PromptTranslateSMS, `Translate this SMS to {{.Languages}} while maintaining the tone and staying under 160 chars:
+"{{.Content}}"
+
+Return JSON object with language codes as keys and translations as values.`),
+
+	userPrompt(PromptOptimizeCampaign, `Analyze this SMS campaign and provide optimization recommendations:
+Campaign Stats: {{.Stats}}
+
+Provide:
+1. Performance assessment
+2. Top 3 improvement recommendations
+3. Suggested A/B test variations
+4. Predicted improvement percentage
+
+Return as structured JSON.`),
+
+	userPrompt(PromptOptimalSchedule, `Based on these delivery patterns, recommend optimal send times:
+Historical peak hours: {{.PeakHours}}
+Target audience: {{.Audience}}
+Timezone: {{.Timezone}}
+Plan for next {{.DaysAhead}} days
+
+Return JSON with recommended schedule slots.`),
+
+	userPrompt(PromptSegmentAudience, `Create audience segments for SMS marketing based on: {{.Criteria}}
+
+Suggest 4-6 segments with:
+- Segment name
+- Description
+- Recommended message tone
+- Best send times
+- Expected engagement rate
+
+Return a JSON object with a "segments" array.`),
+
+	userPrompt(PromptFraudAnalysis, `Analyze these SMS patterns for potential fraud indicators:
+{{.Patterns}}
+
+Identify:
+1. Suspicious patterns (spam, phishing, fraud)
+2. Risk score (0-100)
+3. Specific concerns
+4. Recommended actions
+
+Return structured JSON.`),
+
+	userPrompt(PromptFraudScore, `Score this SMS for fraud risk (0-100):
+Message: "{{.Message}}"
+Sender ID: {{.Sender}}
+Send volume: {{.Volume}}
+
+Evaluate:
+- Phishing indicators
+- Spam characteristics
+- Impersonation attempts
+- Malicious links
+
+Return JSON with "score", "risk_level", "indicators".`),
+
+	{
+		Name:    PromptSupportResponse,
+		Version: "v1",
+		Messages: []llm.Message{
+			{Role: "system", Content: `You are a helpful customer support agent for Brivas, an SMS and telecommunications platform.
+Answer questions about: SMS sending, billing, sender IDs, campaigns, API usage, and account management.
+Be concise, professional, and helpful. If you don't know something, suggest contacting support.
+{{- if .Context}}
+
+Context from the user: {{.Context}}
+{{- end}}
+{{- if .History}}
+
+Relevant history from this account:
+{{.History}}
+{{- end}}`},
+			{Role: "user", Content: `{{.Query}}`},
+		},
+	},
+
+	userPrompt(PromptCategorizeTicket, `Categorize this support ticket:
+Subject: {{.Subject}}
+Body: {{.Body}}
+
+Return JSON with:
+- category: billing/technical/api/sender_id/campaign/account/other
+- priority: low/medium/high/urgent
+- sentiment: positive/neutral/negative
+- suggested_response: brief template`),
+
+	userPrompt(PromptSummarize, `Create an executive summary for this SMS platform usage:
+- Total sent: {{.TotalSent}}
+- Delivered: {{.Delivered}} ({{.DeliveryRate}}%)
+- Failed: {{.Failed}}
+- Total spent: ₦{{.Spent}}
+Period: {{.Period}}
+
+Provide insights on:
+1. Performance overview
+2. Key trends
+3. Recommendations for next period
+
+Keep it concise (3-4 paragraphs).`),
+
+	{
+		Name:    PromptChat,
+		Version: "v1",
+		Messages: []llm.Message{
+			{Role: "system", Content: `You are an AI assistant for the Brivas SMS platform. Help users with:
+- Composing and improving SMS messages
+- Understanding platform features
+- Campaign planning and optimization
+- Troubleshooting issues
+Be helpful, concise, and professional.`},
+		},
+	},
+}
+
+// registerDefaultPrompts adds the built-in prompts to the orchestrator
+func (s *Service) registerDefaultPrompts() {
+	for _, p := range defaultPrompts {
+		if err := s.llm.Prompts().Register(p); err != nil {
+			s.logger.Error("invalid built-in prompt", zap.String("prompt", p.Name), zap.Error(err))
+		}
+	}
+}
+
+// LoadPrompts replaces built-in prompts with the active versions stored in
+// prompt_templates, so prompts can be tuned without a redeploy
+func (s *Service) LoadPrompts(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT name, version, weight, messages FROM prompt_templates WHERE active = TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load prompts: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []llm.PromptTemplate
+	for rows.Next() {
+		var t llm.PromptTemplate
+		var messages []byte
+		if err := rows.Scan(&t.Name, &t.Version, &t.Weight, &messages); err != nil {
+			return fmt.Errorf("failed to scan prompt: %w", err)
+		}
+		if err := json.Unmarshal(messages, &t.Messages); err != nil {
+			return fmt.Errorf("invalid messages in prompt %s %s: %w", t.Name, t.Version, err)
+		}
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return s.llm.Prompts().Load(templates)
+}
+
+// complete renders the named prompt into req and sends it. Answers cut off
+// at the token limit are continued up to maxContinuations times; answers
+// blocked by a provider's safety filter fail with errContentFiltered.
+func (s *Service) complete(ctx context.Context, name string, data map[string]interface{}, req *llm.CompletionRequest) (*llm.CompletionResponse, error) {
+	resp, _, err := s.completeVariant(ctx, name, data, req)
+	return resp, err
+}
+
+// completeVariant is complete, also returning the experiment variant that
+// answered; nil when the prompt is not being experimented on
+func (s *Service) completeVariant(ctx context.Context, name string, data map[string]interface{}, req *llm.CompletionRequest) (*llm.CompletionResponse, *ExperimentAssignment, error) {
+	messages, assignment, err := s.renderPrompt(ctx, name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Messages = append(messages, req.Messages...)
+
+	resp, err := s.llm.Complete(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < s.maxContinuations && resp.FinishReason == llm.FinishReasonLength; i++ {
+		next := *req
+		next.Messages = append(append([]llm.Message{}, req.Messages...),
+			llm.Message{Role: "assistant", Content: resp.Content},
+			llm.Message{Role: "user", Content: continuePrompt},
+		)
+		more, err := s.llm.Complete(ctx, &next)
+		if err != nil {
+			return nil, nil, err
+		}
+		merged := *resp
+		merged.Content += more.Content
+		merged.FinishReason = more.FinishReason
+		merged.Usage.PromptTokens += more.Usage.PromptTokens
+		merged.Usage.CompletionTokens += more.Usage.CompletionTokens
+		merged.Usage.TotalTokens += more.Usage.TotalTokens
+		merged.Cost = resp.Cost.Add(more.Cost)
+		resp = &merged
+	}
+
+	switch resp.FinishReason {
+	case llm.FinishReasonContentFilter:
+		return nil, nil, errContentFiltered
+	case llm.FinishReasonLength:
+		s.logger.Warn("AI response truncated", zap.String("prompt", name))
+	}
+	if assignment != nil {
+		s.recordExposure(ctx, assignment)
+	}
+	return resp, assignment, nil
+}
+
+// continuePrompt asks for the rest of an answer cut off at the token limit
+const continuePrompt = "Continue exactly where you stopped, without repeating anything."
+
+// errContentFiltered reports a response blocked by a provider's safety
+// filter, which retrying will not change
+var errContentFiltered = errors.New("response blocked by content filter")
+
+// defaultMaxContinuations is how many times a truncated answer is continued
+const defaultMaxContinuations = 2
+
+// SetMaxContinuations sets how many times a truncated answer is continued;
+// 0 returns truncated answers as they are
+func (s *Service) SetMaxContinuations(n int) {
+	s.maxContinuations = n
+}
+
+// completionError answers a failed completion, telling filtered content
+// apart from provider failures
+func (s *Service) completionError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, errContentFiltered) {
+		s.jsonError(w, r, "the request was declined by the AI safety filter", http.StatusUnprocessableEntity)
+		return
+	}
+	s.logger.Warn(msg, zap.Error(err))
+	s.jsonError(w, r, msg, http.StatusInternalServerError)
+}
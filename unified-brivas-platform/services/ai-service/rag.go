@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Retrieval settings
+const (
+	ingestBatchSize = 32
+	retrieveTopK    = 5
+	// minRelevance drops matches too dissimilar to help
+	minRelevance = 0.3
+)
+
+// Record is a piece of account history, such as a past ticket or message,
+// that support answers can be grounded in
+type Record struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+	Kind      string `json:"kind"` // ticket, sms, ...
+	Content   string `json:"content"`
+}
+
+// SetVectorStore enables retrieval-augmented support answers
+func (s *Service) SetVectorStore(store lumadb.VectorStore) {
+	s.vectors = store
+}
+
+// IngestRecords embeds records in batches and stores them for retrieval
+func (s *Service) IngestRecords(ctx context.Context, records []Record) error {
+	if s.vectors == nil {
+		return fmt.Errorf("no vector store configured")
+	}
+
+	for start := 0; start < len(records); start += ingestBatchSize {
+		batch := records[start:min(start+ingestBatchSize, len(records))]
+		texts := make([]string, len(batch))
+		for i, r := range batch {
+			texts[i] = r.Content
+		}
+
+		vectors, err := s.llm.EmbedBatch(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed records: %w", err)
+		}
+		for i, r := range batch {
+			err := s.vectors.Upsert(ctx, r.Kind+":"+r.ID, vectors[i], map[string]string{
+				"account_id": r.AccountID,
+				"kind":       r.Kind,
+				"content":    r.Content,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retrieveContext returns the account's records most relevant to query,
+// one per line
+func (s *Service) retrieveContext(ctx context.Context, accountID, query string) (string, error) {
+	if s.vectors == nil || accountID == "" || query == "" {
+		return "", nil
+	}
+
+	vector, err := s.llm.Embed(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	matches, err := s.vectors.Search(ctx, vector, retrieveTopK, map[string]string{"account_id": accountID})
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, m := range matches {
+		if m.Score < minRelevance {
+			continue
+		}
+		fmt.Fprintf(&b, "- [%s] %s\n", m.Metadata["kind"], m.Metadata["content"])
+	}
+	return b.String(), nil
+}
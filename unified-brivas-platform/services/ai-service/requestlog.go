@@ -0,0 +1,64 @@
+package ai
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// RequestLogStore is an llm.RequestLog kept in LumaDB, so logged requests
+// can be replayed from any instance and after restarts. Install it with the
+// orchestrator's SetRequestLog and Prune it periodically.
+type RequestLogStore struct {
+	db lumadb.Querier
+}
+
+// NewRequestLogStore creates a request log in the llm_request_log table
+func NewRequestLogStore(db lumadb.Querier) *RequestLogStore {
+	return &RequestLogStore{db: db}
+}
+
+// Log stores entry
+func (s *RequestLogStore) Log(ctx context.Context, entry *llm.RequestLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO llm_request_log (id, provider, model, error, replay_of, entry, created_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7)
+	`, entry.ID, entry.Provider, entry.Model, entry.Error, entry.ReplayOf, data, entry.Time)
+	return err
+}
+
+// Get loads a stored entry
+func (s *RequestLogStore) Get(ctx context.Context, id string) (*llm.RequestLogEntry, error) {
+	var data []byte
+	err := s.db.QueryRow(ctx, `SELECT entry FROM llm_request_log WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", llm.ErrRequestNotLogged, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry llm.RequestLogEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("invalid request log entry %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// Prune deletes entries logged before cutoff and returns how many
+func (s *RequestLogStore) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(ctx, `DELETE FROM llm_request_log WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,153 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+)
+
+const (
+	// defaultScheduleCacheTTL is how long peak hours and the recommendation
+	// built on them are reused; peaks barely move hour to hour
+	defaultScheduleCacheTTL = time.Hour
+	// defaultScheduleHistoryDays is the delivery history peak hours are
+	// computed from when history_days is not given
+	defaultScheduleHistoryDays = 90
+	maxScheduleHistoryDays     = 365
+)
+
+// SetScheduleCacheTTL sets how long optimal-schedule peak hours and
+// recommendations are cached; 0 disables caching, concurrent identical
+// requests are still served by one computation
+func (s *Service) SetScheduleCacheTTL(ttl time.Duration) {
+	s.scheduleTTL = ttl
+}
+
+// scheduleCache holds values by key until they expire
+type scheduleCache struct {
+	mu      sync.Mutex
+	entries map[string]scheduleEntry
+}
+
+type scheduleEntry struct {
+	value  interface{}
+	expiry time.Time
+}
+
+func (c *scheduleCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiry) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *scheduleCache) put(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]scheduleEntry)
+	}
+	// Expired entries go on write, there are at most a few per account
+	for k, entry := range c.entries {
+		if now.After(entry.expiry) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = scheduleEntry{value: value, expiry: now.Add(ttl)}
+}
+
+// cachedSchedule returns the cached value for key or computes it, once for
+// all concurrent callers. The computation outlives a caller that goes away,
+// so the others still get its result.
+func (s *Service) cachedSchedule(ctx context.Context, key string, compute func(context.Context) (interface{}, error)) (interface{}, error) {
+	if value, ok := s.scheduleCache.get(key); ok {
+		return value, nil
+	}
+	value, err, _ := s.scheduleFlight.Do(key, func() (interface{}, error) {
+		if value, ok := s.scheduleCache.get(key); ok {
+			return value, nil
+		}
+		value, err := compute(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		s.scheduleCache.put(key, value, s.scheduleTTL)
+		return value, nil
+	})
+	return value, err
+}
+
+// peakHours returns the account's five hours with the most deliveries over
+// the last days days
+func (s *Service) peakHours(ctx context.Context, accountID string, days int) ([]map[string]interface{}, error) {
+	value, err := s.cachedSchedule(ctx, fmt.Sprintf("peaks:%s:%d", accountID, days), func(ctx context.Context) (interface{}, error) {
+		rows, err := s.db.Query(ctx, `
+			SELECT EXTRACT(HOUR FROM sent_time) as hour,
+				   COUNT(*) as total,
+				   SUM(CASE WHEN status = 'delivered' THEN 1 ELSE 0 END) as delivered
+			FROM sms_history
+			WHERE account_id = $1 AND sent_date >= CURRENT_DATE - $2 * INTERVAL '1 day'
+			GROUP BY hour
+			ORDER BY delivered DESC
+			LIMIT 5
+		`, accountID, days)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var peaks []map[string]interface{}
+		for rows.Next() {
+			var hour, total, delivered int
+			if err := rows.Scan(&hour, &total, &delivered); err != nil {
+				return nil, err
+			}
+			peaks = append(peaks, map[string]interface{}{
+				"hour": hour, "total": total, "delivered": delivered,
+			})
+		}
+		return peaks, rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	peaks, _ := value.([]map[string]interface{})
+	return peaks, nil
+}
+
+// scheduleRecommendation asks the model for a schedule, reusing the answer
+// for requests with the same peaks, audience, timezone and horizon
+func (s *Service) scheduleRecommendation(ctx context.Context, peaks []map[string]interface{}, audience, timezone string, daysAhead int) (string, error) {
+	input, _ := json.Marshal([]interface{}{peaks, audience, timezone, daysAhead})
+	sum := sha256.Sum256(input)
+
+	value, err := s.cachedSchedule(ctx, "schedule:"+hex.EncodeToString(sum[:]), func(ctx context.Context) (interface{}, error) {
+		resp, err := s.complete(ctx, PromptOptimalSchedule, map[string]interface{}{
+			"PeakHours": fmt.Sprint(peaks),
+			"Audience":  audience,
+			"Timezone":  timezone,
+			"DaysAhead": daysAhead,
+		}, &llm.CompletionRequest{ResponseFormat: jsonObject})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Content, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
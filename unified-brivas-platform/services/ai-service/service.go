@@ -2,42 +2,84 @@
 package ai
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
+	"github.com/brivas/unified-platform/packages/apierr"
 	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
 	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
 )
 
 // Service provides AI-powered platform features
 type Service struct {
-	db     *lumadb.Client
+	db     lumadb.Querier
 	llm    *llm.Orchestrator
 	logger *zap.Logger
+
+	maxContinuations int
+	vectors          lumadb.VectorStore
+	webhooks         WebhookSender
+	embedCache       *embeddingCache
+	embedLimits      *embedLimiter
+	jobRetry         llm.RetryPolicy
+	deadLetters      llm.DeadLetters
+
+	// experiments holds the active experiment of each prompt, by name
+	experimentsMu sync.RWMutex
+	experiments   map[string]*PromptExperiment
+
+	scheduleTTL    time.Duration
+	scheduleCache  scheduleCache
+	scheduleFlight singleflight.Group
+
+	// digestBeat is when RunDigests last polled, in Unix nanoseconds; 0
+	// while it is not running
+	digestBeat atomic.Int64
 }
 
 // NewService creates a new AI service
-func NewService(db *lumadb.Client, llmOrch *llm.Orchestrator, logger *zap.Logger) *Service {
-	return &Service{db: db, llm: llmOrch, logger: logger}
+func NewService(db lumadb.Querier, llmOrch *llm.Orchestrator, logger *zap.Logger) *Service {
+	s := &Service{
+		db:               db,
+		llm:              llmOrch,
+		logger:           logger,
+		maxContinuations: defaultMaxContinuations,
+		embedCache:       newEmbeddingCache(embedCacheSize, embedCacheTTL),
+		embedLimits:      newEmbedLimiter(defaultEmbedRate, defaultEmbedBurst),
+		scheduleTTL:      defaultScheduleCacheTTL,
+	}
+	s.registerDefaultPrompts()
+	return s
 }
 
 // Routes returns Chi router with AI endpoints
 func (s *Service) Routes() chi.Router {
 	r := chi.NewRouter()
+	r.Use(withRequestAccount)
 
 	// SMS Content Generation
 	r.Post("/sms/generate", s.handleGenerateSMS)
+	r.Post("/sms/generate/batch", s.handleGenerateSMSBatch)
 	r.Post("/sms/improve", s.handleImproveSMS)
 	r.Post("/sms/translate", s.handleTranslateSMS)
 
 	// Campaign Optimization
 	r.Post("/campaign/optimize", s.handleOptimizeCampaign)
+	r.Post("/campaign/optimize/batch", s.handleOptimizeCampaigns)
 	r.Post("/campaign/schedule", s.handleOptimalSchedule)
 	r.Post("/campaign/segment", s.handleAudienceSegmentation)
 
@@ -52,10 +94,28 @@ func (s *Service) Routes() chi.Router {
 	// Analytics & Insights
 	r.Post("/analytics/summarize", s.handleSummarize)
 	r.Get("/analytics/insights/{account_id}", s.handleAccountInsights)
+	r.Get("/analytics/digest/{account_id}", s.handleGetDigestSchedule)
+	r.Put("/analytics/digest/{account_id}", s.handleSetDigestSchedule)
+	r.Delete("/analytics/digest/{account_id}", s.handleDeleteDigestSchedule)
 
 	// Chat Interface
 	r.Post("/chat", s.handleChat)
 
+	// Embeddings
+	r.Post("/embed", s.handleEmbed)
+	r.Post("/embed/batch", s.handleEmbedBatch)
+
+	// Prompt experiments
+	r.Post("/experiments", s.handleCreateExperiment)
+	r.Get("/experiments", s.handleListExperiments)
+	r.Get("/experiments/{id}", s.handleGetExperiment)
+	r.Put("/experiments/{id}", s.handleUpdateExperiment)
+	r.Post("/experiments/{id}/outcomes", s.handleExperimentOutcome)
+	r.Get("/experiments/{id}/results", s.handleExperimentResults)
+
+	// AI usage and spend
+	r.Get("/usage/{account_id}", s.handleAccountUsage)
+
 	return r
 }
 
@@ -71,14 +131,46 @@ type GenerateSMSRequest struct {
 	Variations int      `json:"variations"`
 }
 
+func (req *GenerateSMSRequest) validate() error {
+	if req.Purpose == "" && req.Product == "" {
+		return errors.New("purpose or product is required")
+	}
+	if req.MaxLength < 0 || req.Variations < 0 {
+		return errors.New("max_length and variations must not be negative")
+	}
+	return nil
+}
+
+// GeneratedSMS is the message variations generated for a GenerateSMSRequest
+type GeneratedSMS struct {
+	Messages json.RawMessage `json:"messages"`
+	Model    string          `json:"model"`
+	Usage    llm.Usage       `json:"usage"`
+	Cost     *llm.Cost       `json:"cost,omitempty"`
+}
+
 func (s *Service) handleGenerateSMS(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 	var req GenerateSMSRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.jsonError(w, "invalid request", http.StatusBadRequest)
+	if !s.decodeAndValidate(w, r, &req, req.validate) {
 		return
 	}
 
+	generated, err := s.generateSMS(r.Context(), req)
+	if err != nil {
+		s.completionError(w, r, err, "AI generation failed")
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status":   "success",
+		"messages": generated.Messages,
+		"model":    generated.Model,
+		"usage":    generated.Usage,
+		"cost":     generated.Cost,
+	}, http.StatusOK)
+}
+
+func (s *Service) generateSMS(ctx context.Context, req GenerateSMSRequest) (*GeneratedSMS, error) {
 	if req.MaxLength == 0 {
 		req.MaxLength = 160
 	}
@@ -86,33 +178,24 @@ func (s *Service) handleGenerateSMS(w http.ResponseWriter, r *http.Request) {
 		req.Variations = 3
 	}
 
-	prompt := fmt.Sprintf(`Generate %d SMS message variations for:
-Purpose: %s
-Product/Service: %s
-Target Audience: %s
-Tone: %s
-Keywords to include: %s
-Maximum length: %d characters
-
-Return as JSON array with "content" and "char_count" for each variation.
-Ensure messages are engaging, clear, and include a call-to-action.`,
-		req.Variations, req.Purpose, req.Product, req.Audience, req.Tone,
-		strings.Join(req.Keywords, ", "), req.MaxLength)
-
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages:    []llm.Message{{Role: "user", Content: prompt}},
-		Temperature: 0.8,
-	})
+	resp, err := s.complete(ctx, PromptGenerateSMS, map[string]interface{}{
+		"Variations": req.Variations,
+		"Purpose":    req.Purpose,
+		"Product":    req.Product,
+		"Audience":   req.Audience,
+		"Tone":       req.Tone,
+		"Keywords":   strings.Join(req.Keywords, ", "),
+		"MaxLength":  req.MaxLength,
+	}, &llm.CompletionRequest{Temperature: 0.8, ResponseFormat: jsonObject})
 	if err != nil {
-		s.jsonError(w, "AI generation failed", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-
-	s.jsonResponse(w, map[string]interface{}{
-		"status":   "success",
-		"messages": json.RawMessage(resp.Content),
-		"model":    resp.Model,
-	}, http.StatusOK)
+	return &GeneratedSMS{
+		Messages: jsonField(resp.Content, "variations"),
+		Model:    resp.Model,
+		Usage:    resp.Usage,
+		Cost:     resp.Cost,
+	}, nil
 }
 
 func (s *Service) handleImproveSMS(w http.ResponseWriter, r *http.Request) {
@@ -121,32 +204,31 @@ func (s *Service) handleImproveSMS(w http.ResponseWriter, r *http.Request) {
 		Content string `json:"content"`
 		Goal    string `json:"goal"` // engagement, clarity, urgency
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.jsonError(w, "invalid request", http.StatusBadRequest)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		return requireFields("content", req.Content)
+	}) {
 		return
 	}
 
-	prompt := fmt.Sprintf(`Improve this SMS message for better %s:
-Original: "%s"
-
-Provide:
-1. Improved version (max 160 chars)
-2. Explanation of changes
-3. Predicted engagement score (1-10)
-
-Return as JSON with "improved", "explanation", "score" fields.`, req.Goal, req.Content)
-
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	resp, err := s.complete(ctx, PromptImproveSMS, map[string]interface{}{
+		"Goal":    req.Goal,
+		"Content": req.Content,
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
 	if err != nil {
-		s.jsonError(w, "AI improvement failed", http.StatusInternalServerError)
+		s.degrade(w, r, err, "AI improvement failed", map[string]interface{}{
+			"result": map[string]interface{}{
+				"improved":    req.Content,
+				"explanation": "The AI assistant is unavailable; the original message is returned unchanged.",
+			},
+		})
 		return
 	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status": "success",
 		"result": json.RawMessage(resp.Content),
+		"usage":  resp.Usage,
+		"cost":   resp.Cost,
 	}, http.StatusOK)
 }
 
@@ -156,117 +238,123 @@ func (s *Service) handleTranslateSMS(w http.ResponseWriter, r *http.Request) {
 		Content   string   `json:"content"`
 		Languages []string `json:"languages"` // Target languages
 	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	prompt := fmt.Sprintf(`Translate this SMS to %s while maintaining the tone and staying under 160 chars:
-"%s"
-
-Return JSON object with language codes as keys and translations as values.`,
-		strings.Join(req.Languages, ", "), req.Content)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if len(req.Languages) == 0 {
+			return errors.New("languages is required")
+		}
+		return requireFields("content", req.Content)
+	}) {
+		return
+	}
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	resp, err := s.complete(ctx, PromptTranslateSMS, map[string]interface{}{
+		"Languages": strings.Join(req.Languages, ", "),
+		"Content":   req.Content,
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
 	if err != nil {
-		s.jsonError(w, "translation failed", http.StatusInternalServerError)
+		s.completionError(w, r, err, "translation failed")
 		return
 	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status":       "success",
 		"translations": json.RawMessage(resp.Content),
+		"usage":        resp.Usage,
+		"cost":         resp.Cost,
 	}, http.StatusOK)
 }
 
 // ============== Campaign Optimization ==============
 
-func (s *Service) handleOptimizeCampaign(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	var req struct {
-		CampaignID   string                 `json:"campaign_id"`
-		CurrentStats map[string]interface{} `json:"current_stats"`
-	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	// Get historical campaign data
-	statsJSON, _ := json.Marshal(req.CurrentStats)
-
-	prompt := fmt.Sprintf(`Analyze this SMS campaign and provide optimization recommendations:
-Campaign Stats: %s
+// CampaignStats are a campaign's current statistics, for optimization
+type CampaignStats struct {
+	CampaignID   string                 `json:"campaign_id"`
+	CurrentStats map[string]interface{} `json:"current_stats"`
+}
 
-Provide:
-1. Performance assessment
-2. Top 3 improvement recommendations
-3. Suggested A/B test variations
-4. Predicted improvement percentage
+func (req *CampaignStats) validate() error {
+	if len(req.CurrentStats) == 0 {
+		return errors.New("current_stats is required")
+	}
+	return nil
+}
 
-Return as structured JSON.`, string(statsJSON))
+func (s *Service) handleOptimizeCampaign(w http.ResponseWriter, r *http.Request) {
+	var req CampaignStats
+	if !s.decodeAndValidate(w, r, &req, req.validate) {
+		return
+	}
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	recommendations, err := s.optimizeCampaign(r.Context(), req)
 	if err != nil {
-		s.jsonError(w, "optimization failed", http.StatusInternalServerError)
+		s.completionError(w, r, err, "optimization failed")
 		return
 	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status":          "success",
-		"recommendations": json.RawMessage(resp.Content),
+		"recommendations": recommendations,
 	}, http.StatusOK)
 }
 
+func (s *Service) optimizeCampaign(ctx context.Context, req CampaignStats) (json.RawMessage, error) {
+	statsJSON, _ := json.Marshal(req.CurrentStats)
+
+	resp, err := s.complete(ctx, PromptOptimizeCampaign, map[string]interface{}{
+		"Stats": string(statsJSON),
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(resp.Content), nil
+}
+
 func (s *Service) handleOptimalSchedule(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var req struct {
-		AccountID string `json:"account_id"`
-		Audience  string `json:"audience"`
-		Timezone  string `json:"timezone"`
-		DaysAhead int    `json:"days_ahead"`
-	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	// Query historical delivery data
-	var peakHours []map[string]interface{}
-	rows, _ := s.db.Query(ctx, `
-		SELECT EXTRACT(HOUR FROM sent_time) as hour, 
-			   COUNT(*) as total,
-			   SUM(CASE WHEN status = 'delivered' THEN 1 ELSE 0 END) as delivered
-		FROM sms_history 
-		WHERE account_id = $1
-		GROUP BY hour
-		ORDER BY delivered DESC
-		LIMIT 5
-	`, req.AccountID)
-	defer rows.Close()
-	for rows.Next() {
-		var hour, total, delivered int
-		rows.Scan(&hour, &total, &delivered)
-		peakHours = append(peakHours, map[string]interface{}{
-			"hour": hour, "total": total, "delivered": delivered,
-		})
+		AccountID   string `json:"account_id"`
+		Audience    string `json:"audience"`
+		Timezone    string `json:"timezone"`
+		DaysAhead   int    `json:"days_ahead"`
+		HistoryDays int    `json:"history_days"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if req.DaysAhead < 0 {
+			return errors.New("days_ahead must not be negative")
+		}
+		if req.HistoryDays < 0 || req.HistoryDays > maxScheduleHistoryDays {
+			return fmt.Errorf("history_days must be between 1 and %d", maxScheduleHistoryDays)
+		}
+		return requireFields("account_id", req.AccountID)
+	}) {
+		return
+	}
+	if req.HistoryDays == 0 {
+		req.HistoryDays = defaultScheduleHistoryDays
 	}
 
-	prompt := fmt.Sprintf(`Based on these delivery patterns, recommend optimal send times:
-Historical peak hours: %v
-Target audience: %s
-Timezone: %s
-Plan for next %d days
-
-Return JSON with recommended schedule slots.`, peakHours, req.Audience, req.Timezone, req.DaysAhead)
+	peakHours, err := s.peakHours(ctx, req.AccountID, req.HistoryDays)
+	if err != nil {
+		s.logger.Warn("delivery history failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load delivery history", http.StatusInternalServerError)
+		return
+	}
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	schedule, err := s.scheduleRecommendation(ctx, peakHours, req.Audience, req.Timezone, req.DaysAhead)
 	if err != nil {
-		s.jsonError(w, "scheduling failed", http.StatusInternalServerError)
+		s.degrade(w, r, err, "scheduling failed", map[string]interface{}{
+			"schedule":         scheduleFromPeaks(peakHours),
+			"historical_peaks": peakHours,
+			"history_days":     req.HistoryDays,
+		})
 		return
 	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status":           "success",
-		"schedule":         json.RawMessage(resp.Content),
+		"schedule":         json.RawMessage(schedule),
 		"historical_peaks": peakHours,
+		"history_days":     req.HistoryDays,
 	}, http.StatusOK)
 }
 
@@ -276,91 +364,119 @@ func (s *Service) handleAudienceSegmentation(w http.ResponseWriter, r *http.Requ
 		AccountID string `json:"account_id"`
 		Criteria  string `json:"criteria"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		return requireFields("criteria", req.Criteria)
+	}) {
+		return
+	}
 
 	// Get account's contact data characteristics
-	prompt := fmt.Sprintf(`Create audience segments for SMS marketing based on: %s
-
-Suggest 4-6 segments with:
-- Segment name
-- Description
-- Recommended message tone
-- Best send times
-- Expected engagement rate
-
-Return as JSON array.`, req.Criteria)
-
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	resp, err := s.complete(ctx, PromptSegmentAudience, map[string]interface{}{
+		"Criteria": req.Criteria,
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
 	if err != nil {
-		s.jsonError(w, "segmentation failed", http.StatusInternalServerError)
+		s.completionError(w, r, err, "segmentation failed")
 		return
 	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status":   "success",
-		"segments": json.RawMessage(resp.Content),
+		"segments": jsonField(resp.Content, "segments"),
 	}, http.StatusOK)
 }
 
 // ============== Fraud Detection ==============
 
+// fraudTimeRanges are the windows fraud analysis accepts
+var fraudTimeRanges = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// defaultFraudTimeRange is analyzed when time_range is not given
+const defaultFraudTimeRange = "7d"
+
+// noSuspiciousPatterns answers fraud analysis without asking the model when
+// no sender sent to a recipient often enough to look suspicious
+var noSuspiciousPatterns = json.RawMessage(`{"suspicious_patterns":[],"risk_score":0,"concerns":[],"recommended_actions":[],"summary":"no suspicious patterns"}`)
+
 func (s *Service) handleFraudAnalysis(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var req struct {
 		AccountID string `json:"account_id"`
 		TimeRange string `json:"time_range"` // 24h, 7d, 30d
 	}
-	json.NewDecoder(r.Body).Decode(&req)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if req.TimeRange == "" {
+			req.TimeRange = defaultFraudTimeRange
+		}
+		if _, ok := fraudTimeRanges[req.TimeRange]; !ok {
+			return errors.New("time_range must be 24h, 7d or 30d")
+		}
+		return requireFields("account_id", req.AccountID)
+	}) {
+		return
+	}
+	since := time.Now().Add(-fraudTimeRanges[req.TimeRange])
 
-	// Get recent activity patterns
+	// Get activity patterns in the window. The sent_date bound lets the
+	// (account_id, sent_date) index narrow the scan before the exact cut.
 	var patterns []map[string]interface{}
-	rows, _ := s.db.Query(ctx, `
+	rows, err := s.db.Query(ctx, `
 		SELECT sender, recipient, COUNT(*) as count,
 			   COUNT(DISTINCT recipient) as unique_recipients
-		FROM sms_history 
-		WHERE account_id = $1 AND sent_date >= CURRENT_DATE - INTERVAL '7 days'
+		FROM sms_history
+		WHERE account_id = $1 AND sent_date >= $2::timestamptz::date AND sent_date + sent_time >= $2
 		GROUP BY sender, recipient
 		HAVING COUNT(*) > 10
 		ORDER BY count DESC
 		LIMIT 20
-	`, req.AccountID)
+	`, req.AccountID, since)
+	if err != nil {
+		s.logger.Warn("activity patterns failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load activity", http.StatusInternalServerError)
+		return
+	}
 	defer rows.Close()
 	for rows.Next() {
 		var sender, recipient string
 		var count, unique int
-		rows.Scan(&sender, &recipient, &count, &unique)
+		if err := rows.Scan(&sender, &recipient, &count, &unique); err != nil {
+			s.logger.Warn("activity patterns failed", zap.Error(err))
+			s.jsonError(w, r, "failed to load activity", http.StatusInternalServerError)
+			return
+		}
 		patterns = append(patterns, map[string]interface{}{
 			"sender": sender, "recipient": recipient,
 			"count": count, "unique_recipients": unique,
 		})
 	}
+	// Release the connection before waiting on the model
+	rows.Close()
 
-	prompt := fmt.Sprintf(`Analyze these SMS patterns for potential fraud indicators:
-%v
-
-Identify:
-1. Suspicious patterns (spam, phishing, fraud)
-2. Risk score (0-100)
-3. Specific concerns
-4. Recommended actions
-
-Return structured JSON.`, patterns)
+	result := map[string]interface{}{
+		"status":            "success",
+		"analysis":          noSuspiciousPatterns,
+		"patterns_analyzed": len(patterns),
+		"time_range":        req.TimeRange,
+		"since":             since.UTC(),
+	}
+	if len(patterns) == 0 {
+		s.jsonResponse(w, result, http.StatusOK)
+		return
+	}
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	resp, err := s.complete(ctx, PromptFraudAnalysis, map[string]interface{}{
+		"Patterns": fmt.Sprint(patterns),
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
 	if err != nil {
-		s.jsonError(w, "analysis failed", http.StatusInternalServerError)
+		s.completionError(w, r, err, "analysis failed")
 		return
 	}
 
-	s.jsonResponse(w, map[string]interface{}{
-		"status":            "success",
-		"analysis":          json.RawMessage(resp.Content),
-		"patterns_analyzed": len(patterns),
-	}, http.StatusOK)
+	result["analysis"] = json.RawMessage(resp.Content)
+	s.jsonResponse(w, result, http.StatusOK)
 }
 
 func (s *Service) handleFraudScore(w http.ResponseWriter, r *http.Request) {
@@ -370,26 +486,18 @@ func (s *Service) handleFraudScore(w http.ResponseWriter, r *http.Request) {
 		Sender  string `json:"sender"`
 		Volume  int    `json:"volume"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	prompt := fmt.Sprintf(`Score this SMS for fraud risk (0-100):
-Message: "%s"
-Sender ID: %s
-Send volume: %d
-
-Evaluate:
-- Phishing indicators
-- Spam characteristics
-- Impersonation attempts
-- Malicious links
-
-Return JSON with "score", "risk_level", "indicators".`, req.Message, req.Sender, req.Volume)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if req.Volume < 0 {
+			return errors.New("volume must not be negative")
+		}
+		return requireFields("message", req.Message)
+	}) {
+		return
+	}
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	resp, err := s.fraudScore(ctx, req.Message, req.Sender, req.Volume)
 	if err != nil {
-		s.jsonError(w, "scoring failed", http.StatusInternalServerError)
+		s.completionError(w, r, err, "scoring failed")
 		return
 	}
 
@@ -399,37 +507,75 @@ Return JSON with "score", "risk_level", "indicators".`, req.Message, req.Sender,
 	}, http.StatusOK)
 }
 
+func (s *Service) fraudScore(ctx context.Context, message, sender string, volume int) (*llm.CompletionResponse, error) {
+	return s.complete(ctx, PromptFraudScore, map[string]interface{}{
+		"Message": message,
+		"Sender":  sender,
+		"Volume":  volume,
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
+}
+
+// ScoreMessage rates a message's fraud risk from 0 to 100, for callers such
+// as the SMS content filter
+func (s *Service) ScoreMessage(ctx context.Context, message, sender string, volume int) (int, error) {
+	resp, err := s.fraudScore(ctx, message, sender, volume)
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	if err := json.Unmarshal([]byte(resp.Content), &result); err != nil {
+		return 0, fmt.Errorf("invalid fraud score response: %w", err)
+	}
+	return int(math.Max(0, math.Min(100, result.Score))), nil
+}
+
 // ============== Customer Support ==============
 
 func (s *Service) handleSupportResponse(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var req struct {
-		Query    string `json:"query"`
-		Context  string `json:"context"`
-		Language string `json:"language"`
+		AccountID string `json:"account_id"`
+		Query     string `json:"query"`
+		Context   string `json:"context"`
+		Language  string `json:"language"`
+	}
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		return requireFields("query", req.Query)
+	}) {
+		return
 	}
-	json.NewDecoder(r.Body).Decode(&req)
 
-	systemPrompt := `You are a helpful customer support agent for Brivas, an SMS and telecommunications platform.
-Answer questions about: SMS sending, billing, sender IDs, campaigns, API usage, and account management.
-Be concise, professional, and helpful. If you don't know something, suggest contacting support.`
+	// Ground the answer in the account's history when available
+	history, err := s.retrieveContext(ctx, req.AccountID, req.Query)
+	if err != nil {
+		s.logger.Warn("support context retrieval failed", zap.Error(err))
+	}
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: req.Query},
-		},
-	})
+	resp, variant, err := s.completeVariant(ctx, PromptSupportResponse, map[string]interface{}{
+		"Query":   req.Query,
+		"Context": req.Context,
+		"History": history,
+	}, &llm.CompletionRequest{})
 	if err != nil {
-		s.jsonError(w, "response generation failed", http.StatusInternalServerError)
+		s.degrade(w, r, err, "response generation failed", map[string]interface{}{
+			"response": supportUnavailableResponse,
+		})
 		return
 	}
 
-	s.jsonResponse(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"status":   "success",
 		"response": resp.Content,
 		"model":    resp.Model,
-	}, http.StatusOK)
+	}
+	// Tagged so the outcome can be reported against the variant
+	if variant != nil {
+		result["experiment"] = variant
+	}
+	s.jsonResponse(w, result, http.StatusOK)
 }
 
 func (s *Service) handleCategorizeTicket(w http.ResponseWriter, r *http.Request) {
@@ -438,21 +584,25 @@ func (s *Service) handleCategorizeTicket(w http.ResponseWriter, r *http.Request)
 		Subject string `json:"subject"`
 		Body    string `json:"body"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	prompt := fmt.Sprintf(`Categorize this support ticket:
-Subject: %s
-Body: %s
-
-Return JSON with:
-- category: billing/technical/api/sender_id/campaign/account/other
-- priority: low/medium/high/urgent
-- sentiment: positive/neutral/negative
-- suggested_response: brief template`, req.Subject, req.Body)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if req.Subject == "" && req.Body == "" {
+			return errors.New("subject or body is required")
+		}
+		return nil
+	}) {
+		return
+	}
 
-	resp, _ := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	resp, err := s.complete(ctx, PromptCategorizeTicket, map[string]interface{}{
+		"Subject": req.Subject,
+		"Body":    req.Body,
+	}, &llm.CompletionRequest{ResponseFormat: jsonObject})
+	if err != nil {
+		s.degrade(w, r, err, "categorization failed", map[string]interface{}{
+			"categorization": categorizeTicketByKeywords(req.Subject, req.Body),
+		})
+		return
+	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status":         "success",
@@ -468,47 +618,40 @@ func (s *Service) handleSummarize(w http.ResponseWriter, r *http.Request) {
 		AccountID string `json:"account_id"`
 		Period    string `json:"period"` // daily, weekly, monthly
 	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	// Gather stats
-	var totalSent, delivered, failed int
-	var spent float64
-	s.db.QueryRow(ctx, `
-		SELECT COUNT(*), 
-			   SUM(CASE WHEN status='delivered' THEN 1 ELSE 0 END),
-			   SUM(CASE WHEN status='failed' THEN 1 ELSE 0 END),
-			   COALESCE(SUM(rate_per_sms), 0)
-		FROM sms_history 
-		WHERE account_id = $1 AND sent_date >= CURRENT_DATE - INTERVAL '7 days'
-	`, req.AccountID).Scan(&totalSent, &delivered, &failed, &spent)
-
-	prompt := fmt.Sprintf(`Create an executive summary for this SMS platform usage:
-- Total sent: %d
-- Delivered: %d (%.1f%%)
-- Failed: %d
-- Total spent: ₦%.2f
-Period: %s
-
-Provide insights on:
-1. Performance overview
-2. Key trends
-3. Recommendations for next period
-
-Keep it concise (3-4 paragraphs).`, totalSent, delivered,
-		float64(delivered)/float64(totalSent)*100, failed, spent, req.Period)
-
-	resp, _ := s.llm.Complete(ctx, &llm.CompletionRequest{
-		Messages: []llm.Message{{Role: "user", Content: prompt}},
-	})
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		switch req.Period {
+		case "", "daily", "weekly", "monthly":
+		default:
+			return errors.New("period must be daily, weekly or monthly")
+		}
+		return requireFields("account_id", req.AccountID)
+	}) {
+		return
+	}
 
-	s.jsonResponse(w, map[string]interface{}{
-		"status":  "success",
-		"summary": resp.Content,
+	stats, err := s.usageStats(ctx, req.AccountID, 7)
+	if err != nil {
+		s.logger.Warn("usage stats failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load usage stats", http.StatusInternalServerError)
+		return
+	}
+
+	result := map[string]interface{}{
 		"stats": map[string]interface{}{
-			"total_sent": totalSent, "delivered": delivered,
-			"failed": failed, "spent": spent,
+			"total_sent": stats.TotalSent, "delivered": stats.Delivered,
+			"failed": stats.Failed, "spent": stats.Spent,
 		},
-	}, http.StatusOK)
+	}
+	summary, err := s.summarize(ctx, stats, req.Period)
+	if err != nil {
+		result["summary"] = summarizeStats(stats)
+		s.degrade(w, r, err, "summary failed", result)
+		return
+	}
+
+	result["status"] = "success"
+	result["summary"] = summary
+	s.jsonResponse(w, result, http.StatusOK)
 }
 
 func (s *Service) handleAccountInsights(w http.ResponseWriter, r *http.Request) {
@@ -518,9 +661,22 @@ func (s *Service) handleAccountInsights(w http.ResponseWriter, r *http.Request)
 	// Quick stats
 	var balance float64
 	var campaigns, templates int
-	s.db.QueryRow(ctx, "SELECT balance FROM accounts WHERE id = $1", accountID).Scan(&balance)
-	s.db.QueryRow(ctx, "SELECT COUNT(*) FROM campaigns WHERE account_id = $1", accountID).Scan(&campaigns)
-	s.db.QueryRow(ctx, "SELECT COUNT(*) FROM sms_templates WHERE account_id = $1", accountID).Scan(&templates)
+	err := s.db.QueryRow(ctx, "SELECT balance FROM accounts WHERE id = $1", accountID).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "account not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = s.db.QueryRow(ctx, "SELECT COUNT(*) FROM campaigns WHERE account_id = $1", accountID).Scan(&campaigns)
+	}
+	if err == nil {
+		err = s.db.QueryRow(ctx, "SELECT COUNT(*) FROM sms_templates WHERE account_id = $1", accountID).Scan(&templates)
+	}
+	if err != nil {
+		s.logger.Warn("account insights failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load account insights", http.StatusInternalServerError)
+		return
+	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"account_id":   accountID,
@@ -539,22 +695,23 @@ func (s *Service) handleChat(w http.ResponseWriter, r *http.Request) {
 		Messages []llm.Message `json:"messages"`
 		Stream   bool          `json:"stream"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
+	if !s.decodeAndValidate(w, r, &req, func() error {
+		if len(req.Messages) == 0 {
+			return errors.New("messages is required")
+		}
+		return nil
+	}) {
+		return
+	}
 
-	systemMsg := llm.Message{
-		Role: "system",
-		Content: `You are an AI assistant for the Brivas SMS platform. Help users with:
-- Composing and improving SMS messages
-- Understanding platform features
-- Campaign planning and optimization
-- Troubleshooting issues
-Be helpful, concise, and professional.`,
+	if req.Stream {
+		s.streamChat(w, r, req.Messages)
+		return
 	}
-	messages := append([]llm.Message{systemMsg}, req.Messages...)
 
-	resp, err := s.llm.Complete(ctx, &llm.CompletionRequest{Messages: messages})
+	resp, err := s.complete(ctx, PromptChat, nil, &llm.CompletionRequest{Messages: req.Messages})
 	if err != nil {
-		s.jsonError(w, "chat failed", http.StatusInternalServerError)
+		s.completionError(w, r, err, "chat failed")
 		return
 	}
 
@@ -562,19 +719,121 @@ Be helpful, concise, and professional.`,
 		"status":  "success",
 		"message": resp.Content,
 		"usage":   resp.Usage,
+		"cost":    resp.Cost,
 	}, http.StatusOK)
 }
 
+// streamChat answers a chat with server-sent events: a "content" event per
+// chunk and a final "done" event with the usage. It stops as soon as the
+// client goes away, which cancels the provider stream through the request
+// context.
+func (s *Service) streamChat(w http.ResponseWriter, r *http.Request, messages []llm.Message) {
+	ctx := r.Context()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.jsonError(w, r, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	prompt, err := s.llm.RenderPrompt(PromptChat, nil)
+	if err != nil {
+		s.completionError(w, r, err, "chat failed")
+		return
+	}
+	ch, err := s.llm.Stream(ctx, &llm.CompletionRequest{Messages: append(prompt, messages...)})
+	if err != nil {
+		s.completionError(w, r, err, "chat failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, data interface{}) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case chunk, ok := <-ch:
+			if !ok {
+				return
+			}
+			if chunk.Error != nil {
+				s.logger.Warn("chat stream failed", zap.Error(chunk.Error))
+				send("error", map[string]string{"error": "chat failed"})
+				return
+			}
+			if chunk.Content != "" {
+				send("content", map[string]string{"content": chunk.Content})
+			}
+			if chunk.Done {
+				send("done", map[string]interface{}{
+					"finish_reason": chunk.FinishReason,
+					"usage":         chunk.Usage,
+					"cost":          chunk.Cost,
+				})
+				return
+			}
+		}
+	}
+}
+
 // Helpers
 
+// jsonField unwraps an array the model returned inside a JSON object, since
+// JSON mode only produces objects. Other content is returned unchanged.
+func jsonField(content, field string) json.RawMessage {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &obj); err == nil {
+		if value, ok := obj[field]; ok {
+			return value
+		}
+	}
+	return json.RawMessage(content)
+}
+
+// decodeAndValidate decodes the JSON body into req and runs validate on it,
+// answering 400 and returning false when either fails
+func (s *Service) decodeAndValidate(w http.ResponseWriter, r *http.Request, req interface{}, validate func() error) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		msg := "invalid request body"
+		if errors.Is(err, io.EOF) {
+			msg = "request body is required"
+		}
+		s.jsonError(w, r, msg, http.StatusBadRequest)
+		return false
+	}
+	if validate != nil {
+		if err := validate(); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// requireFields takes name/value pairs and reports the first empty value
+func requireFields(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			return fmt.Errorf("%s is required", fields[i])
+		}
+	}
+	return nil
+}
+
 func (s *Service) jsonResponse(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
-func (s *Service) jsonError(w http.ResponseWriter, msg string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+func (s *Service) jsonError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	apierr.Write(w, r, msg, status)
 }
@@ -0,0 +1,629 @@
+package ai
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/brivas/unified-platform/packages/apierr"
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+	"github.com/brivas/unified-platform/packages/lumadb-client/lumadbtest"
+)
+
+func TestHandlersRejectInvalidBodies(t *testing.T) {
+	router := (&Service{}).Routes()
+
+	tests := []struct {
+		path string
+		body string
+		want string
+	}{
+		{"/sms/generate", ``, "request body is required"},
+		{"/sms/generate", `{}`, "purpose or product is required"},
+		{"/sms/improve", `{"goal":"clarity"}`, "content is required"},
+		{"/sms/translate", `{`, "invalid request body"},
+		{"/sms/translate", `{"content":"Hello"}`, "languages is required"},
+		{"/sms/translate", `{"content":"  ","languages":["yo"]}`, "content is required"},
+		{"/campaign/optimize", `{"campaign_id":"c1"}`, "current_stats is required"},
+		{"/campaign/optimize/batch", `{"campaigns":[]}`, "campaigns is required"},
+		{"/campaign/optimize/batch", `{"campaigns":[{"current_stats":{"sent":1}},{"campaign_id":"c2"}]}`, "campaigns[1]: current_stats is required"},
+		{"/sms/generate/batch", `{"requests":[{"max_length":-1,"product":"x"}]}`, "requests[0]: max_length and variations must not be negative"},
+		{"/campaign/schedule", `{}`, "account_id is required"},
+		{"/campaign/schedule", `{"account_id":"BV1","days_ahead":-1}`, "days_ahead must not be negative"},
+		{"/campaign/schedule", `{"account_id":"BV1","history_days":400}`, "history_days must be between 1 and 365"},
+		{"/campaign/segment", `{"account_id":"BV1"}`, "criteria is required"},
+		{"/fraud/analyze", ``, "request body is required"},
+		{"/fraud/analyze", `{"time_range":"7d"}`, "account_id is required"},
+		{"/fraud/analyze", `{"account_id":"BV1","time_range":"1y"}`, "time_range must be 24h, 7d or 30d"},
+		{"/fraud/score", `{"sender":"BRIVAS"}`, "message is required"},
+		{"/support/respond", `{"account_id":"BV1"}`, "query is required"},
+		{"/support/categorize", `{}`, "subject or body is required"},
+		{"/analytics/summarize", `{"account_id":"BV1","period":"hourly"}`, "period must be daily, weekly or monthly"},
+		{"/analytics/summarize", `[]`, "invalid request body"},
+		{"/chat", `{"messages":[]}`, "messages is required"},
+		{"/embed", `{"text":" "}`, "text is required"},
+		{"/embed", `{"text":"` + strings.Repeat("a", maxEmbedTextLength+1) + `"}`, "text exceeds 8000 characters"},
+		{"/embed/batch", `{"texts":[]}`, "texts is required"},
+		{"/embed/batch", `{"texts":["hi",""]}`, "texts[1] is required"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("X-Request-Id", "req-1")
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %q: expected 400, got %d", tt.path, tt.body, rec.Code)
+			continue
+		}
+		var resp apierr.Envelope
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.Error != (apierr.Error{Code: apierr.CodeInvalidRequest, Message: tt.want, RequestID: "req-1"}) {
+			t.Errorf("%s %q: expected error %q, got %+v", tt.path, tt.body, tt.want, resp.Error)
+		}
+	}
+}
+
+func TestRequireFields(t *testing.T) {
+	if err := requireFields("content", "hi", "account_id", "BV1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := requireFields("content", "hi", "account_id", ""); err == nil || err.Error() != "account_id is required" {
+		t.Errorf("expected account_id to be reported, got %v", err)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	orch, err := llm.NewOrchestrator(&llm.Config{Gemini: &llm.GeminiConfig{APIKey: "test"}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(nil, orch, zap.NewNop())
+	s.SetEmbedRateLimit(1, 3)
+	router := s.Routes()
+
+	post := func(path, body string) (*httptest.ResponseRecorder, EmbedResponse) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-Account-ID", "BV1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp EmbedResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec, resp
+	}
+
+	rec, resp := post("/embed", `{"text":"hello"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if resp.Model != "gemini/text-embedding-004" || resp.Dimensions != 768 || len(resp.Embedding) != 768 {
+		t.Errorf("unexpected embedding: model %q, %d dimensions, %d values", resp.Model, resp.Dimensions, len(resp.Embedding))
+	}
+	if _, ok := s.embedCache.get(resp.Model, "hello"); !ok {
+		t.Error("expected the embedding to be cached")
+	}
+
+	rec, resp = post("/embed/batch", `{"texts":["hello","world"]}`)
+	if rec.Code != http.StatusOK || len(resp.Embeddings) != 2 {
+		t.Fatalf("expected 2 embeddings, got %d with status %d", len(resp.Embeddings), rec.Code)
+	}
+
+	// The burst of 3 texts is spent
+	rec, _ = post("/embed", `{"text":"again"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestCachedSchedule(t *testing.T) {
+	s := &Service{scheduleTTL: time.Hour}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	compute := func(context.Context) (interface{}, error) {
+		calls.Add(1)
+		<-release
+		return "peaks", nil
+	}
+
+	// Concurrent identical requests share one computation
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := s.cachedSchedule(context.Background(), "peaks:BV1", compute); err != nil || v != "peaks" {
+				t.Errorf("unexpected result %v, %v", v, err)
+			}
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 computation, got %d", n)
+	}
+
+	// Later requests are served from the cache until it expires
+	s.cachedSchedule(context.Background(), "peaks:BV1", compute)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a cache hit, got %d computations", n)
+	}
+	s.SetScheduleCacheTTL(0)
+	s.cachedSchedule(context.Background(), "peaks:BV2", compute)
+	s.cachedSchedule(context.Background(), "peaks:BV2", compute)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected no caching with a zero TTL, got %d computations", n)
+	}
+}
+
+func TestDegradedResults(t *testing.T) {
+	// Without providers every completion fails
+	orch, err := llm.NewOrchestrator(&llm.Config{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewService(nil, orch, zap.NewNop()).Routes()
+
+	post := func(path, body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	code, resp := post("/sms/improve", `{"content":"Sale ends today"}`)
+	result, _ := resp["result"].(map[string]interface{})
+	if code != http.StatusOK || resp["degraded"] != true || result["improved"] != "Sale ends today" {
+		t.Errorf("expected the original message, degraded; got %d %v", code, resp)
+	}
+
+	code, resp = post("/support/categorize", `{"subject":"Refund","body":"I was charged twice, please fix this ASAP"}`)
+	categorization, _ := resp["categorization"].(map[string]interface{})
+	if code != http.StatusOK || resp["degraded"] != true || categorization["category"] != "billing" || categorization["priority"] != "urgent" {
+		t.Errorf("expected a keyword categorization, degraded; got %d %v", code, resp)
+	}
+
+	// Generation has no fallback
+	if code, resp = post("/sms/generate", `{"purpose":"promotional","product":"data"}`); code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d %v", code, resp)
+	}
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	llama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer llama.Close()
+	orch, err := llm.NewOrchestrator(&llm.Config{Llama: &llm.LlamaConfig{Endpoint: llama.URL}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(nil, orch, zap.NewNop())
+	if err := svc.HealthCheck(context.Background()); err != nil {
+		t.Errorf("Expected healthy, got %v", err)
+	}
+
+	svc.digestBeat.Store(time.Now().Add(-2 * digestStaleAfter).UnixNano())
+	if err := svc.HealthCheck(context.Background()); err == nil || !strings.Contains(err.Error(), "digest worker") {
+		t.Errorf("Expected a stuck digest worker, got %v", err)
+	}
+	svc.digestBeat.Store(0)
+
+	llama.Close()
+	if err := svc.HealthCheck(context.Background()); err == nil || !strings.Contains(err.Error(), "LLM unavailable") {
+		t.Errorf("Expected the LLM unavailable, got %v", err)
+	}
+}
+
+// accountUsage records the account each usage is attributed to
+type accountUsage struct {
+	mu       sync.Mutex
+	accounts []string
+}
+
+func (a *accountUsage) RecordUsage(ctx context.Context, provider, model string, usage llm.Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accounts = append(a.accounts, accountFromContext(ctx))
+}
+
+func TestChatUsageAndCost(t *testing.T) {
+	llama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"mirror-small","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":400000,"completion_tokens":100000,"total_tokens":500000}}`))
+	}))
+	defer llama.Close()
+	orch, err := llm.NewOrchestrator(&llm.Config{
+		Llama:   &llm.LlamaConfig{Endpoint: llama.URL},
+		Pricing: llm.Pricing{"mirror": {Currency: "USD", PromptPerMillion: 1, CompletionPerMillion: 2}},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &accountUsage{}
+	orch.SetUsageRecorder(recorder)
+	router := NewService(nil, orch, zap.NewNop()).Routes()
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"messages":[{"role":"user","content":"Hi"}]}`))
+	req.Header.Set("X-Account-ID", "acc_1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp struct {
+		Usage llm.Usage `json:"usage"`
+		Cost  *llm.Cost `json:"cost"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Usage.TotalTokens != 500000 {
+		t.Fatalf("Unexpected chat response %d %+v", rec.Code, resp)
+	}
+	if resp.Cost == nil || resp.Cost.Currency != "USD" || resp.Cost.Amount != 0.6 || resp.Cost.CompletionPerMillion != 2 {
+		t.Errorf("Unexpected cost %+v", resp.Cost)
+	}
+	if len(recorder.accounts) != 1 || recorder.accounts[0] != "acc_1" {
+		t.Errorf("Expected usage attributed to acc_1, got %v", recorder.accounts)
+	}
+
+	for _, days := range []string{"0", "400", "x"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/usage/acc_1?days="+days, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("days=%s: expected 400, got %d", days, rec.Code)
+		}
+	}
+}
+
+type deadLetterList struct {
+	mu      sync.Mutex
+	letters []*llm.DeadLetter
+	account string
+}
+
+func (l *deadLetterList) DeadLetter(ctx context.Context, letter *llm.DeadLetter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.letters = append(l.letters, letter)
+	l.account = accountFromContext(ctx)
+	return nil
+}
+
+func TestOptimizeCampaignsRetries(t *testing.T) {
+	var flaky atomic.Int32
+	llama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "down") || strings.Contains(string(body), "flaky") && flaky.Add(1) == 1 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"model":"llama3","choices":[{"message":{"role":"assistant","content":"{\"tips\":[]}"},"finish_reason":"stop"}]}`))
+	}))
+	defer llama.Close()
+	orch, err := llm.NewOrchestrator(&llm.Config{Llama: &llm.LlamaConfig{Endpoint: llama.URL}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(nil, orch, zap.NewNop())
+	s.SetJobRetry(llm.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	dead := &deadLetterList{}
+	s.SetDeadLetters(dead)
+
+	req := httptest.NewRequest(http.MethodPost, "/campaign/optimize/batch", strings.NewReader(`{"campaigns":[`+
+		`{"campaign_id":"c1","current_stats":{"state":"fine"}},`+
+		`{"campaign_id":"c2","current_stats":{"state":"flaky"}},`+
+		`{"campaign_id":"c3","current_stats":{"state":"down"}}]}`))
+	req.Header.Set("X-Account-ID", "BV1")
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, req)
+
+	var resp struct {
+		Status string                          `json:"status"`
+		Job    llm.JobSummary[json.RawMessage] `json:"job"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusMultiStatus || resp.Status != "partial" || resp.Job.Succeeded != 2 || resp.Job.Job != JobOptimizeCampaigns {
+		t.Fatalf("Expected 2 of 3 campaigns optimized with 207, got %d %+v", rec.Code, resp)
+	}
+	if item := resp.Job.Items[1]; item.Attempts != 2 || item.Result == nil || string(*item.Result) != `{"tips":[]}` {
+		t.Errorf("Expected the flaky campaign to succeed on retry, got %+v", item)
+	}
+	if item := resp.Job.Items[2]; !item.DeadLettered || item.Error == "" {
+		t.Errorf("Expected the failing campaign dead-lettered, got %+v", item)
+	}
+	if len(dead.letters) != 1 || dead.letters[0].Index != 2 || dead.account != "BV1" ||
+		!strings.Contains(string(dead.letters[0].Item), `"campaign_id":"c3"`) {
+		t.Errorf("Unexpected dead letters %+v for %q", dead.letters, dead.account)
+	}
+}
+
+func TestPromptExperiments(t *testing.T) {
+	// The model answers with the system prompt it was given
+	llama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []llm.Message `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		content, _ := json.Marshal(body.Messages[0].Content)
+		w.Write([]byte(`{"model":"llama3","choices":[{"message":{"role":"assistant","content":` + string(content) + `},"finish_reason":"stop"}]}`))
+	}))
+	defer llama.Close()
+	orch, err := llm.NewOrchestrator(&llm.Config{Llama: &llm.LlamaConfig{Endpoint: llama.URL}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	exposures := map[string][]driver.Value{}
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(query, "INSERT INTO prompt_experiments"):
+			now := time.Now()
+			return &lumadbtest.Rows{Columns: []string{"created_at", "updated_at"}, Data: [][]driver.Value{{now, now}}}, nil
+		case strings.Contains(query, "INSERT INTO prompt_experiment_exposures"):
+			exposures[args[0].Value.(string)] = []driver.Value{args[1].Value, args[2].Value, args[3].Value}
+		case strings.Contains(query, "INSERT INTO prompt_experiment_outcomes"):
+			if x, ok := exposures[args[0].Value.(string)]; ok && x[0] == args[1].Value && x[2] == args[4].Value {
+				return &lumadbtest.Rows{Data: [][]driver.Value{{}}}, nil
+			}
+		}
+		return nil, nil
+	})
+	s := NewService(db, orch, zap.NewNop())
+	router := s.Routes()
+	do := func(method, path, account, role, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Account-ID", account)
+		req.Header.Set("X-Role", role)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	experiment := `{"id":"tone","prompt":"support.respond","variants":[` +
+		`{"id":"formal","weight":1,"messages":[{"role":"system","content":"Be formal."},{"role":"user","content":"{{.Query}}"}]},` +
+		`{"id":"warm","weight":1,"messages":[{"role":"system","content":"Be warm."},{"role":"user","content":"{{.Query}}"}]}]}`
+	if rec := do(http.MethodPost, "/experiments", "BV1", "user", experiment); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected non-admins refused with 403, got %d", rec.Code)
+	}
+	for body, want := range map[string]string{
+		`{"id":"tone","prompt":"nope","variants":[{"id":"a","weight":1,"messages":[{"role":"user","content":"x"}]},{"id":"b","weight":1,"messages":[{"role":"user","content":"y"}]}]}`:            `unknown prompt "nope"`,
+		`{"id":"tone","prompt":"support.respond","variants":[{"id":"a","weight":1,"messages":[{"role":"user","content":"x"}]}]}`:                                                                  "an experiment needs 2 to 10 variants",
+		`{"id":"tone","prompt":"support.respond","variants":[{"id":"a","weight":0,"messages":[{"role":"user","content":"x"}]},{"id":"b","weight":1,"messages":[{"role":"user","content":"y"}]}]}`: "variants[0]: weight must be at least 1",
+	} {
+		rec := do(http.MethodPost, "/experiments", "", "admin", body)
+		var resp apierr.Envelope
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusBadRequest || resp.Error.Message != want {
+			t.Errorf("Expected 400 %q, got %d %q", want, rec.Code, resp.Error.Message)
+		}
+	}
+	if rec := do(http.MethodPost, "/experiments", "", "admin", experiment); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the experiment created, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Each account keeps its variant, and the response is tagged with it
+	seen := map[string]bool{}
+	var tagged ExperimentAssignment
+	for i := 0; i < 20; i++ {
+		account := fmt.Sprintf("BV%d", i)
+		var variants []string
+		for j := 0; j < 2; j++ {
+			rec := do(http.MethodPost, "/support/respond", account, "user", `{"query":"How do I top up?"}`)
+			var resp struct {
+				Response   string                `json:"response"`
+				Experiment *ExperimentAssignment `json:"experiment"`
+			}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if rec.Code != http.StatusOK || resp.Experiment == nil || resp.Experiment.ExperimentID != "tone" {
+				t.Fatalf("Expected a tagged response, got %d %+v", rec.Code, resp)
+			}
+			if want := map[string]string{"formal": "Be formal.", "warm": "Be warm."}[resp.Experiment.VariantID]; resp.Response != want {
+				t.Errorf("Variant %s answered %q", resp.Experiment.VariantID, resp.Response)
+			}
+			variants = append(variants, resp.Experiment.VariantID)
+			tagged = *resp.Experiment
+		}
+		if variants[0] != variants[1] {
+			t.Errorf("Expected %s to keep its variant, got %v", account, variants)
+		}
+		seen[variants[0]] = true
+	}
+	if !seen["formal"] || !seen["warm"] {
+		t.Errorf("Expected both variants served, got %v", seen)
+	}
+	if len(exposures) != 40 {
+		t.Errorf("Expected 40 exposures recorded, got %d", len(exposures))
+	}
+
+	// Requests without an account get the prompt as usual
+	rec := do(http.MethodPost, "/support/respond", "", "user", `{"query":"How do I top up?"}`)
+	if strings.Contains(rec.Body.String(), `"experiment"`) || !strings.Contains(rec.Body.String(), "Brivas") {
+		t.Errorf("Expected the default prompt, got %s", rec.Body.String())
+	}
+
+	// Outcomes are accepted for the account's own responses
+	outcome := `{"response_id":"` + tagged.ResponseID + `","success":true,"score":5}`
+	if rec := do(http.MethodPost, "/experiments/tone/outcomes", "BV19", "user", outcome); rec.Code != http.StatusOK {
+		t.Errorf("Expected the outcome recorded, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/experiments/tone/outcomes", "BV3", "user", outcome); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected another account's response not found, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/experiments/tone/outcomes", "BV19", "user", `{"response_id":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a missing success rejected, got %d", rec.Code)
+	}
+
+	// Stopping the experiment restores the prompt
+	s.installExperiment(&PromptExperiment{ID: "tone", Prompt: PromptSupportResponse})
+	rec = do(http.MethodPost, "/support/respond", "BV1", "user", `{"query":"How do I top up?"}`)
+	if strings.Contains(rec.Body.String(), `"experiment"`) {
+		t.Errorf("Expected no experiment after stopping, got %s", rec.Body.String())
+	}
+}
+
+func TestCategorizeTicketByKeywords(t *testing.T) {
+	tests := []struct {
+		subject, body                 string
+		category, priority, sentiment string
+	}{
+		{"Sender ID approval", "How long does it take?", "sender_id", "medium", "neutral"},
+		{"Webhook", "Our rapid integration returns 401", "api", "medium", "neutral"},
+		{"Messages not delivered", "Terrible, this is not working", "technical", "high", "negative"},
+		{"Hello", "Thanks for the great service", "other", "medium", "positive"},
+	}
+	for _, tt := range tests {
+		got := categorizeTicketByKeywords(tt.subject, tt.body)
+		if got["category"] != tt.category || got["priority"] != tt.priority || got["sentiment"] != tt.sentiment {
+			t.Errorf("%q: got %v/%v/%v, want %s/%s/%s", tt.subject, got["category"], got["priority"], got["sentiment"],
+				tt.category, tt.priority, tt.sentiment)
+		}
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	for _, expr := range []string{"0 8 * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edt, est := time.FixedZone("EDT", -4*3600), time.FixedZone("EST", -5*3600)
+
+	for _, tc := range []struct {
+		name, expr string
+		from, want time.Time
+	}{
+		{"later today", "0 8 * * *", time.Date(2024, 1, 1, 7, 59, 0, 0, lagos), time.Date(2024, 1, 1, 8, 0, 0, 0, lagos)},
+		{"strictly after", "0 8 * * *", time.Date(2024, 1, 1, 8, 0, 0, 0, lagos), time.Date(2024, 1, 2, 8, 0, 0, 0, lagos)},
+		{"step", "*/15 * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, lagos), time.Date(2024, 1, 1, 10, 15, 0, 0, lagos)},
+		{"weekdays", "0 9 * * 1-5", time.Date(2024, 1, 5, 10, 0, 0, 0, lagos), time.Date(2024, 1, 8, 9, 0, 0, 0, lagos)},
+		{"7 is Sunday", "0 0 * * 7", time.Date(2024, 1, 3, 0, 0, 0, 0, lagos), time.Date(2024, 1, 7, 0, 0, 0, 0, lagos)},
+		{"0 is Sunday", "0 0 * * 0", time.Date(2024, 1, 3, 0, 0, 0, 0, lagos), time.Date(2024, 1, 7, 0, 0, 0, 0, lagos)},
+		{"Friday or the 13th: Friday", "0 0 13 * 5", time.Date(2024, 1, 6, 0, 0, 0, 0, lagos), time.Date(2024, 1, 12, 0, 0, 0, 0, lagos)},
+		{"Friday or the 13th: 13th", "0 0 13 * 5", time.Date(2024, 1, 12, 0, 0, 0, 0, lagos), time.Date(2024, 1, 13, 0, 0, 0, 0, lagos)},
+		{"the 13th, any weekday", "0 0 13 * *", time.Date(2024, 1, 6, 0, 0, 0, 0, lagos), time.Date(2024, 1, 13, 0, 0, 0, 0, lagos)},
+		{"Fridays, any day", "0 0 * * 5", time.Date(2024, 1, 12, 0, 0, 0, 0, lagos), time.Date(2024, 1, 19, 0, 0, 0, 0, lagos)},
+		{"leap day", "0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, lagos), time.Date(2028, 2, 29, 0, 0, 0, 0, lagos)},
+		{"never", "0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, lagos), time.Time{}},
+		{"DST skipped hour", "30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"DST after the gap", "0 3 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"DST repeated hour", "30 1 * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, newYork), time.Date(2024, 11, 3, 1, 30, 0, 0, edt)},
+		{"DST repeated hour runs once", "30 1 * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, edt).In(newYork), time.Date(2024, 11, 4, 1, 30, 0, 0, est)},
+		{"DST after the repeated hour", "*/30 * * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, edt).In(newYork), time.Date(2024, 11, 3, 2, 0, 0, 0, est)},
+	} {
+		schedule, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := schedule.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%s: %q after %v: expected %v, got %v", tc.name, tc.expr, tc.from, tc.want, got)
+		}
+	}
+}
+
+// webhookQueue records enqueued webhooks
+type webhookQueue struct {
+	mu       sync.Mutex
+	payloads map[string][]byte
+}
+
+func (q *webhookQueue) Enqueue(ctx context.Context, accountID, url, event string, payload []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.payloads[accountID+" "+url+" "+event] = payload
+	return nil
+}
+
+func TestSendDueDigests(t *testing.T) {
+	var claimed []driver.NamedValue
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.Contains(query, "SELECT account_id, cron"):
+			return &lumadbtest.Rows{
+				Columns: []string{"account_id", "cron", "timezone", "period_days", "webhook_url"},
+				Data: [][]driver.Value{
+					{"acc_1", "0 8 * * *", "Africa/Lagos", int64(7), "https://hooks.example.com/digest"},
+					{"acc_2", "not cron", "Africa/Lagos", int64(1), "https://hooks.example.com/digest"},
+				},
+			}, nil
+		case strings.HasPrefix(strings.TrimSpace(query), "UPDATE digest_schedules"):
+			claimed = args
+			return &lumadbtest.Rows{Data: [][]driver.Value{{}}}, nil
+		case strings.Contains(query, "SELECT COUNT(*)"):
+			return &lumadbtest.Rows{
+				Columns: []string{"count", "delivered", "failed", "spent"},
+				Data:    [][]driver.Value{{int64(10), int64(8), int64(2), 25.0}},
+			}, nil
+		case strings.Contains(query, "GROUP BY 1, 2"):
+			return &lumadbtest.Rows{
+				Columns: []string{"sender", "sms_type", "count"},
+				Data:    [][]driver.Value{{"BRIVAS", "promotional", int64(2)}},
+			}, nil
+		}
+		return &lumadbtest.Rows{}, nil
+	})
+
+	// Without providers the summary fails, leaving a stats-only digest
+	orch, err := llm.NewOrchestrator(&llm.Config{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(db, orch, zap.NewNop())
+
+	// Nothing is claimed until there is somewhere to deliver to
+	svc.sendDueDigests(context.Background())
+	if len(fake.Queries()) != 0 {
+		t.Fatalf("Expected no queries without a webhook sender, got %v", fake.Queries())
+	}
+
+	queue := &webhookQueue{payloads: make(map[string][]byte)}
+	svc.SetWebhookSender(queue)
+	svc.sendDueDigests(context.Background())
+
+	payload, ok := queue.payloads["acc_1 https://hooks.example.com/digest "+EventDigest]
+	if len(queue.payloads) != 1 || !ok {
+		t.Fatalf("Expected only acc_1's digest, got %v", queue.payloads)
+	}
+	var delivered struct {
+		Event string `json:"event"`
+		Data  Digest `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &delivered); err != nil {
+		t.Fatal(err)
+	}
+	digest := delivered.Data
+	if delivered.Event != EventDigest || digest.PeriodDays != 7 || digest.Summary != "" {
+		t.Errorf("Expected a stats-only weekly digest, got %+v", delivered)
+	}
+	if s := digest.Stats; s == nil || s.TotalSent != 10 || s.DeliveryRate != 80 || len(s.TopFailures) != 1 {
+		t.Errorf("Unexpected stats %+v", digest.Stats)
+	}
+	if len(claimed) != 2 || claimed[0].Value != "acc_1" {
+		t.Fatalf("Expected acc_1's run claimed, got %v", claimed)
+	}
+	if next, ok := claimed[1].Value.(time.Time); !ok || !next.After(time.Now()) || next.In(time.UTC).Hour() != 7 {
+		t.Errorf("Expected the next run at 08:00 Lagos time, got %v", claimed[1].Value)
+	}
+}
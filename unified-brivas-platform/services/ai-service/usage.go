@@ -0,0 +1,177 @@
+package ai
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	llm "github.com/brivas/unified-platform/packages/llm-orchestrator"
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Period GET /usage/{account_id} reports on, in days
+const (
+	defaultUsageDays = 30
+	maxUsageDays     = 366
+)
+
+// smsCurrency is what SMS is billed in
+const smsCurrency = "NGN"
+
+type accountKey struct{}
+
+// WithAccount attributes the LLM usage of requests made with ctx to
+// accountID. Routes does this for the account the auth middleware resolved.
+func WithAccount(ctx context.Context, accountID string) context.Context {
+	return context.WithValue(ctx, accountKey{}, accountID)
+}
+
+func accountFromContext(ctx context.Context) string {
+	accountID, _ := ctx.Value(accountKey{}).(string)
+	return accountID
+}
+
+// withRequestAccount attributes each request's LLM usage to its account
+func withRequestAccount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accountID := r.Header.Get("X-Account-ID"); accountID != "" {
+			r = r.WithContext(WithAccount(r.Context(), accountID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UsageStore is an llm.UsageRecorder keeping the token usage and estimated
+// cost of every completion in LumaDB, per account, for GET
+// /usage/{account_id}. Install it with the orchestrator's SetUsageRecorder.
+// Usage without an account, see WithAccount, is not recorded.
+type UsageStore struct {
+	db     lumadb.Querier
+	llm    *llm.Orchestrator
+	logger *zap.Logger
+}
+
+// NewUsageStore creates a usage recorder writing to the ai_usage table,
+// with costs estimated from orch's pricing
+func NewUsageStore(db lumadb.Querier, orch *llm.Orchestrator, logger *zap.Logger) *UsageStore {
+	return &UsageStore{db: db, llm: orch, logger: logger}
+}
+
+// RecordUsage stores usage against the account in ctx
+func (u *UsageStore) RecordUsage(ctx context.Context, provider, model string, usage llm.Usage) {
+	accountID := accountFromContext(ctx)
+	if accountID == "" {
+		return
+	}
+	var cost sql.NullFloat64
+	var currency sql.NullString
+	if c := u.llm.EstimateCost(provider, model, usage); c != nil {
+		cost = sql.NullFloat64{Float64: c.Amount, Valid: true}
+		currency = sql.NullString{String: c.Currency, Valid: true}
+	}
+	// Usage is recorded once the response is complete, which may be after
+	// a streaming client went away
+	if _, err := u.db.Exec(context.WithoutCancel(ctx), `
+		INSERT INTO ai_usage (account_id, provider, model, prompt_tokens, completion_tokens, total_tokens, cost, currency)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8)
+	`, accountID, provider, model, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, cost, currency); err != nil {
+		u.logger.Warn("failed to record AI usage", zap.String("account_id", accountID), zap.Error(err))
+	}
+}
+
+// ModelUsage is an account's usage of one model
+type ModelUsage struct {
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	Requests int       `json:"requests"`
+	Usage    llm.Usage `json:"usage"`
+	// Cost is nil for models without a price
+	Cost *llm.Cost `json:"cost,omitempty"`
+}
+
+// handleAccountUsage reports an account's AI usage and spend over the
+// last ?days= days, alongside its SMS spend
+func (s *Service) handleAccountUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID := chi.URLParam(r, "account_id")
+	days := defaultUsageDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUsageDays {
+			s.jsonError(w, r, "days must be between 1 and "+strconv.Itoa(maxUsageDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT provider, COALESCE(model, ''), currency, COUNT(*),
+			SUM(prompt_tokens), SUM(completion_tokens), SUM(total_tokens), SUM(cost)
+		FROM ai_usage
+		WHERE account_id = $1 AND created_at >= NOW() - $2 * INTERVAL '1 day'
+		GROUP BY provider, model, currency
+		ORDER BY SUM(cost) DESC NULLS LAST, provider, model
+	`, accountID, days)
+	if err != nil {
+		s.logger.Warn("AI usage query failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load AI usage", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	models := make([]ModelUsage, 0)
+	var total llm.Usage
+	var requests int
+	spent := make(map[string]float64)
+	for rows.Next() {
+		var m ModelUsage
+		var currency sql.NullString
+		var cost sql.NullFloat64
+		if err := rows.Scan(&m.Provider, &m.Model, &currency, &m.Requests,
+			&m.Usage.PromptTokens, &m.Usage.CompletionTokens, &m.Usage.TotalTokens, &cost); err != nil {
+			s.logger.Warn("AI usage scan failed", zap.Error(err))
+			s.jsonError(w, r, "failed to load AI usage", http.StatusInternalServerError)
+			return
+		}
+		if currency.Valid && cost.Valid {
+			m.Cost = &llm.Cost{Currency: currency.String, Amount: cost.Float64}
+			spent[currency.String] += cost.Float64
+		}
+		requests += m.Requests
+		total.PromptTokens += m.Usage.PromptTokens
+		total.CompletionTokens += m.Usage.CompletionTokens
+		total.TotalTokens += m.Usage.TotalTokens
+		models = append(models, m)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Warn("AI usage query failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load AI usage", http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := s.usageStats(ctx, accountID, days)
+	if err != nil {
+		s.logger.Warn("SMS usage query failed", zap.Error(err))
+		s.jsonError(w, r, "failed to load SMS usage", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"account_id": accountID,
+		"days":       days,
+		"ai": map[string]interface{}{
+			"requests": requests,
+			"usage":    total,
+			"spent":    spent,
+			"models":   models,
+		},
+		"sms": map[string]interface{}{
+			"messages": stats.TotalSent,
+			"spent":    map[string]float64{smsCurrency: stats.Spent},
+		},
+	}, http.StatusOK)
+}
@@ -0,0 +1,730 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Campaign states. A draft is launched either straight to running or, with
+// a future scheduled_at, to scheduled until it is due. A running campaign
+// can be paused, and a paused or failed one resumed.
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignRunning   = "running"
+	CampaignPaused    = "paused"
+	CampaignCompleted = "completed"
+	CampaignFailed    = "failed"
+)
+
+const (
+	// maxCampaignRecipients caps a campaign's recipient list
+	maxCampaignRecipients = 100000
+	// campaignBatchSize is how many recipients go to the provider at once,
+	// and so the size of a campaign's checkpointed chunks
+	campaignBatchSize = 1000
+	// DefaultCampaignPollInterval is how often scheduled campaigns and
+	// messages are checked
+	DefaultCampaignPollInterval = 30 * time.Second
+)
+
+// Campaign is a bulk send with its recipients, schedule and progress
+type Campaign struct {
+	ID              string     `json:"id"`
+	AccountID       string     `json:"account_id"`
+	Name            string     `json:"name"`
+	TemplateID      string     `json:"template_id,omitempty"`
+	SenderID        string     `json:"sender_id,omitempty"`
+	Message         string     `json:"message,omitempty"`
+	Type            string     `json:"type"`
+	Recipients      []string   `json:"recipients"`
+	TrackLinks      bool       `json:"track_links"`
+	Status          string     `json:"status"`
+	IsLive          bool       `json:"is_live"`
+	SID             string     `json:"sid,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	ScheduledAt     *time.Time `json:"scheduled_at,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	TotalRecipients int        `json:"total_recipients"`
+	SentCount       int        `json:"sent_count"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	// SenderType and Encoding describe SenderID and Message as they will
+	// be sent, for the message to be adjusted before launch
+	SenderType string           `json:"sender_type,omitempty"`
+	Encoding   *MessageEncoding `json:"encoding,omitempty"`
+}
+
+// CampaignProgress is a campaign's delivery state derived from sms_history
+type CampaignProgress struct {
+	CampaignID string `json:"campaign_id"`
+	Status     string `json:"status"`
+	Total      int    `json:"total"`
+	Sent       int    `json:"sent"`
+	Delivered  int    `json:"delivered"`
+	Failed     int    `json:"failed"`
+	Pending    int    `json:"pending"`
+	// Clicks counts tracked link clicks; ClickedRecipients how many
+	// recipients clicked at least once
+	Clicks            int `json:"clicks"`
+	ClickedRecipients int `json:"clicked_recipients"`
+	// Chunks are the batches the campaign is sent in, once it has run
+	Chunks []CampaignChunk `json:"chunks"`
+}
+
+// campaignInput is the editable part of a campaign
+type campaignInput struct {
+	Name        string     `json:"name"`
+	TemplateID  string     `json:"template_id,omitempty"`
+	SenderID    string     `json:"sender_id,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	Type        string     `json:"type"`
+	Recipients  []string   `json:"recipients"`
+	TrackLinks  bool       `json:"track_links,omitempty"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+}
+
+func (in *campaignInput) validate() error {
+	if in.Type == "" {
+		in.Type = "promotional"
+	}
+	switch {
+	case in.Name == "":
+		return errors.New("name is required")
+	case in.Message == "" && in.TemplateID == "":
+		return errors.New("message or template_id is required")
+	case !senderIDTypes[in.Type]:
+		return errors.New("type must be promotional, transactional or corporate")
+	case len(in.Recipients) == 0:
+		return errors.New("recipients are required")
+	case len(in.Recipients) > maxCampaignRecipients:
+		return fmt.Errorf("max %d recipients", maxCampaignRecipients)
+	}
+	if in.SenderID != "" {
+		if _, err := checkSender(in.SenderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const campaignColumns = `campaign_id, account_id, name, COALESCE(template_id, ''), COALESCE(sender_id, ''),
+	COALESCE(message, ''), COALESCE(sms_type, 'promotional'), COALESCE(recipients, '[]'), COALESCE(track_links, FALSE),
+	COALESCE(status, 'draft'), COALESCE(is_live, FALSE), COALESCE(sid, ''), COALESCE(error, ''),
+	scheduled_at, started_at, completed_at, COALESCE(total_recipients, 0), COALESCE(sent_count, 0),
+	created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCampaign(row rowScanner) (*Campaign, error) {
+	var c Campaign
+	var recipients []byte
+	var scheduledAt, startedAt, completedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.AccountID, &c.Name, &c.TemplateID, &c.SenderID,
+		&c.Message, &c.Type, &recipients, &c.TrackLinks,
+		&c.Status, &c.IsLive, &c.SID, &c.Error,
+		&scheduledAt, &startedAt, &completedAt, &c.TotalRecipients, &c.SentCount,
+		&c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(recipients, &c.Recipients); err != nil {
+		return nil, fmt.Errorf("invalid recipients for campaign %s: %w", c.ID, err)
+	}
+	if c.SenderID != "" {
+		c.SenderType = senderKind(c.SenderID)
+	}
+	if c.Message != "" {
+		enc := messageEncoding(c.Message)
+		c.Encoding = &enc
+	}
+	for _, t := range []struct {
+		src sql.NullTime
+		dst **time.Time
+	}{{scheduledAt, &c.ScheduledAt}, {startedAt, &c.StartedAt}, {completedAt, &c.CompletedAt}} {
+		if t.src.Valid {
+			v := t.src.Time
+			*t.dst = &v
+		}
+	}
+	return &c, nil
+}
+
+// handleCreateCampaign creates a draft campaign
+func (s *Service) handleCreateCampaign(w http.ResponseWriter, r *http.Request) {
+	var in campaignInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := in.validate(); err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipients, _ := json.Marshal(in.Recipients)
+
+	ctx := r.Context()
+	c, err := scanCampaign(s.db.QueryRow(ctx, `
+		INSERT INTO campaigns (campaign_id, account_id, name, template_id, sender_id, message,
+			sms_type, recipients, total_recipients, scheduled_at, track_links, status)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10, $11, 'draft')
+		RETURNING `+campaignColumns,
+		"CMP-"+uuid.NewString(), r.Header.Get("X-Account-ID"), in.Name, in.TemplateID, in.SenderID,
+		in.Message, in.Type, recipients, len(in.Recipients), in.ScheduledAt, in.TrackLinks))
+	if err != nil {
+		s.logger.Error("failed to create campaign", zap.Error(err))
+		s.jsonError(w, r, "failed to create campaign", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "campaign created",
+		"data":   c,
+	}, http.StatusCreated)
+}
+
+// handleListCampaigns lists the account's campaigns, optionally filtered by
+// ?status=
+func (s *Service) handleListCampaigns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := s.db.Query(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+		WHERE account_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+	`, r.Header.Get("X-Account-ID"), r.URL.Query().Get("status"))
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch campaigns", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	campaigns := make([]*Campaign, 0)
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			continue
+		}
+		campaigns = append(campaigns, c)
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   campaigns,
+	}, http.StatusOK)
+}
+
+// getCampaign loads one of the account's campaigns; nil if there is none
+func (s *Service) getCampaign(ctx context.Context, accountID, id string) (*Campaign, error) {
+	c, err := scanCampaign(s.db.QueryRow(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns WHERE campaign_id = $1 AND account_id = $2
+	`, id, accountID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
+
+// handleGetCampaign returns one campaign
+func (s *Service) handleGetCampaign(w http.ResponseWriter, r *http.Request) {
+	c, err := s.getCampaign(r.Context(), r.Header.Get("X-Account-ID"), chi.URLParam(r, "id"))
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch campaign", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		s.jsonError(w, r, "campaign not found", http.StatusNotFound)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   c,
+	}, http.StatusOK)
+}
+
+// handleUpdateCampaign replaces a draft campaign's content and recipients
+func (s *Service) handleUpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	var in campaignInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := in.validate(); err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipients, _ := json.Marshal(in.Recipients)
+
+	ctx := r.Context()
+	accountID, id := r.Header.Get("X-Account-ID"), chi.URLParam(r, "id")
+	c, err := scanCampaign(s.db.QueryRow(ctx, `
+		UPDATE campaigns
+		SET name = $3, template_id = NULLIF($4, ''), sender_id = NULLIF($5, ''), message = $6,
+			sms_type = $7, recipients = $8, total_recipients = $9, scheduled_at = $10, track_links = $11,
+			updated_at = NOW()
+		WHERE campaign_id = $1 AND account_id = $2 AND status = 'draft'
+		RETURNING `+campaignColumns,
+		id, accountID, in.Name, in.TemplateID, in.SenderID,
+		in.Message, in.Type, recipients, len(in.Recipients), in.ScheduledAt, in.TrackLinks))
+	if errors.Is(err, sql.ErrNoRows) {
+		s.campaignStateError(w, r, accountID, id, "only draft campaigns can be edited")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to update campaign", zap.String("campaign_id", id), zap.Error(err))
+		s.jsonError(w, r, "failed to update campaign", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "campaign updated",
+		"data":   c,
+	}, http.StatusOK)
+}
+
+// handleDeleteCampaign deletes a campaign that is not running
+func (s *Service) handleDeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID, id := r.Header.Get("X-Account-ID"), chi.URLParam(r, "id")
+	res, err := s.db.Exec(ctx, `
+		DELETE FROM campaigns WHERE campaign_id = $1 AND account_id = $2 AND status <> 'running'
+	`, id, accountID)
+	if err != nil {
+		s.jsonError(w, r, "failed to delete campaign", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.campaignStateError(w, r, accountID, id, "running campaigns cannot be deleted")
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "campaign deleted",
+	}, http.StatusOK)
+}
+
+// campaignStateError answers a conditional write that matched nothing: the
+// campaign is missing, or in a state that does not allow the change
+func (s *Service) campaignStateError(w http.ResponseWriter, r *http.Request, accountID, id, msg string) {
+	c, err := s.getCampaign(r.Context(), accountID, id)
+	switch {
+	case err != nil:
+		s.jsonError(w, r, "failed to fetch campaign", http.StatusInternalServerError)
+	case c == nil:
+		s.jsonError(w, r, "campaign not found", http.StatusNotFound)
+	default:
+		s.jsonError(w, r, msg+" (campaign is "+c.Status+")", http.StatusConflict)
+	}
+}
+
+// handleLaunchCampaign starts a draft campaign, or schedules it when its
+// scheduled_at is in the future. Sending runs in the background; progress
+// is reported by handleCampaignProgress.
+func (s *Service) handleLaunchCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID, id := r.Header.Get("X-Account-ID"), chi.URLParam(r, "id")
+	isLive := r.Header.Get("X-Is-Live") == "true"
+
+	c, err := s.getCampaign(ctx, accountID, id)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch campaign", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		s.jsonError(w, r, "campaign not found", http.StatusNotFound)
+		return
+	}
+	if !isLive && len(c.Recipients) > 5 {
+		s.jsonError(w, r, "max 5 recipients in test mode", http.StatusBadRequest)
+		return
+	}
+
+	// Warn about a near-copy of a recent campaign unless confirmed
+	now := time.Now()
+	var vector []float64
+	if message, err := s.campaignMessage(ctx, c); err == nil {
+		var duplicates []DuplicateCampaign
+		vector, duplicates, err = s.duplicates.find(ctx, c, message, now)
+		if err != nil {
+			s.logger.Warn("duplicate campaign check failed", zap.String("campaign_id", id), zap.Error(err))
+		}
+		if len(duplicates) > 0 && r.URL.Query().Get("confirm") != "true" {
+			s.jsonResponse(w, map[string]interface{}{
+				"status": "confirmation_required",
+				"msg":    "a similar campaign was launched recently; launch again with ?confirm=true to send anyway",
+				"data":   map[string]interface{}{"duplicates": duplicates},
+			}, http.StatusConflict)
+			return
+		}
+	}
+
+	if c.ScheduledAt != nil && c.ScheduledAt.After(time.Now()) {
+		c, err = scanCampaign(s.db.QueryRow(ctx, `
+			UPDATE campaigns SET status = 'scheduled', is_live = $3, updated_at = NOW()
+			WHERE campaign_id = $1 AND account_id = $2 AND status = 'draft'
+			RETURNING `+campaignColumns, id, accountID, isLive))
+	} else {
+		c, err = s.claimCampaign(ctx, `campaign_id = $1 AND account_id = $2 AND status IN ('draft', 'scheduled')`, id, accountID, isLive)
+		if err == nil {
+			go s.runCampaign(context.WithoutCancel(ctx), c)
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		s.campaignStateError(w, r, accountID, id, "campaign already launched")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to launch campaign", zap.String("campaign_id", id), zap.Error(err))
+		s.jsonError(w, r, "failed to launch campaign", http.StatusInternalServerError)
+		return
+	}
+	if err := s.duplicates.record(ctx, c, vector, now); err != nil {
+		s.logger.Warn("failed to store campaign embedding", zap.String("campaign_id", id), zap.Error(err))
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "campaign " + c.Status,
+		"data":   c,
+	}, http.StatusAccepted)
+}
+
+// claimCampaign moves a campaign matching where to running and assigns its
+// batch SID. The conditional update keeps two launchers from both sending.
+func (s *Service) claimCampaign(ctx context.Context, where string, id, accountID string, isLive bool) (*Campaign, error) {
+	return scanCampaign(s.db.QueryRow(ctx, `
+		UPDATE campaigns
+		SET status = 'running', is_live = $3, started_at = NOW(), updated_at = NOW(), error = NULL,
+			sid = $4
+		WHERE `+where+`
+		RETURNING `+campaignColumns, id, accountID, isLive, s.generateSID(accountID, "CAMPAIGN")))
+}
+
+// runCampaign sends a running campaign chunk by chunk and records the
+// outcome. It stops early when the campaign is paused; the last runner to
+// finish a campaign completes it.
+func (s *Service) runCampaign(ctx context.Context, c *Campaign) {
+	if !s.startCampaignRunner(c.ID) {
+		return
+	}
+	defer s.stopCampaignRunner(c.ID)
+
+	err := s.dispatchCampaign(ctx, c)
+	if errors.Is(err, errCampaignStopped) {
+		s.logger.Info("campaign runner stopped", zap.String("campaign_id", c.ID))
+		return
+	}
+	if err != nil {
+		s.logger.Warn("campaign failed", zap.String("campaign_id", c.ID), zap.Error(err))
+		if _, err := s.db.Exec(ctx, `
+			UPDATE campaigns
+			SET status = 'failed', error = $2, completed_at = NOW(), updated_at = NOW()
+			WHERE campaign_id = $1 AND status = 'running'
+		`, c.ID, err.Error()); err != nil {
+			s.logger.Error("failed to record campaign outcome", zap.String("campaign_id", c.ID), zap.Error(err))
+		}
+		return
+	}
+	if _, err := s.db.Exec(ctx, `
+		UPDATE campaigns SET status = 'completed', completed_at = NOW(), updated_at = NOW()
+		WHERE campaign_id = $1 AND status = 'running'
+			AND NOT EXISTS (
+				SELECT 1 FROM campaign_chunks WHERE campaign_id = $1 AND status IN ('pending', 'sending')
+			)
+	`, c.ID); err != nil {
+		s.logger.Error("failed to record campaign outcome", zap.String("campaign_id", c.ID), zap.Error(err))
+	}
+}
+
+// campaignMessage is the campaign's message, or its template's content
+func (s *Service) campaignMessage(ctx context.Context, c *Campaign) (string, error) {
+	if c.Message != "" {
+		return c.Message, nil
+	}
+	var message string
+	err := s.db.QueryRow(ctx, `SELECT content FROM sms_templates WHERE template_id = $1`, c.TemplateID).Scan(&message)
+	if err != nil {
+		return "", fmt.Errorf("failed to load template %s: %w", c.TemplateID, err)
+	}
+	return message, nil
+}
+
+// dispatchCampaign sends the campaign's chunks until none is left to
+// claim, checking before each that the campaign is still running
+func (s *Service) dispatchCampaign(ctx context.Context, c *Campaign) error {
+	message, err := s.campaignMessage(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := s.createCampaignChunks(ctx, c); err != nil {
+		return err
+	}
+
+	for {
+		var status string
+		err := s.db.QueryRow(ctx, `SELECT status FROM campaigns WHERE campaign_id = $1`, c.ID).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && status != CampaignRunning) {
+			return errCampaignStopped
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check campaign status: %w", err)
+		}
+
+		chunk, err := s.claimCampaignChunk(ctx, c.ID)
+		if err != nil || chunk == nil {
+			return err
+		}
+		sent, err := s.sendCampaignChunk(ctx, c, message, chunk)
+		if err != nil {
+			if cpErr := s.finishCampaignChunk(ctx, c.ID, chunk.index, ChunkFailed, sent, err.Error()); cpErr != nil {
+				s.logger.Error("failed to checkpoint campaign chunk", zap.String("campaign_id", c.ID), zap.Error(cpErr))
+			}
+			return fmt.Errorf("chunk %d: %w", chunk.index, err)
+		}
+		if err := s.finishCampaignChunk(ctx, c.ID, chunk.index, ChunkSent, sent, ""); err != nil {
+			return err
+		}
+	}
+}
+
+// sendCampaignChunk sends one chunk, returning how many messages went out
+func (s *Service) sendCampaignChunk(ctx context.Context, c *Campaign, message string, chunk *claimedChunk) (int, error) {
+	to := chunk.recipients
+	if chunk.attempts > 1 {
+		var err error
+		if to, err = s.unsentRecipients(ctx, c, to); err != nil {
+			return 0, err
+		}
+		if len(to) == 0 {
+			return 0, nil
+		}
+	}
+
+	req := &BulkSendRequest{
+		AccountID: c.AccountID,
+		From:      c.SenderID,
+		To:        to,
+		Message:   message,
+		Type:      c.Type,
+	}
+	if c.TrackLinks && linkPattern.MatchString(message) {
+		if err := s.trackCampaignLinks(ctx, c, req); err != nil {
+			return 0, err
+		}
+	}
+	msgs, _, err := s.sendBulk(ctx, c.AccountID, c.IsLive, c.SID, req)
+	if errors.Is(err, errNoRecipients) {
+		return 0, nil // Every recipient in the chunk was skipped
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
+
+// trackCampaignLinks gives each recipient in req its own short links, so
+// clicks can be attributed
+func (s *Service) trackCampaignLinks(ctx context.Context, c *Campaign, req *BulkSendRequest) error {
+	req.Bodies = make(map[string]string, len(req.To))
+	var links []ShortLink
+	for _, to := range req.To {
+		formatted := s.formatNumber(to)
+		body, recipientLinks := s.shortenLinks(req.Message, c.AccountID, c.ID, formatted)
+		req.Bodies[formatted] = body
+		links = append(links, recipientLinks...)
+	}
+	return s.saveShortLinks(ctx, links)
+}
+
+// startCampaignScheduler launches scheduled campaigns and sends scheduled
+// single messages once they are due, and resumes interrupted campaigns,
+// until ctx is cancelled
+func (s *Service) startCampaignScheduler(ctx context.Context, interval time.Duration) {
+	s.resumeCampaigns(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.launchDueCampaigns(ctx)
+			s.resumeCampaigns(ctx)
+			s.sendDueMessages(ctx)
+			s.beat(workerCampaignScheduler)
+		}
+	}
+}
+
+func (s *Service) launchDueCampaigns(ctx context.Context) {
+	rows, err := s.db.Query(ctx, `
+		SELECT campaign_id, account_id, COALESCE(is_live, FALSE) FROM campaigns
+		WHERE status = 'scheduled' AND scheduled_at <= NOW()
+	`)
+	if err != nil {
+		s.logger.Warn("failed to load due campaigns", zap.Error(err))
+		return
+	}
+	type due struct {
+		id, accountID string
+		isLive        bool
+	}
+	var campaigns []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.accountID, &d.isLive); err == nil {
+			campaigns = append(campaigns, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range campaigns {
+		c, err := s.claimCampaign(ctx, `campaign_id = $1 AND account_id = $2 AND status = 'scheduled'`, d.id, d.accountID, d.isLive)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				s.logger.Warn("failed to start scheduled campaign", zap.String("campaign_id", d.id), zap.Error(err))
+			}
+			continue
+		}
+		// A launched campaign runs to completion even if the scheduler stops
+		go s.runCampaign(context.WithoutCancel(ctx), c)
+	}
+}
+
+// handleCampaignProgress reports how far a campaign's messages and chunks
+// have got
+func (s *Service) handleCampaignProgress(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID := r.Header.Get("X-Account-ID")
+	c, err := s.getCampaign(ctx, accountID, chi.URLParam(r, "id"))
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch campaign", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		s.jsonError(w, r, "campaign not found", http.StatusNotFound)
+		return
+	}
+
+	progress := CampaignProgress{CampaignID: c.ID, Status: c.Status, Total: c.TotalRecipients}
+	if c.SID != "" {
+		err := s.db.QueryRow(ctx, `
+			SELECT COUNT(*),
+				COUNT(*) FILTER (WHERE status = 'delivered'),
+				COUNT(*) FILTER (WHERE status = 'failed')
+			FROM sms_history WHERE sid = $1 AND account_id = $2
+		`, c.SID, accountID).Scan(&progress.Sent, &progress.Delivered, &progress.Failed)
+		if err != nil {
+			s.jsonError(w, r, "failed to fetch progress", http.StatusInternalServerError)
+			return
+		}
+	}
+	if c.TrackLinks {
+		err := s.db.QueryRow(ctx, `
+			SELECT COALESCE(SUM(clicks), 0), COUNT(DISTINCT recipient) FILTER (WHERE clicks > 0)
+			FROM short_links WHERE campaign_id = $1
+		`, c.ID).Scan(&progress.Clicks, &progress.ClickedRecipients)
+		if err != nil {
+			s.jsonError(w, r, "failed to fetch clicks", http.StatusInternalServerError)
+			return
+		}
+	}
+	progress.Pending = max(progress.Total-progress.Delivered-progress.Failed, 0)
+	if progress.Chunks, err = s.campaignChunks(ctx, c.ID); err != nil {
+		s.jsonError(w, r, "failed to fetch chunks", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   progress,
+	}, http.StatusOK)
+}
+
+// handlePauseCampaign pauses a running campaign. Chunks already being sent
+// finish; the rest wait until the campaign is resumed.
+func (s *Service) handlePauseCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID, id := r.Header.Get("X-Account-ID"), chi.URLParam(r, "id")
+	c, err := scanCampaign(s.db.QueryRow(ctx, `
+		UPDATE campaigns SET status = 'paused', updated_at = NOW()
+		WHERE campaign_id = $1 AND account_id = $2 AND status = 'running'
+		RETURNING `+campaignColumns, id, accountID))
+	if errors.Is(err, sql.ErrNoRows) {
+		s.campaignStateError(w, r, accountID, id, "only running campaigns can be paused")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to pause campaign", zap.String("campaign_id", id), zap.Error(err))
+		s.jsonError(w, r, "failed to pause campaign", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "campaign paused",
+		"data":   c,
+	}, http.StatusOK)
+}
+
+// handleResumeCampaign resumes a paused campaign from its first unsent
+// chunk, or a failed one with its failed chunk retried
+func (s *Service) handleResumeCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID, id := r.Header.Get("X-Account-ID"), chi.URLParam(r, "id")
+
+	var c *Campaign
+	err := lumadb.InTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		c, err = scanCampaign(tx.QueryRowContext(ctx, `
+			UPDATE campaigns SET status = 'running', error = NULL, completed_at = NULL, updated_at = NOW()
+			WHERE campaign_id = $1 AND account_id = $2 AND status IN ('paused', 'failed')
+			RETURNING `+campaignColumns, id, accountID))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE campaign_chunks SET status = 'pending', updated_at = NOW()
+			WHERE campaign_id = $1 AND status = 'failed'
+		`, id)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		s.campaignStateError(w, r, accountID, id, "only paused or failed campaigns can be resumed")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to resume campaign", zap.String("campaign_id", id), zap.Error(err))
+		s.jsonError(w, r, "failed to resume campaign", http.StatusInternalServerError)
+		return
+	}
+	go s.runCampaign(context.WithoutCancel(ctx), c)
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "campaign resumed",
+		"data":   c,
+	}, http.StatusAccepted)
+}
@@ -0,0 +1,271 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Campaign chunk states
+const (
+	ChunkPending = "pending"
+	ChunkSending = "sending"
+	ChunkSent    = "sent"
+	ChunkFailed  = "failed"
+)
+
+// campaignChunkLease is how long a chunk may stay sending before another
+// runner takes it over, presuming the instance sending it died
+const campaignChunkLease = 10 * time.Minute
+
+// errCampaignStopped ends a runner whose campaign was paused, failed by
+// another runner or deleted
+var errCampaignStopped = errors.New("campaign is no longer running")
+
+// CampaignChunk is one batch of a campaign's recipients and how far it got
+type CampaignChunk struct {
+	Index       int        `json:"index"`
+	Status      string     `json:"status"`
+	Recipients  int        `json:"recipients"`
+	Sent        int        `json:"sent"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// claimedChunk is a chunk a runner holds while sending it
+type claimedChunk struct {
+	index      int
+	recipients []string
+	attempts   int
+}
+
+// splitRecipients cuts recipients into chunks of at most size
+func splitRecipients(recipients []string, size int) [][]string {
+	chunks := make([][]string, 0, (len(recipients)+size-1)/size)
+	for start := 0; start < len(recipients); start += size {
+		chunks = append(chunks, recipients[start:min(start+size, len(recipients))])
+	}
+	return chunks
+}
+
+// createCampaignChunks persists c's recipients as chunks when it first
+// runs; a resumed campaign keeps the chunks it has
+func (s *Service) createCampaignChunks(ctx context.Context, c *Campaign) error {
+	var exists bool
+	if err := s.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM campaign_chunks WHERE campaign_id = $1)
+	`, c.ID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check campaign chunks: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	return lumadb.InTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		for i, chunk := range splitRecipients(c.Recipients, campaignBatchSize) {
+			recipients, _ := json.Marshal(chunk)
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO campaign_chunks (campaign_id, chunk_index, recipients, recipient_count)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (campaign_id, chunk_index) DO NOTHING
+			`, c.ID, i, recipients, len(chunk)); err != nil {
+				return fmt.Errorf("failed to create campaign chunk %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+}
+
+// claimCampaignChunk takes the campaign's first pending chunk, or one whose
+// runner's lease has run out; nil when there is none. Each chunk goes to
+// one runner, however many work the campaign.
+func (s *Service) claimCampaignChunk(ctx context.Context, campaignID string) (*claimedChunk, error) {
+	var chunk claimedChunk
+	var recipients []byte
+	err := s.db.QueryRow(ctx, `
+		UPDATE campaign_chunks
+		SET status = 'sending', attempts = attempts + 1, error = NULL,
+			started_at = NOW(), updated_at = NOW()
+		WHERE (campaign_id, chunk_index) = (
+			SELECT campaign_id, chunk_index FROM campaign_chunks
+			WHERE campaign_id = $1
+				AND (status = 'pending' OR (status = 'sending' AND updated_at < NOW() - $2 * INTERVAL '1 second'))
+			ORDER BY chunk_index
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING chunk_index, recipients, attempts
+	`, campaignID, campaignChunkLease.Seconds()).Scan(&chunk.index, &recipients, &chunk.attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim campaign chunk: %w", err)
+	}
+	if err := json.Unmarshal(recipients, &chunk.recipients); err != nil {
+		return nil, fmt.Errorf("invalid recipients for campaign %s chunk %d: %w", campaignID, chunk.index, err)
+	}
+	return &chunk, nil
+}
+
+// finishCampaignChunk checkpoints a chunk and adds what it sent to the
+// campaign's count
+func (s *Service) finishCampaignChunk(ctx context.Context, campaignID string, index int, status string, sent int, errMsg string) error {
+	return lumadb.InTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE campaign_chunks
+			SET status = $3, sent_count = $4, error = NULLIF($5, ''), completed_at = NOW(), updated_at = NOW()
+			WHERE campaign_id = $1 AND chunk_index = $2
+		`, campaignID, index, status, sent, errMsg); err != nil {
+			return fmt.Errorf("failed to checkpoint campaign chunk %d: %w", index, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE campaigns SET sent_count = COALESCE(sent_count, 0) + $2, updated_at = NOW()
+			WHERE campaign_id = $1
+		`, campaignID, sent); err != nil {
+			return fmt.Errorf("failed to update campaign sent count: %w", err)
+		}
+		return nil
+	})
+}
+
+// unsentRecipients drops the recipients a retried chunk already reached
+// before its runner died, which sms_history logs under the campaign's SID
+func (s *Service) unsentRecipients(ctx context.Context, c *Campaign, recipients []string) ([]string, error) {
+	formatted := make([]string, len(recipients))
+	for i, to := range recipients {
+		formatted[i] = s.formatNumber(to)
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT recipient FROM sms_history WHERE sid = $1 AND account_id = $2 AND recipient = ANY($3)
+	`, c.SID, c.AccountID, pq.Array(formatted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sent recipients: %w", err)
+	}
+	defer rows.Close()
+
+	sent := make(map[string]bool)
+	for rows.Next() {
+		var recipient string
+		if err := rows.Scan(&recipient); err != nil {
+			return nil, fmt.Errorf("failed to scan sent recipient: %w", err)
+		}
+		sent[recipient] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	unsent := make([]string, 0, len(recipients))
+	for i, to := range recipients {
+		if !sent[formatted[i]] {
+			unsent = append(unsent, to)
+		}
+	}
+	return unsent, nil
+}
+
+// campaignChunks lists a campaign's chunks in order
+func (s *Service) campaignChunks(ctx context.Context, campaignID string) ([]CampaignChunk, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT chunk_index, status, recipient_count, sent_count, attempts, COALESCE(error, ''),
+			started_at, completed_at
+		FROM campaign_chunks WHERE campaign_id = $1
+		ORDER BY chunk_index
+	`, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := make([]CampaignChunk, 0)
+	for rows.Next() {
+		var chunk CampaignChunk
+		var startedAt, completedAt sql.NullTime
+		if err := rows.Scan(&chunk.Index, &chunk.Status, &chunk.Recipients, &chunk.Sent, &chunk.Attempts, &chunk.Error,
+			&startedAt, &completedAt); err != nil {
+			return nil, err
+		}
+		if startedAt.Valid {
+			chunk.StartedAt = &startedAt.Time
+		}
+		if completedAt.Valid {
+			chunk.CompletedAt = &completedAt.Time
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// startCampaignRunner registers a runner for the campaign, false when this
+// instance already runs one
+func (s *Service) startCampaignRunner(id string) bool {
+	s.runnersMu.Lock()
+	defer s.runnersMu.Unlock()
+	if s.runners == nil {
+		s.runners = make(map[string]bool)
+	}
+	if s.runners[id] {
+		return false
+	}
+	s.runners[id] = true
+	return true
+}
+
+func (s *Service) stopCampaignRunner(id string) {
+	s.runnersMu.Lock()
+	defer s.runnersMu.Unlock()
+	delete(s.runners, id)
+}
+
+// resumeCampaigns starts runners for running campaigns this instance is
+// not sending that have chunks waiting: those interrupted by a restart,
+// those whose instance died, and those launched before their chunks were
+// created
+func (s *Service) resumeCampaigns(ctx context.Context) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns c
+		WHERE status = 'running' AND (
+			EXISTS (
+				SELECT 1 FROM campaign_chunks k
+				WHERE k.campaign_id = c.campaign_id
+					AND (k.status = 'pending' OR (k.status = 'sending' AND k.updated_at < NOW() - $1 * INTERVAL '1 second'))
+			)
+			OR NOT EXISTS (SELECT 1 FROM campaign_chunks k WHERE k.campaign_id = c.campaign_id)
+		)
+	`, campaignChunkLease.Seconds())
+	if err != nil {
+		s.logger.Warn("failed to load campaigns to resume", zap.Error(err))
+		return
+	}
+	var campaigns []*Campaign
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			s.logger.Warn("failed to load campaign to resume", zap.Error(err))
+			continue
+		}
+		campaigns = append(campaigns, c)
+	}
+	rows.Close()
+
+	for _, c := range campaigns {
+		s.runnersMu.Lock()
+		running := s.runners[c.ID]
+		s.runnersMu.Unlock()
+		if !running {
+			s.logger.Info("resuming campaign", zap.String("campaign_id", c.ID))
+			go s.runCampaign(context.WithoutCancel(ctx), c)
+		}
+	}
+}
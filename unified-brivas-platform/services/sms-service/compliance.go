@@ -0,0 +1,415 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Content filter strictness levels
+const (
+	StrictnessOff      = "off"
+	StrictnessLenient  = "lenient"
+	StrictnessStandard = "standard"
+	StrictnessStrict   = "strict"
+)
+
+// Content filter verdicts. Flagged messages are sent and logged for review;
+// blocked messages are logged and rejected before they are sent or charged.
+const (
+	ContentAllowed = "allowed"
+	ContentFlagged = "flagged"
+	ContentBlocked = "blocked"
+)
+
+// DefaultContentRefreshInterval is how often content rules and policies are
+// reloaded
+const DefaultContentRefreshInterval = 5 * time.Minute
+
+// contentThresholds are the risk scores from which a message is flagged or
+// blocked
+type contentThresholds struct {
+	flag, block int
+}
+
+var strictnessThresholds = map[string]contentThresholds{
+	StrictnessLenient:  {flag: 70, block: 95},
+	StrictnessStandard: {flag: 50, block: 80},
+	StrictnessStrict:   {flag: 30, block: 60},
+}
+
+// errContentBlocked rejects a send the content filter blocked
+var errContentBlocked = errors.New("message blocked by content filter")
+
+// FraudScorer rates a message's fraud risk from 0 to 100
+type FraudScorer interface {
+	ScoreMessage(ctx context.Context, message, sender string, volume int) (int, error)
+}
+
+// ContentRule is a blocklist keyword or regex and the risk it adds
+type ContentRule struct {
+	ID       int64  `json:"id"`
+	Pattern  string `json:"pattern"`
+	IsRegex  bool   `json:"is_regex"`
+	Category string `json:"category"`
+	Score    int    `json:"score"`
+
+	re *regexp.Regexp
+}
+
+func (r *ContentRule) matches(body, lowerBody string) bool {
+	if r.re != nil {
+		return r.re.MatchString(body)
+	}
+	return strings.Contains(lowerBody, strings.ToLower(r.Pattern))
+}
+
+// ContentPolicy sets the filter strictness for an account or a plan
+type ContentPolicy struct {
+	AccountID  string `json:"account_id,omitempty"`
+	Plan       string `json:"plan,omitempty"`
+	Strictness string `json:"strictness"`
+	AIScoring  bool   `json:"ai_scoring"`
+}
+
+// ContentCheck is the filter's verdict on a message
+type ContentCheck struct {
+	Verdict    string   `json:"verdict"`
+	Score      int      `json:"score"`
+	Matches    []string `json:"matches,omitempty"` // categories of the matched rules
+	Strictness string   `json:"strictness"`
+}
+
+// contentFilter is an in-memory copy of content_rules, content_policies and
+// the senders that bypass the filter
+type contentFilter struct {
+	mu       sync.RWMutex
+	rules    []ContentRule
+	policies map[string]ContentPolicy // account ID or "plan:" + plan name
+	plans    map[string]string        // account ID -> plan, for plans with a policy
+	bypass   map[string]bool          // account ID + "/" + sender
+	scorer   FraudScorer
+}
+
+func newContentFilter() *contentFilter {
+	return &contentFilter{
+		policies: make(map[string]ContentPolicy),
+		plans:    make(map[string]string),
+		bypass:   make(map[string]bool),
+	}
+}
+
+// set replaces the filter's rules, policies and bypassing senders
+func (f *contentFilter) set(rules []ContentRule, policies []ContentPolicy, plans map[string]string, bypass map[string]bool) {
+	byOwner := make(map[string]ContentPolicy, len(policies))
+	for _, p := range policies {
+		owner := p.AccountID
+		if owner == "" {
+			owner = "plan:" + p.Plan
+		}
+		byOwner[owner] = p
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+	f.policies = byOwner
+	f.plans = plans
+	f.bypass = bypass
+}
+
+// policy returns the account's policy, else its plan's, else standard
+func (f *contentFilter) policy(accountID string) ContentPolicy {
+	if p, ok := f.policies[accountID]; ok {
+		return p
+	}
+	if plan, ok := f.plans[accountID]; ok {
+		if p, ok := f.policies["plan:"+plan]; ok {
+			return p
+		}
+	}
+	return ContentPolicy{Strictness: StrictnessStandard}
+}
+
+// check scores body against the blocklist and, when the account's policy
+// asks for it, the fraud scorer. A failing scorer leaves the blocklist score.
+func (f *contentFilter) check(ctx context.Context, logger *zap.Logger, accountID, sender, body string, volume int) ContentCheck {
+	f.mu.RLock()
+	bypass := f.bypass[accountID+"/"+sender]
+	policy := f.policy(accountID)
+	rules := f.rules
+	scorer := f.scorer
+	f.mu.RUnlock()
+
+	result := ContentCheck{Verdict: ContentAllowed, Strictness: policy.Strictness}
+	thresholds, ok := strictnessThresholds[policy.Strictness]
+	if bypass || !ok {
+		return result
+	}
+
+	lowerBody := strings.ToLower(body)
+	for i := range rules {
+		if rules[i].matches(body, lowerBody) {
+			result.Score += rules[i].Score
+			result.Matches = append(result.Matches, rules[i].Category)
+		}
+	}
+	result.Score = min(result.Score, 100)
+
+	// The blocklist alone can block; skip the model call then
+	if policy.AIScoring && scorer != nil && result.Score < thresholds.block {
+		score, err := scorer.ScoreMessage(ctx, body, sender, volume)
+		if err != nil {
+			logger.Warn("fraud scoring failed", zap.String("account_id", accountID), zap.Error(err))
+		} else if score > result.Score {
+			result.Score = score
+			result.Matches = append(result.Matches, "ai")
+		}
+	}
+
+	switch {
+	case result.Score >= thresholds.block:
+		result.Verdict = ContentBlocked
+	case result.Score >= thresholds.flag:
+		result.Verdict = ContentFlagged
+	}
+	return result
+}
+
+// SetFraudScorer enables AI scoring for accounts whose policy asks for it
+func (s *Service) SetFraudScorer(scorer FraudScorer) {
+	s.content.mu.Lock()
+	defer s.content.mu.Unlock()
+	s.content.scorer = scorer
+}
+
+// screenContent checks a message before it is sent, logging flagged and
+// blocked messages, and returns errContentBlocked for a blocked one
+func (s *Service) screenContent(ctx context.Context, accountID, sid, sender, body string, recipients int) error {
+	if s.content == nil {
+		return nil
+	}
+
+	check := s.content.check(ctx, s.logger, accountID, sender, body, recipients)
+	if check.Verdict == ContentAllowed {
+		return nil
+	}
+
+	matches, _ := json.Marshal(check.Matches)
+	if _, err := s.db.Exec(ctx, `
+		INSERT INTO flagged_messages
+		(account_id, sid, sender, message, recipients, score, verdict, matches, strictness)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, accountID, sid, sender, body, recipients, check.Score, check.Verdict, matches, check.Strictness); err != nil {
+		s.logger.Error("failed to log flagged message", zap.String("sid", sid), zap.Error(err))
+	}
+
+	if check.Verdict == ContentBlocked {
+		s.logger.Warn("message blocked by content filter",
+			zap.String("account_id", accountID), zap.String("sid", sid), zap.Int("score", check.Score))
+		return errContentBlocked
+	}
+	return nil
+}
+
+// LoadContentRules reloads the blocklist, the filter policies and the
+// senders that bypass the filter. Rules with invalid patterns are skipped.
+func (s *Service) LoadContentRules(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, pattern, is_regex, category, score FROM content_rules WHERE active = TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load content rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []ContentRule
+	for rows.Next() {
+		var rule ContentRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.IsRegex, &rule.Category, &rule.Score); err != nil {
+			return fmt.Errorf("failed to scan content rule: %w", err)
+		}
+		if rule.IsRegex {
+			if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+				s.logger.Warn("skipping invalid content rule", zap.Int64("id", rule.ID), zap.Error(err))
+				continue
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	policyRows, err := s.db.Query(ctx, `
+		SELECT account_id, plan, strictness, ai_scoring FROM content_policies
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load content policies: %w", err)
+	}
+	defer policyRows.Close()
+
+	var policies []ContentPolicy
+	for policyRows.Next() {
+		var p ContentPolicy
+		var accountID, plan sql.NullString
+		if err := policyRows.Scan(&accountID, &plan, &p.Strictness, &p.AIScoring); err != nil {
+			return fmt.Errorf("failed to scan content policy: %w", err)
+		}
+		p.AccountID, p.Plan = accountID.String, plan.String
+		policies = append(policies, p)
+	}
+	if err := policyRows.Err(); err != nil {
+		return err
+	}
+
+	planRows, err := s.db.Query(ctx, `
+		SELECT id, plan FROM accounts
+		WHERE plan IN (SELECT plan FROM content_policies WHERE plan IS NOT NULL)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load account plans: %w", err)
+	}
+	defer planRows.Close()
+
+	plans := make(map[string]string)
+	for planRows.Next() {
+		var id, plan string
+		if err := planRows.Scan(&id, &plan); err != nil {
+			return fmt.Errorf("failed to scan account plan: %w", err)
+		}
+		plans[id] = plan
+	}
+	if err := planRows.Err(); err != nil {
+		return err
+	}
+
+	bypassRows, err := s.db.Query(ctx, `
+		SELECT account_id, sender FROM sender_ids
+		WHERE bypass_content_filter = TRUE AND approved = TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load bypassing senders: %w", err)
+	}
+	defer bypassRows.Close()
+
+	bypass := make(map[string]bool)
+	for bypassRows.Next() {
+		var accountID, sender string
+		if err := bypassRows.Scan(&accountID, &sender); err != nil {
+			return fmt.Errorf("failed to scan bypassing sender: %w", err)
+		}
+		bypass[accountID+"/"+sender] = true
+	}
+	if err := bypassRows.Err(); err != nil {
+		return err
+	}
+
+	s.content.set(rules, policies, plans, bypass)
+	return nil
+}
+
+// startContentRefresher loads the content rules now and then every
+// interval until ctx is cancelled. The previous rules stay in use when a
+// reload fails.
+func (s *Service) startContentRefresher(ctx context.Context, interval time.Duration) {
+	refresh := func() {
+		loadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := s.LoadContentRules(loadCtx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("content rule refresh failed", zap.Error(err))
+		}
+	}
+
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+			s.beat(workerContentRefresher)
+		}
+	}
+}
+
+// handleFlaggedMessages lists flagged and blocked messages for review,
+// newest first. Admins see every account's, optionally filtered by
+// ?account_id= and ?verdict=.
+func (s *Service) handleFlaggedMessages(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "admin role required", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	accountID := r.URL.Query().Get("account_id")
+	verdict := r.URL.Query().Get("verdict")
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		fmt.Sscanf(p, "%d", &page)
+	}
+	if page < 1 {
+		page = 1
+	}
+	limit := 50
+	offset := (page - 1) * limit
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, account_id, sid, COALESCE(sender, ''), message, recipients, score, verdict,
+			matches, COALESCE(strictness, ''), created_at
+		FROM flagged_messages
+		WHERE ($1 = '' OR account_id = $1) AND ($2 = '' OR verdict = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, accountID, verdict, limit, offset)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch flagged messages", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	flagged := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var account, sid, sender, message, verdict, strictness string
+		var recipients, score int
+		var matches []byte
+		var createdAt time.Time
+		if err := rows.Scan(&id, &account, &sid, &sender, &message, &recipients, &score, &verdict,
+			&matches, &strictness, &createdAt); err != nil {
+			continue
+		}
+		flagged = append(flagged, map[string]interface{}{
+			"id":         id,
+			"account_id": account,
+			"sid":        sid,
+			"sender":     sender,
+			"message":    message,
+			"recipients": recipients,
+			"score":      score,
+			"verdict":    verdict,
+			"matches":    json.RawMessage(matches),
+			"strictness": strictness,
+			"created_at": createdAt,
+		})
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "Fetched successfully",
+		"data":   flagged,
+	}, http.StatusOK)
+}
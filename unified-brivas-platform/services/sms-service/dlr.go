@@ -0,0 +1,78 @@
+package sms
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// dlrClockSkew is how far past its receipt a carrier's done date may be
+// before it is distrusted
+const dlrClockSkew = time.Minute
+
+// dlrDateFormats are the layouts carriers send DLR dates in; the first two
+// are the SMPP receipt's YYMMDDhhmm[ss]
+var dlrDateFormats = []string{"0601021504", "060102150405", "2006-01-02 15:04:05", time.RFC3339}
+
+// receiptDate matches the dates in an SMPP receipt's short_message, e.g.
+// "id:123 sub:001 dlvrd:001 submit date:2403011200 done date:2403011201 stat:DELIVRD"
+var receiptDate = regexp.MustCompile(`(submit|done) date:(\d{10,12})`)
+
+// dlrUpdate is a delivery report waiting to be written to sms_history
+type dlrUpdate struct {
+	messageID string
+	doneAt    time.Time // when the carrier delivered or gave up
+	attempts  int       // delivery attempts the carrier reported, 0 if none
+}
+
+// parseDLRDate reads a carrier date; dates without a zone are in loc
+func parseDLRDate(v string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range dlrDateFormats {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseDLRTimes returns when the carrier reports the message done, from
+// the body's done_date and submit_date or its SMPP receipt. A missing done
+// date, one before the submit date, or one after receivedAt falls back to
+// receivedAt.
+func parseDLRTimes(body map[string]interface{}, loc *time.Location, receivedAt time.Time) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+	dates := make(map[string]string)
+	if shortMsg, ok := body["short_message"].(string); ok {
+		for _, m := range receiptDate.FindAllStringSubmatch(shortMsg, -1) {
+			dates[m[1]] = m[2]
+		}
+	}
+	for _, field := range []string{"done", "submit"} {
+		if v, ok := body[field+"_date"].(string); ok && v != "" {
+			dates[field] = v
+		}
+	}
+
+	done, ok := parseDLRDate(dates["done"], loc)
+	if !ok || done.After(receivedAt.Add(dlrClockSkew)) {
+		return receivedAt
+	}
+	if submit, ok := parseDLRDate(dates["submit"], loc); ok && done.Before(submit) {
+		return receivedAt
+	}
+	return done
+}
+
+// parseDLRAttempts reads the attempt count some carriers include
+func parseDLRAttempts(body map[string]interface{}) int {
+	switch v := body["attempts"].(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package sms
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Duplicate campaign detection defaults
+const (
+	// DefaultDuplicateSimilarity is the cosine similarity from which a
+	// recent campaign's message counts as a duplicate
+	DefaultDuplicateSimilarity = 0.95
+	// DefaultDuplicateLookback is how far back launches are compared
+	DefaultDuplicateLookback = 7 * 24 * time.Hour
+
+	duplicateSearchK = 10
+	campaignVectorID = "campaign:"
+)
+
+// Embedder embeds text for similarity search, e.g. the LLM orchestrator
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+// DuplicateCampaign is a recently launched campaign whose message is close
+// to one being launched
+type DuplicateCampaign struct {
+	CampaignID string    `json:"campaign_id"`
+	Name       string    `json:"name"`
+	Similarity float64   `json:"similarity"`
+	LaunchedAt time.Time `json:"launched_at"`
+}
+
+// duplicateDetector compares campaign messages against the account's
+// recent launches. It does nothing until an embedder and store are set.
+type duplicateDetector struct {
+	mu        sync.RWMutex
+	embedder  Embedder
+	store     lumadb.VectorStore
+	threshold float64
+	lookback  time.Duration
+}
+
+func newDuplicateDetector(threshold float64, lookback time.Duration) *duplicateDetector {
+	if threshold <= 0 {
+		threshold = DefaultDuplicateSimilarity
+	}
+	if lookback <= 0 {
+		lookback = DefaultDuplicateLookback
+	}
+	return &duplicateDetector{threshold: threshold, lookback: lookback}
+}
+
+// SetDuplicateDetector enables duplicate warnings on campaign launch,
+// storing launched campaigns' embeddings in store
+func (s *Service) SetDuplicateDetector(embedder Embedder, store lumadb.VectorStore) {
+	s.duplicates.mu.Lock()
+	defer s.duplicates.mu.Unlock()
+	s.duplicates.embedder = embedder
+	s.duplicates.store = store
+}
+
+// find embeds message and returns the account's other campaigns launched
+// since now less the lookback whose messages are at least the threshold
+// similar. The embedding is returned for record; it is nil when detection
+// is off.
+func (d *duplicateDetector) find(ctx context.Context, c *Campaign, message string, now time.Time) ([]float64, []DuplicateCampaign, error) {
+	d.mu.RLock()
+	embedder, store := d.embedder, d.store
+	d.mu.RUnlock()
+	if embedder == nil || store == nil {
+		return nil, nil, nil
+	}
+
+	vector, err := embedder.Embed(ctx, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	matches, err := store.Search(ctx, vector, duplicateSearchK, map[string]string{
+		"account_id": c.AccountID,
+		"kind":       "campaign",
+	})
+	if err != nil {
+		return vector, nil, err
+	}
+
+	var duplicates []DuplicateCampaign
+	for _, m := range matches {
+		if m.Score < d.threshold || m.Metadata["campaign_id"] == c.ID {
+			continue
+		}
+		launchedAt, err := time.Parse(time.RFC3339, m.Metadata["launched_at"])
+		if err != nil || launchedAt.Before(now.Add(-d.lookback)) {
+			continue
+		}
+		duplicates = append(duplicates, DuplicateCampaign{
+			CampaignID: m.Metadata["campaign_id"],
+			Name:       m.Metadata["name"],
+			Similarity: m.Score,
+			LaunchedAt: launchedAt,
+		})
+	}
+	return vector, duplicates, nil
+}
+
+// record stores a launched campaign's embedding for later launches to be
+// compared against
+func (d *duplicateDetector) record(ctx context.Context, c *Campaign, vector []float64, launchedAt time.Time) error {
+	d.mu.RLock()
+	store := d.store
+	d.mu.RUnlock()
+	if store == nil || vector == nil {
+		return nil
+	}
+	return store.Upsert(ctx, campaignVectorID+c.ID, vector, map[string]string{
+		"account_id":  c.AccountID,
+		"kind":        "campaign",
+		"campaign_id": c.ID,
+		"name":        c.Name,
+		"launched_at": launchedAt.UTC().Format(time.RFC3339),
+	})
+}
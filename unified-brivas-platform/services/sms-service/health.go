@@ -0,0 +1,81 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Background workers whose heartbeats HealthCheck watches
+const (
+	workerDLRFlusher        = "dlr_flusher"
+	workerRateRefresher     = "rate_refresher"
+	workerCampaignScheduler = "campaign_scheduler"
+	workerContentRefresher  = "content_refresher"
+	workerWebhooks          = "webhook_dispatcher"
+)
+
+// heartbeatGrace is allowed on top of a worker's missed intervals for one
+// round's work, such as a large DLR flush, to finish
+const heartbeatGrace = time.Minute
+
+// heartbeat records when a worker loop last went round. A nil heartbeat
+// ignores beats, for workers started outside NewService.
+type heartbeat struct {
+	interval time.Duration
+	last     atomic.Int64
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	h := &heartbeat{interval: interval}
+	h.beat()
+	return h
+}
+
+func (h *heartbeat) beat() {
+	if h != nil {
+		h.last.Store(time.Now().UnixNano())
+	}
+}
+
+// stale returns how long ago the worker last beat when that is more than
+// three of its intervals and the grace
+func (h *heartbeat) stale(now time.Time) (time.Duration, bool) {
+	age := now.Sub(time.Unix(0, h.last.Load()))
+	return age, age > 3*h.interval+heartbeatGrace
+}
+
+// beat records that worker went round
+func (s *Service) beat(worker string) {
+	s.heartbeats[worker].beat()
+}
+
+// HealthCheck reports the service unhealthy when a background worker has
+// stopped going round or the send dispatcher has stopped, naming each.
+// It only reads heartbeats, so it is cheap enough to poll.
+func (s *Service) HealthCheck(ctx context.Context) error {
+	names := make([]string, 0, len(s.heartbeats))
+	for name := range s.heartbeats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	var errs []error
+	for _, name := range names {
+		if age, stale := s.heartbeats[name].stale(now); stale {
+			errs = append(errs, fmt.Errorf("%s last ran %s ago", name, age.Round(time.Second)))
+		}
+	}
+	if s.dispatch != nil {
+		select {
+		case <-s.dispatch.stopped:
+			errs = append(errs, errDispatchStopped)
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,193 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Dispatch lanes. OTP, transactional and corporate traffic goes through
+// the priority lane so it is not queued behind promotional blasts.
+const (
+	LanePriority    = "priority"
+	LanePromotional = "promotional"
+)
+
+// Dispatch defaults
+const (
+	// DefaultDispatchWorkers is how many provider submissions run at once
+	DefaultDispatchWorkers = 8
+	// DefaultPriorityWeight is how many priority jobs a worker takes in a
+	// row while promotional jobs wait
+	DefaultPriorityWeight = 4
+
+	// dispatchChunkSize splits bulk sends so one blast cannot hold a
+	// worker for its whole recipient list
+	dispatchChunkSize = 100
+	laneCapacity      = 256
+)
+
+// errDispatchStopped reports a job the service closed before it ran
+var errDispatchStopped = errors.New("dispatch stopped")
+
+// laneFor picks the lane for a message type or SMS type
+func laneFor(types ...string) string {
+	for _, t := range types {
+		if t == "promotional" {
+			return LanePromotional
+		}
+	}
+	return LanePriority
+}
+
+type dispatchJob struct {
+	ctx  context.Context
+	size int // messages in the job
+	run  func(ctx context.Context) error
+	err  chan error
+}
+
+type lane struct {
+	jobs  chan *dispatchJob
+	depth atomic.Int64 // queued messages not yet taken by a worker
+}
+
+// dispatcher runs provider submissions on a worker pool fed by one queue
+// per lane. Workers prefer the priority lane but take a promotional job
+// after every weight priority jobs, so promotional sends still progress.
+type dispatcher struct {
+	lanes   map[string]*lane
+	workers int
+	weight  int
+	stopped chan struct{} // closed once every worker has exited
+}
+
+// newDispatcher creates a dispatcher; zero limits use the defaults
+func newDispatcher(workers, weight int) *dispatcher {
+	if workers <= 0 {
+		workers = DefaultDispatchWorkers
+	}
+	if weight <= 0 {
+		weight = DefaultPriorityWeight
+	}
+	return &dispatcher{
+		lanes: map[string]*lane{
+			LanePriority:    {jobs: make(chan *dispatchJob, laneCapacity)},
+			LanePromotional: {jobs: make(chan *dispatchJob, laneCapacity)},
+		},
+		workers: workers,
+		weight:  weight,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Run starts the workers and waits for them to exit once ctx is cancelled.
+// Jobs still queued then fail with errDispatchStopped.
+func (d *dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx)
+		}()
+	}
+	wg.Wait()
+	close(d.stopped)
+}
+
+func (d *dispatcher) work(ctx context.Context) {
+	turn := 0
+	for {
+		job := d.next(ctx, &turn)
+		if job == nil {
+			return
+		}
+		if err := job.ctx.Err(); err != nil {
+			job.err <- err
+			continue
+		}
+		job.err <- job.run(job.ctx)
+	}
+}
+
+// next takes the next job, or nil once ctx is cancelled. turn counts the
+// priority jobs taken since the last promotional one.
+func (d *dispatcher) next(ctx context.Context, turn *int) *dispatchJob {
+	first, second := d.lanes[LanePriority], d.lanes[LanePromotional]
+	if *turn >= d.weight {
+		first, second = second, first
+	}
+	take := func(l *lane, job *dispatchJob) *dispatchJob {
+		l.depth.Add(-int64(job.size))
+		if l == d.lanes[LanePriority] {
+			*turn++
+		} else {
+			*turn = 0
+		}
+		return job
+	}
+
+	for _, l := range []*lane{first, second} {
+		select {
+		case job := <-l.jobs:
+			return take(l, job)
+		default:
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case job := <-first.jobs:
+		return take(first, job)
+	case job := <-second.jobs:
+		return take(second, job)
+	}
+}
+
+// dispatch queues run on the named lane and waits for it. A nil dispatcher
+// runs it directly.
+func (d *dispatcher) dispatch(ctx context.Context, laneName string, size int, run func(ctx context.Context) error) error {
+	if d == nil {
+		return run(ctx)
+	}
+	l := d.lanes[laneName]
+	job := &dispatchJob{ctx: ctx, size: size, run: run, err: make(chan error, 1)}
+
+	l.depth.Add(int64(size))
+	select {
+	case l.jobs <- job:
+	case <-ctx.Done():
+		l.depth.Add(-int64(size))
+		return ctx.Err()
+	case <-d.stopped:
+		l.depth.Add(-int64(size))
+		return errDispatchStopped
+	}
+
+	select {
+	case err := <-job.err:
+		return err
+	case <-d.stopped:
+		// A worker may have finished the job before exiting
+		select {
+		case err := <-job.err:
+			return err
+		default:
+			return errDispatchStopped
+		}
+	}
+}
+
+// queueDepths is the number of messages waiting in each lane
+func (d *dispatcher) queueDepths() map[string]int64 {
+	depths := map[string]int64{LanePriority: 0, LanePromotional: 0}
+	if d == nil {
+		return depths
+	}
+	for name, l := range d.lanes {
+		depths[name] = l.depth.Load()
+	}
+	return depths
+}
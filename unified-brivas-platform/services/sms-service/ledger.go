@@ -0,0 +1,257 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+)
+
+// Ledger entry types. Amounts are signed: top-ups and refunds are
+// positive, charges negative.
+const (
+	LedgerOpening = "opening"
+	LedgerTopUp   = "topup"
+	LedgerCharge  = "charge"
+	LedgerRefund  = "refund"
+)
+
+// DefaultCurrency is the currency ledger entries are recorded in
+const DefaultCurrency = "NGN"
+
+const (
+	maxTopUpAmount      = 10_000_000
+	ledgerReferenceSize = 100
+)
+
+var (
+	errAccountNotFound = errors.New("account not found")
+	errDuplicateEntry  = errors.New("ledger entry already recorded")
+)
+
+var ledgerEntryTypes = map[string]bool{
+	LedgerOpening: true, LedgerTopUp: true, LedgerCharge: true, LedgerRefund: true,
+}
+
+// LedgerEntry is an immutable row of an account's billing ledger
+type LedgerEntry struct {
+	ID           string    `json:"id"`
+	AccountID    string    `json:"account_id"`
+	Type         string    `json:"type"`
+	Amount       float64   `json:"amount"`
+	BalanceAfter float64   `json:"balance_after"`
+	Currency     string    `json:"currency"`
+	Reference    string    `json:"reference,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TopUpRequest credits an account's balance
+type TopUpRequest struct {
+	AccountID      string  `json:"account_id"`
+	Amount         float64 `json:"amount"`
+	Reference      string  `json:"reference"`
+	Description    string  `json:"description"`
+	IdempotencyKey string  `json:"idempotency_key"`
+}
+
+func (req *TopUpRequest) validate() error {
+	switch {
+	case req.AccountID == "":
+		return errors.New("account_id is required")
+	case req.Amount <= 0:
+		return errors.New("amount must be positive")
+	case req.Amount > maxTopUpAmount:
+		return fmt.Errorf("amount must not exceed %d", maxTopUpAmount)
+	case len(req.Reference) > ledgerReferenceSize:
+		return fmt.Errorf("reference must be at most %d characters", ledgerReferenceSize)
+	}
+	return nil
+}
+
+// postLedger applies amount to the account's cached balance and records the
+// ledger entry in the same transaction. An entry with an idempotency key
+// that was already recorded returns errDuplicateEntry and changes nothing.
+func (s *Service) postLedger(ctx context.Context, accountID, entryType string, amount float64, reference, description, idempotencyKey string) (*LedgerEntry, error) {
+	entry := &LedgerEntry{
+		ID:          "TXN-" + uuid.NewString(),
+		AccountID:   accountID,
+		Type:        entryType,
+		Amount:      amount,
+		Currency:    DefaultCurrency,
+		Reference:   reference,
+		Description: description,
+	}
+
+	err := lumadb.InTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		// Locks the account row, so concurrent entries are applied in turn
+		err := tx.QueryRowContext(ctx, `
+			UPDATE accounts SET balance = balance + $1 WHERE id = $2
+			RETURNING balance
+		`, amount, accountID).Scan(&entry.BalanceAfter)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errAccountNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO billing_transactions
+			(transaction_id, tenant_id, channel, base_amount, sub_total, final_amount, currency,
+			 status, type, balance_after, reference, description, idempotency_key, processed_at)
+			VALUES ($1, $2, 'sms', $3, $3, $3, $4, 'completed', $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NOW())
+			ON CONFLICT (idempotency_key) DO NOTHING
+			RETURNING created_at
+		`, entry.ID, accountID, amount, entry.Currency, entryType, entry.BalanceAfter,
+			reference, description, idempotencyKey).Scan(&entry.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Rolls back the balance change
+			return errDuplicateEntry
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *Service) deductBalance(ctx context.Context, accountID string, amount float64, sid string) {
+	if _, err := s.postLedger(ctx, accountID, LedgerCharge, -amount, sid, "SMS charge", ""); err != nil {
+		s.logger.Error("failed to charge account",
+			zap.String("account_id", accountID), zap.String("sid", sid), zap.Error(err))
+	}
+}
+
+func (s *Service) refundFailedSMS(ctx context.Context, messageID string) {
+	// Get message details and refund
+	var accountID string
+	var rate float64
+	var isLive bool
+	err := s.db.QueryRow(ctx, `
+		SELECT account_id, rate_per_sms, is_live FROM sms_history WHERE rid = $1
+	`, messageID).Scan(&accountID, &rate, &isLive)
+	if err != nil || !isLive {
+		return
+	}
+
+	// Keyed by message, so a repeated failure DLR refunds once
+	_, err = s.postLedger(ctx, accountID, LedgerRefund, rate, messageID, "Failed SMS refund", "refund:"+messageID)
+	if err != nil && !errors.Is(err, errDuplicateEntry) {
+		s.logger.Error("failed to refund SMS",
+			zap.String("account_id", accountID), zap.String("rid", messageID), zap.Error(err))
+	}
+}
+
+// handleTopUp credits an account. Top-ups come from admins or the payment
+// service; the Idempotency-Key header overrides the body's key.
+func (s *Service) handleTopUp(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) && r.Header.Get("X-Role") != "service" {
+		s.jsonError(w, r, "not allowed to top up balances", http.StatusForbidden)
+		return
+	}
+
+	var req TopUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	if err := req.validate(); err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Description == "" {
+		req.Description = "Balance top-up"
+	}
+
+	idempotencyKey := ""
+	if req.IdempotencyKey != "" {
+		idempotencyKey = "topup:" + req.IdempotencyKey
+	}
+
+	entry, err := s.postLedger(r.Context(), req.AccountID, LedgerTopUp, req.Amount, req.Reference, req.Description, idempotencyKey)
+	switch {
+	case errors.Is(err, errAccountNotFound):
+		s.jsonError(w, r, "account not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errDuplicateEntry):
+		s.jsonError(w, r, "top-up already processed", http.StatusConflict)
+		return
+	case err != nil:
+		s.logger.Error("failed to top up", zap.String("account_id", req.AccountID), zap.Error(err))
+		s.jsonError(w, r, "failed to top up", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "Balance topped up",
+		"data":   entry,
+	}, http.StatusOK)
+}
+
+// handleListTransactions lists the account's ledger, newest first,
+// optionally filtered by ?type=
+func (s *Service) handleListTransactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID := r.Header.Get("X-Account-ID")
+
+	entryType := r.URL.Query().Get("type")
+	if entryType != "" && !ledgerEntryTypes[entryType] {
+		s.jsonError(w, r, "invalid transaction type", http.StatusBadRequest)
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		fmt.Sscanf(p, "%d", &page)
+	}
+	if page < 1 {
+		page = 1
+	}
+	limit := 50
+	offset := (page - 1) * limit
+
+	rows, err := s.db.Query(ctx, `
+		SELECT transaction_id, type, final_amount, COALESCE(balance_after, 0), currency,
+			COALESCE(reference, ''), COALESCE(description, ''), created_at
+		FROM billing_transactions
+		WHERE tenant_id = $1 AND type IS NOT NULL AND ($2 = '' OR type = $2)
+		ORDER BY created_at DESC, transaction_id DESC
+		LIMIT $3 OFFSET $4
+	`, accountID, entryType, limit, offset)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch transactions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		e := LedgerEntry{AccountID: accountID}
+		if err := rows.Scan(&e.ID, &e.Type, &e.Amount, &e.BalanceAfter, &e.Currency,
+			&e.Reference, &e.Description, &e.CreatedAt); err != nil {
+			s.jsonError(w, r, "failed to fetch transactions", http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "Fetched successfully",
+		"data":   entries,
+	}, http.StatusOK)
+}
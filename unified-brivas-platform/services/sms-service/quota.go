@@ -0,0 +1,96 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// QuotaEnforcer meters live messages against the account's monthly plan
+// quota, typically the auth engine's AccountQuota(auth.ResourceSMS).
+// A refusal is an error with an HTTPStatus method.
+type QuotaEnforcer interface {
+	CheckQuota(ctx context.Context, accountID string, n int) error
+	ConsumeQuota(ctx context.Context, accountID string, n int) error
+	RecordUsage(ctx context.Context, accountID string, n int) error
+}
+
+// errQuotaExceeded wraps the enforcer's refusal of a send
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// quotaHolder lets SetQuota swap the enforcer while sends read it
+type quotaHolder struct {
+	QuotaEnforcer
+}
+
+// SetQuota enables plan quotas on every live send; nil disables them.
+// Messages are consumed from the quota before they are sent, and given
+// back when the provider does not take them.
+func (s *Service) SetQuota(q QuotaEnforcer) {
+	s.quota.Store(&quotaHolder{q})
+}
+
+func (s *Service) quotaEnforcer() QuotaEnforcer {
+	if h := s.quota.Load(); h != nil {
+		return h.QuotaEnforcer
+	}
+	return nil
+}
+
+// wrapQuotaError wraps the enforcer's refusal in errQuotaExceeded
+func wrapQuotaError(err error) error {
+	var refusal interface{ HTTPStatus() int }
+	if errors.As(err, &refusal) {
+		return fmt.Errorf("%w: %v", errQuotaExceeded, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check quota: %w", err)
+	}
+	return nil
+}
+
+// checkQuota returns errQuotaExceeded when n more messages would go over
+// the account's quota, without consuming any
+func (s *Service) checkQuota(ctx context.Context, accountID string, n int) error {
+	q := s.quotaEnforcer()
+	if q == nil {
+		return nil
+	}
+	return wrapQuotaError(q.CheckQuota(ctx, accountID, n))
+}
+
+// reserveQuota consumes n messages from the account's quota ahead of
+// sending them, or returns errQuotaExceeded
+func (s *Service) reserveQuota(ctx context.Context, accountID string, n int) error {
+	q := s.quotaEnforcer()
+	if q == nil {
+		return nil
+	}
+	return wrapQuotaError(q.ConsumeQuota(ctx, accountID, n))
+}
+
+// releaseQuota gives back n reserved messages that were not sent. A
+// failure is only logged; it leaves the account charged for them.
+func (s *Service) releaseQuota(ctx context.Context, accountID, sid string, n int) {
+	q := s.quotaEnforcer()
+	if q == nil || n == 0 {
+		return
+	}
+	if err := q.RecordUsage(ctx, accountID, -n); err != nil {
+		s.logger.Warn("failed to release SMS quota",
+			zap.String("account_id", accountID), zap.String("sid", sid), zap.Int("messages", n), zap.Error(err))
+	}
+}
+
+// writeQuotaError answers a failed quota check: 402 when the quota is exceeded
+func (s *Service) writeQuotaError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errQuotaExceeded) {
+		s.jsonError(w, r, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+	s.logger.Error("failed to check SMS quota", zap.Error(err))
+	s.jsonError(w, r, "failed to check quota", http.StatusInternalServerError)
+}
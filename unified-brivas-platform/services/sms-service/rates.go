@@ -0,0 +1,282 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"go.uber.org/zap"
+)
+
+// defaultRates price message types no rate card covers
+var defaultRates = map[string]float64{
+	"otp":           3.0,
+	"transactional": 3.0,
+	"promotional":   2.5,
+	"corporate":     3.0,
+}
+
+// defaultRate prices message types missing from defaultRates
+const defaultRate = 3.0
+
+// DefaultRateRefreshInterval is how often rate cards are reloaded
+const DefaultRateRefreshInterval = 5 * time.Minute
+
+// RateCard is the price of one message on a network. Empty Network,
+// AccountID and Plan match any; an account's own cards win over its plan's,
+// which win over cards for everyone.
+type RateCard struct {
+	Network     string  `json:"network"`
+	MessageType string  `json:"message_type"`
+	AccountID   string  `json:"account_id,omitempty"`
+	Plan        string  `json:"plan,omitempty"`
+	Rate        float64 `json:"rate"`
+}
+
+type rateKey struct {
+	owner       string // account ID, "plan:" + plan name, or "" for everyone
+	network     string
+	messageType string
+}
+
+// rateTable is an in-memory copy of sms_rate_cards
+type rateTable struct {
+	mu    sync.RWMutex
+	rates map[rateKey]float64
+	plans map[string]string // account ID -> plan, for accounts on a priced plan
+}
+
+func newRateTable() *rateTable {
+	return &rateTable{rates: make(map[rateKey]float64), plans: make(map[string]string)}
+}
+
+// set replaces the table's cards and account plans
+func (t *rateTable) set(cards []RateCard, plans map[string]string) {
+	rates := make(map[rateKey]float64, len(cards))
+	for _, c := range cards {
+		owner := c.AccountID
+		if owner == "" && c.Plan != "" {
+			owner = "plan:" + c.Plan
+		}
+		rates[rateKey{owner: owner, network: c.Network, messageType: c.MessageType}] = c.Rate
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rates = rates
+	t.plans = plans
+}
+
+// lookup finds the most specific card for the account, network and message
+// type: account over plan over everyone, and an exact network over any
+func (t *rateTable) lookup(accountID, network, msgType string) (float64, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var owners []string
+	if accountID != "" {
+		owners = append(owners, accountID)
+		if plan, ok := t.plans[accountID]; ok {
+			owners = append(owners, "plan:"+plan)
+		}
+	}
+	owners = append(owners, "")
+
+	for _, owner := range owners {
+		for _, n := range []string{network, ""} {
+			if rate, ok := t.rates[rateKey{owner: owner, network: n, messageType: msgType}]; ok {
+				return rate, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// getRate returns the price of one msgType message to recipient on the
+// cards that apply to everyone
+func (s *Service) getRate(msgType, recipient string) float64 {
+	return s.accountRate("", msgType, recipient)
+}
+
+// accountRate returns the price the account pays for one msgType message to
+// recipient, falling back to the default rates when no card matches
+func (s *Service) accountRate(accountID, msgType, recipient string) float64 {
+	if s.rates != nil {
+		network := ""
+		if recipient != "" {
+			network = s.getNetwork(s.formatNumber(recipient))
+		}
+		if rate, ok := s.rates.lookup(accountID, network, msgType); ok {
+			return rate
+		}
+	}
+	if rate, ok := defaultRates[msgType]; ok {
+		return rate
+	}
+	return defaultRate
+}
+
+// LoadRates reloads the rate cards and the plans of the accounts they price
+func (s *Service) LoadRates(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT network, message_type, account_id, plan, rate
+		FROM sms_rate_cards WHERE active = TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load rate cards: %w", err)
+	}
+	defer rows.Close()
+
+	var cards []RateCard
+	for rows.Next() {
+		var c RateCard
+		var network, accountID, plan sql.NullString
+		if err := rows.Scan(&network, &c.MessageType, &accountID, &plan, &c.Rate); err != nil {
+			return fmt.Errorf("failed to scan rate card: %w", err)
+		}
+		c.Network, c.AccountID, c.Plan = network.String, accountID.String, plan.String
+		cards = append(cards, c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	planRows, err := s.db.Query(ctx, `
+		SELECT id, plan FROM accounts
+		WHERE plan IN (SELECT DISTINCT plan FROM sms_rate_cards WHERE active = TRUE AND plan IS NOT NULL)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load account plans: %w", err)
+	}
+	defer planRows.Close()
+
+	plans := make(map[string]string)
+	for planRows.Next() {
+		var id, plan string
+		if err := planRows.Scan(&id, &plan); err != nil {
+			return fmt.Errorf("failed to scan account plan: %w", err)
+		}
+		plans[id] = plan
+	}
+	if err := planRows.Err(); err != nil {
+		return err
+	}
+
+	s.rates.set(cards, plans)
+	return nil
+}
+
+// startRateRefresher loads the rate cards and sender ID policies now and
+// then every interval until ctx is cancelled. The previous cards and
+// policies stay in use when a reload fails.
+func (s *Service) startRateRefresher(ctx context.Context, interval time.Duration) {
+	refresh := func() {
+		loadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := s.LoadRates(loadCtx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("rate card refresh failed", zap.Error(err))
+		}
+		if err := s.LoadSenderPolicies(loadCtx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("sender ID policy refresh failed", zap.Error(err))
+		}
+	}
+
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+			s.beat(workerRateRefresher)
+		}
+	}
+}
+
+// messagesCost totals the rates of msgs
+func messagesCost(msgs []*Message) float64 {
+	var total float64
+	for _, m := range msgs {
+		total += m.RatePerSMS
+	}
+	return total
+}
+
+// gsm7Basic is the GSM 03.38 default alphabet; gsm7Extended characters take
+// two septets
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// SMS body encodings
+const (
+	EncodingGSM7 = "GSM-7"
+	EncodingUCS2 = "UCS-2"
+)
+
+// MessageEncoding is how a body is sent and so billed
+type MessageEncoding struct {
+	Encoding string `json:"encoding"`
+	Segments int    `json:"segments"`
+	// Unicode lists, once each, the characters that force UCS-2
+	Unicode []string `json:"unicode_characters,omitempty"`
+	// Warning explains what UCS-2 costs, for the sender to rephrase
+	Warning string `json:"warning,omitempty"`
+}
+
+// messageEncoding works out how body is sent: in 160 GSM-7 characters or
+// 70 UCS-2 characters in one part, 153 or 67 per part once the message is
+// concatenated. A single character outside the GSM-7 alphabet sends the
+// whole message as UCS-2.
+func messageEncoding(body string) MessageEncoding {
+	septets := 0
+	var unicode []string
+	seen := make(map[rune]bool)
+	for _, r := range body {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			septets++
+		case strings.ContainsRune(gsm7Extended, r):
+			septets += 2
+		default:
+			if !seen[r] {
+				seen[r] = true
+				unicode = append(unicode, string(r))
+			}
+		}
+	}
+
+	enc := MessageEncoding{Encoding: EncodingGSM7, Segments: segments(septets, 160, 153)}
+	if len(unicode) > 0 {
+		// UCS-2 counts UTF-16 code units
+		enc = MessageEncoding{
+			Encoding: EncodingUCS2,
+			Segments: segments(len(utf16.Encode([]rune(body))), 70, 67),
+			Unicode:  unicode,
+		}
+		enc.Warning = fmt.Sprintf("%s: not in the GSM-7 alphabet, so the message is sent as UCS-2 at 70 characters per part instead of 160, in %d part(s)",
+			strings.Join(unicode, " "), enc.Segments)
+	}
+	return enc
+}
+
+// segments is how many parts a body of length units takes
+func segments(length, single, multi int) int {
+	if length <= single {
+		return 1
+	}
+	return (length + multi - 1) / multi
+}
+
+// smsSegments returns how many SMS parts body is sent as. Carriers bill
+// each part.
+func smsSegments(body string) int {
+	return messageEncoding(body).Segments
+}
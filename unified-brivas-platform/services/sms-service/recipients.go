@@ -0,0 +1,64 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// RecipientCounts breaks down a bulk send's recipients. Only accepted
+// recipients are sent to, and only those the provider took are charged
+// for; Failed counts the accepted ones it did not.
+type RecipientCounts struct {
+	Accepted   int `json:"accepted"`
+	Duplicates int `json:"duplicates"`
+	Invalid    int `json:"invalid"`
+	Suppressed int `json:"suppressed"`
+	Failed     int `json:"failed"`
+}
+
+// prepareRecipients formats numbers, skipping invalid ones and, when
+// dedupe is set, repeats of an already accepted number
+func (s *Service) prepareRecipients(to []string, dedupe bool) ([]string, RecipientCounts) {
+	var counts RecipientCounts
+	seen := make(map[string]bool, len(to))
+	recipients := make([]string, 0, len(to))
+	for _, number := range to {
+		formatted := s.formatNumber(number)
+		if s.getNetwork(formatted) == "" {
+			counts.Invalid++
+			continue
+		}
+		if dedupe && seen[formatted] {
+			counts.Duplicates++
+			continue
+		}
+		seen[formatted] = true
+		recipients = append(recipients, formatted)
+	}
+	counts.Accepted = len(recipients)
+	return recipients, counts
+}
+
+// suppressedRecipients returns which of the formatted recipients the
+// account must not message
+func (s *Service) suppressedRecipients(ctx context.Context, accountID string, recipients []string) (map[string]bool, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT recipient FROM sms_suppressions WHERE account_id = $1 AND recipient = ANY($2)
+	`, accountID, pq.Array(recipients))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	suppressed := make(map[string]bool)
+	for rows.Next() {
+		var recipient string
+		if err := rows.Scan(&recipient); err != nil {
+			return nil, fmt.Errorf("failed to scan suppression: %w", err)
+		}
+		suppressed[recipient] = true
+	}
+	return suppressed, rows.Err()
+}
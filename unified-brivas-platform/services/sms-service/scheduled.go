@@ -0,0 +1,203 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Scheduled message states
+const (
+	ScheduledPending   = "scheduled"
+	ScheduledSending   = "sending"
+	ScheduledSent      = "sent"
+	ScheduledFailed    = "failed"
+	ScheduledCancelled = "cancelled"
+)
+
+const (
+	// maxScheduleHorizon is how far ahead a single message may be scheduled
+	maxScheduleHorizon = 30 * 24 * time.Hour
+	// scheduledBatchSize is how many due messages one scheduler tick sends
+	scheduledBatchSize = 100
+)
+
+// validateSchedule rejects schedule times that are not in the future or
+// are beyond maxScheduleHorizon
+func validateSchedule(at, now time.Time) error {
+	switch {
+	case !at.After(now):
+		return errors.New("scheduled_at must be in the future")
+	case at.Sub(now) > maxScheduleHorizon:
+		return fmt.Errorf("scheduled_at must be within %d days", int(maxScheduleHorizon/(24*time.Hour)))
+	}
+	return nil
+}
+
+// availableBalance is the account's balance less what its live scheduled
+// messages have reserved
+func (s *Service) availableBalance(ctx context.Context, accountID string) (float64, error) {
+	var balance float64
+	err := s.db.QueryRow(ctx, `
+		SELECT a.balance - COALESCE((
+			SELECT SUM(rate_per_sms) FROM scheduled_messages
+			WHERE account_id = a.id AND status = 'scheduled' AND is_live = TRUE
+		), 0)
+		FROM accounts a WHERE a.id = $1
+	`, accountID).Scan(&balance)
+	return balance, err
+}
+
+// scheduleMessage holds msg until its ScheduledAt, reserving its rate, and
+// returns the id it can be cancelled by
+func (s *Service) scheduleMessage(ctx context.Context, msg *Message) (string, error) {
+	id := "SCH-" + uuid.NewString()
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO scheduled_messages
+		(id, account_id, sid, sender, recipient, message, network, rate_per_sms, is_live, scheduled_at, webhook)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''))
+	`, id, msg.AccountID, msg.SID, msg.From, msg.To, msg.Body, msg.Network, msg.RatePerSMS, msg.IsLive, msg.ScheduledAt.UTC(), msg.Webhook)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// handleCancelSend cancels a scheduled message that has not been sent yet,
+// releasing its reserved balance
+func (s *Service) handleCancelSend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID, id := r.Header.Get("X-Account-ID"), chi.URLParam(r, "id")
+
+	var status string
+	err := s.db.QueryRow(ctx, `
+		UPDATE scheduled_messages SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND account_id = $2 AND status = 'scheduled'
+		RETURNING status
+	`, id, accountID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Missing, or already sent or cancelled
+		err = s.db.QueryRow(ctx, `
+			SELECT status FROM scheduled_messages WHERE id = $1 AND account_id = $2
+		`, id, accountID).Scan(&status)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			s.jsonError(w, r, "scheduled message not found", http.StatusNotFound)
+		case err != nil:
+			s.jsonError(w, r, "failed to cancel message", http.StatusInternalServerError)
+		default:
+			s.jsonError(w, r, "message can no longer be cancelled (message is "+status+")", http.StatusConflict)
+		}
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to cancel message", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "scheduled message cancelled",
+		"data":   map[string]string{"id": id},
+	}, http.StatusOK)
+}
+
+// sendDueMessages claims scheduled messages that are due and sends them.
+// Claiming moves them to sending, so two schedulers never send one twice.
+func (s *Service) sendDueMessages(ctx context.Context) {
+	rows, err := s.db.Query(ctx, `
+		UPDATE scheduled_messages SET status = 'sending', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM scheduled_messages
+			WHERE status = 'scheduled' AND scheduled_at <= NOW()
+			ORDER BY scheduled_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, account_id, sid, COALESCE(sender, ''), recipient, message, COALESCE(network, ''),
+			rate_per_sms, COALESCE(is_live, FALSE), scheduled_at, COALESCE(webhook, '')
+	`, scheduledBatchSize)
+	if err != nil {
+		s.logger.Warn("failed to claim due messages", zap.Error(err))
+		return
+	}
+	type due struct {
+		id  string
+		msg *Message
+	}
+	var messages []due
+	for rows.Next() {
+		d := due{msg: &Message{Type: "sms-otp", Status: "pending"}}
+		var scheduledAt time.Time
+		if err := rows.Scan(&d.id, &d.msg.AccountID, &d.msg.SID, &d.msg.From, &d.msg.To, &d.msg.Body,
+			&d.msg.Network, &d.msg.RatePerSMS, &d.msg.IsLive, &scheduledAt, &d.msg.Webhook); err != nil {
+			s.logger.Warn("failed to scan due message", zap.Error(err))
+			continue
+		}
+		d.msg.ScheduledAt = &scheduledAt
+		messages = append(messages, d)
+	}
+	rows.Close()
+
+	// Claimed messages are sent even if the scheduler is stopping, so none
+	// is left in sending
+	ctx = context.WithoutCancel(ctx)
+	for _, d := range messages {
+		status, errMsg := ScheduledSent, ""
+		if err := s.sendScheduled(ctx, d.msg); err != nil {
+			status, errMsg = ScheduledFailed, err.Error()
+			s.logger.Warn("scheduled message failed", zap.String("id", d.id), zap.Error(err))
+		}
+		if _, err := s.db.Exec(ctx, `
+			UPDATE scheduled_messages
+			SET status = $2, error = NULLIF($3, ''), sent_at = CASE WHEN $2 = 'sent' THEN NOW() END, updated_at = NOW()
+			WHERE id = $1
+		`, d.id, status, errMsg); err != nil {
+			s.logger.Error("failed to record scheduled message outcome", zap.String("id", d.id), zap.Error(err))
+		}
+	}
+}
+
+// sendScheduled sends a claimed message, logs it and charges the account
+// like handleSend does
+func (s *Service) sendScheduled(ctx context.Context, msg *Message) error {
+	if msg.IsLive {
+		balance, err := s.availableBalance(ctx, msg.AccountID)
+		if err != nil {
+			return fmt.Errorf("failed to check balance: %w", err)
+		}
+		if balance < msg.RatePerSMS {
+			return errInsufficientBalance
+		}
+		if err := s.reserveQuota(ctx, msg.AccountID, 1); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	msg.SentDate, msg.SentTime = now.Format("2006-01-02"), now.Format("15:04:05.000")
+
+	result, err := s.sendViaProvider(ctx, msg)
+	if err != nil {
+		msg.Status = "failed"
+		s.logSMS(ctx, msg)
+		if msg.IsLive {
+			s.releaseQuota(ctx, msg.AccountID, msg.SID, 1)
+		}
+		return fmt.Errorf("send failed: %w", err)
+	}
+
+	msg.RID = result.MessageID
+	s.logSMS(ctx, msg)
+	if msg.IsLive {
+		s.deductBalance(ctx, msg.AccountID, msg.RatePerSMS, msg.SID)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package sms
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// Sender ID review states
+const (
+	SenderIDPending  = "pending"
+	SenderIDApproved = "approved"
+	SenderIDRejected = "rejected"
+)
+
+// Sender ID status change events sent to account webhooks
+const (
+	EventSenderIDApproved = "sender_id.approved"
+	EventSenderIDRejected = "sender_id.rejected"
+)
+
+// senderPattern matches alphanumeric sender IDs, which networks cap at 11
+// characters
+var senderPattern = regexp.MustCompile(`^[A-Za-z0-9]{3,11}$`)
+
+// senderIDTypes are the message types a sender ID can be requested for
+var senderIDTypes = map[string]bool{"promotional": true, "transactional": true, "corporate": true}
+
+// SenderID is a sender ID request and its review state
+type SenderID struct {
+	ID         int        `json:"id"`
+	AccountID  string     `json:"account_id"`
+	Sender     string     `json:"sender"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Approved   bool       `json:"approved"`
+	Reason     string     `json:"reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// isAdminRequest reports whether the auth middleware resolved an admin role
+func isAdminRequest(r *http.Request) bool {
+	role := r.Header.Get("X-Role")
+	return role == "admin" || role == "super_admin"
+}
+
+// handleRequestSenderID files a sender ID for admin review. A sender is
+// unique per message type; an account may request one of its own rejected
+// senders again.
+func (s *Service) handleRequestSenderID(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Sender string          `json:"sender"`
+		Type   string          `json:"type"`
+		Docs   json.RawMessage `json:"docs,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Type == "" {
+		req.Type = "promotional"
+	}
+	if !senderPattern.MatchString(req.Sender) {
+		s.jsonError(w, r, "sender must be 3-11 letters or digits", http.StatusBadRequest)
+		return
+	}
+	if !senderIDTypes[req.Type] {
+		s.jsonError(w, r, "type must be promotional, transactional or corporate", http.StatusBadRequest)
+		return
+	}
+
+	accountID := r.Header.Get("X-Account-ID")
+	if accountID == "" {
+		s.jsonError(w, r, "account required", http.StatusUnauthorized)
+		return
+	}
+
+	var docs []byte
+	if len(req.Docs) > 0 {
+		docs = req.Docs
+	}
+
+	ctx := r.Context()
+	sid := SenderID{AccountID: accountID, Sender: req.Sender, Type: req.Type, Status: SenderIDPending}
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO sender_ids (account_id, sender, type, status, docs, approved)
+		VALUES ($1, $2, $3, 'pending', $4, FALSE)
+		ON CONFLICT (sender, type) DO UPDATE
+		SET status = 'pending', docs = EXCLUDED.docs, reason = NULL,
+			reviewed_by = NULL, reviewed_at = NULL, created_at = NOW()
+		WHERE sender_ids.account_id = EXCLUDED.account_id AND sender_ids.status = 'rejected'
+		RETURNING id, created_at
+	`, accountID, req.Sender, req.Type, docs).Scan(&sid.ID, &sid.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "sender ID already registered for this type", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to request sender ID", zap.Error(err))
+		s.jsonError(w, r, "failed to request sender ID", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "sender ID submitted for review",
+		"data":   sid,
+	}, http.StatusCreated)
+}
+
+// handleListSenderIDs lists the account's sender IDs with their review
+// state, optionally filtered by ?status=
+func (s *Service) handleListSenderIDs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID := r.Header.Get("X-Account-ID")
+	status := r.URL.Query().Get("status")
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, account_id, sender, type, COALESCE(status, 'pending'), approved,
+			COALESCE(reason, ''), created_at, reviewed_at
+		FROM sender_ids
+		WHERE account_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+	`, accountID, status)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch sender IDs", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	senderIDs := make([]SenderID, 0)
+	for rows.Next() {
+		var sid SenderID
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&sid.ID, &sid.AccountID, &sid.Sender, &sid.Type, &sid.Status,
+			&sid.Approved, &sid.Reason, &sid.CreatedAt, &reviewedAt); err != nil {
+			continue
+		}
+		if reviewedAt.Valid {
+			sid.ReviewedAt = &reviewedAt.Time
+		}
+		senderIDs = append(senderIDs, sid)
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   senderIDs,
+	}, http.StatusOK)
+}
+
+// handleApproveSenderID approves a sender ID so validateSenderID accepts it
+func (s *Service) handleApproveSenderID(w http.ResponseWriter, r *http.Request) {
+	s.reviewSenderID(w, r, SenderIDApproved, "")
+}
+
+// handleRejectSenderID rejects or revokes a sender ID; a reason is required
+func (s *Service) handleRejectSenderID(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+		s.jsonError(w, r, "a rejection reason is required", http.StatusBadRequest)
+		return
+	}
+	s.reviewSenderID(w, r, SenderIDRejected, req.Reason)
+}
+
+// reviewSenderID moves a sender ID to status and notifies its account
+func (s *Service) reviewSenderID(w http.ResponseWriter, r *http.Request, status, reason string) {
+	if !isAdminRequest(r) {
+		s.jsonError(w, r, "admin role required", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		s.jsonError(w, r, "invalid sender ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sid := SenderID{ID: id, Status: status, Approved: status == SenderIDApproved, Reason: reason}
+	var reviewedAt time.Time
+	err = s.db.QueryRow(ctx, `
+		UPDATE sender_ids
+		SET status = $2, approved = $3, reason = NULLIF($4, ''), reviewed_by = $5, reviewed_at = NOW()
+		WHERE id = $1 AND COALESCE(status, 'pending') <> $2
+		RETURNING account_id, sender, type, created_at, reviewed_at
+	`, id, status, sid.Approved, reason, r.Header.Get("X-Account-ID")).
+		Scan(&sid.AccountID, &sid.Sender, &sid.Type, &sid.CreatedAt, &reviewedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sender_ids WHERE id = $1)`, id).Scan(&exists)
+		if !exists {
+			s.jsonError(w, r, "sender ID not found", http.StatusNotFound)
+			return
+		}
+		s.jsonError(w, r, "sender ID is already "+status, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to review sender ID", zap.Int("id", id), zap.Error(err))
+		s.jsonError(w, r, "failed to update sender ID", http.StatusInternalServerError)
+		return
+	}
+	sid.ReviewedAt = &reviewedAt
+
+	event := EventSenderIDApproved
+	if status == SenderIDRejected {
+		event = EventSenderIDRejected
+	}
+	s.notifyAccount(ctx, sid.AccountID, event, sid)
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "sender ID " + status,
+		"data":   sid,
+	}, http.StatusOK)
+}
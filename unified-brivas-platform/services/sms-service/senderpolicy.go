@@ -0,0 +1,255 @@
+package sms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Sender ID kinds
+const (
+	SenderAlphanumeric = "alphanumeric"
+	SenderLongCode     = "long_code"  // a full phone number
+	SenderShortCode    = "short_code" // up to 8 digits
+)
+
+const (
+	maxShortCodeLength    = 8
+	maxLongCodeLength     = 15 // E.164
+	maxAlphanumericLength = 11
+)
+
+var (
+	// errSenderPolicy rejects a sender the destination does not accept
+	errSenderPolicy = errors.New("sender ID not allowed")
+	// errInvalidSender rejects a sender no carrier accepts
+	errInvalidSender = errors.New("invalid sender ID")
+)
+
+// SenderPolicy is what sender IDs a destination accepts. Empty Network and
+// MessageType match any.
+type SenderPolicy struct {
+	CountryCode          string `json:"country_code"`
+	Network              string `json:"network,omitempty"`
+	MessageType          string `json:"message_type,omitempty"`
+	AllowAlphanumeric    bool   `json:"allow_alphanumeric"`
+	AllowLongCode        bool   `json:"allow_long_code"`
+	AllowShortCode       bool   `json:"allow_short_code"`
+	RegistrationRequired bool   `json:"registration_required"`
+	DefaultSender        string `json:"default_sender,omitempty"`
+	Note                 string `json:"note,omitempty"`
+}
+
+func (p *SenderPolicy) allows(kind string) bool {
+	switch kind {
+	case SenderAlphanumeric:
+		return p.AllowAlphanumeric
+	case SenderLongCode:
+		return p.AllowLongCode
+	case SenderShortCode:
+		return p.AllowShortCode
+	}
+	return false
+}
+
+// senderKind classifies a sender ID, or returns "" for one no carrier
+// accepts
+func senderKind(sender string) string {
+	kind, _ := checkSender(sender)
+	return kind
+}
+
+// checkSender classifies a sender ID, or says why no carrier accepts it
+func checkSender(sender string) (string, error) {
+	invalid := func(format string, args ...interface{}) (string, error) {
+		return "", fmt.Errorf("%w: "+format, append([]interface{}{errInvalidSender}, args...)...)
+	}
+
+	digits, plus := strings.CutPrefix(sender, "+")
+	numeric := digits != ""
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			numeric = false
+			break
+		}
+	}
+	switch {
+	case sender == "":
+		return invalid("sender ID is empty")
+	case numeric && len(digits) <= maxShortCodeLength:
+		return SenderShortCode, nil
+	case numeric && len(digits) <= maxLongCodeLength:
+		return SenderLongCode, nil
+	case numeric:
+		return invalid("numeric sender IDs are at most %d digits, got %d", maxLongCodeLength, len(digits))
+	case plus:
+		return invalid("a sender ID starting with + must be a phone number")
+	}
+
+	if n := utf8.RuneCountInString(sender); n > maxAlphanumericLength {
+		return invalid("alphanumeric sender IDs are at most %d characters, got %d", maxAlphanumericLength, n)
+	}
+	if strings.TrimSpace(sender) != sender {
+		return invalid("sender IDs must not start or end with a space")
+	}
+	letters := false
+	for _, r := range sender {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters = true
+		case r >= '0' && r <= '9', strings.ContainsRune(" .-_&", r):
+		default:
+			return invalid("alphanumeric sender IDs may only contain letters, digits, spaces and . - _ &, not %q", r)
+		}
+	}
+	if !letters {
+		return invalid("alphanumeric sender IDs need at least one letter")
+	}
+	return SenderAlphanumeric, nil
+}
+
+type senderPolicyKey struct {
+	country     string
+	network     string
+	messageType string
+}
+
+// senderPolicyTable is an in-memory copy of sender_id_policies
+type senderPolicyTable struct {
+	mu        sync.RWMutex
+	policies  map[senderPolicyKey]SenderPolicy
+	countries []string // longest first
+}
+
+func newSenderPolicyTable() *senderPolicyTable {
+	return &senderPolicyTable{policies: make(map[senderPolicyKey]SenderPolicy)}
+}
+
+// set replaces the table's policies
+func (t *senderPolicyTable) set(policies []SenderPolicy) {
+	byKey := make(map[senderPolicyKey]SenderPolicy, len(policies))
+	seen := make(map[string]bool)
+	var countries []string
+	for _, p := range policies {
+		byKey[senderPolicyKey{country: p.CountryCode, network: p.Network, messageType: p.MessageType}] = p
+		if !seen[p.CountryCode] {
+			seen[p.CountryCode] = true
+			countries = append(countries, p.CountryCode)
+		}
+	}
+	sort.Slice(countries, func(i, j int) bool { return len(countries[i]) > len(countries[j]) })
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.policies = byKey
+	t.countries = countries
+}
+
+// lookup finds the most specific policy for a formatted recipient: its
+// country by longest dial prefix, then an exact network over any and an
+// exact message type over any
+func (t *senderPolicyTable) lookup(recipient, network, msgType string) (SenderPolicy, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, country := range t.countries {
+		if !strings.HasPrefix(recipient, country) {
+			continue
+		}
+		for _, n := range []string{network, ""} {
+			for _, m := range []string{msgType, ""} {
+				if p, ok := t.policies[senderPolicyKey{country: country, network: n, messageType: m}]; ok {
+					return p, true
+				}
+			}
+		}
+		return SenderPolicy{}, false
+	}
+	return SenderPolicy{}, false
+}
+
+// applySenderPolicy picks the sender for a message under policy p: the
+// requested one if the destination accepts its kind, else the policy's or
+// the platform's default. needsRegistration reports that the requested
+// sender must also be an approved sender ID.
+func applySenderPolicy(p SenderPolicy, requested, fallback string) (sender string, needsRegistration bool, err error) {
+	explain := func(msg string) error {
+		if p.Note != "" {
+			msg += ": " + p.Note
+		}
+		return fmt.Errorf("%w: %s", errSenderPolicy, msg)
+	}
+
+	if requested == "" {
+		if p.DefaultSender != "" {
+			return p.DefaultSender, false, nil
+		}
+		if !p.allows(senderKind(fallback)) {
+			return "", false, explain(fmt.Sprintf("a sender ID is required for +%s recipients", p.CountryCode))
+		}
+		return fallback, false, nil
+	}
+
+	kind, err := checkSender(requested)
+	if err != nil {
+		return "", false, err
+	}
+	if !p.allows(kind) {
+		return "", false, explain(fmt.Sprintf("%s sender IDs are not accepted for +%s recipients",
+			strings.ReplaceAll(kind, "_", " "), p.CountryCode))
+	}
+	return requested, p.RegistrationRequired, nil
+}
+
+// resolveSender returns the sender for a message to a formatted recipient,
+// or errSenderPolicy when the destination does not accept the requested
+// one. Destinations without a policy accept any sender.
+func (s *Service) resolveSender(recipient, network, msgType, requested, fallback string) (string, bool, error) {
+	if s.senderPolicies != nil {
+		if p, ok := s.senderPolicies.lookup(recipient, network, msgType); ok {
+			return applySenderPolicy(p, requested, fallback)
+		}
+	}
+	if requested == "" {
+		return fallback, false, nil
+	}
+	return requested, false, nil
+}
+
+// LoadSenderPolicies reloads the sender ID policies
+func (s *Service) LoadSenderPolicies(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT country_code, network, message_type, COALESCE(allow_alphanumeric, TRUE),
+			COALESCE(allow_long_code, TRUE), COALESCE(allow_short_code, TRUE),
+			COALESCE(registration_required, FALSE), default_sender, note
+		FROM sender_id_policies WHERE active = TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load sender ID policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []SenderPolicy
+	for rows.Next() {
+		var p SenderPolicy
+		var network, msgType, defaultSender, note sql.NullString
+		if err := rows.Scan(&p.CountryCode, &network, &msgType, &p.AllowAlphanumeric,
+			&p.AllowLongCode, &p.AllowShortCode, &p.RegistrationRequired, &defaultSender, &note); err != nil {
+			return fmt.Errorf("failed to scan sender ID policy: %w", err)
+		}
+		p.Network, p.MessageType = network.String, msgType.String
+		p.DefaultSender, p.Note = defaultSender.String, note.String
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.senderPolicies.set(policies)
+	return nil
+}
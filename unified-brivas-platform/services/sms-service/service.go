@@ -5,25 +5,51 @@ package sms
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
+	"github.com/brivas/unified-platform/packages/apierr"
 	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
 )
 
 // Service handles all SMS operations
 type Service struct {
-	db           *lumadb.Client
-	logger       *zap.Logger
-	providers    map[string]SMSProvider
-	dlrBuffer    *DLRBuffer
-	networkCodes map[string]string
+	db             lumadb.Querier
+	logger         *zap.Logger
+	providers      map[string]SMSProvider // guarded by providersMu
+	providersMu    sync.RWMutex
+	dlrBuffer      *DLRBuffer
+	networkCodes   map[string]string
+	rates          *rateTable
+	senderPolicies *senderPolicyTable
+	webhooks       *WebhookDispatcher
+	dispatch       *dispatcher
+	shortLinkBase  string
+	content        *contentFilter
+	duplicates     *duplicateDetector
+	quota          atomic.Pointer[quotaHolder]
+	// heartbeats of the background workers, by name; fixed by NewService
+	heartbeats map[string]*heartbeat
+
+	// runners are the campaigns this instance is sending
+	runners   map[string]bool
+	runnersMu sync.Mutex
+
+	// Background workers run until Close cancels them
+	stop           context.CancelFunc
+	workers        sync.WaitGroup
+	closeOnce      sync.Once
+	flushBatchSize int
+
+	dlrLocation *time.Location
 }
 
 // SMSProvider interface for SMS gateway providers
@@ -51,8 +77,8 @@ type Message struct {
 	RatePerSMS   float64                `json:"rate_per_sms"`
 	OriginBucket int                    `json:"origin_bucket_id"`
 	UserAppID    int                    `json:"u_aid"`
-	Network      string                 `json:"network"` // MTN, AIRTEL, GLO, 9MOBILE
-	Webhook      string                 `json:"webhook,omitempty"`
+	Network      string                 `json:"network"`           // MTN, AIRTEL, GLO, 9MOBILE
+	Webhook      string                 `json:"webhook,omitempty"` // receives delivery reports instead of the app's webhook
 	ScheduledAt  *time.Time             `json:"scheduled_at,omitempty"`
 	SentDate     string                 `json:"sent_date"`
 	SentTime     string                 `json:"sent_time"`
@@ -76,16 +102,18 @@ type DeliveryStatus struct {
 	To          string     `json:"to"`
 	From        string     `json:"from"`
 	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	LatencyMS   *int64     `json:"latency_ms,omitempty"` // from send to DeliveredAt
+	Attempts    int        `json:"attempts,omitempty"`   // as reported by the carrier
 	ErrorCode   string     `json:"error_code,omitempty"`
 	ErrorMsg    string     `json:"error_message,omitempty"`
 }
 
 // DLRBuffer buffers delivery report updates for batch processing
 type DLRBuffer struct {
-	delivered []string
-	failed    []string
+	delivered []dlrUpdate
+	failed    []dlrUpdate
 	mu        sync.Mutex
-	db        *lumadb.Client
+	db        lumadb.Querier
 	logger    *zap.Logger
 }
 
@@ -98,6 +126,13 @@ type BulkSendRequest struct {
 	Type       string   `json:"type"` // promotional, transactional
 	ScheduleAt string   `json:"schedule_at,omitempty"`
 	LabelID    string   `json:"label_id,omitempty"`
+	// AllowDuplicates sends to a number once per time it is listed
+	AllowDuplicates bool `json:"allow_duplicates,omitempty"`
+	// Webhook receives the messages' delivery reports instead of the app's
+	// webhook; checked with checkMessageWebhook
+	Webhook string `json:"webhook,omitempty"`
+	// Bodies overrides Message per formatted recipient, e.g. with tracked links
+	Bodies map[string]string `json:"-"`
 }
 
 // BulkSendResponse represents bulk send response
@@ -110,35 +145,78 @@ type BulkSendResponse struct {
 
 // Config for SMS service
 type Config struct {
-	MaxBulkRecipients int
-	TestMaxRecipients int
-	FlushInterval     time.Duration
-	FlushBatchSize    int
+	MaxBulkRecipients      int
+	TestMaxRecipients      int
+	FlushInterval          time.Duration
+	FlushBatchSize         int
+	RateRefreshInterval    time.Duration
+	CampaignPollInterval   time.Duration
+	WebhookMaxAttempts     int
+	WebhookConcurrency     int
+	ShortLinkBaseURL       string
+	ContentRefreshInterval time.Duration
+	// WebhookAllow lists trusted internal hosts, addresses and networks
+	// webhooks may be delivered to; other non-public ones are refused
+	WebhookAllow []string
+	// DLRLocation is the zone of carrier DLR dates that carry none
+	DLRLocation *time.Location
+	// DispatchWorkers and PriorityWeight size the provider worker pool
+	// and how many priority jobs it takes before a promotional one
+	DispatchWorkers int
+	PriorityWeight  int
+	// DuplicateSimilarity and DuplicateLookback decide when a launch is
+	// warned about as a near-copy of a recent campaign
+	DuplicateSimilarity float64
+	DuplicateLookback   time.Duration
 }
 
 // DefaultConfig returns default SMS service config
 func DefaultConfig() *Config {
 	return &Config{
-		MaxBulkRecipients: 1000,
-		TestMaxRecipients: 5,
-		FlushInterval:     30 * time.Second,
-		FlushBatchSize:    25,
+		MaxBulkRecipients:      1000,
+		TestMaxRecipients:      5,
+		FlushInterval:          30 * time.Second,
+		FlushBatchSize:         25,
+		RateRefreshInterval:    DefaultRateRefreshInterval,
+		CampaignPollInterval:   DefaultCampaignPollInterval,
+		WebhookMaxAttempts:     DefaultWebhookMaxAttempts,
+		WebhookConcurrency:     DefaultWebhookConcurrency,
+		ShortLinkBaseURL:       DefaultShortLinkBaseURL,
+		ContentRefreshInterval: DefaultContentRefreshInterval,
+		DLRLocation:            time.UTC,
+		DispatchWorkers:        DefaultDispatchWorkers,
+		PriorityWeight:         DefaultPriorityWeight,
+		DuplicateSimilarity:    DefaultDuplicateSimilarity,
+		DuplicateLookback:      DefaultDuplicateLookback,
 	}
 }
 
 // NewService creates a new SMS service
-func NewService(db *lumadb.Client, logger *zap.Logger, cfg *Config) *Service {
+func NewService(db lumadb.Querier, logger *zap.Logger, cfg *Config) *Service {
 	if cfg == nil {
 		cfg = DefaultConfig()
 	}
 
+	refresh := cfg.RateRefreshInterval
+	if refresh <= 0 {
+		refresh = DefaultRateRefreshInterval
+	}
+	poll := cfg.CampaignPollInterval
+	if poll <= 0 {
+		poll = DefaultCampaignPollInterval
+	}
+	contentRefresh := cfg.ContentRefreshInterval
+	if contentRefresh <= 0 {
+		contentRefresh = DefaultContentRefreshInterval
+	}
+
 	svc := &Service{
 		db:        db,
 		logger:    logger,
 		providers: make(map[string]SMSProvider),
 		dlrBuffer: &DLRBuffer{
-			delivered: make([]string, 0),
-			failed:    make([]string, 0),
+			delivered: make([]dlrUpdate, 0),
+			failed:    make([]dlrUpdate, 0),
 			db:        db,
 			logger:    logger,
 		},
@@ -154,27 +232,130 @@ func NewService(db *lumadb.Client, logger *zap.Logger, cfg *Config) *Service {
 			"0809": "9MOBILE", "0817": "9MOBILE", "0818": "9MOBILE",
 			"0908": "9MOBILE", "0909": "9MOBILE",
 		},
+		rates:          newRateTable(),
+		senderPolicies: newSenderPolicyTable(),
+		webhooks:       NewWebhookDispatcher(db, logger, cfg.WebhookMaxAttempts, cfg.WebhookConcurrency, cfg.WebhookAllow),
+		dispatch:       newDispatcher(cfg.DispatchWorkers, cfg.PriorityWeight),
+		shortLinkBase:  cfg.ShortLinkBaseURL,
+		content:        newContentFilter(),
+		duplicates:     newDuplicateDetector(cfg.DuplicateSimilarity, cfg.DuplicateLookback),
+		flushBatchSize: cfg.FlushBatchSize,
+		dlrLocation:    cfg.DLRLocation,
+		heartbeats: map[string]*heartbeat{
+			workerDLRFlusher:        newHeartbeat(cfg.FlushInterval),
+			workerRateRefresher:     newHeartbeat(refresh),
+			workerCampaignScheduler: newHeartbeat(poll),
+			workerContentRefresher:  newHeartbeat(contentRefresh),
+			workerWebhooks:          newHeartbeat(webhookPollInterval),
+		},
 	}
+	svc.webhooks.heartbeat = svc.heartbeats[workerWebhooks]
+
+	ctx, stop := context.WithCancel(context.Background())
+	svc.stop = stop
 
 	// Start DLR flush goroutine
-	go svc.startDLRFlusher(cfg.FlushInterval, cfg.FlushBatchSize)
+	svc.runWorker(ctx, func(ctx context.Context) {
+		svc.startDLRFlusher(ctx, cfg.FlushInterval, cfg.FlushBatchSize)
+	})
+
+	svc.runWorker(ctx, func(ctx context.Context) { svc.startRateRefresher(ctx, refresh) })
+	svc.runWorker(ctx, func(ctx context.Context) { svc.startCampaignScheduler(ctx, poll) })
+	svc.runWorker(ctx, func(ctx context.Context) { svc.startContentRefresher(ctx, contentRefresh) })
+
+	svc.runWorker(ctx, svc.webhooks.Run)
+	svc.runWorker(ctx, svc.dispatch.Run)
 
 	return svc
 }
 
+// runWorker runs fn in the background; Close cancels ctx and waits for it
+func (s *Service) runWorker(ctx context.Context, fn func(ctx context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn(ctx)
+	}()
+}
+
+// Close stops the background workers, waiting for them until ctx is done,
+// then flushes the buffered delivery reports. Campaigns already running
+// are left to finish. Only the first call has any effect.
+func (s *Service) Close(ctx context.Context) error {
+	var err error
+	s.closeOnce.Do(func() {
+		if s.stop != nil {
+			s.stop()
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			s.workers.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			err = fmt.Errorf("background workers did not stop: %w", ctx.Err())
+		}
+
+		if flushErr := s.dlrBuffer.drain(ctx, s.flushBatchSize); flushErr != nil {
+			err = errors.Join(err, fmt.Errorf("final DLR flush failed: %w", flushErr))
+		}
+	})
+	return err
+}
+
 // Routes returns Chi router with SMS endpoints
 func (s *Service) Routes() chi.Router {
 	r := chi.NewRouter()
 
 	// Single SMS
 	r.Post("/send", s.handleSend)
+	r.Delete("/send/{id}", s.handleCancelSend)
 	r.Get("/history", s.handleHistory)
 
+	// Delivery status polling
+	r.Get("/status/{sid}", s.handleBatchStatus)
+	r.Get("/message/{rid}", s.handleMessageStatus)
+	r.Get("/settings/dlr", s.handleGetDLRMode)
+	r.Put("/settings/dlr", s.handleSetDLRMode)
+
 	// Bulk SMS
 	r.Post("/bulk", s.handleBulkSend)
 	r.Post("/bulk/schedule", s.handleSchedule)
 	r.Get("/bulk/history", s.handleBulkHistory)
 	r.Get("/bulk/insights", s.handleInsights)
+	r.Get("/bulk/insights/timeseries", s.handleInsightsTimeSeries)
+	r.Get("/bulk/insights/by-network", s.handleInsightsByNetwork)
+
+	// Sender IDs
+	r.Post("/sender-ids", s.handleRequestSenderID)
+	r.Get("/sender-ids", s.handleListSenderIDs)
+	r.Post("/sender-ids/{id}/approve", s.handleApproveSenderID)
+	r.Post("/sender-ids/{id}/reject", s.handleRejectSenderID)
+
+	// Campaigns
+	r.Post("/campaigns", s.handleCreateCampaign)
+	r.Get("/campaigns", s.handleListCampaigns)
+	r.Get("/campaigns/{id}", s.handleGetCampaign)
+	r.Put("/campaigns/{id}", s.handleUpdateCampaign)
+	r.Delete("/campaigns/{id}", s.handleDeleteCampaign)
+	r.Post("/campaigns/{id}/launch", s.handleLaunchCampaign)
+	r.Get("/campaigns/{id}/progress", s.handleCampaignProgress)
+	r.Post("/campaigns/{id}/pause", s.handlePauseCampaign)
+	r.Post("/campaigns/{id}/resume", s.handleResumeCampaign)
+
+	// Webhooks
+	r.Get("/webhooks/failed", s.handleFailedWebhooks)
+	r.Post("/webhooks/failed/{id}/redeliver", s.handleRedeliverWebhook)
+	r.Post("/webhooks/secret/rotate", s.handleRotateWebhookSecret)
+
+	// Content filter
+	r.Get("/content/flagged", s.handleFlaggedMessages)
+
+	// Short links
+	r.Get("/l/{code}", s.handleShortLink)
 
 	// DLR Callbacks (webhooks from providers)
 	r.Post("/dlr/mtn", s.handleMTNDLR)
@@ -187,61 +368,102 @@ func (s *Service) Routes() chi.Router {
 
 	// Balance
 	r.Get("/balance", s.handleGetBalance)
+	r.Post("/billing/topup", s.handleTopUp)
+	r.Get("/billing/transactions", s.handleListTransactions)
 
 	return r
 }
 
-// handleSend handles single SMS send
+// handleSend handles single SMS send. With a future scheduled_at the
+// message is held for the scheduler instead, reserving its rate.
 func (s *Service) handleSend(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var req struct {
-		To      string `json:"to"`
-		From    string `json:"from,omitempty"`
-		Message string `json:"message"`
+		To          string     `json:"to"`
+		From        string     `json:"from,omitempty"`
+		Message     string     `json:"message"`
+		ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+		Webhook     string     `json:"webhook,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Validate
 	if req.To == "" || req.Message == "" {
-		s.jsonError(w, "missing required fields: to, message", http.StatusBadRequest)
+		s.jsonError(w, r, "missing required fields: to, message", http.StatusBadRequest)
 		return
 	}
+	if req.ScheduledAt != nil {
+		if err := validateSchedule(*req.ScheduledAt, time.Now()); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.From != "" {
+		if _, err := checkSender(req.From); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Webhook != "" {
+		if err := s.checkMessageWebhook(ctx, req.Webhook); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Get account from auth context (would be set by middleware)
 	accountID := r.Header.Get("X-Account-ID")
 	isLive := r.Header.Get("X-Is-Live") == "true"
 
-	// Check balance
-	var balance float64
-	err := s.db.QueryRow(ctx,
-		"SELECT balance FROM accounts WHERE id = $1", accountID).Scan(&balance)
+	// Check balance, less what scheduled messages have reserved
+	balance, err := s.availableBalance(ctx, accountID)
 	if err != nil {
-		s.jsonError(w, "account not found", http.StatusUnauthorized)
+		s.jsonError(w, r, "account not found", http.StatusUnauthorized)
 		return
 	}
 
-	// Get rate
-	rate := s.getRate("otp", req.To)
+	// Get rate; long messages are billed per segment
+	encoding := messageEncoding(req.Message)
+	rate := s.accountRate(accountID, "otp", req.To) * float64(encoding.Segments)
 	if isLive && balance < rate {
-		s.jsonError(w, "insufficient balance", http.StatusPaymentRequired)
+		s.jsonError(w, r, "insufficient balance", http.StatusPaymentRequired)
 		return
 	}
+	if isLive {
+		if err := s.checkQuota(ctx, accountID, 1); err != nil {
+			s.writeQuotaError(w, r, err)
+			return
+		}
+	}
 
-	// Determine network and sender
+	// Determine network and a sender the destination accepts
 	network := s.getNetwork(req.To)
-	sender := req.From
-	if sender == "" {
-		sender = s.getDefaultSender(network)
+	sender, needsRegistration, err := s.resolveSender(s.formatNumber(req.To), network, "otp", req.From, s.getDefaultSender(network))
+	if err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if needsRegistration {
+		if valid, err := s.validateSenderID(ctx, accountID, sender, "transactional"); err != nil || !valid {
+			s.jsonError(w, r, "sender not approved: the destination only accepts registered sender IDs", http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Generate SID
 	sid := s.generateSID(accountID, "P2P")
 
+	// Screen content before anything is sent or charged
+	if err := s.screenContent(ctx, accountID, sid, sender, req.Message, 1); err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
 	// Create message record
 	msg := &Message{
 		AccountID:  accountID,
@@ -254,16 +476,51 @@ func (s *Service) handleSend(w http.ResponseWriter, r *http.Request) {
 		IsLive:     isLive,
 		RatePerSMS: rate,
 		Network:    network,
+		Webhook:    req.Webhook,
 		SentDate:   time.Now().Format("2006-01-02"),
 		SentTime:   time.Now().Format("15:04:05.000"),
 	}
 
+	if req.ScheduledAt != nil {
+		msg.ScheduledAt = req.ScheduledAt
+		id, err := s.scheduleMessage(ctx, msg)
+		if err != nil {
+			s.logger.Error("failed to schedule SMS", zap.String("sid", sid), zap.Error(err))
+			s.jsonError(w, r, "failed to schedule SMS", http.StatusInternalServerError)
+			return
+		}
+		s.jsonResponse(w, map[string]interface{}{
+			"status": "success",
+			"msg":    "SMS scheduled",
+			"data": map[string]interface{}{
+				"id":           id,
+				"sid":          sid,
+				"scheduled_at": req.ScheduledAt.UTC(),
+				"sender_type":  senderKind(sender),
+				"encoding":     encoding,
+				"cost":         rate,
+			},
+		}, http.StatusAccepted)
+		return
+	}
+
+	// A scheduled message takes its quota when it is sent
+	if isLive {
+		if err := s.reserveQuota(ctx, accountID, 1); err != nil {
+			s.writeQuotaError(w, r, err)
+			return
+		}
+	}
+
 	// Send via provider
 	result, err := s.sendViaProvider(ctx, msg)
 	if err != nil {
 		msg.Status = "failed"
 		s.logSMS(ctx, msg)
-		s.jsonError(w, "failed to send SMS", http.StatusInternalServerError)
+		if isLive {
+			s.releaseQuota(ctx, accountID, sid, 1)
+		}
+		s.jsonError(w, r, "failed to send SMS", http.StatusInternalServerError)
 		return
 	}
 
@@ -273,13 +530,18 @@ func (s *Service) handleSend(w http.ResponseWriter, r *http.Request) {
 
 	// Deduct balance
 	if isLive {
-		s.deductBalance(ctx, accountID, rate)
+		s.deductBalance(ctx, accountID, rate, sid)
 	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"status": "success",
 		"msg":    "SMS sent",
-		"data":   map[string]string{"sid": sid},
+		"data": map[string]interface{}{
+			"sid":         sid,
+			"sender_type": senderKind(sender),
+			"encoding":    encoding,
+			"cost":        rate,
+		},
 	}, http.StatusOK)
 }
 
@@ -289,13 +551,13 @@ func (s *Service) handleBulkSend(w http.ResponseWriter, r *http.Request) {
 
 	var req BulkSendRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Validate
 	if len(req.To) == 0 || req.Message == "" || req.Type == "" {
-		s.jsonError(w, "missing fields: to, message, type", http.StatusBadRequest)
+		s.jsonError(w, r, "missing fields: to, message, type", http.StatusBadRequest)
 		return
 	}
 
@@ -304,95 +566,184 @@ func (s *Service) handleBulkSend(w http.ResponseWriter, r *http.Request) {
 
 	// Validate recipient count
 	if !isLive && len(req.To) > 5 {
-		s.jsonError(w, "max 5 recipients in test mode", http.StatusBadRequest)
+		s.jsonError(w, r, "max 5 recipients in test mode", http.StatusBadRequest)
 		return
 	}
 	if len(req.To) > 1000 {
-		s.jsonError(w, "max 1000 recipients", http.StatusBadRequest)
+		s.jsonError(w, r, "max 1000 recipients", http.StatusBadRequest)
 		return
 	}
+	if req.Webhook != "" {
+		if err := s.checkMessageWebhook(ctx, req.Webhook); err != nil {
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
+	sid := s.generateSID(accountID, "BULK")
+	messages, counts, err := s.sendBulk(ctx, accountID, isLive, sid, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoRecipients), errors.Is(err, errInvalidSender):
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errSenderNotApproved):
+			s.jsonError(w, r, "sender not approved", http.StatusBadRequest)
+		case errors.Is(err, errSenderPolicy):
+			s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errInsufficientBalance):
+			s.jsonError(w, r, "insufficient balance", http.StatusPaymentRequired)
+		case errors.Is(err, errQuotaExceeded):
+			s.jsonError(w, r, err.Error(), http.StatusPaymentRequired)
+		case errors.Is(err, errContentBlocked):
+			s.jsonError(w, r, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			s.jsonError(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data": map[string]interface{}{
+			"sid":         sid,
+			"recipients":  counts,
+			"sender_type": senderKind(messages[0].From),
+			"encoding":    messageEncoding(req.Message),
+			"cost":        messagesCost(messages),
+		},
+	}, http.StatusOK)
+}
+
+// Bulk send failures callers answer with a client error
+var (
+	errSenderNotApproved   = errors.New("sender not approved")
+	errInsufficientBalance = errors.New("insufficient balance")
+	errNoRecipients        = errors.New("no valid recipients")
+)
+
+// sendBulk validates the sender, content, recipients, balance and quota,
+// then sends req under the batch sid, logs the messages and bills the
+// account.
+// Invalid, duplicate and suppressed recipients are skipped and counted; a
+// recipient whose destination does not accept the sender fails the send.
+// When the provider takes only some of the messages, those are returned,
+// logged and billed, and the rest are counted as failed.
+func (s *Service) sendBulk(ctx context.Context, accountID string, isLive bool, sid string, req *BulkSendRequest) ([]*Message, RecipientCounts, error) {
 	// Validate sender
 	sender := req.From
 	if sender == "" {
 		sender = s.getDefaultSenderByType(req.Type)
 	} else {
+		if _, err := checkSender(sender); err != nil {
+			return nil, RecipientCounts{}, err
+		}
 		valid, err := s.validateSenderID(ctx, accountID, sender, req.Type)
 		if err != nil || !valid {
-			s.jsonError(w, "sender not approved", http.StatusBadRequest)
-			return
+			return nil, RecipientCounts{}, errSenderNotApproved
 		}
 	}
 
-	// Check balance for all recipients
-	if isLive {
-		var balance float64
-		s.db.QueryRow(ctx, "SELECT balance FROM accounts WHERE id = $1", accountID).Scan(&balance)
-		totalCost := float64(len(req.To)) * s.getRate(req.Type, "")
-		if balance < totalCost {
-			s.jsonError(w, "insufficient balance", http.StatusPaymentRequired)
-			return
-		}
+	// Screen content; tracked-link bodies differ only in their short links
+	if err := s.screenContent(ctx, accountID, sid, sender, req.Message, len(req.To)); err != nil {
+		return nil, RecipientCounts{}, err
 	}
 
-	// Generate batch SID
-	sid := s.generateSID(accountID, "BULK")
+	// Skip invalid, duplicate and suppressed recipients
+	recipients, counts := s.prepareRecipients(req.To, !req.AllowDuplicates)
+	suppressed, err := s.suppressedRecipients(ctx, accountID, recipients)
+	if err != nil {
+		return nil, counts, err
+	}
 
 	// Prepare messages
-	messages := make([]*Message, 0, len(req.To))
-	for _, to := range req.To {
-		formatted := s.formatNumber(to)
+	messages := make([]*Message, 0, len(recipients))
+	for _, formatted := range recipients {
+		if suppressed[formatted] {
+			counts.Suppressed++
+			continue
+		}
+
+		// A custom sender was approved above, which covers registration
 		network := s.getNetwork(formatted)
-		if network == "" {
-			continue // Skip invalid numbers
+		from, _, err := s.resolveSender(formatted, network, req.Type, req.From, sender)
+		if err != nil {
+			return nil, counts, fmt.Errorf("%w (recipient %s)", err, formatted)
+		}
+
+		body := req.Message
+		if b, ok := req.Bodies[formatted]; ok {
+			body = b
 		}
 
 		messages = append(messages, &Message{
 			AccountID:  accountID,
 			SID:        sid,
-			From:       sender,
+			From:       from,
 			To:         formatted,
-			Body:       req.Message,
+			Body:       body,
 			Type:       "bulk-sms",
 			SMSType:    req.Type,
 			Status:     "pending",
 			IsLive:     isLive,
-			RatePerSMS: s.getRate(req.Type, formatted),
+			RatePerSMS: s.accountRate(accountID, req.Type, formatted) * float64(smsSegments(body)),
 			Network:    network,
+			Webhook:    req.Webhook,
 			SentDate:   time.Now().Format("2006-01-02"),
 			SentTime:   time.Now().Format("15:04:05.000"),
 		})
 	}
+	counts.Accepted = len(messages)
+	if len(messages) == 0 {
+		return nil, counts, errNoRecipients
+	}
 
-	// Send via bulk provider
-	results, err := s.bulkSendViaProvider(ctx, messages, sender, req.Message, req.Type)
-	if err != nil {
-		s.jsonError(w, "bulk send failed: "+err.Error(), http.StatusInternalServerError)
-		return
+	// Check balance for accepted recipients at their networks' rates, and
+	// take them from the plan's monthly quota
+	if isLive {
+		balance, _ := s.availableBalance(ctx, accountID)
+		if balance < messagesCost(messages) {
+			return nil, counts, errInsufficientBalance
+		}
+		if err := s.reserveQuota(ctx, accountID, len(messages)); err != nil {
+			return nil, counts, err
+		}
 	}
 
-	// Update message IDs from results
+	// Send via bulk provider
+	results, err := s.bulkSendViaProvider(ctx, messages, sender, req.Message, req.Type)
+	sent := make([]*Message, 0, len(messages))
 	for i, result := range results {
-		if i < len(messages) {
-			messages[i].RID = result.MessageID
-			messages[i].Status = result.Status
+		if result == nil {
+			continue
 		}
+		messages[i].RID = result.MessageID
+		messages[i].Status = result.Status
+		sent = append(sent, messages[i])
+	}
+	counts.Failed = len(messages) - len(sent)
+	if isLive {
+		s.releaseQuota(ctx, accountID, sid, counts.Failed)
+	}
+	if len(sent) == 0 {
+		if err == nil {
+			err = errors.New("provider accepted no messages")
+		}
+		return nil, counts, fmt.Errorf("bulk send failed: %w", err)
+	}
+	if counts.Failed > 0 {
+		s.logger.Warn("bulk send partially failed",
+			zap.String("sid", sid), zap.Int("sent", len(sent)), zap.Int("failed", counts.Failed), zap.Error(err))
 	}
 
-	// Bulk insert to database
-	s.bulkLogSMS(ctx, messages)
-
-	// Deduct balance
+	// Everything the provider took is logged and billed, even when other
+	// chunks failed
+	s.bulkLogSMS(ctx, sent)
 	if isLive {
-		totalCost := float64(len(results)) * s.getRate(req.Type, "")
-		s.deductBalance(ctx, accountID, totalCost)
+		s.deductBalance(ctx, accountID, messagesCost(sent), sid)
 	}
 
-	s.jsonResponse(w, map[string]interface{}{
-		"status": "success",
-		"msg":    "",
-		"data":   map[string]string{"sid": sid},
-	}, http.StatusOK)
+	return sent, counts, nil
 }
 
 // handleSMSCDLRPromotional handles SMSC promotional DLR callbacks
@@ -434,6 +785,7 @@ func (s *Service) handleSMSCDLRCorporate(w http.ResponseWriter, r *http.Request)
 // processDLRCallback processes delivery report callbacks
 func (s *Service) processDLRCallback(body map[string]interface{}, dlrType string) {
 	ctx := context.Background()
+	receivedAt := time.Now()
 
 	// Extract fields from callback
 	var status, messageID, to, from string
@@ -475,15 +827,25 @@ func (s *Service) processDLRCallback(body map[string]interface{}, dlrType string
 	}
 
 	// Queue for batch update
-	s.dlrBuffer.queue(messageID, normalizedStatus)
+	update := dlrUpdate{
+		messageID: messageID,
+		doneAt:    parseDLRTimes(body, s.dlrLocation, receivedAt),
+		attempts:  parseDLRAttempts(body),
+	}
+	s.dlrBuffer.queueUpdate(normalizedStatus, update)
 
 	// Send webhook if configured
-	s.sendWebhook(ctx, messageID, DeliveryStatus{
+	delivery := DeliveryStatus{
 		MessageID: messageID,
 		Status:    normalizedStatus,
 		To:        to,
 		From:      from,
-	})
+		Attempts:  update.attempts,
+	}
+	if normalizedStatus == "delivered" {
+		delivery.DeliveredAt = &update.doneAt
+	}
+	s.sendWebhook(ctx, messageID, delivery)
 
 	// Refund if failed
 	if normalizedStatus == "failed" {
@@ -533,7 +895,7 @@ func (s *Service) handleHistory(w http.ResponseWriter, r *http.Request) {
 		LIMIT $2 OFFSET $3
 	`, accountID, limit, offset)
 	if err != nil {
-		s.jsonError(w, "failed to fetch history", http.StatusInternalServerError)
+		s.jsonError(w, r, "failed to fetch history", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
@@ -592,11 +954,13 @@ func (s *Service) handleInsights(w http.ResponseWriter, r *http.Request) {
 		WHERE account_id = $1 AND type = 'bulk-sms'
 	`, accountID).Scan(&totalSent, &totalDelivered, &totalFailed)
 
+	// Queue depths are service-wide, to show whether priority sends wait
 	s.jsonResponse(w, map[string]interface{}{
-		"data": map[string]int{
+		"data": map[string]interface{}{
 			"total_sent":      totalSent,
 			"total_delivered": totalDelivered,
 			"total_failed":    totalFailed,
+			"queue_depth":     s.dispatch.queueDepths(),
 		},
 	}, http.StatusOK)
 }
@@ -646,19 +1010,6 @@ func (s *Service) formatNumber(number string) string {
 	return number
 }
 
-func (s *Service) getRate(msgType, recipient string) float64 {
-	rates := map[string]float64{
-		"otp":           3.0,
-		"transactional": 3.0,
-		"promotional":   2.5,
-		"corporate":     3.0,
-	}
-	if rate, ok := rates[msgType]; ok {
-		return rate
-	}
-	return 3.0
-}
-
 func (s *Service) getDefaultSender(network string) string {
 	senders := map[string]string{
 		"MTN":     "BRIVAS",
@@ -690,9 +1041,51 @@ func (s *Service) validateSenderID(ctx context.Context, accountID, sender, smsTy
 	return count > 0, err
 }
 
+// RegisterProvider adds a gateway provider, replacing any registered under
+// the same name. Providers can be added and removed while sending.
+func (s *Service) RegisterProvider(p SMSProvider) {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	if s.providers == nil {
+		s.providers = make(map[string]SMSProvider)
+	}
+	s.providers[p.Name()] = p
+}
+
+// UnregisterProvider removes the named provider, reporting whether it was
+// registered. Sends already handed to it are not interrupted.
+func (s *Service) UnregisterProvider(name string) bool {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	_, ok := s.providers[name]
+	delete(s.providers, name)
+	return ok
+}
+
+// provider returns a registered provider, or nil when there is none
+func (s *Service) provider() SMSProvider {
+	s.providersMu.RLock()
+	defer s.providersMu.RUnlock()
+	for _, p := range s.providers {
+		return p
+	}
+	return nil
+}
+
+// sendViaProvider submits msg through its type's dispatch lane
 func (s *Service) sendViaProvider(ctx context.Context, msg *Message) (*SendResult, error) {
+	var result *SendResult
+	err := s.dispatch.dispatch(ctx, laneFor(msg.Type, msg.SMSType), 1, func(ctx context.Context) error {
+		var err error
+		result, err = s.submitToProvider(ctx, msg)
+		return err
+	})
+	return result, err
+}
+
+func (s *Service) submitToProvider(ctx context.Context, msg *Message) (*SendResult, error) {
 	// Select provider based on network/type
-	for _, provider := range s.providers {
+	if provider := s.provider(); provider != nil {
 		return provider.Send(ctx, msg)
 	}
 	// Mock response for demo
@@ -703,7 +1096,43 @@ func (s *Service) sendViaProvider(ctx context.Context, msg *Message) (*SendResul
 	}, nil
 }
 
+// bulkSendViaProvider submits msgs through smsType's dispatch lane in
+// chunks, so priority sends can be taken between them. The results line
+// up with msgs, nil for each message the provider did not take, and the
+// error joins those of the failed chunks.
 func (s *Service) bulkSendViaProvider(ctx context.Context, msgs []*Message, sender, message, smsType string) ([]*SendResult, error) {
+	lane := laneFor(smsType)
+	results := make([]*SendResult, len(msgs))
+	errs := make([]error, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for start := 0; start < len(msgs); start += dispatchChunkSize {
+		chunk := msgs[start:min(start+dispatchChunkSize, len(msgs))]
+		wg.Add(1)
+		go func(start int, chunk []*Message) {
+			defer wg.Done()
+			err := s.dispatch.dispatch(ctx, lane, len(chunk), func(ctx context.Context) error {
+				// A provider may accept only part of a chunk, and report
+				// what it did accept along with an error
+				chunkResults, err := s.bulkSubmitToProvider(ctx, chunk)
+				copy(results[start:start+len(chunk)], chunkResults)
+				return err
+			})
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(start, chunk)
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
+
+func (s *Service) bulkSubmitToProvider(ctx context.Context, msgs []*Message) ([]*SendResult, error) {
+	if provider := s.provider(); provider != nil {
+		return provider.BulkSend(ctx, msgs)
+	}
 	results := make([]*SendResult, len(msgs))
 	for i := range msgs {
 		results[i] = &SendResult{
@@ -718,9 +1147,9 @@ func (s *Service) bulkSendViaProvider(ctx context.Context, msgs []*Message, send
 func (s *Service) logSMS(ctx context.Context, msg *Message) {
 	s.db.Exec(ctx, `
 		INSERT INTO sms_history 
-		(account_id, sid, rid, sender, recipient, message, status, type, sms_type, rate_per_sms, is_live, sent_date, sent_time)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
-	`, msg.AccountID, msg.SID, msg.RID, msg.From, msg.To, msg.Body, msg.Status, msg.Type, msg.SMSType, msg.RatePerSMS, msg.IsLive, msg.SentDate, msg.SentTime)
+		(account_id, sid, rid, sender, recipient, message, status, type, sms_type, rate_per_sms, is_live, sent_date, sent_time, network, webhook)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NULLIF($15, ''))
+	`, msg.AccountID, msg.SID, msg.RID, msg.From, msg.To, msg.Body, msg.Status, msg.Type, msg.SMSType, msg.RatePerSMS, msg.IsLive, msg.SentDate, msg.SentTime, msg.Network, msg.Webhook)
 }
 
 func (s *Service) bulkLogSMS(ctx context.Context, msgs []*Message) {
@@ -729,58 +1158,61 @@ func (s *Service) bulkLogSMS(ctx context.Context, msgs []*Message) {
 	}
 }
 
-func (s *Service) deductBalance(ctx context.Context, accountID string, amount float64) {
-	s.db.Exec(ctx, "UPDATE accounts SET balance = balance - $1 WHERE id = $2", amount, accountID)
-}
-
-func (s *Service) refundFailedSMS(ctx context.Context, messageID string) {
-	// Get message details and refund
-	var accountID string
-	var rate float64
-	var isLive bool
-	err := s.db.QueryRow(ctx, `
-		SELECT account_id, rate_per_sms, is_live FROM sms_history WHERE rid = $1
-	`, messageID).Scan(&accountID, &rate, &isLive)
-	if err != nil || !isLive {
-		return
-	}
-	s.db.Exec(ctx, "UPDATE accounts SET balance = balance + $1 WHERE id = $2", rate, accountID)
-}
-
+// sendWebhook queues a delivery report for the message's own webhook, or
+// its app's when it was sent without one
 func (s *Service) sendWebhook(ctx context.Context, messageID string, status DeliveryStatus) {
-	// Get webhook URL from message or user app
-	var webhook string
+	var accountID, webhook, mode string
+	var sentAt time.Time
 	s.db.QueryRow(ctx, `
-		SELECT ua.webhook FROM sms_history sh
-		JOIN user_apps ua ON sh.u_aid = ua.id
+		SELECT sh.account_id, COALESCE(NULLIF(sh.webhook, ''), ua.webhook, ''), COALESCE(a.dlr_mode, 'push'), sh.created_at
+		FROM sms_history sh
+		LEFT JOIN user_apps ua ON sh.u_aid = ua.id
+		LEFT JOIN accounts a ON a.id = sh.account_id
 		WHERE sh.rid = $1
-	`, messageID).Scan(&webhook)
+	`, messageID).Scan(&accountID, &webhook, &mode, &sentAt)
 
-	if webhook == "" {
+	// Pull accounts poll GET /status/{sid} instead
+	if webhook == "" || mode == DLRModePull {
 		return
 	}
 
-	// Send webhook
-	payload, _ := json.Marshal(status)
-	http.Post(webhook, "application/json", strings.NewReader(string(payload)))
+	if status.DeliveredAt != nil && !sentAt.IsZero() {
+		latency := max(status.DeliveredAt.Sub(sentAt).Milliseconds(), 0)
+		status.LatencyMS = &latency
+	}
+
+	// Queue webhook; the dispatcher retries failed deliveries
+	s.enqueueWebhook(ctx, accountID, webhook, EventSMSStatus, status)
 }
 
-func (s *Service) startDLRFlusher(interval time.Duration, batchSize int) {
+func (s *Service) startDLRFlusher(ctx context.Context, interval time.Duration, batchSize int) {
 	ticker := time.NewTicker(interval)
-	for range ticker.C {
-		s.dlrBuffer.flush(batchSize)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.dlrBuffer.flush(batchSize)
+			s.beat(workerDLRFlusher)
+		}
 	}
 }
 
+// queue buffers a report received now without carrier metadata
 func (b *DLRBuffer) queue(messageID, status string) {
+	b.queueUpdate(status, dlrUpdate{messageID: messageID, doneAt: time.Now()})
+}
+
+func (b *DLRBuffer) queueUpdate(status string, u dlrUpdate) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	switch status {
 	case "delivered":
-		b.delivered = append(b.delivered, messageID)
+		b.delivered = append(b.delivered, u)
 	case "failed":
-		b.failed = append(b.failed, messageID)
+		b.failed = append(b.failed, u)
 	}
 }
 
@@ -797,7 +1229,7 @@ func (b *DLRBuffer) flush(batchSize int) {
 			toFlush = b.delivered[:batchSize]
 			b.delivered = b.delivered[batchSize:]
 		} else {
-			b.delivered = make([]string, 0)
+			b.delivered = make([]dlrUpdate, 0)
 		}
 		b.updateStatusBatch(ctx, toFlush, "delivered")
 	}
@@ -809,28 +1241,65 @@ func (b *DLRBuffer) flush(batchSize int) {
 			toFlush = b.failed[:batchSize]
 			b.failed = b.failed[batchSize:]
 		} else {
-			b.failed = make([]string, 0)
+			b.failed = make([]dlrUpdate, 0)
 		}
 		b.updateStatusBatch(ctx, toFlush, "failed")
 	}
 }
 
-func (b *DLRBuffer) updateStatusBatch(ctx context.Context, messageIDs []string, status string) {
-	if len(messageIDs) == 0 {
-		return
+// drain writes every buffered update, batchSize at a time
+func (b *DLRBuffer) drain(ctx context.Context, batchSize int) error {
+	b.mu.Lock()
+	pending := map[string][]dlrUpdate{"delivered": b.delivered, "failed": b.failed}
+	b.delivered, b.failed = make([]dlrUpdate, 0), make([]dlrUpdate, 0)
+	b.mu.Unlock()
+
+	batchSize = max(batchSize, 1)
+	var errs []error
+	for status, updates := range pending {
+		for start := 0; start < len(updates); start += batchSize {
+			end := min(start+batchSize, len(updates))
+			if err := b.updateStatusBatch(ctx, updates[start:end], status); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *DLRBuffer) updateStatusBatch(ctx context.Context, updates []dlrUpdate, status string) error {
+	if len(updates) == 0 {
+		return nil
 	}
 
-	placeholders := make([]string, len(messageIDs))
-	args := make([]interface{}, len(messageIDs)+1)
+	values := make([]string, len(updates))
+	args := make([]interface{}, 1, len(updates)*3+1)
 	args[0] = status
-	for i, id := range messageIDs {
-		placeholders[i] = fmt.Sprintf("$%d", i+2)
-		args[i+1] = id
+	for i, u := range updates {
+		n := i*3 + 2
+		values[i] = fmt.Sprintf("($%d, $%d::timestamp, $%d::int)", n, n+1, n+2)
+		var attempts interface{}
+		if u.attempts > 0 {
+			attempts = u.attempts
+		}
+		args = append(args, u.messageID, u.doneAt.UTC(), attempts)
 	}
 
-	query := fmt.Sprintf("UPDATE sms_history SET status = $1 WHERE rid IN (%s)", strings.Join(placeholders, ","))
-	b.db.Exec(ctx, query, args...)
-	b.logger.Info("flushed DLR updates", zap.String("status", status), zap.Int("count", len(messageIDs)))
+	// Delivery time and latency feed the per-network latency in
+	// handleInsightsByNetwork
+	query := fmt.Sprintf(`UPDATE sms_history AS sh SET status = $1,
+		delivered_at = CASE WHEN $1 = 'delivered' THEN v.done_at ELSE sh.delivered_at END,
+		delivery_latency_ms = CASE WHEN $1 = 'delivered'
+			THEN GREATEST(0, EXTRACT(EPOCH FROM v.done_at - sh.created_at) * 1000)::bigint
+			ELSE sh.delivery_latency_ms END,
+		dlr_attempts = COALESCE(v.attempts, sh.dlr_attempts)
+		FROM (VALUES %s) AS v(rid, done_at, attempts)
+		WHERE sh.rid = v.rid`, strings.Join(values, ","))
+	if _, err := b.db.Exec(ctx, query, args...); err != nil {
+		return err
+	}
+	b.logger.Info("flushed DLR updates", zap.String("status", status), zap.Int("count", len(updates)))
+	return nil
 }
 
 func (s *Service) jsonResponse(w http.ResponseWriter, data interface{}, status int) {
@@ -839,8 +1308,6 @@ func (s *Service) jsonResponse(w http.ResponseWriter, data interface{}, status i
 	json.NewEncoder(w).Encode(data)
 }
 
-func (s *Service) jsonError(w http.ResponseWriter, msg string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": msg, "status": "error"})
+func (s *Service) jsonError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	apierr.Write(w, r, msg, status)
 }
@@ -3,34 +3,27 @@ package sms
 
 import (
 	"bytes"
+	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
-)
+	"time"
 
-// MockDB implements a mock database for testing
-type MockDB struct {
-	accounts   map[string]map[string]interface{}
-	smsHistory []map[string]interface{}
-	senderIDs  map[string]bool
-}
-
-func NewMockDB() *MockDB {
-	return &MockDB{
-		accounts: map[string]map[string]interface{}{
-			"BV123456789": {
-				"id":      "BV123456789",
-				"balance": 1000.0,
-				"email":   "test@example.com",
-			},
-		},
-		smsHistory: make([]map[string]interface{}, 0),
-		senderIDs: map[string]bool{
-			"BRIVAS": true,
-		},
-	}
-}
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+	"github.com/brivas/unified-platform/packages/lumadb-client/lumadbtest"
+	"github.com/brivas/unified-platform/packages/netguard"
+)
 
 func TestFormatNumber(t *testing.T) {
 	tests := []struct {
@@ -107,6 +100,343 @@ func TestGetRate(t *testing.T) {
 	}
 }
 
+func TestRateCards(t *testing.T) {
+	svc := &Service{
+		networkCodes: map[string]string{"0803": "MTN", "0805": "GLO"},
+		rates:        newRateTable(),
+	}
+	svc.rates.set([]RateCard{
+		{MessageType: "promotional", Rate: 2.2},
+		{Network: "GLO", MessageType: "promotional", Rate: 2.0},
+		{Plan: "reseller", MessageType: "promotional", Rate: 1.8},
+		{AccountID: "BV123456789", Network: "MTN", MessageType: "promotional", Rate: 1.5},
+	}, map[string]string{"BV987654321": "reseller"})
+
+	tests := []struct {
+		accountID string
+		msgType   string
+		to        string
+		expected  float64
+	}{
+		{"", "promotional", "08031234567", 2.2},
+		{"", "promotional", "08051234567", 2.0},
+		{"BV987654321", "promotional", "+2348051234567", 1.8},
+		{"BV123456789", "promotional", "08031234567", 1.5},
+		{"BV123456789", "promotional", "08051234567", 2.0},
+		{"BV123456789", "otp", "08031234567", 3.0},
+	}
+
+	for _, tc := range tests {
+		if got := svc.accountRate(tc.accountID, tc.msgType, tc.to); got != tc.expected {
+			t.Errorf("accountRate(%q, %s, %s) = %f, expected %f", tc.accountID, tc.msgType, tc.to, got, tc.expected)
+		}
+	}
+}
+
+func TestSenderPolicy(t *testing.T) {
+	kinds := map[string]string{
+		"BRIVAS": SenderAlphanumeric, "32811": SenderShortCode, "+14155550100": SenderLongCode,
+		"Acme & Co": SenderAlphanumeric, "123456789012345": SenderLongCode,
+		"": "", "TOOLONGSENDER": "", "1234567890123456": "", "+ACME": "", "ACME!": "",
+		" ACME": "", "12-34": "", "ÄCME": "",
+	}
+	for sender, expected := range kinds {
+		if got := senderKind(sender); got != expected {
+			t.Errorf("senderKind(%q) = %q, expected %q", sender, got, expected)
+		}
+	}
+	if _, err := checkSender("TOOLONGSENDER"); !errors.Is(err, errInvalidSender) || !strings.Contains(err.Error(), "at most 11 characters") {
+		t.Errorf("Expected the alphanumeric length limit, got %v", err)
+	}
+
+	table := newSenderPolicyTable()
+	table.set([]SenderPolicy{
+		{CountryCode: "1", AllowLongCode: true, AllowShortCode: true, RegistrationRequired: true, Note: "use a long code"},
+		{CountryCode: "234", AllowAlphanumeric: true, AllowLongCode: true, AllowShortCode: true, DefaultSender: "BRIVAS"},
+		{CountryCode: "234", Network: "MTN", MessageType: "promotional", AllowShortCode: true},
+	})
+	svc := &Service{senderPolicies: table}
+
+	tests := []struct {
+		name      string
+		recipient string
+		network   string
+		msgType   string
+		requested string
+		sender    string
+		register  bool
+		allowed   bool
+	}{
+		{"no policy", "447700900123", "", "otp", "ACME", "ACME", false, true},
+		{"alphanumeric blocked", "14155550100", "", "otp", "ACME", "", false, false},
+		{"no default for strict country", "14155550100", "", "otp", "", "", false, false},
+		{"registered long code", "14155550100", "", "otp", "+14155550199", "+14155550199", true, true},
+		{"policy default", "2348031234567", "MTN", "transactional", "", "BRIVAS", false, true},
+		{"network and type override", "2348031234567", "MTN", "promotional", "ACME", "", false, false},
+		{"override allows short code", "2348031234567", "MTN", "promotional", "32811", "32811", false, true},
+	}
+
+	for _, tc := range tests {
+		sender, register, err := svc.resolveSender(tc.recipient, tc.network, tc.msgType, tc.requested, "PLATFORM")
+		if (err == nil) != tc.allowed {
+			t.Errorf("%s: error = %v, expected allowed %v", tc.name, err, tc.allowed)
+			continue
+		}
+		if err != nil && !errors.Is(err, errSenderPolicy) {
+			t.Errorf("%s: expected errSenderPolicy, got %v", tc.name, err)
+		}
+		if sender != tc.sender || register != tc.register {
+			t.Errorf("%s: got %q (registration %v), expected %q (registration %v)", tc.name, sender, register, tc.sender, tc.register)
+		}
+	}
+}
+
+func TestSMSSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"empty", "", 1},
+		{"single GSM part", strings.Repeat("a", 160), 1},
+		{"two GSM parts", strings.Repeat("a", 161), 2},
+		{"extended characters count twice", strings.Repeat("€", 80), 1},
+		{"extended characters overflow", strings.Repeat("€", 81), 2},
+		{"three GSM parts", strings.Repeat("a", 307), 3},
+		{"single UCS-2 part", strings.Repeat("ñ", 69) + "ł", 1},
+		{"two UCS-2 parts", strings.Repeat("ł", 71), 2},
+	}
+
+	for _, tc := range tests {
+		if got := smsSegments(tc.body); got != tc.expected {
+			t.Errorf("%s: smsSegments() = %d, expected %d", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	enc := messageEncoding("Your code is 1234 €")
+	if enc.Encoding != EncodingGSM7 || enc.Segments != 1 || enc.Unicode != nil || enc.Warning != "" {
+		t.Errorf("Expected a single GSM-7 part, got %+v", enc)
+	}
+
+	enc = messageEncoding(strings.Repeat("Pay now “today” ", 5))
+	if enc.Encoding != EncodingUCS2 || enc.Segments != 2 {
+		t.Errorf("Expected two UCS-2 parts, got %+v", enc)
+	}
+	if len(enc.Unicode) != 2 || enc.Unicode[0] != "“" || enc.Unicode[1] != "”" {
+		t.Errorf("Expected the curly quotes reported once each, got %q", enc.Unicode)
+	}
+	if !strings.Contains(enc.Warning, "UCS-2") {
+		t.Errorf("Expected a UCS-2 warning, got %q", enc.Warning)
+	}
+}
+
+func TestShortenLinks(t *testing.T) {
+	svc := &Service{shortLinkBase: "https://go.example/"}
+
+	body, links := svc.shortenLinks("Shop now at https://shop.example/sale?id=42. Or https://shop.example!", "BV123456789", "CMP-1", "2348031234567")
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %d", len(links))
+	}
+	if links[0].Target != "https://shop.example/sale?id=42" || links[1].Target != "https://shop.example" {
+		t.Errorf("unexpected targets %q, %q", links[0].Target, links[1].Target)
+	}
+	expected := "Shop now at https://go.example/" + links[0].Code + ". Or https://go.example/" + links[1].Code + "!"
+	if body != expected {
+		t.Errorf("expected body %q, got %q", expected, body)
+	}
+	if links[0].Code == links[1].Code || len(links[0].Code) != shortCodeLength {
+		t.Errorf("expected distinct %d-character codes, got %q and %q", shortCodeLength, links[0].Code, links[1].Code)
+	}
+	if links[0].CampaignID != "CMP-1" || links[0].Recipient != "2348031234567" {
+		t.Errorf("link not attributed to campaign and recipient: %+v", links[0])
+	}
+
+	if body, links := svc.shortenLinks("No links here", "BV123456789", "CMP-1", "2348031234567"); body != "No links here" || len(links) != 0 {
+		t.Errorf("expected body without links unchanged, got %q with %d links", body, len(links))
+	}
+}
+
+func TestFillSeries(t *testing.T) {
+	from := time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	series := fillSeries(from, to, BucketDay, map[int64]TimeSeriesPoint{
+		day2.Unix(): {Sent: 4, Delivered: 3, Failed: 1},
+	})
+	if len(series) != 3 {
+		t.Fatalf("expected 3 daily points, got %d", len(series))
+	}
+	if !series[0].Time.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || series[0].Sent != 0 {
+		t.Errorf("expected a zero-filled first day, got %+v", series[0])
+	}
+	if !series[1].Time.Equal(day2) || series[1].Sent != 4 || series[1].DeliveryRate != 75 {
+		t.Errorf("unexpected second day %+v", series[1])
+	}
+
+	if hours := fillSeries(from, from.Add(3*time.Hour), BucketHour, nil); len(hours) != 4 {
+		t.Errorf("expected 4 hourly points, got %d", len(hours))
+	}
+}
+
+func TestTimeSeriesRange(t *testing.T) {
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{"", true},
+		{"?bucket=hour", true},
+		{"?from=2024-03-01&to=2024-03-08", true},
+		{"?from=2024-03-01T10:00:00Z&to=2024-03-02T10:00:00Z&bucket=hour", true},
+		{"?bucket=week", false},
+		{"?from=yesterday", false},
+		{"?from=2024-03-08&to=2024-03-01", false},
+		{"?from=2022-01-01&to=2024-01-01", false},
+		{"?from=2024-01-01&to=2024-03-01&bucket=hour", false},
+	}
+
+	for _, tc := range tests {
+		_, _, _, err := timeSeriesRange(httptest.NewRequest("GET", "/bulk/insights/timeseries"+tc.query, nil))
+		if (err == nil) != tc.valid {
+			t.Errorf("timeSeriesRange(%q) error = %v, expected valid %v", tc.query, err, tc.valid)
+		}
+	}
+}
+
+func TestInsightsRange(t *testing.T) {
+	from, to, err := insightsRange(httptest.NewRequest("GET", "/bulk/insights/by-network?to=2024-03-08", nil), 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !from.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the 7 days before 2024-03-08, got %v to %v", from, to)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?to=soon", "?from=2024-03-08&to=2024-03-08"} {
+		if _, _, err := insightsRange(httptest.NewRequest("GET", "/bulk/insights/by-network"+query, nil), time.Hour); err == nil {
+			t.Errorf("insightsRange(%q) expected an error", query)
+		}
+	}
+}
+
+func TestParseDLRTimes(t *testing.T) {
+	receivedAt := time.Date(2024, 3, 1, 12, 5, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want time.Time
+	}{
+		{"done date", map[string]interface{}{"done_date": "2024-03-01 12:01:30"}, time.Date(2024, 3, 1, 12, 1, 30, 0, time.UTC)},
+		{"smpp receipt", map[string]interface{}{"short_message": "id:1 submit date:2403011200 done date:2403011202 stat:DELIVRD"}, time.Date(2024, 3, 1, 12, 2, 0, 0, time.UTC)},
+		{"missing", map[string]interface{}{}, receivedAt},
+		{"unparseable", map[string]interface{}{"done_date": "soon"}, receivedAt},
+		{"in the future", map[string]interface{}{"done_date": "2024-03-01 13:00:00"}, receivedAt},
+		{"before submit", map[string]interface{}{"submit_date": "2403011204", "done_date": "2403011200"}, receivedAt},
+	}
+	for _, tt := range tests {
+		if got := parseDLRTimes(tt.body, time.UTC, receivedAt); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	lagos := time.FixedZone("WAT", 3600)
+	got := parseDLRTimes(map[string]interface{}{"done_date": "2403011301"}, lagos, receivedAt)
+	if !got.Equal(time.Date(2024, 3, 1, 12, 1, 0, 0, time.UTC)) {
+		t.Errorf("expected the done date read in WAT, got %v", got)
+	}
+
+	if n := parseDLRAttempts(map[string]interface{}{"attempts": "3"}); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestPrepareRecipients(t *testing.T) {
+	svc := &Service{networkCodes: map[string]string{"0803": "MTN"}}
+	to := []string{"08031234567", "+2348031234567", "12", "2348051234567", " 08031234567 "}
+
+	recipients, counts := svc.prepareRecipients(to, true)
+	expected := RecipientCounts{Accepted: 2, Duplicates: 2, Invalid: 1}
+	if counts != expected {
+		t.Errorf("expected %+v, got %+v", expected, counts)
+	}
+	if len(recipients) != 2 || recipients[0] != "2348031234567" || recipients[1] != "2348051234567" {
+		t.Errorf("unexpected recipients %v", recipients)
+	}
+
+	if recipients, counts := svc.prepareRecipients(to, false); len(recipients) != 4 || counts.Duplicates != 0 {
+		t.Errorf("expected duplicates kept without dedupe, got %v (%+v)", recipients, counts)
+	}
+}
+
+type stubProvider struct {
+	name  string
+	sends atomic.Int64
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) Send(ctx context.Context, msg *Message) (*SendResult, error) {
+	p.sends.Add(1)
+	return &SendResult{MessageID: p.name + "-1", Provider: p.name, Status: "pending"}, nil
+}
+
+func (p *stubProvider) BulkSend(ctx context.Context, msgs []*Message) ([]*SendResult, error) {
+	results := make([]*SendResult, len(msgs))
+	for i := range msgs {
+		results[i], _ = p.Send(ctx, msgs[i])
+	}
+	return results, nil
+}
+
+func (p *stubProvider) GetDeliveryStatus(ctx context.Context, messageID string) (*DeliveryStatus, error) {
+	return &DeliveryStatus{MessageID: messageID, Status: "delivered"}, nil
+}
+
+func TestProviderRegistration(t *testing.T) {
+	svc := &Service{}
+	mtn := &stubProvider{name: "mtn"}
+	svc.RegisterProvider(mtn)
+
+	result, err := svc.sendViaProvider(context.Background(), &Message{To: "2348031234567"})
+	if err != nil || result.Provider != "mtn" {
+		t.Fatalf("expected a send through mtn, got %+v, %v", result, err)
+	}
+	if !svc.UnregisterProvider("mtn") || svc.UnregisterProvider("mtn") {
+		t.Error("expected mtn to be unregistered exactly once")
+	}
+	if result, _ := svc.sendViaProvider(context.Background(), &Message{}); result.Provider != "" {
+		t.Errorf("expected the fallback without providers, got %q", result.Provider)
+	}
+
+	// Run with -race: registration and sends must not race
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			p := &stubProvider{name: fmt.Sprintf("route-%d", i%3)}
+			for j := 0; j < 50; j++ {
+				svc.RegisterProvider(p)
+				svc.UnregisterProvider(p.name)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := svc.sendViaProvider(context.Background(), &Message{}); err != nil {
+					t.Errorf("sendViaProvider() error = %v", err)
+				}
+				if _, err := svc.bulkSendViaProvider(context.Background(), []*Message{{}, {}}, "BRIVAS", "hi", "promotional"); err != nil {
+					t.Errorf("bulkSendViaProvider() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestGenerateSID(t *testing.T) {
 	svc := &Service{}
 
@@ -124,8 +454,8 @@ func TestGenerateSID(t *testing.T) {
 
 func TestDLRBuffer(t *testing.T) {
 	buffer := &DLRBuffer{
-		delivered: make([]string, 0),
-		failed:    make([]string, 0),
+		delivered: make([]dlrUpdate, 0),
+		failed:    make([]dlrUpdate, 0),
 	}
 
 	// Test queuing
@@ -141,6 +471,71 @@ func TestDLRBuffer(t *testing.T) {
 	}
 }
 
+func TestServiceClose(t *testing.T) {
+	svc := &Service{dlrBuffer: &DLRBuffer{}}
+	ctx, stop := context.WithCancel(context.Background())
+	svc.stop = stop
+
+	ticks := 0
+	svc.runWorker(ctx, func(ctx context.Context) {
+		svc.startDLRFlusher(ctx, time.Millisecond, 10)
+		ticks++
+	})
+	if err := svc.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if ticks != 1 {
+		t.Error("expected the flusher to have stopped")
+	}
+	if err := svc.Close(context.Background()); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+
+	// A worker that ignores cancellation is abandoned at the deadline
+	stuck := &Service{dlrBuffer: &DLRBuffer{}, stop: func() {}}
+	release := make(chan struct{})
+	defer close(release)
+	stuck.runWorker(context.Background(), func(context.Context) { <-release })
+	deadline, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := stuck.Close(deadline); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Close() to time out, got %v", err)
+	}
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	flusher := newHeartbeat(time.Second)
+	svc := &Service{
+		dispatch: newDispatcher(1, DefaultPriorityWeight),
+		heartbeats: map[string]*heartbeat{
+			workerDLRFlusher:       flusher,
+			workerRateRefresher:    newHeartbeat(time.Minute),
+			workerContentRefresher: newHeartbeat(time.Minute),
+		},
+	}
+	if err := svc.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("expected healthy, got %v", err)
+	}
+
+	// A flusher that has missed its intervals and the grace is reported
+	flusher.last.Store(time.Now().Add(-3*time.Second - heartbeatGrace - time.Second).UnixNano())
+	err := svc.HealthCheck(context.Background())
+	if err == nil || !strings.Contains(err.Error(), workerDLRFlusher) || strings.Contains(err.Error(), workerRateRefresher) {
+		t.Errorf("expected only the flusher reported, got %v", err)
+	}
+	svc.beat(workerDLRFlusher)
+	if err := svc.HealthCheck(context.Background()); err != nil {
+		t.Errorf("expected healthy after a beat, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc.dispatch.Run(ctx)
+	if err := svc.HealthCheck(context.Background()); !errors.Is(err, errDispatchStopped) {
+		t.Errorf("expected the stopped dispatcher reported, got %v", err)
+	}
+}
+
 func TestHandleSendValidation(t *testing.T) {
 	svc := &Service{
 		networkCodes: map[string]string{},
@@ -158,6 +553,276 @@ func TestHandleSendValidation(t *testing.T) {
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("Expected status 400, got %d", rr.Code)
 	}
+
+	// Webhooks must be public https endpoints
+	for _, webhook := range []string{"http://hooks.example.com/dlr", "https://127.0.0.1/dlr"} {
+		body = bytes.NewBufferString(`{"to": "08031234567", "message": "Your code is 1234", "webhook": "` + webhook + `"}`)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/send", body))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid webhook") {
+			t.Errorf("Expected webhook %s rejected with 400, got %d: %s", webhook, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Senders no carrier accepts are rejected with the reason
+	body = bytes.NewBufferString(`{"to": "08031234567", "from": "BRIVASALERTS", "message": "Your code is 1234"}`)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/send", body))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "at most 11 characters") {
+		t.Errorf("Expected an over-long sender rejected with 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleSend(t *testing.T) {
+	var charged, logged []driver.NamedValue
+	balance := 100.0
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.Contains(query, "FROM accounts a"):
+			return &lumadbtest.Rows{Columns: []string{"balance"}, Data: [][]driver.Value{{balance}}}, nil
+		case strings.Contains(query, "UPDATE accounts SET balance"):
+			charged = args
+			return &lumadbtest.Rows{Columns: []string{"balance"}, Data: [][]driver.Value{{balance - 3}}}, nil
+		case strings.Contains(query, "INSERT INTO billing_transactions"):
+			return &lumadbtest.Rows{Columns: []string{"created_at"}, Data: [][]driver.Value{{time.Now()}}}, nil
+		case strings.Contains(query, "INSERT INTO sms_history"):
+			logged = args
+		}
+		return nil, nil
+	})
+	svc := &Service{
+		db:           db,
+		logger:       zap.NewNop(),
+		networkCodes: map[string]string{"0803": "MTN"},
+		dispatch:     newDispatcher(1, DefaultPriorityWeight),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.dispatch.Run(ctx)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"to": "08031234567", "message": "Your code is 1234", "webhook": "https://203.0.113.10/dlr"}`))
+		req.Header.Set("X-Account-ID", "BV123456789")
+		req.Header.Set("X-Is-Live", "true")
+		rr := httptest.NewRecorder()
+		svc.handleSend(rr, req)
+		return rr
+	}
+
+	rr := send()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			SenderType string          `json:"sender_type"`
+			Encoding   MessageEncoding `json:"encoding"`
+			Cost       float64         `json:"cost"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	rate := svc.getRate("otp", "08031234567")
+	if resp.Data.SenderType != SenderAlphanumeric || resp.Data.Encoding.Encoding != EncodingGSM7 || resp.Data.Cost != rate {
+		t.Errorf("unexpected response data %+v", resp.Data)
+	}
+
+	// The message is logged with its webhook, then charged in one ledger
+	// transaction
+	if len(logged) == 0 || logged[len(logged)-1].Value != "https://203.0.113.10/dlr" {
+		t.Errorf("expected the message logged to sms_history with its webhook, got %v", logged)
+	}
+	if fake.Commits() != 1 || len(charged) != 2 || charged[0].Value != -rate {
+		t.Errorf("expected one charge of %v, got %d commits with %v", -rate, fake.Commits(), charged)
+	}
+
+	// An account that cannot pay is turned away before anything is sent
+	balance = 1
+	sent := len(fake.Queries())
+	if rr := send(); rr.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected status 402, got %d", rr.Code)
+	}
+	if queries := fake.Queries()[sent:]; len(queries) != 1 {
+		t.Errorf("expected only the balance read, got %q", queries)
+	}
+}
+
+// flakyProvider fails the chunk starting with failChunk and takes all but
+// the last message of every other chunk
+type flakyProvider struct {
+	stubProvider
+	failChunk string
+}
+
+func (p *flakyProvider) BulkSend(ctx context.Context, msgs []*Message) ([]*SendResult, error) {
+	if msgs[0].To == p.failChunk {
+		return nil, errors.New("provider unavailable")
+	}
+	results, _ := p.stubProvider.BulkSend(ctx, msgs[:len(msgs)-1])
+	return results, errors.New("last message rejected")
+}
+
+func TestSendBulkPartialFailure(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		logged  int
+		charged []driver.NamedValue
+	)
+	db, _ := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(query, "FROM accounts a"):
+			return &lumadbtest.Rows{Columns: []string{"balance"}, Data: [][]driver.Value{{1e6}}}, nil
+		case strings.Contains(query, "UPDATE accounts SET balance"):
+			charged = args
+			return &lumadbtest.Rows{Columns: []string{"balance"}, Data: [][]driver.Value{{1e6}}}, nil
+		case strings.Contains(query, "INSERT INTO billing_transactions"):
+			return &lumadbtest.Rows{Columns: []string{"created_at"}, Data: [][]driver.Value{{time.Now()}}}, nil
+		case strings.Contains(query, "INSERT INTO sms_history"):
+			logged++
+		}
+		return nil, nil
+	})
+	svc := &Service{
+		db:           db,
+		logger:       zap.NewNop(),
+		networkCodes: map[string]string{"0803": "MTN"},
+		dispatch:     newDispatcher(1, DefaultPriorityWeight),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.dispatch.Run(ctx)
+
+	// Three chunks: the first fails outright, the others lose a message each
+	to := make([]string, 2*dispatchChunkSize+50)
+	for i := range to {
+		to[i] = fmt.Sprintf("0803%07d", i)
+	}
+	svc.RegisterProvider(&flakyProvider{stubProvider: stubProvider{name: "mtn"}, failChunk: "234" + to[0][1:]})
+	quota := &stubQuota{limit: len(to)}
+	svc.SetQuota(quota)
+
+	msgs, counts, err := svc.sendBulk(ctx, "BV123456789", true, "sid-1", &BulkSendRequest{To: to, Message: "Sale ends today", Type: "promotional"})
+	if err != nil {
+		t.Fatalf("sendBulk() error = %v", err)
+	}
+	sent := len(to) - dispatchChunkSize - 2
+	if len(msgs) != sent || counts.Accepted != len(to) || counts.Failed != len(to)-sent {
+		t.Fatalf("expected %d sent and %d failed, got %d sent and counts %+v", sent, len(to)-sent, len(msgs), counts)
+	}
+	for _, msg := range msgs {
+		if msg.RID == "" {
+			t.Fatalf("expected every returned message to carry its provider id, got %+v", msg)
+		}
+	}
+	if logged != sent {
+		t.Errorf("expected the %d sent messages logged, got %d", sent, logged)
+	}
+	if len(charged) != 2 || charged[0].Value != -messagesCost(msgs) {
+		t.Errorf("expected a charge of %v for the sent messages, got %v", -messagesCost(msgs), charged)
+	}
+	if quota.used != sent {
+		t.Errorf("expected only the %d sent messages counted against the quota, got %d", sent, quota.used)
+	}
+
+	// A send over the quota is refused before anything goes out
+	logged = 0
+	_, _, err = svc.sendBulk(ctx, "BV123456789", true, "sid-2", &BulkSendRequest{To: to[:sent+1], Message: "Sale ends today", Type: "promotional"})
+	if !errors.Is(err, errQuotaExceeded) || logged != 0 || quota.used != sent {
+		t.Errorf("expected the quota to refuse the send, got %v with %d logged", err, logged)
+	}
+}
+
+// stubQuota is a QuotaEnforcer allowing limit messages
+type stubQuota struct {
+	mu          sync.Mutex
+	limit, used int
+}
+
+type stubQuotaError struct{}
+
+func (stubQuotaError) Error() string   { return "monthly sms quota exceeded" }
+func (stubQuotaError) HTTPStatus() int { return http.StatusPaymentRequired }
+
+func (q *stubQuota) CheckQuota(ctx context.Context, accountID string, n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used+n > q.limit {
+		return stubQuotaError{}
+	}
+	return nil
+}
+
+func (q *stubQuota) ConsumeQuota(ctx context.Context, accountID string, n int) error {
+	if err := q.CheckQuota(ctx, accountID, n); err != nil {
+		return err
+	}
+	return q.RecordUsage(ctx, accountID, n)
+}
+
+func (q *stubQuota) RecordUsage(ctx context.Context, accountID string, n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used += n
+	return nil
+}
+
+func TestValidateSchedule(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		at    time.Time
+		valid bool
+	}{
+		{"in an hour", now.Add(time.Hour), true},
+		{"at the horizon", now.Add(maxScheduleHorizon), true},
+		{"now", now, false},
+		{"in the past", now.Add(-time.Minute), false},
+		{"beyond the horizon", now.Add(maxScheduleHorizon + time.Second), false},
+	}
+
+	for _, tc := range tests {
+		if err := validateSchedule(tc.at, now); (err == nil) != tc.valid {
+			t.Errorf("%s: validateSchedule() error = %v, expected valid %v", tc.name, err, tc.valid)
+		}
+	}
+
+	svc := &Service{networkCodes: map[string]string{}}
+	body := bytes.NewBufferString(`{"to": "08031234567", "message": "Your code is 1234", "scheduled_at": "2020-01-01T00:00:00Z"}`)
+	rr := httptest.NewRecorder()
+	svc.handleSend(rr, httptest.NewRequest("POST", "/send", body))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a past scheduled_at to be rejected with 400, got %d", rr.Code)
+	}
+}
+
+func TestBatchState(t *testing.T) {
+	tests := []struct {
+		pending, delivered int
+		expected           string
+	}{
+		{3, 0, "pending"},
+		{1, 5, "pending"},
+		{0, 2, "delivered"},
+		{0, 0, "failed"},
+	}
+
+	for _, tc := range tests {
+		if got := batchState(tc.pending, tc.delivered); got != tc.expected {
+			t.Errorf("batchState(%d, %d) = %s, expected %s", tc.pending, tc.delivered, got, tc.expected)
+		}
+	}
+
+	svc := &Service{}
+	for _, body := range []string{`{}`, `{"dlr_mode": "email"}`, `not json`} {
+		rr := httptest.NewRecorder()
+		svc.handleSetDLRMode(rr, httptest.NewRequest("PUT", "/settings/dlr", bytes.NewBufferString(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handleSetDLRMode(%s) = %d, expected 400", body, rr.Code)
+		}
+	}
 }
 
 func TestHandleBulkSendValidation(t *testing.T) {
@@ -179,6 +844,514 @@ func TestHandleBulkSendValidation(t *testing.T) {
 	}
 }
 
+func TestSenderIDValidation(t *testing.T) {
+	svc := &Service{}
+	router := svc.Routes()
+
+	tests := []struct {
+		name   string
+		path   string
+		role   string
+		body   string
+		status int
+	}{
+		{"sender too long", "/sender-ids", "", `{"sender": "BRIVASALERTS", "type": "promotional"}`, http.StatusBadRequest},
+		{"sender with symbols", "/sender-ids", "", `{"sender": "BRIV@S", "type": "promotional"}`, http.StatusBadRequest},
+		{"unknown type", "/sender-ids", "", `{"sender": "BRIVAS", "type": "otp"}`, http.StatusBadRequest},
+		{"approve needs admin", "/sender-ids/1/approve", "user", `{}`, http.StatusForbidden},
+		{"reject needs admin", "/sender-ids/1/reject", "user", `{"reason": "unregistered brand"}`, http.StatusForbidden},
+		{"reject needs reason", "/sender-ids/1/reject", "admin", `{}`, http.StatusBadRequest},
+		{"invalid id", "/sender-ids/abc/approve", "admin", `{}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("POST", tc.path, bytes.NewBufferString(tc.body))
+		req.Header.Set("X-Account-ID", "BV123456789")
+		req.Header.Set("X-Role", tc.role)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, rr.Code)
+		}
+	}
+}
+
+func TestTopUpValidation(t *testing.T) {
+	svc := &Service{}
+	router := svc.Routes()
+
+	tests := []struct {
+		name   string
+		role   string
+		body   string
+		status int
+	}{
+		{"needs admin or service", "user", `{"account_id": "BV123456789", "amount": 500}`, http.StatusForbidden},
+		{"missing account", "admin", `{"amount": 500}`, http.StatusBadRequest},
+		{"zero amount", "admin", `{"account_id": "BV123456789", "amount": 0}`, http.StatusBadRequest},
+		{"negative amount", "service", `{"account_id": "BV123456789", "amount": -50}`, http.StatusBadRequest},
+		{"amount too large", "admin", `{"account_id": "BV123456789", "amount": 20000000}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("POST", "/billing/topup", bytes.NewBufferString(tc.body))
+		req.Header.Set("X-Role", tc.role)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, rr.Code)
+		}
+	}
+}
+
+func TestCampaignValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		input campaignInput
+		valid bool
+	}{
+		{"message", campaignInput{Name: "Promo", Message: "Hi", Recipients: []string{"08031234567"}}, true},
+		{"template", campaignInput{Name: "Promo", TemplateID: "tpl_1", Type: "transactional", Recipients: []string{"08031234567"}}, true},
+		{"missing name", campaignInput{Message: "Hi", Recipients: []string{"08031234567"}}, false},
+		{"missing content", campaignInput{Name: "Promo", Recipients: []string{"08031234567"}}, false},
+		{"missing recipients", campaignInput{Name: "Promo", Message: "Hi"}, false},
+		{"unknown type", campaignInput{Name: "Promo", Message: "Hi", Type: "otp", Recipients: []string{"08031234567"}}, false},
+		{"too many recipients", campaignInput{Name: "Promo", Message: "Hi", Recipients: make([]string, maxCampaignRecipients+1)}, false},
+	}
+
+	for _, tc := range tests {
+		err := tc.input.validate()
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: validate() = %v, expected valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestSplitRecipients(t *testing.T) {
+	recipients := make([]string, 2*campaignBatchSize+1)
+	chunks := splitRecipients(recipients, campaignBatchSize)
+	if len(chunks) != 3 || len(chunks[0]) != campaignBatchSize || len(chunks[2]) != 1 {
+		t.Errorf("expected chunks of 1000, 1000 and 1, got %d chunks", len(chunks))
+	}
+	if chunks := splitRecipients(recipients[:campaignBatchSize], campaignBatchSize); len(chunks) != 1 {
+		t.Errorf("expected one full chunk, got %d", len(chunks))
+	}
+	if chunks := splitRecipients(nil, campaignBatchSize); len(chunks) != 0 {
+		t.Errorf("expected no chunks, got %d", len(chunks))
+	}
+}
+
+func TestCampaignRunners(t *testing.T) {
+	svc := &Service{}
+	if !svc.startCampaignRunner("CMP-1") {
+		t.Fatal("expected the first runner to start")
+	}
+	if svc.startCampaignRunner("CMP-1") {
+		t.Error("expected a second runner for the same campaign to be refused")
+	}
+	if !svc.startCampaignRunner("CMP-2") {
+		t.Error("expected another campaign's runner to start")
+	}
+	svc.stopCampaignRunner("CMP-1")
+	if !svc.startCampaignRunner("CMP-1") {
+		t.Error("expected a runner to start once the previous one stopped")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{8, time.Hour},
+		{40, time.Hour},
+	}
+
+	for _, tc := range tests {
+		got := webhookBackoff(tc.attempt)
+		if got < tc.base || got > tc.base+tc.base/10 {
+			t.Errorf("webhookBackoff(%d) = %v, expected %v plus up to 10%%", tc.attempt, got, tc.base)
+		}
+	}
+}
+
+func TestWebhookDispatcherPerAccountCap(t *testing.T) {
+	d := NewWebhookDispatcher(nil, nil, 0, 2, nil)
+
+	if !d.acquire("BV1") || !d.acquire("BV1") {
+		t.Fatal("expected two slots for BV1")
+	}
+	if d.acquire("BV1") {
+		t.Error("expected BV1 to be at its cap")
+	}
+	if !d.acquire("BV2") {
+		t.Error("expected BV2 to be unaffected by BV1")
+	}
+	d.release("BV1")
+	if !d.acquire("BV1") {
+		t.Error("expected a released slot to be reusable")
+	}
+}
+
+func TestWebhookDispatchDue(t *testing.T) {
+	release := make(chan struct{})
+	var posted atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted.Add(1)
+		<-release
+	}))
+	defer server.Close()
+
+	var (
+		mu     sync.Mutex
+		args   []driver.NamedValue
+		served bool
+	)
+	db, fake := lumadbtest.New(func(query string, a []driver.NamedValue) (*lumadbtest.Rows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(query, "PARTITION BY wd.account_id"):
+			args = a
+			if served {
+				return nil, nil
+			}
+			served = true
+			return &lumadbtest.Rows{
+				Columns: []string{"id", "account_id", "url", "event", "payload", "attempts", "secret"},
+				Types:   []string{"INT8", "VARCHAR", "VARCHAR", "VARCHAR", "BYTEA", "INT4", "VARCHAR"},
+				Data:    [][]driver.Value{{int64(1), "BV2", server.URL, EventSMSStatus, []byte(`{}`), int64(0), ""}},
+			}, nil
+		case strings.Contains(query, "SET status = 'delivering'"):
+			return &lumadbtest.Rows{Columns: []string{"n"}, Data: [][]driver.Value{{1}}}, nil
+		}
+		return nil, nil
+	})
+	d := NewWebhookDispatcher(db, zap.NewNop(), 0, 2, []string{"127.0.0.1"})
+	d.acquire("BV1")
+	d.acquire("BV1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	d.wake <- struct{}{}
+	for deadline := time.Now().Add(2 * time.Second); posted.Load() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the due delivery to be posted")
+		}
+	}
+
+	// Capped accounts are left out and each account is limited to its cap
+	mu.Lock()
+	if len(args) != 3 || args[1].Value != `{"BV1"}` || args[2].Value != int64(2) {
+		t.Errorf("expected BV1 excluded and a cap of 2 per account, got %v", args)
+	}
+	mu.Unlock()
+
+	// Run outlives the attempt in flight, which is still recorded
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("expected Run to wait for the delivery in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Run to return once the delivery finished")
+	}
+	if last := fake.LastQuery(); !strings.Contains(last, "SET status = 'delivered'") {
+		t.Errorf("expected the delivery recorded, got %s", last)
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	var event string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event = r.Header.Get("X-Brivas-Event")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	delivery := &webhookDelivery{id: 1, url: server.URL, event: EventSMSStatus, payload: []byte(`{}`)}
+
+	// The test server is on loopback, which is only reachable when allowed
+	blocked := NewWebhookDispatcher(nil, nil, 0, 0, nil)
+	if _, err := blocked.post(context.Background(), delivery); !errors.Is(err, netguard.ErrBlocked) {
+		t.Fatalf("post() = %v; expected loopback to be blocked", err)
+	}
+
+	d := NewWebhookDispatcher(nil, nil, 0, 0, []string{"127.0.0.1"})
+
+	if code, err := d.post(context.Background(), delivery); err != nil || code != http.StatusOK {
+		t.Fatalf("post() = %d, %v; expected 200", code, err)
+	}
+	if event != EventSMSStatus {
+		t.Errorf("expected event header %q, got %q", EventSMSStatus, event)
+	}
+
+	status = http.StatusServiceUnavailable
+	if code, err := d.post(context.Background(), delivery); err == nil || code != http.StatusServiceUnavailable {
+		t.Errorf("post() = %d, %v; expected a 503 error", code, err)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	var timestamp, signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, signature = r.Header.Get("X-Brivas-Timestamp"), r.Header.Get("X-Brivas-Signature")
+	}))
+	defer server.Close()
+
+	d := NewWebhookDispatcher(nil, nil, 0, 0, []string{"127.0.0.1"})
+	payload := []byte(`{"event":"sms.status"}`)
+	if _, err := d.post(context.Background(), &webhookDelivery{id: 1, url: server.URL, payload: payload}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if signature != "" {
+		t.Errorf("expected no signature without a secret, got %q", signature)
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil || !strings.HasPrefix(secret, webhookSecretPrefix) {
+		t.Fatalf("generateWebhookSecret() = %q, %v", secret, err)
+	}
+	if _, err := d.post(context.Background(), &webhookDelivery{id: 2, url: server.URL, payload: payload, secret: secret}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header %q", timestamp)
+	}
+	if expected := "sha256=" + signWebhook(secret, time.Unix(unix, 0), payload); signature != expected {
+		t.Errorf("expected signature %q, got %q", expected, signature)
+	}
+	if signWebhook("whsec_other", time.Unix(unix, 0), payload) == signWebhook(secret, time.Unix(unix, 0), payload) {
+		t.Error("expected signatures under different secrets to differ")
+	}
+}
+
+func TestCheckMessageWebhook(t *testing.T) {
+	svc := &Service{webhooks: NewWebhookDispatcher(nil, nil, 0, 0, []string{"10.1.2.3"})}
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://203.0.113.10/dlr", true},
+		{"https://10.1.2.3/dlr", true},
+		{"http://203.0.113.10/dlr", false},
+		{"https://127.0.0.1/dlr", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://203.0.113.10/" + strings.Repeat("a", maxWebhookURLLength), false},
+	}
+	for _, tc := range tests {
+		err := svc.checkMessageWebhook(context.Background(), tc.url)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.url, err)
+		}
+		if !tc.valid && !errors.Is(err, errInvalidWebhook) {
+			t.Errorf("%s: expected errInvalidWebhook, got %v", tc.url, err)
+		}
+	}
+}
+
+func TestSendWebhookPerMessage(t *testing.T) {
+	var queued []driver.NamedValue
+	webhook := "https://203.0.113.10/dlr"
+	db, fake := lumadbtest.New(func(query string, args []driver.NamedValue) (*lumadbtest.Rows, error) {
+		switch {
+		case strings.Contains(query, "FROM sms_history sh"):
+			return &lumadbtest.Rows{
+				Columns: []string{"account_id", "webhook", "dlr_mode", "created_at"},
+				Data:    [][]driver.Value{{"BV123456789", webhook, DLRModePush, time.Now().Add(-time.Second)}},
+			}, nil
+		case strings.Contains(query, "INSERT INTO webhook_deliveries"):
+			queued = args
+		}
+		return nil, nil
+	})
+	svc := &Service{db: db, logger: zap.NewNop(), webhooks: NewWebhookDispatcher(db, zap.NewNop(), 0, 0, nil)}
+
+	delivered := time.Now()
+	svc.sendWebhook(context.Background(), "msg-1", DeliveryStatus{MessageID: "msg-1", Status: "delivered", DeliveredAt: &delivered})
+	if len(queued) != 4 || queued[0].Value != "BV123456789" || queued[1].Value != webhook {
+		t.Fatalf("expected the report queued for %s, got %v", webhook, queued)
+	}
+	// The message's own webhook wins over its app's, which it may not have
+	if q := fake.Queries()[0]; !strings.Contains(q, "COALESCE(NULLIF(sh.webhook, ''), ua.webhook, '')") || !strings.Contains(q, "LEFT JOIN user_apps") {
+		t.Errorf("unexpected webhook lookup %s", q)
+	}
+
+	// Internal addresses are never queued
+	webhook, queued = "http://169.254.169.254/latest/meta-data", nil
+	svc.sendWebhook(context.Background(), "msg-1", DeliveryStatus{MessageID: "msg-1", Status: "delivered"})
+	if queued != nil {
+		t.Errorf("expected an internal webhook to be refused, got %v", queued)
+	}
+}
+
+func TestDispatcherLanes(t *testing.T) {
+	d := newDispatcher(1, 2)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queue := func(lane string, n int) {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.dispatch(context.Background(), lane, 10, func(ctx context.Context) error {
+					mu.Lock()
+					order = append(order, lane)
+					mu.Unlock()
+					return nil
+				})
+			}()
+		}
+	}
+	queue(LanePromotional, 3)
+	queue(LanePriority, 5)
+	for d.queueDepths()[LanePriority] != 50 || d.queueDepths()[LanePromotional] != 30 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go d.Run(ctx)
+	wg.Wait()
+
+	// Two priority jobs, then a promotional one while both lanes wait
+	expected := []string{LanePriority, LanePriority, LanePromotional, LanePriority, LanePriority,
+		LanePromotional, LanePriority, LanePromotional}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+	if depths := d.queueDepths(); depths[LanePriority] != 0 || depths[LanePromotional] != 0 {
+		t.Errorf("expected empty lanes, got %v", depths)
+	}
+
+	cancel()
+	<-d.stopped
+	if err := d.dispatch(context.Background(), LanePriority, 1, func(ctx context.Context) error { return nil }); !errors.Is(err, errDispatchStopped) {
+		t.Errorf("expected errDispatchStopped after stop, got %v", err)
+	}
+	if laneFor("sms-otp", "") != LanePriority || laneFor("bulk-sms", "promotional") != LanePromotional {
+		t.Error("expected OTPs in the priority lane and promotional sends in the promotional lane")
+	}
+}
+
+type stubEmbedder map[string][]float64
+
+func (e stubEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if v, ok := e[text]; ok {
+		return v, nil
+	}
+	return nil, errors.New("no embedding")
+}
+
+func TestDuplicateCampaigns(t *testing.T) {
+	d := newDuplicateDetector(0.9, 24*time.Hour)
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	c := &Campaign{ID: "c4", AccountID: "BV1", Name: "March sale"}
+
+	if vector, dups, err := d.find(context.Background(), c, "Sale ends today", now); vector != nil || dups != nil || err != nil {
+		t.Fatalf("expected detection to be off without an embedder, got %v, %v, %v", vector, dups, err)
+	}
+
+	embedder := stubEmbedder{
+		"Sale ends today":       {1, 0},
+		"Sale ends today!":      {0.99, 0.1},
+		"Your OTP is 1234":      {0, 1},
+		"Sale ends today again": {1, 0.01},
+	}
+	d.embedder, d.store = embedder, lumadb.NewMemoryVectorStore()
+	record := func(c *Campaign, message string, at time.Time) {
+		vector, _ := embedder.Embed(context.Background(), message)
+		if err := d.record(context.Background(), c, vector, at); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	record(&Campaign{ID: "c1", AccountID: "BV1", Name: "Sale"}, "Sale ends today!", now.Add(-time.Hour))
+	record(&Campaign{ID: "c2", AccountID: "BV1", Name: "OTP"}, "Your OTP is 1234", now.Add(-time.Hour))
+	record(&Campaign{ID: "c3", AccountID: "BV1", Name: "Old sale"}, "Sale ends today again", now.Add(-48*time.Hour))
+	record(&Campaign{ID: "c5", AccountID: "BV2", Name: "Other account"}, "Sale ends today", now.Add(-time.Hour))
+	record(c, "Sale ends today", now.Add(-time.Minute))
+
+	vector, dups, err := d.find(context.Background(), c, "Sale ends today", now)
+	if err != nil || vector == nil {
+		t.Fatalf("find() = %v, %v; expected an embedding", vector, err)
+	}
+	if len(dups) != 1 || dups[0].CampaignID != "c1" || dups[0].Name != "Sale" {
+		t.Errorf("expected only c1 as a duplicate, got %+v", dups)
+	}
+
+	if _, _, err := d.find(context.Background(), c, "unknown", now); err == nil {
+		t.Error("expected the embedder error")
+	}
+}
+
+type stubScorer struct {
+	score int
+	calls int
+}
+
+func (s *stubScorer) ScoreMessage(ctx context.Context, message, sender string, volume int) (int, error) {
+	s.calls++
+	return s.score, nil
+}
+
+func TestContentFilter(t *testing.T) {
+	f := newContentFilter()
+	scorer := &stubScorer{score: 55}
+	f.scorer = scorer
+	f.set([]ContentRule{
+		{Pattern: "Send Your OTP", Category: "phishing", Score: 90},
+		{Pattern: "you have won", Category: "fraud", Score: 40},
+		{Pattern: `(?i)\bdouble\b`, IsRegex: true, Category: "fraud", Score: 20, re: regexp.MustCompile(`(?i)\bdouble\b`)},
+	}, []ContentPolicy{
+		{AccountID: "BV2", Strictness: StrictnessStrict},
+		{AccountID: "BV3", Strictness: StrictnessOff},
+		{Plan: "enterprise", Strictness: StrictnessLenient, AIScoring: true},
+	}, map[string]string{"BV4": "enterprise"}, map[string]bool{"BV1/BRIVAS": true})
+
+	tests := []struct {
+		name      string
+		accountID string
+		sender    string
+		body      string
+		verdict   string
+		score     int
+	}{
+		{"clean", "BV1", "ACME", "Your order has shipped", ContentAllowed, 0},
+		{"keyword blocks", "BV1", "ACME", "Please send your otp to 0803", ContentBlocked, 90},
+		{"scores add up", "BV1", "ACME", "You have won! DOUBLE it", ContentFlagged, 60},
+		{"bypassing sender", "BV1", "BRIVAS", "send your otp", ContentAllowed, 0},
+		{"strict account", "BV2", "ACME", "You have won! Double it", ContentBlocked, 60},
+		{"filter off", "BV3", "ACME", "send your otp", ContentAllowed, 0},
+		{"plan policy", "BV4", "ACME", "you have won", ContentAllowed, 55},
+	}
+
+	for _, tc := range tests {
+		got := f.check(context.Background(), zap.NewNop(), tc.accountID, tc.sender, tc.body, 1)
+		if got.Verdict != tc.verdict || got.Score != tc.score {
+			t.Errorf("%s: expected %s at %d, got %s at %d", tc.name, tc.verdict, tc.score, got.Verdict, got.Score)
+		}
+	}
+	if scorer.calls != 1 {
+		t.Errorf("expected the scorer to be called only for AI-scoring policies, got %d calls", scorer.calls)
+	}
+}
+
 func TestHandleBulkSendTooManyRecipients(t *testing.T) {
 	svc := &Service{
 		networkCodes: map[string]string{},
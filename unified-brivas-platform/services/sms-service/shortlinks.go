@@ -0,0 +1,146 @@
+package sms
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// DefaultShortLinkBaseURL is the short link domain used when none is
+// configured
+const DefaultShortLinkBaseURL = "https://brv.as"
+
+const (
+	shortCodeLength = 7
+	// shortCodeAlphabet leaves out look-alike characters
+	shortCodeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// shortLinkInsertBatch keeps multi-row inserts under the parameter limit
+	shortLinkInsertBatch = 1000
+)
+
+// linkPattern finds URLs in message bodies
+var linkPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// ShortLink maps a short code to the URL a recipient was sent
+type ShortLink struct {
+	Code       string `json:"code"`
+	Target     string `json:"target"`
+	AccountID  string `json:"account_id"`
+	CampaignID string `json:"campaign_id,omitempty"`
+	Recipient  string `json:"recipient,omitempty"`
+}
+
+func newShortCode() string {
+	b := make([]byte, shortCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = shortCodeAlphabet[int(b[i])%len(shortCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// shortURL is the public URL of a short link
+func (s *Service) shortURL(code string) string {
+	base := s.shortLinkBase
+	if base == "" {
+		base = DefaultShortLinkBaseURL
+	}
+	return strings.TrimRight(base, "/") + "/" + code
+}
+
+// shortenLinks replaces every URL in body with a new short link for the
+// recipient and returns the rewritten body with the links to store
+func (s *Service) shortenLinks(body, accountID, campaignID, recipient string) (string, []ShortLink) {
+	var links []ShortLink
+	shortened := linkPattern.ReplaceAllStringFunc(body, func(match string) string {
+		// Punctuation ending a sentence is not part of the URL
+		target := strings.TrimRight(match, ".,;:!?)'\"")
+		link := ShortLink{
+			Code:       newShortCode(),
+			Target:     target,
+			AccountID:  accountID,
+			CampaignID: campaignID,
+			Recipient:  recipient,
+		}
+		links = append(links, link)
+		return s.shortURL(link.Code) + match[len(target):]
+	})
+	return shortened, links
+}
+
+// saveShortLinks stores links with multi-row inserts
+func (s *Service) saveShortLinks(ctx context.Context, links []ShortLink) error {
+	for start := 0; start < len(links); start += shortLinkInsertBatch {
+		batch := links[start:min(start+shortLinkInsertBatch, len(links))]
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*5)
+		for i, l := range batch {
+			n := i * 5
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, NULLIF($%d, ''), NULLIF($%d, ''))", n+1, n+2, n+3, n+4, n+5)
+			args = append(args, l.Code, l.Target, l.AccountID, l.CampaignID, l.Recipient)
+		}
+		if _, err := s.db.Exec(ctx, `
+			INSERT INTO short_links (code, target, account_id, campaign_id, recipient)
+			VALUES `+strings.Join(values, ", "), args...); err != nil {
+			return fmt.Errorf("failed to save short links: %w", err)
+		}
+	}
+	return nil
+}
+
+// ShortLinkHandler serves short link redirects at /{code}, for mounting on
+// the short link domain. The SMS routes serve the same at /l/{code}.
+func (s *Service) ShortLinkHandler() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/{code}", s.handleShortLink)
+	return r
+}
+
+// handleShortLink counts a click and redirects to the link's target
+func (s *Service) handleShortLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code := chi.URLParam(r, "code")
+
+	var target string
+	err := s.db.QueryRow(ctx, `
+		UPDATE short_links SET clicks = clicks + 1, last_clicked_at = NOW()
+		WHERE code = $1
+		RETURNING target
+	`, code).Scan(&target)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to resolve short link", zap.String("code", code), zap.Error(err))
+		http.Error(w, "link unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := s.db.Exec(ctx, `
+		INSERT INTO link_clicks (code, ip, user_agent) VALUES ($1, $2, $3)
+	`, code, clientIP(r), r.UserAgent()); err != nil {
+		s.logger.Warn("failed to record click", zap.String("code", code), zap.Error(err))
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// clientIP is the first X-Forwarded-For address, or the peer address
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	if i := strings.LastIndex(r.RemoteAddr, ":"); i > 0 {
+		return r.RemoteAddr[:i]
+	}
+	return r.RemoteAddr
+}
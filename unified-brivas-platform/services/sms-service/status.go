@@ -0,0 +1,228 @@
+package sms
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DLR modes: whether delivery reports are pushed to the account's webhooks,
+// left for it to poll, or both
+const (
+	DLRModePush = "push"
+	DLRModePull = "pull"
+	DLRModeBoth = "both"
+)
+
+// statusPageSize is how many recipients GET /status/{sid} lists per page
+const statusPageSize = 500
+
+var dlrModes = map[string]bool{DLRModePush: true, DLRModePull: true, DLRModeBoth: true}
+
+// MessageStatus is the delivery state of one logged message
+type MessageStatus struct {
+	RID         string     `json:"rid"`
+	SID         string     `json:"sid"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Status      string     `json:"status"`
+	Network     string     `json:"network,omitempty"`
+	SentAt      time.Time  `json:"sent_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	LatencyMS   *int64     `json:"latency_ms,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+}
+
+// BatchStatus summarises the messages logged under a SID
+type BatchStatus struct {
+	SID        string          `json:"sid"`
+	Status     string          `json:"status"`
+	Total      int             `json:"total"`
+	Pending    int             `json:"pending"`
+	Delivered  int             `json:"delivered"`
+	Failed     int             `json:"failed"`
+	Recipients []MessageStatus `json:"recipients"`
+}
+
+// batchState is pending while any message awaits its DLR, then delivered
+// if any message was, else failed
+func batchState(pending, delivered int) string {
+	switch {
+	case pending > 0:
+		return "pending"
+	case delivered > 0:
+		return "delivered"
+	}
+	return "failed"
+}
+
+const messageStatusColumns = `COALESCE(rid, ''), sid, COALESCE(sender, ''), recipient, COALESCE(status, 'pending'),
+	COALESCE(network, ''), created_at, delivered_at, delivery_latency_ms, COALESCE(dlr_attempts, 0)`
+
+func scanMessageStatus(row rowScanner) (MessageStatus, error) {
+	var m MessageStatus
+	var deliveredAt sql.NullTime
+	var latency sql.NullInt64
+	err := row.Scan(&m.RID, &m.SID, &m.From, &m.To, &m.Status, &m.Network, &m.SentAt, &deliveredAt,
+		&latency, &m.Attempts)
+	if deliveredAt.Valid {
+		m.DeliveredAt = &deliveredAt.Time
+	}
+	if latency.Valid {
+		m.LatencyMS = &latency.Int64
+	}
+	return m, err
+}
+
+// handleMessageStatus returns the delivery state of one of the account's
+// messages by its provider message ID
+func (s *Service) handleMessageStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID := r.Header.Get("X-Account-ID")
+
+	m, err := scanMessageStatus(s.db.QueryRow(ctx, `
+		SELECT `+messageStatusColumns+` FROM sms_history
+		WHERE rid = $1 AND account_id = $2
+	`, chi.URLParam(r, "rid"), accountID))
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch message status", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   m,
+	}, http.StatusOK)
+}
+
+// handleBatchStatus returns the delivery state of the account's messages
+// under a SID, with a page of per-recipient states for bulk SIDs
+func (s *Service) handleBatchStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID, sid := r.Header.Get("X-Account-ID"), chi.URLParam(r, "sid")
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		fmt.Sscanf(p, "%d", &page)
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	b := BatchStatus{SID: sid, Recipients: make([]MessageStatus, 0)}
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'delivered'),
+			COUNT(*) FILTER (WHERE status = 'failed')
+		FROM sms_history
+		WHERE sid = $1 AND account_id = $2
+	`, sid, accountID).Scan(&b.Total, &b.Delivered, &b.Failed)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch status", http.StatusInternalServerError)
+		return
+	}
+	if b.Total == 0 {
+		s.jsonError(w, r, "sid not found", http.StatusNotFound)
+		return
+	}
+	b.Pending = b.Total - b.Delivered - b.Failed
+	b.Status = batchState(b.Pending, b.Delivered)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+messageStatusColumns+` FROM sms_history
+		WHERE sid = $1 AND account_id = $2
+		ORDER BY id
+		LIMIT $3 OFFSET $4
+	`, sid, accountID, statusPageSize, (page-1)*statusPageSize)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch status", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMessageStatus(rows)
+		if err != nil {
+			s.jsonError(w, r, "failed to fetch status", http.StatusInternalServerError)
+			return
+		}
+		b.Recipients = append(b.Recipients, m)
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, r, "failed to fetch status", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   b,
+	}, http.StatusOK)
+}
+
+// handleGetDLRMode returns how the account receives delivery reports
+func (s *Service) handleGetDLRMode(w http.ResponseWriter, r *http.Request) {
+	var mode string
+	err := s.db.QueryRow(r.Context(), `
+		SELECT COALESCE(dlr_mode, 'push') FROM accounts WHERE id = $1
+	`, r.Header.Get("X-Account-ID")).Scan(&mode)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch DLR mode", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   map[string]string{"dlr_mode": mode},
+	}, http.StatusOK)
+}
+
+// handleSetDLRMode sets whether the account's delivery reports are pushed
+// to its webhooks (push, both) or only polled (pull)
+func (s *Service) handleSetDLRMode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mode string `json:"dlr_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, r, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !dlrModes[req.Mode] {
+		s.jsonError(w, r, "dlr_mode must be push, pull or both", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec(r.Context(), `
+		UPDATE accounts SET dlr_mode = $1 WHERE id = $2
+	`, req.Mode, r.Header.Get("X-Account-ID"))
+	n, err := rowsAffected(res, err)
+	if err != nil {
+		s.jsonError(w, r, "failed to update DLR mode", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		s.jsonError(w, r, "account not found", http.StatusNotFound)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "DLR mode updated",
+		"data":   map[string]string{"dlr_mode": req.Mode},
+	}, http.StatusOK)
+}
@@ -0,0 +1,173 @@
+package sms
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Time series buckets and the longest range each may cover
+const (
+	BucketDay  = "day"
+	BucketHour = "hour"
+
+	maxDayBuckets  = 366
+	maxHourBuckets = 24 * 31
+)
+
+// TimeSeriesPoint is the bulk traffic of one bucket
+type TimeSeriesPoint struct {
+	Time         time.Time `json:"time"`
+	Sent         int       `json:"sent"`
+	Delivered    int       `json:"delivered"`
+	Failed       int       `json:"failed"`
+	DeliveryRate float64   `json:"delivery_rate"`
+}
+
+// parseTimeBound accepts RFC 3339 times and YYYY-MM-DD dates (UTC midnight)
+func parseTimeBound(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// truncateBucket returns the start of the UTC bucket containing t
+func truncateBucket(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	if bucket == BucketHour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	if bucket == BucketHour {
+		return t.Add(time.Hour)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// insightsRange parses ?from=&to=. Without from the range starts span
+// before to, which defaults to now.
+func insightsRange(r *http.Request, span time.Duration) (from, to time.Time, err error) {
+	q := r.URL.Query()
+	to = time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		if to, err = parseTimeBound(v); err != nil {
+			return from, to, fmt.Errorf("invalid to: %s", v)
+		}
+	}
+	from = to.Add(-span)
+	if v := q.Get("from"); v != "" {
+		if from, err = parseTimeBound(v); err != nil {
+			return from, to, fmt.Errorf("invalid from: %s", v)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// timeSeriesRange parses ?from=&to=&bucket=. The range defaults to the last
+// 7 days by day or the last 24 hours by hour.
+func timeSeriesRange(r *http.Request) (from, to time.Time, bucket string, err error) {
+	bucket = r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = BucketDay
+	}
+	if bucket != BucketDay && bucket != BucketHour {
+		return from, to, "", fmt.Errorf("bucket must be %s or %s", BucketDay, BucketHour)
+	}
+
+	defaultSpan := 7 * 24 * time.Hour
+	if bucket == BucketHour {
+		defaultSpan = 24 * time.Hour
+	}
+	if from, to, err = insightsRange(r, defaultSpan); err != nil {
+		return from, to, "", err
+	}
+
+	limit, span := maxDayBuckets, to.Sub(truncateBucket(from, bucket))/(24*time.Hour)
+	if bucket == BucketHour {
+		limit, span = maxHourBuckets, to.Sub(truncateBucket(from, bucket))/time.Hour
+	}
+	if int(span) >= limit {
+		return from, to, "", fmt.Errorf("range too long: at most %d %s buckets", limit, bucket)
+	}
+	return from, to, bucket, nil
+}
+
+// fillSeries returns one point per bucket from from up to to, taking counts
+// from points (keyed by bucket start) and zero elsewhere
+func fillSeries(from, to time.Time, bucket string, points map[int64]TimeSeriesPoint) []TimeSeriesPoint {
+	series := make([]TimeSeriesPoint, 0)
+	for t := truncateBucket(from, bucket); t.Before(to); t = nextBucket(t, bucket) {
+		p := points[t.Unix()]
+		p.Time = t
+		if p.Sent > 0 {
+			p.DeliveryRate = float64(p.Delivered) / float64(p.Sent) * 100
+		}
+		series = append(series, p)
+	}
+	return series
+}
+
+// handleInsightsTimeSeries returns the account's bulk sent, delivered and
+// failed counts per day or hour, with empty buckets zero-filled. Like
+// handleInsights it is scoped to the X-Account-ID the auth middleware sets
+// from the caller's claims.
+func (s *Service) handleInsightsTimeSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, bucket, err := timeSeriesRange(r)
+	if err != nil {
+		s.jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	accountID := r.Header.Get("X-Account-ID")
+
+	// Served by idx_sms_history_account_type_created
+	rows, err := s.db.Query(ctx, `
+		SELECT date_trunc($2, created_at) AS bucket,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'delivered'),
+			COUNT(*) FILTER (WHERE status = 'failed')
+		FROM sms_history
+		WHERE account_id = $1 AND type = 'bulk-sms' AND created_at >= $3 AND created_at < $4
+		GROUP BY 1
+		ORDER BY 1
+	`, accountID, bucket, from, to)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch insights", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	points := make(map[int64]TimeSeriesPoint)
+	for rows.Next() {
+		var t time.Time
+		var p TimeSeriesPoint
+		if err := rows.Scan(&t, &p.Sent, &p.Delivered, &p.Failed); err != nil {
+			s.jsonError(w, r, "failed to fetch insights", http.StatusInternalServerError)
+			return
+		}
+		points[t.UTC().Unix()] = p
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, r, "failed to fetch insights", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data": map[string]interface{}{
+			"from":   from,
+			"to":     to,
+			"bucket": bucket,
+			"series": fillSeries(from, to, bucket, points),
+		},
+	}, http.StatusOK)
+}
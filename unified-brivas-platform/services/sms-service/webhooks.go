@@ -0,0 +1,600 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	lumadb "github.com/brivas/unified-platform/packages/lumadb-client"
+	"github.com/brivas/unified-platform/packages/netguard"
+)
+
+// Webhook delivery defaults
+const (
+	// DefaultWebhookMaxAttempts is how many times a delivery is tried before
+	// it is dead-lettered
+	DefaultWebhookMaxAttempts = 6
+	// DefaultWebhookConcurrency caps the deliveries in flight per account, so
+	// one slow endpoint cannot hold every worker
+	DefaultWebhookConcurrency = 4
+
+	webhookTimeout      = 10 * time.Second
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = time.Hour
+	webhookPollInterval = 2 * time.Second
+	webhookBatchSize    = 100
+
+	// webhookSecretGrace is how long receivers should keep accepting the
+	// secret a rotation replaced, covering deliveries already in flight
+	webhookSecretGrace  = 24 * time.Hour
+	webhookSecretPrefix = "whsec_"
+
+	// maxWebhookURLLength bounds a per-message webhook
+	maxWebhookURLLength = 2048
+)
+
+// EventSMSStatus is sent when a message's delivery report arrives
+const EventSMSStatus = "sms.status"
+
+// WebhookEvent is posted to an account's app webhooks when something about
+// the account changes
+type WebhookEvent struct {
+	Event     string      `json:"event"`
+	AccountID string      `json:"account_id"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// FailedWebhook is a delivery that ran out of attempts
+type FailedWebhook struct {
+	ID            int64           `json:"id"`
+	DeliveryID    int64           `json:"delivery_id"`
+	URL           string          `json:"url"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error"`
+	FailedAt      time.Time       `json:"failed_at"`
+	RedeliveredAt *time.Time      `json:"redelivered_at,omitempty"`
+}
+
+// errDeadLetterNotFound reports a dead letter that does not exist, belongs
+// to another account, or was already redelivered
+var errDeadLetterNotFound = errors.New("failed webhook not found")
+
+// errInvalidWebhook rejects a per-message webhook URL
+var errInvalidWebhook = errors.New("invalid webhook")
+
+// checkMessageWebhook checks a webhook a caller gave with a message: it must
+// be an https URL resolving only to addresses the dispatcher delivers to
+func (s *Service) checkMessageWebhook(ctx context.Context, raw string) error {
+	if len(raw) > maxWebhookURLLength {
+		return fmt.Errorf("%w: URL is longer than %d characters", errInvalidWebhook, maxWebhookURLLength)
+	}
+	policy := netguard.Policy{RequireHTTPS: true}
+	if s.webhooks != nil {
+		policy.Allow = s.webhooks.policy.Allow
+	}
+	if err := policy.CheckURL(ctx, raw); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidWebhook, err)
+	}
+	return nil
+}
+
+// WebhookDispatcher delivers webhooks queued in webhook_deliveries, retrying
+// failures with exponential backoff and dead-lettering deliveries that fail
+// every attempt. The per-account cap applies to each service instance.
+// Deliveries are only made to public addresses, or those the policy
+// allows; others are dead-lettered without retrying.
+type WebhookDispatcher struct {
+	db          lumadb.Querier
+	logger      *zap.Logger
+	policy      netguard.Policy
+	client      *http.Client
+	maxAttempts int
+	perAccount  int
+	wake        chan struct{}
+	// heartbeat, when set, is beaten each time Run polls
+	heartbeat *heartbeat
+	// deliveries tracks the attempts in flight, which Run waits for
+	deliveries sync.WaitGroup
+
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+// NewWebhookDispatcher creates a dispatcher; zero limits use the defaults.
+// allow lists trusted internal endpoints webhooks may be delivered to, see
+// netguard.Policy.
+func NewWebhookDispatcher(db lumadb.Querier, logger *zap.Logger, maxAttempts, perAccount int, allow []string) *WebhookDispatcher {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+	if perAccount <= 0 {
+		perAccount = DefaultWebhookConcurrency
+	}
+	policy := netguard.Policy{Allow: allow}
+	return &WebhookDispatcher{
+		db:          db,
+		logger:      logger,
+		policy:      policy,
+		client:      policy.Client(webhookTimeout),
+		maxAttempts: maxAttempts,
+		perAccount:  perAccount,
+		wake:        make(chan struct{}, 1),
+		inflight:    make(map[string]int),
+	}
+}
+
+// webhookBackoff is the wait before retrying after the given failed attempt:
+// 30s doubling up to an hour, plus up to 10% jitter
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookMaxBackoff
+	if attempt < 20 {
+		d = min(webhookBaseBackoff<<(attempt-1), webhookMaxBackoff)
+	}
+	return d + time.Duration(mathrand.Int63n(int64(d/10)+1))
+}
+
+// generateWebhookSecret returns a new random signing secret
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(b), nil
+}
+
+// signWebhook is the hex HMAC-SHA256 of "<unix timestamp>.<payload>" under
+// secret. Receivers recompute it from the X-Brivas-Timestamp header and the
+// raw body; while a rotation's grace window is open they should accept a
+// match against either the new or the previous secret.
+func signWebhook(secret string, timestamp time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue stores a delivery and wakes the dispatcher
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, accountID, url, event string, payload []byte) error {
+	_, err := d.db.Exec(ctx, `
+		INSERT INTO webhook_deliveries (account_id, url, event, payload) VALUES ($1, $2, $3, $4)
+	`, accountID, url, event, payload)
+	if err != nil {
+		return fmt.Errorf("failed to queue webhook: %w", err)
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers due webhooks until ctx is cancelled, then waits for the
+// attempts in flight to be recorded, so whoever waits for Run waits for
+// them too. Deliveries left in flight by a previous process are retried.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	defer d.deliveries.Wait()
+
+	if _, err := d.db.Exec(ctx, `
+		UPDATE webhook_deliveries SET status = 'pending' WHERE status = 'delivering'
+	`); err != nil {
+		d.logger.Warn("failed to requeue interrupted webhooks", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		d.dispatchDue(ctx)
+		d.heartbeat.beat()
+	}
+}
+
+type webhookDelivery struct {
+	id        int64
+	accountID string
+	url       string
+	event     string
+	payload   []byte
+	attempts  int
+	secret    string // the account's current signing secret, if any
+}
+
+// dispatchDue starts the due deliveries whose accounts are under the cap.
+// Each account contributes at most its cap to a batch and accounts at the
+// cap none, so a backlog on one account cannot fill the batch and starve
+// the others.
+func (d *WebhookDispatcher) dispatchDue(ctx context.Context) {
+	rows, err := d.db.Query(ctx, `
+		SELECT id, account_id, url, event, payload, attempts, secret FROM (
+			SELECT wd.id, wd.account_id, wd.url, wd.event, wd.payload, wd.attempts,
+				COALESCE(a.webhook_secret, '') AS secret, wd.next_attempt_at,
+				ROW_NUMBER() OVER (PARTITION BY wd.account_id ORDER BY wd.next_attempt_at, wd.id) AS account_rank
+			FROM webhook_deliveries wd
+			LEFT JOIN accounts a ON a.id = wd.account_id
+			WHERE wd.status = 'pending' AND wd.next_attempt_at <= NOW() AND wd.account_id <> ALL($2)
+		) due
+		WHERE account_rank <= $3
+		ORDER BY next_attempt_at
+		LIMIT $1
+	`, webhookBatchSize, pq.Array(d.cappedAccounts()), d.perAccount)
+	if err != nil {
+		d.logger.Warn("failed to load due webhooks", zap.Error(err))
+		return
+	}
+	var due []*webhookDelivery
+	for rows.Next() {
+		var w webhookDelivery
+		if err := rows.Scan(&w.id, &w.accountID, &w.url, &w.event, &w.payload, &w.attempts, &w.secret); err == nil {
+			due = append(due, &w)
+		}
+	}
+	rows.Close()
+
+	for _, w := range due {
+		if !d.acquire(w.accountID) {
+			continue
+		}
+		// Claim the row so another instance does not deliver it too
+		res, err := d.db.Exec(ctx, `
+			UPDATE webhook_deliveries SET status = 'delivering', updated_at = NOW()
+			WHERE id = $1 AND status = 'pending'
+		`, w.id)
+		if n, _ := rowsAffected(res, err); n == 0 {
+			d.release(w.accountID)
+			continue
+		}
+		d.deliveries.Add(1)
+		go func(w *webhookDelivery) {
+			defer d.deliveries.Done()
+			defer d.release(w.accountID)
+			// An attempt under way at shutdown is finished and recorded
+			// rather than left delivering; the client timeout bounds it
+			d.deliver(context.WithoutCancel(ctx), w)
+		}(w)
+	}
+}
+
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// acquire takes one of the account's delivery slots
+func (d *WebhookDispatcher) acquire(accountID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inflight[accountID] >= d.perAccount {
+		return false
+	}
+	d.inflight[accountID]++
+	return true
+}
+
+// cappedAccounts lists the accounts using all their delivery slots
+func (d *WebhookDispatcher) cappedAccounts() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Never nil: <> ALL(NULL) would exclude every account
+	capped := make([]string, 0)
+	for accountID, n := range d.inflight {
+		if n >= d.perAccount {
+			capped = append(capped, accountID)
+		}
+	}
+	return capped
+}
+
+func (d *WebhookDispatcher) release(accountID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inflight[accountID]--; d.inflight[accountID] <= 0 {
+		delete(d.inflight, accountID)
+	}
+}
+
+// post sends one attempt, signed when the account has a secret. Any status
+// outside 2xx is an error; the status is zero when no response arrived.
+func (d *WebhookDispatcher) post(ctx context.Context, w *webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(w.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Brivas-Event", w.event)
+	req.Header.Set("X-Brivas-Delivery", strconv.FormatInt(w.id, 10))
+	if w.secret != "" {
+		now := time.Now()
+		req.Header.Set("X-Brivas-Timestamp", strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set("X-Brivas-Signature", "sha256="+signWebhook(w.secret, now, w.payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver makes one attempt and records its outcome: delivered, retried
+// after a backoff, or dead-lettered once attempts run out
+func (d *WebhookDispatcher) deliver(ctx context.Context, w *webhookDelivery) {
+	start := time.Now()
+	code, err := d.post(ctx, w)
+	attempt := w.attempts + 1
+
+	var statusCode sql.NullInt64
+	if code != 0 {
+		statusCode = sql.NullInt64{Int64: int64(code), Valid: true}
+	}
+	var errMsg sql.NullString
+	if err != nil {
+		errMsg = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if _, dbErr := d.db.Exec(ctx, `
+		INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)
+	`, w.id, attempt, statusCode, errMsg, time.Since(start).Milliseconds()); dbErr != nil {
+		d.logger.Warn("failed to record webhook attempt", zap.Int64("delivery_id", w.id), zap.Error(dbErr))
+	}
+
+	switch {
+	case err == nil:
+		_, err = d.db.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'delivered', attempts = $2, last_error = NULL, updated_at = NOW()
+			WHERE id = $1
+		`, w.id, attempt)
+	case attempt >= d.maxAttempts || errors.Is(err, netguard.ErrBlocked):
+		d.logger.Warn("webhook dead-lettered", zap.Int64("delivery_id", w.id), zap.String("url", w.url), zap.Error(err))
+		lastError := err.Error()
+		err = lumadb.InTransaction(ctx, d.db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE webhook_deliveries
+				SET status = 'dead', attempts = $2, last_error = $3, updated_at = NOW()
+				WHERE id = $1
+			`, w.id, attempt, lastError); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO webhook_deadletter (delivery_id, account_id, url, event, payload, attempts, last_error)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`, w.id, w.accountID, w.url, w.event, w.payload, attempt, lastError)
+			return err
+		})
+	default:
+		_, err = d.db.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'pending', attempts = $2, last_error = $3,
+				next_attempt_at = NOW() + $4 * INTERVAL '1 millisecond', updated_at = NOW()
+			WHERE id = $1
+		`, w.id, attempt, err.Error(), webhookBackoff(attempt).Milliseconds())
+	}
+	if err != nil {
+		d.logger.Error("failed to record webhook outcome", zap.Int64("delivery_id", w.id), zap.Error(err))
+	}
+}
+
+// Redeliver queues a dead-lettered delivery again with a fresh set of
+// attempts
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, accountID string, id int64) error {
+	err := lumadb.InTransaction(ctx, d.db, func(tx *sql.Tx) error {
+		var deliveryID int64
+		err := tx.QueryRowContext(ctx, `
+			UPDATE webhook_deadletter SET redelivered_at = NOW()
+			WHERE id = $1 AND account_id = $2 AND redelivered_at IS NULL
+			RETURNING delivery_id
+		`, id, accountID).Scan(&deliveryID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errDeadLetterNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'pending', attempts = 0, last_error = NULL, next_attempt_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+		`, deliveryID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// enqueueWebhook queues payload for delivery to url; failures are logged.
+// URLs the dispatcher would refuse are dropped, while ones that do not
+// resolve now are queued to be retried.
+func (s *Service) enqueueWebhook(ctx context.Context, accountID, url, event string, payload interface{}) {
+	if s.webhooks == nil {
+		return
+	}
+	if err := s.webhooks.policy.CheckURL(ctx, url); errors.Is(err, netguard.ErrInvalidURL) || errors.Is(err, netguard.ErrBlocked) {
+		s.logger.Warn("refusing webhook", zap.String("event", event), zap.String("account_id", accountID), zap.Error(err))
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.Error("failed to encode webhook", zap.String("event", event), zap.Error(err))
+		return
+	}
+	if err := s.webhooks.Enqueue(ctx, accountID, url, event, body); err != nil {
+		s.logger.Warn("failed to queue webhook", zap.String("event", event), zap.Error(err))
+	}
+}
+
+// notifyAccount queues event for the webhooks of the account's enabled apps
+func (s *Service) notifyAccount(ctx context.Context, accountID, event string, data interface{}) {
+	rows, err := s.db.Query(ctx, `
+		SELECT webhook FROM user_apps
+		WHERE account_id = $1 AND is_enabled = TRUE AND webhook IS NOT NULL AND webhook <> ''
+	`, accountID)
+	if err != nil {
+		s.logger.Warn("failed to load webhooks", zap.String("account_id", accountID), zap.Error(err))
+		return
+	}
+	var webhooks []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err == nil {
+			webhooks = append(webhooks, url)
+		}
+	}
+	rows.Close()
+
+	payload := WebhookEvent{Event: event, AccountID: accountID, Data: data, CreatedAt: time.Now().UTC()}
+	for _, url := range webhooks {
+		s.enqueueWebhook(ctx, accountID, url, event, payload)
+	}
+}
+
+// handleFailedWebhooks lists the account's dead-lettered deliveries
+func (s *Service) handleFailedWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accountID := r.Header.Get("X-Account-ID")
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		fmt.Sscanf(p, "%d", &page)
+	}
+	limit := 50
+	offset := (max(page, 1) - 1) * limit
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, delivery_id, url, event, payload, attempts, COALESCE(last_error, ''), failed_at, redelivered_at
+		FROM webhook_deadletter
+		WHERE account_id = $1
+		ORDER BY failed_at DESC
+		LIMIT $2 OFFSET $3
+	`, accountID, limit, offset)
+	if err != nil {
+		s.jsonError(w, r, "failed to fetch failed webhooks", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	failed := make([]FailedWebhook, 0)
+	for rows.Next() {
+		var f FailedWebhook
+		var payload []byte
+		var redeliveredAt sql.NullTime
+		if err := rows.Scan(&f.ID, &f.DeliveryID, &f.URL, &f.Event, &payload, &f.Attempts,
+			&f.LastError, &f.FailedAt, &redeliveredAt); err != nil {
+			continue
+		}
+		f.Payload = payload
+		if redeliveredAt.Valid {
+			f.RedeliveredAt = &redeliveredAt.Time
+		}
+		failed = append(failed, f)
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "",
+		"data":   failed,
+	}, http.StatusOK)
+}
+
+// handleRedeliverWebhook queues a dead-lettered delivery again
+func (s *Service) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.jsonError(w, r, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.webhooks.Redeliver(r.Context(), r.Header.Get("X-Account-ID"), id)
+	if errors.Is(err, errDeadLetterNotFound) {
+		s.jsonError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to redeliver webhook", zap.Int64("id", id), zap.Error(err))
+		s.jsonError(w, r, "failed to redeliver webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "webhook queued for redelivery",
+	}, http.StatusAccepted)
+}
+
+// handleRotateWebhookSecret replaces the account's webhook signing secret.
+// The new secret is only ever returned here; the one it replaces stays
+// valid for webhookSecretGrace.
+func (s *Service) handleRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		s.jsonError(w, r, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	var previousExpiresAt sql.NullTime
+	err = s.db.QueryRow(r.Context(), `
+		UPDATE accounts SET
+			webhook_secret_previous = webhook_secret,
+			webhook_secret_previous_expires_at = CASE WHEN webhook_secret IS NULL THEN NULL
+				ELSE NOW() + $3 * INTERVAL '1 second' END,
+			webhook_secret = $1,
+			webhook_secret_created_at = NOW()
+		WHERE id = $2
+		RETURNING webhook_secret_previous_expires_at
+	`, secret, r.Header.Get("X-Account-ID"), int64(webhookSecretGrace.Seconds())).Scan(&previousExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, r, "account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to rotate webhook secret", zap.Error(err))
+		s.jsonError(w, r, "failed to rotate webhook secret", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{"secret": secret}
+	if previousExpiresAt.Valid {
+		data["previous_valid_until"] = previousExpiresAt.Time
+	}
+	s.jsonResponse(w, map[string]interface{}{
+		"status": "success",
+		"msg":    "webhook secret rotated; store it now, it is not shown again",
+		"data":   data,
+	}, http.StatusOK)
+}